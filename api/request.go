@@ -20,7 +20,9 @@ type VolumeCreateRequest struct {
 	Type           string
 	IOPS           int64
 	PrepareForVM   bool
+	FromSnapshot   string
 	Verbose        bool
+	Progress       bool
 }
 
 type VolumeDeleteRequest struct {
@@ -33,9 +35,21 @@ type VolumeInspectRequest struct {
 }
 
 type SnapshotCreateRequest struct {
-	Name       string
+	Name        string
+	VolumeName  string
+	Freeze      bool
+	Verbose     bool
+	Labels      []string
+	Description string
+	Compression string
+	TTL         string
+	// Pause the containers using the volume around the snapshot
+	PauseContainers bool
+}
+
+type SnapshotListRequest struct {
 	VolumeName string
-	Verbose    bool
+	Labels     []string
 }
 
 type SnapshotDeleteRequest struct {
@@ -46,11 +60,62 @@ type SnapshotInspectRequest struct {
 	SnapshotName string
 }
 
+type SnapshotMountRequest struct {
+	SnapshotName string
+	MountPoint   string
+}
+
+type SnapshotUmountRequest struct {
+	SnapshotName string
+}
+
+type SnapshotRevertRequest struct {
+	SnapshotName string
+}
+
+type SnapshotExportRequest struct {
+	SnapshotName string
+	FilePath     string
+}
+
+type SnapshotChecksumRequest struct {
+	SnapshotName string
+}
+
+type SnapshotSpaceRequest struct {
+	VolumeName string
+}
+
+type SnapshotChainRequest struct {
+	VolumeName string
+}
+
+type SnapshotDiffRequest struct {
+	SnapshotName        string
+	CompareSnapshotName string
+}
+
+type SnapshotQuiesceRequest struct {
+	VolumeName string
+	Quiesce    string
+	Unquiesce  string
+	Clear      bool
+}
+
+type SnapshotRetentionRequest struct {
+	VolumeName string
+	MaxCount   int
+	MaxAge     string
+	Clear      bool
+}
+
 type BackupListRequest struct {
 	URL          string
 	Endpoint     string
 	VolumeName   string
 	SnapshotName string
+	All          bool
+	Labels       []string
 }
 
 type BackupCreateRequest struct {
@@ -58,9 +123,132 @@ type BackupCreateRequest struct {
 	Endpoint     string
 	SnapshotName string
 	Verbose      bool
+	Progress     bool
+	ExportImage  string
+	Labels       []string
+	DryRun       bool
+	// Stream the snapshot as a raw image in the response
+	Stream bool
+}
+
+type BackupRestoreRequest struct {
+	URL        string
+	Endpoint   string
+	VolumeName string
+	Progress   bool
+	// Restore the raw image following the request in the body
+	Stream bool
+}
+
+type BackupVerifyRequest struct {
+	URL      string
+	Endpoint string
+	Sample   int
+}
+
+type BackupExportRequest struct {
+	URL      string
+	Endpoint string
+	FilePath string
+}
+
+type BackupExtractRequest struct {
+	URL        string
+	Endpoint   string
+	Path       string
+	TargetPath string
+}
+
+type BackupImportRequest struct {
+	URL      string
+	Endpoint string
+	FilePath string
+}
+
+type BackupReplicateRequest struct {
+	URL          string
+	Endpoint     string
+	DestURL      string
+	DestEndpoint string
+	VolumeName   string
+}
+
+type BackupGCRequest struct {
+	URL        string
+	Endpoint   string
+	VolumeName string
+	DryRun     bool
+}
+
+type ObjectStoreUpgradeRequest struct {
+	URL      string
+	Endpoint string
+	DryRun   bool
+}
+
+type ObjectStoreBreakLockRequest struct {
+	URL        string
+	Endpoint   string
+	VolumeName string
+}
+
+type ObjectStoreRotateKeyRequest struct {
+	URL      string
+	Endpoint string
+	KeyFile  string
+}
+
+type BackupRetentionRequest struct {
+	VolumeName  string
+	KeepLast    int
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+	Clear       bool
+}
+
+type BackupHooksRequest struct {
+	VolumeName  string
+	PreSnapshot string
+	PostBackup  string
+	Clear       bool
 }
 
 type BackupDeleteRequest struct {
 	URL      string
 	Endpoint string
+	Force    bool
+}
+
+type ScheduleCreateRequest struct {
+	Name            string
+	VolumeName      string
+	Cron            string
+	URL             string
+	Endpoint        string
+	Freeze          bool
+	PauseContainers bool
+	SnapshotOnly    bool
+}
+
+type ScheduleDeleteRequest struct {
+	Name string
+}
+
+type GroupCreateRequest struct {
+	Name        string
+	VolumeNames []string
+}
+
+type GroupDeleteRequest struct {
+	Name string
+}
+
+type GroupSnapshotRequest struct {
+	GroupName       string
+	Name            string
+	Labels          []string
+	Description     string
+	NoFreeze        bool
+	PauseContainers bool
 }
@@ -28,13 +28,95 @@ type SnapshotResponse struct {
 	VolumeName      string `json:",omitempty"`
 	VolumeCreatedAt string `json:",omitempty"`
 	CreatedTime     string
+	Labels          map[string]string `json:",omitempty"`
+	Description     string            `json:",omitempty"`
+	ExpiresAt       string            `json:",omitempty"`
 	DriverInfo      map[string]string
 }
 
+// SnapshotExtent is a range of the volume changed between the snapshots
+type SnapshotExtent struct {
+	Offset int64
+	Size   int64
+}
+
+type SnapshotDiffResponse struct {
+	SnapshotName        string
+	CompareSnapshotName string
+	VolumeName          string
+	Extents             []SnapshotExtent
+	ChangedBytes        int64
+}
+
+// SnapshotChainEntry is a snapshot in the chain of the snapshots of a volume,
+// see SnapshotChainResponse
+type SnapshotChainEntry struct {
+	Name        string
+	CreatedTime string
+	// The snapshot the volume was derived from when the snapshot was taken
+	Parent string `json:",omitempty"`
+	// If the latest backup of the volume was created from the snapshot
+	LastBackup bool `json:",omitempty"`
+}
+
+// SnapshotChainResponse is the chain of the snapshots of a volume, Ancestry
+// is the snapshots the current content of the volume is derived from, from
+// Head to the oldest one
+type SnapshotChainResponse struct {
+	VolumeName         string
+	Head               string
+	Ancestry           []string
+	LastBackupSnapshot string
+	LastBackupURL      string
+	Snapshots          []SnapshotChainEntry
+}
+
+// SnapshotSpaceEntry is the space held only by a snapshot, which is not shared
+// with the neighbor snapshots or the volume
+type SnapshotSpaceEntry struct {
+	Name        string
+	CreatedTime string
+	UniqueSize  int64
+}
+
+type SnapshotSpaceResponse struct {
+	VolumeName      string
+	Snapshots       []SnapshotSpaceEntry
+	TotalUniqueSize int64
+}
+
+type SnapshotChecksumResponse struct {
+	SnapshotName     string
+	VolumeName       string
+	Algorithm        string
+	Checksum         string
+	RecordedChecksum string
+	RecordedTime     string
+	Valid            bool
+}
+
 type BackupURLResponse struct {
 	URL string
 }
 
+type Progress struct {
+	Operation        string
+	TotalBlocks      int
+	ProcessedBlocks  int
+	TransferredBytes int64
+	ElapsedSeconds   int64
+	// ETASeconds is -1 if it's unknown yet
+	ETASeconds int64
+}
+
+// ProgressResponse is one line of the streamed response of a long running
+// operation. Only the last line has Result or Error.
+type ProgressResponse struct {
+	Progress *Progress `json:",omitempty"`
+	Result   string    `json:",omitempty"`
+	Error    string    `json:",omitempty"`
+}
+
 // ResponseError would generate a error information in JSON format for output
 func ResponseError(format string, a ...interface{}) {
 	response := ErrorResponse{Error: fmt.Sprintf(format, a...)}
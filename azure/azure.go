@@ -0,0 +1,211 @@
+package azure
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/objectstore"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "azure"})
+)
+
+type AzureObjectStoreDriver struct {
+	destURL string
+	path    string
+	service AzureService
+
+	// Block files would be moved to this access tier if specified
+	blockAccessTier string
+}
+
+const (
+	KIND = "azure"
+
+	ENV_STORAGE_KEY       = "AZURE_STORAGE_KEY"
+	ENV_STORAGE_SAS_TOKEN = "AZURE_STORAGE_SAS_TOKEN"
+	ENV_BLOCK_ACCESS_TIER = "AZURE_BLOCK_ACCESS_TIER"
+)
+
+func init() {
+	if err := objectstore.RegisterDriver(KIND, initFunc); err != nil {
+		panic(err)
+	}
+}
+
+func initFunc(destURL, endpoint string) (objectstore.ObjectStoreDriver, error) {
+	return initFuncWithConnectionCheck(destURL, endpoint, func(d objectstore.ObjectStoreDriver) error {
+		_, err := d.List("")
+		return err
+	})
+}
+
+func initFuncWithConnectionCheck(destURL, endpoint string, connectionTest func(d objectstore.ObjectStoreDriver) error) (objectstore.ObjectStoreDriver, error) {
+	b := &AzureObjectStoreDriver{}
+
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != KIND {
+		return nil, fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, KIND)
+	}
+
+	if u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return nil, fmt.Errorf("Invalid URL. Must be azure://container@account/path/")
+	}
+	b.service.Container = u.User.Username()
+	b.service.Account = u.Host
+
+	b.service.AccountKey = os.Getenv(ENV_STORAGE_KEY)
+	b.service.SASToken = os.Getenv(ENV_STORAGE_SAS_TOKEN)
+	if b.service.AccountKey == "" && b.service.SASToken == "" {
+		return nil, fmt.Errorf("Cannot find Azure credentials, %v or %v need to be set", ENV_STORAGE_KEY, ENV_STORAGE_SAS_TOKEN)
+	}
+
+	b.path = strings.TrimLeft(u.Path, "/")
+	b.blockAccessTier = os.Getenv(ENV_BLOCK_ACCESS_TIER)
+
+	if err := connectionTest(b); err != nil {
+		return nil, err
+	}
+
+	b.destURL = KIND + "://" + b.service.Container + "@" + b.service.Account + "/" + b.path
+	log.Debugf("Loaded driver for %v", b.destURL)
+	return b, nil
+}
+
+func (a *AzureObjectStoreDriver) Kind() string {
+	return KIND
+}
+
+func (a *AzureObjectStoreDriver) GetURL() string {
+	return a.destURL
+}
+
+func (a *AzureObjectStoreDriver) updatePath(path string) string {
+	return filepath.Join(a.path, path)
+}
+
+func (a *AzureObjectStoreDriver) List(listPath string) ([]string, error) {
+	var result []string
+
+	path := a.updatePath(listPath) + "/"
+	if path == "/" {
+		path = ""
+	}
+	blobs, prefixes, err := a.service.ListBlobs(path, "/")
+	if err != nil {
+		log.Error("Fail to list azure: ", err)
+		return result, err
+	}
+
+	for _, b := range blobs {
+		r := strings.TrimPrefix(b.Name, path)
+		if r != "" {
+			result = append(result, r)
+		}
+	}
+	for _, p := range prefixes {
+		r := strings.TrimPrefix(p.Name, path)
+		r = strings.TrimSuffix(r, "/")
+		if r != "" {
+			result = append(result, r)
+		}
+	}
+
+	return result, nil
+}
+
+func (a *AzureObjectStoreDriver) FileExists(filePath string) bool {
+	return a.FileSize(filePath) >= 0
+}
+
+func (a *AzureObjectStoreDriver) FileSize(filePath string) int64 {
+	size, err := a.service.GetBlobSize(a.updatePath(filePath))
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+func (a *AzureObjectStoreDriver) Remove(names ...string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = a.updatePath(name)
+	}
+	return a.service.DeleteBlobs(paths)
+}
+
+func (a *AzureObjectStoreDriver) Read(src string) (io.ReadCloser, error) {
+	return a.service.GetBlob(a.updatePath(src))
+}
+
+func (a *AzureObjectStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	path := a.updatePath(dst)
+	if err := a.service.PutBlob(path, rs); err != nil {
+		return err
+	}
+	if a.blockAccessTier != "" && strings.HasSuffix(dst, objectstore.BLOCK_FILE_SUFFIX) {
+		return a.service.SetBlobTier(path, a.blockAccessTier)
+	}
+	return nil
+}
+
+func (a *AzureObjectStoreDriver) Upload(src, dst string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return a.service.PutBlob(a.updatePath(dst), file)
+}
+
+func (a *AzureObjectStoreDriver) Download(src, dst string) error {
+	if _, err := os.Stat(dst); err != nil {
+		os.Remove(dst)
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rc, err := a.service.GetBlob(a.updatePath(src))
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(f, rc)
+	return err
+}
+
+func (a *AzureObjectStoreDriver) ArchiveStatus(filePath string) (string, error) {
+	tier, archiveStatus, err := a.service.GetBlobTier(a.updatePath(filePath))
+	if err != nil {
+		return "", err
+	}
+	if tier != ACCESS_TIER_ARCHIVE {
+		return objectstore.ARCHIVE_STATUS_AVAILABLE, nil
+	}
+	if strings.HasPrefix(archiveStatus, "rehydrate-pending") {
+		return objectstore.ARCHIVE_STATUS_RETRIEVING, nil
+	}
+	return objectstore.ARCHIVE_STATUS_ARCHIVED, nil
+}
+
+// RetrieveFile rehydrates the blob to Hot tier. It would stay in Hot tier
+// until being moved back, e.g. by lifecycle management policy.
+func (a *AzureObjectStoreDriver) RetrieveFile(filePath string) error {
+	return a.service.SetBlobTier(a.updatePath(filePath), ACCESS_TIER_HOT)
+}
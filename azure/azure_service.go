@@ -0,0 +1,430 @@
+package azure
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	API_VERSION = "2015-04-05"
+
+	// Uploads larger than this would be split into blocks of this size,
+	// each block would be retried up to MAX_BLOCK_RETRIES times
+	MAX_PUT_BLOB_SIZE = 4 << 20
+	MAX_BLOCK_RETRIES = 3
+
+	// Access tiers need newer API version
+	TIER_API_VERSION = "2018-11-09"
+
+	ACCESS_TIER_HOT     = "Hot"
+	ACCESS_TIER_ARCHIVE = "Archive"
+)
+
+var (
+	blockRetryInterval = time.Second
+)
+
+type AzureService struct {
+	Account    string
+	Container  string
+	AccountKey string
+	SASToken   string
+	Endpoint   string
+
+	client *http.Client
+}
+
+type blobProperties struct {
+	ContentLength int64 `xml:"Content-Length"`
+}
+
+type blob struct {
+	Name       string
+	Properties blobProperties
+}
+
+type blobPrefix struct {
+	Name string
+}
+
+type listBlobsResult struct {
+	Blobs struct {
+		Blob       []blob
+		BlobPrefix []blobPrefix
+	}
+	NextMarker string
+}
+
+func (s *AzureService) httpClient() *http.Client {
+	if s.client == nil {
+		s.client = &http.Client{}
+	}
+	return s.client
+}
+
+func (s *AzureService) baseURL() string {
+	if s.Endpoint != "" {
+		return strings.TrimRight(s.Endpoint, "/")
+	}
+	return fmt.Sprintf("https://%s.blob.core.windows.net", s.Account)
+}
+
+func (s *AzureService) blobURL(key string, query url.Values) (*url.URL, error) {
+	u, err := url.Parse(s.baseURL() + "/" + s.Container + "/" + escapeBlobName(key))
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = query.Encode()
+	return u, nil
+}
+
+func escapeBlobName(key string) string {
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		parts[i] = url.QueryEscape(p)
+		parts[i] = strings.Replace(parts[i], "+", "%20", -1)
+	}
+	return strings.Join(parts, "/")
+}
+
+// signRequest signs the request using Shared Key authorization, see
+// https://docs.microsoft.com/rest/api/storageservices/authorize-with-shared-key
+func (s *AzureService) signRequest(req *http.Request) error {
+	key, err := base64.StdEncoding.DecodeString(s.AccountKey)
+	if err != nil {
+		return fmt.Errorf("Invalid Azure storage account key: %v", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(s.stringToSign(req)))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	req.Header.Set("Authorization", "SharedKey "+s.Account+":"+signature)
+	return nil
+}
+
+func (s *AzureService) stringToSign(req *http.Request) string {
+	contentLength := req.Header.Get("Content-Length")
+	if contentLength == "0" {
+		contentLength = ""
+	}
+	headers := []string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		req.Header.Get("Date"),
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	}
+
+	msHeaders := []string{}
+	for k := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-ms-") {
+			msHeaders = append(msHeaders, k)
+		}
+	}
+	sort.Strings(msHeaders)
+	canonicalizedHeaders := ""
+	for _, k := range msHeaders {
+		canonicalizedHeaders += k + ":" + strings.TrimSpace(req.Header.Get(k)) + "\n"
+	}
+
+	canonicalizedResource := "/" + s.Account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	params := []string{}
+	for k := range query {
+		params = append(params, k)
+	}
+	sort.Strings(params)
+	for _, k := range params {
+		values := query[k]
+		sort.Strings(values)
+		canonicalizedResource += "\n" + strings.ToLower(k) + ":" + strings.Join(values, ",")
+	}
+
+	return strings.Join(headers, "\n") + "\n" + canonicalizedHeaders + canonicalizedResource
+}
+
+func (s *AzureService) do(method string, u *url.URL, body []byte, headers map[string]string) (*http.Response, error) {
+	if s.SASToken != "" {
+		sas, err := url.ParseQuery(strings.TrimPrefix(s.SASToken, "?"))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Azure SAS token: %v", err)
+		}
+		query := u.Query()
+		for k, v := range sas {
+			query[k] = v
+		}
+		u.RawQuery = query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	if len(body) != 0 {
+		req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", API_VERSION)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if s.SASToken == "" {
+		if err := s.signRequest(req); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := s.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Azure Error: %v %v %v, %v", method, u.Path, resp.Status, string(msg))
+	}
+	return resp, nil
+}
+
+func (s *AzureService) ListBlobs(prefix, delimiter string) ([]blob, []blobPrefix, error) {
+	var (
+		blobs    []blob
+		prefixes []blobPrefix
+	)
+	marker := ""
+	for {
+		query := url.Values{}
+		query.Set("restype", "container")
+		query.Set("comp", "list")
+		query.Set("prefix", prefix)
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		u, err := url.Parse(s.baseURL() + "/" + s.Container)
+		if err != nil {
+			return nil, nil, err
+		}
+		u.RawQuery = query.Encode()
+
+		resp, err := s.do("GET", u, nil, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		result := &listBlobsResult{}
+		err = xml.NewDecoder(resp.Body).Decode(result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		blobs = append(blobs, result.Blobs.Blob...)
+		prefixes = append(prefixes, result.Blobs.BlobPrefix...)
+		if result.NextMarker == "" {
+			break
+		}
+		marker = result.NextMarker
+	}
+	return blobs, prefixes, nil
+}
+
+func (s *AzureService) GetBlobSize(key string) (int64, error) {
+	u, err := s.blobURL(key, url.Values{})
+	if err != nil {
+		return -1, err
+	}
+	resp, err := s.do("HEAD", u, nil, nil)
+	if err != nil {
+		return -1, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+func (s *AzureService) GetBlob(key string) (io.ReadCloser, error) {
+	u, err := s.blobURL(key, url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do("GET", u, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *AzureService) PutBlob(key string, r io.Reader) error {
+	data := make([]byte, MAX_PUT_BLOB_SIZE)
+	blockIDs := []string{}
+	for {
+		n, err := io.ReadFull(r, data)
+		if err == io.EOF && len(blockIDs) != 0 {
+			break
+		}
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if len(blockIDs) == 0 && err != nil {
+			// Small enough to be put in one request
+			return s.putSingleBlob(key, data[:n])
+		}
+
+		blockID := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", len(blockIDs))))
+		if err := s.putBlockWithRetry(key, blockID, data[:n]); err != nil {
+			return err
+		}
+		blockIDs = append(blockIDs, blockID)
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+	return s.putBlockList(key, blockIDs)
+}
+
+func (s *AzureService) putSingleBlob(key string, data []byte) error {
+	u, err := s.blobURL(key, url.Values{})
+	if err != nil {
+		return err
+	}
+	resp, err := s.do("PUT", u, data, map[string]string{
+		"x-ms-blob-type": "BlockBlob",
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// putBlockWithRetry sends the failed block again rather than the whole blob,
+// the uncommitted blocks would be discarded by Azure if the blob failed
+func (s *AzureService) putBlockWithRetry(key, blockID string, data []byte) error {
+	for retry := 0; ; retry++ {
+		err := s.putBlock(key, blockID, data)
+		if err == nil {
+			return nil
+		}
+		if retry >= MAX_BLOCK_RETRIES {
+			return fmt.Errorf("Failed to put block %v of %v after %v retries: %v", blockID, key, retry, err)
+		}
+		log.Warnf("Failed to put block %v of %v, would retry: %v", blockID, key, err)
+		time.Sleep(blockRetryInterval * time.Duration(retry+1))
+	}
+}
+
+func (s *AzureService) putBlock(key, blockID string, data []byte) error {
+	query := url.Values{}
+	query.Set("comp", "block")
+	query.Set("blockid", blockID)
+	u, err := s.blobURL(key, query)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do("PUT", u, data, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *AzureService) putBlockList(key string, blockIDs []string) error {
+	var b bytes.Buffer
+	b.WriteString(xml.Header + "<BlockList>")
+	for _, id := range blockIDs {
+		b.WriteString("<Latest>" + id + "</Latest>")
+	}
+	b.WriteString("</BlockList>")
+
+	query := url.Values{}
+	query.Set("comp", "blocklist")
+	u, err := s.blobURL(key, query)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do("PUT", u, b.Bytes(), nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *AzureService) DeleteBlobs(keys []string) error {
+	for _, key := range keys {
+		blobs, _, err := s.ListBlobs(key, "")
+		if err != nil {
+			return err
+		}
+		for _, b := range blobs {
+			u, err := s.blobURL(b.Name, url.Values{})
+			if err != nil {
+				return err
+			}
+			resp, err := s.do("DELETE", u, nil, nil)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+		}
+	}
+	return nil
+}
+
+// SetBlobTier moves the blob to the access tier. Moving an archived blob to
+// Hot or Cool tier would start the rehydration.
+func (s *AzureService) SetBlobTier(key, tier string) error {
+	query := url.Values{}
+	query.Set("comp", "tier")
+	u, err := s.blobURL(key, query)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do("PUT", u, nil, map[string]string{
+		"x-ms-version":     TIER_API_VERSION,
+		"x-ms-access-tier": tier,
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// GetBlobTier returns the access tier and the archive status, which is only
+// set during rehydration, e.g. "rehydrate-pending-to-hot"
+func (s *AzureService) GetBlobTier(key string) (string, string, error) {
+	u, err := s.blobURL(key, url.Values{})
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := s.do("HEAD", u, nil, map[string]string{
+		"x-ms-version": TIER_API_VERSION,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("x-ms-access-tier"), resp.Header.Get("x-ms-archive-status"), nil
+}
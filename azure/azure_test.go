@@ -0,0 +1,191 @@
+package azure
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rancher/convoy/objectstore"
+	"gopkg.in/check.v1"
+)
+
+func TestAzure(t *testing.T) { check.TestingT(t) }
+
+type AzureTestSuite struct{}
+
+var _ = check.Suite(&AzureTestSuite{})
+
+func (s *AzureTestSuite) SetUpTest(c *check.C) {
+	os.Setenv(ENV_STORAGE_KEY, "c2VjcmV0")
+	os.Unsetenv(ENV_STORAGE_SAS_TOKEN)
+}
+
+func runInitFunc(destURL string) (objectstore.ObjectStoreDriver, error) {
+	return initFuncWithConnectionCheck(destURL, "", func(d objectstore.ObjectStoreDriver) error {
+		return nil
+	})
+}
+
+func (s *AzureTestSuite) TestInitFuncBasicURL(c *check.C) {
+	driver, err := runInitFunc("azure://backups@myaccount//path")
+	c.Assert(err, check.IsNil)
+	c.Check(driver.GetURL(), check.Equals, "azure://backups@myaccount/path")
+
+	d := driver.(*AzureObjectStoreDriver)
+	c.Check(d.path, check.Equals, "path")
+	c.Check(d.service.Container, check.Equals, "backups")
+	c.Check(d.service.Account, check.Equals, "myaccount")
+	c.Check(d.service.AccountKey, check.Equals, "c2VjcmV0")
+}
+
+func (s *AzureTestSuite) TestInitFuncBadURL(c *check.C) {
+	_, err := runInitFunc("azure://myaccount/path")
+	c.Check(err, check.NotNil)
+
+	_, err = runInitFunc("s3://backups@myaccount/path")
+	c.Check(err, check.NotNil)
+}
+
+func (s *AzureTestSuite) TestInitFuncNoCredentials(c *check.C) {
+	os.Unsetenv(ENV_STORAGE_KEY)
+	_, err := runInitFunc("azure://backups@myaccount/path")
+	c.Check(err, check.NotNil)
+}
+
+func (s *AzureTestSuite) TestStringToSign(c *check.C) {
+	service := &AzureService{Account: "myaccount"}
+	req, err := http.NewRequest("GET", "https://myaccount.blob.core.windows.net/backups?restype=container&comp=list", nil)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("x-ms-date", "Fri, 26 Jun 2015 23:39:12 GMT")
+	req.Header.Set("x-ms-version", API_VERSION)
+
+	expected := "GET\n\n\n\n\n\n\n\n\n\n\n\n" +
+		"x-ms-date:Fri, 26 Jun 2015 23:39:12 GMT\nx-ms-version:" + API_VERSION + "\n" +
+		"/myaccount/backups\ncomp:list\nrestype:container"
+	c.Check(service.stringToSign(req), check.Equals, expected)
+}
+
+func (s *AzureTestSuite) TestPutBlobInBlocks(c *check.C) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("Authorization"), check.Matches, "SharedKey myaccount:.*")
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.URL.Query().Get("comp") == "blocklist" {
+			c.Check(bytes.Count(body, []byte("<Latest>")), check.Equals, 3)
+			requests = append(requests, "blocklist")
+		} else {
+			requests = append(requests, r.URL.Query().Get("comp")+":"+r.Header.Get("Content-Length"))
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	service := &AzureService{
+		Account:    "myaccount",
+		Container:  "backups",
+		AccountKey: "c2VjcmV0",
+		Endpoint:   server.URL,
+	}
+	data := make([]byte, 2*MAX_PUT_BLOB_SIZE+1)
+	c.Assert(service.PutBlob("path/file", bytes.NewReader(data)), check.IsNil)
+	c.Check(requests, check.DeepEquals, []string{"block:4194304", "block:4194304", "block:1", "blocklist"})
+
+	requests = nil
+	c.Assert(service.PutBlob("path/small", bytes.NewReader([]byte("small"))), check.IsNil)
+	c.Check(requests, check.DeepEquals, []string{":5"})
+}
+
+func (s *AzureTestSuite) TestPutBlockRetry(c *check.C) {
+	defer func(interval time.Duration) { blockRetryInterval = interval }(blockRetryInterval)
+	blockRetryInterval = 0
+
+	failures := 0
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		comp := r.URL.Query().Get("comp")
+		if comp == "block" && failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		requests = append(requests, comp)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	service := &AzureService{
+		Account:    "myaccount",
+		Container:  "backups",
+		AccountKey: "c2VjcmV0",
+		Endpoint:   server.URL,
+	}
+	data := make([]byte, MAX_PUT_BLOB_SIZE+1)
+	failures = MAX_BLOCK_RETRIES
+	c.Assert(service.PutBlob("path/file", bytes.NewReader(data)), check.IsNil)
+	c.Check(requests, check.DeepEquals, []string{"block", "block", "blocklist"})
+
+	requests = nil
+	failures = MAX_BLOCK_RETRIES + 1
+	err := service.PutBlob("path/file", bytes.NewReader(data))
+	c.Check(err, check.ErrorMatches, "Failed to put block .* of path/file after 3 retries: .*")
+	c.Check(requests, check.HasLen, 0)
+}
+
+func (s *AzureTestSuite) TestArchive(c *check.C) {
+	tiers := map[string]string{}
+	archiveStatus := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Path
+		switch {
+		case r.Method == "PUT" && r.URL.Query().Get("comp") == "tier":
+			c.Check(r.Header.Get("x-ms-version"), check.Equals, TIER_API_VERSION)
+			tier := r.Header.Get("x-ms-access-tier")
+			if tiers[key] == ACCESS_TIER_ARCHIVE && tier == ACCESS_TIER_HOT {
+				archiveStatus[key] = "rehydrate-pending-to-hot"
+			} else {
+				tiers[key] = tier
+			}
+			w.WriteHeader(http.StatusOK)
+		case r.Method == "PUT":
+			tiers[key] = ACCESS_TIER_HOT
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == "HEAD":
+			w.Header().Set("x-ms-access-tier", tiers[key])
+			if status, exists := archiveStatus[key]; exists {
+				w.Header().Set("x-ms-archive-status", status)
+			}
+		}
+	}))
+	defer server.Close()
+
+	os.Setenv(ENV_BLOCK_ACCESS_TIER, ACCESS_TIER_ARCHIVE)
+	defer os.Unsetenv(ENV_BLOCK_ACCESS_TIER)
+	driver, err := runInitFunc("azure://backups@myaccount/path")
+	c.Assert(err, check.IsNil)
+	d := driver.(*AzureObjectStoreDriver)
+	d.service.Endpoint = server.URL
+
+	blkFile := "blocks/aa/bb/aabbcc.blk"
+	c.Assert(d.Write(blkFile, bytes.NewReader([]byte("block"))), check.IsNil)
+	c.Assert(d.Write("volume.cfg", bytes.NewReader([]byte("cfg"))), check.IsNil)
+	c.Check(tiers["/backups/path/"+blkFile], check.Equals, ACCESS_TIER_ARCHIVE)
+	c.Check(tiers["/backups/path/volume.cfg"], check.Equals, ACCESS_TIER_HOT)
+
+	status, err := d.ArchiveStatus("volume.cfg")
+	c.Assert(err, check.IsNil)
+	c.Check(status, check.Equals, objectstore.ARCHIVE_STATUS_AVAILABLE)
+
+	status, err = d.ArchiveStatus(blkFile)
+	c.Assert(err, check.IsNil)
+	c.Check(status, check.Equals, objectstore.ARCHIVE_STATUS_ARCHIVED)
+
+	c.Assert(d.RetrieveFile(blkFile), check.IsNil)
+	status, err = d.ArchiveStatus(blkFile)
+	c.Assert(err, check.IsNil)
+	c.Check(status, check.Equals, objectstore.ARCHIVE_STATUS_RETRIEVING)
+}
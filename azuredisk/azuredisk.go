@@ -0,0 +1,825 @@
+package azuredisk
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	DRIVER_NAME        = "azuredisk"
+	DRIVER_CONFIG_FILE = "azuredisk.cfg"
+
+	VOLUME_CFG_PREFIX = "volume_"
+	CFG_PREFIX        = DRIVER_NAME + "_"
+	CFG_POSTFIX       = ".json"
+
+	AZURE_DEFAULT_VOLUME_SIZE = "azuredisk.defaultvolumesize"
+	AZURE_DEFAULT_VOLUME_TYPE = "azuredisk.defaultvolumetype"
+	AZURE_RESOURCE_GROUP      = "azuredisk.resourcegroup"
+	AZURE_CLIENT_ID           = "azuredisk.clientid"
+	AZURE_FSFREEZE            = "azuredisk.fsfreeze"
+
+	DEFAULT_VOLUME_SIZE = "4G"
+	DEFAULT_VOLUME_TYPE = "Standard_LRS"
+	DEFAULT_FSFREEZE    = "false"
+
+	TAG_VOLUME   = "convoy-volume"
+	TAG_SNAPSHOT = "convoy-snapshot"
+
+	MOUNTS_DIR = "mounts"
+
+	// Azure managed disk and snapshot names are limited to 80 characters
+	MAX_NAME_LENGTH = 80
+)
+
+var (
+	validName = regexp.MustCompile(`^[a-zA-Z0-9]([-a-zA-Z0-9_.]*[a-zA-Z0-9_])?$`)
+)
+
+type Driver struct {
+	mutex        *sync.RWMutex
+	azureService *azureService
+	Device
+}
+
+type Device struct {
+	Root              string
+	DefaultVolumeSize int64
+	DefaultVolumeType string
+	ResourceGroup     string
+	ClientID          string
+	FsFreeze          bool
+}
+
+func (dev *Device) ConfigFile() (string, error) {
+	if dev.Root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty device config path")
+	}
+	return filepath.Join(dev.Root, DRIVER_CONFIG_FILE), nil
+}
+
+type VolumeSnapshot struct {
+	Name       string
+	VolumeName string
+	SnapshotID string
+	SnapshotMetadata
+}
+
+type Volume struct {
+	Name       string
+	DiskID     string
+	Device     string
+	MountPoint string
+	Snapshots  map[string]VolumeSnapshot
+
+	configPath string
+}
+
+func (d *Driver) blankVolume(name string) *Volume {
+	return &Volume{
+		configPath: d.Root,
+		Name:       name,
+	}
+}
+
+func (v *Volume) ConfigFile() (string, error) {
+	if v.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if v.configPath == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume config path")
+	}
+	return filepath.Join(v.configPath, CFG_PREFIX+VOLUME_CFG_PREFIX+v.Name+CFG_POSTFIX), nil
+}
+
+func (v *Volume) GetDevice() (string, error) {
+	return v.Device, nil
+}
+
+func (v *Volume) GetMountOpts() []string {
+	return []string{}
+}
+
+func (v *Volume) GenerateDefaultMountPoint() string {
+	return filepath.Join(v.configPath, MOUNTS_DIR, v.Name)
+}
+
+func init() {
+	if err := Register(DRIVER_NAME, Init); err != nil {
+		panic(err)
+	}
+}
+
+func generateError(fields logrus.Fields, format string, v ...interface{}) error {
+	return ErrorWithFields("azuredisk", fields, format, v...)
+}
+
+func checkVolumeType(volumeType string) error {
+	validVolumeType := map[string]bool{
+		"Standard_LRS":    true,
+		"StandardSSD_LRS": true,
+		"StandardSSD_ZRS": true,
+		"Premium_LRS":     true,
+		"Premium_ZRS":     true,
+	}
+	if !validVolumeType[volumeType] {
+		return fmt.Errorf("Invalid volume type %v", volumeType)
+	}
+	return nil
+}
+
+// generateName generates an unique Azure resource name from the Convoy
+// names, since Convoy names may contain the characters not allowed by Azure
+func generateName(names ...string) string {
+	suffix := "-" + util.NewUUID()[:8]
+	name := "convoy"
+	for _, n := range names {
+		name += "-" + strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.':
+				return r
+			}
+			return '-'
+		}, n)
+	}
+	if len(name)+len(suffix) > MAX_NAME_LENGTH {
+		name = name[:MAX_NAME_LENGTH-len(suffix)]
+	}
+	return strings.TrimRight(name, "-.") + suffix
+}
+
+func (d *Driver) remountVolumes() error {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return err
+	}
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return err
+		}
+		if volume.MountPoint == "" {
+			continue
+		}
+		req := Request{
+			Name:    id,
+			Options: map[string]string{},
+		}
+		if _, err := d.MountVolume(req); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func Init(root string, config map[string]string) (ConvoyDriver, error) {
+	dev := &Device{
+		Root: root,
+	}
+	exists, err := util.ObjectExists(dev)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if err := util.ObjectLoad(dev); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := util.MkdirIfNotExists(root); err != nil {
+			return nil, err
+		}
+
+		if config[AZURE_DEFAULT_VOLUME_SIZE] == "" {
+			config[AZURE_DEFAULT_VOLUME_SIZE] = DEFAULT_VOLUME_SIZE
+		}
+		size, err := util.ParseSize(config[AZURE_DEFAULT_VOLUME_SIZE])
+		if err != nil {
+			return nil, err
+		}
+		if config[AZURE_DEFAULT_VOLUME_TYPE] == "" {
+			config[AZURE_DEFAULT_VOLUME_TYPE] = DEFAULT_VOLUME_TYPE
+		}
+		volumeType := config[AZURE_DEFAULT_VOLUME_TYPE]
+		if err := checkVolumeType(volumeType); err != nil {
+			return nil, err
+		}
+		if config[AZURE_FSFREEZE] == "" {
+			config[AZURE_FSFREEZE] = DEFAULT_FSFREEZE
+		}
+		fsFreeze, err := strconv.ParseBool(config[AZURE_FSFREEZE])
+		if err != nil {
+			return nil, err
+		}
+
+		dev = &Device{
+			Root:              root,
+			DefaultVolumeSize: size,
+			DefaultVolumeType: volumeType,
+			ResourceGroup:     config[AZURE_RESOURCE_GROUP],
+			ClientID:          config[AZURE_CLIENT_ID],
+			FsFreeze:          fsFreeze,
+		}
+	}
+
+	azureService, err := NewAzureService(dev.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	// Use the resource group of the VM by default
+	if dev.ResourceGroup == "" {
+		dev.ResourceGroup = azureService.ResourceGroup
+	}
+	if !exists {
+		if err := util.ObjectSave(dev); err != nil {
+			return nil, err
+		}
+	}
+
+	d := &Driver{
+		mutex:        &sync.RWMutex{},
+		azureService: azureService,
+		Device:       *dev,
+	}
+	if err := d.remountVolumes(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Driver) Name() string {
+	return DRIVER_NAME
+}
+
+func (d *Driver) Info() (map[string]string, error) {
+	return map[string]string{
+		"DefaultVolumeSize": strconv.FormatInt(d.DefaultVolumeSize, 10),
+		"DefaultVolumeType": d.DefaultVolumeType,
+		"FsFreeze":          strconv.FormatBool(d.FsFreeze),
+		"SubscriptionID":    d.azureService.SubscriptionID,
+		"ResourceGroup":     d.ResourceGroup,
+		"Location":          d.azureService.Location,
+		"Zone":              d.azureService.Zone,
+		"VMName":            d.azureService.VMName,
+	}, nil
+}
+
+func (d *Driver) VolumeOps() (VolumeOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) getSize(opts map[string]string, defaultVolumeSize int64) (int64, error) {
+	size := opts[OPT_SIZE]
+	if size == "" || size == "0" {
+		size = strconv.FormatInt(defaultVolumeSize, 10)
+	}
+	return util.ParseSize(size)
+}
+
+func (d *Driver) getType(opts map[string]string) (string, error) {
+	volumeType := opts[OPT_VOLUME_TYPE]
+	if volumeType == "" {
+		volumeType = d.DefaultVolumeType
+	}
+	if err := checkVolumeType(volumeType); err != nil {
+		return "", err
+	}
+	return volumeType, nil
+}
+
+func (d *Driver) CreateVolume(req Request) error {
+	var (
+		err    error
+		format bool
+	)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	opts := req.Options
+
+	volume := d.blankVolume(id)
+	exists, err := util.ObjectExists(volume)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Volume %v already exists", id)
+	}
+
+	// Azure managed disk name
+	diskName := opts[OPT_VOLUME_DRIVER_ID]
+	backupURL := opts[OPT_BACKUP_URL]
+	if backupURL != "" && diskName != "" {
+		return fmt.Errorf("Cannot specify both backup and Azure disk name")
+	}
+
+	tags := map[string]string{
+		TAG_VOLUME: id,
+	}
+	var diskID string
+	if diskName != "" {
+		disk, err := d.azureService.GetDisk(d.azureService.resourceID(d.ResourceGroup, "disks", diskName))
+		if err != nil {
+			return err
+		}
+		diskID = disk.ID
+		log.Debugf("Found Azure disk %v for volume %v, update tags", diskName, id)
+		if err := d.azureService.AddTags(diskID, tags); err != nil {
+			log.Debugf("Failed to update tags for disk %v, but continue", diskName)
+		}
+	} else {
+		volumeType, err := d.getType(opts)
+		if err != nil {
+			return err
+		}
+		r := &CreateDiskRequest{
+			ResourceGroup: d.ResourceGroup,
+			Name:          generateName(id),
+			DiskType:      volumeType,
+			Tags:          tags,
+		}
+		if backupURL != "" {
+			resourceGroup, snapshotName, err := decodeURL(backupURL)
+			if err != nil {
+				return err
+			}
+			snapshot, err := d.azureService.GetSnapshot(d.azureService.resourceID(resourceGroup, "snapshots", snapshotName))
+			if err != nil {
+				return err
+			}
+			if snapshot.Properties.ProvisioningState != "Succeeded" {
+				return fmt.Errorf("Snapshot %v is not ready, provisioning state %v",
+					snapshotName, snapshot.Properties.ProvisioningState)
+			}
+			snapshotVolumeSize := snapshot.Properties.DiskSizeGB * GB
+			if r.Size, err = d.getSize(opts, snapshotVolumeSize); err != nil {
+				return err
+			}
+			if r.Size < snapshotVolumeSize {
+				return fmt.Errorf("Volume size cannot be less than snapshot size %v", snapshotVolumeSize)
+			}
+			r.SourceResourceID = snapshot.ID
+		} else {
+			if r.Size, err = d.getSize(opts, d.DefaultVolumeSize); err != nil {
+				return err
+			}
+			format = true
+		}
+		disk, err := d.azureService.CreateDisk(r)
+		if err != nil {
+			return err
+		}
+		diskID = disk.ID
+		log.Debugf("Created Azure disk %v for volume %v", disk.Name, id)
+	}
+
+	dev, err := d.azureService.AttachDisk(diskID)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Attached Azure disk %v to %v", diskID, dev)
+
+	volume.Name = id
+	volume.DiskID = diskID
+	volume.Device = dev
+	volume.Snapshots = make(map[string]VolumeSnapshot)
+
+	// We don't format existing or snapshot restored volume
+	if format {
+		if _, err := util.Execute("mkfs", []string{"-t", "ext4", dev}); err != nil {
+			return err
+		}
+	}
+
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) DeleteVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	opts := req.Options
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	referenceOnly, _ := strconv.ParseBool(opts[OPT_REFERENCE_ONLY])
+	if err := d.azureService.DetachDisk(volume.DiskID); err != nil {
+		if !referenceOnly {
+			return err
+		}
+		//Ignore the error, remove the reference
+		log.Warnf("Unable to detached %v(%v) due to %v, but continue with removing the reference",
+			id, volume.DiskID, err)
+	} else {
+		log.Debugf("Detached %v(%v) from %v", id, volume.DiskID, volume.Device)
+	}
+
+	if !referenceOnly {
+		if err := d.azureService.DeleteDisk(volume.DiskID); err != nil {
+			return err
+		}
+		log.Debugf("Deleted %v(%v)", id, volume.DiskID)
+	}
+	return util.ObjectDelete(volume)
+}
+
+func (d *Driver) MountVolume(req Request) (string, error) {
+	id := req.Name
+	opts := req.Options
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+
+	mountPoint, err := util.VolumeMount(volume, opts[OPT_MOUNT_POINT], false)
+	if err != nil {
+		return "", err
+	}
+
+	if err := util.ObjectSave(volume); err != nil {
+		return "", err
+	}
+
+	return mountPoint, nil
+}
+
+func (d *Driver) UmountVolume(req Request) error {
+	id := req.Name
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	if err := util.VolumeUmount(volume); err != nil {
+		return err
+	}
+
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) MountPoint(req Request) (string, error) {
+	id := req.Name
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	return volume.MountPoint, nil
+}
+
+func (d *Driver) GetVolumeInfo(id string) (map[string]string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+
+	disk, err := d.azureService.GetDisk(volume.DiskID)
+	if err != nil {
+		return nil, err
+	}
+
+	diskType := ""
+	if disk.Sku != nil {
+		diskType = disk.Sku.Name
+	}
+	return map[string]string{
+		"Device":                volume.Device,
+		"MountPoint":            volume.MountPoint,
+		"AzureDiskID":           disk.ID,
+		"AzureDiskName":         disk.Name,
+		"Location":              disk.Location,
+		OPT_VOLUME_NAME:         id,
+		OPT_VOLUME_CREATED_TIME: disk.Properties.TimeCreated,
+		"Size":                  strconv.FormatInt(disk.Properties.DiskSizeGB*GB, 10),
+		"State":                 disk.Properties.DiskState,
+		"Type":                  diskType,
+		"SourceSnapshot":        disk.Properties.CreationData.SourceResourceID,
+	}, nil
+}
+
+func (d *Driver) listVolumeNames() ([]string, error) {
+	return util.ListConfigIDs(d.Root, CFG_PREFIX+VOLUME_CFG_PREFIX, CFG_POSTFIX)
+}
+
+func (d *Driver) ListVolume(opts map[string]string) (map[string]map[string]string, error) {
+	volumes := make(map[string]map[string]string)
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, uuid := range volumeIDs {
+		volumes[uuid], err = d.GetVolumeInfo(uuid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return volumes, nil
+}
+
+func (d *Driver) SnapshotOps() (SnapshotOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) getSnapshotAndVolume(snapshotID, volumeID string) (*VolumeSnapshot, *Volume, error) {
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, nil, err
+	}
+	snap, exists := volume.Snapshots[snapshotID]
+	if !exists {
+		return nil, nil, generateError(logrus.Fields{
+			LOG_FIELD_VOLUME:   volumeID,
+			LOG_FIELD_SNAPSHOT: snapshotID,
+		}, "cannot find snapshot of volume")
+	}
+	return &snap, volume, nil
+}
+
+func (d *Driver) CreateSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if _, exists := volume.Snapshots[id]; exists {
+		return generateError(logrus.Fields{
+			LOG_FIELD_VOLUME:   volumeID,
+			LOG_FIELD_SNAPSHOT: id,
+		}, "Already has snapshot with uuid")
+	}
+
+	if volume.MountPoint != "" {
+		log.Debugf("syncing filesystems...")
+		if err := util.Sync(); err != nil {
+			return err
+		}
+
+		if d.FsFreeze {
+			log.Debugf("freezing %v", volume.MountPoint)
+			if err := util.Freeze(volume.MountPoint); err != nil {
+				return err
+			}
+			defer func() {
+				log.Debugf("unfreezing %v", volume.MountPoint)
+				if err := util.UnFreeze(volume.MountPoint); err != nil {
+					log.Errorf("Failed to unfreeze %v: %v", volume.MountPoint, err)
+				}
+			}()
+		}
+	}
+
+	request := &CreateSnapshotRequest{
+		ResourceGroup: d.ResourceGroup,
+		DiskID:        volume.DiskID,
+		Name:          generateName(volumeID, id),
+		Tags: map[string]string{
+			TAG_VOLUME:   volumeID,
+			TAG_SNAPSHOT: id,
+		},
+	}
+	snapshot, err := d.azureService.CreateSnapshot(request)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Created snapshot %v(%v) of volume %v(%v)", id, snapshot.ID, volumeID, volume.DiskID)
+
+	volume.Snapshots[id] = VolumeSnapshot{
+		Name:             id,
+		VolumeName:       volumeID,
+		SnapshotID:       snapshot.ID,
+		SnapshotMetadata: NewSnapshotMetadata(req.Options),
+	}
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) DeleteSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return err
+	}
+
+	// The Azure snapshot is the backup as well, so it would only be removed
+	// by DeleteBackup
+	log.Debugf("Removing reference of snapshot %v(%v) of volume %v(%v)", id, snapshot.SnapshotID, volumeID, volume.DiskID)
+	delete(volume.Snapshots, id)
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) GetSnapshotInfo(req Request) (map[string]string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.getSnapshotInfo(id, volumeID)
+}
+
+func (d *Driver) getSnapshotInfo(id, volumeID string) (map[string]string, error) {
+	snapshot, _, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Snapshot on Azure can be removed by DeleteBackup
+	azureSnapshot, err := d.azureService.GetSnapshot(snapshot.SnapshotID)
+	if err != nil {
+		if !IsNotFound(err) {
+			return nil, err
+		}
+		return map[string]string{
+			OPT_SNAPSHOT_NAME:        snapshot.Name,
+			OPT_SNAPSHOT_LABELS:      snapshot.Labels,
+			OPT_SNAPSHOT_DESCRIPTION: snapshot.Description,
+			"VolumeName":             volumeID,
+			"State":                  "removed",
+		}, nil
+	}
+
+	return map[string]string{
+		OPT_SNAPSHOT_NAME:         snapshot.Name,
+		OPT_SNAPSHOT_LABELS:       snapshot.Labels,
+		OPT_SNAPSHOT_DESCRIPTION:  snapshot.Description,
+		"VolumeName":              volumeID,
+		"AzureSnapshotID":         azureSnapshot.ID,
+		"AzureSnapshotName":       azureSnapshot.Name,
+		"AzureDiskID":             azureSnapshot.Properties.CreationData.SourceResourceID,
+		OPT_SNAPSHOT_CREATED_TIME: azureSnapshot.Properties.TimeCreated,
+		OPT_SIZE:                  strconv.FormatInt(azureSnapshot.Properties.DiskSizeGB*GB, 10),
+		"State":                   azureSnapshot.Properties.ProvisioningState,
+	}, nil
+}
+
+func (d *Driver) ListSnapshot(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var (
+		volumeIDs []string
+		err       error
+	)
+	snapshots := make(map[string]map[string]string)
+	specifiedVolumeID, _ := util.GetFieldFromOpts(OPT_VOLUME_NAME, opts)
+	if specifiedVolumeID != "" {
+		volumeIDs = []string{
+			specifiedVolumeID,
+		}
+	} else {
+		volumeIDs, err = d.listVolumeNames()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, volumeID := range volumeIDs {
+		volume := d.blankVolume(volumeID)
+		if err := util.ObjectLoad(volume); err != nil {
+			return nil, err
+		}
+		for snapshotID := range volume.Snapshots {
+			snapshots[snapshotID], err = d.getSnapshotInfo(snapshotID, volumeID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return snapshots, nil
+}
+
+func (d *Driver) BackupOps() (BackupOperations, error) {
+	return d, nil
+}
+
+func encodeURL(resourceGroup, snapshotName string) string {
+	return DRIVER_NAME + "://" + resourceGroup + "/" + snapshotName
+}
+
+func decodeURL(backupURL string) (string, string, error) {
+	u, err := url.Parse(backupURL)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != DRIVER_NAME {
+		return "", "", fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, DRIVER_NAME)
+	}
+
+	resourceGroup := u.Host
+	snapshotName := strings.Trim(u.Path, "/")
+	if resourceGroup == "" || !validName.MatchString(snapshotName) {
+		return "", "", fmt.Errorf("Invalid Azure backup URL %v, must be azuredisk://<resource group>/<snapshot>", backupURL)
+	}
+	return resourceGroup, snapshotName, nil
+}
+
+func (d *Driver) CreateBackup(snapshotID, volumeID, destURL, endpointURL string, opts map[string]string) (string, error) {
+	//destURL is not necessary in Azure case
+	snapshot, _, err := d.getSnapshotAndVolume(snapshotID, volumeID)
+	if err != nil {
+		return "", err
+	}
+
+	azureSnapshot, err := d.azureService.GetSnapshot(snapshot.SnapshotID)
+	if err != nil {
+		return "", err
+	}
+	return encodeURL(d.ResourceGroup, azureSnapshot.Name), nil
+}
+
+func (d *Driver) DeleteBackup(backupURL, endpointURL string, opts map[string]string) error {
+	// Would remove the snapshot
+	resourceGroup, snapshotName, err := decodeURL(backupURL)
+	if err != nil {
+		return err
+	}
+	return d.azureService.DeleteSnapshot(d.azureService.resourceID(resourceGroup, "snapshots", snapshotName))
+}
+
+func (d *Driver) GetBackupInfo(backupURL, endpointURL string) (map[string]string, error) {
+	resourceGroup, snapshotName, err := decodeURL(backupURL)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := d.azureService.GetSnapshot(d.azureService.resourceID(resourceGroup, "snapshots", snapshotName))
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"ResourceGroup":     resourceGroup,
+		"AzureSnapshotName": snapshot.Name,
+		"AzureDiskID":       snapshot.Properties.CreationData.SourceResourceID,
+		"VolumeName":        snapshot.Tags[TAG_VOLUME],
+		"SnapshotName":      snapshot.Tags[TAG_SNAPSHOT],
+		"StartTime":         snapshot.Properties.TimeCreated,
+		"Size":              strconv.FormatInt(snapshot.Properties.DiskSizeGB*GB, 10),
+		"State":             snapshot.Properties.ProvisioningState,
+	}, nil
+}
+
+func (d *Driver) ListBackup(destURL, endpointURL string, opts map[string]string) (map[string]map[string]string, error) {
+	// In Azure, the backups are really the snapshots. So list the snapshots
+	// and reformat the output for its consistent with the other drivers
+	snapshots, err := d.ListSnapshot(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make(map[string]map[string]string)
+	for k, v := range snapshots {
+		if v["State"] != "removed" {
+			backupURL := encodeURL(d.ResourceGroup, v["AzureSnapshotName"])
+			backups[backupURL] = map[string]string{
+				"BackupName":        v["AzureSnapshotName"],
+				"BackupURL":         backupURL,
+				"CreatedTime":       v[OPT_SNAPSHOT_CREATED_TIME],
+				"DriverName":        DRIVER_NAME,
+				"SnapshotCreatedAt": v[OPT_SNAPSHOT_CREATED_TIME],
+				"SnapshotName":      k,
+				"VolumeCreatedAt":   "",
+				"VolumeName":        v["VolumeName"],
+				"VolumeSize":        v[OPT_SIZE],
+			}
+		}
+	}
+	return backups, nil
+}
@@ -0,0 +1,532 @@
+package azuredisk
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	GB = 1073741824
+
+	METADATA_URL   = "http://169.254.169.254/metadata/"
+	MANAGEMENT_URL = "https://management.azure.com/"
+
+	METADATA_API_VERSION = "2021-02-01"
+	TOKEN_API_VERSION    = "2018-02-01"
+	COMPUTE_API_VERSION  = "2022-03-02"
+	VM_API_VERSION       = "2022-03-01"
+
+	// The udev rules of Azure Linux agent would link the data disks here
+	DEVICE_PATH_PREFIX = "/dev/disk/azure/scsi1/lun"
+
+	MAX_LUN = 64
+
+	OPERATION_TIMEOUT = 10 * time.Minute
+	DEVICE_TIMEOUT    = 2 * time.Minute
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "azuredisk"})
+
+	// RetryInterval is the interval of polling operations and devices
+	RetryInterval = 3 * time.Second
+)
+
+type azureService struct {
+	client        *http.Client
+	metadataURL   string
+	managementURL string
+	clientID      string
+
+	mutex       *sync.Mutex
+	token       string
+	tokenExpiry time.Time
+
+	SubscriptionID string
+	ResourceGroup  string
+	VMName         string
+	Location       string
+	Zone           string
+}
+
+type Sku struct {
+	Name string `json:"name"`
+}
+
+type CreationData struct {
+	CreateOption     string `json:"createOption"`
+	SourceResourceID string `json:"sourceResourceId,omitempty"`
+}
+
+type DiskProperties struct {
+	CreationData      CreationData `json:"creationData"`
+	DiskSizeGB        int64        `json:"diskSizeGB,omitempty"`
+	DiskState         string       `json:"diskState,omitempty"`
+	ProvisioningState string       `json:"provisioningState,omitempty"`
+	TimeCreated       string       `json:"timeCreated,omitempty"`
+	Incremental       bool         `json:"incremental,omitempty"`
+}
+
+// Disk is either a managed disk or a snapshot, which share the same schema
+// for the fields used here
+type Disk struct {
+	ID         string            `json:"id,omitempty"`
+	Name       string            `json:"name,omitempty"`
+	Location   string            `json:"location"`
+	Zones      []string          `json:"zones,omitempty"`
+	Sku        *Sku              `json:"sku,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	ManagedBy  string            `json:"managedBy,omitempty"`
+	Properties DiskProperties    `json:"properties"`
+}
+
+type dataDisk struct {
+	Lun          int               `json:"lun"`
+	Name         string            `json:"name,omitempty"`
+	CreateOption string            `json:"createOption"`
+	Caching      string            `json:"caching,omitempty"`
+	ManagedDisk  map[string]string `json:"managedDisk,omitempty"`
+	DiskSizeGB   int64             `json:"diskSizeGB,omitempty"`
+}
+
+type virtualMachine struct {
+	Properties struct {
+		StorageProfile struct {
+			DataDisks []dataDisk `json:"dataDisks"`
+		} `json:"storageProfile"`
+		ProvisioningState string `json:"provisioningState,omitempty"`
+	} `json:"properties"`
+}
+
+type apiError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type NotFoundError struct {
+	Resource string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("Cannot find %v", e.Resource)
+}
+
+func IsNotFound(err error) bool {
+	_, ok := err.(*NotFoundError)
+	return ok
+}
+
+// NewAzureService loads the subscription, resource group and VM from the
+// instance metadata service, so it can only be used on an Azure VM with
+// managed identity enabled
+func NewAzureService(clientID string) (*azureService, error) {
+	return newAzureService(&http.Client{Timeout: time.Minute}, METADATA_URL, MANAGEMENT_URL, clientID)
+}
+
+func newAzureService(client *http.Client, metadataURL, managementURL, clientID string) (*azureService, error) {
+	s := &azureService{
+		client:        client,
+		metadataURL:   metadataURL,
+		managementURL: managementURL,
+		clientID:      clientID,
+		mutex:         &sync.Mutex{},
+	}
+	query := url.Values{}
+	query.Set("api-version", METADATA_API_VERSION)
+	instance := struct {
+		Compute struct {
+			SubscriptionID    string `json:"subscriptionId"`
+			ResourceGroupName string `json:"resourceGroupName"`
+			Name              string `json:"name"`
+			Location          string `json:"location"`
+			Zone              string `json:"zone"`
+		} `json:"compute"`
+	}{}
+	if err := s.getMetadata("instance", query, &instance); err != nil {
+		return nil, fmt.Errorf("Not running on an Azure VM: %v", err)
+	}
+	s.SubscriptionID = instance.Compute.SubscriptionID
+	s.ResourceGroup = instance.Compute.ResourceGroupName
+	s.VMName = instance.Compute.Name
+	s.Location = instance.Compute.Location
+	s.Zone = instance.Compute.Zone
+	return s, nil
+}
+
+func (s *azureService) getMetadata(key string, query url.Values, out interface{}) error {
+	req, err := http.NewRequest("GET", s.metadataURL+key+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Metadata", "true")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to get metadata %v: %v %v", key, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, out)
+}
+
+// getToken returns the access token of the managed identity of the VM, which
+// would be refreshed before it expires
+func (s *azureService) getToken() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.token != "" && time.Now().Before(s.tokenExpiry) {
+		return s.token, nil
+	}
+	query := url.Values{}
+	query.Set("api-version", TOKEN_API_VERSION)
+	query.Set("resource", MANAGEMENT_URL)
+	if s.clientID != "" {
+		query.Set("client_id", s.clientID)
+	}
+	token := struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}{}
+	if err := s.getMetadata("identity/oauth2/token", query, &token); err != nil {
+		return "", err
+	}
+	expiresIn, err := token.ExpiresIn.Int64()
+	if err != nil {
+		return "", err
+	}
+	s.token = token.AccessToken
+	s.tokenExpiry = time.Now().Add(time.Duration(expiresIn)*time.Second - time.Minute)
+	return s.token, nil
+}
+
+func (s *azureService) resourceID(resourceGroup, resourceType, name string) string {
+	return path.Join("/subscriptions", s.SubscriptionID, "resourceGroups", resourceGroup,
+		"providers/Microsoft.Compute", resourceType, name)
+}
+
+func (s *azureService) do(method, resourceID, apiVersion string, in, out interface{}) error {
+	token, err := s.getToken()
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	u := s.managementURL + strings.TrimLeft(resourceID, "/") + "?api-version=" + apiVersion
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return &NotFoundError{Resource: resourceID}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := &apiError{}
+		if err := json.Unmarshal(data, apiErr); err == nil && apiErr.Error.Message != "" {
+			return fmt.Errorf("Azure Error: %v %v", apiErr.Error.Code, apiErr.Error.Message)
+		}
+		return fmt.Errorf("Azure Error: %v %v %v", method, resourceID, resp.Status)
+	}
+	if out != nil && len(data) != 0 {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+// waitForProvisioning polls the resource until it's provisioned, or gone if
+// deleted is true
+func (s *azureService) waitForProvisioning(resourceID, apiVersion string, deleted bool) error {
+	deadline := time.Now().Add(OPERATION_TIMEOUT)
+	for {
+		resource := struct {
+			Properties struct {
+				ProvisioningState string `json:"provisioningState"`
+			} `json:"properties"`
+		}{}
+		err := s.do("GET", resourceID, apiVersion, nil, &resource)
+		if deleted && IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		state := resource.Properties.ProvisioningState
+		if !deleted {
+			switch state {
+			case "Succeeded":
+				return nil
+			case "Failed", "Canceled":
+				return fmt.Errorf("Provisioning of %v %v", resourceID, strings.ToLower(state))
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timed out waiting for %v, provisioning state %v", resourceID, state)
+		}
+		log.Debugf("Waiting for %v, provisioning state %v", resourceID, state)
+		time.Sleep(RetryInterval)
+	}
+}
+
+type CreateDiskRequest struct {
+	ResourceGroup    string
+	Name             string
+	Size             int64
+	DiskType         string
+	SourceResourceID string
+	Tags             map[string]string
+}
+
+// CreateDisk creates an empty managed disk, or a disk copied from the
+// snapshot if SourceResourceID is specified, in the location and zone of the
+// VM
+func (s *azureService) CreateDisk(request *CreateDiskRequest) (*Disk, error) {
+	disk := &Disk{
+		Location: s.Location,
+		Sku:      &Sku{Name: request.DiskType},
+		Tags:     request.Tags,
+		Properties: DiskProperties{
+			CreationData: CreationData{CreateOption: "Empty"},
+			DiskSizeGB:   (request.Size + GB - 1) / GB,
+		},
+	}
+	if s.Zone != "" {
+		disk.Zones = []string{s.Zone}
+	}
+	if request.SourceResourceID != "" {
+		disk.Properties.CreationData = CreationData{
+			CreateOption:     "Copy",
+			SourceResourceID: request.SourceResourceID,
+		}
+	}
+	id := s.resourceID(request.ResourceGroup, "disks", request.Name)
+	if err := s.do("PUT", id, COMPUTE_API_VERSION, disk, nil); err != nil {
+		return nil, err
+	}
+	if err := s.waitForProvisioning(id, COMPUTE_API_VERSION, false); err != nil {
+		return nil, err
+	}
+	return s.GetDisk(id)
+}
+
+func (s *azureService) GetDisk(id string) (*Disk, error) {
+	disk := &Disk{}
+	if err := s.do("GET", id, COMPUTE_API_VERSION, nil, disk); err != nil {
+		return nil, err
+	}
+	return disk, nil
+}
+
+func (s *azureService) DeleteDisk(id string) error {
+	if err := s.do("DELETE", id, COMPUTE_API_VERSION, nil, nil); err != nil {
+		return err
+	}
+	return s.waitForProvisioning(id, COMPUTE_API_VERSION, true)
+}
+
+func (s *azureService) AddTags(id string, tags map[string]string) error {
+	disk, err := s.GetDisk(id)
+	if err != nil {
+		return err
+	}
+	if disk.Tags == nil {
+		disk.Tags = map[string]string{}
+	}
+	for k, v := range tags {
+		disk.Tags[k] = v
+	}
+	return s.do("PATCH", id, COMPUTE_API_VERSION, map[string]interface{}{"tags": disk.Tags}, nil)
+}
+
+func (s *azureService) vmID() string {
+	return s.resourceID(s.ResourceGroup, "virtualMachines", s.VMName)
+}
+
+func (s *azureService) getVM() (*virtualMachine, error) {
+	vm := &virtualMachine{}
+	if err := s.do("GET", s.vmID(), VM_API_VERSION, nil, vm); err != nil {
+		return nil, err
+	}
+	return vm, nil
+}
+
+func (s *azureService) updateDataDisks(disks []dataDisk) error {
+	update := map[string]interface{}{
+		"properties": map[string]interface{}{
+			"storageProfile": map[string]interface{}{
+				"dataDisks": disks,
+			},
+		},
+	}
+	if err := s.do("PATCH", s.vmID(), VM_API_VERSION, update, nil); err != nil {
+		return err
+	}
+	return s.waitForProvisioning(s.vmID(), VM_API_VERSION, false)
+}
+
+// DevicePath returns the path of the data disk attached at lun
+func DevicePath(lun int) string {
+	return fmt.Sprintf("%v%d", DEVICE_PATH_PREFIX, lun)
+}
+
+func freeLun(disks []dataDisk) (int, error) {
+	used := map[int]bool{}
+	for _, d := range disks {
+		used[d.Lun] = true
+	}
+	for lun := 0; lun < MAX_LUN; lun++ {
+		if !used[lun] {
+			return lun, nil
+		}
+	}
+	return 0, fmt.Errorf("No free LUN on the VM")
+}
+
+func findDataDisk(disks []dataDisk, id string) int {
+	for i, d := range disks {
+		if strings.EqualFold(d.ManagedDisk["id"], id) {
+			return i
+		}
+	}
+	return -1
+}
+
+// AttachDisk attaches the disk to the VM at a free LUN, and waits until the
+// device shows up
+func (s *azureService) AttachDisk(id string) (string, error) {
+	disk, err := s.GetDisk(id)
+	if err != nil {
+		return "", err
+	}
+	vm, err := s.getVM()
+	if err != nil {
+		return "", err
+	}
+	disks := vm.Properties.StorageProfile.DataDisks
+	idx := findDataDisk(disks, disk.ID)
+	if idx < 0 {
+		if disk.ManagedBy != "" {
+			return "", fmt.Errorf("Disk %v is attached to other VM %v", disk.Name, disk.ManagedBy)
+		}
+		lun, err := freeLun(disks)
+		if err != nil {
+			return "", err
+		}
+		disks = append(disks, dataDisk{
+			Lun:          lun,
+			Name:         disk.Name,
+			CreateOption: "Attach",
+			Caching:      "None",
+			ManagedDisk:  map[string]string{"id": disk.ID},
+		})
+		if err := s.updateDataDisks(disks); err != nil {
+			return "", err
+		}
+		idx = len(disks) - 1
+	}
+	dev := DevicePath(disks[idx].Lun)
+	if err := waitForDevice(dev); err != nil {
+		return "", err
+	}
+	return dev, nil
+}
+
+func waitForDevice(dev string) error {
+	for start := time.Now(); time.Since(start) < DEVICE_TIMEOUT; time.Sleep(RetryInterval) {
+		if _, err := os.Stat(dev); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("Timed out waiting for device %v", dev)
+}
+
+func (s *azureService) DetachDisk(id string) error {
+	vm, err := s.getVM()
+	if err != nil {
+		return err
+	}
+	disks := vm.Properties.StorageProfile.DataDisks
+	idx := findDataDisk(disks, id)
+	if idx < 0 {
+		return nil
+	}
+	disks = append(disks[:idx], disks[idx+1:]...)
+	return s.updateDataDisks(disks)
+}
+
+type CreateSnapshotRequest struct {
+	ResourceGroup string
+	DiskID        string
+	Name          string
+	Tags          map[string]string
+}
+
+// CreateSnapshot creates an incremental snapshot of the disk
+func (s *azureService) CreateSnapshot(request *CreateSnapshotRequest) (*Disk, error) {
+	snapshot := &Disk{
+		Location: s.Location,
+		Tags:     request.Tags,
+		Properties: DiskProperties{
+			CreationData: CreationData{
+				CreateOption:     "Copy",
+				SourceResourceID: request.DiskID,
+			},
+			Incremental: true,
+		},
+	}
+	id := s.resourceID(request.ResourceGroup, "snapshots", request.Name)
+	if err := s.do("PUT", id, COMPUTE_API_VERSION, snapshot, nil); err != nil {
+		return nil, err
+	}
+	if err := s.waitForProvisioning(id, COMPUTE_API_VERSION, false); err != nil {
+		return nil, err
+	}
+	return s.GetSnapshot(id)
+}
+
+func (s *azureService) GetSnapshot(id string) (*Disk, error) {
+	return s.GetDisk(id)
+}
+
+func (s *azureService) DeleteSnapshot(id string) error {
+	return s.DeleteDisk(id)
+}
@@ -0,0 +1,214 @@
+package azuredisk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+const (
+	testRG = "/subscriptions/sub1/resourceGroups/rg1/providers/Microsoft.Compute/"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct {
+	server *httptest.Server
+
+	mutex    sync.Mutex
+	requests []string
+	bodies   map[string]map[string]interface{}
+	polls    int
+}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) SetUpTest(c *C) {
+	RetryInterval = time.Millisecond
+	s.requests = nil
+	s.bodies = map[string]map[string]interface{}{}
+	s.polls = 0
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+}
+
+func (s *TestSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *TestSuite) handle(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if strings.HasPrefix(r.URL.Path, "/metadata/") {
+		if r.Header.Get("Metadata") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch strings.TrimPrefix(r.URL.Path, "/metadata/") {
+		case "instance":
+			fmt.Fprint(w, `{"compute":{"subscriptionId":"sub1","resourceGroupName":"rg1","name":"vm1","location":"westus2","zone":"1"}}`)
+		case "identity/oauth2/token":
+			if r.URL.Query().Get("resource") != MANAGEMENT_URL {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"token1","expires_in":"3600","token_type":"Bearer"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer token1" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	p := "/" + strings.TrimPrefix(r.URL.Path, "/arm/")
+	s.requests = append(s.requests, r.Method+" "+p)
+	if r.Body != nil {
+		data, _ := ioutil.ReadAll(r.Body)
+		if len(data) != 0 {
+			body := map[string]interface{}{}
+			json.Unmarshal(data, &body)
+			s.bodies[r.Method+" "+p] = body
+		}
+	}
+
+	switch {
+	case r.Method == "PUT" && p == testRG+"disks/disk2":
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == "GET" && p == testRG+"disks/disk2":
+		s.polls++
+		state := "Updating"
+		if s.polls >= 2 {
+			state = "Succeeded"
+		}
+		fmt.Fprintf(w, `{"id":"%vdisks/disk2","name":"disk2","location":"westus2","properties":{"diskSizeGB":2,"provisioningState":"%v"}}`, testRG, state)
+	case r.Method == "GET" && p == testRG+"disks/disk1":
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":{"code":"ResourceNotFound","message":"not found"}}`)
+	case r.Method == "GET" && p == testRG+"virtualMachines/vm1":
+		fmt.Fprintf(w, `{"properties":{"provisioningState":"Succeeded","storageProfile":{"dataDisks":[`+
+			`{"lun":0,"createOption":"Attach","managedDisk":{"id":"%[1]vdisks/disk0"}},`+
+			`{"lun":2,"createOption":"Attach","managedDisk":{"id":"%[1]vdisks/disk3"}}]}}}`, testRG)
+	case r.Method == "PATCH" && p == testRG+"virtualMachines/vm1":
+		w.WriteHeader(http.StatusOK)
+	case r.Method == "DELETE" && p == testRG+"snapshots/snap1":
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, `{"error":{"code":"OperationNotAllowed","message":"in use"}}`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"code":"BadRequest","message":"unexpected request"}}`)
+	}
+}
+
+func (s *TestSuite) newService(c *C) *azureService {
+	svc, err := newAzureService(http.DefaultClient, s.server.URL+"/metadata/", s.server.URL+"/arm/", "")
+	c.Assert(err, IsNil)
+	return svc
+}
+
+func (s *TestSuite) TestMetadata(c *C) {
+	svc := s.newService(c)
+	c.Assert(svc.SubscriptionID, Equals, "sub1")
+	c.Assert(svc.ResourceGroup, Equals, "rg1")
+	c.Assert(svc.VMName, Equals, "vm1")
+	c.Assert(svc.Location, Equals, "westus2")
+	c.Assert(svc.Zone, Equals, "1")
+
+	_, err := newAzureService(http.DefaultClient, s.server.URL+"/nowhere/", s.server.URL+"/arm/", "")
+	c.Assert(err, ErrorMatches, "Not running on an Azure VM.*")
+}
+
+func (s *TestSuite) TestDisk(c *C) {
+	svc := s.newService(c)
+	disk, err := svc.CreateDisk(&CreateDiskRequest{
+		ResourceGroup:    "rg1",
+		Name:             "disk2",
+		Size:             GB + 1,
+		DiskType:         "Premium_LRS",
+		SourceResourceID: testRG + "snapshots/snap1",
+		Tags:             map[string]string{TAG_VOLUME: "vol2"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(disk.ID, Equals, testRG+"disks/disk2")
+	c.Assert(s.polls, Equals, 3)
+	body := s.bodies["PUT "+testRG+"disks/disk2"]
+	c.Assert(body["location"], Equals, "westus2")
+	c.Assert(body["zones"], DeepEquals, []interface{}{"1"})
+	c.Assert(body["sku"], DeepEquals, map[string]interface{}{"name": "Premium_LRS"})
+	c.Assert(body["tags"], DeepEquals, map[string]interface{}{TAG_VOLUME: "vol2"})
+	properties := body["properties"].(map[string]interface{})
+	c.Assert(properties["diskSizeGB"], Equals, float64(2))
+	c.Assert(properties["creationData"], DeepEquals, map[string]interface{}{
+		"createOption":     "Copy",
+		"sourceResourceId": testRG + "snapshots/snap1",
+	})
+
+	_, err = svc.GetDisk(svc.resourceID("rg1", "disks", "disk1"))
+	c.Assert(IsNotFound(err), Equals, true)
+
+	err = svc.DeleteSnapshot(svc.resourceID("rg1", "snapshots", "snap1"))
+	c.Assert(err, ErrorMatches, "Azure Error: OperationNotAllowed in use")
+}
+
+func (s *TestSuite) TestDataDisks(c *C) {
+	svc := s.newService(c)
+	vm, err := svc.getVM()
+	c.Assert(err, IsNil)
+	disks := vm.Properties.StorageProfile.DataDisks
+	lun, err := freeLun(disks)
+	c.Assert(err, IsNil)
+	c.Assert(lun, Equals, 1)
+	c.Assert(findDataDisk(disks, strings.ToUpper(testRG)+"disks/disk3"), Equals, 1)
+	c.Assert(DevicePath(lun), Equals, "/dev/disk/azure/scsi1/lun1")
+
+	full := []dataDisk{}
+	for i := 0; i < MAX_LUN; i++ {
+		full = append(full, dataDisk{Lun: i})
+	}
+	_, err = freeLun(full)
+	c.Assert(err, ErrorMatches, "No free LUN.*")
+
+	err = svc.DetachDisk(testRG + "disks/disk0")
+	c.Assert(err, IsNil)
+	body := s.bodies["PATCH "+testRG+"virtualMachines/vm1"]
+	dataDisks := body["properties"].(map[string]interface{})["storageProfile"].(map[string]interface{})["dataDisks"].([]interface{})
+	c.Assert(dataDisks, HasLen, 1)
+	c.Assert(dataDisks[0].(map[string]interface{})["lun"], Equals, float64(2))
+}
+
+func (s *TestSuite) TestNames(c *C) {
+	name := generateName("My Volume/1", "snap")
+	c.Assert(name, Matches, "convoy-My-Volume-1-snap-[0-9a-f]{8}")
+	c.Assert(validName.MatchString(name), Equals, true)
+
+	name = generateName(strings.Repeat("a", 100))
+	c.Assert(len(name), Equals, MAX_NAME_LENGTH)
+	c.Assert(validName.MatchString(name), Equals, true)
+
+	c.Assert(checkVolumeType("Premium_LRS"), IsNil)
+	c.Assert(checkVolumeType("pd-ssd"), ErrorMatches, "Invalid volume type.*")
+}
+
+func (s *TestSuite) TestURL(c *C) {
+	url := encodeURL("rg1", "convoy-vol1-snap1-0123abcd")
+	c.Assert(url, Equals, "azuredisk://rg1/convoy-vol1-snap1-0123abcd")
+	resourceGroup, snapshot, err := decodeURL(url)
+	c.Assert(err, IsNil)
+	c.Assert(resourceGroup, Equals, "rg1")
+	c.Assert(snapshot, Equals, "convoy-vol1-snap1-0123abcd")
+
+	_, _, err = decodeURL("azuredisk://rg1/-invalid")
+	c.Assert(err, ErrorMatches, "Invalid Azure backup URL.*")
+	_, _, err = decodeURL("gce://proj/snap1")
+	c.Assert(err, ErrorMatches, "BUG: Why dispatch gce to azuredisk.*")
+}
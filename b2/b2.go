@@ -0,0 +1,170 @@
+package b2
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/objectstore"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "b2"})
+)
+
+type B2ObjectStoreDriver struct {
+	destURL string
+	path    string
+	service B2Service
+}
+
+const (
+	KIND = "b2"
+
+	ENV_APPLICATION_KEY_ID = "B2_APPLICATION_KEY_ID"
+	ENV_APPLICATION_KEY    = "B2_APPLICATION_KEY"
+)
+
+func init() {
+	if err := objectstore.RegisterDriver(KIND, initFunc); err != nil {
+		panic(err)
+	}
+}
+
+func initFunc(destURL, endpoint string) (objectstore.ObjectStoreDriver, error) {
+	return initFuncWithConnectionCheck(destURL, endpoint, func(d objectstore.ObjectStoreDriver) error {
+		_, err := d.List("")
+		return err
+	})
+}
+
+func initFuncWithConnectionCheck(destURL, endpoint string, connectionTest func(d objectstore.ObjectStoreDriver) error) (objectstore.ObjectStoreDriver, error) {
+	b := &B2ObjectStoreDriver{}
+
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != KIND {
+		return nil, fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, KIND)
+	}
+
+	b.service.Bucket = u.Host
+	if b.service.Bucket == "" {
+		return nil, fmt.Errorf("Invalid URL. Must be b2://bucket/path/")
+	}
+	b.path = strings.TrimLeft(u.Path, "/")
+
+	b.service.KeyID = os.Getenv(ENV_APPLICATION_KEY_ID)
+	b.service.Key = os.Getenv(ENV_APPLICATION_KEY)
+	if b.service.KeyID == "" || b.service.Key == "" {
+		return nil, fmt.Errorf("Cannot find B2 application key, %v and %v need to be set",
+			ENV_APPLICATION_KEY_ID, ENV_APPLICATION_KEY)
+	}
+
+	if err := connectionTest(b); err != nil {
+		return nil, err
+	}
+
+	b.destURL = KIND + "://" + b.service.Bucket + "/" + b.path
+	log.Debugf("Loaded driver for %v", b.destURL)
+	return b, nil
+}
+
+func (s *B2ObjectStoreDriver) Kind() string {
+	return KIND
+}
+
+func (s *B2ObjectStoreDriver) GetURL() string {
+	return s.destURL
+}
+
+func (s *B2ObjectStoreDriver) updatePath(path string) string {
+	return filepath.Join(s.path, path)
+}
+
+func (s *B2ObjectStoreDriver) List(listPath string) ([]string, error) {
+	var result []string
+
+	path := s.updatePath(listPath) + "/"
+	if path == "/" {
+		path = ""
+	}
+	files, err := s.service.ListFileNames(path, "/")
+	if err != nil {
+		log.Error("Fail to list b2: ", err)
+		return result, err
+	}
+
+	for _, f := range files {
+		r := strings.TrimPrefix(strings.TrimSuffix(f.FileName, "/"), path)
+		if r != "" {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+func (s *B2ObjectStoreDriver) FileExists(filePath string) bool {
+	return s.FileSize(filePath) >= 0
+}
+
+func (s *B2ObjectStoreDriver) FileSize(filePath string) int64 {
+	size, err := s.service.GetFileSize(s.updatePath(filePath))
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+func (s *B2ObjectStoreDriver) Remove(names ...string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = s.updatePath(name)
+	}
+	return s.service.DeleteFiles(paths)
+}
+
+func (s *B2ObjectStoreDriver) Read(src string) (io.ReadCloser, error) {
+	return s.service.GetFile(s.updatePath(src))
+}
+
+func (s *B2ObjectStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	return s.service.PutFile(s.updatePath(dst), rs)
+}
+
+func (s *B2ObjectStoreDriver) Upload(src, dst string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return s.service.PutFile(s.updatePath(dst), file)
+}
+
+func (s *B2ObjectStoreDriver) Download(src, dst string) error {
+	if _, err := os.Stat(dst); err != nil {
+		os.Remove(dst)
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rc, err := s.service.GetFile(s.updatePath(src))
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(f, rc)
+	return err
+}
@@ -0,0 +1,469 @@
+package b2
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	DEFAULT_AUTH_URL = "https://api.backblazeb2.com"
+
+	API_PREFIX = "/b2api/v2/"
+
+	UPLOAD_RETRY_COUNTS = 3
+
+	DEFAULT_PART_SIZE = 100 * 1024 * 1024
+)
+
+type B2Service struct {
+	AuthURL string
+	KeyID   string
+	Key     string
+	Bucket  string
+	// Files larger than PartSize would be uploaded as B2 large files. Use
+	// the recommended part size of the account if not specified
+	PartSize int64
+	bucketID string
+
+	accountID          string
+	authorizationToken string
+	apiURL             string
+	downloadURL        string
+
+	client *http.Client
+}
+
+type b2File struct {
+	FileID        string `json:"fileId"`
+	FileName      string `json:"fileName"`
+	ContentLength int64  `json:"contentLength"`
+	Action        string `json:"action"`
+}
+
+type b2Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *b2Error) Error() string {
+	return fmt.Sprintf("B2 Error: %v %v %v", e.Status, e.Code, e.Message)
+}
+
+func (s *B2Service) httpClient() *http.Client {
+	if s.client == nil {
+		s.client = &http.Client{}
+	}
+	return s.client
+}
+
+func parseB2Error(resp *http.Response) error {
+	defer resp.Body.Close()
+	e := &b2Error{}
+	data, _ := ioutil.ReadAll(resp.Body)
+	if err := json.Unmarshal(data, e); err != nil || e.Code == "" {
+		return &b2Error{Status: resp.StatusCode, Message: string(data)}
+	}
+	return e
+}
+
+func isRetriable(err error) bool {
+	e, ok := err.(*b2Error)
+	if !ok {
+		// Network errors
+		return true
+	}
+	return e.Status == http.StatusUnauthorized || e.Status == http.StatusRequestTimeout ||
+		e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+func (s *B2Service) Authorize() error {
+	authURL := s.AuthURL
+	if authURL == "" {
+		authURL = DEFAULT_AUTH_URL
+	}
+	req, err := http.NewRequest("GET", strings.TrimRight(authURL, "/")+API_PREFIX+"b2_authorize_account", nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.KeyID, s.Key)
+	resp, err := s.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return parseB2Error(resp)
+	}
+	defer resp.Body.Close()
+
+	result := struct {
+		AccountID           string `json:"accountId"`
+		AuthorizationToken  string `json:"authorizationToken"`
+		APIURL              string `json:"apiUrl"`
+		DownloadURL         string `json:"downloadUrl"`
+		RecommendedPartSize int64  `json:"recommendedPartSize"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	s.accountID = result.AccountID
+	s.authorizationToken = result.AuthorizationToken
+	s.apiURL = result.APIURL
+	s.downloadURL = result.DownloadURL
+	if s.PartSize == 0 {
+		s.PartSize = result.RecommendedPartSize
+	}
+	if s.PartSize <= 0 {
+		s.PartSize = DEFAULT_PART_SIZE
+	}
+
+	if s.bucketID == "" {
+		buckets := struct {
+			Buckets []struct {
+				BucketID string `json:"bucketId"`
+			} `json:"buckets"`
+		}{}
+		if err := s.call("b2_list_buckets", map[string]string{
+			"accountId":  s.accountID,
+			"bucketName": s.Bucket,
+		}, &buckets); err != nil {
+			return err
+		}
+		if len(buckets.Buckets) == 0 {
+			return fmt.Errorf("Cannot find B2 bucket %v", s.Bucket)
+		}
+		s.bucketID = buckets.Buckets[0].BucketID
+	}
+	return nil
+}
+
+func (s *B2Service) ensureAuthorized() error {
+	if s.authorizationToken != "" {
+		return nil
+	}
+	return s.Authorize()
+}
+
+// call invokes the B2 API, authorizes again in case the token has expired
+func (s *B2Service) call(api string, request, result interface{}) error {
+	if err := s.ensureAuthorized(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	for retry := 0; ; retry++ {
+		req, err := http.NewRequest("POST", s.apiURL+API_PREFIX+api, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", s.authorizationToken)
+		resp, err := s.httpClient().Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && retry == 0 {
+			resp.Body.Close()
+			if err := s.Authorize(); err != nil {
+				return err
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return parseB2Error(resp)
+		}
+		defer resp.Body.Close()
+		if result == nil {
+			return nil
+		}
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+}
+
+func (s *B2Service) ListFileNames(prefix, delimiter string) ([]b2File, error) {
+	var files []b2File
+	startFileName := ""
+	for {
+		request := map[string]interface{}{
+			"bucketId":     s.bucketID,
+			"prefix":       prefix,
+			"maxFileCount": 1000,
+		}
+		if delimiter != "" {
+			request["delimiter"] = delimiter
+		}
+		if startFileName != "" {
+			request["startFileName"] = startFileName
+		}
+		result := struct {
+			Files        []b2File `json:"files"`
+			NextFileName *string  `json:"nextFileName"`
+		}{}
+		if err := s.call("b2_list_file_names", request, &result); err != nil {
+			return nil, err
+		}
+		files = append(files, result.Files...)
+		if result.NextFileName == nil || *result.NextFileName == "" {
+			break
+		}
+		startFileName = *result.NextFileName
+	}
+	return files, nil
+}
+
+func (s *B2Service) listFileVersions(prefix string) ([]b2File, error) {
+	var files []b2File
+	startFileName, startFileID := "", ""
+	for {
+		request := map[string]interface{}{
+			"bucketId":     s.bucketID,
+			"prefix":       prefix,
+			"maxFileCount": 1000,
+		}
+		if startFileName != "" {
+			request["startFileName"] = startFileName
+			request["startFileId"] = startFileID
+		}
+		result := struct {
+			Files        []b2File `json:"files"`
+			NextFileName *string  `json:"nextFileName"`
+			NextFileID   *string  `json:"nextFileId"`
+		}{}
+		if err := s.call("b2_list_file_versions", request, &result); err != nil {
+			return nil, err
+		}
+		files = append(files, result.Files...)
+		if result.NextFileName == nil || *result.NextFileName == "" {
+			break
+		}
+		startFileName = *result.NextFileName
+		if result.NextFileID != nil {
+			startFileID = *result.NextFileID
+		}
+	}
+	return files, nil
+}
+
+func (s *B2Service) fileURL(key string) string {
+	return s.downloadURL + "/file/" + url.QueryEscape(s.Bucket) + "/" + escapeFileName(key)
+}
+
+func escapeFileName(key string) string {
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		parts[i] = strings.Replace(url.QueryEscape(p), "+", "%20", -1)
+	}
+	return strings.Join(parts, "/")
+}
+
+func (s *B2Service) download(method, key string) (*http.Response, error) {
+	if err := s.ensureAuthorized(); err != nil {
+		return nil, err
+	}
+	for retry := 0; ; retry++ {
+		req, err := http.NewRequest(method, s.fileURL(key), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", s.authorizationToken)
+		resp, err := s.httpClient().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && retry == 0 {
+			resp.Body.Close()
+			if err := s.Authorize(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			if method == "HEAD" {
+				resp.Body.Close()
+				return nil, &b2Error{Status: resp.StatusCode}
+			}
+			return nil, parseB2Error(resp)
+		}
+		return resp, nil
+	}
+}
+
+func (s *B2Service) GetFileSize(key string) (int64, error) {
+	resp, err := s.download("HEAD", key)
+	if err != nil {
+		return -1, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+func (s *B2Service) GetFile(key string) (io.ReadCloser, error) {
+	resp, err := s.download("GET", key)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *B2Service) upload(uploadURL, token string, headers map[string]string, data []byte) error {
+	req, err := http.NewRequest("POST", uploadURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	checksum := sha1.Sum(data)
+	req.ContentLength = int64(len(data))
+	req.Header.Set("Authorization", token)
+	req.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	req.Header.Set("X-Bz-Content-Sha1", hex.EncodeToString(checksum[:]))
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return parseB2Error(resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *B2Service) putSmallFile(key string, data []byte) error {
+	var err error
+	// Upload URL would be refreshed for each retry, as B2 suggested
+	for retry := 0; retry < UPLOAD_RETRY_COUNTS; retry++ {
+		target := struct {
+			UploadURL          string `json:"uploadUrl"`
+			AuthorizationToken string `json:"authorizationToken"`
+		}{}
+		if err = s.call("b2_get_upload_url", map[string]string{
+			"bucketId": s.bucketID,
+		}, &target); err != nil {
+			return err
+		}
+		err = s.upload(target.UploadURL, target.AuthorizationToken, map[string]string{
+			"X-Bz-File-Name": escapeFileName(key),
+			"Content-Type":   "b2/x-auto",
+		}, data)
+		if err == nil || !isRetriable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+func (s *B2Service) putPart(fileID string, partNumber int, data []byte) (string, error) {
+	var err error
+	checksum := sha1.Sum(data)
+	for retry := 0; retry < UPLOAD_RETRY_COUNTS; retry++ {
+		target := struct {
+			UploadURL          string `json:"uploadUrl"`
+			AuthorizationToken string `json:"authorizationToken"`
+		}{}
+		if err = s.call("b2_get_upload_part_url", map[string]string{
+			"fileId": fileID,
+		}, &target); err != nil {
+			return "", err
+		}
+		err = s.upload(target.UploadURL, target.AuthorizationToken, map[string]string{
+			"X-Bz-Part-Number": strconv.Itoa(partNumber),
+		}, data)
+		if err == nil {
+			return hex.EncodeToString(checksum[:]), nil
+		}
+		if !isRetriable(err) {
+			return "", err
+		}
+	}
+	return "", err
+}
+
+// PutFile uploads the content in one request if it's not larger than
+// PartSize, otherwise it would use B2 large file API
+func (s *B2Service) PutFile(key string, r io.Reader) error {
+	if err := s.ensureAuthorized(); err != nil {
+		return err
+	}
+	part := &bytes.Buffer{}
+	if _, err := io.CopyN(part, r, s.PartSize+1); err != nil && err != io.EOF {
+		return err
+	}
+	if int64(part.Len()) <= s.PartSize {
+		return s.putSmallFile(key, part.Bytes())
+	}
+
+	largeFile := b2File{}
+	if err := s.call("b2_start_large_file", map[string]string{
+		"bucketId":    s.bucketID,
+		"fileName":    key,
+		"contentType": "b2/x-auto",
+	}, &largeFile); err != nil {
+		return err
+	}
+	if err := s.putParts(largeFile.FileID, part, r); err != nil {
+		s.call("b2_cancel_large_file", map[string]string{"fileId": largeFile.FileID}, nil)
+		return err
+	}
+	return nil
+}
+
+func (s *B2Service) putParts(fileID string, part *bytes.Buffer, r io.Reader) error {
+	checksums := []string{}
+	data := make([]byte, s.PartSize)
+	// The extra byte read to decide it's a large file would be carried over
+	pending := part.Bytes()
+	for {
+		n := copy(data, pending)
+		pending = pending[n:]
+		if len(pending) == 0 && n < len(data) {
+			m, err := io.ReadFull(r, data[n:])
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return err
+			}
+			n += m
+		}
+		if n == 0 {
+			break
+		}
+		checksum, err := s.putPart(fileID, len(checksums)+1, data[:n])
+		if err != nil {
+			return err
+		}
+		checksums = append(checksums, checksum)
+	}
+	return s.call("b2_finish_large_file", map[string]interface{}{
+		"fileId":        fileID,
+		"partSha1Array": checksums,
+	}, nil)
+}
+
+// DeleteFiles removes all the versions of the files with the specified
+// prefixes
+func (s *B2Service) DeleteFiles(prefixes []string) error {
+	for _, prefix := range prefixes {
+		files, err := s.listFileVersions(prefix)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if err := s.call("b2_delete_file_version", map[string]string{
+				"fileName": f.FileName,
+				"fileId":   f.FileID,
+			}, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
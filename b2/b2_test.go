@@ -0,0 +1,141 @@
+package b2
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/rancher/convoy/objectstore"
+	"gopkg.in/check.v1"
+)
+
+func TestB2(t *testing.T) { check.TestingT(t) }
+
+type B2TestSuite struct {
+	server   *httptest.Server
+	auths    int
+	requests []string
+}
+
+var _ = check.Suite(&B2TestSuite{})
+
+func (s *B2TestSuite) SetUpTest(c *check.C) {
+	s.auths = 0
+	s.requests = nil
+	mux := http.NewServeMux()
+	mux.HandleFunc(API_PREFIX+"b2_authorize_account", func(w http.ResponseWriter, r *http.Request) {
+		id, key, _ := r.BasicAuth()
+		c.Check(id, check.Equals, "keyid")
+		c.Check(key, check.Equals, "key")
+		s.auths++
+		fmt.Fprintf(w, `{"accountId": "account", "authorizationToken": "token-%v",
+			"apiUrl": "%v", "downloadUrl": "%v", "recommendedPartSize": 100000000}`,
+			s.auths, s.server.URL, s.server.URL)
+	})
+	mux.HandleFunc(API_PREFIX+"b2_list_buckets", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"buckets": [{"bucketId": "bucketid"}]}`)
+	})
+	mux.HandleFunc(API_PREFIX+"b2_list_file_names", func(w http.ResponseWriter, r *http.Request) {
+		// Only the second token is accepted, to test re-authorization
+		if r.Header.Get("Authorization") != "token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"status": 401, "code": "expired_auth_token", "message": "expired"}`)
+			return
+		}
+		request := map[string]interface{}{}
+		c.Assert(json.NewDecoder(r.Body).Decode(&request), check.IsNil)
+		if request["startFileName"] == nil {
+			fmt.Fprint(w, `{"files": [{"fileName": "path/volume.cfg", "action": "upload"}],
+				"nextFileName": "path/volume.cfg1"}`)
+			return
+		}
+		fmt.Fprint(w, `{"files": [{"fileName": "path/backups/", "action": "folder"}], "nextFileName": null}`)
+	})
+	mux.HandleFunc(API_PREFIX+"b2_get_upload_url", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"uploadUrl": "%v/upload", "authorizationToken": "upload-token"}`, s.server.URL)
+	})
+	mux.HandleFunc(API_PREFIX+"b2_start_large_file", func(w http.ResponseWriter, r *http.Request) {
+		s.requests = append(s.requests, "start")
+		fmt.Fprint(w, `{"fileId": "fileid"}`)
+	})
+	mux.HandleFunc(API_PREFIX+"b2_get_upload_part_url", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"uploadUrl": "%v/upload", "authorizationToken": "upload-token"}`, s.server.URL)
+	})
+	mux.HandleFunc(API_PREFIX+"b2_finish_large_file", func(w http.ResponseWriter, r *http.Request) {
+		request := struct {
+			PartSha1Array []string `json:"partSha1Array"`
+		}{}
+		c.Assert(json.NewDecoder(r.Body).Decode(&request), check.IsNil)
+		s.requests = append(s.requests, fmt.Sprintf("finish:%v", len(request.PartSha1Array)))
+		fmt.Fprint(w, `{}`)
+	})
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("Authorization"), check.Equals, "upload-token")
+		body, _ := ioutil.ReadAll(r.Body)
+		c.Check(r.Header.Get("X-Bz-Content-Sha1"), check.Not(check.Equals), "")
+		s.requests = append(s.requests, fmt.Sprintf("%v%v:%v",
+			r.Header.Get("X-Bz-File-Name"), r.Header.Get("X-Bz-Part-Number"), len(body)))
+		fmt.Fprint(w, `{}`)
+	})
+	s.server = httptest.NewServer(mux)
+
+	os.Setenv(ENV_APPLICATION_KEY_ID, "keyid")
+	os.Setenv(ENV_APPLICATION_KEY, "key")
+}
+
+func (s *B2TestSuite) TearDownTest(c *check.C) {
+	s.server.Close()
+}
+
+func (s *B2TestSuite) TestInitFunc(c *check.C) {
+	driver, err := initFuncWithConnectionCheck("b2://backups//path", "", func(d objectstore.ObjectStoreDriver) error {
+		d.(*B2ObjectStoreDriver).service.AuthURL = s.server.URL
+		_, err := d.List("")
+		return err
+	})
+	c.Assert(err, check.IsNil)
+	c.Check(driver.GetURL(), check.Equals, "b2://backups/path")
+
+	d := driver.(*B2ObjectStoreDriver)
+	c.Check(d.service.bucketID, check.Equals, "bucketid")
+	c.Check(d.service.authorizationToken, check.Equals, "token-2")
+	c.Check(d.service.PartSize, check.Equals, int64(100000000))
+
+	names, err := driver.List("")
+	c.Assert(err, check.IsNil)
+	c.Check(names, check.DeepEquals, []string{"volume.cfg", "backups"})
+}
+
+func (s *B2TestSuite) TestInitFuncBadConfig(c *check.C) {
+	_, err := initFuncWithConnectionCheck("b2:///path", "", func(d objectstore.ObjectStoreDriver) error {
+		return nil
+	})
+	c.Check(err, check.NotNil)
+
+	os.Unsetenv(ENV_APPLICATION_KEY)
+	_, err = initFuncWithConnectionCheck("b2://backups/path", "", func(d objectstore.ObjectStoreDriver) error {
+		return nil
+	})
+	c.Check(err, check.NotNil)
+}
+
+func (s *B2TestSuite) TestPutLargeFile(c *check.C) {
+	service := &B2Service{
+		AuthURL:  s.server.URL,
+		KeyID:    "keyid",
+		Key:      "key",
+		Bucket:   "backups",
+		PartSize: 10,
+	}
+	c.Assert(service.PutFile("path/file", bytes.NewReader(make([]byte, 25))), check.IsNil)
+	c.Check(s.requests, check.DeepEquals, []string{"start", "1:10", "2:10", "3:5", "finish:3"})
+
+	s.requests = nil
+	c.Assert(service.PutFile("path/small file", bytes.NewReader(make([]byte, 10))), check.IsNil)
+	c.Check(s.requests, check.DeepEquals, []string{"path/small%20file:10"})
+}
@@ -0,0 +1,768 @@
+package cinder
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	DRIVER_NAME        = "cinder"
+	DRIVER_CONFIG_FILE = "cinder.cfg"
+
+	VOLUME_CFG_PREFIX = "volume_"
+	CFG_PREFIX        = DRIVER_NAME + "_"
+	CFG_POSTFIX       = ".json"
+
+	CINDER_DEFAULT_VOLUME_SIZE = "cinder.defaultvolumesize"
+	CINDER_DEFAULT_VOLUME_TYPE = "cinder.defaultvolumetype"
+	CINDER_FSFREEZE            = "cinder.fsfreeze"
+
+	DEFAULT_VOLUME_SIZE = "10G"
+	DEFAULT_FSFREEZE    = "false"
+
+	METADATA_VOLUME   = "convoy-volume"
+	METADATA_SNAPSHOT = "convoy-snapshot"
+
+	MOUNTS_DIR = "mounts"
+)
+
+type Driver struct {
+	mutex         *sync.RWMutex
+	cinderService *cinderService
+	Device
+}
+
+type Device struct {
+	Root              string
+	DefaultVolumeSize int64
+	DefaultVolumeType string
+	FsFreeze          bool
+}
+
+func (dev *Device) ConfigFile() (string, error) {
+	if dev.Root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty device config path")
+	}
+	return filepath.Join(dev.Root, DRIVER_CONFIG_FILE), nil
+}
+
+type VolumeSnapshot struct {
+	Name       string
+	VolumeName string
+	CinderID   string
+	SnapshotMetadata
+}
+
+type Volume struct {
+	Name       string
+	CinderID   string
+	Device     string
+	MountPoint string
+	Snapshots  map[string]VolumeSnapshot
+
+	configPath string
+}
+
+func (d *Driver) blankVolume(name string) *Volume {
+	return &Volume{
+		configPath: d.Root,
+		Name:       name,
+	}
+}
+
+func (v *Volume) ConfigFile() (string, error) {
+	if v.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if v.configPath == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume config path")
+	}
+	return filepath.Join(v.configPath, CFG_PREFIX+VOLUME_CFG_PREFIX+v.Name+CFG_POSTFIX), nil
+}
+
+func (v *Volume) GetDevice() (string, error) {
+	return v.Device, nil
+}
+
+func (v *Volume) GetMountOpts() []string {
+	return []string{}
+}
+
+func (v *Volume) GenerateDefaultMountPoint() string {
+	return filepath.Join(v.configPath, MOUNTS_DIR, v.Name)
+}
+
+func init() {
+	if err := Register(DRIVER_NAME, Init); err != nil {
+		panic(err)
+	}
+}
+
+func generateError(fields logrus.Fields, format string, v ...interface{}) error {
+	return ErrorWithFields("cinder", fields, format, v...)
+}
+
+func (d *Driver) remountVolumes() error {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return err
+	}
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return err
+		}
+		if volume.MountPoint == "" {
+			continue
+		}
+		req := Request{
+			Name:    id,
+			Options: map[string]string{},
+		}
+		if _, err := d.MountVolume(req); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func Init(root string, config map[string]string) (ConvoyDriver, error) {
+	cinderService, err := NewCinderService()
+	if err != nil {
+		return nil, err
+	}
+	dev := &Device{
+		Root: root,
+	}
+	exists, err := util.ObjectExists(dev)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if err := util.ObjectLoad(dev); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := util.MkdirIfNotExists(root); err != nil {
+			return nil, err
+		}
+
+		if config[CINDER_DEFAULT_VOLUME_SIZE] == "" {
+			config[CINDER_DEFAULT_VOLUME_SIZE] = DEFAULT_VOLUME_SIZE
+		}
+		size, err := util.ParseSize(config[CINDER_DEFAULT_VOLUME_SIZE])
+		if err != nil {
+			return nil, err
+		}
+		if config[CINDER_FSFREEZE] == "" {
+			config[CINDER_FSFREEZE] = DEFAULT_FSFREEZE
+		}
+		fsFreeze, err := strconv.ParseBool(config[CINDER_FSFREEZE])
+		if err != nil {
+			return nil, err
+		}
+
+		dev = &Device{
+			Root:              root,
+			DefaultVolumeSize: size,
+			// Empty volume type means the default type of the cloud
+			DefaultVolumeType: config[CINDER_DEFAULT_VOLUME_TYPE],
+			FsFreeze:          fsFreeze,
+		}
+		if err := util.ObjectSave(dev); err != nil {
+			return nil, err
+		}
+	}
+	d := &Driver{
+		mutex:         &sync.RWMutex{},
+		cinderService: cinderService,
+		Device:        *dev,
+	}
+	if err := d.remountVolumes(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Driver) Name() string {
+	return DRIVER_NAME
+}
+
+func (d *Driver) Info() (map[string]string, error) {
+	return map[string]string{
+		"DefaultVolumeSize": strconv.FormatInt(d.DefaultVolumeSize, 10),
+		"DefaultVolumeType": d.DefaultVolumeType,
+		"FsFreeze":          strconv.FormatBool(d.FsFreeze),
+		"Region":            d.cinderService.Region,
+		"AvailabilityZone":  d.cinderService.AvailabilityZone,
+		"ServerID":          d.cinderService.ServerID,
+	}, nil
+}
+
+func (d *Driver) VolumeOps() (VolumeOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) getSize(opts map[string]string, defaultVolumeSize int64) (int64, error) {
+	size := opts[OPT_SIZE]
+	if size == "" || size == "0" {
+		size = strconv.FormatInt(defaultVolumeSize, 10)
+	}
+	return util.ParseSize(size)
+}
+
+func (d *Driver) getType(opts map[string]string) string {
+	if opts[OPT_VOLUME_TYPE] != "" {
+		return opts[OPT_VOLUME_TYPE]
+	}
+	return d.DefaultVolumeType
+}
+
+func (d *Driver) CreateVolume(req Request) error {
+	var (
+		err    error
+		format bool
+	)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	opts := req.Options
+
+	volume := d.blankVolume(id)
+	exists, err := util.ObjectExists(volume)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Volume %v already exists", id)
+	}
+
+	// Cinder volume ID
+	cinderID := opts[OPT_VOLUME_DRIVER_ID]
+	backupURL := opts[OPT_BACKUP_URL]
+	if backupURL != "" && cinderID != "" {
+		return fmt.Errorf("Cannot specify both backup and Cinder volume ID")
+	}
+
+	metadata := map[string]string{
+		METADATA_VOLUME: id,
+	}
+	if cinderID != "" {
+		cinderVolume, err := d.cinderService.GetVolume(cinderID)
+		if err != nil {
+			return err
+		}
+		if cinderVolume.Status != "available" {
+			return fmt.Errorf("Cinder volume %v is %v, must be available", cinderID, cinderVolume.Status)
+		}
+		log.Debugf("Found Cinder volume %v for volume %v, update metadata", cinderID, id)
+		if err := d.cinderService.UpdateVolumeMetadata(cinderID, metadata); err != nil {
+			log.Debugf("Failed to update metadata for volume %v, but continue", cinderID)
+		}
+	} else {
+		r := &CreateVolumeRequest{
+			Name:       "convoy-" + id,
+			VolumeType: d.getType(opts),
+			Metadata:   metadata,
+		}
+		var backup *CinderBackup
+		if backupURL != "" {
+			if backup, err = d.getBackup(backupURL); err != nil {
+				return err
+			}
+			if backup.Status != "available" {
+				return fmt.Errorf("Backup %v is not available, status %v", backup.ID, backup.Status)
+			}
+			backupVolumeSize := backup.Size * GB
+			if r.Size, err = d.getSize(opts, backupVolumeSize); err != nil {
+				return err
+			}
+			if r.Size < backupVolumeSize {
+				return fmt.Errorf("Volume size cannot be less than backup size %v", backupVolumeSize)
+			}
+		} else {
+			if r.Size, err = d.getSize(opts, d.DefaultVolumeSize); err != nil {
+				return err
+			}
+			format = true
+		}
+		cinderVolume, err := d.cinderService.CreateVolume(r)
+		if err != nil {
+			return err
+		}
+		cinderID = cinderVolume.ID
+		log.Debugf("Created Cinder volume %v for volume %v", cinderID, id)
+
+		if backup != nil {
+			if err := d.cinderService.RestoreBackup(backup.ID, cinderID); err != nil {
+				return err
+			}
+			log.Debugf("Restored backup %v to Cinder volume %v", backup.ID, cinderID)
+		}
+	}
+
+	dev, err := d.cinderService.AttachVolume(cinderID)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Attached Cinder volume %v to %v", cinderID, dev)
+
+	volume.Name = id
+	volume.CinderID = cinderID
+	volume.Device = dev
+	volume.Snapshots = make(map[string]VolumeSnapshot)
+
+	// We don't format existing or backup restored volume
+	if format {
+		if _, err := util.Execute("mkfs", []string{"-t", "ext4", dev}); err != nil {
+			return err
+		}
+	}
+
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) DeleteVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	opts := req.Options
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	referenceOnly, _ := strconv.ParseBool(opts[OPT_REFERENCE_ONLY])
+	if err := d.cinderService.DetachVolume(volume.CinderID); err != nil {
+		if !referenceOnly {
+			return err
+		}
+		//Ignore the error, remove the reference
+		log.Warnf("Unable to detached %v(%v) due to %v, but continue with removing the reference",
+			id, volume.CinderID, err)
+	} else {
+		log.Debugf("Detached %v(%v) from %v", id, volume.CinderID, volume.Device)
+	}
+
+	if !referenceOnly {
+		// Cinder won't delete a volume with snapshots
+		for snapshotID, snapshot := range volume.Snapshots {
+			if err := d.cinderService.DeleteSnapshot(snapshot.CinderID); err != nil && !IsNotFound(err) {
+				return err
+			}
+			log.Debugf("Deleted snapshot %v(%v) of %v", snapshotID, snapshot.CinderID, id)
+		}
+		if err := d.cinderService.DeleteVolume(volume.CinderID); err != nil {
+			return err
+		}
+		log.Debugf("Deleted %v(%v)", id, volume.CinderID)
+	}
+	return util.ObjectDelete(volume)
+}
+
+func (d *Driver) MountVolume(req Request) (string, error) {
+	id := req.Name
+	opts := req.Options
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+
+	mountPoint, err := util.VolumeMount(volume, opts[OPT_MOUNT_POINT], false)
+	if err != nil {
+		return "", err
+	}
+
+	if err := util.ObjectSave(volume); err != nil {
+		return "", err
+	}
+
+	return mountPoint, nil
+}
+
+func (d *Driver) UmountVolume(req Request) error {
+	id := req.Name
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	if err := util.VolumeUmount(volume); err != nil {
+		return err
+	}
+
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) MountPoint(req Request) (string, error) {
+	id := req.Name
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	return volume.MountPoint, nil
+}
+
+func (d *Driver) GetVolumeInfo(id string) (map[string]string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+
+	cinderVolume, err := d.cinderService.GetVolume(volume.CinderID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"Device":                volume.Device,
+		"MountPoint":            volume.MountPoint,
+		"CinderVolumeID":        volume.CinderID,
+		"CinderVolumeName":      cinderVolume.Name,
+		"AvailabilityZone":      cinderVolume.AvailabilityZone,
+		OPT_VOLUME_NAME:         id,
+		OPT_VOLUME_CREATED_TIME: cinderVolume.CreatedAt,
+		"Size":                  strconv.FormatInt(cinderVolume.Size*GB, 10),
+		"State":                 cinderVolume.Status,
+		"Type":                  cinderVolume.VolumeType,
+	}, nil
+}
+
+func (d *Driver) listVolumeNames() ([]string, error) {
+	return util.ListConfigIDs(d.Root, CFG_PREFIX+VOLUME_CFG_PREFIX, CFG_POSTFIX)
+}
+
+func (d *Driver) ListVolume(opts map[string]string) (map[string]map[string]string, error) {
+	volumes := make(map[string]map[string]string)
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, uuid := range volumeIDs {
+		volumes[uuid], err = d.GetVolumeInfo(uuid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return volumes, nil
+}
+
+func (d *Driver) SnapshotOps() (SnapshotOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) getSnapshotAndVolume(snapshotID, volumeID string) (*VolumeSnapshot, *Volume, error) {
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, nil, err
+	}
+	snap, exists := volume.Snapshots[snapshotID]
+	if !exists {
+		return nil, nil, generateError(logrus.Fields{
+			LOG_FIELD_VOLUME:   volumeID,
+			LOG_FIELD_SNAPSHOT: snapshotID,
+		}, "cannot find snapshot of volume")
+	}
+	return &snap, volume, nil
+}
+
+func (d *Driver) CreateSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if _, exists := volume.Snapshots[id]; exists {
+		return generateError(logrus.Fields{
+			LOG_FIELD_VOLUME:   volumeID,
+			LOG_FIELD_SNAPSHOT: id,
+		}, "Already has snapshot with uuid")
+	}
+
+	if volume.MountPoint != "" {
+		log.Debugf("syncing filesystems...")
+		if err := util.Sync(); err != nil {
+			return err
+		}
+
+		if d.FsFreeze {
+			log.Debugf("freezing %v", volume.MountPoint)
+			if err := util.Freeze(volume.MountPoint); err != nil {
+				return err
+			}
+			defer func() {
+				log.Debugf("unfreezing %v", volume.MountPoint)
+				if err := util.UnFreeze(volume.MountPoint); err != nil {
+					log.Errorf("Failed to unfreeze %v: %v", volume.MountPoint, err)
+				}
+			}()
+		}
+	}
+
+	request := &CreateSnapshotRequest{
+		VolumeID: volume.CinderID,
+		Name:     "convoy-" + volumeID + "-" + id,
+		Metadata: map[string]string{
+			METADATA_VOLUME:   volumeID,
+			METADATA_SNAPSHOT: id,
+		},
+	}
+	snapshot, err := d.cinderService.CreateSnapshot(request)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Created snapshot %v(%v) of volume %v(%v)", id, snapshot.ID, volumeID, volume.CinderID)
+
+	volume.Snapshots[id] = VolumeSnapshot{
+		Name:             id,
+		VolumeName:       volumeID,
+		CinderID:         snapshot.ID,
+		SnapshotMetadata: NewSnapshotMetadata(req.Options),
+	}
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) DeleteSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return err
+	}
+
+	// Backups are independent of the snapshot in Cinder, so the snapshot
+	// can be removed after backed up
+	if err := d.cinderService.DeleteSnapshot(snapshot.CinderID); err != nil && !IsNotFound(err) {
+		return err
+	}
+	log.Debugf("Deleted snapshot %v(%v) of volume %v(%v)", id, snapshot.CinderID, volumeID, volume.CinderID)
+	delete(volume.Snapshots, id)
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) GetSnapshotInfo(req Request) (map[string]string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.getSnapshotInfo(id, volumeID)
+}
+
+func (d *Driver) getSnapshotInfo(id, volumeID string) (map[string]string, error) {
+	snapshot, _, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return nil, err
+	}
+
+	cinderSnapshot, err := d.cinderService.GetSnapshot(snapshot.CinderID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		OPT_SNAPSHOT_NAME:         snapshot.Name,
+		OPT_SNAPSHOT_LABELS:       snapshot.Labels,
+		OPT_SNAPSHOT_DESCRIPTION:  snapshot.Description,
+		"VolumeName":              volumeID,
+		"CinderSnapshotID":        cinderSnapshot.ID,
+		"CinderVolumeID":          cinderSnapshot.VolumeID,
+		OPT_SNAPSHOT_CREATED_TIME: cinderSnapshot.CreatedAt,
+		OPT_SIZE:                  strconv.FormatInt(cinderSnapshot.Size*GB, 10),
+		"State":                   cinderSnapshot.Status,
+	}, nil
+}
+
+func (d *Driver) ListSnapshot(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var (
+		volumeIDs []string
+		err       error
+	)
+	snapshots := make(map[string]map[string]string)
+	specifiedVolumeID, _ := util.GetFieldFromOpts(OPT_VOLUME_NAME, opts)
+	if specifiedVolumeID != "" {
+		volumeIDs = []string{
+			specifiedVolumeID,
+		}
+	} else {
+		volumeIDs, err = d.listVolumeNames()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, volumeID := range volumeIDs {
+		volume := d.blankVolume(volumeID)
+		if err := util.ObjectLoad(volume); err != nil {
+			return nil, err
+		}
+		for snapshotID := range volume.Snapshots {
+			snapshots[snapshotID], err = d.getSnapshotInfo(snapshotID, volumeID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return snapshots, nil
+}
+
+func (d *Driver) BackupOps() (BackupOperations, error) {
+	return d, nil
+}
+
+func encodeURL(region, backupID string) string {
+	return DRIVER_NAME + "://" + region + "/" + backupID
+}
+
+func decodeURL(backupURL string) (string, string, error) {
+	u, err := url.Parse(backupURL)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != DRIVER_NAME {
+		return "", "", fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, DRIVER_NAME)
+	}
+
+	region := u.Host
+	backupID := strings.Trim(u.Path, "/")
+	if region == "" || backupID == "" || strings.Contains(backupID, "/") {
+		return "", "", fmt.Errorf("Invalid Cinder backup URL %v, must be cinder://<region>/<backup id>", backupURL)
+	}
+	return region, backupID, nil
+}
+
+func (d *Driver) getBackup(backupURL string) (*CinderBackup, error) {
+	region, backupID, err := decodeURL(backupURL)
+	if err != nil {
+		return nil, err
+	}
+	if region != d.cinderService.Region {
+		return nil, fmt.Errorf("Backup %v is in region %v, current region is %v", backupID, region, d.cinderService.Region)
+	}
+	return d.cinderService.GetBackup(backupID)
+}
+
+func (d *Driver) CreateBackup(snapshotID, volumeID, destURL, endpointURL string, opts map[string]string) (string, error) {
+	//destURL is not necessary in Cinder case, backups go to the backup
+	//service configured in Cinder
+	snapshot, volume, err := d.getSnapshotAndVolume(snapshotID, volumeID)
+	if err != nil {
+		return "", err
+	}
+
+	request := &CreateBackupRequest{
+		VolumeID:   volume.CinderID,
+		SnapshotID: snapshot.CinderID,
+		Name:       "convoy-" + volumeID + "-" + snapshotID,
+		Metadata: map[string]string{
+			METADATA_VOLUME:   volumeID,
+			METADATA_SNAPSHOT: snapshotID,
+		},
+	}
+	backup, err := d.cinderService.CreateBackup(request)
+	if err != nil {
+		return "", err
+	}
+	log.Debugf("Created backup %v of snapshot %v(%v)", backup.ID, snapshotID, snapshot.CinderID)
+	return encodeURL(d.cinderService.Region, backup.ID), nil
+}
+
+func (d *Driver) DeleteBackup(backupURL, endpointURL string, opts map[string]string) error {
+	backup, err := d.getBackup(backupURL)
+	if err != nil {
+		return err
+	}
+	return d.cinderService.DeleteBackup(backup.ID)
+}
+
+func backupInfo(region string, backup *CinderBackup) map[string]string {
+	return map[string]string{
+		"BackupName":        backup.Name,
+		"BackupURL":         encodeURL(region, backup.ID),
+		"CreatedTime":       backup.CreatedAt,
+		"DriverName":        DRIVER_NAME,
+		"CinderBackupID":    backup.ID,
+		"CinderVolumeID":    backup.VolumeID,
+		"CinderSnapshotID":  backup.SnapshotID,
+		"VolumeName":        backup.Metadata[METADATA_VOLUME],
+		"SnapshotName":      backup.Metadata[METADATA_SNAPSHOT],
+		"SnapshotCreatedAt": "",
+		"VolumeCreatedAt":   "",
+		"VolumeSize":        strconv.FormatInt(backup.Size*GB, 10),
+		"Incremental":       strconv.FormatBool(backup.IsIncremental),
+		"State":             backup.Status,
+	}
+}
+
+func (d *Driver) GetBackupInfo(backupURL, endpointURL string) (map[string]string, error) {
+	backup, err := d.getBackup(backupURL)
+	if err != nil {
+		return nil, err
+	}
+	return backupInfo(d.cinderService.Region, backup), nil
+}
+
+func (d *Driver) ListBackup(destURL, endpointURL string, opts map[string]string) (map[string]map[string]string, error) {
+	// Only the backups created by Convoy would be listed, which are
+	// recognized by the metadata
+	backups, err := d.cinderService.ListBackups()
+	if err != nil {
+		return nil, err
+	}
+
+	specifiedVolumeID, _ := util.GetFieldFromOpts(OPT_VOLUME_NAME, opts)
+	result := make(map[string]map[string]string)
+	for i := range backups {
+		volumeName := backups[i].Metadata[METADATA_VOLUME]
+		if volumeName == "" {
+			continue
+		}
+		if specifiedVolumeID != "" && volumeName != specifiedVolumeID {
+			continue
+		}
+		info := backupInfo(d.cinderService.Region, &backups[i])
+		result[info["BackupURL"]] = info
+	}
+	return result, nil
+}
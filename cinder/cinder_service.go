@@ -0,0 +1,664 @@
+package cinder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	GB = 1073741824
+
+	METADATA_URL = "http://169.254.169.254/"
+
+	// Backup metadata requires Cinder API microversion 3.43
+	BACKUP_MICROVERSION = "volume 3.43"
+
+	DEVICE_FOLDER = "/dev/disk/by-id"
+
+	OPERATION_TIMEOUT = 10 * time.Minute
+	BACKUP_TIMEOUT    = 2 * time.Hour
+	DEVICE_TIMEOUT    = 2 * time.Minute
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "cinder"})
+
+	// RetryInterval is the interval of polling resource status and devices
+	RetryInterval = 3 * time.Second
+)
+
+// Credential is the Keystone v3 password credential, loaded from the
+// standard OS_* environment variables
+type Credential struct {
+	AuthURL           string
+	Username          string
+	UserID            string
+	Password          string
+	UserDomainName    string
+	ProjectName       string
+	ProjectID         string
+	ProjectDomainName string
+	RegionName        string
+	Interface         string
+}
+
+func getEnv(key, defaultValue string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return defaultValue
+}
+
+func CredentialFromEnv() (*Credential, error) {
+	cred := &Credential{
+		AuthURL:           os.Getenv("OS_AUTH_URL"),
+		Username:          os.Getenv("OS_USERNAME"),
+		UserID:            os.Getenv("OS_USER_ID"),
+		Password:          os.Getenv("OS_PASSWORD"),
+		UserDomainName:    getEnv("OS_USER_DOMAIN_NAME", "Default"),
+		ProjectName:       os.Getenv("OS_PROJECT_NAME"),
+		ProjectID:         os.Getenv("OS_PROJECT_ID"),
+		ProjectDomainName: getEnv("OS_PROJECT_DOMAIN_NAME", "Default"),
+		RegionName:        os.Getenv("OS_REGION_NAME"),
+		Interface:         getEnv("OS_INTERFACE", "public"),
+	}
+	if cred.AuthURL == "" || cred.Password == "" {
+		return nil, fmt.Errorf("OS_AUTH_URL and OS_PASSWORD must be set")
+	}
+	if cred.Username == "" && cred.UserID == "" {
+		return nil, fmt.Errorf("Either OS_USERNAME or OS_USER_ID must be set")
+	}
+	if cred.ProjectName == "" && cred.ProjectID == "" {
+		return nil, fmt.Errorf("Either OS_PROJECT_NAME or OS_PROJECT_ID must be set")
+	}
+	return cred, nil
+}
+
+type cinderService struct {
+	client      *http.Client
+	metadataURL string
+	credential  *Credential
+
+	mutex       *sync.Mutex
+	token       string
+	tokenExpiry time.Time
+	volumeURL   string
+	computeURL  string
+
+	Region           string
+	ServerID         string
+	AvailabilityZone string
+}
+
+type CinderVolume struct {
+	ID               string            `json:"id"`
+	Name             string            `json:"name"`
+	Size             int64             `json:"size"`
+	Status           string            `json:"status"`
+	VolumeType       string            `json:"volume_type"`
+	AvailabilityZone string            `json:"availability_zone"`
+	SnapshotID       string            `json:"snapshot_id"`
+	CreatedAt        string            `json:"created_at"`
+	Metadata         map[string]string `json:"metadata"`
+}
+
+type CinderSnapshot struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	VolumeID  string            `json:"volume_id"`
+	Size      int64             `json:"size"`
+	Status    string            `json:"status"`
+	CreatedAt string            `json:"created_at"`
+	Metadata  map[string]string `json:"metadata"`
+}
+
+type CinderBackup struct {
+	ID            string            `json:"id"`
+	Name          string            `json:"name"`
+	VolumeID      string            `json:"volume_id"`
+	SnapshotID    string            `json:"snapshot_id"`
+	Size          int64             `json:"size"`
+	Status        string            `json:"status"`
+	FailReason    string            `json:"fail_reason"`
+	IsIncremental bool              `json:"is_incremental"`
+	CreatedAt     string            `json:"created_at"`
+	Metadata      map[string]string `json:"metadata"`
+}
+
+type NotFoundError struct {
+	Resource string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("Cannot find %v", e.Resource)
+}
+
+func IsNotFound(err error) bool {
+	_, ok := err.(*NotFoundError)
+	return ok
+}
+
+// NewCinderService authenticates with Keystone using the credential in the
+// environment, and identifies the current server through the metadata service
+func NewCinderService() (*cinderService, error) {
+	cred, err := CredentialFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return newCinderService(&http.Client{Timeout: time.Minute}, METADATA_URL, cred)
+}
+
+func newCinderService(client *http.Client, metadataURL string, cred *Credential) (*cinderService, error) {
+	s := &cinderService{
+		client:      client,
+		metadataURL: metadataURL,
+		credential:  cred,
+		mutex:       &sync.Mutex{},
+	}
+	if err := s.loadMetadata(); err != nil {
+		return nil, fmt.Errorf("Not running on an OpenStack server: %v", err)
+	}
+	if _, err := s.getToken(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *cinderService) loadMetadata() error {
+	resp, err := s.client.Get(s.metadataURL + "openstack/latest/meta_data.json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to get metadata: %v", resp.Status)
+	}
+	metadata := struct {
+		UUID             string `json:"uuid"`
+		AvailabilityZone string `json:"availability_zone"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return err
+	}
+	if metadata.UUID == "" {
+		return fmt.Errorf("Empty server UUID in metadata")
+	}
+	s.ServerID = metadata.UUID
+	s.AvailabilityZone = metadata.AvailabilityZone
+	return nil
+}
+
+type catalogEntry struct {
+	Type      string `json:"type"`
+	Endpoints []struct {
+		Interface string `json:"interface"`
+		Region    string `json:"region"`
+		RegionID  string `json:"region_id"`
+		URL       string `json:"url"`
+	} `json:"endpoints"`
+}
+
+func (s *cinderService) findEndpoint(catalog []catalogEntry, types ...string) (string, string, error) {
+	for _, t := range types {
+		for _, entry := range catalog {
+			if entry.Type != t {
+				continue
+			}
+			for _, ep := range entry.Endpoints {
+				region := ep.RegionID
+				if region == "" {
+					region = ep.Region
+				}
+				if ep.Interface != s.credential.Interface {
+					continue
+				}
+				if s.credential.RegionName != "" && region != s.credential.RegionName {
+					continue
+				}
+				return strings.TrimRight(ep.URL, "/"), region, nil
+			}
+		}
+	}
+	return "", "", fmt.Errorf("Cannot find %v endpoint of %v in service catalog", s.credential.Interface, types)
+}
+
+// getToken returns the Keystone token scoped to the project, which would be
+// reissued before it expires. The endpoints are updated from the catalog of
+// the new token
+func (s *cinderService) getToken() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.token != "" && time.Now().Before(s.tokenExpiry) {
+		return s.token, nil
+	}
+
+	cred := s.credential
+	user := map[string]interface{}{
+		"password": cred.Password,
+	}
+	if cred.UserID != "" {
+		user["id"] = cred.UserID
+	} else {
+		user["name"] = cred.Username
+		user["domain"] = map[string]string{"name": cred.UserDomainName}
+	}
+	project := map[string]interface{}{}
+	if cred.ProjectID != "" {
+		project["id"] = cred.ProjectID
+	} else {
+		project["name"] = cred.ProjectName
+		project["domain"] = map[string]string{"name": cred.ProjectDomainName}
+	}
+	request := map[string]interface{}{
+		"auth": map[string]interface{}{
+			"identity": map[string]interface{}{
+				"methods":  []string{"password"},
+				"password": map[string]interface{}{"user": user},
+			},
+			"scope": map[string]interface{}{"project": project},
+		},
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.client.Post(strings.TrimRight(cred.AuthURL, "/")+"/auth/tokens",
+		"application/json", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("Failed to authenticate with Keystone: %v", resp.Status)
+	}
+	token := struct {
+		Token struct {
+			ExpiresAt time.Time      `json:"expires_at"`
+			Catalog   []catalogEntry `json:"catalog"`
+		} `json:"token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+
+	volumeURL, region, err := s.findEndpoint(token.Token.Catalog, "block-storage", "volumev3")
+	if err != nil {
+		return "", err
+	}
+	computeURL, _, err := s.findEndpoint(token.Token.Catalog, "compute")
+	if err != nil {
+		return "", err
+	}
+	s.volumeURL = volumeURL
+	s.computeURL = computeURL
+	s.Region = region
+	s.token = resp.Header.Get("X-Subject-Token")
+	s.tokenExpiry = token.Token.ExpiresAt.Add(-time.Minute)
+	return s.token, nil
+}
+
+func (s *cinderService) do(method string, compute bool, path string, headers map[string]string, in, out interface{}) error {
+	token, err := s.getToken()
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	baseURL := s.volumeURL
+	if compute {
+		baseURL = s.computeURL
+	}
+	req, err := http.NewRequest(method, baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Auth-Token", token)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return &NotFoundError{Resource: path}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// The error is wrapped by its type, e.g. {"badRequest": {...}}
+		apiErr := map[string]struct {
+			Message string `json:"message"`
+		}{}
+		if err := json.Unmarshal(data, &apiErr); err == nil {
+			for k, v := range apiErr {
+				if v.Message != "" {
+					return fmt.Errorf("OpenStack Error: %v %v", k, v.Message)
+				}
+			}
+		}
+		return fmt.Errorf("OpenStack Error: %v %v %v", method, path, resp.Status)
+	}
+	if out != nil && len(data) != 0 {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+// waitForStatus polls the resource by get until its status becomes one of
+// the expected, or it's gone if no status is expected
+func waitForStatus(resource string, timeout time.Duration, get func() (string, error), expected ...string) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := get()
+		if len(expected) == 0 && IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, e := range expected {
+			if status == e {
+				return nil
+			}
+		}
+		if strings.HasPrefix(status, "error") {
+			return fmt.Errorf("%v is in %v status", resource, status)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timed out waiting for %v, status %v", resource, status)
+		}
+		log.Debugf("Waiting for %v, status %v", resource, status)
+		time.Sleep(RetryInterval)
+	}
+}
+
+func (s *cinderService) waitForVolume(id string, expected ...string) error {
+	return waitForStatus("volume "+id, OPERATION_TIMEOUT, func() (string, error) {
+		volume, err := s.GetVolume(id)
+		if err != nil {
+			return "", err
+		}
+		return volume.Status, nil
+	}, expected...)
+}
+
+type CreateVolumeRequest struct {
+	Name       string
+	Size       int64
+	VolumeType string
+	SnapshotID string
+	Metadata   map[string]string
+}
+
+// CreateVolume creates the volume in the availability zone of the server
+func (s *cinderService) CreateVolume(request *CreateVolumeRequest) (*CinderVolume, error) {
+	volume := map[string]interface{}{
+		"name":     request.Name,
+		"size":     (request.Size + GB - 1) / GB,
+		"metadata": request.Metadata,
+	}
+	if s.AvailabilityZone != "" {
+		volume["availability_zone"] = s.AvailabilityZone
+	}
+	if request.VolumeType != "" {
+		volume["volume_type"] = request.VolumeType
+	}
+	if request.SnapshotID != "" {
+		volume["snapshot_id"] = request.SnapshotID
+	}
+	resp := struct {
+		Volume CinderVolume `json:"volume"`
+	}{}
+	if err := s.do("POST", false, "/volumes", nil, map[string]interface{}{"volume": volume}, &resp); err != nil {
+		return nil, err
+	}
+	if err := s.waitForVolume(resp.Volume.ID, "available"); err != nil {
+		return nil, err
+	}
+	return s.GetVolume(resp.Volume.ID)
+}
+
+func (s *cinderService) GetVolume(id string) (*CinderVolume, error) {
+	resp := struct {
+		Volume CinderVolume `json:"volume"`
+	}{}
+	if err := s.do("GET", false, "/volumes/"+id, nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Volume, nil
+}
+
+func (s *cinderService) DeleteVolume(id string) error {
+	if err := s.do("DELETE", false, "/volumes/"+id, nil, nil, nil); err != nil {
+		return err
+	}
+	return s.waitForVolume(id)
+}
+
+func (s *cinderService) UpdateVolumeMetadata(id string, metadata map[string]string) error {
+	return s.do("POST", false, "/volumes/"+id+"/metadata", nil,
+		map[string]interface{}{"metadata": metadata}, nil)
+}
+
+// DevicePaths returns the possible device links of the volume, for virtio-blk
+// and virtio-scsi. The serial of virtio-blk is truncated to 20 characters
+func DevicePaths(id string) []string {
+	serial := id
+	if len(serial) > 20 {
+		serial = serial[:20]
+	}
+	return []string{
+		filepath.Join(DEVICE_FOLDER, "virtio-"+serial),
+		filepath.Join(DEVICE_FOLDER, "scsi-0QEMU_QEMU_HARDDISK_"+id),
+		filepath.Join(DEVICE_FOLDER, "scsi-0QEMU_QEMU_HARDDISK_"+serial),
+	}
+}
+
+func waitForDevice(id string) (string, error) {
+	for start := time.Now(); time.Since(start) < DEVICE_TIMEOUT; time.Sleep(RetryInterval) {
+		for _, dev := range DevicePaths(id) {
+			if _, err := os.Stat(dev); err == nil {
+				return dev, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("Timed out waiting for device of volume %v", id)
+}
+
+// AttachVolume attaches the volume to the server through Nova, and waits
+// until the device shows up
+func (s *cinderService) AttachVolume(id string) (string, error) {
+	attachment := map[string]interface{}{
+		"volumeAttachment": map[string]string{"volumeId": id},
+	}
+	if err := s.do("POST", true, "/servers/"+s.ServerID+"/os-volume_attachments", nil, attachment, nil); err != nil {
+		return "", err
+	}
+	if err := s.waitForVolume(id, "in-use"); err != nil {
+		return "", err
+	}
+	return waitForDevice(id)
+}
+
+func (s *cinderService) DetachVolume(id string) error {
+	if err := s.do("DELETE", true, "/servers/"+s.ServerID+"/os-volume_attachments/"+id, nil, nil, nil); err != nil {
+		if !IsNotFound(err) {
+			return err
+		}
+		log.Debugf("Volume %v is not attached to server %v", id, s.ServerID)
+	}
+	return s.waitForVolume(id, "available")
+}
+
+type CreateSnapshotRequest struct {
+	VolumeID string
+	Name     string
+	Metadata map[string]string
+}
+
+func (s *cinderService) waitForSnapshot(id string, expected ...string) error {
+	return waitForStatus("snapshot "+id, OPERATION_TIMEOUT, func() (string, error) {
+		snapshot, err := s.GetSnapshot(id)
+		if err != nil {
+			return "", err
+		}
+		return snapshot.Status, nil
+	}, expected...)
+}
+
+// CreateSnapshot creates the snapshot of the volume, which would be in use
+func (s *cinderService) CreateSnapshot(request *CreateSnapshotRequest) (*CinderSnapshot, error) {
+	snapshot := map[string]interface{}{
+		"volume_id": request.VolumeID,
+		"name":      request.Name,
+		"force":     true,
+		"metadata":  request.Metadata,
+	}
+	resp := struct {
+		Snapshot CinderSnapshot `json:"snapshot"`
+	}{}
+	if err := s.do("POST", false, "/snapshots", nil, map[string]interface{}{"snapshot": snapshot}, &resp); err != nil {
+		return nil, err
+	}
+	if err := s.waitForSnapshot(resp.Snapshot.ID, "available"); err != nil {
+		return nil, err
+	}
+	return s.GetSnapshot(resp.Snapshot.ID)
+}
+
+func (s *cinderService) GetSnapshot(id string) (*CinderSnapshot, error) {
+	resp := struct {
+		Snapshot CinderSnapshot `json:"snapshot"`
+	}{}
+	if err := s.do("GET", false, "/snapshots/"+id, nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Snapshot, nil
+}
+
+func (s *cinderService) DeleteSnapshot(id string) error {
+	if err := s.do("DELETE", false, "/snapshots/"+id, nil, nil, nil); err != nil {
+		return err
+	}
+	return s.waitForSnapshot(id)
+}
+
+type CreateBackupRequest struct {
+	VolumeID   string
+	SnapshotID string
+	Name       string
+	Metadata   map[string]string
+}
+
+var backupHeaders = map[string]string{
+	"OpenStack-API-Version": BACKUP_MICROVERSION,
+}
+
+func (s *cinderService) waitForBackup(id string, expected ...string) error {
+	return waitForStatus("backup "+id, BACKUP_TIMEOUT, func() (string, error) {
+		backup, err := s.GetBackup(id)
+		if err != nil {
+			return "", err
+		}
+		if backup.Status == "error" && backup.FailReason != "" {
+			return "", fmt.Errorf("Backup %v failed: %v", id, backup.FailReason)
+		}
+		return backup.Status, nil
+	}, expected...)
+}
+
+// CreateBackup backs up the snapshot of the volume to the backup service
+// configured in Cinder, and waits until it's done
+func (s *cinderService) CreateBackup(request *CreateBackupRequest) (*CinderBackup, error) {
+	backup := map[string]interface{}{
+		"volume_id":   request.VolumeID,
+		"snapshot_id": request.SnapshotID,
+		"name":        request.Name,
+		"description": "Convoy backup",
+		"force":       true,
+		"metadata":    request.Metadata,
+	}
+	resp := struct {
+		Backup CinderBackup `json:"backup"`
+	}{}
+	if err := s.do("POST", false, "/backups", backupHeaders, map[string]interface{}{"backup": backup}, &resp); err != nil {
+		return nil, err
+	}
+	if err := s.waitForBackup(resp.Backup.ID, "available"); err != nil {
+		return nil, err
+	}
+	return s.GetBackup(resp.Backup.ID)
+}
+
+func (s *cinderService) GetBackup(id string) (*CinderBackup, error) {
+	resp := struct {
+		Backup CinderBackup `json:"backup"`
+	}{}
+	if err := s.do("GET", false, "/backups/"+id, backupHeaders, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Backup, nil
+}
+
+func (s *cinderService) ListBackups() ([]CinderBackup, error) {
+	resp := struct {
+		Backups []CinderBackup `json:"backups"`
+	}{}
+	if err := s.do("GET", false, "/backups/detail", backupHeaders, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Backups, nil
+}
+
+func (s *cinderService) DeleteBackup(id string) error {
+	if err := s.do("DELETE", false, "/backups/"+id, backupHeaders, nil, nil); err != nil {
+		return err
+	}
+	return s.waitForBackup(id)
+}
+
+// RestoreBackup restores the backup to the existing volume, which must be
+// available and at least as large as the backup
+func (s *cinderService) RestoreBackup(backupID, volumeID string) error {
+	restore := map[string]interface{}{
+		"restore": map[string]string{"volume_id": volumeID},
+	}
+	if err := s.do("POST", false, "/backups/"+backupID+"/restore", nil, restore, nil); err != nil {
+		return err
+	}
+	return waitForStatus("volume "+volumeID, BACKUP_TIMEOUT, func() (string, error) {
+		volume, err := s.GetVolume(volumeID)
+		if err != nil {
+			return "", err
+		}
+		return volume.Status, nil
+	}, "available")
+}
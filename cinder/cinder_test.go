@@ -0,0 +1,281 @@
+package cinder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct {
+	server *httptest.Server
+
+	mutex    sync.Mutex
+	requests []string
+	headers  map[string]http.Header
+	bodies   map[string]map[string]interface{}
+	polls    int
+}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) SetUpTest(c *C) {
+	RetryInterval = time.Millisecond
+	s.requests = nil
+	s.headers = map[string]http.Header{}
+	s.bodies = map[string]map[string]interface{}{}
+	s.polls = 0
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+}
+
+func (s *TestSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *TestSuite) catalog() string {
+	return fmt.Sprintf(`{"token":{"expires_at":"%v","catalog":[`+
+		`{"type":"compute","endpoints":[{"interface":"public","region_id":"RegionOne","url":"%[2]v/compute/v2.1/"}]},`+
+		`{"type":"volumev3","endpoints":[`+
+		`{"interface":"internal","region_id":"RegionOne","url":"%[2]v/internal/"},`+
+		`{"interface":"public","region_id":"RegionTwo","url":"%[2]v/two/"},`+
+		`{"interface":"public","region_id":"RegionOne","url":"%[2]v/volume/v3/proj"}]}]}}`,
+		time.Now().Add(time.Hour).UTC().Format(time.RFC3339), s.server.URL)
+}
+
+func (s *TestSuite) handle(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p := r.URL.Path
+	if p == "/openstack/latest/meta_data.json" {
+		fmt.Fprint(w, `{"uuid":"server1","availability_zone":"nova"}`)
+		return
+	}
+	if data, _ := ioutil.ReadAll(r.Body); len(data) != 0 {
+		body := map[string]interface{}{}
+		json.Unmarshal(data, &body)
+		s.bodies[r.Method+" "+p] = body
+	}
+	if p == "/identity/v3/auth/tokens" {
+		w.Header().Set("X-Subject-Token", "token1")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, s.catalog())
+		return
+	}
+
+	if r.Header.Get("X-Auth-Token") != "token1" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.requests = append(s.requests, r.Method+" "+p)
+	s.headers[r.Method+" "+p] = r.Header
+
+	switch r.Method + " " + p {
+	case "POST /volume/v3/proj/volumes":
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, `{"volume":{"id":"vol1","status":"creating"}}`)
+	case "GET /volume/v3/proj/volumes/vol1":
+		s.polls++
+		status := "creating"
+		if s.polls >= 2 {
+			status = "available"
+		}
+		fmt.Fprintf(w, `{"volume":{"id":"vol1","name":"convoy-v1","size":2,"status":"%v"}}`, status)
+	case "GET /volume/v3/proj/volumes/vol2":
+		fmt.Fprint(w, `{"volume":{"id":"vol2","status":"error_restoring"}}`)
+	case "GET /volume/v3/proj/volumes/vol3":
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"itemNotFound":{"code":404,"message":"Volume vol3 could not be found."}}`)
+	case "DELETE /compute/v2.1/servers/server1/os-volume_attachments/vol1":
+		w.WriteHeader(http.StatusNotFound)
+	case "POST /volume/v3/proj/backups/backup1/restore":
+		w.WriteHeader(http.StatusAccepted)
+	case "GET /volume/v3/proj/backups/detail":
+		fmt.Fprint(w, `{"backups":[`+
+			`{"id":"backup1","name":"convoy-v1-s1","size":2,"status":"available","metadata":{"convoy-volume":"v1","convoy-snapshot":"s1"}},`+
+			`{"id":"backup2","name":"other","size":1,"status":"available","metadata":{}}]}`)
+	case "POST /volume/v3/proj/snapshots":
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"badRequest":{"code":400,"message":"Invalid volume"}}`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (s *TestSuite) newService(c *C) *cinderService {
+	svc, err := newCinderService(http.DefaultClient, s.server.URL+"/", &Credential{
+		AuthURL:           s.server.URL + "/identity/v3/",
+		Username:          "user1",
+		Password:          "password1",
+		UserDomainName:    "Default",
+		ProjectName:       "proj1",
+		ProjectDomainName: "Default",
+		Interface:         "public",
+	})
+	c.Assert(err, IsNil)
+	return svc
+}
+
+func (s *TestSuite) TestAuth(c *C) {
+	svc := s.newService(c)
+	c.Assert(svc.ServerID, Equals, "server1")
+	c.Assert(svc.AvailabilityZone, Equals, "nova")
+	c.Assert(svc.Region, Equals, "RegionTwo")
+	c.Assert(svc.volumeURL, Equals, s.server.URL+"/two")
+	c.Assert(svc.computeURL, Equals, s.server.URL+"/compute/v2.1")
+
+	auth := s.bodies["POST /identity/v3/auth/tokens"]["auth"].(map[string]interface{})
+	c.Assert(auth["scope"], DeepEquals, map[string]interface{}{
+		"project": map[string]interface{}{
+			"name":   "proj1",
+			"domain": map[string]interface{}{"name": "Default"},
+		},
+	})
+	user := auth["identity"].(map[string]interface{})["password"].(map[string]interface{})["user"].(map[string]interface{})
+	c.Assert(user["name"], Equals, "user1")
+	c.Assert(user["password"], Equals, "password1")
+
+	svc.credential.RegionName = "RegionOne"
+	svc.token = ""
+	_, err := svc.getToken()
+	c.Assert(err, IsNil)
+	c.Assert(svc.Region, Equals, "RegionOne")
+	c.Assert(svc.volumeURL, Equals, s.server.URL+"/volume/v3/proj")
+
+	svc.credential.RegionName = "RegionThree"
+	svc.token = ""
+	_, err = svc.getToken()
+	c.Assert(err, ErrorMatches, "Cannot find public endpoint of \\[block-storage volumev3\\].*")
+
+	_, err = newCinderService(http.DefaultClient, s.server.URL+"/nowhere/", svc.credential)
+	c.Assert(err, ErrorMatches, "Not running on an OpenStack server.*")
+}
+
+func (s *TestSuite) newRegionOneService(c *C) *cinderService {
+	svc := s.newService(c)
+	svc.credential.RegionName = "RegionOne"
+	svc.token = ""
+	_, err := svc.getToken()
+	c.Assert(err, IsNil)
+	return svc
+}
+
+func (s *TestSuite) TestVolume(c *C) {
+	svc := s.newRegionOneService(c)
+	volume, err := svc.CreateVolume(&CreateVolumeRequest{
+		Name:       "convoy-v1",
+		Size:       GB + 1,
+		VolumeType: "ssd",
+		Metadata:   map[string]string{METADATA_VOLUME: "v1"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(volume.ID, Equals, "vol1")
+	c.Assert(volume.Size, Equals, int64(2))
+	c.Assert(s.polls, Equals, 3)
+	c.Assert(s.bodies["POST /volume/v3/proj/volumes"]["volume"], DeepEquals, map[string]interface{}{
+		"name":              "convoy-v1",
+		"size":              float64(2),
+		"volume_type":       "ssd",
+		"availability_zone": "nova",
+		"metadata":          map[string]interface{}{METADATA_VOLUME: "v1"},
+	})
+
+	// Not attached, detach would only wait for the volume to be available
+	err = svc.DetachVolume("vol1")
+	c.Assert(err, IsNil)
+
+	err = svc.waitForVolume("vol2", "available")
+	c.Assert(err, ErrorMatches, "volume vol2 is in error_restoring status")
+
+	_, err = svc.GetVolume("vol3")
+	c.Assert(IsNotFound(err), Equals, true)
+	err = svc.waitForVolume("vol3")
+	c.Assert(err, IsNil)
+
+	_, err = svc.CreateSnapshot(&CreateSnapshotRequest{VolumeID: "vol3"})
+	c.Assert(err, ErrorMatches, "OpenStack Error: badRequest Invalid volume")
+}
+
+func (s *TestSuite) TestBackup(c *C) {
+	svc := s.newRegionOneService(c)
+	backups, err := svc.ListBackups()
+	c.Assert(err, IsNil)
+	c.Assert(backups, HasLen, 2)
+	c.Assert(backups[0].Metadata[METADATA_SNAPSHOT], Equals, "s1")
+	c.Assert(s.headers["GET /volume/v3/proj/backups/detail"].Get("OpenStack-API-Version"), Equals, BACKUP_MICROVERSION)
+
+	err = svc.RestoreBackup("backup1", "vol1")
+	c.Assert(err, IsNil)
+	c.Assert(s.bodies["POST /volume/v3/proj/backups/backup1/restore"], DeepEquals, map[string]interface{}{
+		"restore": map[string]interface{}{"volume_id": "vol1"},
+	})
+
+	d := &Driver{cinderService: svc}
+	list, err := d.ListBackup("", "", map[string]string{})
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 1)
+	info := list["cinder://RegionOne/backup1"]
+	c.Assert(info["VolumeName"], Equals, "v1")
+	c.Assert(info["SnapshotName"], Equals, "s1")
+	c.Assert(info["VolumeSize"], Equals, "2147483648")
+
+	list, err = d.ListBackup("", "", map[string]string{"VolumeName": "v2"})
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 0)
+
+	_, err = d.getBackup("cinder://RegionTwo/backup1")
+	c.Assert(err, ErrorMatches, "Backup backup1 is in region RegionTwo, current region is RegionOne")
+}
+
+func (s *TestSuite) TestURL(c *C) {
+	url := encodeURL("RegionOne", "backup1")
+	c.Assert(url, Equals, "cinder://RegionOne/backup1")
+	region, backupID, err := decodeURL(url)
+	c.Assert(err, IsNil)
+	c.Assert(region, Equals, "RegionOne")
+	c.Assert(backupID, Equals, "backup1")
+
+	_, _, err = decodeURL("cinder://RegionOne/")
+	c.Assert(err, ErrorMatches, "Invalid Cinder backup URL.*")
+	_, _, err = decodeURL("ebs://us-west-1/snap-1234")
+	c.Assert(err, ErrorMatches, "BUG: Why dispatch ebs to cinder.*")
+}
+
+func (s *TestSuite) TestDevicePaths(c *C) {
+	paths := DevicePaths("0123456789abcdef0123456789abcdef")
+	c.Assert(paths[0], Equals, "/dev/disk/by-id/virtio-0123456789abcdef0123")
+	c.Assert(strings.HasSuffix(paths[1], "_0123456789abcdef0123456789abcdef"), Equals, true)
+}
+
+func (s *TestSuite) TestCredentialFromEnv(c *C) {
+	for _, k := range []string{"OS_AUTH_URL", "OS_USERNAME", "OS_USER_ID", "OS_PASSWORD",
+		"OS_PROJECT_NAME", "OS_PROJECT_ID", "OS_USER_DOMAIN_NAME", "OS_INTERFACE"} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Unsetenv(k)
+	}
+
+	_, err := CredentialFromEnv()
+	c.Assert(err, ErrorMatches, "OS_AUTH_URL and OS_PASSWORD must be set")
+
+	os.Setenv("OS_AUTH_URL", "http://keystone:5000/v3")
+	os.Setenv("OS_PASSWORD", "password1")
+	os.Setenv("OS_USER_ID", "user1")
+	_, err = CredentialFromEnv()
+	c.Assert(err, ErrorMatches, "Either OS_PROJECT_NAME or OS_PROJECT_ID must be set")
+
+	os.Setenv("OS_PROJECT_ID", "proj1")
+	cred, err := CredentialFromEnv()
+	c.Assert(err, IsNil)
+	c.Assert(cred.UserDomainName, Equals, "Default")
+	c.Assert(cred.Interface, Equals, "public")
+}
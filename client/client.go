@@ -111,6 +111,47 @@ func sendRequestAndPrint(method, request string, data interface{}) error {
 	return nil
 }
 
+// sendRequestWithBodyAndPrint sends the request followed by the content of
+// body, e.g. the image read from stdin, and prints the response
+func sendRequestWithBodyAndPrint(method, request string, data interface{}, body io.Reader) error {
+	log.Debugf("Sending request %v %v with body", method, request)
+	params, err := util.EncodeData(data)
+	if err != nil {
+		return err
+	}
+	rc, _, _, err := client.clientRequest(method, request, io.MultiReader(params, body), nil)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+// sendRequestAndCopyOutput writes the response to w, e.g. the image streamed
+// to stdout. The response is shorter than its length if the daemon failed
+// half way.
+func sendRequestAndCopyOutput(method, request string, data interface{}, w io.Writer) error {
+	rc, err := sendRequest(method, request, data)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if _, err := io.Copy(w, rc); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("The stream ended before completed, see the log of daemon for the reason")
+		}
+		return err
+	}
+	return nil
+}
+
 func cmdNotFound(c *cli.Context, command string) {
 	panic(fmt.Errorf("Unrecognized command: %s", command))
 }
@@ -149,7 +190,9 @@ func NewCli(version string) *cli.App {
 		volumeListCmd,
 		volumeInspectCmd,
 		snapshotCmd,
+		groupCmd,
 		backupCmd,
+		objectstoreCmd,
 	}
 	return app
 }
@@ -29,6 +29,11 @@ var (
 			Value: &cli.StringSlice{},
 			Usage: "options for driver",
 		},
+		cli.StringSliceFlag{
+			Name:  "driver-plugins",
+			Value: &cli.StringSlice{},
+			Usage: "Driver plugins in the form of <name>=<path of plugin binary>, which can be enabled by --drivers as the builtin drivers",
+		},
 		cli.StringFlag{
 			Name:  "mnt-ns",
 			Usage: "Specify mount namespace file descriptor if user don't want to mount in current namespace. Support by Device Mapper and EBS",
@@ -45,6 +50,94 @@ var (
 			Name:  "cmd-timeout",
 			Usage: "Set timeout value for executing each command. One minute (1m) by default and at least one minute.",
 		},
+		cli.IntFlag{
+			Name:  "backup-workers",
+			Usage: "Number of blocks to be hashed, compressed and written to objectstore concurrently when creating backup. 4 by default",
+		},
+		cli.IntFlag{
+			Name:  "restore-workers",
+			Usage: "Number of blocks to be read from objectstore and written to the volume concurrently when restoring backup. 4 by default",
+		},
+		cli.StringFlag{
+			Name:  "backup-read-limit",
+			Usage: "Rate limit of reading the snapshots when creating backup, in bytes per second across all the backups, e.g. 50M. No limit by default",
+		},
+		cli.IntFlag{
+			Name:  "backup-read-iops",
+			Usage: "Limit of the reads per second of the snapshots when creating backup, across all the backups. No limit by default",
+		},
+		cli.StringFlag{
+			Name:  "backup-compression",
+			Value: "gzip",
+			Usage: "Compression of backup blocks for the objectstores used for the first time, can be none, gzip or zstd",
+		},
+		cli.StringFlag{
+			Name:  "backup-block-size",
+			Value: "2M",
+			Usage: "Size of backup blocks for the objectstores used for the first time, a power of 2 between 64K and 64M",
+		},
+		cli.BoolFlag{
+			Name:  "backup-shared-blocks",
+			Usage: "Deduplicate backup blocks across all the volumes for the objectstores used for the first time",
+		},
+		cli.StringFlag{
+			Name:  "backup-hash",
+			Value: "sha512",
+			Usage: "Hash to address backup blocks for the objectstores used for the first time, can be sha512, sha256 or blake2b",
+		},
+		cli.StringFlag{
+			Name:  "backup-chunking",
+			Value: "fixed",
+			Usage: "How volumes are split into backup blocks for the objectstores used for the first time, can be fixed or cdc",
+		},
+		cli.StringSliceFlag{
+			Name:  "backup-keys",
+			Value: &cli.StringSlice{},
+			Usage: "Key files to encrypt backups, the first key would be used to encrypt the objectstores used for the first time",
+		},
+		cli.StringSliceFlag{
+			Name:  "readonly-objectstores",
+			Value: &cli.StringSlice{},
+			Usage: "Objectstore URLs to be used as read-only. Backups in them can be listed, inspected and restored, but cannot be created or deleted",
+		},
+		cli.StringSliceFlag{
+			Name:  "objectstore-upload-limits",
+			Value: &cli.StringSlice{},
+			Usage: "Upload rate limits of objectstores in bytes per second, in the form of [<dest URL>=]<rate>, e.g. s3://backups@us-west-2/=10M",
+		},
+		cli.StringSliceFlag{
+			Name:  "objectstore-download-limits",
+			Value: &cli.StringSlice{},
+			Usage: "Download rate limits of objectstores in bytes per second, in the form of [<dest URL>=]<rate>, e.g. 50M",
+		},
+		cli.StringSliceFlag{
+			Name:  "backup-copies",
+			Value: &cli.StringSlice{},
+			Usage: "Copy the backups to another objectstore in background once created, in the form of <dest URL>=<copy URL>, e.g. s3://backups@us-east-1/=s3://backups-dr@us-west-2/",
+		},
+		cli.StringFlag{
+			Name:  "objectstore-lock-ttl",
+			Value: "5m",
+			Usage: "How long the locks in objectstores would be kept after the host stopped renewing them, e.g. crashed. At least one minute",
+		},
+		cli.StringFlag{
+			Name:  "docker-host",
+			Value: "unix:///var/run/docker.sock",
+			Usage: "Docker API endpoint used to pause the containers using the volumes around the snapshots, e.g. unix:///var/run/docker.sock or tcp://127.0.0.1:2375",
+		},
+		cli.IntFlag{
+			Name:  "max-concurrent-operations",
+			Value: 0,
+			Usage: "Maximum snapshot and backup operations running at the same time, the others would wait in a queue. 0 means unlimited",
+		},
+		cli.StringFlag{
+			Name:  "plugin-socket",
+			Usage: "Additional unix domain socket for Docker to talk to the daemon, e.g. /run/docker/plugins/convoy.sock when running as a Docker managed plugin",
+		},
+		cli.StringFlag{
+			Name:  "propagated-mount",
+			Usage: "Propagated mount of the Docker managed plugin, the volumes must be mounted in it to be used by Docker",
+		},
 		cli.BoolFlag{
 			Name:  "ignore-config-file",
 			Usage: "Avoid loading the existing config file when starting daemon, and use the command line options instead (not including driver options)",
@@ -0,0 +1,151 @@
+package client
+
+import (
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+)
+
+var (
+	groupCreateCmd = cli.Command{
+		Name:  "create",
+		Usage: "create a group of volumes snapshotted together: group create <group>",
+		Flags: []cli.Flag{
+			cli.StringSliceFlag{
+				Name:  "volume",
+				Value: &cli.StringSlice{},
+				Usage: "volume of the group, can be specified multiple times",
+			},
+		},
+		Action: cmdGroupCreate,
+	}
+
+	groupDeleteCmd = cli.Command{
+		Name:   "delete",
+		Usage:  "delete a group, the snapshots are kept: group delete <group>",
+		Action: cmdGroupDelete,
+	}
+
+	groupListCmd = cli.Command{
+		Name:   "list",
+		Usage:  "list groups and their group snapshots",
+		Action: cmdGroupList,
+	}
+
+	groupSnapshotCmd = cli.Command{
+		Name:  "snapshot",
+		Usage: "snapshot all the volumes of a group consistently: group snapshot <group>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "name",
+				Usage: "name of group snapshot, the snapshot of each volume would be named <name>-<volume>",
+			},
+			cli.StringSliceFlag{
+				Name:  "label",
+				Value: &cli.StringSlice{},
+				Usage: "label of the snapshots in the form of <key>=<value>, can be specified multiple times",
+			},
+			cli.StringFlag{
+				Name:  "description",
+				Usage: "free-form description of the snapshots",
+			},
+			cli.BoolFlag{
+				Name:  "no-freeze",
+				Usage: "don't freeze the filesystems of the mounted volumes of the group while creating the snapshots",
+			},
+			cli.BoolFlag{
+				Name:  "pause-containers",
+				Usage: "pause the running docker containers using any volume of the group while creating the snapshots",
+			},
+		},
+		Action: cmdGroupSnapshot,
+	}
+
+	groupCmd = cli.Command{
+		Name:  "group",
+		Usage: "consistency group related operations",
+		Subcommands: []cli.Command{
+			groupCreateCmd,
+			groupDeleteCmd,
+			groupListCmd,
+			groupSnapshotCmd,
+		},
+	}
+)
+
+func cmdGroupCreate(c *cli.Context) {
+	if err := doGroupCreate(c); err != nil {
+		panic(err)
+	}
+}
+
+func doGroupCreate(c *cli.Context) error {
+	groupName, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+
+	request := &api.GroupCreateRequest{
+		Name:        groupName,
+		VolumeNames: c.StringSlice("volume"),
+	}
+	url := "/groups/create"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdGroupDelete(c *cli.Context) {
+	if err := doGroupDelete(c); err != nil {
+		panic(err)
+	}
+}
+
+func doGroupDelete(c *cli.Context) error {
+	groupName, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+
+	request := &api.GroupDeleteRequest{
+		Name: groupName,
+	}
+	url := "/groups"
+	return sendRequestAndPrint("DELETE", url, request)
+}
+
+func cmdGroupList(c *cli.Context) {
+	if err := doGroupList(c); err != nil {
+		panic(err)
+	}
+}
+
+func doGroupList(c *cli.Context) error {
+	url := "/groups/list"
+	return sendRequestAndPrint("GET", url, nil)
+}
+
+func cmdGroupSnapshot(c *cli.Context) {
+	if err := doGroupSnapshot(c); err != nil {
+		panic(err)
+	}
+}
+
+func doGroupSnapshot(c *cli.Context) error {
+	var err error
+
+	groupName, err := getName(c, "", true)
+	snapshotName, err := util.GetName(c, "name", false, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.GroupSnapshotRequest{
+		GroupName:       groupName,
+		Name:            snapshotName,
+		Labels:          c.StringSlice("label"),
+		Description:     c.String("description"),
+		NoFreeze:        c.Bool("no-freeze"),
+		PauseContainers: c.Bool("pause-containers"),
+	}
+	url := "/groups/snapshot"
+	return sendRequestAndPrint("POST", url, request)
+}
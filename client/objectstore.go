@@ -1,6 +1,10 @@
 package client
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+
 	"github.com/codegangsta/cli"
 	"github.com/rancher/convoy/api"
 	"github.com/rancher/convoy/util"
@@ -20,13 +24,40 @@ var (
 				Name:  "dest",
 				Usage: "destination of backup if driver supports, would be url like s3://bucket@region/path/ or vfs:///path/",
 			},
+			cli.BoolFlag{
+				Name:  "progress",
+				Usage: "report the progress of backup",
+			},
+			cli.StringFlag{
+				Name:  "export-image",
+				Usage: "also export the snapshot as a single image in the format, can be raw or qcow2",
+			},
+			cli.StringSliceFlag{
+				Name:  "label",
+				Value: &cli.StringSlice{},
+				Usage: "label of the backup in the form of <key>=<value>, e.g. app=postgres, can be specified multiple times",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "only report the blocks and bytes the backup would transfer, without creating it",
+			},
+			cli.StringFlag{
+				Name:  "output",
+				Usage: "stream the snapshot as a raw image to the file, or stdout if it's -, rather than creating a backup in objectstore",
+			},
 		},
 		Action: cmdBackupCreate,
 	}
 
 	backupDeleteCmd = cli.Command{
-		Name:   "delete",
-		Usage:  "delete a backup in objectstore: delete <backup>",
+		Name:  "delete",
+		Usage: "delete a backup in objectstore: delete <backup>",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "force",
+				Usage: "delete the backup even if other backups were built on top of it",
+			},
+		},
 		Action: cmdBackupDelete,
 	}
 
@@ -38,6 +69,15 @@ var (
 				Name:  "volume-name",
 				Usage: "name of volume",
 			},
+			cli.BoolFlag{
+				Name:  "all",
+				Usage: "list the backups of all the volumes in objectstore, including the ones of the drivers not enabled",
+			},
+			cli.StringSliceFlag{
+				Name:  "label",
+				Value: &cli.StringSlice{},
+				Usage: "only list the backups with the label in the form of <key>=<value>, can be specified multiple times",
+			},
 		},
 		Action: cmdBackupList,
 	}
@@ -48,6 +88,243 @@ var (
 		Action: cmdBackupInspect,
 	}
 
+	backupStatsCmd = cli.Command{
+		Name:  "stats",
+		Usage: "show the stats of the backups of the volumes in objectstore, e.g. change rate and dedup ratio: stats <dest>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "volume-name",
+				Usage: "name of volume",
+			},
+		},
+		Action: cmdBackupStats,
+	}
+
+	backupRetrieveCmd = cli.Command{
+		Name:   "retrieve",
+		Usage:  "start or check the retrieval of an archived backup before restoring it: retrieve <backup>",
+		Action: cmdBackupRetrieve,
+	}
+
+	backupRestoreCmd = cli.Command{
+		Name:  "restore",
+		Usage: "restore a backup onto the volume restored from another backup of the same volume: restore <backup>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "volume-name",
+				Usage: "name of the volume to restore to",
+			},
+			cli.BoolFlag{
+				Name:  "progress",
+				Usage: "report the progress of restore",
+			},
+			cli.StringFlag{
+				Name:  "input",
+				Usage: "restore the raw image read from the file, or stdin if it's -, rather than a backup in objectstore",
+			},
+		},
+		Action: cmdBackupRestore,
+	}
+
+	backupVerifyCmd = cli.Command{
+		Name:  "verify",
+		Usage: "verify the blocks of a backup in objectstore: verify <backup>",
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "sample",
+				Value: 100,
+				Usage: "percentage of the blocks would be downloaded and verified by checksum, the others would only be checked for existence",
+			},
+		},
+		Action: cmdBackupVerify,
+	}
+
+	backupRepairCmd = cli.Command{
+		Name:  "repair",
+		Usage: "upload the missing or corrupted blocks of a backup again from its snapshot: repair <backup>",
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "sample",
+				Value: 100,
+				Usage: "percentage of the blocks would be downloaded and verified by checksum, the others would only be checked for existence",
+			},
+		},
+		Action: cmdBackupRepair,
+	}
+
+	backupExportCmd = cli.Command{
+		Name:  "export",
+		Usage: "export a backup with its blocks to a portable archive: export <backup>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "file",
+				Usage: "path of the archive on the host of daemon",
+			},
+		},
+		Action: cmdBackupExport,
+	}
+
+	backupExtractCmd = cli.Command{
+		Name:  "extract",
+		Usage: "extract a file or directory from a single file backup to the host of daemon: extract <backup>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "path",
+				Usage: "path of the file or directory in the volume, e.g. /data/db.conf, or / for the whole backup",
+			},
+			cli.StringFlag{
+				Name:  "target",
+				Usage: "path on the host of daemon to extract to, which must not exist",
+			},
+		},
+		Action: cmdBackupExtract,
+	}
+
+	backupImportCmd = cli.Command{
+		Name:  "import",
+		Usage: "import a backup from a portable archive into objectstore: import <file>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "dest",
+				Usage: "destination of backup, would be url like s3://bucket@region/path/ or vfs:///path/",
+			},
+		},
+		Action: cmdBackupImport,
+	}
+
+	backupReplicateCmd = cli.Command{
+		Name:  "replicate",
+		Usage: "copy the backups of a volume missing in another objectstore to it: replicate <src>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "dest",
+				Usage: "destination of replication, would be url like s3://bucket@region/path/ or vfs:///path/",
+			},
+			cli.StringFlag{
+				Name:  "dest-s3-endpoint",
+				Usage: "custom S3 endpoint URL of destination, like http://minio.example.com:9000",
+			},
+			cli.StringFlag{
+				Name:  "volume-name",
+				Usage: "name of volume",
+			},
+		},
+		Action: cmdBackupReplicate,
+	}
+
+	backupGCCmd = cli.Command{
+		Name:  "gc",
+		Usage: "remove the blocks not referenced by any backup in objectstore: gc <dest>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "volume-name",
+				Usage: "only collect the blocks of the volume",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "only report the unused blocks without removing them",
+			},
+		},
+		Action: cmdBackupGC,
+	}
+
+	backupRetentionCmd = cli.Command{
+		Name:  "retention",
+		Usage: "set or show the retention policy of the backups of a volume: retention <volume>",
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "keep-last",
+				Usage: "keep the latest N backups",
+			},
+			cli.IntFlag{
+				Name:  "keep-daily",
+				Usage: "keep the latest backup of each of the latest N days",
+			},
+			cli.IntFlag{
+				Name:  "keep-weekly",
+				Usage: "keep the latest backup of each of the latest N weeks",
+			},
+			cli.IntFlag{
+				Name:  "keep-monthly",
+				Usage: "keep the latest backup of each of the latest N months",
+			},
+			cli.BoolFlag{
+				Name:  "clear",
+				Usage: "remove the retention policy, so all the backups would be kept",
+			},
+		},
+		Action: cmdBackupRetention,
+	}
+
+	backupHooksCmd = cli.Command{
+		Name:  "hooks",
+		Usage: "set or show the commands run around the backups of a volume: hooks <volume>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "pre-snapshot",
+				Usage: "shell command run before creating the snapshot, the snapshot would not be created if it fails",
+			},
+			cli.StringFlag{
+				Name:  "post-backup",
+				Usage: "shell command run after the backup of the snapshot completed",
+			},
+			cli.BoolFlag{
+				Name:  "clear",
+				Usage: "remove the hooks of the volume",
+			},
+		},
+		Action: cmdBackupHooks,
+	}
+
+	objectstoreUpgradeCmd = cli.Command{
+		Name:  "upgrade",
+		Usage: "upgrade the configs in objectstore written by older versions to the current format: upgrade <dest>",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "only report the configs need to be upgraded without writing them",
+			},
+		},
+		Action: cmdObjectStoreUpgrade,
+	}
+
+	objectstoreBreakLockCmd = cli.Command{
+		Name:  "break-lock",
+		Usage: "remove the lock left in objectstore by a host which is gone: break-lock <dest>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "volume-name",
+				Usage: "break the lock of the volume, otherwise the lock of the objectstore",
+			},
+		},
+		Action: cmdObjectStoreBreakLock,
+	}
+
+	objectstoreRotateKeyCmd = cli.Command{
+		Name:  "rotate-key",
+		Usage: "encrypt the objectstore by a new key and re-encrypt the existing objects: rotate-key <dest>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "key",
+				Usage: "the file of the new key on the daemon host, which must have been loaded by --backup-keys",
+			},
+		},
+		Action: cmdObjectStoreRotateKey,
+	}
+
+	objectstoreCmd = cli.Command{
+		Name:  "objectstore",
+		Usage: "objectstore related operations",
+		Subcommands: []cli.Command{
+			objectstoreUpgradeCmd,
+			objectstoreBreakLockCmd,
+			objectstoreRotateKeyCmd,
+		},
+		Flags: []cli.Flag{
+			S3EndpointFlag,
+		},
+	}
+
 	backupCmd = cli.Command{
 		Name:  "backup",
 		Usage: "backup related operations",
@@ -56,6 +333,19 @@ var (
 			backupDeleteCmd,
 			backupListCmd,
 			backupInspectCmd,
+			backupStatsCmd,
+			backupRetrieveCmd,
+			backupRestoreCmd,
+			backupVerifyCmd,
+			backupRepairCmd,
+			backupExportCmd,
+			backupExtractCmd,
+			backupImportCmd,
+			backupReplicateCmd,
+			backupGCCmd,
+			backupRetentionCmd,
+			backupHooksCmd,
+			backupScheduleCmd,
 		},
 		Flags: []cli.Flag{
 			S3EndpointFlag,
@@ -83,11 +373,38 @@ func doBackupList(c *cli.Context) error {
 		URL:        destURL,
 		Endpoint:   endpointURL,
 		VolumeName: volumeName,
+		All:        c.Bool("all"),
+		Labels:     c.StringSlice("label"),
 	}
 	url := "/backups/list"
 	return sendRequestAndPrint("GET", url, request)
 }
 
+func cmdBackupStats(c *cli.Context) {
+	if err := doBackupStats(c); err != nil {
+		panic(err)
+	}
+}
+
+func doBackupStats(c *cli.Context) error {
+	var err error
+
+	destURL, err := util.GetFlag(c, "", true, err)
+	volumeName, err := util.GetName(c, "volume-name", false, err)
+	if err != nil {
+		return err
+	}
+
+	endpointURL := c.GlobalString("s3-endpoint")
+	request := &api.BackupListRequest{
+		URL:        destURL,
+		Endpoint:   endpointURL,
+		VolumeName: volumeName,
+	}
+	url := "/backups/stats"
+	return sendRequestAndPrint("GET", url, request)
+}
+
 func cmdBackupInspect(c *cli.Context) {
 	if err := doBackupInspect(c); err != nil {
 		panic(err)
@@ -111,6 +428,304 @@ func doBackupInspect(c *cli.Context) error {
 	return sendRequestAndPrint("GET", url, request)
 }
 
+func cmdBackupRetrieve(c *cli.Context) {
+	if err := doBackupRetrieve(c); err != nil {
+		panic(err)
+	}
+}
+
+func doBackupRetrieve(c *cli.Context) error {
+	var err error
+
+	backupURL, err := util.GetFlag(c, "", true, err)
+	if err != nil {
+		return err
+	}
+
+	endpointURL := c.GlobalString("s3-endpoint")
+	request := &api.BackupListRequest{
+		URL:      backupURL,
+		Endpoint: endpointURL,
+	}
+	url := "/backups/retrieve"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdBackupVerify(c *cli.Context) {
+	if err := doBackupVerify(c); err != nil {
+		panic(err)
+	}
+}
+
+func doBackupVerify(c *cli.Context) error {
+	var err error
+	backupURL, err := util.GetFlag(c, "", true, err)
+	if err != nil {
+		return err
+	}
+
+	endpointURL := c.GlobalString("s3-endpoint")
+	request := &api.BackupVerifyRequest{
+		URL:      backupURL,
+		Endpoint: endpointURL,
+		Sample:   c.Int("sample"),
+	}
+	url := "/backups/verify"
+	return sendRequestAndPrint("GET", url, request)
+}
+
+func cmdBackupRestore(c *cli.Context) {
+	if err := doBackupRestore(c); err != nil {
+		panic(err)
+	}
+}
+
+func doBackupRestore(c *cli.Context) error {
+	var err error
+	input, err := util.GetFlag(c, "input", false, err)
+	backupURL, err := util.GetFlag(c, "", input == "", err)
+	volumeName, err := util.GetFlag(c, "volume-name", true, err)
+	if err != nil {
+		return err
+	}
+
+	endpointURL := c.GlobalString("s3-endpoint")
+	request := &api.BackupRestoreRequest{
+		URL:        backupURL,
+		Endpoint:   endpointURL,
+		VolumeName: volumeName,
+		Progress:   c.Bool("progress"),
+		Stream:     input != "",
+	}
+	url := "/backups/restore"
+	if request.Stream {
+		if backupURL != "" || request.Progress {
+			return fmt.Errorf("Cannot restore backup or report progress with --input")
+		}
+		if input == "-" {
+			return sendRequestWithBodyAndPrint("POST", url, request, os.Stdin)
+		}
+		f, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return sendRequestWithBodyAndPrint("POST", url, request, f)
+	}
+	if request.Progress {
+		return sendRequestAndStreamProgress("POST", url, request)
+	}
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdBackupRepair(c *cli.Context) {
+	if err := doBackupRepair(c); err != nil {
+		panic(err)
+	}
+}
+
+func doBackupRepair(c *cli.Context) error {
+	var err error
+	backupURL, err := util.GetFlag(c, "", true, err)
+	if err != nil {
+		return err
+	}
+
+	endpointURL := c.GlobalString("s3-endpoint")
+	request := &api.BackupVerifyRequest{
+		URL:      backupURL,
+		Endpoint: endpointURL,
+		Sample:   c.Int("sample"),
+	}
+	url := "/backups/repair"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdBackupExport(c *cli.Context) {
+	if err := doBackupExport(c); err != nil {
+		panic(err)
+	}
+}
+
+func doBackupExport(c *cli.Context) error {
+	var err error
+	backupURL, err := util.GetFlag(c, "", true, err)
+	filePath, err := util.GetFlag(c, "file", true, err)
+	if err != nil {
+		return err
+	}
+	// The archive is written by daemon, which may run in another directory
+	if filePath, err = filepath.Abs(filePath); err != nil {
+		return err
+	}
+
+	endpointURL := c.GlobalString("s3-endpoint")
+	request := &api.BackupExportRequest{
+		URL:      backupURL,
+		Endpoint: endpointURL,
+		FilePath: filePath,
+	}
+	url := "/backups/export"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdBackupExtract(c *cli.Context) {
+	if err := doBackupExtract(c); err != nil {
+		panic(err)
+	}
+}
+
+func doBackupExtract(c *cli.Context) error {
+	var err error
+	backupURL, err := util.GetFlag(c, "", true, err)
+	path, err := util.GetFlag(c, "path", true, err)
+	targetPath, err := util.GetFlag(c, "target", true, err)
+	if err != nil {
+		return err
+	}
+	// The files are written by daemon, which may run in another directory
+	if targetPath, err = filepath.Abs(targetPath); err != nil {
+		return err
+	}
+
+	endpointURL := c.GlobalString("s3-endpoint")
+	request := &api.BackupExtractRequest{
+		URL:        backupURL,
+		Endpoint:   endpointURL,
+		Path:       path,
+		TargetPath: targetPath,
+	}
+	url := "/backups/extract"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdBackupImport(c *cli.Context) {
+	if err := doBackupImport(c); err != nil {
+		panic(err)
+	}
+}
+
+func doBackupImport(c *cli.Context) error {
+	var err error
+	filePath, err := util.GetFlag(c, "", true, err)
+	destURL, err := util.GetFlag(c, "dest", true, err)
+	if err != nil {
+		return err
+	}
+	if filePath, err = filepath.Abs(filePath); err != nil {
+		return err
+	}
+
+	endpointURL := c.GlobalString("s3-endpoint")
+	request := &api.BackupImportRequest{
+		URL:      destURL,
+		Endpoint: endpointURL,
+		FilePath: filePath,
+	}
+	url := "/backups/import"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdBackupReplicate(c *cli.Context) {
+	if err := doBackupReplicate(c); err != nil {
+		panic(err)
+	}
+}
+
+func doBackupReplicate(c *cli.Context) error {
+	var err error
+
+	srcURL, err := util.GetFlag(c, "", true, err)
+	destURL, err := util.GetFlag(c, "dest", true, err)
+	volumeName, err := util.GetName(c, "volume-name", true, err)
+	if err != nil {
+		return err
+	}
+
+	endpointURL := c.GlobalString("s3-endpoint")
+	request := &api.BackupReplicateRequest{
+		URL:          srcURL,
+		Endpoint:     endpointURL,
+		DestURL:      destURL,
+		DestEndpoint: c.String("dest-s3-endpoint"),
+		VolumeName:   volumeName,
+	}
+	url := "/backups/replicate"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdBackupGC(c *cli.Context) {
+	if err := doBackupGC(c); err != nil {
+		panic(err)
+	}
+}
+
+func doBackupGC(c *cli.Context) error {
+	var err error
+
+	destURL, err := util.GetFlag(c, "", true, err)
+	volumeName, err := util.GetName(c, "volume-name", false, err)
+	if err != nil {
+		return err
+	}
+
+	endpointURL := c.GlobalString("s3-endpoint")
+	request := &api.BackupGCRequest{
+		URL:        destURL,
+		Endpoint:   endpointURL,
+		VolumeName: volumeName,
+		DryRun:     c.Bool("dry-run"),
+	}
+	url := "/backups/gc"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdBackupRetention(c *cli.Context) {
+	if err := doBackupRetention(c); err != nil {
+		panic(err)
+	}
+}
+
+func doBackupRetention(c *cli.Context) error {
+	volumeName, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+
+	request := &api.BackupRetentionRequest{
+		VolumeName:  volumeName,
+		KeepLast:    c.Int("keep-last"),
+		KeepDaily:   c.Int("keep-daily"),
+		KeepWeekly:  c.Int("keep-weekly"),
+		KeepMonthly: c.Int("keep-monthly"),
+		Clear:       c.Bool("clear"),
+	}
+	url := "/backups/retention"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdBackupHooks(c *cli.Context) {
+	if err := doBackupHooks(c); err != nil {
+		panic(err)
+	}
+}
+
+func doBackupHooks(c *cli.Context) error {
+	volumeName, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+
+	request := &api.BackupHooksRequest{
+		VolumeName:  volumeName,
+		PreSnapshot: c.String("pre-snapshot"),
+		PostBackup:  c.String("post-backup"),
+		Clear:       c.Bool("clear"),
+	}
+	url := "/backups/hooks"
+	return sendRequestAndPrint("POST", url, request)
+}
+
 func cmdBackupCreate(c *cli.Context) {
 	if err := doBackupCreate(c); err != nil {
 		panic(err)
@@ -136,9 +751,35 @@ func doBackupCreate(c *cli.Context) error {
 		Endpoint:     endpointURL,
 		SnapshotName: snapshotName,
 		Verbose:      c.GlobalBool(verboseFlag),
+		Progress:     c.Bool("progress"),
+		ExportImage:  c.String("export-image"),
+		Labels:       c.StringSlice("label"),
+		DryRun:       c.Bool("dry-run"),
+		Stream:       c.String("output") != "",
 	}
 
 	url := "/backups/create"
+	if output := c.String("output"); output != "" {
+		if request.Progress {
+			return fmt.Errorf("Cannot report progress with --output")
+		}
+		if output == "-" {
+			return sendRequestAndCopyOutput("POST", url, request, os.Stdout)
+		}
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		if err := sendRequestAndCopyOutput("POST", url, request, f); err != nil {
+			f.Close()
+			os.Remove(output)
+			return err
+		}
+		return f.Close()
+	}
+	if request.Progress {
+		return sendRequestAndStreamProgress("POST", url, request)
+	}
 	return sendRequestAndPrint("POST", url, request)
 }
 
@@ -159,7 +800,82 @@ func doBackupDelete(c *cli.Context) error {
 	request := &api.BackupDeleteRequest{
 		URL:      backupURL,
 		Endpoint: endpointURL,
+		Force:    c.Bool("force"),
 	}
 	url := "/backups"
 	return sendRequestAndPrint("DELETE", url, request)
 }
+
+func cmdObjectStoreUpgrade(c *cli.Context) {
+	if err := doObjectStoreUpgrade(c); err != nil {
+		panic(err)
+	}
+}
+
+func doObjectStoreUpgrade(c *cli.Context) error {
+	var err error
+
+	destURL, err := util.GetFlag(c, "", true, err)
+	if err != nil {
+		return err
+	}
+
+	endpointURL := c.GlobalString("s3-endpoint")
+	request := &api.ObjectStoreUpgradeRequest{
+		URL:      destURL,
+		Endpoint: endpointURL,
+		DryRun:   c.Bool("dry-run"),
+	}
+	url := "/objectstore/upgrade"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdObjectStoreBreakLock(c *cli.Context) {
+	if err := doObjectStoreBreakLock(c); err != nil {
+		panic(err)
+	}
+}
+
+func doObjectStoreBreakLock(c *cli.Context) error {
+	var err error
+
+	destURL, err := util.GetFlag(c, "", true, err)
+	volumeName, err := util.GetName(c, "volume-name", false, err)
+	if err != nil {
+		return err
+	}
+
+	endpointURL := c.GlobalString("s3-endpoint")
+	request := &api.ObjectStoreBreakLockRequest{
+		URL:        destURL,
+		Endpoint:   endpointURL,
+		VolumeName: volumeName,
+	}
+	url := "/objectstore/break-lock"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdObjectStoreRotateKey(c *cli.Context) {
+	if err := doObjectStoreRotateKey(c); err != nil {
+		panic(err)
+	}
+}
+
+func doObjectStoreRotateKey(c *cli.Context) error {
+	var err error
+
+	destURL, err := util.GetFlag(c, "", true, err)
+	keyFile, err := util.GetFlag(c, "key", true, err)
+	if err != nil {
+		return err
+	}
+
+	endpointURL := c.GlobalString("s3-endpoint")
+	request := &api.ObjectStoreRotateKeyRequest{
+		URL:      destURL,
+		Endpoint: endpointURL,
+		KeyFile:  keyFile,
+	}
+	url := "/objectstore/rotate-key"
+	return sendRequestAndPrint("POST", url, request)
+}
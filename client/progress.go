@@ -0,0 +1,62 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rancher/convoy/api"
+)
+
+// sendRequestAndStreamProgress prints the progress streamed by the daemon to
+// stderr, and the result to stdout as sendRequestAndPrint does
+func sendRequestAndStreamProgress(method, request string, data interface{}) error {
+	rc, err := sendRequest(method, request, data)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	printed := false
+	decoder := json.NewDecoder(rc)
+	for {
+		response := &api.ProgressResponse{}
+		if err := decoder.Decode(response); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("Connection closed before the operation completed")
+			}
+			return err
+		}
+		if response.Progress != nil {
+			fmt.Fprintf(os.Stderr, "\r%v", formatProgress(response.Progress))
+			printed = true
+			continue
+		}
+		if printed {
+			fmt.Fprintln(os.Stderr)
+		}
+		if response.Error != "" {
+			return errors.New(response.Error)
+		}
+		fmt.Println(response.Result)
+		return nil
+	}
+}
+
+func formatProgress(p *api.Progress) string {
+	percent := 100
+	if p.TotalBlocks != 0 {
+		percent = p.ProcessedBlocks * 100 / p.TotalBlocks
+	}
+	eta := "unknown"
+	if p.ETASeconds >= 0 {
+		eta = (time.Duration(p.ETASeconds) * time.Second).String()
+	}
+	return fmt.Sprintf("%v: %3d%% (%d/%d blocks), %.1f MiB transferred, elapsed %v, ETA %v   ",
+		p.Operation, percent, p.ProcessedBlocks, p.TotalBlocks,
+		float64(p.TransferredBytes)/(1<<20),
+		time.Duration(p.ElapsedSeconds)*time.Second, eta)
+}
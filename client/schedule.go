@@ -0,0 +1,181 @@
+package client
+
+import (
+	"github.com/codegangsta/cli"
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+)
+
+var (
+	scheduleCreateCmd = cli.Command{
+		Name:  "create",
+		Usage: "back up a volume periodically: schedule create <volume>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "name",
+				Usage: "name of schedule",
+			},
+			cli.StringFlag{
+				Name:  "cron",
+				Usage: "cron expression of the time to back up, e.g. \"0 2 * * *\" or \"@daily\"",
+			},
+			cli.StringFlag{
+				Name:  "dest",
+				Usage: "destination of backup if driver supports, would be url like s3://bucket@region/path/ or vfs:///path/",
+			},
+			cli.BoolFlag{
+				Name:  "freeze",
+				Usage: "freeze the filesystem of the volume while creating the snapshot, if it's mounted",
+			},
+			cli.BoolFlag{
+				Name:  "pause-containers",
+				Usage: "pause the running docker containers using the volume while creating the snapshot",
+			},
+		},
+		Action: cmdScheduleCreate,
+	}
+
+	scheduleDeleteCmd = cli.Command{
+		Name:   "delete",
+		Usage:  "delete a schedule: schedule delete <schedule>",
+		Action: cmdScheduleDelete,
+	}
+
+	scheduleListCmd = cli.Command{
+		Name:   "list",
+		Usage:  "list schedules",
+		Action: cmdScheduleList,
+	}
+
+	snapshotScheduleCreateCmd = cli.Command{
+		Name:  "create",
+		Usage: "snapshot a volume periodically without backup: schedule create <volume>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "name",
+				Usage: "name of schedule",
+			},
+			cli.StringFlag{
+				Name:  "cron",
+				Usage: "cron expression of the time to snapshot, e.g. \"0 * * * *\" or \"@hourly\"",
+			},
+			cli.BoolFlag{
+				Name:  "freeze",
+				Usage: "freeze the filesystem of the volume while creating the snapshot, if it's mounted",
+			},
+			cli.BoolFlag{
+				Name:  "pause-containers",
+				Usage: "pause the running docker containers using the volume while creating the snapshot",
+			},
+		},
+		Action: cmdSnapshotScheduleCreate,
+	}
+
+	backupScheduleCmd = cli.Command{
+		Name:  "schedule",
+		Usage: "scheduled backup related operations",
+		Subcommands: []cli.Command{
+			scheduleCreateCmd,
+			scheduleDeleteCmd,
+			scheduleListCmd,
+		},
+	}
+
+	snapshotScheduleCmd = cli.Command{
+		Name:  "schedule",
+		Usage: "scheduled snapshot related operations",
+		Subcommands: []cli.Command{
+			snapshotScheduleCreateCmd,
+			scheduleDeleteCmd,
+			scheduleListCmd,
+		},
+	}
+)
+
+func cmdScheduleCreate(c *cli.Context) {
+	if err := doScheduleCreate(c); err != nil {
+		panic(err)
+	}
+}
+
+func doScheduleCreate(c *cli.Context) error {
+	var err error
+
+	volumeName, err := getName(c, "", true)
+	scheduleName, err := util.GetName(c, "name", false, err)
+	cron, err := util.GetFlag(c, "cron", true, err)
+	destURL, err := util.GetFlag(c, "dest", true, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.ScheduleCreateRequest{
+		Name:            scheduleName,
+		VolumeName:      volumeName,
+		Cron:            cron,
+		URL:             destURL,
+		Endpoint:        c.GlobalString("s3-endpoint"),
+		Freeze:          c.Bool("freeze"),
+		PauseContainers: c.Bool("pause-containers"),
+	}
+	url := "/schedules/create"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdSnapshotScheduleCreate(c *cli.Context) {
+	if err := doSnapshotScheduleCreate(c); err != nil {
+		panic(err)
+	}
+}
+
+func doSnapshotScheduleCreate(c *cli.Context) error {
+	var err error
+
+	volumeName, err := getName(c, "", true)
+	scheduleName, err := util.GetName(c, "name", false, err)
+	cron, err := util.GetFlag(c, "cron", true, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.ScheduleCreateRequest{
+		Name:            scheduleName,
+		VolumeName:      volumeName,
+		Cron:            cron,
+		Freeze:          c.Bool("freeze"),
+		PauseContainers: c.Bool("pause-containers"),
+		SnapshotOnly:    true,
+	}
+	url := "/schedules/create"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdScheduleDelete(c *cli.Context) {
+	if err := doScheduleDelete(c); err != nil {
+		panic(err)
+	}
+}
+
+func doScheduleDelete(c *cli.Context) error {
+	scheduleName, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+
+	request := &api.ScheduleDeleteRequest{
+		Name: scheduleName,
+	}
+	url := "/schedules"
+	return sendRequestAndPrint("DELETE", url, request)
+}
+
+func cmdScheduleList(c *cli.Context) {
+	if err := doScheduleList(c); err != nil {
+		panic(err)
+	}
+}
+
+func doScheduleList(c *cli.Context) error {
+	url := "/schedules/list"
+	return sendRequestAndPrint("GET", url, nil)
+}
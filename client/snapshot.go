@@ -1,6 +1,9 @@
 package client
 
 import (
+	"fmt"
+	"path/filepath"
+
 	"github.com/codegangsta/cli"
 	"github.com/rancher/convoy/api"
 	"github.com/rancher/convoy/util"
@@ -15,6 +18,31 @@ var (
 				Name:  "name",
 				Usage: "name of snapshot",
 			},
+			cli.BoolFlag{
+				Name:  "freeze",
+				Usage: "freeze the filesystem of the volume while creating the snapshot, if it's mounted",
+			},
+			cli.BoolFlag{
+				Name:  "pause-containers",
+				Usage: "pause the running docker containers using the volume while creating the snapshot",
+			},
+			cli.StringSliceFlag{
+				Name:  "label",
+				Value: &cli.StringSlice{},
+				Usage: "label of the snapshot in the form of <key>=<value>, e.g. app=postgres, can be specified multiple times",
+			},
+			cli.StringFlag{
+				Name:  "description",
+				Usage: "free-form description of the snapshot",
+			},
+			cli.StringFlag{
+				Name:  "compression",
+				Usage: "compression of the snapshot tarball if driver supports, in the form of <codec>[:<level>], e.g. zstd:3",
+			},
+			cli.StringFlag{
+				Name:  "ttl",
+				Usage: "remove the snapshot automatically after the duration, e.g. 12h or 7d",
+			},
 		},
 		Action: cmdSnapshotCreate,
 	}
@@ -31,6 +59,123 @@ var (
 		Action: cmdSnapshotInspect,
 	}
 
+	snapshotListCmd = cli.Command{
+		Name:  "list",
+		Usage: "list the snapshots: snapshot list",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "volume-name",
+				Usage: "only list the snapshots of the volume",
+			},
+			cli.StringSliceFlag{
+				Name:  "label",
+				Value: &cli.StringSlice{},
+				Usage: "only list the snapshots with the label in the form of <key>=<value>, can be specified multiple times",
+			},
+		},
+		Action: cmdSnapshotList,
+	}
+
+	snapshotMountCmd = cli.Command{
+		Name:  "mount",
+		Usage: "mount a snapshot read-only: snapshot mount <snapshot>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "mountpoint",
+				Usage: "mountpoint of snapshot, a temporary one would be created if it's not specified",
+			},
+		},
+		Action: cmdSnapshotMount,
+	}
+
+	snapshotUmountCmd = cli.Command{
+		Name:   "umount",
+		Usage:  "umount a snapshot: snapshot umount <snapshot>",
+		Action: cmdSnapshotUmount,
+	}
+
+	snapshotRevertCmd = cli.Command{
+		Name:   "revert",
+		Usage:  "roll the volume of a snapshot back to the snapshot in place: snapshot revert <snapshot>",
+		Action: cmdSnapshotRevert,
+	}
+
+	snapshotDiffCmd = cli.Command{
+		Name:  "diff",
+		Usage: "show the extents changed between two snapshots of a volume: snapshot diff <snapshot>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "base",
+				Usage: "the older snapshot of the same volume to compare with, all the allocated data would be shown if it's not specified",
+			},
+		},
+		Action: cmdSnapshotDiff,
+	}
+
+	snapshotExportCmd = cli.Command{
+		Name:   "export",
+		Usage:  "export a snapshot as a local sparse raw image file: snapshot export <snapshot> <file>",
+		Action: cmdSnapshotExport,
+	}
+
+	snapshotChecksumCmd = cli.Command{
+		Name:   "checksum",
+		Usage:  "compute the checksum of a snapshot and verify it against the recorded one: snapshot checksum <snapshot>",
+		Action: cmdSnapshotChecksum,
+	}
+
+	snapshotChainCmd = cli.Command{
+		Name:   "chain",
+		Usage:  "show the ancestry of the snapshots of a volume and the snapshot last backed up: snapshot chain <volume>",
+		Action: cmdSnapshotChain,
+	}
+
+	snapshotSpaceCmd = cli.Command{
+		Name:   "space",
+		Usage:  "show the space held only by each snapshot of a volume: snapshot space <volume>",
+		Action: cmdSnapshotSpace,
+	}
+
+	snapshotRetentionCmd = cli.Command{
+		Name:  "retention",
+		Usage: "set or show the retention of the snapshots of a volume: snapshot retention <volume>",
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "max-count",
+				Usage: "keep the latest N snapshots",
+			},
+			cli.StringFlag{
+				Name:  "max-age",
+				Usage: "remove the snapshots older than the duration, e.g. 12h or 7d",
+			},
+			cli.BoolFlag{
+				Name:  "clear",
+				Usage: "remove the retention, so all the snapshots would be kept",
+			},
+		},
+		Action: cmdSnapshotRetention,
+	}
+
+	snapshotQuiesceCmd = cli.Command{
+		Name:  "quiesce",
+		Usage: "set or show the commands run right around the snapshots of a volume: snapshot quiesce <volume>",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "quiesce",
+				Usage: "shell command run right before creating the snapshot, the snapshot would not be created if it fails",
+			},
+			cli.StringFlag{
+				Name:  "unquiesce",
+				Usage: "shell command run right after creating the snapshot, even if the snapshot failed",
+			},
+			cli.BoolFlag{
+				Name:  "clear",
+				Usage: "remove the quiesce hooks of the volume",
+			},
+		},
+		Action: cmdSnapshotQuiesce,
+	}
+
 	snapshotCmd = cli.Command{
 		Name:  "snapshot",
 		Usage: "snapshot related operations",
@@ -38,6 +183,18 @@ var (
 			snapshotCreateCmd,
 			snapshotDeleteCmd,
 			snapshotInspectCmd,
+			snapshotListCmd,
+			snapshotMountCmd,
+			snapshotUmountCmd,
+			snapshotRevertCmd,
+			snapshotDiffCmd,
+			snapshotExportCmd,
+			snapshotChecksumCmd,
+			snapshotChainCmd,
+			snapshotSpaceCmd,
+			snapshotRetentionCmd,
+			snapshotQuiesceCmd,
+			snapshotScheduleCmd,
 		},
 	}
 )
@@ -58,9 +215,15 @@ func doSnapshotCreate(c *cli.Context) error {
 	}
 
 	request := &api.SnapshotCreateRequest{
-		Name:       snapshotName,
-		VolumeName: volumeName,
-		Verbose:    c.GlobalBool(verboseFlag),
+		Name:            snapshotName,
+		VolumeName:      volumeName,
+		Freeze:          c.Bool("freeze"),
+		Verbose:         c.GlobalBool(verboseFlag),
+		Labels:          c.StringSlice("label"),
+		Description:     c.String("description"),
+		Compression:     c.String("compression"),
+		TTL:             c.String("ttl"),
+		PauseContainers: c.Bool("pause-containers"),
 	}
 
 	url := "/snapshots/create"
@@ -108,3 +271,248 @@ func doSnapshotInspect(c *cli.Context) error {
 	url := "/snapshots/"
 	return sendRequestAndPrint("GET", url, request)
 }
+
+func cmdSnapshotList(c *cli.Context) {
+	if err := doSnapshotList(c); err != nil {
+		panic(err)
+	}
+}
+
+func doSnapshotList(c *cli.Context) error {
+	var err error
+
+	volumeName, err := util.GetName(c, "volume-name", false, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.SnapshotListRequest{
+		VolumeName: volumeName,
+		Labels:     c.StringSlice("label"),
+	}
+	url := "/snapshots/list"
+	return sendRequestAndPrint("GET", url, request)
+}
+
+func cmdSnapshotMount(c *cli.Context) {
+	if err := doSnapshotMount(c); err != nil {
+		panic(err)
+	}
+}
+
+func doSnapshotMount(c *cli.Context) error {
+	var err error
+
+	snapshotName, err := getName(c, "", true)
+	mountPoint, err := util.GetFlag(c, "mountpoint", false, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.SnapshotMountRequest{
+		SnapshotName: snapshotName,
+		MountPoint:   mountPoint,
+	}
+	url := "/snapshots/mount"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdSnapshotUmount(c *cli.Context) {
+	if err := doSnapshotUmount(c); err != nil {
+		panic(err)
+	}
+}
+
+func doSnapshotUmount(c *cli.Context) error {
+	var err error
+
+	snapshotName, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+
+	request := &api.SnapshotUmountRequest{
+		SnapshotName: snapshotName,
+	}
+	url := "/snapshots/umount"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdSnapshotRevert(c *cli.Context) {
+	if err := doSnapshotRevert(c); err != nil {
+		panic(err)
+	}
+}
+
+func doSnapshotRevert(c *cli.Context) error {
+	var err error
+
+	snapshotName, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+
+	request := &api.SnapshotRevertRequest{
+		SnapshotName: snapshotName,
+	}
+	url := "/snapshots/revert"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdSnapshotDiff(c *cli.Context) {
+	if err := doSnapshotDiff(c); err != nil {
+		panic(err)
+	}
+}
+
+func doSnapshotDiff(c *cli.Context) error {
+	var err error
+
+	snapshotName, err := getName(c, "", true)
+	baseName, err := util.GetName(c, "base", false, err)
+	if err != nil {
+		return err
+	}
+
+	request := &api.SnapshotDiffRequest{
+		SnapshotName:        snapshotName,
+		CompareSnapshotName: baseName,
+	}
+	url := "/snapshots/diff"
+	return sendRequestAndPrint("GET", url, request)
+}
+
+func cmdSnapshotRetention(c *cli.Context) {
+	if err := doSnapshotRetention(c); err != nil {
+		panic(err)
+	}
+}
+
+func doSnapshotRetention(c *cli.Context) error {
+	volumeName, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+
+	request := &api.SnapshotRetentionRequest{
+		VolumeName: volumeName,
+		MaxCount:   c.Int("max-count"),
+		MaxAge:     c.String("max-age"),
+		Clear:      c.Bool("clear"),
+	}
+	url := "/snapshots/retention"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdSnapshotQuiesce(c *cli.Context) {
+	if err := doSnapshotQuiesce(c); err != nil {
+		panic(err)
+	}
+}
+
+func doSnapshotQuiesce(c *cli.Context) error {
+	volumeName, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+
+	request := &api.SnapshotQuiesceRequest{
+		VolumeName: volumeName,
+		Quiesce:    c.String("quiesce"),
+		Unquiesce:  c.String("unquiesce"),
+		Clear:      c.Bool("clear"),
+	}
+	url := "/snapshots/quiesce"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdSnapshotChain(c *cli.Context) {
+	if err := doSnapshotChain(c); err != nil {
+		panic(err)
+	}
+}
+
+func doSnapshotChain(c *cli.Context) error {
+	var err error
+
+	volumeName, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+
+	request := &api.SnapshotChainRequest{
+		VolumeName: volumeName,
+	}
+	url := "/snapshots/chain"
+	return sendRequestAndPrint("GET", url, request)
+}
+
+func cmdSnapshotExport(c *cli.Context) {
+	if err := doSnapshotExport(c); err != nil {
+		panic(err)
+	}
+}
+
+func doSnapshotExport(c *cli.Context) error {
+	var err error
+
+	snapshotName, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+	filePath := c.Args().Get(1)
+	if filePath == "" {
+		return fmt.Errorf("Require file path of the image")
+	}
+	// The image is written by daemon, which may run in another directory
+	if filePath, err = filepath.Abs(filePath); err != nil {
+		return err
+	}
+
+	request := &api.SnapshotExportRequest{
+		SnapshotName: snapshotName,
+		FilePath:     filePath,
+	}
+	url := "/snapshots/export"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdSnapshotChecksum(c *cli.Context) {
+	if err := doSnapshotChecksum(c); err != nil {
+		panic(err)
+	}
+}
+
+func doSnapshotChecksum(c *cli.Context) error {
+	snapshotName, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+
+	request := &api.SnapshotChecksumRequest{
+		SnapshotName: snapshotName,
+	}
+	url := "/snapshots/checksum"
+	return sendRequestAndPrint("POST", url, request)
+}
+
+func cmdSnapshotSpace(c *cli.Context) {
+	if err := doSnapshotSpace(c); err != nil {
+		panic(err)
+	}
+}
+
+func doSnapshotSpace(c *cli.Context) error {
+	var err error
+
+	volumeName, err := getName(c, "", true)
+	if err != nil {
+		return err
+	}
+
+	request := &api.SnapshotSpaceRequest{
+		VolumeName: volumeName,
+	}
+	url := "/snapshots/space"
+	return sendRequestAndPrint("GET", url, request)
+}
@@ -43,6 +43,14 @@ var (
 				Name:  "vm",
 				Usage: "Prepare volume for Rancher VM if driver supports",
 			},
+			cli.BoolFlag{
+				Name:  "progress",
+				Usage: "report the progress of restoring from backup",
+			},
+			cli.StringFlag{
+				Name:  "from-snapshot",
+				Usage: "create a volume from a local snapshot if driver supports",
+			},
 		},
 		Action: cmdVolumeCreate,
 	}
@@ -117,6 +125,7 @@ func doVolumeCreate(c *cli.Context) error {
 	size, err := getSize(c, err)
 	driverName, err := util.GetFlag(c, "driver", false, err)
 	backupURL, err := util.GetFlag(c, "backup", false, err)
+	fromSnapshot, err := util.GetName(c, "from-snapshot", false, err)
 	if err != nil {
 		return err
 	}
@@ -137,10 +146,15 @@ func doVolumeCreate(c *cli.Context) error {
 		Type:           volumeType,
 		IOPS:           int64(iops),
 		PrepareForVM:   prepareForVM,
+		FromSnapshot:   fromSnapshot,
 		Verbose:        c.GlobalBool(verboseFlag),
+		Progress:       c.Bool("progress") && backupURL != "",
 	}
 
 	url := "/volumes/create"
+	if request.Progress {
+		return sendRequestAndStreamProgress("POST", url, request)
+	}
 
 	return sendRequestAndPrint("POST", url, request)
 }
@@ -76,11 +76,116 @@ VolumeOperations.CreateVolume() with opts[OPT_BACKUP_URL]
 type BackupOperations interface {
 	Name() string
 	CreateBackup(snapshotID, volumeID, destURL, endpointURL string, opts map[string]string) (string, error)
-	DeleteBackup(backupURL, endpointURL string) error
+	DeleteBackup(backupURL, endpointURL string, opts map[string]string) error
 	GetBackupInfo(backupURL, endpointURL string) (map[string]string, error)
 	ListBackup(destURL, endpointURL string, opts map[string]string) (map[string]map[string]string, error)
 }
 
+/*
+BlockDeviceOperations is an optional interface of VolumeOperations, for the
+Convoy Drivers which provide volumes as block devices. It allows another
+driver to be layered on top of the raw device, e.g. for encryption. In that
+case the volume would be created with opts[OPT_FORMAT] set to "false", and
+the driver should leave the device unformatted.
+*/
+type BlockDeviceOperations interface {
+	AttachVolume(req Request) (string, error)
+	DetachVolume(req Request) error
+}
+
+/*
+SnapshotRevertOperations is an optional interface of SnapshotOperations, for
+the Convoy Drivers which can roll a volume back to one of its snapshots in
+place, with req.Name as the snapshot and req.Options[OPT_VOLUME_NAME] as the
+volume. The volume would be umounted before reverting, and the snapshot would
+be kept.
+*/
+type SnapshotRevertOperations interface {
+	RevertSnapshot(req Request) error
+}
+
+/*
+SnapshotCloneOperations is an optional interface of SnapshotOperations, for the
+Convoy Drivers which can create a new volume from a local snapshot directly,
+without going through objectstore. The volume req.Name would be created from
+the snapshot req.Options[OPT_SNAPSHOT_NAME] of the volume
+req.Options[OPT_SNAPSHOT_VOLUME_NAME], with the same size and filesystem.
+*/
+type SnapshotCloneOperations interface {
+	CreateVolumeFromSnapshot(req Request) error
+}
+
+/*
+SnapshotMountOperations is an optional interface of SnapshotOperations, for the
+Convoy Drivers which can mount a snapshot read-only, with req.Name as the
+snapshot, req.Options[OPT_VOLUME_NAME] as its volume and an optional
+req.Options[OPT_MOUNT_POINT]. The snapshot cannot be deleted while mounted.
+*/
+type SnapshotMountOperations interface {
+	MountSnapshot(req Request) (string, error)
+	UmountSnapshot(req Request) error
+}
+
+/*
+SnapshotSpaceOperations is an optional interface of SnapshotOperations, for the
+Convoy Drivers which can report the space held only by each snapshot of the
+volume volumeID, i.e. the space deleting the snapshot would reclaim. The sizes
+in bytes are keyed by the snapshot names.
+*/
+type SnapshotSpaceOperations interface {
+	GetSnapshotUniqueSizes(volumeID string) (map[string]int64, error)
+}
+
+/*
+BackupEstimateOperations is an optional interface of BackupOperations, for the
+Convoy Drivers which can estimate the blocks and bytes a backup would
+transfer to objectstore, without creating the backup.
+*/
+type BackupEstimateOperations interface {
+	EstimateBackup(snapshotID, volumeID, destURL, endpointURL string, opts map[string]string) (map[string]string, error)
+}
+
+/*
+BackupRestoreOperations is an optional interface of BackupOperations, for the
+Convoy Drivers which can restore a backup onto an existing volume, with
+opts[OPT_BACKUP_URL] and opts[OPT_ENDPOINT_URL]. Only the blocks differing
+from the backup the volume was last restored from would be written, so it's
+only supported if the volume still has the content of another backup of the
+same volume in the objectstore.
+*/
+type BackupRestoreOperations interface {
+	RestoreBackup(req Request) error
+}
+
+/*
+LayeredDriver is an optional interface for the Convoy Drivers built on top of
+another driver. The backups created through them would be recorded with the
+name of the underlying driver.
+*/
+type LayeredDriver interface {
+	UnderlyingDriverName() string
+}
+
+/*
+SnapshotMetadata is embedded in the snapshot configs of the drivers, to keep
+the labels, encoded by objectstore.EncodeLabels, and the description specified
+when creating the snapshot. The drivers return them in the snapshot info as
+OPT_SNAPSHOT_LABELS and OPT_SNAPSHOT_DESCRIPTION.
+*/
+type SnapshotMetadata struct {
+	Labels      string `json:",omitempty"`
+	Description string `json:",omitempty"`
+}
+
+// NewSnapshotMetadata returns the metadata in the options of the request to
+// create the snapshot
+func NewSnapshotMetadata(opts map[string]string) SnapshotMetadata {
+	return SnapshotMetadata{
+		Labels:      opts[OPT_SNAPSHOT_LABELS],
+		Description: opts[OPT_SNAPSHOT_DESCRIPTION],
+	}
+}
+
 const (
 	OPT_MOUNT_POINT           = "MountPoint"
 	OPT_SIZE                  = "Size"
@@ -92,11 +197,18 @@ const (
 	OPT_VOLUME_CREATED_TIME   = "VolumeCreatedAt"
 	OPT_SNAPSHOT_NAME         = "SnapshotName"
 	OPT_SNAPSHOT_CREATED_TIME = "SnapshotCreatedAt"
+	OPT_SNAPSHOT_VOLUME_NAME  = "SnapshotVolumeName"
+	OPT_SNAPSHOT_LABELS       = "SnapshotLabels"
+	OPT_SNAPSHOT_DESCRIPTION  = "SnapshotDescription"
+	OPT_SNAPSHOT_COMPRESSION  = "SnapshotCompression"
 	OPT_BACKUP_URL            = "BackupURL"
 	OPT_ENDPOINT_URL          = "EndpointURL"
 	OPT_REFERENCE_ONLY        = "ReferenceOnly"
 	OPT_PREPARE_FOR_VM        = "PrepareForVM"
 	OPT_FILESYSTEM            = "Filesystem"
+	OPT_FORCE                 = "Force"
+	OPT_EXPORT_IMAGE          = "ExportImage"
+	OPT_BACKUP_LABELS         = "BackupLabels"
 )
 
 var (
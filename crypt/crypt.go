@@ -0,0 +1,643 @@
+package crypt
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+)
+
+const (
+	DRIVER_NAME        = "crypt"
+	DRIVER_CONFIG_FILE = "crypt.cfg"
+
+	VOLUME_CFG_PREFIX = "volume_"
+	CFG_PREFIX        = DRIVER_NAME + "_"
+	CFG_POSTFIX       = ".json"
+
+	MOUNTS_DIR      = "mounts"
+	KEYS_DIR        = "keys"
+	BACKUP_KEYS_DIR = "backups"
+	MAPPER_PREFIX   = "convoy-crypt-"
+
+	CRYPT_DRIVER     = "crypt.driver"
+	CRYPT_KEY_DIR    = "crypt.keydir"
+	CRYPT_CIPHER     = "crypt.cipher"
+	CRYPT_DEFAULT_FS = "crypt.fs"
+
+	DEFAULT_CIPHER  = "aes-xts-plain64"
+	DEFAULT_FS_TYPE = "ext4"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "crypt"})
+)
+
+type Driver struct {
+	mutex *sync.RWMutex
+	Device
+
+	inner    ConvoyDriver
+	volOps   VolumeOperations
+	blockOps BlockDeviceOperations
+}
+
+type Device struct {
+	Root        string
+	InnerDriver string
+	KeyDir      string
+	Cipher      string
+	Filesystem  string
+}
+
+func (dev *Device) ConfigFile() (string, error) {
+	if dev.Root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty device config path")
+	}
+	return filepath.Join(dev.Root, DRIVER_CONFIG_FILE), nil
+}
+
+// Volume is a LUKS device on top of the volume with the same name of the
+// inner driver
+type Volume struct {
+	Name        string
+	MapperName  string
+	KeyFile     string
+	MountPoint  string
+	CreatedTime string
+	Filesystem  string
+
+	configPath string
+}
+
+func (v *Volume) ConfigFile() (string, error) {
+	if v.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if v.configPath == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume config path")
+	}
+	return filepath.Join(v.configPath, CFG_PREFIX+VOLUME_CFG_PREFIX+v.Name+CFG_POSTFIX), nil
+}
+
+func (v *Volume) GetDevice() (string, error) {
+	if v.MapperName == "" {
+		return "", fmt.Errorf("BUG: Invalid empty mapper name of volume %v", v.Name)
+	}
+	return mapperPath(v.MapperName), nil
+}
+
+func (v *Volume) GetMountOpts() []string {
+	return []string{}
+}
+
+func (v *Volume) GenerateDefaultMountPoint() string {
+	return filepath.Join(v.configPath, MOUNTS_DIR, v.Name)
+}
+
+func init() {
+	if err := Register(DRIVER_NAME, Init); err != nil {
+		panic(err)
+	}
+}
+
+func (d *Driver) Name() string {
+	return DRIVER_NAME
+}
+
+func (d *Driver) listVolumeNames() ([]string, error) {
+	return util.ListConfigIDs(d.Root, CFG_PREFIX+VOLUME_CFG_PREFIX, CFG_POSTFIX)
+}
+
+func (d *Driver) blankVolume(name string) *Volume {
+	return &Volume{
+		configPath: d.Root,
+		Name:       name,
+	}
+}
+
+func (d *Driver) keyFile(name string) string {
+	return filepath.Join(d.KeyDir, name+KEY_POSTFIX)
+}
+
+func (d *Driver) backupKeyFile(backupURL string) string {
+	return filepath.Join(d.KeyDir, BACKUP_KEYS_DIR, backupKeyName(backupURL))
+}
+
+func verifyConfig(root string, config map[string]string) (*Device, error) {
+	dev := &Device{
+		Root:        root,
+		InnerDriver: config[CRYPT_DRIVER],
+		KeyDir:      config[CRYPT_KEY_DIR],
+		Cipher:      config[CRYPT_CIPHER],
+		Filesystem:  config[CRYPT_DEFAULT_FS],
+	}
+	if dev.InnerDriver == "" {
+		return nil, fmt.Errorf("Driver to be encrypted must be specified by %v", CRYPT_DRIVER)
+	}
+	if dev.InnerDriver == DRIVER_NAME {
+		return nil, fmt.Errorf("Cannot encrypt %v driver itself", DRIVER_NAME)
+	}
+	if dev.KeyDir == "" {
+		dev.KeyDir = filepath.Join(root, KEYS_DIR)
+	}
+	if dev.Cipher == "" {
+		dev.Cipher = DEFAULT_CIPHER
+	}
+	if dev.Filesystem == "" {
+		dev.Filesystem = DEFAULT_FS_TYPE
+	}
+	if _, err := exec.LookPath("mkfs." + dev.Filesystem); err != nil {
+		return nil, fmt.Errorf("Unsupported filesystem type %v, cannot find mkfs.%v", dev.Filesystem, dev.Filesystem)
+	}
+	return dev, nil
+}
+
+// initInner initializes the driver to be encrypted. It has its own config
+// root under the root of crypt driver, so it can be used at the same time
+// as another instance of the driver, as long as they don't share the
+// backend storage.
+func (d *Driver) initInner(config map[string]string) error {
+	inner, err := GetDriver(d.InnerDriver, d.Root, config)
+	if err != nil {
+		return err
+	}
+	volOps, err := inner.VolumeOps()
+	if err != nil {
+		return err
+	}
+	blockOps, ok := volOps.(BlockDeviceOperations)
+	if !ok {
+		return fmt.Errorf("Driver %v doesn't provide block devices, cannot be encrypted", d.InnerDriver)
+	}
+	d.inner = inner
+	d.volOps = volOps
+	d.blockOps = blockOps
+	return nil
+}
+
+func (d *Driver) remountVolumes() error {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return err
+	}
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return err
+		}
+		if volume.MountPoint == "" {
+			continue
+		}
+		req := Request{
+			Name:    id,
+			Options: map[string]string{},
+		}
+		if _, err := d.MountVolume(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Init(root string, config map[string]string) (ConvoyDriver, error) {
+	if _, err := exec.LookPath(CRYPTSETUP_BINARY); err != nil {
+		return nil, fmt.Errorf("Cannot find %v binary, please make sure cryptsetup is installed", CRYPTSETUP_BINARY)
+	}
+
+	if err := util.MkdirIfNotExists(root); err != nil {
+		return nil, err
+	}
+	dev := &Device{
+		Root: root,
+	}
+	exists, err := util.ObjectExists(dev)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if err := util.ObjectLoad(dev); err != nil {
+			return nil, err
+		}
+	} else {
+		if dev, err = verifyConfig(root, config); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Join(dev.KeyDir, BACKUP_KEYS_DIR), 0700); err != nil {
+			return nil, err
+		}
+		if err := util.ObjectSave(dev); err != nil {
+			return nil, err
+		}
+	}
+	d := &Driver{
+		mutex:  &sync.RWMutex{},
+		Device: *dev,
+	}
+	if err := d.initInner(config); err != nil {
+		return nil, err
+	}
+	if err := d.remountVolumes(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Driver) UnderlyingDriverName() string {
+	return d.InnerDriver
+}
+
+func (d *Driver) Info() (map[string]string, error) {
+	return map[string]string{
+		"Driver":      d.Name(),
+		"Root":        d.Root,
+		"InnerDriver": d.InnerDriver,
+		"KeyDir":      d.KeyDir,
+		"Cipher":      d.Cipher,
+		"Filesystem":  d.Filesystem,
+	}, nil
+}
+
+func (d *Driver) VolumeOps() (VolumeOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) CreateVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	opts := req.Options
+
+	volume := d.blankVolume(id)
+	exists, err := util.ObjectExists(volume)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Already has volume with specific uuid %v", id)
+	}
+
+	// The backup contains the encrypted data, so the key of the volume it
+	// was taken from is needed to restore it
+	keyFile := d.keyFile(id)
+	backupURL := opts[OPT_BACKUP_URL]
+	if backupURL != "" {
+		backupKeyFile := d.backupKeyFile(backupURL)
+		if err := copyKey(backupKeyFile, keyFile); err != nil {
+			return fmt.Errorf("Cannot find the key of backup %v at %v: %v", backupURL, backupKeyFile, err)
+		}
+	} else {
+		if err := generateKey(keyFile); err != nil {
+			return err
+		}
+	}
+
+	innerOpts := map[string]string{}
+	for k, v := range opts {
+		innerOpts[k] = v
+	}
+	innerOpts[OPT_FORMAT] = "false"
+	innerReq := Request{
+		Name:    id,
+		Options: innerOpts,
+	}
+	if err := d.volOps.CreateVolume(innerReq); err != nil {
+		os.Remove(keyFile)
+		return err
+	}
+
+	volume.MapperName = MAPPER_PREFIX + id
+	volume.KeyFile = keyFile
+	volume.CreatedTime = util.Now()
+	volume.Filesystem = d.Filesystem
+	if err := d.prepareVolume(volume, backupURL == ""); err != nil {
+		if err := d.volOps.DeleteVolume(Request{Name: id, Options: map[string]string{}}); err != nil {
+			log.Errorf("Failed to cleanup volume %v of %v: %v", id, d.InnerDriver, err)
+		}
+		os.Remove(keyFile)
+		return err
+	}
+	return util.ObjectSave(volume)
+}
+
+// prepareVolume would create the LUKS header and the filesystem on the inner
+// volume if format is true, or verify the key works otherwise
+func (d *Driver) prepareVolume(volume *Volume, format bool) error {
+	req := Request{
+		Name:    volume.Name,
+		Options: map[string]string{},
+	}
+	dev, err := d.blockOps.AttachVolume(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := d.blockOps.DetachVolume(req); err != nil {
+			log.Warnf("Failed to detach volume %v of %v: %v", volume.Name, d.InnerDriver, err)
+		}
+	}()
+
+	if format {
+		log.Debugf("Formatting device %v with LUKS", dev)
+		if err := luksFormat(dev, volume.KeyFile, d.Cipher); err != nil {
+			return err
+		}
+	}
+	if err := luksOpen(dev, volume.KeyFile, volume.MapperName); err != nil {
+		return err
+	}
+	defer func() {
+		if err := luksClose(volume.MapperName); err != nil {
+			log.Warnf("Failed to close %v: %v", volume.MapperName, err)
+		}
+	}()
+
+	if format {
+		mapperDev := mapperPath(volume.MapperName)
+		log.Debugf("Formatting device %v with %v filesystem", mapperDev, d.Filesystem)
+		if _, err := util.Execute("mkfs", []string{"-t", d.Filesystem, mapperDev}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) DeleteVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if volume.MountPoint != "" {
+		return fmt.Errorf("Cannot delete volume %v, it hasn't been umounted", id)
+	}
+	if isOpened(volume.MapperName) {
+		if err := luksClose(volume.MapperName); err != nil {
+			return err
+		}
+	}
+
+	if err := d.volOps.DeleteVolume(req); err != nil {
+		return err
+	}
+	// Without the key the data cannot be recovered, unless it's kept
+	// for the inner volume left by reference only deletion
+	referenceOnly, _ := strconv.ParseBool(req.Options[OPT_REFERENCE_ONLY])
+	if !referenceOnly {
+		if err := os.Remove(volume.KeyFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return util.ObjectDelete(volume)
+}
+
+func (d *Driver) MountVolume(req Request) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+
+	innerReq := Request{
+		Name:    id,
+		Options: map[string]string{},
+	}
+	dev, err := d.blockOps.AttachVolume(innerReq)
+	if err != nil {
+		return "", err
+	}
+	if !isOpened(volume.MapperName) {
+		if err := luksOpen(dev, volume.KeyFile, volume.MapperName); err != nil {
+			return "", err
+		}
+	}
+
+	mountPoint, err := util.VolumeMount(volume, req.Options[OPT_MOUNT_POINT], false)
+	if err != nil {
+		return "", err
+	}
+	if err := util.ObjectSave(volume); err != nil {
+		return "", err
+	}
+	return mountPoint, nil
+}
+
+func (d *Driver) UmountVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	if err := util.VolumeUmount(volume); err != nil {
+		return err
+	}
+	if err := util.ObjectSave(volume); err != nil {
+		return err
+	}
+	// The plain text device is only available when mounted
+	if isOpened(volume.MapperName) {
+		if err := luksClose(volume.MapperName); err != nil {
+			return err
+		}
+	}
+	return d.blockOps.DetachVolume(Request{
+		Name:    id,
+		Options: map[string]string{},
+	})
+}
+
+func (d *Driver) MountPoint(req Request) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	return volume.MountPoint, nil
+}
+
+func (d *Driver) GetVolumeInfo(name string) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.getVolumeInfo(name)
+}
+
+// getVolumeInfo returns the information of the inner volume, along with the
+// encryption details
+func (d *Driver) getVolumeInfo(name string) (map[string]string, error) {
+	volume := d.blankVolume(name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+	info, err := d.volOps.GetVolumeInfo(name)
+	if err != nil {
+		return nil, err
+	}
+	info["InnerDriver"] = d.InnerDriver
+	info["CryptDevice"] = mapperPath(volume.MapperName)
+	info["KeyFile"] = volume.KeyFile
+	info["Cipher"] = d.Cipher
+	info[OPT_MOUNT_POINT] = volume.MountPoint
+	info[OPT_FILESYSTEM] = volume.Filesystem
+	info[OPT_VOLUME_CREATED_TIME] = volume.CreatedTime
+	return info, nil
+}
+
+func (d *Driver) ListVolume(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	volumes := make(map[string]map[string]string)
+	for _, id := range volumeIDs {
+		volumes[id], err = d.getVolumeInfo(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return volumes, nil
+}
+
+func (d *Driver) SnapshotOps() (SnapshotOperations, error) {
+	if _, err := d.inner.SnapshotOps(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Driver) CreateSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	snapOps, err := d.inner.SnapshotOps()
+	if err != nil {
+		return err
+	}
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	// The inner driver doesn't know the filesystem on top of the device
+	if volume.MountPoint != "" {
+		if err := util.Freeze(volume.MountPoint); err != nil {
+			return err
+		}
+		defer func() {
+			if err := util.UnFreeze(volume.MountPoint); err != nil {
+				log.Errorf("Failed to unfreeze %v: %v", volume.MountPoint, err)
+			}
+		}()
+	}
+	return snapOps.CreateSnapshot(req)
+}
+
+func (d *Driver) DeleteSnapshot(req Request) error {
+	snapOps, err := d.inner.SnapshotOps()
+	if err != nil {
+		return err
+	}
+	return snapOps.DeleteSnapshot(req)
+}
+
+func (d *Driver) GetSnapshotInfo(req Request) (map[string]string, error) {
+	snapOps, err := d.inner.SnapshotOps()
+	if err != nil {
+		return nil, err
+	}
+	return snapOps.GetSnapshotInfo(req)
+}
+
+func (d *Driver) ListSnapshot(opts map[string]string) (map[string]map[string]string, error) {
+	snapOps, err := d.inner.SnapshotOps()
+	if err != nil {
+		return nil, err
+	}
+	return snapOps.ListSnapshot(opts)
+}
+
+func (d *Driver) BackupOps() (BackupOperations, error) {
+	if _, err := d.inner.BackupOps(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// CreateBackup backs up the encrypted data through the inner driver, and
+// keeps a copy of the key for the backup, so it can still be restored after
+// the volume is deleted
+func (d *Driver) CreateBackup(snapshotID, volumeID, destURL, endpointURL string, opts map[string]string) (string, error) {
+	backupOps, err := d.inner.BackupOps()
+	if err != nil {
+		return "", err
+	}
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	backupURL, err := backupOps.CreateBackup(snapshotID, volumeID, destURL, endpointURL, opts)
+	if err != nil {
+		return "", err
+	}
+	if err := copyKey(volume.KeyFile, d.backupKeyFile(backupURL)); err != nil {
+		return "", fmt.Errorf("Backup %v was created but failed to save its key: %v", backupURL, err)
+	}
+	return backupURL, nil
+}
+
+func (d *Driver) DeleteBackup(backupURL, endpointURL string, opts map[string]string) error {
+	backupOps, err := d.inner.BackupOps()
+	if err != nil {
+		return err
+	}
+	if err := backupOps.DeleteBackup(backupURL, endpointURL, opts); err != nil {
+		return err
+	}
+	if err := os.Remove(d.backupKeyFile(backupURL)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (d *Driver) GetBackupInfo(backupURL, endpointURL string) (map[string]string, error) {
+	backupOps, err := d.inner.BackupOps()
+	if err != nil {
+		return nil, err
+	}
+	return backupOps.GetBackupInfo(backupURL, endpointURL)
+}
+
+func (d *Driver) ListBackup(destURL, endpointURL string, opts map[string]string) (map[string]map[string]string, error) {
+	backupOps, err := d.inner.BackupOps()
+	if err != nil {
+		return nil, err
+	}
+	return backupOps.ListBackup(destURL, endpointURL, opts)
+}
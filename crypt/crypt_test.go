@@ -0,0 +1,137 @@
+package crypt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "gopkg.in/check.v1"
+)
+
+const (
+	testBlockDriver = "cryptblocktest"
+	testFileDriver  = "cryptfiletest"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct {
+	dir string
+}
+
+var _ = Suite(&TestSuite{})
+
+// fileDriver only implements the mandatory operations, like the drivers
+// provide directories as volumes
+type fileDriver struct{}
+
+func (f *fileDriver) Name() string                                    { return testFileDriver }
+func (f *fileDriver) Info() (map[string]string, error)                { return nil, nil }
+func (f *fileDriver) VolumeOps() (VolumeOperations, error)            { return f, nil }
+func (f *fileDriver) SnapshotOps() (SnapshotOperations, error)        { return nil, nil }
+func (f *fileDriver) BackupOps() (BackupOperations, error)            { return nil, nil }
+func (f *fileDriver) CreateVolume(req Request) error                  { return nil }
+func (f *fileDriver) DeleteVolume(req Request) error                  { return nil }
+func (f *fileDriver) MountVolume(req Request) (string, error)         { return "", nil }
+func (f *fileDriver) UmountVolume(req Request) error                  { return nil }
+func (f *fileDriver) MountPoint(req Request) (string, error)          { return "", nil }
+func (f *fileDriver) GetVolumeInfo(string) (map[string]string, error) { return nil, nil }
+func (f *fileDriver) ListVolume(map[string]string) (map[string]map[string]string, error) {
+	return nil, nil
+}
+
+type blockDriver struct {
+	fileDriver
+	root string
+}
+
+func (b *blockDriver) VolumeOps() (VolumeOperations, error)     { return b, nil }
+func (b *blockDriver) AttachVolume(req Request) (string, error) { return "/dev/null", nil }
+func (b *blockDriver) DetachVolume(req Request) error           { return nil }
+
+func init() {
+	Register(testFileDriver, func(root string, config map[string]string) (ConvoyDriver, error) {
+		return &fileDriver{}, nil
+	})
+	Register(testBlockDriver, func(root string, config map[string]string) (ConvoyDriver, error) {
+		return &blockDriver{root: root}, nil
+	})
+}
+
+func (s *TestSuite) SetUpTest(c *C) {
+	var err error
+	s.dir, err = ioutil.TempDir("", "convoy-crypt")
+	c.Assert(err, IsNil)
+}
+
+func (s *TestSuite) TearDownTest(c *C) {
+	os.RemoveAll(s.dir)
+}
+
+func (s *TestSuite) TestKey(c *C) {
+	keyFile := filepath.Join(s.dir, "vol1"+KEY_POSTFIX)
+	c.Assert(generateKey(keyFile), IsNil)
+	c.Assert(generateKey(keyFile), NotNil)
+
+	info, err := os.Stat(keyFile)
+	c.Assert(err, IsNil)
+	c.Assert(info.Size(), Equals, int64(KEY_SIZE))
+	c.Assert(info.Mode().Perm(), Equals, os.FileMode(0400))
+
+	copyFile := filepath.Join(s.dir, "vol2"+KEY_POSTFIX)
+	c.Assert(copyKey(keyFile, copyFile), IsNil)
+	key, err := ioutil.ReadFile(keyFile)
+	c.Assert(err, IsNil)
+	copied, err := ioutil.ReadFile(copyFile)
+	c.Assert(err, IsNil)
+	c.Assert(copied, DeepEquals, key)
+
+	c.Assert(copyKey(filepath.Join(s.dir, "nonexistent"), copyFile), NotNil)
+}
+
+func (s *TestSuite) TestBackupKeyName(c *C) {
+	name := backupKeyName("s3://bucket@us-west-2/backup?backup=backup-1&volume=vol1")
+	c.Assert(name, HasLen, 64+len(KEY_POSTFIX))
+	c.Assert(name, Equals, backupKeyName("s3://bucket@us-west-2/backup?backup=backup-1&volume=vol1"))
+	c.Assert(name, Not(Equals), backupKeyName("s3://bucket@us-west-2/backup?backup=backup-2&volume=vol1"))
+}
+
+func (s *TestSuite) TestVerifyConfig(c *C) {
+	_, err := verifyConfig(s.dir, map[string]string{})
+	c.Assert(err, ErrorMatches, "Driver to be encrypted must be specified by crypt.driver")
+	_, err = verifyConfig(s.dir, map[string]string{CRYPT_DRIVER: DRIVER_NAME})
+	c.Assert(err, ErrorMatches, "Cannot encrypt crypt driver itself")
+	_, err = verifyConfig(s.dir, map[string]string{
+		CRYPT_DRIVER:     testBlockDriver,
+		CRYPT_DEFAULT_FS: "nonexistentfs",
+	})
+	c.Assert(err, ErrorMatches, "Unsupported filesystem type nonexistentfs.*")
+
+	dev, err := verifyConfig(s.dir, map[string]string{CRYPT_DRIVER: testBlockDriver})
+	if err != nil {
+		c.Skip("mkfs.ext4 is not available")
+	}
+	c.Assert(dev.KeyDir, Equals, filepath.Join(s.dir, KEYS_DIR))
+	c.Assert(dev.Cipher, Equals, DEFAULT_CIPHER)
+	c.Assert(dev.Filesystem, Equals, DEFAULT_FS_TYPE)
+}
+
+func (s *TestSuite) TestInitInner(c *C) {
+	d := &Driver{
+		mutex: &sync.RWMutex{},
+		Device: Device{
+			Root:        s.dir,
+			InnerDriver: testFileDriver,
+		},
+	}
+	err := d.initInner(map[string]string{})
+	c.Assert(err, ErrorMatches, "Driver cryptfiletest doesn't provide block devices, cannot be encrypted")
+
+	d.InnerDriver = testBlockDriver
+	c.Assert(d.initInner(map[string]string{}), IsNil)
+	c.Assert(d.inner.(*blockDriver).root, Equals, filepath.Join(s.dir, testBlockDriver))
+	c.Assert(d.UnderlyingDriverName(), Equals, testBlockDriver)
+}
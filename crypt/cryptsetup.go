@@ -0,0 +1,89 @@
+package crypt
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	CRYPTSETUP_BINARY = "cryptsetup"
+
+	MAPPER_DIR = "/dev/mapper"
+
+	KEY_SIZE    = 64
+	KEY_POSTFIX = ".key"
+)
+
+func cryptsetup(args ...string) error {
+	_, err := util.Execute(CRYPTSETUP_BINARY, args)
+	return err
+}
+
+func luksFormat(dev, keyFile, cipher string) error {
+	return cryptsetup("-q", "luksFormat", "--cipher", cipher, "--key-file", keyFile, dev)
+}
+
+func luksOpen(dev, keyFile, name string) error {
+	return cryptsetup("luksOpen", "--key-file", keyFile, dev, name)
+}
+
+func luksClose(name string) error {
+	return cryptsetup("luksClose", name)
+}
+
+func mapperPath(name string) string {
+	return filepath.Join(MAPPER_DIR, name)
+}
+
+func isOpened(name string) bool {
+	_, err := os.Stat(mapperPath(name))
+	return err == nil
+}
+
+// generateKey writes a random key to file, which must not exist
+func generateKey(file string) error {
+	key := make([]byte, KEY_SIZE)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	return writeKey(file, key)
+}
+
+func writeKey(file string, key []byte) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0400)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(key); err != nil {
+		f.Close()
+		os.Remove(file)
+		return err
+	}
+	return f.Close()
+}
+
+func copyKey(src, dst string) error {
+	key, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		return fmt.Errorf("Invalid empty key file %v", src)
+	}
+	return writeKey(dst, key)
+}
+
+// backupKeyName returns the file name of the key for a backup. The backup
+// URL can contain any characters, so it's hashed.
+func backupKeyName(backupURL string) string {
+	sum := sha256.Sum256([]byte(backupURL))
+	return hex.EncodeToString(sum[:]) + KEY_POSTFIX
+}
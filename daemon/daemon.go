@@ -8,12 +8,16 @@ import (
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
 	"github.com/gorilla/mux"
 	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/driverplugin"
+	"github.com/rancher/convoy/objectstore"
 	"github.com/rancher/convoy/util"
 
 	. "github.com/rancher/convoy/convoydriver"
@@ -27,6 +31,21 @@ type daemon struct {
 	NameUUIDIndex       *util.Index
 	SnapshotVolumeIndex *util.Index
 	daemonConfig
+
+	scheduler *scheduler
+	// Guards the configs of the groups
+	groupMutex *sync.Mutex
+	// Guards volumeConfigLocks, which guard the configs of the volumes, see
+	// updateVolumeConfig
+	volumeConfigMutex *sync.Mutex
+	volumeConfigLocks map[string]*sync.Mutex
+	// Caps the snapshot and backup operations running at the same time
+	operations *operationLimiter
+	// The snapshots being backed up or queued for it
+	snapshotUsers *snapshotUsers
+	// The mount points returned to Docker must be in it, when running as a
+	// Docker managed plugin
+	propagatedMount string
 }
 
 const (
@@ -45,13 +64,32 @@ var (
 )
 
 type daemonConfig struct {
-	Root                string
-	DriverList          []string
-	DefaultDriver       string
-	MountNamespaceFD    string
-	IgnoreDockerDelete  bool
-	CreateOnDockerMount bool
-	CmdTimeout          string
+	Root                 string
+	DriverList           []string
+	DefaultDriver        string
+	DriverPlugins        map[string]string
+	MountNamespaceFD     string
+	IgnoreDockerDelete   bool
+	CreateOnDockerMount  bool
+	CmdTimeout           string
+	BackupWorkers        int
+	RestoreWorkers       int
+	BackupReadLimit      string
+	BackupReadIOPS       int
+	BackupCompression    string
+	BackupBlockSize      string
+	BackupSharedBlocks   bool
+	BackupHash           string
+	BackupChunking       string
+	BackupKeys           []string
+	ReadOnlyObjectStores []string
+	UploadLimits         []string
+	DownloadLimits       []string
+	BackupCopies         []string
+	ObjectStoreLockTTL   string
+	DockerHost           string `json:",omitempty"`
+	// Zero means unlimited
+	MaxConcurrentOperations int `json:",omitempty"`
 }
 
 func (c *daemonConfig) ConfigFile() (string, error) {
@@ -69,20 +107,53 @@ func createRouter(s *daemon) *mux.Router {
 			"/volumes/list":    s.doVolumeList,
 			"/volumes/":        s.doVolumeInspect,
 			"/snapshots/":      s.doSnapshotInspect,
+			"/snapshots/diff":  s.doSnapshotDiff,
+			"/snapshots/list":  s.doSnapshotList,
+			"/snapshots/chain": s.doSnapshotChain,
+			"/snapshots/space": s.doSnapshotSpace,
 			"/backups/list":    s.doBackupList,
 			"/backups/inspect": s.doBackupInspect,
+			"/backups/stats":   s.doBackupStats,
+			"/schedules/list":  s.doScheduleList,
+			"/groups/list":     s.doGroupList,
 		},
 		"POST": {
-			"/volumes/create":   s.doVolumeCreate,
-			"/volumes/mount":    s.doVolumeMount,
-			"/volumes/umount":   s.doVolumeUmount,
-			"/snapshots/create": s.doSnapshotCreate,
-			"/backups/create":   s.doBackupCreate,
+			"/volumes/create":         s.doVolumeCreate,
+			"/volumes/mount":          s.doVolumeMount,
+			"/volumes/umount":         s.doVolumeUmount,
+			"/snapshots/create":       s.doSnapshotCreate,
+			"/snapshots/revert":       s.doSnapshotRevert,
+			"/snapshots/mount":        s.doSnapshotMount,
+			"/snapshots/umount":       s.doSnapshotUmount,
+			"/snapshots/export":       s.doSnapshotExport,
+			"/snapshots/checksum":     s.doSnapshotChecksum,
+			"/snapshots/retention":    s.doSnapshotRetention,
+			"/snapshots/quiesce":      s.doSnapshotQuiesce,
+			"/backups/create":         s.doBackupCreate,
+			"/backups/retrieve":       s.doBackupRetrieve,
+			"/backups/restore":        s.doBackupRestore,
+			"/backups/gc":             s.doBackupGC,
+			"/backups/retention":      s.doBackupRetention,
+			"/backups/hooks":          s.doBackupHooks,
+			"/schedules/create":       s.doScheduleCreate,
+			"/groups/create":          s.doGroupCreate,
+			"/groups/snapshot":        s.doGroupSnapshot,
+			"/backups/verify":         s.doBackupVerify,
+			"/backups/repair":         s.doBackupRepair,
+			"/backups/export":         s.doBackupExport,
+			"/backups/extract":        s.doBackupExtract,
+			"/backups/import":         s.doBackupImport,
+			"/backups/replicate":      s.doBackupReplicate,
+			"/objectstore/upgrade":    s.doObjectStoreUpgrade,
+			"/objectstore/break-lock": s.doObjectStoreBreakLock,
+			"/objectstore/rotate-key": s.doObjectStoreRotateKey,
 		},
 		"DELETE": {
 			"/volumes/":   s.doVolumeDelete,
 			"/snapshots/": s.doSnapshotDelete,
 			"/backups":    s.doBackupDelete,
+			"/schedules":  s.doScheduleDelete,
+			"/groups":     s.doGroupDelete,
 		},
 	}
 	for method, routes := range m {
@@ -263,7 +334,10 @@ func Start(sockFile string, c *cli.Context) error {
 
 	root := c.String("root")
 	s := &daemon{
-		ConvoyDrivers: make(map[string]ConvoyDriver),
+		ConvoyDrivers:     make(map[string]ConvoyDriver),
+		volumeConfigMutex: &sync.Mutex{},
+		volumeConfigLocks: make(map[string]*sync.Mutex),
+		snapshotUsers:     newSnapshotUsers(),
 	}
 	config := &daemonConfig{
 		Root: root,
@@ -300,9 +374,31 @@ func Start(sockFile string, c *cli.Context) error {
 
 		config.DriverList = driverList
 		config.DefaultDriver = driverList[0]
+		pluginList := c.StringSlice("driver-plugins")
+		config.DriverPlugins = util.SliceToMap(pluginList)
+		if config.DriverPlugins == nil {
+			return fmt.Errorf("Invalid driver plugins %v, should be in the form of <name>=<path>", pluginList)
+		}
 		config.IgnoreDockerDelete = c.Bool("ignore-docker-delete")
 		config.CreateOnDockerMount = c.Bool("create-on-docker-mount")
 		config.CmdTimeout = c.String("cmd-timeout")
+		config.BackupWorkers = c.Int("backup-workers")
+		config.RestoreWorkers = c.Int("restore-workers")
+		config.BackupReadLimit = c.String("backup-read-limit")
+		config.BackupReadIOPS = c.Int("backup-read-iops")
+		config.BackupCompression = c.String("backup-compression")
+		config.BackupBlockSize = c.String("backup-block-size")
+		config.BackupSharedBlocks = c.Bool("backup-shared-blocks")
+		config.BackupHash = c.String("backup-hash")
+		config.BackupChunking = c.String("backup-chunking")
+		config.BackupKeys = c.StringSlice("backup-keys")
+		config.ReadOnlyObjectStores = c.StringSlice("readonly-objectstores")
+		config.UploadLimits = c.StringSlice("objectstore-upload-limits")
+		config.DownloadLimits = c.StringSlice("objectstore-download-limits")
+		config.BackupCopies = c.StringSlice("backup-copies")
+		config.ObjectStoreLockTTL = c.String("objectstore-lock-ttl")
+		config.DockerHost = c.String("docker-host")
+		config.MaxConcurrentOperations = c.Int("max-concurrent-operations")
 	}
 
 	s.daemonConfig = *config
@@ -313,6 +409,110 @@ func Start(sockFile string, c *cli.Context) error {
 
 	util.InitTimeout(config.CmdTimeout)
 
+	if config.BackupWorkers != 0 {
+		if err := objectstore.SetBackupWorkers(config.BackupWorkers); err != nil {
+			return err
+		}
+	}
+	if config.RestoreWorkers != 0 {
+		if err := objectstore.SetRestoreWorkers(config.RestoreWorkers); err != nil {
+			return err
+		}
+	}
+	if config.BackupReadLimit != "" || config.BackupReadIOPS != 0 {
+		rate := int64(0)
+		if config.BackupReadLimit != "" {
+			var err error
+			if rate, err = util.ParseSize(config.BackupReadLimit); err != nil {
+				return err
+			}
+		}
+		if err := objectstore.SetSnapshotReadLimits(rate, config.BackupReadIOPS); err != nil {
+			return err
+		}
+	}
+	if config.BackupCompression != "" {
+		if err := objectstore.SetDefaultCompression(config.BackupCompression); err != nil {
+			return err
+		}
+	}
+	if config.BackupBlockSize != "" {
+		blockSize, err := util.ParseSize(config.BackupBlockSize)
+		if err != nil {
+			return err
+		}
+		if err := objectstore.SetDefaultBlockSize(blockSize); err != nil {
+			return err
+		}
+	}
+	objectstore.SetDefaultSharedBlocks(config.BackupSharedBlocks)
+	if config.BackupHash != "" {
+		if err := objectstore.SetDefaultHash(config.BackupHash); err != nil {
+			return err
+		}
+	}
+	if config.BackupChunking != "" {
+		if err := objectstore.SetDefaultChunking(config.BackupChunking); err != nil {
+			return err
+		}
+	}
+	if err := objectstore.SetEncryptionKeys(config.BackupKeys); err != nil {
+		return err
+	}
+
+	for _, destURL := range config.ReadOnlyObjectStores {
+		if err := objectstore.RegisterReadOnly(destURL); err != nil {
+			return err
+		}
+	}
+	if err := registerBandwidthLimits(objectstore.BANDWIDTH_UPLOAD, config.UploadLimits); err != nil {
+		return err
+	}
+	if err := registerBandwidthLimits(objectstore.BANDWIDTH_DOWNLOAD, config.DownloadLimits); err != nil {
+		return err
+	}
+	if config.ObjectStoreLockTTL != "" {
+		ttl, err := time.ParseDuration(config.ObjectStoreLockTTL)
+		if err != nil {
+			return err
+		}
+		if err := objectstore.SetLeaseTTL(ttl); err != nil {
+			return err
+		}
+	}
+	for _, spec := range config.BackupCopies {
+		destURL, copyURL, err := objectstore.ParseBackupCopy(spec)
+		if err != nil {
+			return err
+		}
+		if err := objectstore.RegisterBackupCopy(destURL, copyURL); err != nil {
+			return err
+		}
+	}
+
+	operations, err := newOperationLimiter(config.MaxConcurrentOperations)
+	if err != nil {
+		return err
+	}
+	s.operations = operations
+
+	// The plugin options depend on how the daemon is started rather than the
+	// saved config, the same as the socket
+	pluginSockFile := c.String("plugin-socket")
+	if s.propagatedMount = c.String("propagated-mount"); s.propagatedMount != "" {
+		if !filepath.IsAbs(s.propagatedMount) {
+			return fmt.Errorf("Invalid propagated mount %v, should be an absolute path", s.propagatedMount)
+		}
+		s.propagatedMount = filepath.Clean(s.propagatedMount)
+	}
+
+	for name, path := range config.DriverPlugins {
+		if err := driverplugin.RegisterPlugin(name, path); err != nil {
+			return err
+		}
+	}
+	defer driverplugin.Shutdown()
+
 	// driverOpts would be ignored by Convoy Drivers if config already exists
 	driverOpts := util.SliceToMap(c.StringSlice("driver-opts"))
 	if err := s.initDrivers(driverOpts); err != nil {
@@ -325,28 +525,35 @@ func Start(sockFile string, c *cli.Context) error {
 		return err
 	}
 
-	s.Router = createRouter(s)
-
-	if err := util.MkdirIfNotExists(filepath.Dir(sockFile)); err != nil {
+	s.scheduler = newScheduler()
+	s.groupMutex = &sync.Mutex{}
+	if err := s.loadSchedules(); err != nil {
 		return err
 	}
-	// This should be safe because lock file prevent starting daemon twice
-	if _, err := os.Stat(sockFile); err == nil {
-		log.Warnf("Remove previous sockfile at %v", sockFile)
-		if err := os.Remove(sockFile); err != nil {
-			return err
-		}
-	}
+	defer s.stopSchedules()
+	stopPruning := s.startSnapshotPruning()
+	defer stopPruning()
 
-	l, err := net.Listen("unix", sockFile)
+	s.Router = createRouter(s)
+
+	l, err := listenUnixSocket(sockFile)
 	if err != nil {
 		fmt.Println("listen err", err)
 		return err
 	}
 	defer l.Close()
+	listeners := []net.Listener{l}
+	if pluginSockFile != "" && pluginSockFile != sockFile {
+		pl, err := listenUnixSocket(pluginSockFile)
+		if err != nil {
+			return err
+		}
+		defer pl.Close()
+		listeners = append(listeners, pl)
+	}
 
 	sigs := make(chan os.Signal, 1)
-	done := make(chan bool, 1)
+	done := make(chan bool, len(listeners)+1)
 	signal.Notify(sigs, os.Interrupt, os.Kill, syscall.SIGTERM)
 	go func() {
 		sig := <-sigs
@@ -354,18 +561,46 @@ func Start(sockFile string, c *cli.Context) error {
 		done <- true
 	}()
 
-	go func() {
-		err = http.Serve(l, s.Router)
-		if err != nil {
-			log.Error("http server error", err.Error())
-		}
-		done <- true
-	}()
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			if err := http.Serve(l, s.Router); err != nil {
+				log.Error("http server error", err.Error())
+			}
+			done <- true
+		}(l)
+	}
 
 	<-done
 	return nil
 }
 
+func listenUnixSocket(sockFile string) (net.Listener, error) {
+	if err := util.MkdirIfNotExists(filepath.Dir(sockFile)); err != nil {
+		return nil, err
+	}
+	// This should be safe because lock file prevent starting daemon twice
+	if _, err := os.Stat(sockFile); err == nil {
+		log.Warnf("Remove previous sockfile at %v", sockFile)
+		if err := os.Remove(sockFile); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", sockFile)
+}
+
+func registerBandwidthLimits(direction string, limits []string) error {
+	for _, spec := range limits {
+		destURL, rate, err := objectstore.ParseBandwidthLimit(spec)
+		if err != nil {
+			return err
+		}
+		if err := objectstore.RegisterBandwidthLimit(destURL, direction, rate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *daemon) getDriver(driverName string) (ConvoyDriver, error) {
 	driver, exists := s.ConvoyDrivers[driverName]
 	if !exists {
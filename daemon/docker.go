@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/rancher/convoy/api"
 	. "github.com/rancher/convoy/convoydriver"
@@ -107,6 +108,24 @@ func (s *daemon) getDockerVolume(r *http.Request) (*Volume, *pluginRequest, erro
 	return volume, request, nil
 }
 
+/*
+checkPropagatedMountPoint makes sure Docker can see the mount point when the
+daemon runs as a Docker managed plugin. The mounts in the plugin are only
+visible to Docker under the propagated mount of the plugin, so the volume
+mounted anywhere else, e.g. the directory of vfs.path, would show up as an
+empty directory in the containers rather than the content of the volume.
+*/
+func (s *daemon) checkPropagatedMountPoint(volume *Volume, mountPoint string) error {
+	if s.propagatedMount == "" {
+		return nil
+	}
+	if mountPoint != s.propagatedMount && !strings.HasPrefix(mountPoint, s.propagatedMount+"/") {
+		return fmt.Errorf("Volume %v is mounted at %v, which is not in the propagated mount %v of the plugin and cannot be seen by Docker",
+			volume.Name, mountPoint, s.propagatedMount)
+	}
+	return nil
+}
+
 func dockerResponse(w http.ResponseWriter, mountPoint string, err error) {
 	e := pluginResponse{
 		Mountpoint: mountPoint,
@@ -213,6 +232,10 @@ func (s *daemon) dockerMountVolume(w http.ResponseWriter, r *http.Request) {
 		dockerResponse(w, "", err)
 		return
 	}
+	if err := s.checkPropagatedMountPoint(volume, mountPoint); err != nil {
+		dockerResponse(w, "", err)
+		return
+	}
 
 	dockerResponse(w, mountPoint, nil)
 }
@@ -261,6 +284,12 @@ func (s *daemon) dockerVolumePath(w http.ResponseWriter, r *http.Request) {
 		dockerResponse(w, "", err)
 		return
 	}
+	if mountPoint != "" {
+		if err := s.checkPropagatedMountPoint(volume, mountPoint); err != nil {
+			dockerResponse(w, "", err)
+			return
+		}
+	}
 	log.Debugf("Volume: %v is mounted at %v for docker", volume.Name, mountPoint)
 
 	dockerResponse(w, mountPoint, nil)
@@ -0,0 +1,206 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_DOCKER_HOST = "unix:///var/run/docker.sock"
+
+	DOCKER_API_TIMEOUT = 30 * time.Second
+)
+
+type dockerMount struct {
+	Type        string
+	Name        string
+	Source      string
+	Destination string
+	Driver      string
+}
+
+type dockerContainer struct {
+	Id     string
+	Names  []string
+	Mounts []dockerMount
+}
+
+func (c *dockerContainer) name() string {
+	if len(c.Names) == 0 {
+		return c.Id
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
+
+// usesVolume checks the container mounts the volume, either as the docker
+// volume of the plugin, or by the mount point on the host
+func (c *dockerContainer) usesVolume(volumeName, mountPoint string) bool {
+	for _, m := range c.Mounts {
+		if mountPoint != "" && m.Source == mountPoint {
+			return true
+		}
+		if m.Type == "volume" && m.Name == volumeName && m.Driver != "local" {
+			return true
+		}
+	}
+	return false
+}
+
+// dockerClient calls the Docker Engine API at the host, either
+// unix://<socket> or tcp://<address>
+type dockerClient struct {
+	client  *http.Client
+	baseURL string
+}
+
+func newDockerClient(host string) (*dockerClient, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid docker host %v: %v", host, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		sockFile := u.Path
+		return &dockerClient{
+			client: &http.Client{
+				Timeout: DOCKER_API_TIMEOUT,
+				Transport: &http.Transport{
+					Dial: func(_, _ string) (net.Conn, error) {
+						return net.DialTimeout("unix", sockFile, 10*time.Second)
+					},
+				},
+			},
+			// The host is ignored when dialing the socket
+			baseURL: "http://docker",
+		}, nil
+	case "tcp", "http":
+		return &dockerClient{
+			client:  &http.Client{Timeout: DOCKER_API_TIMEOUT},
+			baseURL: "http://" + u.Host,
+		}, nil
+	}
+	return nil, fmt.Errorf("Unsupported docker host %v, should be unix://<socket> or tcp://<address>", host)
+}
+
+func (c *dockerClient) call(method, path string, query url.Values, out interface{}) error {
+	u := c.baseURL + path
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to call docker: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		dockerErr := struct {
+			Message string `json:"message"`
+		}{}
+		if json.Unmarshal(body, &dockerErr) != nil || dockerErr.Message == "" {
+			dockerErr.Message = strings.TrimSpace(string(body))
+		}
+		return fmt.Errorf("Docker responded %v to %v %v: %v", resp.StatusCode, method, path, dockerErr.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
+
+func (c *dockerClient) listRunningContainers() ([]dockerContainer, error) {
+	filters, err := json.Marshal(map[string][]string{
+		"status": {"running"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	containers := []dockerContainer{}
+	if err := c.call("GET", "/containers/json", url.Values{"filters": {string(filters)}}, &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+func (c *dockerClient) pause(id string) error {
+	return c.call("POST", "/containers/"+id+"/pause", nil, nil)
+}
+
+func (c *dockerClient) unpause(id string) error {
+	return c.call("POST", "/containers/"+id+"/unpause", nil, nil)
+}
+
+func (s *daemon) getDockerHost() string {
+	if s.DockerHost == "" {
+		return DEFAULT_DOCKER_HOST
+	}
+	return s.DockerHost
+}
+
+/*
+pauseContainers pauses the running containers using any of the volumes by the
+Docker API, so the files wouldn't be changed by them during the snapshots,
+which makes the snapshots crash consistent without the hooks of the
+applications. The writes cached by the filesystem are still to be flushed,
+e.g. by freezing it. Only the containers paused here would be unpaused by
+the returned function, the ones paused by the users are left as they are.
+*/
+func (s *daemon) pauseContainers(volumes []*Volume) (func(), error) {
+	client, err := newDockerClient(s.getDockerHost())
+	if err != nil {
+		return nil, err
+	}
+	containers, err := client.listRunningContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	paused := []dockerContainer{}
+	unpause := func() {
+		for i := len(paused) - 1; i >= 0; i-- {
+			c := paused[i]
+			if err := client.unpause(c.Id); err != nil {
+				log.Errorf("Failed to unpause container %v: %v", c.name(), err)
+				continue
+			}
+			log.Debugf("Unpaused container %v", c.name())
+		}
+	}
+	pausing := map[string]bool{}
+	for _, volume := range volumes {
+		mountPoint, err := s.getVolumeMountPoint(volume)
+		if err != nil {
+			unpause()
+			return nil, err
+		}
+		for _, c := range containers {
+			if pausing[c.Id] || !c.usesVolume(volume.Name, mountPoint) {
+				continue
+			}
+			pausing[c.Id] = true
+			log.Debugf("Pausing container %v using volume %v", c.name(), volume.Name)
+			if err := client.pause(c.Id); err != nil {
+				unpause()
+				return nil, fmt.Errorf("Failed to pause container %v using volume %v: %v", c.name(), volume.Name, err)
+			}
+			paused = append(paused, c)
+		}
+	}
+	if len(paused) == 0 {
+		log.Debugf("No running container uses the volumes to be paused")
+	}
+	return unpause, nil
+}
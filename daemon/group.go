@@ -0,0 +1,390 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	GROUP_CFG_PREFIX = "group_"
+
+	// The label added to the snapshots of the members of a group snapshot,
+	// whose value is the name of the group snapshot
+	GROUP_SNAPSHOT_LABEL = "convoy/group-snapshot"
+)
+
+/*
+Group is a consistency group of volumes, e.g. the data and the WAL volumes of
+a database, which would be snapshotted together by the group snapshot so the
+snapshots of the members are consistent with each other. The group snapshots
+are recorded with the snapshots of the members, named as
+<group snapshot>-<volume>.
+*/
+type Group struct {
+	Name        string
+	VolumeNames []string
+	Snapshots   []GroupSnapshot `json:",omitempty"`
+
+	configPath string
+}
+
+type GroupSnapshot struct {
+	Name        string
+	CreatedTime string
+	// The snapshot of each member volume
+	Snapshots map[string]string
+}
+
+func (g *Group) ConfigFile() (string, error) {
+	if g.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty group name")
+	}
+	if g.configPath == "" {
+		return "", fmt.Errorf("BUG: Invalid empty group config path")
+	}
+	return filepath.Join(g.configPath, GROUP_CFG_PREFIX+g.Name+CFG_POSTFIX), nil
+}
+
+func (s *daemon) loadGroup(name string) (*Group, error) {
+	if err := util.CheckName(name); err != nil {
+		return nil, err
+	}
+	group := &Group{
+		Name:       name,
+		configPath: s.Root,
+	}
+	if err := util.ObjectLoad(group); err != nil {
+		if util.IsNotExistsError(err) {
+			return nil, fmt.Errorf("Group %v doesn't exist", name)
+		}
+		return nil, err
+	}
+	return group, nil
+}
+
+func (s *daemon) doGroupCreate(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.GroupCreateRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	if request.Name == "" {
+		return fmt.Errorf("Missing name of group")
+	}
+	if err := util.CheckName(request.Name); err != nil {
+		return err
+	}
+	if len(request.VolumeNames) == 0 {
+		return fmt.Errorf("Missing volumes of group %v", request.Name)
+	}
+	members := map[string]bool{}
+	for _, volumeName := range request.VolumeNames {
+		if members[volumeName] {
+			return fmt.Errorf("Volume %v is specified more than once", volumeName)
+		}
+		if err := util.CheckName(volumeName); err != nil {
+			return err
+		}
+		if s.getVolume(volumeName) == nil {
+			return fmt.Errorf("volume %v doesn't exist", volumeName)
+		}
+		members[volumeName] = true
+	}
+
+	group := &Group{
+		Name:        request.Name,
+		VolumeNames: request.VolumeNames,
+		configPath:  s.Root,
+	}
+
+	s.groupMutex.Lock()
+	defer s.groupMutex.Unlock()
+
+	exists, err := util.ObjectExists(group)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Group %v already exists", group.Name)
+	}
+	if err := util.ObjectSave(group); err != nil {
+		return err
+	}
+	log.Debugf("Created group %v of volumes %v", group.Name, group.VolumeNames)
+	return writeStringResponse(w, group.Name)
+}
+
+// doGroupDelete removes the group, the snapshots of the members are kept
+func (s *daemon) doGroupDelete(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.GroupDeleteRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+
+	s.groupMutex.Lock()
+	defer s.groupMutex.Unlock()
+
+	group, err := s.loadGroup(request.Name)
+	if err != nil {
+		return err
+	}
+	if err := util.ObjectDelete(group); err != nil {
+		return err
+	}
+	log.Debugf("Removed group %v", request.Name)
+	return nil
+}
+
+func (s *daemon) doGroupList(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	s.groupMutex.Lock()
+	defer s.groupMutex.Unlock()
+
+	names, err := util.ListConfigIDs(s.Root, GROUP_CFG_PREFIX, CFG_POSTFIX)
+	if err != nil {
+		return err
+	}
+	result := map[string]*Group{}
+	for _, name := range names {
+		group, err := s.loadGroup(name)
+		if err != nil {
+			return err
+		}
+		result[name] = group
+	}
+	return writeResponseOutput(w, result)
+}
+
+func (s *daemon) doGroupSnapshot(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.GroupSnapshotRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	groupSnapshot, err := s.processGroupSnapshot(request)
+	if err != nil {
+		return err
+	}
+	return writeResponseOutput(w, groupSnapshot)
+}
+
+type groupMember struct {
+	volume  *Volume
+	snapOps SnapshotOperations
+	req     Request
+}
+
+/*
+processGroupSnapshot snapshots all the volumes of the group as close to
+atomically as the drivers allow: the pre-snapshot and the quiesce hooks of
+every member run first, then the filesystems of all the mounted members are
+frozen together while the snapshots are created one after another, so no write
+could reach any member between the first and the last snapshot, unless
+request.NoFreeze is set. Only the members mounted from their own block devices
+can be frozen, see freezeVolume, the others are skipped with a warning. If any
+snapshot failed, the ones already created would be removed, so either all the
+members have the snapshot or none has.
+*/
+func (s *daemon) processGroupSnapshot(request *api.GroupSnapshotRequest) (*GroupSnapshot, error) {
+	s.groupMutex.Lock()
+	defer s.groupMutex.Unlock()
+
+	group, err := s.loadGroup(request.GroupName)
+	if err != nil {
+		return nil, err
+	}
+
+	name := request.Name
+	if name != "" {
+		if err := util.CheckName(name); err != nil {
+			return nil, err
+		}
+		for _, snapshot := range group.Snapshots {
+			if snapshot.Name == name {
+				return nil, fmt.Errorf("Group snapshot %v already exists", name)
+			}
+		}
+	} else {
+		name = util.GenerateName("groupsnap")
+	}
+
+	labels, err := objectstore.ParseLabels(request.Labels)
+	if err != nil {
+		return nil, err
+	}
+	labels[GROUP_SNAPSHOT_LABEL] = name
+
+	members := []*groupMember{}
+	for _, volumeName := range group.VolumeNames {
+		volume := s.getVolume(volumeName)
+		if volume == nil {
+			return nil, fmt.Errorf("volume %v of group %v doesn't exist", volumeName, group.Name)
+		}
+		snapOps, err := s.getSnapshotOpsForVolume(volume)
+		if err != nil {
+			return nil, err
+		}
+		snapshotName := name + "-" + volumeName
+		if err := util.CheckName(snapshotName); err != nil {
+			return nil, err
+		}
+		if s.NameUUIDIndex.Get(snapshotName) != "" {
+			return nil, fmt.Errorf("Snapshot name %v already exists", snapshotName)
+		}
+		members = append(members, &groupMember{
+			volume:  volume,
+			snapOps: snapOps,
+			req: Request{
+				Name: snapshotName,
+				Options: map[string]string{
+					OPT_VOLUME_NAME:          volumeName,
+					OPT_SNAPSHOT_LABELS:      objectstore.EncodeLabels(labels),
+					OPT_SNAPSHOT_DESCRIPTION: request.Description,
+				},
+			},
+		})
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_PREPARE,
+		LOG_FIELD_EVENT:    LOG_EVENT_CREATE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: name,
+	}).Debugf("Creating group snapshot of group %v", group.Name)
+
+	// The snapshots of the members are taken together as one operation
+	release := s.operations.acquire("group snapshot " + name + " of group " + group.Name)
+	err = s.createGroupSnapshot(members, !request.NoFreeze, request.PauseContainers)
+	release()
+	if err != nil {
+		return nil, err
+	}
+
+	groupSnapshot := GroupSnapshot{
+		Name:        name,
+		CreatedTime: util.Now(),
+		Snapshots:   map[string]string{},
+	}
+	for _, m := range members {
+		if err := s.addSnapshot(m.req.Name, m.volume.Name); err != nil {
+			return nil, err
+		}
+		groupSnapshot.Snapshots[m.volume.Name] = m.req.Name
+	}
+
+	// Forget the group snapshots whose member snapshots are all gone
+	snapshots := []GroupSnapshot{}
+	for _, snapshot := range group.Snapshots {
+		for _, snapshotName := range snapshot.Snapshots {
+			if s.SnapshotVolumeIndex.Get(snapshotName) != "" {
+				snapshots = append(snapshots, snapshot)
+				break
+			}
+		}
+	}
+	group.Snapshots = append(snapshots, groupSnapshot)
+	if err := util.ObjectSave(group); err != nil {
+		return nil, err
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:    LOG_EVENT_CREATE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: name,
+	}).Debugf("Created group snapshot of group %v", group.Name)
+	return &groupSnapshot, nil
+}
+
+// createGroupSnapshot creates the snapshots of the members by the drivers
+// with all of them quiesced, the freezable ones frozen if freeze is true, and
+// the containers using them paused if pause is true, see processGroupSnapshot
+func (s *daemon) createGroupSnapshot(members []*groupMember, freeze, pause bool) (err error) {
+	for _, m := range members {
+		if err := s.runPreSnapshotHook(m.volume, m.req.Name); err != nil {
+			return err
+		}
+	}
+
+	unquiesces := []func(error){}
+	defer func() {
+		for i := len(unquiesces) - 1; i >= 0; i-- {
+			unquiesces[i](err)
+		}
+	}()
+	for _, m := range members {
+		unquiesce, err := s.runQuiesceHook(m.volume, m.req.Name)
+		if err != nil {
+			return err
+		}
+		unquiesces = append(unquiesces, unquiesce)
+	}
+
+	created := []*groupMember{}
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, m := range created {
+			if e := m.snapOps.DeleteSnapshot(m.req); e != nil {
+				log.Warnf("Failed to remove snapshot %v of volume %v of failed group snapshot: %v",
+					m.req.Name, m.volume.Name, e)
+			}
+		}
+	}()
+
+	if pause {
+		volumes := []*Volume{}
+		for _, m := range members {
+			volumes = append(volumes, m.volume)
+		}
+		unpause, err := s.pauseContainers(volumes)
+		if err != nil {
+			return err
+		}
+		defer unpause()
+	}
+
+	unfreezes := []func(){}
+	defer func() {
+		for i := len(unfreezes) - 1; i >= 0; i-- {
+			unfreezes[i]()
+		}
+	}()
+	if freeze {
+		for _, m := range members {
+			mountPoint, freezable, err := s.getFreezableMountPoint(m.volume)
+			if err != nil {
+				return err
+			}
+			if mountPoint == "" {
+				log.Debugf("Volume %v is not mounted, no need to freeze", m.volume.Name)
+				continue
+			}
+			if !freezable {
+				log.Warnf("Skip freezing volume %v of driver %v for group snapshot, %v is not a mount of its own block device",
+					m.volume.Name, m.volume.DriverName, mountPoint)
+				continue
+			}
+			unfreeze, err := freezeMountPoint(m.volume, mountPoint)
+			if err != nil {
+				return err
+			}
+			unfreezes = append(unfreezes, unfreeze)
+		}
+	}
+
+	for _, m := range members {
+		if err := s.createSnapshot(m.snapOps, m.req, m.volume, false); err != nil {
+			return err
+		}
+		created = append(created, m)
+	}
+	return nil
+}
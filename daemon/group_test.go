@@ -0,0 +1,54 @@
+package daemon
+
+import (
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestGroupSnapshotSkipUnfreezableVolumes(c *C) {
+	d := newTestDaemon(c)
+	volumeNames := []string{"vol1", "vol2"}
+	for _, name := range volumeNames {
+		volume, err := d.processVolumeCreate(&api.VolumeCreateRequest{Name: name})
+		c.Assert(err, IsNil)
+		_, err = d.processVolumeMount(volume, &api.VolumeMountRequest{})
+		c.Assert(err, IsNil)
+	}
+	c.Assert(util.ObjectSave(&Group{
+		Name:        "group1",
+		VolumeNames: volumeNames,
+		configPath:  d.Root,
+	}), IsNil)
+
+	// The mount points of vfs are directories on the filesystem of the host,
+	// which can't be frozen on their own, so they're skipped by default
+	mountPoint, freezable, err := d.getFreezableMountPoint(d.getVolume("vol1"))
+	c.Assert(err, IsNil)
+	c.Assert(mountPoint, Not(Equals), "")
+	c.Assert(freezable, Equals, false)
+
+	_, err = d.processGroupSnapshot(&api.GroupSnapshotRequest{
+		GroupName: "group1",
+		Name:      "snapshot1",
+	})
+	c.Assert(err, IsNil)
+	_, err = d.processGroupSnapshot(&api.GroupSnapshotRequest{
+		GroupName: "group1",
+		Name:      "snapshot2",
+		NoFreeze:  true,
+	})
+	c.Assert(err, IsNil)
+	for _, name := range volumeNames {
+		c.Assert(d.SnapshotVolumeIndex.Get("snapshot1-"+name), Equals, name)
+		c.Assert(d.SnapshotVolumeIndex.Get("snapshot2-"+name), Equals, name)
+	}
+
+	// The single volume snapshot still refuses to freeze it
+	_, _, err = d.processSnapshotCreate(&api.SnapshotCreateRequest{
+		VolumeName: "vol1",
+		Freeze:     true,
+	})
+	c.Assert(err, ErrorMatches, "Cannot freeze volume vol1 of driver vfs.*")
+}
@@ -0,0 +1,285 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	HOOK_PRE_SNAPSHOT = "pre-snapshot"
+	HOOK_POST_BACKUP  = "post-backup"
+	HOOK_QUIESCE      = "quiesce"
+	HOOK_UNQUIESCE    = "unquiesce"
+
+	HOOK_ENV_VOLUME_NAME    = "CONVOY_VOLUME_NAME"
+	HOOK_ENV_SNAPSHOT_NAME  = "CONVOY_SNAPSHOT_NAME"
+	HOOK_ENV_MOUNT_POINT    = "CONVOY_MOUNT_POINT"
+	HOOK_ENV_BACKUP_URL     = "CONVOY_BACKUP_URL"
+	HOOK_ENV_BACKUP_ERROR   = "CONVOY_BACKUP_ERROR"
+	HOOK_ENV_SNAPSHOT_ERROR = "CONVOY_SNAPSHOT_ERROR"
+)
+
+/*
+BackupHooks are the shell commands run by the daemon around the backups of
+the volume, e.g. to flush and lock the tables of the database on it. The
+pre-snapshot command is run before creating the snapshot, and the snapshot
+would not be created if it fails. The post-backup command is run after the
+backup of the snapshot completed, whether it succeeded or not.
+*/
+type BackupHooks struct {
+	PreSnapshot string `json:",omitempty"`
+	PostBackup  string `json:",omitempty"`
+}
+
+func (h *BackupHooks) IsEmpty() bool {
+	return h.PreSnapshot == "" && h.PostBackup == ""
+}
+
+/*
+QuiesceHooks are the shell commands run by the daemon right around every
+snapshot of the volume, for the application consistent snapshots, e.g. to
+suspend the writes of the database on it. The unquiesce command is always run
+once the quiesce command has been run, even if the quiesce command or the
+snapshot failed, so the application won't be left quiesced.
+*/
+type QuiesceHooks struct {
+	Quiesce   string `json:",omitempty"`
+	Unquiesce string `json:",omitempty"`
+}
+
+func (h *QuiesceHooks) IsEmpty() bool {
+	return h.Quiesce == "" && h.Unquiesce == ""
+}
+
+// doBackupHooks sets the hook commands of the backups of the volume, or only
+// shows them if no command is specified
+func (s *daemon) doBackupHooks(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.BackupHooksRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+
+	if s.getVolume(request.VolumeName) == nil {
+		return notFoundAPIError
+	}
+
+	hooks := &BackupHooks{
+		PreSnapshot: strings.TrimSpace(request.PreSnapshot),
+		PostBackup:  strings.TrimSpace(request.PostBackup),
+	}
+	if request.Clear || !hooks.IsEmpty() {
+		if err := s.updateVolumeConfig(request.VolumeName, func(volume *Volume) error {
+			if request.Clear {
+				hooks = nil
+			} else if volume.Hooks != nil {
+				// Only the specified commands would be replaced
+				if hooks.PreSnapshot == "" {
+					hooks.PreSnapshot = volume.Hooks.PreSnapshot
+				}
+				if hooks.PostBackup == "" {
+					hooks.PostBackup = volume.Hooks.PostBackup
+				}
+			}
+			volume.Hooks = hooks
+			return nil
+		}); err != nil {
+			return err
+		}
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_REASON: LOG_REASON_COMPLETE,
+			LOG_FIELD_VOLUME: request.VolumeName,
+		}).Debugf("Updated backup hooks to %+v", hooks)
+	}
+
+	volume, err := s.loadVolumeConfig(request.VolumeName)
+	if err != nil {
+		return err
+	}
+	if volume == nil || volume.Hooks == nil {
+		return sendResponse(w, &BackupHooks{})
+	}
+	return sendResponse(w, volume.Hooks)
+}
+
+// doSnapshotQuiesce sets the quiesce hooks of the snapshots of the volume, or
+// only shows them if no command is specified
+func (s *daemon) doSnapshotQuiesce(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.SnapshotQuiesceRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+
+	if s.getVolume(request.VolumeName) == nil {
+		return notFoundAPIError
+	}
+
+	hooks := &QuiesceHooks{
+		Quiesce:   strings.TrimSpace(request.Quiesce),
+		Unquiesce: strings.TrimSpace(request.Unquiesce),
+	}
+	if request.Clear || !hooks.IsEmpty() {
+		if err := s.updateVolumeConfig(request.VolumeName, func(volume *Volume) error {
+			if request.Clear {
+				hooks = nil
+			} else if volume.QuiesceHooks != nil {
+				// Only the specified commands would be replaced
+				if hooks.Quiesce == "" {
+					hooks.Quiesce = volume.QuiesceHooks.Quiesce
+				}
+				if hooks.Unquiesce == "" {
+					hooks.Unquiesce = volume.QuiesceHooks.Unquiesce
+				}
+			}
+			volume.QuiesceHooks = hooks
+			return nil
+		}); err != nil {
+			return err
+		}
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_REASON: LOG_REASON_COMPLETE,
+			LOG_FIELD_VOLUME: request.VolumeName,
+		}).Debugf("Updated quiesce hooks to %+v", hooks)
+	}
+
+	volume, err := s.loadVolumeConfig(request.VolumeName)
+	if err != nil {
+		return err
+	}
+	if volume == nil || volume.QuiesceHooks == nil {
+		return sendResponse(w, &QuiesceHooks{})
+	}
+	return sendResponse(w, volume.QuiesceHooks)
+}
+
+// getBackupHooks returns the hooks of the volume, or nil if there is none
+func (s *daemon) getBackupHooks(volumeName string) (*BackupHooks, error) {
+	volume, err := s.loadVolumeConfig(volumeName)
+	if err != nil || volume == nil {
+		return nil, err
+	}
+	return volume.Hooks, nil
+}
+
+/*
+runHook runs the command of the hook by the shell, with the information of
+the volume and the backup passed through the environment variables. The
+command would be killed if it doesn't complete within the timeout of
+commands, see "--cmd-timeout" of the daemon.
+*/
+func (s *daemon) runHook(hook, command string, volume *Volume, env map[string]string) error {
+	mountPoint, err := s.getVolumeMountPoint(volume)
+	if err != nil {
+		return err
+	}
+	vars := []string{
+		HOOK_ENV_VOLUME_NAME + "=" + volume.Name,
+		HOOK_ENV_MOUNT_POINT + "=" + mountPoint,
+	}
+	for k, v := range env {
+		vars = append(vars, k+"="+v)
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_START,
+		LOG_FIELD_EVENT:  LOG_EVENT_HOOK,
+		LOG_FIELD_VOLUME: volume.Name,
+	}).Debugf("Running %v hook: %v", hook, command)
+	output, err := util.ExecuteWithEnv("sh", []string{"-c", command}, vars)
+	if err != nil {
+		return fmt.Errorf("The %v hook of volume %v failed: %v", hook, volume.Name, err)
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:  LOG_EVENT_HOOK,
+		LOG_FIELD_VOLUME: volume.Name,
+	}).Debugf("Completed %v hook, output: %v", hook, output)
+	return nil
+}
+
+// runPreSnapshotHook runs the pre-snapshot hook of the volume if any, the
+// snapshot should not be created if it fails
+func (s *daemon) runPreSnapshotHook(volume *Volume, snapshotName string) error {
+	hooks, err := s.getBackupHooks(volume.Name)
+	if err != nil {
+		return err
+	}
+	if hooks == nil || hooks.PreSnapshot == "" {
+		return nil
+	}
+	return s.runHook(HOOK_PRE_SNAPSHOT, hooks.PreSnapshot, volume, map[string]string{
+		HOOK_ENV_SNAPSHOT_NAME: snapshotName,
+	})
+}
+
+/*
+runQuiesceHook runs the quiesce hook of the volume if any before creating the
+snapshot, and returns the function to run the unquiesce hook with the result
+of the snapshot. If the quiesce hook failed, the unquiesce hook would be run
+right away, since the application may have been partly quiesced.
+*/
+func (s *daemon) runQuiesceHook(volume *Volume, snapshotName string) (func(error), error) {
+	config, err := s.loadVolumeConfig(volume.Name)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil || config.QuiesceHooks == nil {
+		return func(error) {}, nil
+	}
+	hooks := config.QuiesceHooks
+	unquiesce := func(snapshotErr error) {
+		if hooks.Unquiesce == "" {
+			return
+		}
+		env := map[string]string{
+			HOOK_ENV_SNAPSHOT_NAME: snapshotName,
+		}
+		if snapshotErr != nil {
+			env[HOOK_ENV_SNAPSHOT_ERROR] = snapshotErr.Error()
+		}
+		// The snapshot has been created or failed already
+		if err := s.runHook(HOOK_UNQUIESCE, hooks.Unquiesce, volume, env); err != nil {
+			log.Error(err)
+		}
+	}
+	if hooks.Quiesce != "" {
+		err := s.runHook(HOOK_QUIESCE, hooks.Quiesce, volume, map[string]string{
+			HOOK_ENV_SNAPSHOT_NAME: snapshotName,
+		})
+		if err != nil {
+			unquiesce(err)
+			return nil, err
+		}
+	}
+	return unquiesce, nil
+}
+
+// runPostBackupHook runs the post-backup hook of the volume if any, after the
+// backup of the snapshot completed with backupErr. The backup has completed,
+// so the failure of the hook would only be logged.
+func (s *daemon) runPostBackupHook(volume *Volume, snapshotName, backupURL string, backupErr error) {
+	hooks, err := s.getBackupHooks(volume.Name)
+	if err != nil {
+		log.Warnf("Failed to load the backup hooks of volume %v: %v", volume.Name, err)
+		return
+	}
+	if hooks == nil || hooks.PostBackup == "" {
+		return
+	}
+	env := map[string]string{
+		HOOK_ENV_SNAPSHOT_NAME: snapshotName,
+		HOOK_ENV_BACKUP_URL:    backupURL,
+	}
+	if backupErr != nil {
+		env[HOOK_ENV_BACKUP_ERROR] = backupErr.Error()
+	}
+	if err := s.runHook(HOOK_POST_BACKUP, hooks.PostBackup, volume, env); err != nil {
+		log.Warn(err)
+	}
+}
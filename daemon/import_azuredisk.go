@@ -0,0 +1,6 @@
+package daemon
+
+import (
+	// Involve Azure Managed Disk driver for registeration
+	_ "github.com/rancher/convoy/azuredisk"
+)
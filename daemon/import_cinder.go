@@ -0,0 +1,6 @@
+package daemon
+
+import (
+	// Involve OpenStack Cinder driver for registeration
+	_ "github.com/rancher/convoy/cinder"
+)
@@ -0,0 +1,8 @@
+// +build linux
+
+package daemon
+
+import (
+	// Involve dm-crypt encryption driver for registeration
+	_ "github.com/rancher/convoy/crypt"
+)
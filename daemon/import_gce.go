@@ -0,0 +1,6 @@
+package daemon
+
+import (
+	// Involve GCE Persistent Disk driver for registeration
+	_ "github.com/rancher/convoy/gce"
+)
@@ -0,0 +1,8 @@
+// +build linux
+
+package daemon
+
+import (
+	// Involve loopback sparse file driver for registeration
+	_ "github.com/rancher/convoy/loopback"
+)
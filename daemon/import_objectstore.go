@@ -1,8 +1,32 @@
 package daemon
 
 import (
+	// Involve Azure Blob Storage objectstore driver for registeration
+	_ "github.com/rancher/convoy/azure"
+	// Involve Backblaze B2 objectstore driver for registeration
+	_ "github.com/rancher/convoy/b2"
+	// Involve FTP objectstore driver for registeration
+	_ "github.com/rancher/convoy/ftp"
+	// Involve HDFS objectstore driver for registeration
+	_ "github.com/rancher/convoy/hdfs"
+	// Involve IPFS objectstore driver for registeration
+	_ "github.com/rancher/convoy/ipfs"
+	// Involve removable media objectstore driver for registeration
+	_ "github.com/rancher/convoy/media"
+	// Involve mirror objectstore driver for registeration
+	_ "github.com/rancher/convoy/mirror"
+	// Involve NFS convoy driver/objectstore driver for registeration
+	_ "github.com/rancher/convoy/nfs"
+	// Involve Alibaba Cloud OSS objectstore driver for registeration
+	_ "github.com/rancher/convoy/oss"
 	// Involve S3 objecstore drivers for registeration
 	_ "github.com/rancher/convoy/s3"
+	// Involve SSH objectstore driver for registeration
+	_ "github.com/rancher/convoy/ssh"
+	// Involve OpenStack Swift objectstore driver for registeration
+	_ "github.com/rancher/convoy/swift"
+	// Involve WebDAV objectstore driver for registeration
+	_ "github.com/rancher/convoy/webdav"
 	// Involve VFS convoy driver/objectstore driver for registeration
 	_ "github.com/rancher/convoy/vfs"
 )
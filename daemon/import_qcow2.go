@@ -0,0 +1,8 @@
+// +build linux
+
+package daemon
+
+import (
+	// Involve qcow2 driver for registeration
+	_ "github.com/rancher/convoy/qcow2"
+)
@@ -0,0 +1,8 @@
+// +build linux,rados
+
+package daemon
+
+import (
+	// Involve Ceph RADOS objectstore driver for registeration
+	_ "github.com/rancher/convoy/rados"
+)
@@ -0,0 +1,6 @@
+package daemon
+
+import (
+	// Involve reflink driver for registeration
+	_ "github.com/rancher/convoy/reflink"
+)
@@ -1,9 +1,13 @@
 package daemon
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/Sirupsen/logrus"
@@ -21,23 +25,40 @@ func (s *daemon) doBackupList(version string, w http.ResponseWriter, r *http.Req
 		return err
 	}
 	request.URL = util.UnescapeURL(request.URL)
-
-	opts := map[string]string{
-		OPT_VOLUME_NAME: request.VolumeName,
+	selector, err := objectstore.ParseLabels(request.Labels)
+	if err != nil {
+		return err
 	}
+
 	result := make(map[string]map[string]string)
-	for _, driver := range s.ConvoyDrivers {
-		backupOps, err := driver.BackupOps()
-		if err != nil {
-			// Not support backup ops
-			continue
-		}
-		infos, err := backupOps.ListBackup(request.URL, request.Endpoint, opts)
-		if err != nil {
+	if request.All {
+		// The backups of all the volumes in the objectstore, including
+		// the ones of the drivers not enabled by the daemon
+		if result, err = objectstore.List(request.VolumeName, request.URL, request.Endpoint, ""); err != nil {
 			return err
 		}
-		for k, v := range infos {
-			result[k] = v
+	} else {
+		opts := map[string]string{
+			OPT_VOLUME_NAME: request.VolumeName,
+		}
+		for _, driver := range s.ConvoyDrivers {
+			backupOps, err := driver.BackupOps()
+			if err != nil {
+				// Not support backup ops
+				continue
+			}
+			infos, err := backupOps.ListBackup(request.URL, request.Endpoint, opts)
+			if err != nil {
+				return err
+			}
+			for k, v := range infos {
+				result[k] = v
+			}
+		}
+	}
+	for k, v := range result {
+		if !objectstore.MatchLabels(objectstore.DecodeLabels(v["Labels"]), selector) {
+			delete(result, k)
 		}
 	}
 
@@ -55,12 +76,62 @@ func (s *daemon) doBackupInspect(version string, w http.ResponseWriter, r *http.
 		return err
 	}
 	request.URL = util.UnescapeURL(request.URL)
+	var info map[string]string
 	backupOps, err := s.getBackupOpsForBackup(request.URL, request.Endpoint)
 	if err != nil {
+		// The backup in objectstore can be inspected without its driver
+		if _, storeErr := objectstore.GetObjectStoreDriver(request.URL, request.Endpoint); storeErr != nil {
+			return err
+		}
+		if info, err = objectstore.GetBackupInfo(request.URL, request.Endpoint); err != nil {
+			return err
+		}
+	} else if info, err = backupOps.GetBackupInfo(request.URL, request.Endpoint); err != nil {
+		return err
+	}
+
+	data, err := api.ResponseOutput(info)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (s *daemon) doBackupStats(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.BackupListRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	request.URL = util.UnescapeURL(request.URL)
+
+	stats, err := objectstore.GetBackupStats(request.URL, request.Endpoint, request.VolumeName)
+	if err != nil {
+		return err
+	}
+
+	data, err := api.ResponseOutput(stats)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (s *daemon) doBackupRetrieve(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.BackupListRequest{}
+	if err := decodeRequest(r, request); err != nil {
 		return err
 	}
+	request.URL = util.UnescapeURL(request.URL)
 
-	info, err := backupOps.GetBackupInfo(request.URL, request.Endpoint)
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:       LOG_REASON_PREPARE,
+		LOG_FIELD_EVENT:        LOG_EVENT_RESTORE,
+		LOG_FIELD_BACKUP_URL:   request.URL,
+		LOG_FIELD_ENDPOINT_URL: request.Endpoint,
+	}).Debug("Retrieving backup from archive")
+	info, err := objectstore.RetrieveBackup(request.URL, request.Endpoint)
 	if err != nil {
 		return err
 	}
@@ -73,44 +144,604 @@ func (s *daemon) doBackupInspect(version string, w http.ResponseWriter, r *http.
 	return err
 }
 
+// doBackupRestore restores the backup onto an existing volume, which only
+// writes the blocks differing from the backup it was restored from
+func (s *daemon) doBackupRestore(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.BackupRestoreRequest{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(request); err != nil {
+		return err
+	}
+	request.URL = util.UnescapeURL(request.URL)
+	if err := util.CheckName(request.VolumeName); err != nil {
+		return err
+	}
+
+	volume := s.getVolume(request.VolumeName)
+	if volume == nil {
+		return notFoundAPIError
+	}
+	if request.Stream {
+		// The image follows the request in the body
+		image := io.MultiReader(decoder.Buffered(), r.Body)
+		if err := s.restoreImageStream(volume, image); err != nil {
+			return err
+		}
+		return writeStringResponse(w, volume.Name)
+	}
+	backupOps, err := s.getBackupOpsForVolume(volume)
+	if err != nil {
+		return err
+	}
+	restoreOps, ok := backupOps.(BackupRestoreOperations)
+	if !ok {
+		return fmt.Errorf("Driver %v doesn't support restoring backups to existing volumes", backupOps.Name())
+	}
+
+	restore := func() ([]byte, error) {
+		req := Request{
+			Name: volume.Name,
+			Options: map[string]string{
+				OPT_BACKUP_URL:   request.URL,
+				OPT_ENDPOINT_URL: request.Endpoint,
+			},
+		}
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_REASON:       LOG_REASON_START,
+			LOG_FIELD_EVENT:        LOG_EVENT_RESTORE,
+			LOG_FIELD_VOLUME:       volume.Name,
+			LOG_FIELD_BACKUP_URL:   request.URL,
+			LOG_FIELD_ENDPOINT_URL: request.Endpoint,
+		}).Debug("Restoring backup to existing volume")
+		if err := restoreOps.RestoreBackup(req); err != nil {
+			return nil, err
+		}
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_REASON:     LOG_REASON_COMPLETE,
+			LOG_FIELD_EVENT:      LOG_EVENT_RESTORE,
+			LOG_FIELD_VOLUME:     volume.Name,
+			LOG_FIELD_BACKUP_URL: request.URL,
+		}).Debug()
+		return []byte(volume.Name), nil
+	}
+
+	if request.Progress {
+		return streamProgress(w, objectstore.RestoreProgressKey(request.URL), restore)
+	}
+	output, err := restore()
+	if err != nil {
+		return err
+	}
+	return writeStringResponse(w, string(output))
+}
+
+// restoreImageStream writes the raw image to the device of the volume, which
+// must not be mounted
+func (s *daemon) restoreImageStream(volume *Volume, image io.Reader) error {
+	volOps, err := s.getVolumeOpsForVolume(volume)
+	if err != nil {
+		return err
+	}
+	blockOps, ok := volOps.(BlockDeviceOperations)
+	if !ok {
+		return fmt.Errorf("Driver %v doesn't support restoring images to volumes", volOps.Name())
+	}
+	req := Request{
+		Name:    volume.Name,
+		Options: map[string]string{},
+	}
+	mountPoint, err := volOps.MountPoint(req)
+	if err != nil {
+		return err
+	}
+	if mountPoint != "" {
+		return fmt.Errorf("Cannot restore image to volume %v, which is mounted at %v", volume.Name, mountPoint)
+	}
+
+	dev, err := blockOps.AttachVolume(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := blockOps.DetachVolume(req); err != nil {
+			log.Errorf("Failed to detach volume %v: %v", volume.Name, err)
+		}
+	}()
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:     LOG_REASON_START,
+		LOG_FIELD_EVENT:      LOG_EVENT_RESTORE,
+		LOG_FIELD_VOLUME:     volume.Name,
+		LOG_FIELD_VOLUME_DEV: dev,
+	}).Debug("Restoring image stream")
+	size, err := objectstore.RestoreImageStream(image, dev)
+	if err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:     LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:      LOG_EVENT_RESTORE,
+		LOG_FIELD_VOLUME:     volume.Name,
+		LOG_FIELD_VOLUME_DEV: dev,
+		LOG_FIELD_SIZE:       size,
+	}).Debug()
+	return nil
+}
+
+func (s *daemon) doBackupVerify(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.BackupVerifyRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	request.URL = util.UnescapeURL(request.URL)
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:       LOG_REASON_START,
+		LOG_FIELD_EVENT:        LOG_EVENT_VERIFY,
+		LOG_FIELD_BACKUP_URL:   request.URL,
+		LOG_FIELD_ENDPOINT_URL: request.Endpoint,
+	}).Debug("Verifying backup")
+	report, err := objectstore.VerifyBackup(request.URL, request.Endpoint, request.Sample)
+	if err != nil {
+		return err
+	}
+
+	data, err := api.ResponseOutput(report)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// doBackupRepair verifies the backup, and uploads the missing or corrupted
+// blocks again from the snapshot of the backup if it still exists
+func (s *daemon) doBackupRepair(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.BackupVerifyRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	request.URL = util.UnescapeURL(request.URL)
+
+	info, err := objectstore.GetBackupInfo(request.URL, request.Endpoint)
+	if err != nil {
+		return err
+	}
+	backupOps, volumeName, err := s.getBackupOpsForSnapshot(info["SnapshotName"])
+	if err != nil {
+		return fmt.Errorf("Cannot repair backup from its snapshot: %v", err)
+	}
+	if volumeName != info["VolumeName"] {
+		return fmt.Errorf("Snapshot %v belongs to volume %v rather than %v of the backup",
+			info["SnapshotName"], volumeName, info["VolumeName"])
+	}
+	deltaOps, ok := backupOps.(objectstore.DeltaBlockBackupOperations)
+	if !ok {
+		return fmt.Errorf("Driver %v doesn't support repairing backups", backupOps.Name())
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:       LOG_REASON_START,
+		LOG_FIELD_EVENT:        LOG_EVENT_REPAIR,
+		LOG_FIELD_BACKUP_URL:   request.URL,
+		LOG_FIELD_ENDPOINT_URL: request.Endpoint,
+		LOG_FIELD_SNAPSHOT:     info["SnapshotName"],
+		LOG_FIELD_VOLUME:       volumeName,
+	}).Debug("Repairing backup")
+	report, err := objectstore.VerifyBackup(request.URL, request.Endpoint, request.Sample)
+	if err != nil {
+		return err
+	}
+	result, err := objectstore.RepairDeltaBlockBackup(report, request.Endpoint, deltaOps)
+	if err != nil {
+		return err
+	}
+
+	data, err := api.ResponseOutput(result)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (s *daemon) doBackupExport(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.BackupExportRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	request.URL = util.UnescapeURL(request.URL)
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:       LOG_REASON_START,
+		LOG_FIELD_EVENT:        LOG_EVENT_EXPORT,
+		LOG_FIELD_BACKUP_URL:   request.URL,
+		LOG_FIELD_ENDPOINT_URL: request.Endpoint,
+		LOG_FIELD_FILEPATH:     request.FilePath,
+	}).Debug("Exporting backup")
+	return objectstore.ExportBackup(request.URL, request.Endpoint, request.FilePath)
+}
+
+func (s *daemon) doBackupExtract(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.BackupExtractRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	request.URL = util.UnescapeURL(request.URL)
+	if request.Path == "" {
+		return fmt.Errorf("Path in the backup is required")
+	}
+	if !filepath.IsAbs(request.TargetPath) {
+		return fmt.Errorf("Target path %v must be absolute", request.TargetPath)
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:       LOG_REASON_START,
+		LOG_FIELD_EVENT:        LOG_EVENT_EXTRACT,
+		LOG_FIELD_BACKUP_URL:   request.URL,
+		LOG_FIELD_ENDPOINT_URL: request.Endpoint,
+		LOG_FIELD_FILEPATH:     request.TargetPath,
+	}).Debugf("Extracting %v from backup", request.Path)
+	return objectstore.ExtractSingleFileBackup(request.URL, request.Endpoint, request.Path, request.TargetPath)
+}
+
+func (s *daemon) doBackupImport(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.BackupImportRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	request.URL = util.UnescapeURL(request.URL)
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:       LOG_REASON_START,
+		LOG_FIELD_EVENT:        LOG_EVENT_IMPORT,
+		LOG_FIELD_DEST_URL:     request.URL,
+		LOG_FIELD_ENDPOINT_URL: request.Endpoint,
+		LOG_FIELD_FILEPATH:     request.FilePath,
+	}).Debug("Importing backup")
+	backupURL, err := objectstore.ImportBackup(request.FilePath, request.URL, request.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	data, err := api.ResponseOutput(&api.BackupURLResponse{
+		URL: backupURL,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (s *daemon) doBackupReplicate(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.BackupReplicateRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	request.URL = util.UnescapeURL(request.URL)
+	request.DestURL = util.UnescapeURL(request.DestURL)
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:       LOG_REASON_START,
+		LOG_FIELD_EVENT:        LOG_EVENT_REPLICATE,
+		LOG_FIELD_DEST_URL:     request.DestURL,
+		LOG_FIELD_ENDPOINT_URL: request.Endpoint,
+		LOG_FIELD_VOLUME:       request.VolumeName,
+	}).Debugf("Replicating backups from %v", request.URL)
+	report, err := objectstore.ReplicateVolume(request.URL, request.Endpoint,
+		request.DestURL, request.DestEndpoint, request.VolumeName)
+	if err != nil {
+		return err
+	}
+
+	data, err := api.ResponseOutput(report)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (s *daemon) doBackupGC(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.BackupGCRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	request.URL = util.UnescapeURL(request.URL)
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:       LOG_REASON_START,
+		LOG_FIELD_EVENT:        LOG_EVENT_DELETE,
+		LOG_FIELD_DEST_URL:     request.URL,
+		LOG_FIELD_ENDPOINT_URL: request.Endpoint,
+		LOG_FIELD_VOLUME:       request.VolumeName,
+	}).Debug("Garbage collecting objectstore")
+	report, err := objectstore.GarbageCollect(request.URL, request.Endpoint, request.VolumeName, request.DryRun)
+	if err != nil {
+		return err
+	}
+
+	data, err := api.ResponseOutput(report)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (s *daemon) doObjectStoreUpgrade(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.ObjectStoreUpgradeRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	request.URL = util.UnescapeURL(request.URL)
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:       LOG_REASON_START,
+		LOG_FIELD_EVENT:        LOG_EVENT_UPGRADE,
+		LOG_FIELD_DEST_URL:     request.URL,
+		LOG_FIELD_ENDPOINT_URL: request.Endpoint,
+	}).Debug("Upgrading objectstore")
+	report, err := objectstore.UpgradeObjectStore(request.URL, request.Endpoint, request.DryRun)
+	if err != nil {
+		return err
+	}
+
+	data, err := api.ResponseOutput(report)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (s *daemon) doObjectStoreBreakLock(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.ObjectStoreBreakLockRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	request.URL = util.UnescapeURL(request.URL)
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:       LOG_REASON_START,
+		LOG_FIELD_EVENT:        LOG_EVENT_REMOVE,
+		LOG_FIELD_DEST_URL:     request.URL,
+		LOG_FIELD_ENDPOINT_URL: request.Endpoint,
+		LOG_FIELD_VOLUME:       request.VolumeName,
+	}).Debug("Breaking lock in objectstore")
+	lease, err := objectstore.BreakLock(request.URL, request.Endpoint, request.VolumeName)
+	if err != nil {
+		return err
+	}
+	if lease == nil {
+		return nil
+	}
+
+	data, err := api.ResponseOutput(lease)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
 func (s *daemon) doBackupCreate(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
 	request := &api.BackupCreateRequest{}
 	if err := decodeRequest(r, request); err != nil {
 		return err
 	}
 	request.URL = util.UnescapeURL(request.URL)
+	if request.ExportImage != "" {
+		if err := objectstore.ValidateImageFormat(request.ExportImage); err != nil {
+			return err
+		}
+	}
+	if _, err := objectstore.ParseLabels(request.Labels); err != nil {
+		return err
+	}
+	if request.Stream {
+		return s.streamSnapshotImage(w, request)
+	}
 
-	snapshotName := request.SnapshotName
+	create := func() ([]byte, error) {
+		if request.DryRun {
+			estimate, err := s.processBackupEstimate(request)
+			if err != nil {
+				return nil, err
+			}
+			return api.ResponseOutput(estimate)
+		}
+		backupURL, err := s.processBackupCreate(request)
+		if err != nil {
+			return nil, err
+		}
+
+		backup := &api.BackupURLResponse{
+			URL: backupURL,
+		}
+		if request.Verbose {
+			return api.ResponseOutput(backup)
+		}
+		escapedURL := strings.Replace(backupURL, "&", "\\u0026", 1)
+		return []byte(escapedURL), nil
+	}
+
+	if request.Progress {
+		volumeName := s.SnapshotVolumeIndex.Get(request.SnapshotName)
+		key := objectstore.BackupProgressKey(volumeName, request.SnapshotName)
+		return streamProgress(w, key, create)
+	}
+	output, err := create()
+	if err != nil {
+		return err
+	}
+	return writeStringResponse(w, string(output))
+}
+
+// countingWriter counts the bytes written
+type countingWriter struct {
+	io.Writer
+	count int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.count += int64(n)
+	return n, err
+}
+
+/*
+streamSnapshotImage writes the snapshot as a raw image in the response,
+rather than creating a backup in objectstore. The length of the image is set
+in the header, so the client would find the image incomplete if it failed
+after the response started.
+*/
+func (s *daemon) streamSnapshotImage(w http.ResponseWriter, request *api.BackupCreateRequest) error {
+	if request.URL != "" || request.DryRun || request.ExportImage != "" || len(request.Labels) != 0 {
+		return fmt.Errorf("Cannot stream snapshot with the options for the backup in objectstore")
+	}
+	unuse := s.snapshotUsers.use(request.SnapshotName)
+	defer unuse()
+	deltaOps, volume, err := s.getSnapshotImageOps(request.SnapshotName)
+	if err != nil {
+		return err
+	}
+	volumeName, size := volume.Name, volume.Size
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_BACKUP,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: request.SnapshotName,
+		LOG_FIELD_VOLUME:   volumeName,
+		LOG_FIELD_SIZE:     size,
+	}).Debug("Streaming snapshot")
+	release := s.operations.acquire("stream of snapshot " + request.SnapshotName + " of volume " + volumeName)
+	defer release()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	cw := &countingWriter{Writer: w}
+	if err := objectstore.WriteSnapshotImage(cw, volume, request.SnapshotName, deltaOps); err != nil {
+		if cw.count == 0 {
+			return err
+		}
+		// The status has been sent, closing the connection short of the
+		// length is the only way left to report the failure
+		log.Errorf("Failed to stream snapshot %v after %v bytes: %v", request.SnapshotName, cw.count, err)
+		return nil
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:    LOG_EVENT_BACKUP,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: request.SnapshotName,
+		LOG_FIELD_VOLUME:   volumeName,
+	}).Debug()
+	return nil
+}
+
+// getSnapshotImageOps returns the driver to read the snapshot as an image,
+// and the volume of the snapshot with its size
+func (s *daemon) getSnapshotImageOps(snapshotName string) (objectstore.DeltaBlockBackupOperations, *objectstore.Volume, error) {
+	backupOps, volumeName, err := s.getBackupOpsForSnapshot(snapshotName)
+	if err != nil {
+		return nil, nil, err
+	}
+	deltaOps, ok := backupOps.(objectstore.DeltaBlockBackupOperations)
+	if !ok {
+		return nil, nil, fmt.Errorf("Driver %v doesn't support reading snapshots as images", backupOps.Name())
+	}
+	volumeInfo, err := s.getVolumeDriverInfo(s.getVolume(volumeName))
+	if err != nil {
+		return nil, nil, err
+	}
+	size, err := strconv.ParseInt(volumeInfo[OPT_SIZE], 10, 64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot get the size of volume %v: %v", volumeName, err)
+	}
+	volume := &objectstore.Volume{
+		Name: volumeName,
+		Size: size,
+	}
+	return deltaOps, volume, nil
+}
+
+func (s *daemon) getBackupOpsForSnapshot(snapshotName string) (BackupOperations, string, error) {
 	volumeName := s.SnapshotVolumeIndex.Get(snapshotName)
 	if volumeName == "" {
-		return fmt.Errorf("Cannot find volume of snapshot %v", snapshotName)
+		return nil, "", fmt.Errorf("Cannot find volume of snapshot %v", snapshotName)
 	}
 
 	if !s.snapshotExists(volumeName, snapshotName) {
-		return fmt.Errorf("snapshot %v of volume %v doesn't exist", snapshotName, volumeName)
+		return nil, "", fmt.Errorf("snapshot %v of volume %v doesn't exist", snapshotName, volumeName)
 	}
 
-	volume := s.getVolume(volumeName)
-	backupOps, err := s.getBackupOpsForVolume(volume)
+	backupOps, err := s.getBackupOpsForVolume(s.getVolume(volumeName))
 	if err != nil {
-		return err
+		return nil, "", err
+	}
+	return backupOps, volumeName, nil
+}
+
+func (s *daemon) processBackupEstimate(request *api.BackupCreateRequest) (map[string]string, error) {
+	snapshotName := request.SnapshotName
+	backupOps, volumeName, err := s.getBackupOpsForSnapshot(snapshotName)
+	if err != nil {
+		return nil, err
+	}
+	estimateOps, ok := backupOps.(BackupEstimateOperations)
+	if !ok {
+		return nil, fmt.Errorf("Driver %v doesn't support dry run of backup", backupOps.Name())
 	}
 
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:       LOG_REASON_PREPARE,
+		LOG_FIELD_EVENT:        LOG_EVENT_BACKUP,
+		LOG_FIELD_OBJECT:       LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT:     snapshotName,
+		LOG_FIELD_VOLUME:       volumeName,
+		LOG_FIELD_DRIVER:       backupOps.Name(),
+		LOG_FIELD_DEST_URL:     request.URL,
+		LOG_FIELD_ENDPOINT_URL: request.Endpoint,
+	}).Debug("Estimating backup")
+	opts := map[string]string{
+		OPT_VOLUME_NAME: volumeName,
+	}
+	return estimateOps.EstimateBackup(snapshotName, volumeName, request.URL, request.Endpoint, opts)
+}
+
+func (s *daemon) processBackupCreate(request *api.BackupCreateRequest) (string, error) {
+	snapshotName := request.SnapshotName
+	// Keep the snapshot from being removed while the backup is queued
+	unuse := s.snapshotUsers.use(snapshotName)
+	defer unuse()
+	backupOps, volumeName, err := s.getBackupOpsForSnapshot(snapshotName)
+	if err != nil {
+		return "", err
+	}
+	volume := s.getVolume(volumeName)
+
 	volumeInfo, err := s.getVolumeDriverInfo(volume)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	snapshot, err := s.getSnapshotDriverInfo(snapshotName, volume)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	opts := map[string]string{
 		OPT_VOLUME_NAME:           volumeName,
 		OPT_VOLUME_CREATED_TIME:   volumeInfo[OPT_VOLUME_CREATED_TIME],
 		OPT_SNAPSHOT_CREATED_TIME: snapshot[OPT_SNAPSHOT_CREATED_TIME],
+		OPT_EXPORT_IMAGE:          request.ExportImage,
 	}
+	labels, err := objectstore.ParseLabels(request.Labels)
+	if err != nil {
+		return "", err
+	}
+	opts[OPT_BACKUP_LABELS] = objectstore.EncodeLabels(labels)
 
 	log.WithFields(logrus.Fields{
 		LOG_FIELD_REASON:       LOG_REASON_PREPARE,
@@ -122,9 +753,13 @@ func (s *daemon) doBackupCreate(version string, w http.ResponseWriter, r *http.R
 		LOG_FIELD_DEST_URL:     request.URL,
 		LOG_FIELD_ENDPOINT_URL: request.Endpoint,
 	}).Debug()
+	release := s.operations.acquire("backup of snapshot " + snapshotName + " of volume " + volumeName)
 	backupURL, err := backupOps.CreateBackup(snapshotName, volumeName, request.URL, request.Endpoint, opts)
+	release()
+	unuse()
+	s.runPostBackupHook(volume, snapshotName, backupURL, err)
 	if err != nil {
-		return err
+		return "", err
 	}
 	log.WithFields(logrus.Fields{
 		LOG_FIELD_REASON:       LOG_REASON_COMPLETE,
@@ -136,15 +771,17 @@ func (s *daemon) doBackupCreate(version string, w http.ResponseWriter, r *http.R
 		LOG_FIELD_DEST_URL:     request.URL,
 		LOG_FIELD_ENDPOINT_URL: request.Endpoint,
 	}).Debug()
+	s.recordSnapshotChain(volumeName, func(chain *SnapshotChain) {
+		chain.LastBackupSnapshot = snapshotName
+		chain.LastBackupURL = backupURL
+	})
 
-	backup := &api.BackupURLResponse{
-		URL: backupURL,
-	}
-	if request.Verbose {
-		return sendResponse(w, backup)
+	// The backup has been created, only report the failure of pruning
+	if err := s.applyRetention(backupOps, volumeName, backupURL, request.URL, request.Endpoint); err != nil {
+		log.Warnf("Failed to apply retention policy to the backups of volume %v: %v", volumeName, err)
 	}
-	escapedURL := strings.Replace(backupURL, "&", "\\u0026", 1)
-	return writeStringResponse(w, escapedURL)
+
+	return backupURL, nil
 }
 
 func (s *daemon) doBackupDelete(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
@@ -167,7 +804,10 @@ func (s *daemon) doBackupDelete(version string, w http.ResponseWriter, r *http.R
 		LOG_FIELD_ENDPOINT_URL: request.Endpoint,
 		LOG_FIELD_DRIVER:       backupOps.Name(),
 	}).Debug()
-	if err := backupOps.DeleteBackup(request.URL, request.Endpoint); err != nil {
+	opts := map[string]string{
+		OPT_FORCE: strconv.FormatBool(request.Force),
+	}
+	if err := backupOps.DeleteBackup(request.URL, request.Endpoint, opts); err != nil {
 		return err
 	}
 	log.WithFields(logrus.Fields{
@@ -200,8 +840,48 @@ func (s *daemon) getBackupOpsForBackup(requestURL, endpointURL string) (BackupOp
 		driverName = u.Scheme
 	}
 	driver := s.ConvoyDrivers[driverName]
+	if driver == nil {
+		driver = s.getLayeredDriver(driverName)
+	}
 	if driver == nil {
 		return nil, fmt.Errorf("Cannot find driver %v for restoring", driverName)
 	}
 	return driver.BackupOps()
 }
+
+// getLayeredDriver returns the driver built on top of the specified driver,
+// which is not used directly
+func (s *daemon) getLayeredDriver(driverName string) ConvoyDriver {
+	for _, driver := range s.ConvoyDrivers {
+		if layered, ok := driver.(LayeredDriver); ok && layered.UnderlyingDriverName() == driverName {
+			return driver
+		}
+	}
+	return nil
+}
+
+func (s *daemon) doObjectStoreRotateKey(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.ObjectStoreRotateKeyRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	request.URL = util.UnescapeURL(request.URL)
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:       LOG_REASON_START,
+		LOG_FIELD_EVENT:        LOG_EVENT_ROTATE,
+		LOG_FIELD_DEST_URL:     request.URL,
+		LOG_FIELD_ENDPOINT_URL: request.Endpoint,
+	}).Debug("Rotating encryption key of objectstore")
+	report, err := objectstore.RotateEncryptionKey(request.URL, request.Endpoint, request.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	data, err := api.ResponseOutput(report)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,134 @@
+package daemon
+
+import (
+	"fmt"
+	"sync"
+)
+
+/*
+operationLimiter caps the snapshot and backup operations running at the same
+time in the daemon, since each of them could read or write the whole volume.
+The operations beyond the cap wait in a queue and are started in the order
+they arrived. Zero max means unlimited.
+*/
+type operationLimiter struct {
+	mutex   *sync.Mutex
+	max     int
+	running int
+	queue   []chan struct{}
+}
+
+type OperationStats struct {
+	MaxConcurrent int
+	Running       int
+	Queued        int
+}
+
+func newOperationLimiter(max int) (*operationLimiter, error) {
+	if max < 0 {
+		return nil, fmt.Errorf("Invalid max concurrent operations %v, should be 0 for unlimited or positive", max)
+	}
+	return &operationLimiter{
+		mutex: &sync.Mutex{},
+		max:   max,
+	}, nil
+}
+
+// acquire waits for a slot of the operation, and returns the function to
+// release it once the operation is done
+func (l *operationLimiter) acquire(operation string) func() {
+	if l == nil {
+		return func() {}
+	}
+	l.mutex.Lock()
+	if l.max == 0 || l.running < l.max {
+		l.running++
+		l.mutex.Unlock()
+		return l.releaseFunc()
+	}
+	ready := make(chan struct{})
+	l.queue = append(l.queue, ready)
+	position := len(l.queue)
+	l.mutex.Unlock()
+
+	log.Infof("Queued %v behind %v running and %v queued operations", operation, l.max, position-1)
+	<-ready
+	log.Debugf("Started queued %v", operation)
+	return l.releaseFunc()
+}
+
+func (l *operationLimiter) releaseFunc() func() {
+	once := &sync.Once{}
+	return func() {
+		once.Do(l.release)
+	}
+}
+
+// release hands the slot to the first queued operation if there is one
+func (l *operationLimiter) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if len(l.queue) != 0 {
+		ready := l.queue[0]
+		l.queue = l.queue[1:]
+		close(ready)
+		return
+	}
+	l.running--
+}
+
+func (l *operationLimiter) stats() OperationStats {
+	if l == nil {
+		return OperationStats{}
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return OperationStats{
+		MaxConcurrent: l.max,
+		Running:       l.running,
+		Queued:        len(l.queue),
+	}
+}
+
+/*
+snapshotUsers counts the operations using each snapshot, from the time they
+are requested rather than started, so a snapshot waiting in the queue of
+operationLimiter wouldn't be removed by the expiration or the pruning either.
+The snapshots are keyed by name, which is unique in the daemon.
+*/
+type snapshotUsers struct {
+	mutex *sync.Mutex
+	users map[string]int
+}
+
+func newSnapshotUsers() *snapshotUsers {
+	return &snapshotUsers{
+		mutex: &sync.Mutex{},
+		users: map[string]int{},
+	}
+}
+
+// use marks the snapshot in use, and returns the function to unmark it once
+// the operation is done
+func (u *snapshotUsers) use(snapshotName string) func() {
+	u.mutex.Lock()
+	u.users[snapshotName]++
+	u.mutex.Unlock()
+
+	once := &sync.Once{}
+	return func() {
+		once.Do(func() {
+			u.mutex.Lock()
+			defer u.mutex.Unlock()
+			if u.users[snapshotName]--; u.users[snapshotName] == 0 {
+				delete(u.users, snapshotName)
+			}
+		})
+	}
+}
+
+func (u *snapshotUsers) inUse(snapshotName string) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.users[snapshotName] != 0
+}
@@ -0,0 +1,109 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct{}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) SetUpSuite(c *C) {
+	// The entry of the package logger is shared by the goroutines
+	logrus.SetLevel(logrus.WarnLevel)
+}
+
+// waitForStats waits for the limiter to reach the expected state, since the
+// waiters are queued by their own goroutines
+func waitForStats(c *C, l *operationLimiter, running, queued int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := l.stats()
+		if stats.Running == running && stats.Queued == queued {
+			return
+		}
+		if time.Now().After(deadline) {
+			c.Fatalf("Expect %v running and %v queued operations, got %+v", running, queued, stats)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+type testWaiter struct {
+	index   int
+	release func()
+}
+
+func (s *TestSuite) TestOperationLimiterQueue(c *C) {
+	l, err := newOperationLimiter(1)
+	c.Assert(err, IsNil)
+
+	first := l.acquire("operation 0")
+	waitForStats(c, l, 1, 0)
+
+	// Queue the waiters one by one, so their order is known
+	served := make(chan testWaiter)
+	for i := 1; i <= 3; i++ {
+		go func(i int) {
+			release := l.acquire("operation")
+			served <- testWaiter{i, release}
+		}(i)
+		waitForStats(c, l, 1, i)
+	}
+	select {
+	case w := <-served:
+		c.Fatalf("Operation %v started before the slot was released", w.index)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// Each released slot is handed to the first waiter in the queue, which
+	// hands it on once it's done
+	release := first
+	for i := 1; i <= 3; i++ {
+		release()
+		// Releasing again won't free another slot
+		release()
+		w := <-served
+		c.Assert(w.index, Equals, i)
+		waitForStats(c, l, 1, 3-i)
+		release = w.release
+	}
+	release()
+	waitForStats(c, l, 0, 0)
+
+	// No slot leaked after the hand-offs
+	release = l.acquire("operation 4")
+	waitForStats(c, l, 1, 0)
+	release()
+	waitForStats(c, l, 0, 0)
+}
+
+func (s *TestSuite) TestOperationLimiterUnlimited(c *C) {
+	l, err := newOperationLimiter(0)
+	c.Assert(err, IsNil)
+
+	releases := []func(){}
+	for i := 0; i < 100; i++ {
+		releases = append(releases, l.acquire("operation"))
+	}
+	c.Assert(l.stats(), Equals, OperationStats{MaxConcurrent: 0, Running: 100, Queued: 0})
+	for _, release := range releases {
+		release()
+	}
+	c.Assert(l.stats(), Equals, OperationStats{})
+
+	_, err = newOperationLimiter(-1)
+	c.Assert(err, ErrorMatches, "Invalid max concurrent operations -1.*")
+
+	// The limiter is optional for the daemon
+	var nilLimiter *operationLimiter
+	nilLimiter.acquire("operation")()
+	c.Assert(nilLimiter.stats(), Equals, OperationStats{})
+}
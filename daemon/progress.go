@@ -0,0 +1,83 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/objectstore"
+)
+
+var (
+	progressInterval = time.Second
+)
+
+type progressResult struct {
+	output []byte
+	err    error
+}
+
+/*
+streamProgress runs f, and sends the progress of the objectstore operation
+identified by key as one JSON line of api.ProgressResponse every
+progressInterval until f returns. The last line would contain the output or
+the error of f. Since the response header has been sent by then, the error
+is reported in the last line rather than the HTTP status.
+*/
+func streamProgress(w http.ResponseWriter, key string, f func() ([]byte, error)) error {
+	done := make(chan progressResult, 1)
+	go func() {
+		output, err := f()
+		done <- progressResult{output, err}
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case result := <-done:
+			response := &api.ProgressResponse{}
+			if result.err != nil {
+				log.Errorf("Failed to complete operation %v: %v", key, result.err)
+				response.Error = result.err.Error()
+			} else {
+				response.Result = string(result.output)
+			}
+			return encoder.Encode(response)
+		case <-ticker.C:
+			p, ok := objectstore.GetProgress(key)
+			if !ok {
+				continue
+			}
+			if err := encoder.Encode(&api.ProgressResponse{Progress: apiProgress(p)}); err != nil {
+				// The client has gone, let f finish by itself
+				return nil
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func apiProgress(p objectstore.Progress) *api.Progress {
+	now := time.Now()
+	eta := int64(-1)
+	if d := p.ETA(now); d >= 0 {
+		eta = int64(d / time.Second)
+	}
+	return &api.Progress{
+		Operation:        p.Operation,
+		TotalBlocks:      p.TotalBlocks,
+		ProcessedBlocks:  p.ProcessedBlocks,
+		TransferredBytes: p.TransferredBytes,
+		ElapsedSeconds:   int64(now.Sub(p.StartedAt) / time.Second),
+		ETASeconds:       eta,
+	}
+}
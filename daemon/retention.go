@@ -0,0 +1,116 @@
+package daemon
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/objectstore"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+// doBackupRetention sets the retention policy of the backups of the volume,
+// or only shows it if no rule is specified
+func (s *daemon) doBackupRetention(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.BackupRetentionRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+
+	if s.getVolume(request.VolumeName) == nil {
+		return notFoundAPIError
+	}
+
+	policy := &objectstore.RetentionPolicy{
+		KeepLast:    request.KeepLast,
+		KeepDaily:   request.KeepDaily,
+		KeepWeekly:  request.KeepWeekly,
+		KeepMonthly: request.KeepMonthly,
+	}
+	if request.Clear || !policy.IsEmpty() {
+		if request.Clear {
+			policy = nil
+		} else if err := policy.Validate(); err != nil {
+			return err
+		}
+		if err := s.updateVolumeConfig(request.VolumeName, func(volume *Volume) error {
+			volume.Retention = policy
+			return nil
+		}); err != nil {
+			return err
+		}
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_REASON: LOG_REASON_COMPLETE,
+			LOG_FIELD_VOLUME: request.VolumeName,
+		}).Debugf("Updated retention policy to %+v", policy)
+	}
+
+	volume, err := s.loadVolumeConfig(request.VolumeName)
+	if err != nil {
+		return err
+	}
+	if volume == nil || volume.Retention == nil {
+		return sendResponse(w, &objectstore.RetentionPolicy{})
+	}
+	return sendResponse(w, volume.Retention)
+}
+
+/*
+applyRetention removes the backups of the volume in the destination, which
+are expired according to the retention policy of the volume. It's called after
+each successful backup, and the backup just created would never be removed.
+*/
+func (s *daemon) applyRetention(backupOps BackupOperations, volumeName, backupURL, destURL, endpointURL string) error {
+	volume, err := s.loadVolumeConfig(volumeName)
+	if err != nil || volume == nil || volume.Retention == nil {
+		return err
+	}
+
+	infos, err := backupOps.ListBackup(destURL, endpointURL, map[string]string{
+		OPT_VOLUME_NAME: volumeName,
+	})
+	if err != nil {
+		return err
+	}
+	backups := map[string]time.Time{}
+	for url, info := range infos {
+		if info["VolumeName"] != volumeName {
+			continue
+		}
+		t, err := objectstore.ParseBackupTime(info["CreatedTime"])
+		if err != nil {
+			log.Warnf("Skip backup %v for retention: %v", url, err)
+			continue
+		}
+		backups[url] = t
+	}
+
+	opts := map[string]string{
+		OPT_FORCE: strconv.FormatBool(true),
+	}
+	for _, url := range volume.Retention.ExpiredBackups(backups) {
+		if url == backupURL {
+			continue
+		}
+		// The backups retained by the objectstore would be removed later
+		if until, err := objectstore.ParseBackupTime(infos[url]["RetainedUntil"]); err == nil && until.After(time.Now()) {
+			log.Debugf("Skip expired backup %v, which is retained until %v", url, infos[url]["RetainedUntil"])
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_REASON:       LOG_REASON_START,
+			LOG_FIELD_EVENT:        LOG_EVENT_REMOVE,
+			LOG_FIELD_VOLUME:       volumeName,
+			LOG_FIELD_BACKUP_URL:   url,
+			LOG_FIELD_ENDPOINT_URL: endpointURL,
+		}).Debug("Removing expired backup")
+		if err := backupOps.DeleteBackup(url, endpointURL, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	SCHEDULE_CFG_PREFIX = "schedule_"
+)
+
+/*
+Schedule would create a snapshot of the volume and back it up to DestURL at
+the time matches Cron. The snapshot of the last successful backup is kept as
+the base of the next incremental backup, and the older ones created by the
+schedule would be removed. The schedule of SnapshotOnly creates the snapshots
+without backing them up, and leaves them to the snapshot retention of the
+volume, see SnapshotRetention.
+*/
+type Schedule struct {
+	Name         string
+	VolumeName   string
+	Cron         string
+	DestURL      string
+	Endpoint     string
+	Freeze       bool
+	SnapshotOnly bool `json:",omitempty"`
+	// Pause the containers using the volume around the snapshot
+	PauseContainers bool `json:",omitempty"`
+
+	LastSnapshotName string
+	LastBackupURL    string
+	LastRunAt        string
+	LastError        string
+
+	configPath string
+}
+
+func (sc *Schedule) ConfigFile() (string, error) {
+	if sc.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty schedule name")
+	}
+	if sc.configPath == "" {
+		return "", fmt.Errorf("BUG: Invalid empty schedule config path")
+	}
+	return filepath.Join(sc.configPath, SCHEDULE_CFG_PREFIX+sc.Name+CFG_POSTFIX), nil
+}
+
+type scheduler struct {
+	mutex *sync.Mutex
+	// The stop channels of the running schedules
+	stops map[string]chan struct{}
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		mutex: &sync.Mutex{},
+		stops: map[string]chan struct{}{},
+	}
+}
+
+// loadSchedules starts the schedules saved in the root directory
+func (s *daemon) loadSchedules() error {
+	names, err := util.ListConfigIDs(s.Root, SCHEDULE_CFG_PREFIX, CFG_POSTFIX)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		schedule := &Schedule{
+			Name:       name,
+			configPath: s.Root,
+		}
+		if err := util.ObjectLoad(schedule); err != nil {
+			return err
+		}
+		cron, err := util.ParseCron(schedule.Cron)
+		if err != nil {
+			return fmt.Errorf("Invalid schedule %v: %v", name, err)
+		}
+		s.scheduler.mutex.Lock()
+		s.startSchedule(name, cron)
+		s.scheduler.mutex.Unlock()
+	}
+	return nil
+}
+
+// startSchedule must be called with s.scheduler.mutex held
+func (s *daemon) startSchedule(name string, cron *util.CronSchedule) {
+	stop := make(chan struct{})
+	s.scheduler.stops[name] = stop
+	go s.runSchedule(name, cron, stop)
+	log.Debugf("Started schedule %v", name)
+}
+
+func (s *daemon) stopSchedules() {
+	s.scheduler.mutex.Lock()
+	defer s.scheduler.mutex.Unlock()
+
+	for name, stop := range s.scheduler.stops {
+		close(stop)
+		delete(s.scheduler.stops, name)
+	}
+}
+
+func (s *daemon) runSchedule(name string, cron *util.CronSchedule, stop chan struct{}) {
+	for {
+		next := cron.Next(time.Now())
+		if next.IsZero() {
+			log.Warnf("Schedule %v would never be triggered", name)
+			return
+		}
+		timer := time.NewTimer(next.Sub(time.Now()))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.triggerSchedule(name)
+	}
+}
+
+// triggerSchedule creates the snapshot and the backup. The runs of one
+// schedule never overlap, since they're in the same goroutine.
+func (s *daemon) triggerSchedule(name string) {
+	schedule := &Schedule{
+		Name:       name,
+		configPath: s.Root,
+	}
+	if err := util.ObjectLoad(schedule); err != nil {
+		log.Errorf("Failed to load schedule %v: %v", name, err)
+		return
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_BACKUP,
+		LOG_FIELD_VOLUME:   schedule.VolumeName,
+		LOG_FIELD_DEST_URL: schedule.DestURL,
+	}).Debugf("Triggered schedule %v", name)
+
+	schedule.LastRunAt = util.Now()
+	run := s.runScheduledBackup
+	if schedule.SnapshotOnly {
+		run = s.runScheduledSnapshot
+	}
+	if err := run(schedule); err != nil {
+		log.Errorf("Failed to run schedule %v: %v", name, err)
+		schedule.LastError = err.Error()
+	} else {
+		schedule.LastError = ""
+	}
+
+	// Don't save the schedule again if it has been deleted during the run
+	s.scheduler.mutex.Lock()
+	defer s.scheduler.mutex.Unlock()
+	if _, ok := s.scheduler.stops[name]; !ok {
+		return
+	}
+	if err := util.ObjectSave(schedule); err != nil {
+		log.Errorf("Failed to save schedule %v: %v", name, err)
+	}
+}
+
+func (s *daemon) runScheduledSnapshot(schedule *Schedule) error {
+	snapshotName, _, err := s.processSnapshotCreate(&api.SnapshotCreateRequest{
+		VolumeName:      schedule.VolumeName,
+		Freeze:          schedule.Freeze,
+		PauseContainers: schedule.PauseContainers,
+	})
+	if err != nil {
+		return err
+	}
+	schedule.LastSnapshotName = snapshotName
+	return nil
+}
+
+func (s *daemon) runScheduledBackup(schedule *Schedule) error {
+	snapshotName, _, err := s.processSnapshotCreate(&api.SnapshotCreateRequest{
+		VolumeName:      schedule.VolumeName,
+		Freeze:          schedule.Freeze,
+		PauseContainers: schedule.PauseContainers,
+	})
+	if err != nil {
+		return err
+	}
+	backupURL, err := s.processBackupCreate(&api.BackupCreateRequest{
+		URL:          schedule.DestURL,
+		Endpoint:     schedule.Endpoint,
+		SnapshotName: snapshotName,
+	})
+	if err != nil {
+		// Keep the last snapshot as the base of next backup
+		if err := s.processSnapshotDelete(snapshotName); err != nil {
+			log.Warnf("Failed to remove snapshot %v of failed schedule %v: %v", snapshotName, schedule.Name, err)
+		}
+		return err
+	}
+
+	lastSnapshotName := schedule.LastSnapshotName
+	schedule.LastSnapshotName = snapshotName
+	schedule.LastBackupURL = backupURL
+	if lastSnapshotName != "" && s.SnapshotVolumeIndex.Get(lastSnapshotName) != "" {
+		if err := s.processSnapshotDelete(lastSnapshotName); err != nil {
+			log.Warnf("Failed to remove snapshot %v of schedule %v: %v", lastSnapshotName, schedule.Name, err)
+		}
+	}
+	return nil
+}
+
+func (s *daemon) doScheduleCreate(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.ScheduleCreateRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	request.URL = util.UnescapeURL(request.URL)
+
+	if s.getVolume(request.VolumeName) == nil {
+		return fmt.Errorf("volume %v doesn't exist", request.VolumeName)
+	}
+	if request.SnapshotOnly {
+		if request.URL != "" {
+			return fmt.Errorf("Snapshot schedule cannot have backup destination %v", request.URL)
+		}
+	} else if request.URL == "" {
+		return fmt.Errorf("Missing backup destination of schedule")
+	}
+	cron, err := util.ParseCron(request.Cron)
+	if err != nil {
+		return err
+	}
+
+	schedule := &Schedule{
+		Name:            request.Name,
+		VolumeName:      request.VolumeName,
+		Cron:            request.Cron,
+		DestURL:         request.URL,
+		Endpoint:        request.Endpoint,
+		Freeze:          request.Freeze,
+		SnapshotOnly:    request.SnapshotOnly,
+		PauseContainers: request.PauseContainers,
+		configPath:      s.Root,
+	}
+
+	s.scheduler.mutex.Lock()
+	defer s.scheduler.mutex.Unlock()
+
+	if schedule.Name == "" {
+		schedule.Name = util.GenerateName("schedule")
+	} else if err := util.CheckName(schedule.Name); err != nil {
+		return err
+	}
+	if _, exists := s.scheduler.stops[schedule.Name]; exists {
+		return fmt.Errorf("Schedule %v already exists", schedule.Name)
+	}
+	if err := util.ObjectSave(schedule); err != nil {
+		return err
+	}
+	s.startSchedule(schedule.Name, cron)
+
+	return writeStringResponse(w, schedule.Name)
+}
+
+func (s *daemon) doScheduleDelete(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.ScheduleDeleteRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+
+	s.scheduler.mutex.Lock()
+	defer s.scheduler.mutex.Unlock()
+
+	stop, ok := s.scheduler.stops[request.Name]
+	if !ok {
+		return fmt.Errorf("Schedule %v doesn't exist", request.Name)
+	}
+	schedule := &Schedule{
+		Name:       request.Name,
+		configPath: s.Root,
+	}
+	if err := util.ObjectDelete(schedule); err != nil {
+		return err
+	}
+	close(stop)
+	delete(s.scheduler.stops, request.Name)
+	log.Debugf("Removed schedule %v", request.Name)
+	return nil
+}
+
+func (s *daemon) doScheduleList(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	names, err := util.ListConfigIDs(s.Root, SCHEDULE_CFG_PREFIX, CFG_POSTFIX)
+	if err != nil {
+		return err
+	}
+	result := map[string]map[string]string{}
+	for _, name := range names {
+		schedule := &Schedule{
+			Name:       name,
+			configPath: s.Root,
+		}
+		if err := util.ObjectLoad(schedule); err != nil {
+			return err
+		}
+		nextRunAt := ""
+		if cron, err := util.ParseCron(schedule.Cron); err == nil {
+			if next := cron.Next(time.Now()); !next.IsZero() {
+				nextRunAt = next.Format(time.RubyDate)
+			}
+		}
+		result[name] = map[string]string{
+			"Name":             schedule.Name,
+			"VolumeName":       schedule.VolumeName,
+			"Cron":             schedule.Cron,
+			"DestURL":          schedule.DestURL,
+			"Freeze":           strconv.FormatBool(schedule.Freeze),
+			"PauseContainers":  strconv.FormatBool(schedule.PauseContainers),
+			"SnapshotOnly":     strconv.FormatBool(schedule.SnapshotOnly),
+			"NextRunAt":        nextRunAt,
+			"LastRunAt":        schedule.LastRunAt,
+			"LastSnapshotName": schedule.LastSnapshotName,
+			"LastBackupURL":    schedule.LastBackupURL,
+			"LastError":        schedule.LastError,
+		}
+	}
+	return writeResponseOutput(w, result)
+}
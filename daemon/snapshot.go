@@ -3,9 +3,15 @@ package daemon
 import (
 	"fmt"
 	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/metadata"
+	"github.com/rancher/convoy/objectstore"
 	"github.com/rancher/convoy/util"
 
 	. "github.com/rancher/convoy/convoydriver"
@@ -26,23 +32,108 @@ func (s *daemon) doSnapshotCreate(version string, w http.ResponseWriter, r *http
 	if err := decodeRequest(r, request); err != nil {
 		return err
 	}
+	snapshotName, volume, err := s.processSnapshotCreate(request)
+	if err != nil {
+		return err
+	}
+	driverInfo, err := s.getSnapshotDriverInfo(snapshotName, volume)
+	if err != nil {
+		return err
+	}
+	if request.Verbose {
+		resp := getSnapshotResponse(snapshotName, driverInfo)
+		resp.VolumeName = volume.Name
+		resp.ExpiresAt = s.getSnapshotExpirations(volume.Name)[snapshotName]
+		return writeResponseOutput(w, resp)
+	}
+	return writeStringResponse(w, snapshotName)
+}
+
+// getSnapshotResponse returns the response of the snapshot with the labels
+// and the description recorded by the driver
+func getSnapshotResponse(snapshotName string, driverInfo map[string]string) api.SnapshotResponse {
+	return api.SnapshotResponse{
+		Name:        snapshotName,
+		CreatedTime: driverInfo[OPT_SNAPSHOT_CREATED_TIME],
+		Labels:      objectstore.DecodeLabels(driverInfo[OPT_SNAPSHOT_LABELS]),
+		Description: driverInfo[OPT_SNAPSHOT_DESCRIPTION],
+		DriverInfo:  driverInfo,
+	}
+}
+
+/*
+freezeVolume freezes the filesystem of the volume if it's mounted, so the
+snapshot would be consistent. The returned function would thaw it. Only the
+volume mounted from a block device can be frozen. The mount point of the
+volumes of the directory backed drivers, e.g. vfs, is a directory on the
+filesystem of the host, which would be frozen as a whole otherwise, including
+the root of the daemon where the driver writes the snapshot.
+*/
+func (s *daemon) freezeVolume(volume *Volume) (func(), error) {
+	mountPoint, freezable, err := s.getFreezableMountPoint(volume)
+	if err != nil {
+		return nil, err
+	}
+	if mountPoint == "" {
+		log.Debugf("Volume %v is not mounted, no need to freeze", volume.Name)
+		return func() {}, nil
+	}
+	if !freezable {
+		return nil, fmt.Errorf("Cannot freeze volume %v of driver %v, %v is not a mount of its own block device, freezing it would freeze the filesystem holding it",
+			volume.Name, volume.DriverName, mountPoint)
+	}
+	return freezeMountPoint(volume, mountPoint)
+}
+
+// getFreezableMountPoint returns the mount point of the volume, empty if it's
+// not mounted, and whether it's a mount of its own block device, which is the
+// only kind safe to be frozen
+func (s *daemon) getFreezableMountPoint(volume *Volume) (string, bool, error) {
+	mountPoint, err := s.getVolumeMountPoint(volume)
+	if err != nil || mountPoint == "" {
+		return "", false, err
+	}
+	device, err := util.GetMountDevice(mountPoint)
+	if err != nil {
+		return "", false, err
+	}
+	return mountPoint, device != "" && util.IsBlockDevice(device), nil
+}
+
+// freezeMountPoint flushes and freezes the filesystem of the volume, and
+// returns the function to unfreeze it
+func freezeMountPoint(volume *Volume, mountPoint string) (func(), error) {
+	if err := util.Sync(); err != nil {
+		return nil, err
+	}
+	if err := util.Freeze(mountPoint); err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := util.UnFreeze(mountPoint); err != nil {
+			log.Errorf("Failed to unfreeze %v of volume %v: %v", mountPoint, volume.Name, err)
+		}
+	}, nil
+}
+
+func (s *daemon) processSnapshotCreate(request *api.SnapshotCreateRequest) (string, *Volume, error) {
 	volumeName := request.VolumeName
 	if err := util.CheckName(volumeName); err != nil {
-		return err
+		return "", nil, err
 	}
 	volume := s.getVolume(volumeName)
 	if volume == nil {
-		return fmt.Errorf("volume %v doesn't exist", volumeName)
+		return "", nil, fmt.Errorf("volume %v doesn't exist", volumeName)
 	}
 
 	snapshotName := request.Name
 	if snapshotName != "" {
 		if err := util.CheckName(snapshotName); err != nil {
-			return err
+			return "", nil, err
 		}
 		existName := s.NameUUIDIndex.Get(snapshotName)
 		if existName != "" {
-			return fmt.Errorf("Snapshot name %v already exists", snapshotName)
+			return "", nil, fmt.Errorf("Snapshot name %v already exists", snapshotName)
 		}
 	} else {
 		snapshotName = util.GenerateName("snapshot")
@@ -51,24 +142,108 @@ func (s *daemon) doSnapshotCreate(version string, w http.ResponseWriter, r *http
 		}
 	}
 
+	labels, err := objectstore.ParseLabels(request.Labels)
+	if err != nil {
+		return "", nil, err
+	}
+	var ttl time.Duration
+	if request.TTL != "" {
+		if ttl, err = parseSnapshotTTL(request.TTL); err != nil {
+			return "", nil, err
+		}
+	}
+
 	snapOps, err := s.getSnapshotOpsForVolume(volume)
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 
 	req := Request{
 		Name: snapshotName,
 		Options: map[string]string{
-			OPT_VOLUME_NAME: volumeName,
+			OPT_VOLUME_NAME:          volumeName,
+			OPT_SNAPSHOT_LABELS:      objectstore.EncodeLabels(labels),
+			OPT_SNAPSHOT_DESCRIPTION: request.Description,
+			OPT_SNAPSHOT_COMPRESSION: request.Compression,
 		},
 	}
 
+	// Wait for the slot before the hooks, so the applications wouldn't be
+	// quiesced while queued
+	release := s.operations.acquire("snapshot " + snapshotName + " of volume " + volumeName)
+	if err := s.runPreSnapshotHook(volume, snapshotName); err != nil {
+		release()
+		return "", nil, err
+	}
+
+	unquiesce, err := s.runQuiesceHook(volume, snapshotName)
+	if err != nil {
+		release()
+		return "", nil, err
+	}
+	unpause := func() {}
+	if request.PauseContainers {
+		unpause, err = s.pauseContainers([]*Volume{volume})
+	}
+	if err == nil {
+		err = s.createSnapshot(snapOps, req, volume, request.Freeze)
+		unpause()
+	}
+	unquiesce(err)
+	release()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := s.addSnapshot(snapshotName, volumeName); err != nil {
+		return "", nil, err
+	}
+	if ttl != 0 {
+		if err := s.setSnapshotExpiration(volumeName, snapshotName, time.Now().Add(ttl)); err != nil {
+			return "", nil, err
+		}
+	}
+	return snapshotName, volume, nil
+}
+
+// addSnapshot records the snapshot created by the driver in the indexes and
+// the snapshot chain of the volume, then prunes the older snapshots
+func (s *daemon) addSnapshot(snapshotName, volumeName string) error {
+	//TODO: error handling
+	if err := s.SnapshotVolumeIndex.Add(snapshotName, volumeName); err != nil {
+		return err
+	}
+	if err := s.NameUUIDIndex.Add(snapshotName, "exists"); err != nil {
+		return err
+	}
+	s.recordSnapshotChain(volumeName, func(chain *SnapshotChain) {
+		chain.addSnapshot(snapshotName)
+	})
+
+	// The snapshot has been created, only report the failure of pruning
+	if err := s.pruneSnapshots(volumeName, snapshotName); err != nil {
+		log.Warnf("Failed to prune the snapshots of volume %v: %v", volumeName, err)
+	}
+	return nil
+}
+
+// createSnapshot creates the snapshot by the driver, with the filesystem of
+// the volume frozen if freeze is true
+func (s *daemon) createSnapshot(snapOps SnapshotOperations, req Request, volume *Volume, freeze bool) error {
+	if freeze {
+		unfreeze, err := s.freezeVolume(volume)
+		if err != nil {
+			return err
+		}
+		defer unfreeze()
+	}
+
 	log.WithFields(logrus.Fields{
 		LOG_FIELD_REASON:   LOG_REASON_PREPARE,
 		LOG_FIELD_EVENT:    LOG_EVENT_CREATE,
 		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
-		LOG_FIELD_SNAPSHOT: snapshotName,
-		LOG_FIELD_VOLUME:   volumeName,
+		LOG_FIELD_SNAPSHOT: req.Name,
+		LOG_FIELD_VOLUME:   volume.Name,
 	}).Debug()
 	if err := snapOps.CreateSnapshot(req); err != nil {
 		return err
@@ -77,30 +252,10 @@ func (s *daemon) doSnapshotCreate(version string, w http.ResponseWriter, r *http
 		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
 		LOG_FIELD_EVENT:    LOG_EVENT_CREATE,
 		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
-		LOG_FIELD_SNAPSHOT: snapshotName,
-		LOG_FIELD_VOLUME:   volumeName,
+		LOG_FIELD_SNAPSHOT: req.Name,
+		LOG_FIELD_VOLUME:   volume.Name,
 	}).Debug()
-
-	//TODO: error handling
-	if err := s.SnapshotVolumeIndex.Add(snapshotName, volume.Name); err != nil {
-		return err
-	}
-	if err := s.NameUUIDIndex.Add(snapshotName, "exists"); err != nil {
-		return err
-	}
-	driverInfo, err := s.getSnapshotDriverInfo(snapshotName, volume)
-	if err != nil {
-		return err
-	}
-	if request.Verbose {
-		return writeResponseOutput(w, api.SnapshotResponse{
-			Name:        snapshotName,
-			VolumeName:  volume.Name,
-			CreatedTime: driverInfo[OPT_SNAPSHOT_CREATED_TIME],
-			DriverInfo:  driverInfo,
-		})
-	}
-	return writeStringResponse(w, snapshotName)
+	return nil
 }
 
 func (s *daemon) getSnapshotDriverInfo(snapshotName string, volume *Volume) (map[string]string, error) {
@@ -137,12 +292,42 @@ func (s *daemon) listSnapshotDriverInfos(volume *Volume) (map[string]map[string]
 	return snapshots, nil
 }
 
+// sortSnapshotsByCreatedTime returns the names of the snapshots of the volume
+// in infos from the oldest one, only the snapshots known by the daemon are
+// included
+func (s *daemon) sortSnapshotsByCreatedTime(volumeName string, infos map[string]map[string]string) []string {
+	names := []string{}
+	createdTimes := map[string]time.Time{}
+	for name, info := range infos {
+		if s.SnapshotVolumeIndex.Get(name) != volumeName {
+			continue
+		}
+		t, err := objectstore.ParseBackupTime(info[OPT_SNAPSHOT_CREATED_TIME])
+		if err != nil {
+			t = time.Time{}
+		}
+		createdTimes[name] = t
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ti, tj := createdTimes[names[i]], createdTimes[names[j]]
+		if ti.Equal(tj) {
+			return names[i] < names[j]
+		}
+		return ti.Before(tj)
+	})
+	return names
+}
+
 func (s *daemon) doSnapshotDelete(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
 	request := &api.SnapshotDeleteRequest{}
 	if err := decodeRequest(r, request); err != nil {
 		return err
 	}
-	snapshotName := request.SnapshotName
+	return s.processSnapshotDelete(request.SnapshotName)
+}
+
+func (s *daemon) processSnapshotDelete(snapshotName string) error {
 	if err := util.CheckName(snapshotName); err != nil {
 		return err
 	}
@@ -192,9 +377,211 @@ func (s *daemon) doSnapshotDelete(version string, w http.ResponseWriter, r *http
 	if err := s.NameUUIDIndex.Delete(snapshotName); err != nil {
 		return err
 	}
+	s.recordSnapshotChain(volumeName, func(chain *SnapshotChain) {
+		chain.removeSnapshot(snapshotName)
+	})
+	s.forgetSnapshotChecksum(volumeName, snapshotName)
+	s.forgetSnapshotExpiration(volumeName, snapshotName)
+	return nil
+}
+
+// getSnapshotMountOps returns the driver of the snapshot to mount it, and the
+// volume of the snapshot
+func (s *daemon) getSnapshotMountOps(snapshotName string) (SnapshotMountOperations, string, error) {
+	if err := util.CheckName(snapshotName); err != nil {
+		return nil, "", err
+	}
+	volumeName := s.SnapshotVolumeIndex.Get(snapshotName)
+	if volumeName == "" {
+		return nil, "", fmt.Errorf("cannot find volume for snapshot %v", snapshotName)
+	}
+	if !s.snapshotExists(volumeName, snapshotName) {
+		return nil, "", fmt.Errorf("snapshot %v of volume %v doesn't exist", snapshotName, volumeName)
+	}
+	snapOps, err := s.getSnapshotOpsForVolume(s.getVolume(volumeName))
+	if err != nil {
+		return nil, "", err
+	}
+	mountOps, ok := snapOps.(SnapshotMountOperations)
+	if !ok {
+		return nil, "", fmt.Errorf("Driver %v doesn't support mounting snapshots", snapOps.Name())
+	}
+	return mountOps, volumeName, nil
+}
+
+// doSnapshotMount mounts the snapshot read-only, so the files can be copied
+// out of it without restoring
+func (s *daemon) doSnapshotMount(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.SnapshotMountRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	mountOps, volumeName, err := s.getSnapshotMountOps(request.SnapshotName)
+	if err != nil {
+		return err
+	}
+
+	req := Request{
+		Name: request.SnapshotName,
+		Options: map[string]string{
+			OPT_VOLUME_NAME: volumeName,
+			OPT_MOUNT_POINT: request.MountPoint,
+		},
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_PREPARE,
+		LOG_FIELD_EVENT:    LOG_EVENT_MOUNT,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: request.SnapshotName,
+		LOG_FIELD_VOLUME:   volumeName,
+		LOG_FIELD_OPTS:     req.Options,
+	}).Debug()
+	mountPoint, err := mountOps.MountSnapshot(req)
+	if err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:     LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:      LOG_EVENT_MOUNT,
+		LOG_FIELD_OBJECT:     LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT:   request.SnapshotName,
+		LOG_FIELD_MOUNTPOINT: mountPoint,
+	}).Debug()
+	return writeStringResponse(w, mountPoint)
+}
+
+func (s *daemon) doSnapshotUmount(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.SnapshotUmountRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	mountOps, volumeName, err := s.getSnapshotMountOps(request.SnapshotName)
+	if err != nil {
+		return err
+	}
+
+	req := Request{
+		Name: request.SnapshotName,
+		Options: map[string]string{
+			OPT_VOLUME_NAME: volumeName,
+		},
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_PREPARE,
+		LOG_FIELD_EVENT:    LOG_EVENT_UMOUNT,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: request.SnapshotName,
+		LOG_FIELD_VOLUME:   volumeName,
+	}).Debug()
+	if err := mountOps.UmountSnapshot(req); err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:    LOG_EVENT_UMOUNT,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: request.SnapshotName,
+	}).Debug()
 	return nil
 }
 
+func (s *daemon) doSnapshotRevert(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.SnapshotRevertRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	volumeName, err := s.processSnapshotRevert(request.SnapshotName)
+	if err != nil {
+		return err
+	}
+	return writeStringResponse(w, volumeName)
+}
+
+/*
+processSnapshotRevert rolls the volume of the snapshot back to it in place.
+The volume would be umounted before reverting, and mounted at the same mount
+point again afterwards, even if the revert failed.
+*/
+func (s *daemon) processSnapshotRevert(snapshotName string) (string, error) {
+	if err := util.CheckName(snapshotName); err != nil {
+		return "", err
+	}
+	volumeName := s.SnapshotVolumeIndex.Get(snapshotName)
+	if volumeName == "" {
+		return "", fmt.Errorf("cannot find volume for snapshot %v", snapshotName)
+	}
+
+	volume := s.getVolume(volumeName)
+	if !s.snapshotExists(volumeName, snapshotName) {
+		return "", fmt.Errorf("snapshot %v of volume %v doesn't exist", snapshotName, volumeName)
+	}
+
+	snapOps, err := s.getSnapshotOpsForVolume(volume)
+	if err != nil {
+		return "", err
+	}
+	revertOps, ok := snapOps.(SnapshotRevertOperations)
+	if !ok {
+		return "", fmt.Errorf("Driver %v doesn't support reverting volumes to snapshots", snapOps.Name())
+	}
+
+	mountPoint, err := s.getVolumeMountPoint(volume)
+	if err != nil {
+		return "", err
+	}
+	if mountPoint != "" {
+		if err := s.processVolumeUmount(volume); err != nil {
+			return "", err
+		}
+	}
+
+	req := Request{
+		Name: snapshotName,
+		Options: map[string]string{
+			OPT_VOLUME_NAME: volumeName,
+		},
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_PREPARE,
+		LOG_FIELD_EVENT:    LOG_EVENT_REVERT,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: snapshotName,
+		LOG_FIELD_VOLUME:   volumeName,
+	}).Debug()
+	revertErr := revertOps.RevertSnapshot(req)
+	if revertErr == nil {
+		s.recordSnapshotChain(volumeName, func(chain *SnapshotChain) {
+			chain.Head = snapshotName
+		})
+	}
+
+	if mountPoint != "" {
+		mountRequest := &api.VolumeMountRequest{
+			VolumeName: volumeName,
+			MountPoint: mountPoint,
+		}
+		if _, err := s.processVolumeMount(volume, mountRequest); err != nil {
+			if revertErr != nil {
+				log.Errorf("Failed to mount volume %v at %v again: %v", volumeName, mountPoint, err)
+				return "", revertErr
+			}
+			return "", fmt.Errorf("Reverted volume %v to snapshot %v, but failed to mount it at %v again: %v",
+				volumeName, snapshotName, mountPoint, err)
+		}
+	}
+	if revertErr != nil {
+		return "", revertErr
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:    LOG_EVENT_REVERT,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: snapshotName,
+		LOG_FIELD_VOLUME:   volumeName,
+	}).Debug()
+	return volumeName, nil
+}
+
 func (s *daemon) doSnapshotInspect(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
 	request := &api.SnapshotInspectRequest{}
 	if err := decodeRequest(r, request); err != nil {
@@ -229,12 +616,158 @@ func (s *daemon) doSnapshotInspect(version string, w http.ResponseWriter, r *htt
 		return err
 	}
 
-	resp := api.SnapshotResponse{
-		Name:            snapshotName,
-		VolumeName:      volumeName,
-		VolumeCreatedAt: volumeDriverInfo[OPT_VOLUME_CREATED_TIME],
-		CreatedTime:     snapshot[OPT_SNAPSHOT_CREATED_TIME],
-		DriverInfo:      driverInfo,
+	resp := getSnapshotResponse(snapshotName, driverInfo)
+	resp.VolumeName = volumeName
+	resp.VolumeCreatedAt = volumeDriverInfo[OPT_VOLUME_CREATED_TIME]
+	resp.CreatedTime = snapshot[OPT_SNAPSHOT_CREATED_TIME]
+	resp.ExpiresAt = s.getSnapshotExpirations(volumeName)[snapshotName]
+	data, err := api.ResponseOutput(resp)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+/*
+doSnapshotList lists the snapshots of the volume, or of all the volumes if the
+volume name is not specified, which have all the labels of the request. The
+volumes of the drivers which don't support snapshots are skipped.
+*/
+func (s *daemon) doSnapshotList(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.SnapshotListRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	selector, err := objectstore.ParseLabels(request.Labels)
+	if err != nil {
+		return err
+	}
+
+	volumeNames := []string{}
+	if request.VolumeName != "" {
+		if err := util.CheckName(request.VolumeName); err != nil {
+			return err
+		}
+		if s.getVolume(request.VolumeName) == nil {
+			return fmt.Errorf("volume %v doesn't exist", request.VolumeName)
+		}
+		volumeNames = append(volumeNames, request.VolumeName)
+	} else {
+		for name := range s.getVolumeList() {
+			volumeNames = append(volumeNames, name)
+		}
+	}
+
+	result := map[string]api.SnapshotResponse{}
+	for _, volumeName := range volumeNames {
+		volume := s.getVolume(volumeName)
+		if volume == nil {
+			return fmt.Errorf("Volume list changed for volume %v", volumeName)
+		}
+		snapshots, err := s.listSnapshotDriverInfos(volume)
+		if err != nil {
+			continue
+		}
+		expirations := s.getSnapshotExpirations(volumeName)
+		for name, snapshot := range snapshots {
+			resp := getSnapshotResponse(name, snapshot)
+			if !objectstore.MatchLabels(resp.Labels, selector) {
+				continue
+			}
+			resp.VolumeName = volumeName
+			resp.ExpiresAt = expirations[name]
+			result[name] = resp
+		}
+	}
+	return writeResponseOutput(w, result)
+}
+
+/*
+doSnapshotDiff reports the extents of the volume changed between two snapshots
+of it, and the total changed bytes. Without CompareSnapshotName all the data
+allocated in the snapshot would be reported, the same as a full backup.
+*/
+func (s *daemon) doSnapshotDiff(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.SnapshotDiffRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	snapshotName := request.SnapshotName
+	if err := util.CheckName(snapshotName); err != nil {
+		return err
+	}
+	compareName := request.CompareSnapshotName
+	if compareName != "" {
+		if err := util.CheckName(compareName); err != nil {
+			return err
+		}
+		if compareName == snapshotName {
+			return fmt.Errorf("Cannot compare snapshot %v with itself", snapshotName)
+		}
+	}
+
+	backupOps, volumeName, err := s.getBackupOpsForSnapshot(snapshotName)
+	if err != nil {
+		return err
+	}
+	if compareName != "" {
+		if s.SnapshotVolumeIndex.Get(compareName) != volumeName {
+			return fmt.Errorf("Snapshot %v doesn't belong to volume %v of snapshot %v",
+				compareName, volumeName, snapshotName)
+		}
+		if !s.snapshotExists(volumeName, compareName) {
+			return fmt.Errorf("snapshot %v of volume %v doesn't exist", compareName, volumeName)
+		}
+	}
+	deltaOps, ok := backupOps.(objectstore.DeltaBlockBackupOperations)
+	if !ok {
+		return fmt.Errorf("Driver %v doesn't support comparing snapshots", backupOps.Name())
+	}
+	volumeInfo, err := s.getVolumeDriverInfo(s.getVolume(volumeName))
+	if err != nil {
+		return err
+	}
+	size, err := strconv.ParseInt(volumeInfo[OPT_SIZE], 10, 64)
+	if err != nil {
+		return fmt.Errorf("Cannot get the size of volume %v: %v", volumeName, err)
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_PREPARE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: snapshotName,
+		LOG_FIELD_VOLUME:   volumeName,
+	}).Debugf("Comparing snapshot with %v", compareName)
+	delta, err := deltaOps.CompareSnapshot(snapshotName, compareName, volumeName)
+	if err != nil {
+		return err
+	}
+
+	resp := api.SnapshotDiffResponse{
+		SnapshotName:        snapshotName,
+		CompareSnapshotName: compareName,
+		VolumeName:          volumeName,
+		Extents:             []api.SnapshotExtent{},
+	}
+	blockSize := delta.BlockSize
+	if blockSize <= 0 {
+		blockSize = objectstore.DEFAULT_BLOCK_SIZE
+	}
+	// The ranges reported by the drivers may overlap or be adjacent, and the
+	// last block may go beyond the end of the volume
+	for _, m := range metadata.AlignMappings(delta, blockSize).Mappings {
+		if size > 0 && m.Offset+m.Size > size {
+			m.Size = size - m.Offset
+		}
+		if m.Size <= 0 {
+			continue
+		}
+		resp.Extents = append(resp.Extents, api.SnapshotExtent{
+			Offset: m.Offset,
+			Size:   m.Size,
+		})
+		resp.ChangedBytes += m.Size
 	}
 	data, err := api.ResponseOutput(resp)
 	if err != nil {
@@ -243,3 +776,94 @@ func (s *daemon) doSnapshotInspect(version string, w http.ResponseWriter, r *htt
 	_, err = w.Write(data)
 	return err
 }
+
+// doSnapshotExport writes the snapshot to the local file as a sparse raw
+// image of the volume size
+func (s *daemon) doSnapshotExport(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.SnapshotExportRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	if err := util.CheckName(request.SnapshotName); err != nil {
+		return err
+	}
+	if !filepath.IsAbs(request.FilePath) {
+		return fmt.Errorf("File path %v must be absolute", request.FilePath)
+	}
+	deltaOps, volume, err := s.getSnapshotImageOps(request.SnapshotName)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_EXPORT,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: request.SnapshotName,
+		LOG_FIELD_VOLUME:   volume.Name,
+		LOG_FIELD_FILEPATH: request.FilePath,
+		LOG_FIELD_SIZE:     volume.Size,
+	}).Debug("Exporting snapshot")
+	if err := objectstore.ExportSnapshotImage(request.FilePath, volume, request.SnapshotName, deltaOps); err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:    LOG_EVENT_EXPORT,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: request.SnapshotName,
+		LOG_FIELD_VOLUME:   volume.Name,
+		LOG_FIELD_FILEPATH: request.FilePath,
+	}).Debug()
+	return writeStringResponse(w, request.FilePath)
+}
+
+/*
+doSnapshotSpace reports the space held only by each snapshot of the volume
+from the oldest one, which deleting the snapshot would reclaim, and the total
+of them.
+*/
+func (s *daemon) doSnapshotSpace(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.SnapshotSpaceRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	volumeName := request.VolumeName
+	if err := util.CheckName(volumeName); err != nil {
+		return err
+	}
+	volume := s.getVolume(volumeName)
+	if volume == nil {
+		return notFoundAPIError
+	}
+	snapOps, err := s.getSnapshotOpsForVolume(volume)
+	if err != nil {
+		return err
+	}
+	spaceOps, ok := snapOps.(SnapshotSpaceOperations)
+	if !ok {
+		return fmt.Errorf("Driver %v doesn't support reporting the space of snapshots", snapOps.Name())
+	}
+	infos, err := s.listSnapshotDriverInfos(volume)
+	if err != nil {
+		return err
+	}
+	sizes, err := spaceOps.GetSnapshotUniqueSizes(volumeName)
+	if err != nil {
+		return err
+	}
+
+	resp := &api.SnapshotSpaceResponse{
+		VolumeName: volumeName,
+		Snapshots:  []api.SnapshotSpaceEntry{},
+	}
+	for _, name := range s.sortSnapshotsByCreatedTime(volumeName, infos) {
+		resp.Snapshots = append(resp.Snapshots, api.SnapshotSpaceEntry{
+			Name:        name,
+			CreatedTime: infos[name][OPT_SNAPSHOT_CREATED_TIME],
+			UniqueSize:  sizes[name],
+		})
+		resp.TotalUniqueSize += sizes[name]
+	}
+	return sendResponse(w, resp)
+}
@@ -0,0 +1,158 @@
+package daemon
+
+import (
+	"net/http"
+
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+)
+
+/*
+SnapshotChain records the ancestry of the local snapshots of a volume, which
+is saved with the volume. The parent of a snapshot is the snapshot the content
+of the volume was derived from when it was taken, which is Head: the latest
+snapshot, or the one the volume was reverted to. The snapshots taken before
+the chain was recorded have no parent. The snapshot of the latest backup
+created by the daemon is recorded as well, since the objectstore only knows
+the latest backup of the volume.
+*/
+type SnapshotChain struct {
+	Head               string            `json:",omitempty"`
+	Parents            map[string]string `json:",omitempty"`
+	LastBackupSnapshot string            `json:",omitempty"`
+	LastBackupURL      string            `json:",omitempty"`
+}
+
+func (c *SnapshotChain) addSnapshot(snapshotName string) {
+	if c.Head != "" {
+		if c.Parents == nil {
+			c.Parents = map[string]string{}
+		}
+		c.Parents[snapshotName] = c.Head
+	}
+	c.Head = snapshotName
+}
+
+// removeSnapshot removes the snapshot from the chain, its children would be
+// children of its parent instead
+func (c *SnapshotChain) removeSnapshot(snapshotName string) {
+	parent := c.Parents[snapshotName]
+	for child, p := range c.Parents {
+		if p != snapshotName {
+			continue
+		}
+		if parent == "" {
+			delete(c.Parents, child)
+		} else {
+			c.Parents[child] = parent
+		}
+	}
+	delete(c.Parents, snapshotName)
+	if c.Head == snapshotName {
+		c.Head = parent
+	}
+}
+
+// getParent returns the nearest ancestor of the snapshot which exists, in
+// case the snapshots were removed without the daemon
+func (c *SnapshotChain) getParent(snapshotName string, exists func(string) bool) string {
+	visited := map[string]bool{snapshotName: true}
+	parent := c.Parents[snapshotName]
+	for parent != "" && !exists(parent) && !visited[parent] {
+		visited[parent] = true
+		parent = c.Parents[parent]
+	}
+	if visited[parent] {
+		return ""
+	}
+	return parent
+}
+
+// updateSnapshotChain updates the snapshot chain of the volume by update and
+// saves it. It does nothing if the volume has no config.
+func (s *daemon) updateSnapshotChain(volumeName string, update func(chain *SnapshotChain)) error {
+	err := s.updateVolumeConfig(volumeName, func(volume *Volume) error {
+		if volume.SnapshotChain == nil {
+			volume.SnapshotChain = &SnapshotChain{}
+		}
+		update(volume.SnapshotChain)
+		return nil
+	})
+	if util.IsNotExistsError(err) {
+		return nil
+	}
+	return err
+}
+
+// recordSnapshotChain updates the snapshot chain of the volume, only the
+// failure would be logged since the operation on the snapshot has been done
+func (s *daemon) recordSnapshotChain(volumeName string, update func(chain *SnapshotChain)) {
+	if err := s.updateSnapshotChain(volumeName, update); err != nil {
+		log.Warnf("Failed to update the snapshot chain of volume %v: %v", volumeName, err)
+	}
+}
+
+/*
+doSnapshotChain reports the snapshots of the volume from the oldest one, each
+with its parent, along with the ancestry of the current content of the volume
+from Head back to the oldest snapshot, and the snapshot of the latest backup.
+*/
+func (s *daemon) doSnapshotChain(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.SnapshotChainRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	volumeName := request.VolumeName
+	if err := util.CheckName(volumeName); err != nil {
+		return err
+	}
+	volume := s.getVolume(volumeName)
+	if volume == nil {
+		return notFoundAPIError
+	}
+	config, err := s.loadVolumeConfig(volumeName)
+	if err != nil {
+		return err
+	}
+	chain := &SnapshotChain{}
+	if config != nil && config.SnapshotChain != nil {
+		chain = config.SnapshotChain
+	}
+
+	infos, err := s.listSnapshotDriverInfos(volume)
+	if err != nil {
+		return err
+	}
+	exists := func(name string) bool {
+		_, ok := infos[name]
+		return ok
+	}
+	resp := &api.SnapshotChainResponse{
+		VolumeName:         volumeName,
+		Ancestry:           []string{},
+		LastBackupSnapshot: chain.LastBackupSnapshot,
+		LastBackupURL:      chain.LastBackupURL,
+		Snapshots:          []api.SnapshotChainEntry{},
+	}
+	for _, name := range s.sortSnapshotsByCreatedTime(volumeName, infos) {
+		resp.Snapshots = append(resp.Snapshots, api.SnapshotChainEntry{
+			Name:        name,
+			CreatedTime: infos[name][OPT_SNAPSHOT_CREATED_TIME],
+			Parent:      chain.getParent(name, exists),
+			LastBackup:  name == chain.LastBackupSnapshot,
+		})
+	}
+
+	resp.Head = chain.Head
+	if resp.Head != "" && !exists(resp.Head) {
+		resp.Head = chain.getParent(resp.Head, exists)
+	}
+	visited := map[string]bool{}
+	for name := resp.Head; name != "" && !visited[name]; name = chain.getParent(name, exists) {
+		visited[name] = true
+		resp.Ancestry = append(resp.Ancestry, name)
+	}
+	return sendResponse(w, resp)
+}
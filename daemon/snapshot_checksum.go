@@ -0,0 +1,115 @@
+package daemon
+
+import (
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	SNAPSHOT_CHECKSUM_SHA256 = "sha256"
+)
+
+// SnapshotChecksum is the checksum of the whole content of a snapshot read
+// back by the driver, recorded when it's computed the first time
+type SnapshotChecksum struct {
+	Algorithm   string
+	Checksum    string
+	CreatedTime string
+}
+
+/*
+doSnapshotChecksum reads the snapshot back by the driver and computes the
+checksum of it, see objectstore.GetSnapshotChecksum. The first checksum of
+the snapshot is recorded with the volume, and the later ones are verified
+against it, so the silent corruption of the snapshot in the local storage
+would be reported as invalid. The recorded checksum is kept as it is.
+*/
+func (s *daemon) doSnapshotChecksum(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.SnapshotChecksumRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+	snapshotName := request.SnapshotName
+	if err := util.CheckName(snapshotName); err != nil {
+		return err
+	}
+	deltaOps, volume, err := s.getSnapshotImageOps(snapshotName)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_VERIFY,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: snapshotName,
+		LOG_FIELD_VOLUME:   volume.Name,
+		LOG_FIELD_SIZE:     volume.Size,
+	}).Debug("Computing checksum of snapshot")
+	checksum, err := objectstore.GetSnapshotChecksum(volume, snapshotName, deltaOps)
+	if err != nil {
+		return err
+	}
+
+	// The checksum is recorded the first time it's computed, and verified
+	// against since
+	var recorded *SnapshotChecksum
+	if err := s.updateVolumeConfig(volume.Name, func(config *Volume) error {
+		recorded = config.SnapshotChecksums[snapshotName]
+		if recorded != nil {
+			return nil
+		}
+		recorded = &SnapshotChecksum{
+			Algorithm:   SNAPSHOT_CHECKSUM_SHA256,
+			Checksum:    checksum,
+			CreatedTime: util.Now(),
+		}
+		if config.SnapshotChecksums == nil {
+			config.SnapshotChecksums = map[string]*SnapshotChecksum{}
+		}
+		config.SnapshotChecksums[snapshotName] = recorded
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	resp := &api.SnapshotChecksumResponse{
+		SnapshotName:     snapshotName,
+		VolumeName:       volume.Name,
+		Algorithm:        SNAPSHOT_CHECKSUM_SHA256,
+		Checksum:         checksum,
+		RecordedChecksum: recorded.Checksum,
+		RecordedTime:     recorded.CreatedTime,
+		Valid:            recorded.Checksum == checksum,
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:    LOG_EVENT_VERIFY,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: snapshotName,
+		LOG_FIELD_VOLUME:   volume.Name,
+	}).Debugf("Checksum of snapshot is %v, valid: %v", checksum, resp.Valid)
+	if !resp.Valid {
+		log.Errorf("Checksum %v of snapshot %v of volume %v doesn't match the recorded one %v",
+			checksum, snapshotName, volume.Name, recorded.Checksum)
+	}
+	return writeResponseOutput(w, resp)
+}
+
+// forgetSnapshotChecksum removes the recorded checksum of the deleted
+// snapshot, only the failure would be logged
+func (s *daemon) forgetSnapshotChecksum(volumeName, snapshotName string) {
+	err := s.updateVolumeConfig(volumeName, func(config *Volume) error {
+		delete(config.SnapshotChecksums, snapshotName)
+		return nil
+	})
+	if err != nil && !util.IsNotExistsError(err) {
+		log.Warnf("Failed to remove the checksum of snapshot %v of volume %v: %v", snapshotName, volumeName, err)
+	}
+}
@@ -0,0 +1,132 @@
+package daemon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	// How often the snapshots would be checked against their TTL
+	SNAPSHOT_EXPIRE_INTERVAL = time.Minute
+)
+
+func parseSnapshotTTL(value string) (time.Duration, error) {
+	ttl, err := parseDayDuration(value)
+	if err != nil || ttl <= 0 {
+		return 0, fmt.Errorf("Invalid snapshot TTL %v, should be a positive duration, e.g. 12h or 7d", value)
+	}
+	return ttl, nil
+}
+
+// setSnapshotExpiration records the time the snapshot would be removed at
+func (s *daemon) setSnapshotExpiration(volumeName, snapshotName string, expiresAt time.Time) error {
+	return s.updateVolumeConfig(volumeName, func(config *Volume) error {
+		if config.SnapshotExpirations == nil {
+			config.SnapshotExpirations = map[string]string{}
+		}
+		config.SnapshotExpirations[snapshotName] = expiresAt.Format(time.RubyDate)
+		return nil
+	})
+}
+
+// getSnapshotExpirations returns the expiration time of the snapshots of
+// the volume, only the failure would be logged
+func (s *daemon) getSnapshotExpirations(volumeName string) map[string]string {
+	config, err := s.loadVolumeConfig(volumeName)
+	if err != nil {
+		log.Warnf("Failed to load the snapshot expirations of volume %v: %v", volumeName, err)
+		return nil
+	}
+	if config == nil {
+		return nil
+	}
+	return config.SnapshotExpirations
+}
+
+// forgetSnapshotExpiration removes the expiration time of the deleted
+// snapshot, only the failure would be logged
+func (s *daemon) forgetSnapshotExpiration(volumeName, snapshotName string) {
+	err := s.updateVolumeConfig(volumeName, func(config *Volume) error {
+		delete(config.SnapshotExpirations, snapshotName)
+		return nil
+	})
+	if err != nil && !util.IsNotExistsError(err) {
+		log.Warnf("Failed to remove the expiration of snapshot %v of volume %v: %v", snapshotName, volumeName, err)
+	}
+}
+
+/*
+expireSnapshots removes the snapshots of the volume whose TTL has passed. The
+protected snapshots are kept until they're not the bases of the next backups
+any more, see getProtectedSnapshots, and so are the ones being backed up or
+queued for it, see snapshotUsers. The removal is logged at info level with the
+expiration time, so it can be told from the ones requested by the users in the
+daemon log.
+*/
+func (s *daemon) expireSnapshots(volumeName string) error {
+	expirations := s.getSnapshotExpirations(volumeName)
+	if len(expirations) == 0 {
+		return nil
+	}
+	protected, err := s.getProtectedSnapshots(volumeName)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for name, value := range expirations {
+		expiresAt, err := objectstore.ParseBackupTime(value)
+		if err != nil {
+			log.Warnf("Skip snapshot %v for expiration: %v", name, err)
+			continue
+		}
+		if now.Before(expiresAt) {
+			continue
+		}
+		if s.SnapshotVolumeIndex.Get(name) != volumeName {
+			// The snapshot has been removed in other ways
+			s.forgetSnapshotExpiration(volumeName, name)
+			continue
+		}
+		if protected[name] {
+			log.Debugf("Skip expired snapshot %v, which is the base of the next scheduled backup", name)
+			continue
+		}
+		if s.snapshotUsers.inUse(name) {
+			log.Debugf("Skip expired snapshot %v, which is being backed up or queued for it", name)
+			continue
+		}
+		if err := s.processSnapshotDelete(name); err != nil {
+			log.Warnf("Failed to remove snapshot %v of volume %v expired at %v: %v", name, volumeName, value, err)
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_REASON:     LOG_REASON_COMPLETE,
+			LOG_FIELD_EVENT:      LOG_EVENT_DELETE,
+			LOG_FIELD_OBJECT:     LOG_OBJECT_SNAPSHOT,
+			LOG_FIELD_SNAPSHOT:   name,
+			LOG_FIELD_VOLUME:     volumeName,
+			LOG_FIELD_EXPIRES_AT: value,
+		}).Infof("Removed snapshot %v expired by its TTL", name)
+	}
+	return nil
+}
+
+func (s *daemon) expireAllSnapshots() {
+	names, err := util.ListConfigIDs(s.Root, VOLUME_CFG_PREFIX, CFG_POSTFIX)
+	if err != nil {
+		log.Errorf("Failed to list volumes for snapshot expiration: %v", err)
+		return
+	}
+	for _, name := range names {
+		if err := s.expireSnapshots(name); err != nil {
+			log.Warnf("Failed to expire the snapshots of volume %v: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package daemon
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rancher/convoy/api"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "gopkg.in/check.v1"
+)
+
+// newTestDaemon returns the daemon with only the vfs driver, whose root and
+// volumes are in the temporary directories of the test
+func newTestDaemon(c *C) *daemon {
+	s := &daemon{
+		ConvoyDrivers:     map[string]ConvoyDriver{},
+		volumeConfigMutex: &sync.Mutex{},
+		volumeConfigLocks: map[string]*sync.Mutex{},
+		snapshotUsers:     newSnapshotUsers(),
+		groupMutex:        &sync.Mutex{},
+		daemonConfig: daemonConfig{
+			Root:          c.MkDir(),
+			DriverList:    []string{"vfs"},
+			DefaultDriver: "vfs",
+		},
+	}
+	c.Assert(s.initDrivers(map[string]string{
+		"vfs.path":           c.MkDir(),
+		"vfs.snapshotformat": "tarball",
+	}), IsNil)
+	c.Assert(s.finializeInitialization(), IsNil)
+	return s
+}
+
+func (s *TestSuite) TestExpireSnapshotsWhileCreating(c *C) {
+	d := newTestDaemon(c)
+	_, err := d.processVolumeCreate(&api.VolumeCreateRequest{Name: "vol1"})
+	c.Assert(err, IsNil)
+
+	// Several clients create the snapshots, half of them expire right away and
+	// are removed while the others are still being created
+	expired := map[string]bool{}
+	created := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 6; j++ {
+			name := fmt.Sprintf("snapshot-%v-%v", i, j)
+			if j%2 == 0 {
+				expired[name] = true
+			} else {
+				created[name] = true
+			}
+		}
+	}
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 6; j++ {
+				name := fmt.Sprintf("snapshot-%v-%v", i, j)
+				ttl := "1h"
+				if expired[name] {
+					ttl = "1ms"
+				}
+				_, _, err := d.processSnapshotCreate(&api.SnapshotCreateRequest{
+					Name:       name,
+					VolumeName: "vol1",
+					TTL:        ttl,
+				})
+				c.Check(err, IsNil)
+			}
+		}(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		d.expireAllSnapshots()
+	}
+	time.Sleep(time.Millisecond)
+	d.expireAllSnapshots()
+
+	for name := range expired {
+		c.Assert(d.SnapshotVolumeIndex.Get(name), Equals, "", Commentf("snapshot %v", name))
+	}
+	config, err := d.loadVolumeConfig("vol1")
+	c.Assert(err, IsNil)
+	c.Assert(config.SnapshotExpirations, HasLen, len(created))
+	for name := range created {
+		c.Assert(d.SnapshotVolumeIndex.Get(name), Equals, "vol1")
+		c.Assert(config.SnapshotExpirations[name], Not(Equals), "", Commentf("snapshot %v", name))
+	}
+	// None of the updates of the chain is lost either
+	chain := map[string]bool{}
+	for name := config.SnapshotChain.Head; name != ""; name = config.SnapshotChain.Parents[name] {
+		chain[name] = true
+	}
+	c.Assert(chain, DeepEquals, created)
+}
+
+func (s *TestSuite) TestExpireSnapshotsWhileBackupQueued(c *C) {
+	d := newTestDaemon(c)
+	limiter, err := newOperationLimiter(1)
+	c.Assert(err, IsNil)
+	d.operations = limiter
+	_, err = d.processVolumeCreate(&api.VolumeCreateRequest{Name: "vol1"})
+	c.Assert(err, IsNil)
+	_, _, err = d.processSnapshotCreate(&api.SnapshotCreateRequest{
+		Name:       "snapshot1",
+		VolumeName: "vol1",
+	})
+	c.Assert(err, IsNil)
+
+	// The backup waits for the slot held by another operation
+	release := limiter.acquire("operation")
+	backupErr := make(chan error)
+	go func() {
+		_, err := d.processBackupCreate(&api.BackupCreateRequest{
+			URL:          "vfs://" + c.MkDir(),
+			SnapshotName: "snapshot1",
+		})
+		backupErr <- err
+	}()
+	waitForStats(c, limiter, 1, 1)
+
+	// The snapshot expires while queued, but would be kept for the backup
+	c.Assert(d.setSnapshotExpiration("vol1", "snapshot1", time.Now().Add(-time.Hour)), IsNil)
+	d.expireAllSnapshots()
+	c.Assert(d.SnapshotVolumeIndex.Get("snapshot1"), Equals, "vol1")
+
+	release()
+	c.Assert(<-backupErr, IsNil)
+	c.Assert(d.snapshotUsers.inUse("snapshot1"), Equals, false)
+	d.expireAllSnapshots()
+	c.Assert(d.SnapshotVolumeIndex.Get("snapshot1"), Equals, "")
+}
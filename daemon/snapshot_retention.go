@@ -0,0 +1,267 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	// How often the snapshots would be checked against MaxAge, besides
+	// after each snapshot created
+	SNAPSHOT_PRUNE_INTERVAL = time.Hour
+)
+
+/*
+SnapshotRetention limits the local snapshots of a volume, which consume the
+space of the thin pool or the disk. Only the latest MaxCount snapshots are
+kept, and the snapshots older than MaxAge are removed, e.g. "12h" or "7d".
+Either of them can be 0 or empty for no limit.
+*/
+type SnapshotRetention struct {
+	MaxCount int    `json:",omitempty"`
+	MaxAge   string `json:",omitempty"`
+}
+
+func (p *SnapshotRetention) IsEmpty() bool {
+	return p.MaxCount == 0 && p.MaxAge == ""
+}
+
+func (p *SnapshotRetention) Validate() error {
+	if p.MaxCount < 0 {
+		return fmt.Errorf("Invalid snapshot retention, max count %v cannot be negative", p.MaxCount)
+	}
+	if p.MaxAge != "" {
+		if _, err := parseSnapshotMaxAge(p.MaxAge); err != nil {
+			return err
+		}
+	}
+	if p.IsEmpty() {
+		return fmt.Errorf("Invalid snapshot retention, either max count or max age should be specified")
+	}
+	return nil
+}
+
+// parseDayDuration parses the duration, which can be in days as well, e.g.
+// "7d"
+func parseDayDuration(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		return time.Duration(days) * 24 * time.Hour, err
+	}
+	return time.ParseDuration(value)
+}
+
+func parseSnapshotMaxAge(value string) (time.Duration, error) {
+	age, err := parseDayDuration(value)
+	if err != nil || age <= 0 {
+		return 0, fmt.Errorf("Invalid snapshot max age %v, should be a positive duration, e.g. 12h or 7d", value)
+	}
+	return age, nil
+}
+
+// ExpiredSnapshots returns the snapshots should be removed at now, from the
+// creation time of the snapshots keyed by their names
+func (p *SnapshotRetention) ExpiredSnapshots(snapshots map[string]time.Time, now time.Time) []string {
+	names := []string{}
+	for name := range snapshots {
+		names = append(names, name)
+	}
+	// Latest first
+	sort.Slice(names, func(i, j int) bool {
+		ti, tj := snapshots[names[i]], snapshots[names[j]]
+		if ti.Equal(tj) {
+			return names[i] > names[j]
+		}
+		return ti.After(tj)
+	})
+
+	maxAge, err := parseSnapshotMaxAge(p.MaxAge)
+	if err != nil {
+		maxAge = 0
+	}
+	expired := []string{}
+	for i, name := range names {
+		if (p.MaxCount > 0 && i >= p.MaxCount) || (maxAge > 0 && now.Sub(snapshots[name]) > maxAge) {
+			expired = append(expired, name)
+		}
+	}
+	return expired
+}
+
+// doSnapshotRetention sets the retention of the local snapshots of the
+// volume, or only shows it if no limit is specified
+func (s *daemon) doSnapshotRetention(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
+	request := &api.SnapshotRetentionRequest{}
+	if err := decodeRequest(r, request); err != nil {
+		return err
+	}
+
+	if s.getVolume(request.VolumeName) == nil {
+		return notFoundAPIError
+	}
+
+	retention := &SnapshotRetention{
+		MaxCount: request.MaxCount,
+		MaxAge:   request.MaxAge,
+	}
+	if request.Clear || !retention.IsEmpty() {
+		if request.Clear {
+			retention = nil
+		} else if err := retention.Validate(); err != nil {
+			return err
+		}
+		if err := s.updateVolumeConfig(request.VolumeName, func(volume *Volume) error {
+			volume.SnapshotRetention = retention
+			return nil
+		}); err != nil {
+			return err
+		}
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_REASON: LOG_REASON_COMPLETE,
+			LOG_FIELD_VOLUME: request.VolumeName,
+		}).Debugf("Updated snapshot retention to %+v", retention)
+		if retention != nil {
+			if err := s.pruneSnapshots(request.VolumeName, ""); err != nil {
+				log.Warnf("Failed to prune the snapshots of volume %v: %v", request.VolumeName, err)
+			}
+		}
+	}
+
+	volume, err := s.loadVolumeConfig(request.VolumeName)
+	if err != nil {
+		return err
+	}
+	if volume == nil || volume.SnapshotRetention == nil {
+		return sendResponse(w, &SnapshotRetention{})
+	}
+	return sendResponse(w, volume.SnapshotRetention)
+}
+
+// getProtectedSnapshots returns the snapshots which should never be pruned,
+// which are the bases of the next backups of the backup schedules
+func (s *daemon) getProtectedSnapshots(volumeName string) (map[string]bool, error) {
+	protected := map[string]bool{}
+	names, err := util.ListConfigIDs(s.Root, SCHEDULE_CFG_PREFIX, CFG_POSTFIX)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		schedule := &Schedule{
+			Name:       name,
+			configPath: s.Root,
+		}
+		if err := util.ObjectLoad(schedule); err != nil {
+			return nil, err
+		}
+		if schedule.VolumeName == volumeName && !schedule.SnapshotOnly && schedule.LastSnapshotName != "" {
+			protected[schedule.LastSnapshotName] = true
+		}
+	}
+	return protected, nil
+}
+
+/*
+pruneSnapshots removes the snapshots of the volume expired according to the
+snapshot retention of the volume. The snapshot keep is never removed, e.g.
+the one just created, as well as the protected ones, see
+getProtectedSnapshots. Only the snapshots known by the daemon are counted.
+*/
+func (s *daemon) pruneSnapshots(volumeName, keep string) error {
+	volume, err := s.loadVolumeConfig(volumeName)
+	if err != nil || volume == nil {
+		return err
+	}
+	if volume.SnapshotRetention == nil || s.getVolume(volumeName) == nil {
+		return nil
+	}
+
+	infos, err := s.listSnapshotDriverInfos(volume)
+	if err != nil {
+		return err
+	}
+	snapshots := map[string]time.Time{}
+	for name, info := range infos {
+		if s.SnapshotVolumeIndex.Get(name) != volumeName {
+			continue
+		}
+		t, err := objectstore.ParseBackupTime(info[OPT_SNAPSHOT_CREATED_TIME])
+		if err != nil {
+			log.Warnf("Skip snapshot %v for retention: %v", name, err)
+			continue
+		}
+		snapshots[name] = t
+	}
+	protected, err := s.getProtectedSnapshots(volumeName)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range volume.SnapshotRetention.ExpiredSnapshots(snapshots, time.Now()) {
+		if name == keep || protected[name] {
+			continue
+		}
+		if s.snapshotUsers.inUse(name) {
+			log.Debugf("Skip expired snapshot %v, which is being backed up or queued for it", name)
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_REASON:   LOG_REASON_START,
+			LOG_FIELD_EVENT:    LOG_EVENT_REMOVE,
+			LOG_FIELD_VOLUME:   volumeName,
+			LOG_FIELD_SNAPSHOT: name,
+		}).Debugf("Removing snapshot %v expired by the snapshot retention", name)
+		if err := s.processSnapshotDelete(name); err != nil {
+			log.Warnf("Failed to remove expired snapshot %v of volume %v: %v", name, volumeName, err)
+		}
+	}
+	return nil
+}
+
+// startSnapshotPruning prunes the snapshots of all the volumes periodically,
+// so the snapshots expired by MaxAge would be removed without new snapshots
+// created, and removes the snapshots expired by their TTL more often, see
+// expireSnapshots. The returned function would stop it.
+func (s *daemon) startSnapshotPruning() func() {
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(SNAPSHOT_PRUNE_INTERVAL)
+		defer ticker.Stop()
+		expireTicker := time.NewTicker(SNAPSHOT_EXPIRE_INTERVAL)
+		defer expireTicker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-expireTicker.C:
+				s.expireAllSnapshots()
+				continue
+			case <-ticker.C:
+			}
+			names, err := util.ListConfigIDs(s.Root, VOLUME_CFG_PREFIX, CFG_POSTFIX)
+			if err != nil {
+				log.Errorf("Failed to list volumes for snapshot retention: %v", err)
+				continue
+			}
+			for _, name := range names {
+				if err := s.pruneSnapshots(name, ""); err != nil {
+					log.Warnf("Failed to prune the snapshots of volume %v: %v", name, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+	}
+}
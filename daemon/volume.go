@@ -4,19 +4,48 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"strconv"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/rancher/convoy/api"
+	"github.com/rancher/convoy/objectstore"
 	"github.com/rancher/convoy/util"
 
 	. "github.com/rancher/convoy/convoydriver"
 	. "github.com/rancher/convoy/logging"
 )
 
+/*
+Volume is the binding between the volume and the driver it's created by,
+which is saved in the daemon root directory. The retention policy and the
+hooks of the backups of the volume are saved with it, as well as the
+retention, the quiesce hooks, the chain and the checksums of the local
+snapshots.
+*/
 type Volume struct {
-	Name       string
-	DriverName string
+	Name              string
+	DriverName        string
+	Retention         *objectstore.RetentionPolicy `json:",omitempty"`
+	Hooks             *BackupHooks                 `json:",omitempty"`
+	SnapshotRetention *SnapshotRetention           `json:",omitempty"`
+	QuiesceHooks      *QuiesceHooks                `json:",omitempty"`
+	SnapshotChain     *SnapshotChain               `json:",omitempty"`
+	SnapshotChecksums map[string]*SnapshotChecksum `json:",omitempty"`
+	// The expiration time of the snapshots created with TTL
+	SnapshotExpirations map[string]string `json:",omitempty"`
+
+	configPath string
+}
+
+func (v *Volume) ConfigFile() (string, error) {
+	if v.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if v.configPath == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume config path")
+	}
+	return filepath.Join(v.configPath, VOLUME_CFG_PREFIX+v.Name+CFG_POSTFIX), nil
 }
 
 var notFoundAPIError = APIError{
@@ -33,10 +62,22 @@ func (s *daemon) getVolume(name string) *Volume {
 	return &Volume{
 		Name:       name,
 		DriverName: driver.Name(),
+		configPath: s.Root,
 	}
 }
 
 func (s *daemon) volumeExists(name string) (bool, error) {
+	// Don't reuse the name of the volume whose driver is not enabled now
+	volume, err := s.loadVolumeConfig(name)
+	if err != nil {
+		return false, err
+	}
+	if volume != nil {
+		if _, err := s.getDriver(volume.DriverName); err != nil {
+			return true, nil
+		}
+	}
+
 	for _, driver := range s.ConvoyDrivers {
 		volOps, err := driver.VolumeOps()
 		if err != nil {
@@ -83,6 +124,9 @@ func (s *daemon) processVolumeCreate(request *api.VolumeCreateRequest) (*Volume,
 			return nil, err
 		}
 	} else {
+		if err := util.CheckName(volumeName); err != nil {
+			return nil, err
+		}
 		exists, err := s.volumeExists(volumeName)
 		if err != nil {
 			return nil, fmt.Errorf("Error occurred while checking if volume %v exists: %v", volumeName, err)
@@ -111,6 +155,30 @@ func (s *daemon) processVolumeCreate(request *api.VolumeCreateRequest) (*Volume,
 		}
 	}
 
+	// The volume would be created by the driver of the snapshot
+	snapshotVolumeName := ""
+	if request.FromSnapshot != "" {
+		if request.BackupURL != "" || request.DriverVolumeID != "" || request.Size != 0 || request.PrepareForVM {
+			return nil, fmt.Errorf("Cannot specify backup, driver volume ID, size or VM option when creating volume from snapshot")
+		}
+		if err := util.CheckName(request.FromSnapshot); err != nil {
+			return nil, err
+		}
+		snapshotVolumeName = s.SnapshotVolumeIndex.Get(request.FromSnapshot)
+		if snapshotVolumeName == "" {
+			return nil, fmt.Errorf("cannot find volume for snapshot %v", request.FromSnapshot)
+		}
+		if !s.snapshotExists(snapshotVolumeName, request.FromSnapshot) {
+			return nil, fmt.Errorf("snapshot %v of volume %v doesn't exist", request.FromSnapshot, snapshotVolumeName)
+		}
+		snapshotDriverName := s.getVolume(snapshotVolumeName).DriverName
+		if driverName != "" && driverName != snapshotDriverName {
+			return nil, fmt.Errorf("Cannot create volume of driver %v from snapshot %v of driver %v",
+				driverName, request.FromSnapshot, snapshotDriverName)
+		}
+		driverName = snapshotDriverName
+	}
+
 	if driverName == "" {
 		driverName = s.DefaultDriver
 	}
@@ -136,6 +204,20 @@ func (s *daemon) processVolumeCreate(request *api.VolumeCreateRequest) (*Volume,
 			OPT_PREPARE_FOR_VM:   strconv.FormatBool(request.PrepareForVM),
 		},
 	}
+	createVolume := volOps.CreateVolume
+	if request.FromSnapshot != "" {
+		snapOps, err := driver.SnapshotOps()
+		if err != nil {
+			return nil, err
+		}
+		cloneOps, ok := snapOps.(SnapshotCloneOperations)
+		if !ok {
+			return nil, fmt.Errorf("Driver %v doesn't support creating volumes from snapshots", driverName)
+		}
+		req.Options[OPT_SNAPSHOT_NAME] = request.FromSnapshot
+		req.Options[OPT_SNAPSHOT_VOLUME_NAME] = snapshotVolumeName
+		createVolume = cloneOps.CreateVolumeFromSnapshot
+	}
 	log.WithFields(logrus.Fields{
 		LOG_FIELD_REASON: LOG_REASON_PREPARE,
 		LOG_FIELD_EVENT:  LOG_EVENT_CREATE,
@@ -143,7 +225,7 @@ func (s *daemon) processVolumeCreate(request *api.VolumeCreateRequest) (*Volume,
 		LOG_FIELD_VOLUME: volumeName,
 		LOG_FIELD_OPTS:   req.Options,
 	}).Debug()
-	if err := volOps.CreateVolume(req); err != nil {
+	if err := createVolume(req); err != nil {
 		return nil, err
 	}
 	log.WithFields(logrus.Fields{
@@ -156,6 +238,10 @@ func (s *daemon) processVolumeCreate(request *api.VolumeCreateRequest) (*Volume,
 	volume := &Volume{
 		Name:       volumeName,
 		DriverName: driverName,
+		configPath: s.Root,
+	}
+	if err := s.saveVolumeConfig(volume); err != nil {
+		return nil, err
 	}
 
 	if err := s.NameUUIDIndex.Add(volumeName, "exists"); err != nil {
@@ -170,25 +256,38 @@ func (s *daemon) doVolumeCreate(version string, w http.ResponseWriter, r *http.R
 		return err
 	}
 
-	volume, err := s.processVolumeCreate(request)
-	if err != nil {
-		return err
+	create := func() ([]byte, error) {
+		volume, err := s.processVolumeCreate(request)
+		if err != nil {
+			return nil, err
+		}
+
+		driverInfo, err := s.getVolumeDriverInfo(volume)
+		if err != nil {
+			return nil, err
+		}
+		if request.Verbose {
+			return api.ResponseOutput(api.VolumeResponse{
+				Name:        volume.Name,
+				Driver:      volume.DriverName,
+				CreatedTime: driverInfo[OPT_VOLUME_CREATED_TIME],
+				DriverInfo:  driverInfo,
+				Snapshots:   map[string]api.SnapshotResponse{},
+			})
+		}
+		return []byte(volume.Name), nil
 	}
 
-	driverInfo, err := s.getVolumeDriverInfo(volume)
+	// Only restoring from a backup would report progress
+	if request.Progress && request.BackupURL != "" {
+		key := objectstore.RestoreProgressKey(util.UnescapeURL(request.BackupURL))
+		return streamProgress(w, key, create)
+	}
+	output, err := create()
 	if err != nil {
 		return err
 	}
-	if request.Verbose {
-		return writeResponseOutput(w, api.VolumeResponse{
-			Name:        volume.Name,
-			Driver:      volume.DriverName,
-			CreatedTime: driverInfo[OPT_VOLUME_CREATED_TIME],
-			DriverInfo:  driverInfo,
-			Snapshots:   map[string]api.SnapshotResponse{},
-		})
-	}
-	return writeStringResponse(w, volume.Name)
+	return writeStringResponse(w, string(output))
 }
 
 func (s *daemon) doVolumeDelete(version string, w http.ResponseWriter, r *http.Request, objs map[string]string) error {
@@ -241,6 +340,9 @@ func (s *daemon) processVolumeDelete(request *api.VolumeDeleteRequest) error {
 		LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
 		LOG_FIELD_VOLUME: name,
 	}).Debug()
+	if err := s.deleteVolumeConfig(volume); err != nil {
+		return err
+	}
 	if err := s.NameUUIDIndex.Delete(volume.Name); err != nil {
 		return err
 	}
@@ -287,11 +389,7 @@ func (s *daemon) listVolumeInfo(volume *Volume) (*api.VolumeResponse, error) {
 	}
 	for name, snapshot := range snapshots {
 		snapshot["Driver"] = volOps.Name()
-		resp.Snapshots[name] = api.SnapshotResponse{
-			Name:        name,
-			CreatedTime: snapshot[OPT_SNAPSHOT_CREATED_TIME],
-			DriverInfo:  snapshot,
-		}
+		resp.Snapshots[name] = getSnapshotResponse(name, snapshot)
 	}
 	return resp, nil
 }
@@ -524,6 +622,31 @@ func (s *daemon) getVolumeMountPoint(volume *Volume) (string, error) {
 }
 
 func (s *daemon) getDriverForVolume(id string) (ConvoyDriver, error) {
+	volume, err := s.loadVolumeConfig(id)
+	if err != nil {
+		return nil, err
+	}
+	if volume != nil {
+		driver, err := s.getDriver(volume.DriverName)
+		if err != nil {
+			return nil, fmt.Errorf("Volume %v was created by driver %v, which is not enabled", id, volume.DriverName)
+		}
+		volOps, err := driver.VolumeOps()
+		if err != nil {
+			return nil, err
+		}
+		if vol, _ := volOps.GetVolumeInfo(id); vol == nil {
+			// The volume has been removed out of Convoy
+			log.Warnf("Cannot find volume %v in driver %v, removing the record", id, volume.DriverName)
+			if err := s.deleteVolumeConfig(volume); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("Cannot find volume %v in driver %v", id, volume.DriverName)
+		}
+		return driver, nil
+	}
+
+	// The volume may be created before the driver of the volume is recorded
 	for _, driver := range s.ConvoyDrivers {
 		volOps, err := driver.VolumeOps()
 		if err != nil {
@@ -536,6 +659,14 @@ func (s *daemon) getDriverForVolume(id string) (ConvoyDriver, error) {
 		if vol, _ := volOps.GetVolumeInfo(id); vol == nil {
 			continue
 		}
+		volume := &Volume{
+			Name:       id,
+			DriverName: driver.Name(),
+			configPath: s.Root,
+		}
+		if err := s.saveVolumeConfig(volume); err != nil {
+			log.Warnf("Failed to record driver %v for volume %v: %v", volume.DriverName, id, err)
+		}
 		return driver, nil
 	}
 	return nil, fmt.Errorf("Cannot find driver for volume %v", id)
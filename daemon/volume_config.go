@@ -0,0 +1,94 @@
+package daemon
+
+import (
+	"sync"
+
+	"github.com/rancher/convoy/util"
+)
+
+/*
+The config of each volume is changed by the requests, the schedules and the
+background loops of the daemon, e.g. the snapshot chain, the checksums and the
+expirations of the snapshots. All of them load, change and save the whole
+config, so they must be serialized per volume, otherwise the last one saved
+would drop the changes of the others. The config is only to be read by
+loadVolumeConfig and written by the helpers below.
+*/
+
+// lockVolumeConfig locks the config of the volume, and returns the function
+// to unlock it
+func (s *daemon) lockVolumeConfig(volumeName string) func() {
+	s.volumeConfigMutex.Lock()
+	mutex, ok := s.volumeConfigLocks[volumeName]
+	if !ok {
+		mutex = &sync.Mutex{}
+		s.volumeConfigLocks[volumeName] = mutex
+	}
+	s.volumeConfigMutex.Unlock()
+
+	mutex.Lock()
+	return mutex.Unlock
+}
+
+func (s *daemon) loadVolumeConfigLocked(volumeName string) (*Volume, error) {
+	volume := &Volume{
+		Name:       volumeName,
+		configPath: s.Root,
+	}
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+	return volume, nil
+}
+
+// loadVolumeConfig returns the saved config of the volume, or nil if there is
+// none, e.g. the volume has been deleted
+func (s *daemon) loadVolumeConfig(volumeName string) (*Volume, error) {
+	unlock := s.lockVolumeConfig(volumeName)
+	defer unlock()
+
+	volume, err := s.loadVolumeConfigLocked(volumeName)
+	if err != nil {
+		if util.IsNotExistsError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return volume, nil
+}
+
+/*
+updateVolumeConfig loads the config of the volume, changes it by update and
+saves it, with the config locked all the way. The config wouldn't be saved if
+update failed. It fails with the error checked by util.IsNotExistsError if the
+volume has no config.
+*/
+func (s *daemon) updateVolumeConfig(volumeName string, update func(volume *Volume) error) error {
+	unlock := s.lockVolumeConfig(volumeName)
+	defer unlock()
+
+	volume, err := s.loadVolumeConfigLocked(volumeName)
+	if err != nil {
+		return err
+	}
+	if err := update(volume); err != nil {
+		return err
+	}
+	return util.ObjectSave(volume)
+}
+
+// saveVolumeConfig saves the config of the volume as a whole, which is only
+// used when the config is created
+func (s *daemon) saveVolumeConfig(volume *Volume) error {
+	unlock := s.lockVolumeConfig(volume.Name)
+	defer unlock()
+
+	return util.ObjectSave(volume)
+}
+
+func (s *daemon) deleteVolumeConfig(volume *Volume) error {
+	unlock := s.lockVolumeConfig(volume.Name)
+	defer unlock()
+
+	return util.ObjectDelete(volume)
+}
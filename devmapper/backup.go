@@ -43,11 +43,7 @@ func (d *Driver) CompareSnapshot(id, compareID, volumeID string) (*metadata.Mapp
 		return nil, err
 	}
 
-	dev := d.MetadataDevice
-	out, err := util.Execute(THIN_PROVISION_TOOLS_BINARY, []string{"thin_delta",
-		"--snap1", strconv.Itoa(snap1.DevID),
-		"--snap2", strconv.Itoa(snap2.DevID),
-		dev})
+	out, err := d.thinDelta(snap1.DevID, snap2.DevID)
 	if err != nil {
 		return nil, err
 	}
@@ -59,11 +55,74 @@ func (d *Driver) CompareSnapshot(id, compareID, volumeID string) (*metadata.Mapp
 	return mapping, err
 }
 
+/*
+thinDelta returns the output of thin_delta between the thin devices, which
+compares the block mappings of them in the metadata of the pool, so the
+changed blocks are found without reading the devices. The metadata of the
+active pool may be changed by the kernel at any time, so thin_delta reads
+the metadata snapshot reserved in the pool for the call rather than the live
+metadata.
+*/
+func (d *Driver) thinDelta(devID1, devID2 int) (string, error) {
+	d.metadataSnapMutex.Lock()
+	defer d.metadataSnapMutex.Unlock()
+
+	if err := d.reserveMetadataSnap(); err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := sendPoolMessage(d.ThinpoolDevice, "release_metadata_snap"); err != nil {
+			log.Warnf("Failed to release metadata snapshot of pool %v: %v", d.ThinpoolDevice, err)
+		}
+	}()
+	return util.Execute(THIN_PROVISION_TOOLS_BINARY, []string{"thin_delta",
+		"--metadata-snap",
+		"--snap1", strconv.Itoa(devID1),
+		"--snap2", strconv.Itoa(devID2),
+		d.MetadataDevice})
+}
+
+// reserveMetadataSnap reserves the metadata snapshot of the pool. The one
+// left by the daemon crashed during thinDelta would be released first, since
+// nothing else reserves it in the pool of Convoy.
+func (d *Driver) reserveMetadataSnap() error {
+	err := sendPoolMessage(d.ThinpoolDevice, "reserve_metadata_snap")
+	if err == nil {
+		return nil
+	}
+	log.Debugf("Failed to reserve metadata snapshot of pool %v, releasing the existing one: %v", d.ThinpoolDevice, err)
+	if e := sendPoolMessage(d.ThinpoolDevice, "release_metadata_snap"); e != nil {
+		return fmt.Errorf("Failed to reserve metadata snapshot of pool %v: %v", d.ThinpoolDevice, err)
+	}
+	return sendPoolMessage(d.ThinpoolDevice, "reserve_metadata_snap")
+}
+
+func sendPoolMessage(pool, message string) error {
+	task, err := devicemapper.TaskCreateNamed(devicemapper.DeviceTargetMsg, pool)
+	if task == nil {
+		return err
+	}
+	if err := task.SetSector(0); err != nil {
+		return fmt.Errorf("Can't set sector %s", err)
+	}
+	if err := task.SetMessage(message); err != nil {
+		return fmt.Errorf("Can't set message %s", err)
+	}
+	if err := task.Run(); err != nil {
+		return fmt.Errorf("Error running message %v: %s", message, err)
+	}
+	return nil
+}
+
 func (d *Driver) OpenSnapshot(id, volumeID string) error {
 	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
 	if err != nil {
 		return err
 	}
+	// The device of the mounted snapshot is already activated
+	if snapshot.MountPoint != "" {
+		return nil
+	}
 
 	log.WithFields(logrus.Fields{
 		LOG_FIELD_REASON:            LOG_REASON_START,
@@ -87,6 +146,9 @@ func (d *Driver) CloseSnapshot(id, volumeID string) error {
 	if err != nil {
 		return err
 	}
+	if snapshot.MountPoint != "" {
+		return nil
+	}
 
 	log.WithFields(logrus.Fields{
 		LOG_FIELD_REASON:   LOG_REASON_START,
@@ -140,11 +202,33 @@ func (d *Driver) CreateBackup(snapshotID, volumeID, destURL, endpointURL string,
 	objSnapshot := &objectstore.Snapshot{
 		Name:        snapshotID,
 		CreatedTime: opts[convoydriver.OPT_SNAPSHOT_CREATED_TIME],
+		ExportImage: opts[convoydriver.OPT_EXPORT_IMAGE],
+		Labels:      objectstore.DecodeLabels(opts[convoydriver.OPT_BACKUP_LABELS]),
 	}
 	return objectstore.CreateDeltaBlockBackup(objVolume, objSnapshot, destURL, endpointURL, d)
 }
 
-func (d *Driver) DeleteBackup(backupURL, endpointURL string) error {
+func (d *Driver) EstimateBackup(snapshotID, volumeID, destURL, endpointURL string, opts map[string]string) (map[string]string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+
+	objVolume := &objectstore.Volume{
+		Name:   volumeID,
+		Driver: d.Name(),
+		Size:   volume.Size,
+	}
+	objSnapshot := &objectstore.Snapshot{
+		Name: snapshotID,
+	}
+	return objectstore.EstimateDeltaBlockBackup(objVolume, objSnapshot, destURL, endpointURL, d)
+}
+
+func (d *Driver) DeleteBackup(backupURL, endpointURL string, opts map[string]string) error {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
@@ -155,7 +239,8 @@ func (d *Driver) DeleteBackup(backupURL, endpointURL string) error {
 	if objVolume.Driver != d.Name() {
 		return fmt.Errorf("BUG: Wrong driver handling DeleteBackup(), driver should be %v but is %v", objVolume.Driver, d.Name())
 	}
-	return objectstore.DeleteDeltaBlockBackup(backupURL, endpointURL)
+	force, _ := strconv.ParseBool(opts[convoydriver.OPT_FORCE])
+	return objectstore.DeleteDeltaBlockBackup(backupURL, endpointURL, force)
 }
 
 func (d *Driver) GetBackupInfo(backupURL, endpointURL string) (map[string]string, error) {
@@ -178,3 +263,52 @@ func (d *Driver) ListBackup(destURL, endpointURL string, opts map[string]string)
 
 	return objectstore.List(opts[convoydriver.OPT_VOLUME_NAME], destURL, endpointURL, d.Name())
 }
+
+/*
+RestoreBackup restores the backup onto the existing volume, by writing only
+the blocks differing from the backup the volume was restored from. The
+volume must not have been mounted or attached since then, otherwise its
+content is unknown and it has to be created from the backup again, as well
+as after a failed restore.
+*/
+func (d *Driver) RestoreBackup(req convoydriver.Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	backupURL := req.Options[convoydriver.OPT_BACKUP_URL]
+	endpointURL := req.Options[convoydriver.OPT_ENDPOINT_URL]
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if volume.MountPoint != "" {
+		return fmt.Errorf("Cannot restore backup to volume %v, which is mounted at %v", volume.Name, volume.MountPoint)
+	}
+	if volume.RestoredBackupURL == "" {
+		return fmt.Errorf("Volume %v wasn't restored from a backup or has been changed since, "+
+			"create a new volume from the backup instead", volume.Name)
+	}
+	objVolume, err := objectstore.LoadVolume(backupURL, endpointURL)
+	if err != nil {
+		return err
+	}
+	if objVolume.Size > volume.Size {
+		return fmt.Errorf("Volume size %v cannot be less than backup's size %v", volume.Size, objVolume.Size)
+	}
+	dev, err := volume.GetDevice()
+	if err != nil {
+		return err
+	}
+
+	lastBackupURL := volume.RestoredBackupURL
+	if err := objectstore.RestoreDeltaBlockBackupIncrementally(backupURL, lastBackupURL, endpointURL, dev); err != nil {
+		// The restore may have failed half way, leaving the content unknown
+		volume.RestoredBackupURL = ""
+		if saveErr := util.ObjectSave(volume); saveErr != nil {
+			log.Errorf("Failed to save volume %v: %v", volume.Name, saveErr)
+		}
+		return err
+	}
+	volume.RestoredBackupURL = backupURL
+	return util.ObjectSave(volume)
+}
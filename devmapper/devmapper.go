@@ -64,6 +64,8 @@ const (
 type Driver struct {
 	mutex      *sync.RWMutex
 	devIDMutex *sync.Mutex
+	// Only one metadata snapshot can be reserved in the pool at a time
+	metadataSnapMutex *sync.Mutex
 	Device
 }
 
@@ -75,6 +77,9 @@ type Volume struct {
 	MountPoint  string
 	CreatedTime string
 	Snapshots   map[string]Snapshot
+	// The backup the volume was restored from, cleared once the volume is
+	// mounted or attached, see RestoreBackup
+	RestoredBackupURL string `json:",omitempty"`
 
 	configPath string
 	Filesystem string
@@ -85,6 +90,9 @@ type Snapshot struct {
 	CreatedTime string
 	DevID       int
 	Activated   bool
+	// Where the snapshot is mounted read-only, see MountSnapshot
+	MountPoint string `json:",omitempty"`
+	SnapshotMetadata
 }
 
 func (v *Volume) ConfigFile() (string, error) {
@@ -329,9 +337,10 @@ func Init(root string, config map[string]string) (ConvoyDriver, error) {
 			return nil, err
 		}
 		d := &Driver{
-			mutex:      &sync.RWMutex{},
-			devIDMutex: &sync.Mutex{},
-			Device:     *dev,
+			mutex:             &sync.RWMutex{},
+			devIDMutex:        &sync.Mutex{},
+			metadataSnapMutex: &sync.Mutex{},
+			Device:            *dev,
 		}
 		if err := d.activatePool(); err != nil {
 			return nil, err
@@ -376,9 +385,10 @@ func Init(root string, config map[string]string) (ConvoyDriver, error) {
 		return nil, err
 	}
 	d := &Driver{
-		mutex:      &sync.RWMutex{},
-		devIDMutex: &sync.Mutex{},
-		Device:     *dev,
+		mutex:             &sync.RWMutex{},
+		devIDMutex:        &sync.Mutex{},
+		metadataSnapMutex: &sync.Mutex{},
+		Device:            *dev,
 	}
 	return d, nil
 }
@@ -443,15 +453,15 @@ func (d *Driver) CreateVolume(req Request) error {
 		if err != nil {
 			return err
 		}
-		if objVolume.Driver != d.Name() {
-			return fmt.Errorf("Cannot restore backup of %v to %v", objVolume.Driver, d.Name())
+		if err := objectstore.CheckRestoreDriver(objVolume, backupURL, endpointURL, d.Name()); err != nil {
+			return err
 		}
 		size, err = d.getSize(opts, objVolume.Size)
 		if err != nil {
 			return err
 		}
-		if size != objVolume.Size {
-			return fmt.Errorf("Volume size must match with backup's size")
+		if size < objVolume.Size {
+			return fmt.Errorf("Volume size cannot be less than backup's size %v", objVolume.Size)
 		}
 	} else {
 		size, err = d.getSize(opts, d.DefaultVolumeSize)
@@ -524,7 +534,11 @@ func (d *Driver) CreateVolume(req Request) error {
 	volume.Size = size
 	volume.CreatedTime = util.Now()
 	volume.Snapshots = make(map[string]Snapshot)
-	volume.Filesystem = d.Filesystem
+	// The device would be left raw if it's used by a layered driver
+	format := opts[OPT_FORMAT] != "false"
+	if format {
+		volume.Filesystem = d.Filesystem
+	}
 	if err := util.ObjectSave(volume); err != nil {
 		return err
 	}
@@ -534,12 +548,19 @@ func (d *Driver) CreateVolume(req Request) error {
 		return err
 	}
 	if backupURL == "" {
+		if !format {
+			return nil
+		}
 		// format the device
 		if err := d.createFilesystem(dev); err != nil {
 			return err
 		}
 	} else {
-		if err := objectstore.RestoreDeltaBlockBackup(backupURL, endpointURL, dev); err != nil {
+		if err := objectstore.RestoreDeltaBlockBackupToSize(backupURL, endpointURL, dev, size); err != nil {
+			return err
+		}
+		volume.RestoredBackupURL = backupURL
+		if err := util.ObjectSave(volume); err != nil {
 			return err
 		}
 	}
@@ -699,10 +720,11 @@ func (d *Driver) CreateSnapshot(req Request) error {
 	log.Debugf("Created snapshot device")
 
 	snapshot = Snapshot{
-		Name:        id,
-		CreatedTime: util.Now(),
-		DevID:       devID,
-		Activated:   false,
+		Name:             id,
+		CreatedTime:      util.Now(),
+		DevID:            devID,
+		Activated:        false,
+		SnapshotMetadata: NewSnapshotMetadata(req.Options),
 	}
 	volume.Snapshots[id] = snapshot
 
@@ -730,6 +752,9 @@ func (d *Driver) deleteSnapshot(id, volumeID string) error {
 	if err != nil {
 		return err
 	}
+	if snapshot.MountPoint != "" {
+		return fmt.Errorf("Cannot delete snapshot %v of volume %v, which is mounted at %v", id, volumeID, snapshot.MountPoint)
+	}
 
 	log.WithFields(logrus.Fields{
 		LOG_FIELD_REASON:   LOG_REASON_START,
@@ -751,6 +776,241 @@ func (d *Driver) deleteSnapshot(id, volumeID string) error {
 	return nil
 }
 
+/*
+RevertSnapshot replaces the thin device of the volume with a new snapshot of
+the snapshot, so the volume would have the content of the snapshot while the
+snapshot itself is kept. The other snapshots of the volume are not affected,
+since they're independent thin devices.
+*/
+func (d *Driver) RevertSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return err
+	}
+	if volume.MountPoint != "" {
+		return fmt.Errorf("Cannot revert volume %v, which is mounted at %v", volumeID, volume.MountPoint)
+	}
+	devID, err := d.allocateDevID()
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:            LOG_REASON_START,
+		LOG_FIELD_EVENT:             LOG_EVENT_REVERT,
+		LOG_FIELD_OBJECT:            LOG_OBJECT_VOLUME,
+		LOG_FIELD_SNAPSHOT:          id,
+		LOG_FIELD_VOLUME:            volumeID,
+		DM_LOG_FIELD_VOLUME_DEVID:   volume.DevID,
+		DM_LOG_FIELD_SNAPSHOT_DEVID: snapshot.DevID,
+	}).Debugf("Reverting volume to snapshot as device %v", devID)
+	// The snapshot device is only activated while it's being read, it would
+	// be suspended by the creation in that case
+	if err := devicemapper.CreateSnapDevice(d.ThinpoolDevice, devID, id, snapshot.DevID); err != nil {
+		return err
+	}
+	if err := d.removeDevice(volumeID); err != nil {
+		devicemapper.DeleteDevice(d.ThinpoolDevice, devID)
+		return err
+	}
+	oldDevID := volume.DevID
+	if err := devicemapper.ActivateDevice(d.ThinpoolDevice, volumeID, devID, uint64(volume.Size)); err != nil {
+		// Bring back the original device, nothing has been lost yet
+		if activateErr := devicemapper.ActivateDevice(d.ThinpoolDevice, volumeID, oldDevID, uint64(volume.Size)); activateErr != nil {
+			log.Errorf("Failed to activate device %v of volume %v again: %v", oldDevID, volumeID, activateErr)
+		}
+		devicemapper.DeleteDevice(d.ThinpoolDevice, devID)
+		return err
+	}
+	volume.DevID = devID
+	volume.RestoredBackupURL = ""
+	if err := util.ObjectSave(volume); err != nil {
+		return err
+	}
+	if err := devicemapper.DeleteDevice(d.ThinpoolDevice, oldDevID); err != nil {
+		log.Errorf("Failed to delete the original device %v of volume %v: %v", oldDevID, volumeID, err)
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:    LOG_EVENT_REVERT,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_VOLUME,
+		LOG_FIELD_SNAPSHOT: id,
+		LOG_FIELD_VOLUME:   volumeID,
+	}).Debug()
+	return nil
+}
+
+/*
+CreateVolumeFromSnapshot creates the thin device of the new volume as a
+snapshot of the snapshot, which shares the blocks in the pool until they're
+written. The thin devices are independent of each other, so the snapshot and
+its volume can still be deleted later.
+*/
+func (d *Driver) CreateVolumeFromSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	snapshotID, err := util.GetFieldFromOpts(OPT_SNAPSHOT_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+	volumeID, err := util.GetFieldFromOpts(OPT_SNAPSHOT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+	snapshot, origin, err := d.getSnapshotAndVolume(snapshotID, volumeID)
+	if err != nil {
+		return err
+	}
+
+	volume := d.blankVolume(id)
+	exists, err := util.ObjectExists(volume)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return generateError(logrus.Fields{
+			LOG_FIELD_VOLUME: id,
+		}, "Already has volume with specific uuid")
+	}
+
+	devID, err := d.allocateDevID()
+	if err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:            LOG_REASON_START,
+		LOG_FIELD_EVENT:             LOG_EVENT_CREATE,
+		LOG_FIELD_OBJECT:            LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME:            id,
+		LOG_FIELD_SNAPSHOT:          snapshotID,
+		DM_LOG_FIELD_VOLUME_DEVID:   devID,
+		DM_LOG_FIELD_SNAPSHOT_DEVID: snapshot.DevID,
+	}).Debugf("Creating volume from snapshot of volume %v", volumeID)
+	if err := devicemapper.CreateSnapDevice(d.ThinpoolDevice, devID, snapshotID, snapshot.DevID); err != nil {
+		return err
+	}
+	if err := devicemapper.ActivateDevice(d.ThinpoolDevice, id, devID, uint64(origin.Size)); err != nil {
+		if err := devicemapper.DeleteDevice(d.ThinpoolDevice, devID); err != nil {
+			log.Errorf("Failed to remove device %v of volume %v: %v", devID, id, err)
+		}
+		return err
+	}
+
+	volume.DevID = devID
+	volume.Size = origin.Size
+	volume.CreatedTime = util.Now()
+	volume.Snapshots = make(map[string]Snapshot)
+	volume.Filesystem = origin.Filesystem
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) snapshotMount(snapshot *Snapshot, volume *Volume) *util.SnapshotMount {
+	return &util.SnapshotMount{
+		Name:       snapshot.Name,
+		MountPoint: snapshot.MountPoint,
+		Device:     devPath(snapshot.Name),
+		Filesystem: volume.Filesystem,
+		MountsDir:  filepath.Join(d.Root, util.SNAPSHOT_MOUNTS_DIR, volume.Name),
+	}
+}
+
+// MountSnapshot activates the device of the snapshot, and mounts it
+// read-only. The device is kept activated until the snapshot is umounted.
+func (d *Driver) MountSnapshot(req Request) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return "", err
+	}
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return "", err
+	}
+	if volume.Filesystem == "" {
+		return "", fmt.Errorf("Cannot mount snapshot %v of volume %v, which has no filesystem", id, volumeID)
+	}
+
+	activated := false
+	if snapshot.MountPoint == "" {
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_REASON:            LOG_REASON_START,
+			LOG_FIELD_EVENT:             LOG_EVENT_ACTIVATE,
+			LOG_FIELD_OBJECT:            LOG_OBJECT_SNAPSHOT,
+			LOG_FIELD_VOLUME:            volumeID,
+			LOG_FIELD_SNAPSHOT:          id,
+			DM_LOG_FIELD_SNAPSHOT_DEVID: snapshot.DevID,
+		}).Debug()
+		if err := devicemapper.ActivateDevice(d.ThinpoolDevice, id, snapshot.DevID, uint64(volume.Size)); err != nil {
+			return "", err
+		}
+		activated = true
+	}
+	m := d.snapshotMount(snapshot, volume)
+	mountPoint, err := util.VolumeMount(m, req.Options[OPT_MOUNT_POINT], false)
+	if err != nil {
+		if activated {
+			if err := devicemapper.RemoveDevice(id); err != nil {
+				log.Errorf("Failed to deactivate snapshot %v: %v", id, err)
+			}
+		}
+		return "", err
+	}
+	snapshot.Activated = true
+	snapshot.MountPoint = mountPoint
+	volume.Snapshots[id] = *snapshot
+	if err := util.ObjectSave(volume); err != nil {
+		return "", err
+	}
+	return mountPoint, nil
+}
+
+func (d *Driver) UmountSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return err
+	}
+	if snapshot.MountPoint == "" {
+		return nil
+	}
+	if err := util.SnapshotUmount(d.snapshotMount(snapshot, volume)); err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_DEACTIVATE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: id,
+	}).Debug()
+	if err := d.removeDevice(id); err != nil {
+		return err
+	}
+	snapshot.Activated = false
+	snapshot.MountPoint = ""
+	volume.Snapshots[id] = *snapshot
+	return util.ObjectSave(volume)
+}
+
 func (d *Driver) Info() (map[string]string, error) {
 	// from sector count to byte
 	blockSize := d.ThinpoolBlockSize * 512
@@ -844,6 +1104,33 @@ func (d *Driver) GetVolumeDevice(id string) (string, error) {
 	return volume.GetDevice()
 }
 
+// AttachVolume returns the device of the volume, which is always activated
+func (d *Driver) AttachVolume(req Request) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	// The device may be written by the driver on top of it
+	if volume.RestoredBackupURL != "" {
+		volume.RestoredBackupURL = ""
+		if err := util.ObjectSave(volume); err != nil {
+			return "", err
+		}
+	}
+	return volume.GetDevice()
+}
+
+func (d *Driver) DetachVolume(req Request) error {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(req.Name)
+	return util.ObjectLoad(volume)
+}
+
 func (d *Driver) MountVolume(req Request) (string, error) {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
@@ -860,6 +1147,7 @@ func (d *Driver) MountVolume(req Request) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	volume.RestoredBackupURL = ""
 
 	if err := util.ObjectSave(volume); err != nil {
 		return "", err
@@ -947,10 +1235,13 @@ func (d *Driver) getSnapshotInfo(id, volumeID string) (map[string]string, error)
 	result := map[string]string{
 		"UUID":                    id,
 		OPT_SNAPSHOT_NAME:         snapshot.Name,
+		OPT_SNAPSHOT_LABELS:       snapshot.Labels,
+		OPT_SNAPSHOT_DESCRIPTION:  snapshot.Description,
 		OPT_SNAPSHOT_CREATED_TIME: snapshot.CreatedTime,
 		OPT_SIZE:                  strconv.FormatInt(volume.Size, 10),
 		"VolumeUUID":              volumeID,
 		"DevID":                   strconv.Itoa(snapshot.DevID),
+		OPT_MOUNT_POINT:           snapshot.MountPoint,
 	}
 	log.Debug("Output result %v", result)
 	return result, nil
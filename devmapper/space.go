@@ -0,0 +1,63 @@
+package devmapper
+
+import (
+	"sort"
+
+	"github.com/rancher/convoy/metadata"
+	"github.com/rancher/convoy/util"
+)
+
+/*
+GetSnapshotUniqueSizes returns the space held only by each snapshot of the
+volume, which are the blocks not shared with its neighbors: the previous
+snapshot, and the next snapshot or the volume itself for the latest one. The
+snapshots are ordered by their device IDs, which are allocated in the order
+of creation.
+*/
+func (d *Driver) GetSnapshotUniqueSizes(volumeID string) (map[string]int64, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+	snapshots := []Snapshot{}
+	for _, snapshot := range volume.Snapshots {
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].DevID < snapshots[j].DevID
+	})
+
+	sizes := map[string]int64{}
+	for i, snapshot := range snapshots {
+		nextDevID := volume.DevID
+		if i+1 < len(snapshots) {
+			nextDevID = snapshots[i+1].DevID
+		}
+		unique, err := d.getUnsharedBlocks(snapshot.DevID, nextDevID)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			unsharedWithPrev, err := d.getUnsharedBlocks(snapshot.DevID, snapshots[i-1].DevID)
+			if err != nil {
+				return nil, err
+			}
+			unique = metadata.IntersectMappings(unique, unsharedWithPrev)
+		}
+		sizes[snapshot.Name] = metadata.GetMappingsSize(unique)
+	}
+	return sizes, nil
+}
+
+// getUnsharedBlocks returns the blocks mapped by the device devID which are
+// not shared with the device compareDevID
+func (d *Driver) getUnsharedBlocks(devID, compareDevID int) (*metadata.Mappings, error) {
+	out, err := d.thinDelta(devID, compareDevID)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.DeviceMapperThinDeltaUnsharedParser([]byte(out), d.ThinpoolBlockSize*SECTOR_SIZE)
+}
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"sync"
+	"time"
 
 	. "github.com/rancher/convoy/convoydriver"
 	"github.com/rancher/convoy/util"
@@ -50,12 +51,20 @@ func (d *Device) ConfigFile() (string, error) {
 	return filepath.Join(d.Root, DRIVER_CONFIG_FILE), nil
 }
 
+type Snapshot struct {
+	Name       string
+	VolumeName string
+	ID         string
+	SnapshotMetadata
+}
+
 type Volume struct {
 	Name       string
 	ID         string
 	Device     string
 	MountPoint string
 	Size       int64
+	Snapshots  map[string]Snapshot
 	configPath string
 }
 
@@ -196,6 +205,7 @@ func (d *Driver) CreateVolume(req Request) error {
 	vol := d.blankVolume(id)
 	var (
 		size   int64
+		doName string
 		format bool
 	)
 
@@ -215,6 +225,7 @@ func (d *Driver) CreateVolume(req Request) error {
 		}
 
 		size = doVol.SizeGigaBytes * GB
+		doName = doVol.Name
 	} else {
 		// Create new volume
 		vSize, err := d.getSize(opt, d.DefaultVolumeSize)
@@ -227,6 +238,7 @@ func (d *Driver) CreateVolume(req Request) error {
 			return err
 		}
 		size = vSize
+		doName = id
 		format = true
 	}
 
@@ -236,8 +248,11 @@ func (d *Driver) CreateVolume(req Request) error {
 
 	vol.Name = id
 	vol.ID = vID
-	vol.Device = filepath.Join(DO_DEVICE_FOLDER, DO_DEVICE_PREFIX+id)
+	// The device is named after the DigitalOcean volume name, which may
+	// differ from the Convoy volume name for an existing volume
+	vol.Device = filepath.Join(DO_DEVICE_FOLDER, DO_DEVICE_PREFIX+doName)
 	vol.Size = size
+	vol.Snapshots = make(map[string]Snapshot)
 
 	if format {
 		if err := formatDevice(vol.Device, DO_VOLUME_FS); err != nil {
@@ -374,11 +389,158 @@ func formatDevice(device, fs string) error {
 	return err
 }
 
-// These methods are not implemented currently at DigitalOcean
 func (d *Driver) SnapshotOps() (SnapshotOperations, error) {
-	return nil, errors.New("not implemented")
+	return d, nil
+}
+
+func (d *Driver) getSnapshotAndVolume(snapshotID, volumeID string) (*Snapshot, *Volume, error) {
+	vol := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(vol); err != nil {
+		return nil, nil, err
+	}
+	snap, exists := vol.Snapshots[snapshotID]
+	if !exists {
+		return nil, nil, fmt.Errorf("cannot find snapshot %s of volume %s", snapshotID, volumeID)
+	}
+	return &snap, vol, nil
+}
+
+func (d *Driver) CreateSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+
+	vol := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(vol); err != nil {
+		return err
+	}
+	if _, exists := vol.Snapshots[id]; exists {
+		return fmt.Errorf("snapshot %s of volume %s already exists", id, volumeID)
+	}
+
+	if vol.MountPoint != "" {
+		if err := util.Sync(); err != nil {
+			return err
+		}
+	}
+
+	// Snapshot names must be unique in the account
+	doSnap, err := d.client.CreateSnapshot(vol.ID, volumeID+"-"+id+"-"+util.NewUUID()[:8])
+	if err != nil {
+		return err
+	}
+
+	// Volumes created by older versions don't have the snapshot map
+	if vol.Snapshots == nil {
+		vol.Snapshots = make(map[string]Snapshot)
+	}
+	vol.Snapshots[id] = Snapshot{
+		Name:             id,
+		VolumeName:       volumeID,
+		ID:               doSnap.ID,
+		SnapshotMetadata: NewSnapshotMetadata(req.Options),
+	}
+	return util.ObjectSave(vol)
+}
+
+func (d *Driver) DeleteSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+
+	snap, vol, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return err
+	}
+
+	if err := d.client.DeleteSnapshot(snap.ID); err != nil {
+		return err
+	}
+	delete(vol.Snapshots, id)
+	return util.ObjectSave(vol)
+}
+
+func (d *Driver) GetSnapshotInfo(req Request) (map[string]string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.getSnapshotInfo(id, volumeID)
+}
+
+func (d *Driver) getSnapshotInfo(id, volumeID string) (map[string]string, error) {
+	snap, _, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return nil, err
+	}
+
+	doSnap, err := d.client.GetSnapshot(snap.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	info := map[string]string{
+		OPT_SNAPSHOT_NAME:         snap.Name,
+		OPT_SNAPSHOT_LABELS:       snap.Labels,
+		OPT_SNAPSHOT_DESCRIPTION:  snap.Description,
+		"VolumeName":              volumeID,
+		"ID":                      doSnap.ID,
+		"DOSnapshotName":          doSnap.Name,
+		OPT_SNAPSHOT_CREATED_TIME: doSnap.CreatedAt.Format(time.RFC3339),
+		OPT_SIZE:                  strconv.FormatInt(doSnap.SizeGigaBytes*GB, 10),
+	}
+	return info, nil
+}
+
+func (d *Driver) ListSnapshot(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var (
+		volumes []string
+		err     error
+	)
+	if volumeID, _ := util.GetFieldFromOpts(OPT_VOLUME_NAME, opts); volumeID != "" {
+		volumes = []string{volumeID}
+	} else {
+		volumes, err = util.ListConfigIDs(d.Root, CFG_PREFIX+VOLUME_CFG_PREFIX, CFG_SUFFIX)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ret := make(map[string]map[string]string)
+	for _, volumeID := range volumes {
+		vol := d.blankVolume(volumeID)
+		if err := util.ObjectLoad(vol); err != nil {
+			return nil, err
+		}
+		for id := range vol.Snapshots {
+			ret[id], err = d.getSnapshotInfo(id, volumeID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ret, nil
 }
 
+// Backup is not implemented currently at DigitalOcean
 func (d *Driver) BackupOps() (BackupOperations, error) {
 	return nil, errors.New("not implemented")
 }
@@ -88,6 +88,52 @@ func (c *Client) DeleteVolume(name string) error {
 	return err
 }
 
+// betaStorage returns the storage service with snapshot support, which is
+// still a beta API in godo
+func (c *Client) betaStorage() (godo.BetaStorageService, error) {
+	storage, ok := c.client.Storage.(godo.BetaStorageService)
+	if !ok {
+		return nil, errors.New("volume snapshot is not supported by the client")
+	}
+	return storage, nil
+}
+
+func (c *Client) CreateSnapshot(volumeID, name string) (*godo.Snapshot, error) {
+	storage, err := c.betaStorage()
+	if err != nil {
+		return nil, err
+	}
+
+	req := &godo.SnapshotCreateRequest{
+		VolumeID:    volumeID,
+		Name:        name,
+		Description: "Convoy snapshot",
+	}
+
+	snap, _, err := storage.CreateSnapshot(req)
+	return snap, err
+}
+
+func (c *Client) GetSnapshot(id string) (*godo.Snapshot, error) {
+	storage, err := c.betaStorage()
+	if err != nil {
+		return nil, err
+	}
+
+	snap, _, err := storage.GetSnapshot(id)
+	return snap, err
+}
+
+func (c *Client) DeleteSnapshot(id string) error {
+	storage, err := c.betaStorage()
+	if err != nil {
+		return err
+	}
+
+	_, err = storage.DeleteSnapshot(id)
+	return err
+}
+
 func (c *Client) AttachVolume(id string) error {
 	event, _, err := c.client.StorageActions.Attach(id, c.id)
 	if err != nil {
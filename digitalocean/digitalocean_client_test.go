@@ -50,6 +50,19 @@ func (s *TestSuite) TestVolume(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(stat1.Mode()&os.ModeDevice != 0, Equals, true)
 
+	log.Debug("creating snapshot")
+	snap, err := svc.CreateSnapshot(id, "volume1-snap1")
+	c.Assert(err, IsNil)
+	c.Assert(snap.VolumeID, Equals, id)
+
+	snap, err = svc.GetSnapshot(snap.ID)
+	c.Assert(err, IsNil)
+	c.Assert(snap.Name, Equals, "volume1-snap1")
+	c.Assert(snap.SizeGigaBytes, Equals, int64(1))
+
+	err = svc.DeleteSnapshot(snap.ID)
+	c.Assert(err, IsNil)
+
 	log.Debug("detaching & deleting volume")
 	err = svc.DetachVolume(id)
 	c.Assert(err, IsNil)
@@ -0,0 +1,633 @@
+package drbd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	DRIVER_NAME        = "drbd"
+	DRIVER_CONFIG_FILE = "drbd.cfg"
+
+	VOLUME_CFG_PREFIX = "volume_"
+	CFG_PREFIX        = DRIVER_NAME + "_"
+	CFG_POSTFIX       = ".json"
+
+	MOUNTS_DIR = "mounts"
+
+	DRBD_VOLUME_GROUP        = "drbd.vg"
+	DRBD_ADDRESS             = "drbd.address"
+	DRBD_PEER                = "drbd.peer"
+	DRBD_PEER_ADDRESS        = "drbd.peeraddress"
+	DRBD_PORT_BASE           = "drbd.portbase"
+	DRBD_MINOR_BASE          = "drbd.minorbase"
+	DRBD_CONF_DIR            = "drbd.confdir"
+	DRBD_DEFAULT_VOLUME_SIZE = "drbd.defaultvolumesize"
+	DRBD_DEFAULT_FS_TYPE     = "drbd.fs"
+
+	DEFAULT_PORT_BASE   = 7800
+	DEFAULT_MINOR_BASE  = 1000
+	DEFAULT_CONF_DIR    = "/etc/drbd.d"
+	DEFAULT_VOLUME_SIZE = "10G"
+	DEFAULT_FS_TYPE     = "ext4"
+
+	// MAX_VOLUMES limits the ports and the minors reserved for the volumes
+	MAX_VOLUMES = 1000
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "drbd"})
+)
+
+type Driver struct {
+	mutex *sync.RWMutex
+	Device
+}
+
+// Device records both hosts of the replication. The peer host should be
+// configured the same way, with the local and peer options swapped.
+type Device struct {
+	Root              string
+	VolumeGroup       string
+	Hostname          string
+	Address           string
+	Peer              string
+	PeerAddress       string
+	PortBase          int
+	MinorBase         int
+	ConfDir           string
+	DefaultVolumeSize int64
+	Filesystem        string
+}
+
+func (dev *Device) ConfigFile() (string, error) {
+	if dev.Root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty device config path")
+	}
+	return filepath.Join(dev.Root, DRIVER_CONFIG_FILE), nil
+}
+
+// Volume is a DRBD resource on top of a LV, replicated to the LV of the same
+// name on the peer host. The port and the minor of the resource are derived
+// from the same index, which must be the same on both hosts.
+type Volume struct {
+	Name        string
+	Resource    string
+	LV          string
+	Index       int
+	Port        int
+	Minor       int
+	Size        int64
+	MountPoint  string
+	CreatedTime string
+	Filesystem  string
+
+	configPath string
+}
+
+func (v *Volume) ConfigFile() (string, error) {
+	if v.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if v.configPath == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume config path")
+	}
+	return filepath.Join(v.configPath, CFG_PREFIX+VOLUME_CFG_PREFIX+v.Name+CFG_POSTFIX), nil
+}
+
+func (v *Volume) GetDevice() (string, error) {
+	return devicePath(v.Minor), nil
+}
+
+func (v *Volume) GetMountOpts() []string {
+	return []string{}
+}
+
+func (v *Volume) GenerateDefaultMountPoint() string {
+	return filepath.Join(v.configPath, MOUNTS_DIR, v.Name)
+}
+
+func init() {
+	if err := Register(DRIVER_NAME, Init); err != nil {
+		panic(err)
+	}
+}
+
+func (d *Driver) Name() string {
+	return DRIVER_NAME
+}
+
+func (device *Device) listVolumeNames() ([]string, error) {
+	return util.ListConfigIDs(device.Root, CFG_PREFIX+VOLUME_CFG_PREFIX, CFG_POSTFIX)
+}
+
+func (d *Driver) blankVolume(name string) *Volume {
+	return &Volume{
+		configPath: d.Root,
+		Name:       name,
+	}
+}
+
+func lvPath(vg, lv string) string {
+	return filepath.Join("/dev", vg, lv)
+}
+
+func lvm(binary string, args ...string) error {
+	if _, err := util.Execute(binary, args); err != nil {
+		return err
+	}
+	return nil
+}
+
+func parseIntOption(config map[string]string, key string, defaultValue int) (int, error) {
+	if config[key] == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.Atoi(config[key])
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("Invalid %v: %v", key, config[key])
+	}
+	return value, nil
+}
+
+func verifyConfig(config map[string]string) (*Device, error) {
+	var err error
+	dv := &Device{
+		VolumeGroup: config[DRBD_VOLUME_GROUP],
+		Address:     config[DRBD_ADDRESS],
+		Peer:        config[DRBD_PEER],
+		PeerAddress: config[DRBD_PEER_ADDRESS],
+		ConfDir:     config[DRBD_CONF_DIR],
+	}
+	if dv.VolumeGroup == "" {
+		return nil, fmt.Errorf("Volume group for DRBD backing devices unspecified")
+	}
+	if dv.Peer == "" {
+		return nil, fmt.Errorf("Hostname of the DRBD peer unspecified")
+	}
+	for key, addr := range map[string]string{
+		DRBD_ADDRESS:      dv.Address,
+		DRBD_PEER_ADDRESS: dv.PeerAddress,
+	} {
+		if net.ParseIP(addr) == nil {
+			return nil, fmt.Errorf("Invalid or unspecified %v: %v, must be an IP address", key, addr)
+		}
+	}
+	// DRBD finds the local node in the configuration by uname -n
+	if dv.Hostname, err = os.Hostname(); err != nil {
+		return nil, err
+	}
+	if dv.Hostname == dv.Peer {
+		return nil, fmt.Errorf("DRBD peer %v cannot be the local host", dv.Peer)
+	}
+
+	if dv.PortBase, err = parseIntOption(config, DRBD_PORT_BASE, DEFAULT_PORT_BASE); err != nil {
+		return nil, err
+	}
+	if dv.PortBase == 0 || dv.PortBase+MAX_VOLUMES > 65536 {
+		return nil, fmt.Errorf("Invalid %v: %v, ports %v to %v would be used", DRBD_PORT_BASE,
+			dv.PortBase, dv.PortBase, dv.PortBase+MAX_VOLUMES-1)
+	}
+	if dv.MinorBase, err = parseIntOption(config, DRBD_MINOR_BASE, DEFAULT_MINOR_BASE); err != nil {
+		return nil, err
+	}
+	if dv.ConfDir == "" {
+		dv.ConfDir = DEFAULT_CONF_DIR
+	}
+	if st, err := os.Stat(dv.ConfDir); err != nil || !st.IsDir() {
+		return nil, fmt.Errorf("Invalid DRBD configuration directory %v", dv.ConfDir)
+	}
+
+	if _, exists := config[DRBD_DEFAULT_VOLUME_SIZE]; !exists {
+		config[DRBD_DEFAULT_VOLUME_SIZE] = DEFAULT_VOLUME_SIZE
+	}
+	volumeSize, err := util.ParseSize(config[DRBD_DEFAULT_VOLUME_SIZE])
+	if err != nil || volumeSize == 0 {
+		return nil, fmt.Errorf("Illegal default volume size specified")
+	}
+	dv.DefaultVolumeSize = volumeSize
+
+	if _, exists := config[DRBD_DEFAULT_FS_TYPE]; !exists {
+		config[DRBD_DEFAULT_FS_TYPE] = DEFAULT_FS_TYPE
+	}
+	fsType := config[DRBD_DEFAULT_FS_TYPE]
+	if fsType != "ext4" && fsType != "xfs" {
+		return nil, fmt.Errorf("Unsupported filesystem type specified")
+	}
+	dv.Filesystem = fsType
+	return dv, nil
+}
+
+// remountVolumes brings up the resources and mounts the volumes again after
+// the host restarted
+func (d *Driver) remountVolumes() error {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return err
+	}
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return err
+		}
+		if _, err := drbdadm("adjust", volume.Resource); err != nil {
+			return err
+		}
+		if volume.MountPoint == "" {
+			continue
+		}
+		req := Request{
+			Name: id,
+			Options: map[string]string{
+				OPT_MOUNT_POINT: volume.MountPoint,
+			},
+		}
+		if _, err := d.mountVolume(volume, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Init(root string, config map[string]string) (ConvoyDriver, error) {
+	for _, binary := range []string{DRBDADM_BINARY, "lvcreate"} {
+		if _, err := exec.LookPath(binary); err != nil {
+			return nil, fmt.Errorf("Cannot find %v binary, please make sure drbd-utils and lvm2 are installed", binary)
+		}
+	}
+
+	if err := util.MkdirIfNotExists(root); err != nil {
+		return nil, err
+	}
+	dev := &Device{
+		Root: root,
+	}
+	exists, err := util.ObjectExists(dev)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if err := util.ObjectLoad(dev); err != nil {
+			return nil, err
+		}
+		d := &Driver{
+			mutex:  &sync.RWMutex{},
+			Device: *dev,
+		}
+		if err := d.remountVolumes(); err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+
+	dev, err = verifyConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	dev.Root = root
+
+	if err := util.ObjectSave(dev); err != nil {
+		return nil, err
+	}
+	d := &Driver{
+		mutex:  &sync.RWMutex{},
+		Device: *dev,
+	}
+	return d, nil
+}
+
+func (d *Driver) Info() (map[string]string, error) {
+	return map[string]string{
+		"Driver":            d.Name(),
+		"Root":              d.Root,
+		"VolumeGroup":       d.VolumeGroup,
+		"Hostname":          d.Hostname,
+		"Address":           d.Address,
+		"Peer":              d.Peer,
+		"PeerAddress":       d.PeerAddress,
+		"PortBase":          strconv.Itoa(d.PortBase),
+		"MinorBase":         strconv.Itoa(d.MinorBase),
+		"ConfDir":           d.ConfDir,
+		"DefaultVolumeSize": strconv.FormatInt(d.DefaultVolumeSize, 10),
+		"Filesystem":        d.Filesystem,
+	}, nil
+}
+
+func (d *Driver) VolumeOps() (VolumeOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) SnapshotOps() (SnapshotOperations, error) {
+	return nil, fmt.Errorf("Doesn't support snapshot operations")
+}
+
+func (d *Driver) BackupOps() (BackupOperations, error) {
+	return nil, fmt.Errorf("Doesn't support backup operations")
+}
+
+func (d *Driver) getSize(opts map[string]string, defaultVolumeSize int64) (int64, error) {
+	size := opts[OPT_SIZE]
+	if size == "" || size == "0" {
+		size = strconv.FormatInt(defaultVolumeSize, 10)
+	}
+	return util.ParseSize(size)
+}
+
+// usedIndexes returns the indexes taken by the existing volumes
+func (d *Driver) usedIndexes() (map[int]string, error) {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[int]string)
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return nil, err
+		}
+		used[volume.Index] = id
+	}
+	return used, nil
+}
+
+// checkIndex verifies that the port and the minor of the index are free
+func (d *Driver) checkIndex(index int, used map[int]string) error {
+	if index < 0 || index >= MAX_VOLUMES {
+		return fmt.Errorf("Invalid port %v, must be in %v to %v", d.PortBase+index, d.PortBase, d.PortBase+MAX_VOLUMES-1)
+	}
+	if id, exists := used[index]; exists {
+		return fmt.Errorf("Port %v has been used by volume %v", d.PortBase+index, id)
+	}
+	if _, err := os.Stat(devicePath(d.MinorBase + index)); err == nil {
+		return fmt.Errorf("DRBD device %v exists but is not managed by Convoy", devicePath(d.MinorBase+index))
+	}
+	return nil
+}
+
+// allocateIndex picks the index of a new volume. If port is specified, it
+// would be used, otherwise the first free one would be picked.
+func (d *Driver) allocateIndex(port string) (int, error) {
+	used, err := d.usedIndexes()
+	if err != nil {
+		return 0, err
+	}
+	if port != "" {
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid port %v", port)
+		}
+		index := p - d.PortBase
+		if err := d.checkIndex(index, used); err != nil {
+			return 0, err
+		}
+		return index, nil
+	}
+	for index := 0; index < MAX_VOLUMES; index++ {
+		if d.checkIndex(index, used) == nil {
+			return index, nil
+		}
+	}
+	return 0, fmt.Errorf("Cannot find free port for the volume, %v volumes at most", MAX_VOLUMES)
+}
+
+// CreateVolume creates the primary copy of the volume. If the port of the
+// volume is specified through opts[OPT_VOLUME_DRIVER_ID], the volume would
+// be created as the replica of the volume using the port on the peer, and
+// the data would be synced from the peer.
+func (d *Driver) CreateVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	opts := req.Options
+
+	volume := d.blankVolume(id)
+	exists, err := util.ObjectExists(volume)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Already has volume with specific uuid %v", id)
+	}
+	if opts[OPT_BACKUP_URL] != "" {
+		return fmt.Errorf("Doesn't support restoring from backup")
+	}
+
+	size, err := d.getSize(opts, d.DefaultVolumeSize)
+	if err != nil {
+		return err
+	}
+	port := opts[OPT_VOLUME_DRIVER_ID]
+	replica := port != ""
+	index, err := d.allocateIndex(port)
+	if err != nil {
+		return err
+	}
+
+	volume.Resource = RESOURCE_PREFIX + id
+	volume.LV = RESOURCE_PREFIX + id
+	volume.Index = index
+	volume.Port = d.PortBase + index
+	volume.Minor = d.MinorBase + index
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_START,
+		LOG_FIELD_EVENT:  LOG_EVENT_CREATE,
+		LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME: id,
+	}).Debugf("Creating DRBD resource %v on port %v, replica %v", volume.Resource, volume.Port, replica)
+	if err := lvm("lvcreate", "-y", "-L", strconv.FormatInt(size, 10)+"b", "-n", volume.LV, d.VolumeGroup); err != nil {
+		return err
+	}
+
+	volume.Size = size
+	volume.CreatedTime = util.Now()
+	volume.Filesystem = d.Filesystem
+	if err := util.ObjectSave(volume); err != nil {
+		return err
+	}
+
+	if err := d.writeResourceFile(volume); err != nil {
+		return err
+	}
+	if _, err := drbdadm("create-md", "--force", volume.Resource); err != nil {
+		return err
+	}
+	if _, err := drbdadm("up", volume.Resource); err != nil {
+		return err
+	}
+	if replica {
+		// The initial sync would start once connected to the peer
+		return nil
+	}
+
+	// The new volume is the source of the initial sync
+	if _, err := drbdadm("primary", "--force", volume.Resource); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := drbdadm("secondary", volume.Resource); err != nil {
+			log.Warnf("Failed to demote %v: %v", volume.Resource, err)
+		}
+	}()
+	dev := devicePath(volume.Minor)
+	log.Debugf("Formatting device %s with %s filesystem", dev, d.Filesystem)
+	if _, err := util.Execute("mkfs", []string{"-t", d.Filesystem, dev}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (d *Driver) DeleteVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	if volume.MountPoint != "" {
+		return fmt.Errorf("Cannot delete volume %s, it hasn't been umounted", id)
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_START,
+		LOG_FIELD_EVENT:  LOG_EVENT_REMOVE,
+		LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME: id,
+	}).Debugf("Removing DRBD resource %v, the replica on the peer is untouched", volume.Resource)
+	if isUp(volume.Resource) {
+		if _, err := drbdadm("down", volume.Resource); err != nil {
+			return err
+		}
+	}
+	if err := d.removeResourceFile(volume.Resource); err != nil {
+		return err
+	}
+	if err := lvm("lvremove", "-y", d.VolumeGroup+"/"+volume.LV); err != nil {
+		return err
+	}
+	return util.ObjectDelete(volume)
+}
+
+func (d *Driver) MountVolume(req Request) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	return d.mountVolume(volume, req)
+}
+
+// mountVolume promotes the local node to primary before mounting. DRBD would
+// refuse it if the peer is primary, or the local data is not up to date.
+func (d *Driver) mountVolume(volume *Volume, req Request) (string, error) {
+	if _, err := drbdadm("primary", volume.Resource); err != nil {
+		return "", err
+	}
+	mountPoint, err := util.VolumeMount(volume, req.Options[OPT_MOUNT_POINT], false)
+	if err != nil {
+		if _, err := drbdadm("secondary", volume.Resource); err != nil {
+			log.Warnf("Failed to demote %v: %v", volume.Resource, err)
+		}
+		return "", err
+	}
+
+	if err := util.ObjectSave(volume); err != nil {
+		return "", err
+	}
+	return mountPoint, nil
+}
+
+func (d *Driver) UmountVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	if err := util.VolumeUmount(volume); err != nil {
+		return err
+	}
+	// Demote so the peer can take over the volume
+	if _, err := drbdadm("secondary", volume.Resource); err != nil {
+		return err
+	}
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) MountPoint(req Request) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	return volume.MountPoint, nil
+}
+
+func (d *Driver) GetVolumeInfo(id string) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.getVolumeInfo(id)
+}
+
+func (d *Driver) getVolumeInfo(id string) (map[string]string, error) {
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+	role, diskState, connState := resourceState(volume.Resource)
+	return map[string]string{
+		"Resource":              volume.Resource,
+		"Device":                devicePath(volume.Minor),
+		"LV":                    lvPath(d.VolumeGroup, volume.LV),
+		"Port":                  strconv.Itoa(volume.Port),
+		"Peer":                  d.Peer,
+		"Role":                  role,
+		"DiskState":             diskState,
+		"ConnectionState":       connState,
+		OPT_VOLUME_NAME:         volume.Name,
+		OPT_VOLUME_CREATED_TIME: volume.CreatedTime,
+		OPT_MOUNT_POINT:         volume.MountPoint,
+		OPT_SIZE:                strconv.FormatInt(volume.Size, 10),
+		OPT_FILESYSTEM:          volume.Filesystem,
+	}, nil
+}
+
+func (d *Driver) ListVolume(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	volumes := make(map[string]map[string]string)
+	for _, id := range volumeIDs {
+		volumes[id], err = d.getVolumeInfo(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return volumes, nil
+}
@@ -0,0 +1,139 @@
+package drbd
+
+import (
+	"testing"
+
+	"github.com/rancher/convoy/util"
+
+	. "gopkg.in/check.v1"
+)
+
+const (
+	expectedResource = `# Generated by Convoy, don't edit
+resource convoy-vol1 {
+	net {
+		protocol C;
+	}
+	on host-a {
+		node-id 0;
+		device minor 1002;
+		disk /dev/vg0/convoy-vol1;
+		meta-disk internal;
+		address 10.0.0.1:7802;
+	}
+	on host-b {
+		node-id 1;
+		device minor 1002;
+		disk /dev/vg0/convoy-vol1;
+		meta-disk internal;
+		address ipv6 [fd00::2]:7802;
+	}
+}
+`
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct{}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) TestResourceConfig(c *C) {
+	volume := &Volume{
+		Resource: "convoy-vol1",
+		LV:       "convoy-vol1",
+		Port:     7802,
+		Minor:    1002,
+	}
+	// The node IDs must not depend on which host generates the config
+	dev := &Device{
+		VolumeGroup: "vg0",
+		Hostname:    "host-b",
+		Address:     "fd00::2",
+		Peer:        "host-a",
+		PeerAddress: "10.0.0.1",
+	}
+	config, err := dev.resourceConfig(volume)
+	c.Assert(err, IsNil)
+	c.Assert(config, Equals, expectedResource)
+
+	dev = &Device{
+		VolumeGroup: "vg0",
+		Hostname:    "host-a",
+		Address:     "10.0.0.1",
+		Peer:        "host-b",
+		PeerAddress: "fd00::2",
+	}
+	config, err = dev.resourceConfig(volume)
+	c.Assert(err, IsNil)
+	c.Assert(config, Equals, expectedResource)
+
+	dev.PeerAddress = "host-b"
+	_, err = dev.resourceConfig(volume)
+	c.Assert(err, ErrorMatches, "Invalid IP address host-b")
+}
+
+func (s *TestSuite) TestVerifyConfig(c *C) {
+	config := map[string]string{}
+	_, err := verifyConfig(config)
+	c.Assert(err, ErrorMatches, "Volume group for DRBD backing devices unspecified")
+
+	config[DRBD_VOLUME_GROUP] = "vg0"
+	_, err = verifyConfig(config)
+	c.Assert(err, ErrorMatches, "Hostname of the DRBD peer unspecified")
+
+	config[DRBD_PEER] = "nonexistent-peer"
+	config[DRBD_ADDRESS] = "10.0.0.1"
+	_, err = verifyConfig(config)
+	c.Assert(err, ErrorMatches, "Invalid or unspecified drbd.peeraddress: , must be an IP address")
+
+	config[DRBD_PEER_ADDRESS] = "10.0.0.2"
+	config[DRBD_PORT_BASE] = "65000"
+	_, err = verifyConfig(config)
+	c.Assert(err, ErrorMatches, "Invalid drbd.portbase: 65000, .*")
+
+	config[DRBD_PORT_BASE] = "7900"
+	config[DRBD_CONF_DIR] = c.MkDir()
+	config[DRBD_DEFAULT_FS_TYPE] = "btrfs"
+	_, err = verifyConfig(config)
+	c.Assert(err, ErrorMatches, "Unsupported filesystem type specified")
+
+	delete(config, DRBD_DEFAULT_FS_TYPE)
+	dev, err := verifyConfig(config)
+	c.Assert(err, IsNil)
+	c.Assert(dev.PortBase, Equals, 7900)
+	c.Assert(dev.MinorBase, Equals, DEFAULT_MINOR_BASE)
+	c.Assert(dev.Filesystem, Equals, DEFAULT_FS_TYPE)
+}
+
+func (s *TestSuite) TestAllocateIndex(c *C) {
+	d := &Driver{
+		Device: Device{
+			Root:      c.MkDir(),
+			PortBase:  DEFAULT_PORT_BASE,
+			MinorBase: 1000000,
+		},
+	}
+	index, err := d.allocateIndex("")
+	c.Assert(err, IsNil)
+	c.Assert(index, Equals, 0)
+
+	volume := d.blankVolume("vol1")
+	volume.Index = 0
+	c.Assert(util.ObjectSave(volume), IsNil)
+
+	index, err = d.allocateIndex("")
+	c.Assert(err, IsNil)
+	c.Assert(index, Equals, 1)
+
+	index, err = d.allocateIndex("7805")
+	c.Assert(err, IsNil)
+	c.Assert(index, Equals, 5)
+
+	_, err = d.allocateIndex("7800")
+	c.Assert(err, ErrorMatches, "Port 7800 has been used by volume vol1")
+	_, err = d.allocateIndex("9000")
+	c.Assert(err, ErrorMatches, "Invalid port 9000, must be in 7800 to 8799")
+	_, err = d.allocateIndex("port")
+	c.Assert(err, ErrorMatches, "Invalid port port")
+}
@@ -0,0 +1,163 @@
+package drbd
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	DRBDADM_BINARY = "drbdadm"
+
+	RESOURCE_PREFIX  = "convoy-"
+	RESOURCE_POSTFIX = ".res"
+
+	DEVICE_PREFIX = "/dev/drbd"
+)
+
+var (
+	resourceTemplate = template.Must(template.New("resource").Parse(`# Generated by Convoy, don't edit
+resource {{.Name}} {
+	net {
+		protocol C;
+	}
+{{- range .Nodes}}
+	on {{.Hostname}} {
+		node-id {{.ID}};
+		device minor {{$.Minor}};
+		disk {{$.Disk}};
+		meta-disk internal;
+		address {{.Address}};
+	}
+{{- end}}
+}
+`))
+)
+
+type resourceNode struct {
+	ID       int
+	Hostname string
+	Address  string
+}
+
+type nodesByHostname []resourceNode
+
+func (n nodesByHostname) Len() int           { return len(n) }
+func (n nodesByHostname) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+func (n nodesByHostname) Less(i, j int) bool { return n[i].Hostname < n[j].Hostname }
+
+type resource struct {
+	Name  string
+	Minor int
+	Disk  string
+	Nodes []resourceNode
+}
+
+func drbdadm(args ...string) (string, error) {
+	return util.Execute(DRBDADM_BINARY, args)
+}
+
+func devicePath(minor int) string {
+	return DEVICE_PREFIX + strconv.Itoa(minor)
+}
+
+// formatAddress returns the address in the form of DRBD configuration
+func formatAddress(ip string, port int) (string, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return "", fmt.Errorf("Invalid IP address %v", ip)
+	}
+	if addr.To4() == nil {
+		return fmt.Sprintf("ipv6 [%v]:%d", ip, port), nil
+	}
+	return fmt.Sprintf("%v:%d", ip, port), nil
+}
+
+// resourceConfig generates the configuration of the volume, which would be
+// the same on both hosts. The node IDs are assigned in the order of the
+// hostnames, so both hosts agree on them.
+func (dev *Device) resourceConfig(volume *Volume) (string, error) {
+	res := resource{
+		Name:  volume.Resource,
+		Minor: volume.Minor,
+		Disk:  lvPath(dev.VolumeGroup, volume.LV),
+	}
+	local, err := formatAddress(dev.Address, volume.Port)
+	if err != nil {
+		return "", err
+	}
+	peer, err := formatAddress(dev.PeerAddress, volume.Port)
+	if err != nil {
+		return "", err
+	}
+	res.Nodes = []resourceNode{
+		{Hostname: dev.Hostname, Address: local},
+		{Hostname: dev.Peer, Address: peer},
+	}
+	sort.Sort(nodesByHostname(res.Nodes))
+	for i := range res.Nodes {
+		res.Nodes[i].ID = i
+	}
+
+	buf := &bytes.Buffer{}
+	if err := resourceTemplate.Execute(buf, res); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (dev *Device) resourceFile(resource string) string {
+	return filepath.Join(dev.ConfDir, resource+RESOURCE_POSTFIX)
+}
+
+func (dev *Device) writeResourceFile(volume *Volume) error {
+	config, err := dev.resourceConfig(volume)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(dev.resourceFile(volume.Resource))
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(config); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (dev *Device) removeResourceFile(resource string) error {
+	if err := os.Remove(dev.resourceFile(resource)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// isUp tells if the resource has been configured in the kernel
+func isUp(resource string) bool {
+	_, err := drbdadm("status", resource)
+	return err == nil
+}
+
+// resourceState returns the role of the local node, the state of local disk
+// and the connection state to the peer. Empty string would be returned for
+// the state cannot be retrieved.
+func resourceState(resource string) (role, diskState, connState string) {
+	get := func(cmd string) string {
+		out, err := drbdadm(cmd, resource)
+		if err != nil {
+			return ""
+		}
+		// The state of the peer may follow after "/"
+		return strings.SplitN(strings.TrimSpace(out), "/", 2)[0]
+	}
+	return get("role"), get("dstate"), get("cstate")
+}
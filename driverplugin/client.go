@@ -0,0 +1,541 @@
+package driverplugin
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
+
+	. "github.com/rancher/convoy/convoydriver"
+	pb "github.com/rancher/convoy/driverplugin/proto"
+)
+
+const (
+	HANDSHAKE_TIMEOUT = 30 * time.Second
+	SHUTDOWN_TIMEOUT  = 10 * time.Second
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "driverplugin"})
+
+	clientsMutex = &sync.Mutex{}
+	clients      = []*Client{}
+)
+
+// grpcLogger logs the messages of gRPC for debugging only, since the
+// connections to the plugins would be closed as the plugins restart
+type grpcLogger struct {
+	*logrus.Entry
+}
+
+func (l grpcLogger) Print(args ...interface{}) {
+	l.Debug(args...)
+}
+
+func (l grpcLogger) Printf(format string, args ...interface{}) {
+	l.Debugf(format, args...)
+}
+
+func (l grpcLogger) Println(args ...interface{}) {
+	l.Debugln(args...)
+}
+
+func init() {
+	grpclog.SetLogger(grpcLogger{log})
+}
+
+/*
+Client is the Convoy Driver backed by a plugin process. The process would be
+started again on the next call if it exits.
+*/
+type Client struct {
+	name   string
+	root   string
+	config map[string]string
+	newCmd func() *exec.Cmd
+
+	mutex *sync.Mutex
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	conn  *pluginConn
+	exit  chan struct{}
+	ops   *pb.InitResponse
+}
+
+// pluginConn is the connection to the plugin process, with the clients of
+// the services on it
+type pluginConn struct {
+	conn     *grpc.ClientConn
+	driver   pb.ConvoyDriverClient
+	volume   pb.VolumeOperationsClient
+	snapshot pb.SnapshotOperationsClient
+	backup   pb.BackupOperationsClient
+}
+
+/*
+RegisterPlugin would add the plugin binary at path as Convoy Driver name, so
+it can be enabled through --drivers like the builtin drivers.
+*/
+func RegisterPlugin(name, path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("Path of driver plugin %v must be absolute: %v", name, path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("Cannot find driver plugin %v: %v", name, err)
+	}
+	if info.IsDir() || info.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("Driver plugin %v at %v is not executable", name, path)
+	}
+	return RegisterCommand(name, func() *exec.Cmd {
+		return exec.Command(path)
+	})
+}
+
+// RegisterCommand is the same as RegisterPlugin, with the command to start the
+// plugin specified by newCmd
+func RegisterCommand(name string, newCmd func() *exec.Cmd) error {
+	return Register(name, func(root string, config map[string]string) (ConvoyDriver, error) {
+		return NewClient(name, root, config, newCmd)
+	})
+}
+
+// NewClient starts the plugin and initializes the driver in it
+func NewClient(name, root string, config map[string]string, newCmd func() *exec.Cmd) (*Client, error) {
+	c := &Client{
+		name:   name,
+		root:   root,
+		config: config,
+		newCmd: newCmd,
+		mutex:  &sync.Mutex{},
+	}
+	if _, err := c.getConn(); err != nil {
+		return nil, err
+	}
+
+	clientsMutex.Lock()
+	clients = append(clients, c)
+	clientsMutex.Unlock()
+	return c, nil
+}
+
+// Shutdown stops all the plugin processes, which should be called before the
+// daemon exits
+func Shutdown() {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+
+	for _, c := range clients {
+		c.mutex.Lock()
+		c.stop()
+		c.mutex.Unlock()
+	}
+	clients = []*Client{}
+}
+
+func parseHandshake(line string) (string, string, error) {
+	parts := strings.Split(strings.TrimSpace(line), HANDSHAKE_SEPARATOR)
+	if len(parts) != 4 {
+		return "", "", fmt.Errorf("Invalid handshake %q, expect <version>|<network>|<address>|<protocol>", line)
+	}
+	if parts[0] != PROTOCOL_VERSION {
+		return "", "", fmt.Errorf("Unsupported protocol version %v, expect %v", parts[0], PROTOCOL_VERSION)
+	}
+	if parts[1] != "unix" && parts[1] != "tcp" {
+		return "", "", fmt.Errorf("Unsupported network %v", parts[1])
+	}
+	if parts[3] != HANDSHAKE_PROTOCOL {
+		return "", "", fmt.Errorf("Unsupported protocol %v, expect %v", parts[3], HANDSHAKE_PROTOCOL)
+	}
+	return parts[1], parts[2], nil
+}
+
+func (c *Client) logOutput(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		log.WithFields(logrus.Fields{"plugin": c.name}).Info(scanner.Text())
+	}
+}
+
+// start runs the plugin process and connects to it. c.mutex must be held.
+func (c *Client) start() error {
+	cmd := c.newCmd()
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env,
+		MAGIC_COOKIE_KEY+"="+MAGIC_COOKIE_VALUE,
+		PROTOCOL_VERSION_KEY+"="+PROTOCOL_VERSION)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	log.Debugf("Starting driver plugin %v: %v", c.name, strings.Join(cmd.Args, " "))
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Failed to start driver plugin %v: %v", c.name, err)
+	}
+	c.cmd = cmd
+	c.stdin = stdin
+	c.exit = make(chan struct{})
+	exit := c.exit
+	go c.logOutput(stderr)
+
+	handshake := make(chan string, 1)
+	go func() {
+		reader := bufio.NewReader(stdout)
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			line = ""
+		}
+		handshake <- line
+		// Keep draining the output, or the plugin may block on writing
+		c.logOutput(reader)
+		cmd.Wait()
+		close(exit)
+	}()
+
+	var line string
+	select {
+	case line = <-handshake:
+	case <-time.After(HANDSHAKE_TIMEOUT):
+		c.stop()
+		return fmt.Errorf("Timeout waiting for the handshake of driver plugin %v", c.name)
+	}
+	network, addr, err := parseHandshake(line)
+	if err != nil {
+		c.stop()
+		return fmt.Errorf("Failed to start driver plugin %v: %v", c.name, err)
+	}
+	conn, err := grpc.Dial(addr,
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.WithTimeout(HANDSHAKE_TIMEOUT),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout(network, addr, timeout)
+		}))
+	if err != nil {
+		c.stop()
+		return fmt.Errorf("Failed to connect to driver plugin %v: %v", c.name, err)
+	}
+	c.conn = &pluginConn{
+		conn:     conn,
+		driver:   pb.NewConvoyDriverClient(conn),
+		volume:   pb.NewVolumeOperationsClient(conn),
+		snapshot: pb.NewSnapshotOperationsClient(conn),
+		backup:   pb.NewBackupOperationsClient(conn),
+	}
+
+	req := &pb.InitRequest{
+		Root:   c.root,
+		Config: c.config,
+	}
+	ops, err := c.conn.driver.Init(context.Background(), req)
+	if err != nil {
+		c.stop()
+		return fmt.Errorf("%v", grpc.ErrorDesc(err))
+	}
+	c.ops = ops
+	log.Debugf("Driver plugin %v started", c.name)
+	return nil
+}
+
+// stop closes the connection and waits for the plugin to exit, which would
+// be killed if it doesn't exit in time. c.mutex must be held.
+func (c *Client) stop() {
+	if c.cmd == nil {
+		return
+	}
+	if c.conn != nil {
+		c.conn.conn.Close()
+		c.conn = nil
+	}
+	c.stdin.Close()
+	select {
+	case <-c.exit:
+	case <-time.After(SHUTDOWN_TIMEOUT):
+		log.Warnf("Driver plugin %v doesn't exit in time, killing it", c.name)
+		c.cmd.Process.Kill()
+		<-c.exit
+	}
+	c.cmd = nil
+}
+
+func (c *Client) getConn() (*pluginConn, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.conn != nil {
+		select {
+		case <-c.exit:
+			log.Warnf("Driver plugin %v has exited, restarting it", c.name)
+			c.stop()
+		default:
+			return c.conn, nil
+		}
+	}
+	if err := c.start(); err != nil {
+		return nil, err
+	}
+	return c.conn, nil
+}
+
+// call runs the method of the plugin by f, with the errors of the driver
+// returned as they are
+func (c *Client) call(method string, f func(conn *pluginConn) error) error {
+	conn, err := c.getConn()
+	if err != nil {
+		return err
+	}
+	err = f(conn)
+	if err == nil {
+		return nil
+	}
+	// The errors of the driver are returned as unknown, while the others are
+	// the failures of the connection
+	if code := grpc.Code(err); code == codes.Unavailable || code == codes.Internal {
+		// Clean up, so the plugin would be started again on the next call
+		c.mutex.Lock()
+		if c.conn == conn {
+			c.stop()
+		}
+		c.mutex.Unlock()
+		return fmt.Errorf("Driver plugin %v has exited during %v: %v", c.name, method, grpc.ErrorDesc(err))
+	}
+	return fmt.Errorf("%v", grpc.ErrorDesc(err))
+}
+
+func listItems(list *pb.ListResponse) map[string]map[string]string {
+	items := map[string]map[string]string{}
+	for name, info := range list.Items {
+		items[name] = info.Info
+		if items[name] == nil {
+			items[name] = map[string]string{}
+		}
+	}
+	return items
+}
+
+func newPBRequest(req Request) *pb.Request {
+	return &pb.Request{
+		Name:    req.Name,
+		Options: req.Options,
+	}
+}
+
+func (c *Client) Name() string {
+	return c.name
+}
+
+func (c *Client) Info() (map[string]string, error) {
+	var resp *pb.InfoResponse
+	if err := c.call("Info", func(conn *pluginConn) (err error) {
+		resp, err = conn.driver.Info(context.Background(), &pb.Empty{})
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return resp.Info, nil
+}
+
+func (c *Client) VolumeOps() (VolumeOperations, error) {
+	if c.ops.VolumeOpsError != "" {
+		return nil, fmt.Errorf("%v", c.ops.VolumeOpsError)
+	}
+	return c, nil
+}
+
+func (c *Client) SnapshotOps() (SnapshotOperations, error) {
+	if c.ops.SnapshotOpsError != "" {
+		return nil, fmt.Errorf("%v", c.ops.SnapshotOpsError)
+	}
+	return c, nil
+}
+
+func (c *Client) BackupOps() (BackupOperations, error) {
+	if c.ops.BackupOpsError != "" {
+		return nil, fmt.Errorf("%v", c.ops.BackupOpsError)
+	}
+	return c, nil
+}
+
+func (c *Client) CreateVolume(req Request) error {
+	return c.call("CreateVolume", func(conn *pluginConn) error {
+		_, err := conn.volume.CreateVolume(context.Background(), newPBRequest(req))
+		return err
+	})
+}
+
+func (c *Client) DeleteVolume(req Request) error {
+	return c.call("DeleteVolume", func(conn *pluginConn) error {
+		_, err := conn.volume.DeleteVolume(context.Background(), newPBRequest(req))
+		return err
+	})
+}
+
+func (c *Client) MountVolume(req Request) (string, error) {
+	var resp *pb.MountPointResponse
+	if err := c.call("MountVolume", func(conn *pluginConn) (err error) {
+		resp, err = conn.volume.MountVolume(context.Background(), newPBRequest(req))
+		return err
+	}); err != nil {
+		return "", err
+	}
+	return resp.MountPoint, nil
+}
+
+func (c *Client) UmountVolume(req Request) error {
+	return c.call("UmountVolume", func(conn *pluginConn) error {
+		_, err := conn.volume.UmountVolume(context.Background(), newPBRequest(req))
+		return err
+	})
+}
+
+func (c *Client) MountPoint(req Request) (string, error) {
+	var resp *pb.MountPointResponse
+	if err := c.call("MountPoint", func(conn *pluginConn) (err error) {
+		resp, err = conn.volume.MountPoint(context.Background(), newPBRequest(req))
+		return err
+	}); err != nil {
+		return "", err
+	}
+	return resp.MountPoint, nil
+}
+
+func (c *Client) GetVolumeInfo(name string) (map[string]string, error) {
+	var resp *pb.InfoResponse
+	if err := c.call("GetVolumeInfo", func(conn *pluginConn) (err error) {
+		resp, err = conn.volume.GetVolumeInfo(context.Background(), &pb.VolumeInfoRequest{Name: name})
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return resp.Info, nil
+}
+
+func (c *Client) ListVolume(opts map[string]string) (map[string]map[string]string, error) {
+	var resp *pb.ListResponse
+	if err := c.call("ListVolume", func(conn *pluginConn) (err error) {
+		resp, err = conn.volume.ListVolume(context.Background(), &pb.ListRequest{Opts: opts})
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return listItems(resp), nil
+}
+
+func (c *Client) CreateSnapshot(req Request) error {
+	return c.call("CreateSnapshot", func(conn *pluginConn) error {
+		_, err := conn.snapshot.CreateSnapshot(context.Background(), newPBRequest(req))
+		return err
+	})
+}
+
+func (c *Client) DeleteSnapshot(req Request) error {
+	return c.call("DeleteSnapshot", func(conn *pluginConn) error {
+		_, err := conn.snapshot.DeleteSnapshot(context.Background(), newPBRequest(req))
+		return err
+	})
+}
+
+func (c *Client) GetSnapshotInfo(req Request) (map[string]string, error) {
+	var resp *pb.InfoResponse
+	if err := c.call("GetSnapshotInfo", func(conn *pluginConn) (err error) {
+		resp, err = conn.snapshot.GetSnapshotInfo(context.Background(), newPBRequest(req))
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return resp.Info, nil
+}
+
+func (c *Client) ListSnapshot(opts map[string]string) (map[string]map[string]string, error) {
+	var resp *pb.ListResponse
+	if err := c.call("ListSnapshot", func(conn *pluginConn) (err error) {
+		resp, err = conn.snapshot.ListSnapshot(context.Background(), &pb.ListRequest{Opts: opts})
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return listItems(resp), nil
+}
+
+func (c *Client) CreateBackup(snapshotID, volumeID, destURL, endpointURL string, opts map[string]string) (string, error) {
+	var resp *pb.CreateBackupResponse
+	req := &pb.CreateBackupRequest{
+		SnapshotId:  snapshotID,
+		VolumeId:    volumeID,
+		DestUrl:     destURL,
+		EndpointUrl: endpointURL,
+		Opts:        opts,
+	}
+	if err := c.call("CreateBackup", func(conn *pluginConn) (err error) {
+		resp, err = conn.backup.CreateBackup(context.Background(), req)
+		return err
+	}); err != nil {
+		return "", err
+	}
+	return resp.BackupUrl, nil
+}
+
+func (c *Client) DeleteBackup(backupURL, endpointURL string, opts map[string]string) error {
+	req := &pb.DeleteBackupRequest{
+		BackupUrl:   backupURL,
+		EndpointUrl: endpointURL,
+		Opts:        opts,
+	}
+	return c.call("DeleteBackup", func(conn *pluginConn) error {
+		_, err := conn.backup.DeleteBackup(context.Background(), req)
+		return err
+	})
+}
+
+func (c *Client) GetBackupInfo(backupURL, endpointURL string) (map[string]string, error) {
+	var resp *pb.InfoResponse
+	req := &pb.GetBackupInfoRequest{
+		BackupUrl:   backupURL,
+		EndpointUrl: endpointURL,
+	}
+	if err := c.call("GetBackupInfo", func(conn *pluginConn) (err error) {
+		resp, err = conn.backup.GetBackupInfo(context.Background(), req)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return resp.Info, nil
+}
+
+func (c *Client) ListBackup(destURL, endpointURL string, opts map[string]string) (map[string]map[string]string, error) {
+	var resp *pb.ListResponse
+	req := &pb.ListBackupRequest{
+		DestUrl:     destURL,
+		EndpointUrl: endpointURL,
+		Opts:        opts,
+	}
+	if err := c.call("ListBackup", func(conn *pluginConn) (err error) {
+		resp, err = conn.backup.ListBackup(context.Background(), req)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return listItems(resp), nil
+}
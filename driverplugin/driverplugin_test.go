@@ -0,0 +1,220 @@
+package driverplugin
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "gopkg.in/check.v1"
+)
+
+const (
+	helperEnv    = "CONVOY_DRIVER_PLUGIN_TEST_HELPER"
+	helperDriver = "plugintest"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+// TestHelperProcess is not a real test, it runs the fake driver as plugin
+// when the test binary is started by the client
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv(helperEnv) != "1" {
+		return
+	}
+	if err := Serve(initFakeDriver); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func helperCmd() *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=TestHelperProcess")
+	cmd.Env = append(os.Environ(), helperEnv+"=1")
+	return cmd
+}
+
+type fakeDriver struct {
+	mutex   *sync.Mutex
+	root    string
+	volumes map[string]map[string]string
+}
+
+func initFakeDriver(root string, config map[string]string) (ConvoyDriver, error) {
+	if config["fail"] != "" {
+		return nil, fmt.Errorf("Failed as requested")
+	}
+	return &fakeDriver{
+		mutex:   &sync.Mutex{},
+		root:    root,
+		volumes: map[string]map[string]string{},
+	}, nil
+}
+
+func (f *fakeDriver) Name() string { return helperDriver }
+
+func (f *fakeDriver) Info() (map[string]string, error) {
+	return map[string]string{
+		"Root": f.root,
+		"Pid":  fmt.Sprint(os.Getpid()),
+	}, nil
+}
+
+func (f *fakeDriver) VolumeOps() (VolumeOperations, error) { return f, nil }
+
+func (f *fakeDriver) SnapshotOps() (SnapshotOperations, error) {
+	return nil, fmt.Errorf("Doesn't support snapshot operations")
+}
+
+func (f *fakeDriver) BackupOps() (BackupOperations, error) {
+	return nil, fmt.Errorf("Doesn't support backup operations")
+}
+
+func (f *fakeDriver) CreateVolume(req Request) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, exists := f.volumes[req.Name]; exists {
+		return fmt.Errorf("Volume %v already exists", req.Name)
+	}
+	f.volumes[req.Name] = map[string]string{
+		OPT_SIZE: req.Options[OPT_SIZE],
+	}
+	return nil
+}
+
+func (f *fakeDriver) DeleteVolume(req Request) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.volumes, req.Name)
+	return nil
+}
+
+func (f *fakeDriver) MountVolume(req Request) (string, error) {
+	return "/mnt/" + req.Name, nil
+}
+
+func (f *fakeDriver) UmountVolume(req Request) error {
+	if req.Name == "crash" {
+		os.Exit(2)
+	}
+	return nil
+}
+
+func (f *fakeDriver) MountPoint(req Request) (string, error) {
+	return "", nil
+}
+
+func (f *fakeDriver) GetVolumeInfo(name string) (map[string]string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	info, exists := f.volumes[name]
+	if !exists {
+		return nil, fmt.Errorf("Cannot find volume %v", name)
+	}
+	return info, nil
+}
+
+func (f *fakeDriver) ListVolume(opts map[string]string) (map[string]map[string]string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.volumes, nil
+}
+
+type TestSuite struct{}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) TearDownTest(c *C) {
+	Shutdown()
+}
+
+func (s *TestSuite) TestHandshake(c *C) {
+	network, addr, err := parseHandshake("2|unix|/tmp/plugin.sock|grpc\n")
+	c.Assert(err, IsNil)
+	c.Assert(network, Equals, "unix")
+	c.Assert(addr, Equals, "/tmp/plugin.sock")
+
+	_, _, err = parseHandshake("1|unix|/tmp/plugin.sock|grpc")
+	c.Assert(err, ErrorMatches, "Unsupported protocol version 1, expect 2")
+	_, _, err = parseHandshake("2|unix|/tmp/plugin.sock|netrpc")
+	c.Assert(err, ErrorMatches, "Unsupported protocol netrpc, expect grpc")
+	_, _, err = parseHandshake("2|unix|/tmp/plugin.sock")
+	c.Assert(err, ErrorMatches, "Invalid handshake .*")
+	_, _, err = parseHandshake("")
+	c.Assert(err, ErrorMatches, "Invalid handshake .*")
+}
+
+func (s *TestSuite) TestServeWithoutDaemon(c *C) {
+	c.Assert(Serve(initFakeDriver), ErrorMatches, "This binary is a Convoy driver plugin.*")
+}
+
+func (s *TestSuite) TestRegisterPlugin(c *C) {
+	c.Assert(RegisterPlugin("plugin1", "plugin1"), ErrorMatches, "Path of driver plugin plugin1 must be absolute.*")
+	c.Assert(RegisterPlugin("plugin1", "/nonexistent"), ErrorMatches, "Cannot find driver plugin plugin1.*")
+	c.Assert(RegisterPlugin("plugin1", c.MkDir()), ErrorMatches, "Driver plugin plugin1 at .* is not executable")
+}
+
+func (s *TestSuite) TestClient(c *C) {
+	root := c.MkDir()
+	c.Assert(RegisterCommand(helperDriver, helperCmd), IsNil)
+	driver, err := GetDriver(helperDriver, root, map[string]string{})
+	c.Assert(err, IsNil)
+	c.Assert(driver.Name(), Equals, helperDriver)
+
+	info, err := driver.Info()
+	c.Assert(err, IsNil)
+	c.Assert(info["Root"], Equals, root+"/"+helperDriver)
+	c.Assert(info["Pid"], Not(Equals), fmt.Sprint(os.Getpid()))
+
+	_, err = driver.SnapshotOps()
+	c.Assert(err, ErrorMatches, "Doesn't support snapshot operations")
+	_, err = driver.BackupOps()
+	c.Assert(err, ErrorMatches, "Doesn't support backup operations")
+
+	volOps, err := driver.VolumeOps()
+	c.Assert(err, IsNil)
+	req := Request{
+		Name: "vol1",
+		Options: map[string]string{
+			OPT_SIZE: "1024",
+		},
+	}
+	c.Assert(volOps.CreateVolume(req), IsNil)
+	c.Assert(volOps.CreateVolume(req), ErrorMatches, "Volume vol1 already exists")
+
+	volume, err := volOps.GetVolumeInfo("vol1")
+	c.Assert(err, IsNil)
+	c.Assert(volume[OPT_SIZE], Equals, "1024")
+	_, err = volOps.GetVolumeInfo("vol2")
+	c.Assert(err, ErrorMatches, "Cannot find volume vol2")
+
+	volumes, err := volOps.ListVolume(map[string]string{})
+	c.Assert(err, IsNil)
+	c.Assert(volumes, HasLen, 1)
+
+	mountPoint, err := volOps.MountVolume(req)
+	c.Assert(err, IsNil)
+	c.Assert(mountPoint, Equals, "/mnt/vol1")
+
+	// The plugin would be started again after crashed
+	err = volOps.UmountVolume(Request{Name: "crash"})
+	c.Assert(err, ErrorMatches, "Driver plugin plugintest has exited during UmountVolume.*")
+	newInfo, err := driver.Info()
+	c.Assert(err, IsNil)
+	c.Assert(newInfo["Pid"], Not(Equals), info["Pid"])
+}
+
+func (s *TestSuite) TestClientInitFailure(c *C) {
+	_, err := NewClient("plugin2", c.MkDir(), map[string]string{"fail": "true"}, helperCmd)
+	c.Assert(err, ErrorMatches, "Failed as requested")
+
+	_, err = NewClient("plugin2", c.MkDir(), map[string]string{}, func() *exec.Cmd {
+		return exec.Command("sh", "-c", "echo invalid")
+	})
+	c.Assert(err, NotNil)
+	c.Assert(strings.Contains(err.Error(), "Invalid handshake"), Equals, true)
+}
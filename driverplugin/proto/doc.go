@@ -0,0 +1,4 @@
+package proto
+
+// plugin.pb.go is generated by protoc-gen-go v1.0.0 of github.com/golang/protobuf
+//go:generate protoc --go_out=plugins=grpc:. plugin.proto
@@ -0,0 +1,1148 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: plugin.proto
+
+/*
+Package proto is a generated protocol buffer package.
+
+It is generated from these files:
+
+	plugin.proto
+
+It has these top-level messages:
+
+	Empty
+	Request
+	InitRequest
+	InitResponse
+	InfoResponse
+	ListResponse
+	ListRequest
+	MountPointResponse
+	VolumeInfoRequest
+	CreateBackupRequest
+	CreateBackupResponse
+	DeleteBackupRequest
+	GetBackupInfoRequest
+	ListBackupRequest
+*/
+package proto
+
+import proto1 "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto1.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto1.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type Empty struct {
+}
+
+func (m *Empty) Reset()                    { *m = Empty{} }
+func (m *Empty) String() string            { return proto1.CompactTextString(m) }
+func (*Empty) ProtoMessage()               {}
+func (*Empty) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+// Request is convoydriver.Request
+type Request struct {
+	Name    string            `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Options map[string]string `protobuf:"bytes,2,rep,name=options" json:"options,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *Request) Reset()                    { *m = Request{} }
+func (m *Request) String() string            { return proto1.CompactTextString(m) }
+func (*Request) ProtoMessage()               {}
+func (*Request) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *Request) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Request) GetOptions() map[string]string {
+	if m != nil {
+		return m.Options
+	}
+	return nil
+}
+
+type InitRequest struct {
+	// The root directory of the driver
+	Root string `protobuf:"bytes,1,opt,name=root" json:"root,omitempty"`
+	// The driver options, same as the ones passed to the builtin drivers
+	Config map[string]string `protobuf:"bytes,2,rep,name=config" json:"config,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *InitRequest) Reset()                    { *m = InitRequest{} }
+func (m *InitRequest) String() string            { return proto1.CompactTextString(m) }
+func (*InitRequest) ProtoMessage()               {}
+func (*InitRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *InitRequest) GetRoot() string {
+	if m != nil {
+		return m.Root
+	}
+	return ""
+}
+
+func (m *InitRequest) GetConfig() map[string]string {
+	if m != nil {
+		return m.Config
+	}
+	return nil
+}
+
+// InitResponse reports the operations the driver doesn't support, by the
+// errors returned from VolumeOps(), SnapshotOps() and BackupOps()
+type InitResponse struct {
+	VolumeOpsError   string `protobuf:"bytes,1,opt,name=volume_ops_error,json=volumeOpsError" json:"volume_ops_error,omitempty"`
+	SnapshotOpsError string `protobuf:"bytes,2,opt,name=snapshot_ops_error,json=snapshotOpsError" json:"snapshot_ops_error,omitempty"`
+	BackupOpsError   string `protobuf:"bytes,3,opt,name=backup_ops_error,json=backupOpsError" json:"backup_ops_error,omitempty"`
+}
+
+func (m *InitResponse) Reset()                    { *m = InitResponse{} }
+func (m *InitResponse) String() string            { return proto1.CompactTextString(m) }
+func (*InitResponse) ProtoMessage()               {}
+func (*InitResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *InitResponse) GetVolumeOpsError() string {
+	if m != nil {
+		return m.VolumeOpsError
+	}
+	return ""
+}
+
+func (m *InitResponse) GetSnapshotOpsError() string {
+	if m != nil {
+		return m.SnapshotOpsError
+	}
+	return ""
+}
+
+func (m *InitResponse) GetBackupOpsError() string {
+	if m != nil {
+		return m.BackupOpsError
+	}
+	return ""
+}
+
+// InfoResponse is the information of the driver, a volume, a snapshot or a
+// backup
+type InfoResponse struct {
+	Info map[string]string `protobuf:"bytes,1,rep,name=info" json:"info,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *InfoResponse) Reset()                    { *m = InfoResponse{} }
+func (m *InfoResponse) String() string            { return proto1.CompactTextString(m) }
+func (*InfoResponse) ProtoMessage()               {}
+func (*InfoResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *InfoResponse) GetInfo() map[string]string {
+	if m != nil {
+		return m.Info
+	}
+	return nil
+}
+
+// ListResponse is the information of the objects keyed by their names, or the
+// URLs for the backups
+type ListResponse struct {
+	Items map[string]*InfoResponse `protobuf:"bytes,1,rep,name=items" json:"items,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *ListResponse) Reset()                    { *m = ListResponse{} }
+func (m *ListResponse) String() string            { return proto1.CompactTextString(m) }
+func (*ListResponse) ProtoMessage()               {}
+func (*ListResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *ListResponse) GetItems() map[string]*InfoResponse {
+	if m != nil {
+		return m.Items
+	}
+	return nil
+}
+
+type ListRequest struct {
+	Opts map[string]string `protobuf:"bytes,1,rep,name=opts" json:"opts,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *ListRequest) Reset()                    { *m = ListRequest{} }
+func (m *ListRequest) String() string            { return proto1.CompactTextString(m) }
+func (*ListRequest) ProtoMessage()               {}
+func (*ListRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *ListRequest) GetOpts() map[string]string {
+	if m != nil {
+		return m.Opts
+	}
+	return nil
+}
+
+type MountPointResponse struct {
+	MountPoint string `protobuf:"bytes,1,opt,name=mount_point,json=mountPoint" json:"mount_point,omitempty"`
+}
+
+func (m *MountPointResponse) Reset()                    { *m = MountPointResponse{} }
+func (m *MountPointResponse) String() string            { return proto1.CompactTextString(m) }
+func (*MountPointResponse) ProtoMessage()               {}
+func (*MountPointResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *MountPointResponse) GetMountPoint() string {
+	if m != nil {
+		return m.MountPoint
+	}
+	return ""
+}
+
+type VolumeInfoRequest struct {
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+}
+
+func (m *VolumeInfoRequest) Reset()                    { *m = VolumeInfoRequest{} }
+func (m *VolumeInfoRequest) String() string            { return proto1.CompactTextString(m) }
+func (*VolumeInfoRequest) ProtoMessage()               {}
+func (*VolumeInfoRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *VolumeInfoRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+type CreateBackupRequest struct {
+	SnapshotId  string            `protobuf:"bytes,1,opt,name=snapshot_id,json=snapshotId" json:"snapshot_id,omitempty"`
+	VolumeId    string            `protobuf:"bytes,2,opt,name=volume_id,json=volumeId" json:"volume_id,omitempty"`
+	DestUrl     string            `protobuf:"bytes,3,opt,name=dest_url,json=destUrl" json:"dest_url,omitempty"`
+	EndpointUrl string            `protobuf:"bytes,4,opt,name=endpoint_url,json=endpointUrl" json:"endpoint_url,omitempty"`
+	Opts        map[string]string `protobuf:"bytes,5,rep,name=opts" json:"opts,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *CreateBackupRequest) Reset()                    { *m = CreateBackupRequest{} }
+func (m *CreateBackupRequest) String() string            { return proto1.CompactTextString(m) }
+func (*CreateBackupRequest) ProtoMessage()               {}
+func (*CreateBackupRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+func (m *CreateBackupRequest) GetSnapshotId() string {
+	if m != nil {
+		return m.SnapshotId
+	}
+	return ""
+}
+
+func (m *CreateBackupRequest) GetVolumeId() string {
+	if m != nil {
+		return m.VolumeId
+	}
+	return ""
+}
+
+func (m *CreateBackupRequest) GetDestUrl() string {
+	if m != nil {
+		return m.DestUrl
+	}
+	return ""
+}
+
+func (m *CreateBackupRequest) GetEndpointUrl() string {
+	if m != nil {
+		return m.EndpointUrl
+	}
+	return ""
+}
+
+func (m *CreateBackupRequest) GetOpts() map[string]string {
+	if m != nil {
+		return m.Opts
+	}
+	return nil
+}
+
+type CreateBackupResponse struct {
+	BackupUrl string `protobuf:"bytes,1,opt,name=backup_url,json=backupUrl" json:"backup_url,omitempty"`
+}
+
+func (m *CreateBackupResponse) Reset()                    { *m = CreateBackupResponse{} }
+func (m *CreateBackupResponse) String() string            { return proto1.CompactTextString(m) }
+func (*CreateBackupResponse) ProtoMessage()               {}
+func (*CreateBackupResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *CreateBackupResponse) GetBackupUrl() string {
+	if m != nil {
+		return m.BackupUrl
+	}
+	return ""
+}
+
+type DeleteBackupRequest struct {
+	BackupUrl   string            `protobuf:"bytes,1,opt,name=backup_url,json=backupUrl" json:"backup_url,omitempty"`
+	EndpointUrl string            `protobuf:"bytes,2,opt,name=endpoint_url,json=endpointUrl" json:"endpoint_url,omitempty"`
+	Opts        map[string]string `protobuf:"bytes,3,rep,name=opts" json:"opts,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *DeleteBackupRequest) Reset()                    { *m = DeleteBackupRequest{} }
+func (m *DeleteBackupRequest) String() string            { return proto1.CompactTextString(m) }
+func (*DeleteBackupRequest) ProtoMessage()               {}
+func (*DeleteBackupRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *DeleteBackupRequest) GetBackupUrl() string {
+	if m != nil {
+		return m.BackupUrl
+	}
+	return ""
+}
+
+func (m *DeleteBackupRequest) GetEndpointUrl() string {
+	if m != nil {
+		return m.EndpointUrl
+	}
+	return ""
+}
+
+func (m *DeleteBackupRequest) GetOpts() map[string]string {
+	if m != nil {
+		return m.Opts
+	}
+	return nil
+}
+
+type GetBackupInfoRequest struct {
+	BackupUrl   string `protobuf:"bytes,1,opt,name=backup_url,json=backupUrl" json:"backup_url,omitempty"`
+	EndpointUrl string `protobuf:"bytes,2,opt,name=endpoint_url,json=endpointUrl" json:"endpoint_url,omitempty"`
+}
+
+func (m *GetBackupInfoRequest) Reset()                    { *m = GetBackupInfoRequest{} }
+func (m *GetBackupInfoRequest) String() string            { return proto1.CompactTextString(m) }
+func (*GetBackupInfoRequest) ProtoMessage()               {}
+func (*GetBackupInfoRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+func (m *GetBackupInfoRequest) GetBackupUrl() string {
+	if m != nil {
+		return m.BackupUrl
+	}
+	return ""
+}
+
+func (m *GetBackupInfoRequest) GetEndpointUrl() string {
+	if m != nil {
+		return m.EndpointUrl
+	}
+	return ""
+}
+
+type ListBackupRequest struct {
+	DestUrl     string            `protobuf:"bytes,1,opt,name=dest_url,json=destUrl" json:"dest_url,omitempty"`
+	EndpointUrl string            `protobuf:"bytes,2,opt,name=endpoint_url,json=endpointUrl" json:"endpoint_url,omitempty"`
+	Opts        map[string]string `protobuf:"bytes,3,rep,name=opts" json:"opts,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *ListBackupRequest) Reset()                    { *m = ListBackupRequest{} }
+func (m *ListBackupRequest) String() string            { return proto1.CompactTextString(m) }
+func (*ListBackupRequest) ProtoMessage()               {}
+func (*ListBackupRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+func (m *ListBackupRequest) GetDestUrl() string {
+	if m != nil {
+		return m.DestUrl
+	}
+	return ""
+}
+
+func (m *ListBackupRequest) GetEndpointUrl() string {
+	if m != nil {
+		return m.EndpointUrl
+	}
+	return ""
+}
+
+func (m *ListBackupRequest) GetOpts() map[string]string {
+	if m != nil {
+		return m.Opts
+	}
+	return nil
+}
+
+func init() {
+	proto1.RegisterType((*Empty)(nil), "driverplugin.Empty")
+	proto1.RegisterType((*Request)(nil), "driverplugin.Request")
+	proto1.RegisterType((*InitRequest)(nil), "driverplugin.InitRequest")
+	proto1.RegisterType((*InitResponse)(nil), "driverplugin.InitResponse")
+	proto1.RegisterType((*InfoResponse)(nil), "driverplugin.InfoResponse")
+	proto1.RegisterType((*ListResponse)(nil), "driverplugin.ListResponse")
+	proto1.RegisterType((*ListRequest)(nil), "driverplugin.ListRequest")
+	proto1.RegisterType((*MountPointResponse)(nil), "driverplugin.MountPointResponse")
+	proto1.RegisterType((*VolumeInfoRequest)(nil), "driverplugin.VolumeInfoRequest")
+	proto1.RegisterType((*CreateBackupRequest)(nil), "driverplugin.CreateBackupRequest")
+	proto1.RegisterType((*CreateBackupResponse)(nil), "driverplugin.CreateBackupResponse")
+	proto1.RegisterType((*DeleteBackupRequest)(nil), "driverplugin.DeleteBackupRequest")
+	proto1.RegisterType((*GetBackupInfoRequest)(nil), "driverplugin.GetBackupInfoRequest")
+	proto1.RegisterType((*ListBackupRequest)(nil), "driverplugin.ListBackupRequest")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// Client API for ConvoyDriver service
+
+type ConvoyDriverClient interface {
+	Init(ctx context.Context, in *InitRequest, opts ...grpc.CallOption) (*InitResponse, error)
+	Info(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*InfoResponse, error)
+}
+
+type convoyDriverClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewConvoyDriverClient(cc *grpc.ClientConn) ConvoyDriverClient {
+	return &convoyDriverClient{cc}
+}
+
+func (c *convoyDriverClient) Init(ctx context.Context, in *InitRequest, opts ...grpc.CallOption) (*InitResponse, error) {
+	out := new(InitResponse)
+	err := grpc.Invoke(ctx, "/driverplugin.ConvoyDriver/Init", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *convoyDriverClient) Info(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*InfoResponse, error) {
+	out := new(InfoResponse)
+	err := grpc.Invoke(ctx, "/driverplugin.ConvoyDriver/Info", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for ConvoyDriver service
+
+type ConvoyDriverServer interface {
+	Init(context.Context, *InitRequest) (*InitResponse, error)
+	Info(context.Context, *Empty) (*InfoResponse, error)
+}
+
+func RegisterConvoyDriverServer(s *grpc.Server, srv ConvoyDriverServer) {
+	s.RegisterService(&_ConvoyDriver_serviceDesc, srv)
+}
+
+func _ConvoyDriver_Init_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConvoyDriverServer).Init(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/driverplugin.ConvoyDriver/Init",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConvoyDriverServer).Init(ctx, req.(*InitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ConvoyDriver_Info_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConvoyDriverServer).Info(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/driverplugin.ConvoyDriver/Info",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConvoyDriverServer).Info(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _ConvoyDriver_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "driverplugin.ConvoyDriver",
+	HandlerType: (*ConvoyDriverServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Init",
+			Handler:    _ConvoyDriver_Init_Handler,
+		},
+		{
+			MethodName: "Info",
+			Handler:    _ConvoyDriver_Info_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "plugin.proto",
+}
+
+// Client API for VolumeOperations service
+
+type VolumeOperationsClient interface {
+	CreateVolume(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Empty, error)
+	DeleteVolume(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Empty, error)
+	MountVolume(ctx context.Context, in *Request, opts ...grpc.CallOption) (*MountPointResponse, error)
+	UmountVolume(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Empty, error)
+	MountPoint(ctx context.Context, in *Request, opts ...grpc.CallOption) (*MountPointResponse, error)
+	GetVolumeInfo(ctx context.Context, in *VolumeInfoRequest, opts ...grpc.CallOption) (*InfoResponse, error)
+	ListVolume(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+}
+
+type volumeOperationsClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewVolumeOperationsClient(cc *grpc.ClientConn) VolumeOperationsClient {
+	return &volumeOperationsClient{cc}
+}
+
+func (c *volumeOperationsClient) CreateVolume(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := grpc.Invoke(ctx, "/driverplugin.VolumeOperations/CreateVolume", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *volumeOperationsClient) DeleteVolume(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := grpc.Invoke(ctx, "/driverplugin.VolumeOperations/DeleteVolume", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *volumeOperationsClient) MountVolume(ctx context.Context, in *Request, opts ...grpc.CallOption) (*MountPointResponse, error) {
+	out := new(MountPointResponse)
+	err := grpc.Invoke(ctx, "/driverplugin.VolumeOperations/MountVolume", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *volumeOperationsClient) UmountVolume(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := grpc.Invoke(ctx, "/driverplugin.VolumeOperations/UmountVolume", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *volumeOperationsClient) MountPoint(ctx context.Context, in *Request, opts ...grpc.CallOption) (*MountPointResponse, error) {
+	out := new(MountPointResponse)
+	err := grpc.Invoke(ctx, "/driverplugin.VolumeOperations/MountPoint", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *volumeOperationsClient) GetVolumeInfo(ctx context.Context, in *VolumeInfoRequest, opts ...grpc.CallOption) (*InfoResponse, error) {
+	out := new(InfoResponse)
+	err := grpc.Invoke(ctx, "/driverplugin.VolumeOperations/GetVolumeInfo", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *volumeOperationsClient) ListVolume(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	out := new(ListResponse)
+	err := grpc.Invoke(ctx, "/driverplugin.VolumeOperations/ListVolume", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for VolumeOperations service
+
+type VolumeOperationsServer interface {
+	CreateVolume(context.Context, *Request) (*Empty, error)
+	DeleteVolume(context.Context, *Request) (*Empty, error)
+	MountVolume(context.Context, *Request) (*MountPointResponse, error)
+	UmountVolume(context.Context, *Request) (*Empty, error)
+	MountPoint(context.Context, *Request) (*MountPointResponse, error)
+	GetVolumeInfo(context.Context, *VolumeInfoRequest) (*InfoResponse, error)
+	ListVolume(context.Context, *ListRequest) (*ListResponse, error)
+}
+
+func RegisterVolumeOperationsServer(s *grpc.Server, srv VolumeOperationsServer) {
+	s.RegisterService(&_VolumeOperations_serviceDesc, srv)
+}
+
+func _VolumeOperations_CreateVolume_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VolumeOperationsServer).CreateVolume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/driverplugin.VolumeOperations/CreateVolume",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VolumeOperationsServer).CreateVolume(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VolumeOperations_DeleteVolume_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VolumeOperationsServer).DeleteVolume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/driverplugin.VolumeOperations/DeleteVolume",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VolumeOperationsServer).DeleteVolume(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VolumeOperations_MountVolume_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VolumeOperationsServer).MountVolume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/driverplugin.VolumeOperations/MountVolume",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VolumeOperationsServer).MountVolume(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VolumeOperations_UmountVolume_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VolumeOperationsServer).UmountVolume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/driverplugin.VolumeOperations/UmountVolume",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VolumeOperationsServer).UmountVolume(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VolumeOperations_MountPoint_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VolumeOperationsServer).MountPoint(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/driverplugin.VolumeOperations/MountPoint",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VolumeOperationsServer).MountPoint(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VolumeOperations_GetVolumeInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VolumeInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VolumeOperationsServer).GetVolumeInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/driverplugin.VolumeOperations/GetVolumeInfo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VolumeOperationsServer).GetVolumeInfo(ctx, req.(*VolumeInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VolumeOperations_ListVolume_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VolumeOperationsServer).ListVolume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/driverplugin.VolumeOperations/ListVolume",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VolumeOperationsServer).ListVolume(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _VolumeOperations_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "driverplugin.VolumeOperations",
+	HandlerType: (*VolumeOperationsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateVolume",
+			Handler:    _VolumeOperations_CreateVolume_Handler,
+		},
+		{
+			MethodName: "DeleteVolume",
+			Handler:    _VolumeOperations_DeleteVolume_Handler,
+		},
+		{
+			MethodName: "MountVolume",
+			Handler:    _VolumeOperations_MountVolume_Handler,
+		},
+		{
+			MethodName: "UmountVolume",
+			Handler:    _VolumeOperations_UmountVolume_Handler,
+		},
+		{
+			MethodName: "MountPoint",
+			Handler:    _VolumeOperations_MountPoint_Handler,
+		},
+		{
+			MethodName: "GetVolumeInfo",
+			Handler:    _VolumeOperations_GetVolumeInfo_Handler,
+		},
+		{
+			MethodName: "ListVolume",
+			Handler:    _VolumeOperations_ListVolume_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "plugin.proto",
+}
+
+// Client API for SnapshotOperations service
+
+type SnapshotOperationsClient interface {
+	CreateSnapshot(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Empty, error)
+	DeleteSnapshot(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Empty, error)
+	GetSnapshotInfo(ctx context.Context, in *Request, opts ...grpc.CallOption) (*InfoResponse, error)
+	ListSnapshot(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+}
+
+type snapshotOperationsClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewSnapshotOperationsClient(cc *grpc.ClientConn) SnapshotOperationsClient {
+	return &snapshotOperationsClient{cc}
+}
+
+func (c *snapshotOperationsClient) CreateSnapshot(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := grpc.Invoke(ctx, "/driverplugin.SnapshotOperations/CreateSnapshot", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *snapshotOperationsClient) DeleteSnapshot(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := grpc.Invoke(ctx, "/driverplugin.SnapshotOperations/DeleteSnapshot", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *snapshotOperationsClient) GetSnapshotInfo(ctx context.Context, in *Request, opts ...grpc.CallOption) (*InfoResponse, error) {
+	out := new(InfoResponse)
+	err := grpc.Invoke(ctx, "/driverplugin.SnapshotOperations/GetSnapshotInfo", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *snapshotOperationsClient) ListSnapshot(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	out := new(ListResponse)
+	err := grpc.Invoke(ctx, "/driverplugin.SnapshotOperations/ListSnapshot", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for SnapshotOperations service
+
+type SnapshotOperationsServer interface {
+	CreateSnapshot(context.Context, *Request) (*Empty, error)
+	DeleteSnapshot(context.Context, *Request) (*Empty, error)
+	GetSnapshotInfo(context.Context, *Request) (*InfoResponse, error)
+	ListSnapshot(context.Context, *ListRequest) (*ListResponse, error)
+}
+
+func RegisterSnapshotOperationsServer(s *grpc.Server, srv SnapshotOperationsServer) {
+	s.RegisterService(&_SnapshotOperations_serviceDesc, srv)
+}
+
+func _SnapshotOperations_CreateSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SnapshotOperationsServer).CreateSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/driverplugin.SnapshotOperations/CreateSnapshot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SnapshotOperationsServer).CreateSnapshot(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SnapshotOperations_DeleteSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SnapshotOperationsServer).DeleteSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/driverplugin.SnapshotOperations/DeleteSnapshot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SnapshotOperationsServer).DeleteSnapshot(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SnapshotOperations_GetSnapshotInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SnapshotOperationsServer).GetSnapshotInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/driverplugin.SnapshotOperations/GetSnapshotInfo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SnapshotOperationsServer).GetSnapshotInfo(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SnapshotOperations_ListSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SnapshotOperationsServer).ListSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/driverplugin.SnapshotOperations/ListSnapshot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SnapshotOperationsServer).ListSnapshot(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _SnapshotOperations_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "driverplugin.SnapshotOperations",
+	HandlerType: (*SnapshotOperationsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateSnapshot",
+			Handler:    _SnapshotOperations_CreateSnapshot_Handler,
+		},
+		{
+			MethodName: "DeleteSnapshot",
+			Handler:    _SnapshotOperations_DeleteSnapshot_Handler,
+		},
+		{
+			MethodName: "GetSnapshotInfo",
+			Handler:    _SnapshotOperations_GetSnapshotInfo_Handler,
+		},
+		{
+			MethodName: "ListSnapshot",
+			Handler:    _SnapshotOperations_ListSnapshot_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "plugin.proto",
+}
+
+// Client API for BackupOperations service
+
+type BackupOperationsClient interface {
+	CreateBackup(ctx context.Context, in *CreateBackupRequest, opts ...grpc.CallOption) (*CreateBackupResponse, error)
+	DeleteBackup(ctx context.Context, in *DeleteBackupRequest, opts ...grpc.CallOption) (*Empty, error)
+	GetBackupInfo(ctx context.Context, in *GetBackupInfoRequest, opts ...grpc.CallOption) (*InfoResponse, error)
+	ListBackup(ctx context.Context, in *ListBackupRequest, opts ...grpc.CallOption) (*ListResponse, error)
+}
+
+type backupOperationsClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewBackupOperationsClient(cc *grpc.ClientConn) BackupOperationsClient {
+	return &backupOperationsClient{cc}
+}
+
+func (c *backupOperationsClient) CreateBackup(ctx context.Context, in *CreateBackupRequest, opts ...grpc.CallOption) (*CreateBackupResponse, error) {
+	out := new(CreateBackupResponse)
+	err := grpc.Invoke(ctx, "/driverplugin.BackupOperations/CreateBackup", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *backupOperationsClient) DeleteBackup(ctx context.Context, in *DeleteBackupRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := grpc.Invoke(ctx, "/driverplugin.BackupOperations/DeleteBackup", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *backupOperationsClient) GetBackupInfo(ctx context.Context, in *GetBackupInfoRequest, opts ...grpc.CallOption) (*InfoResponse, error) {
+	out := new(InfoResponse)
+	err := grpc.Invoke(ctx, "/driverplugin.BackupOperations/GetBackupInfo", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *backupOperationsClient) ListBackup(ctx context.Context, in *ListBackupRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	out := new(ListResponse)
+	err := grpc.Invoke(ctx, "/driverplugin.BackupOperations/ListBackup", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for BackupOperations service
+
+type BackupOperationsServer interface {
+	CreateBackup(context.Context, *CreateBackupRequest) (*CreateBackupResponse, error)
+	DeleteBackup(context.Context, *DeleteBackupRequest) (*Empty, error)
+	GetBackupInfo(context.Context, *GetBackupInfoRequest) (*InfoResponse, error)
+	ListBackup(context.Context, *ListBackupRequest) (*ListResponse, error)
+}
+
+func RegisterBackupOperationsServer(s *grpc.Server, srv BackupOperationsServer) {
+	s.RegisterService(&_BackupOperations_serviceDesc, srv)
+}
+
+func _BackupOperations_CreateBackup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateBackupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BackupOperationsServer).CreateBackup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/driverplugin.BackupOperations/CreateBackup",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BackupOperationsServer).CreateBackup(ctx, req.(*CreateBackupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BackupOperations_DeleteBackup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteBackupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BackupOperationsServer).DeleteBackup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/driverplugin.BackupOperations/DeleteBackup",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BackupOperationsServer).DeleteBackup(ctx, req.(*DeleteBackupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BackupOperations_GetBackupInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBackupInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BackupOperationsServer).GetBackupInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/driverplugin.BackupOperations/GetBackupInfo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BackupOperationsServer).GetBackupInfo(ctx, req.(*GetBackupInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BackupOperations_ListBackup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListBackupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BackupOperationsServer).ListBackup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/driverplugin.BackupOperations/ListBackup",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BackupOperationsServer).ListBackup(ctx, req.(*ListBackupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _BackupOperations_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "driverplugin.BackupOperations",
+	HandlerType: (*BackupOperationsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateBackup",
+			Handler:    _BackupOperations_CreateBackup_Handler,
+		},
+		{
+			MethodName: "DeleteBackup",
+			Handler:    _BackupOperations_DeleteBackup_Handler,
+		},
+		{
+			MethodName: "GetBackupInfo",
+			Handler:    _BackupOperations_GetBackupInfo_Handler,
+		},
+		{
+			MethodName: "ListBackup",
+			Handler:    _BackupOperations_ListBackup_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "plugin.proto",
+}
+
+func init() { proto1.RegisterFile("plugin.proto", fileDescriptor0) }
+
+var fileDescriptor0 = []byte{
+	// 871 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x57, 0x4d, 0x6f, 0xd3, 0x4a,
+	0x14, 0x95, 0xf3, 0xd1, 0x34, 0xd7, 0x7e, 0x7d, 0xe9, 0xb4, 0x4f, 0x4a, 0xfd, 0xf4, 0x94, 0xd6,
+	0xef, 0x3d, 0x11, 0x04, 0x8a, 0x50, 0x10, 0x6a, 0x09, 0xad, 0x90, 0x9a, 0xa6, 0x55, 0xa4, 0xa2,
+	0xa2, 0x42, 0x2a, 0xc4, 0x26, 0x4a, 0x9b, 0x49, 0xb1, 0x9a, 0x78, 0x8c, 0x3d, 0x89, 0x94, 0x15,
+	0x62, 0xc3, 0x86, 0x0d, 0x3b, 0x16, 0x88, 0x05, 0x3f, 0x87, 0x05, 0x0b, 0xfe, 0x05, 0x3f, 0x03,
+	0xcd, 0x87, 0x1d, 0xbb, 0x9d, 0xb8, 0x4a, 0x28, 0xab, 0xda, 0xf7, 0xde, 0x73, 0xe7, 0xf8, 0xcc,
+	0x99, 0x3b, 0x29, 0x18, 0x6e, 0x7f, 0x78, 0x6e, 0x3b, 0x15, 0xd7, 0x23, 0x94, 0x20, 0xa3, 0xeb,
+	0xd9, 0x23, 0xec, 0x89, 0x98, 0x95, 0x83, 0x6c, 0x63, 0xe0, 0xd2, 0xb1, 0xf5, 0x51, 0x83, 0xdc,
+	0x31, 0x7e, 0x3d, 0xc4, 0x3e, 0x45, 0x08, 0x32, 0x4e, 0x67, 0x80, 0x8b, 0xda, 0xba, 0x56, 0xce,
+	0x1f, 0xf3, 0x67, 0xb4, 0x0d, 0x39, 0xe2, 0x52, 0x9b, 0x38, 0x7e, 0x31, 0xb5, 0x9e, 0x2e, 0xeb,
+	0x55, 0xab, 0x12, 0x6d, 0x54, 0x91, 0xd8, 0xca, 0x91, 0x28, 0x6a, 0x38, 0xd4, 0x1b, 0x1f, 0x07,
+	0x10, 0xb3, 0x06, 0x46, 0x34, 0x81, 0x0a, 0x90, 0xbe, 0xc0, 0x63, 0xb9, 0x00, 0x7b, 0x44, 0xab,
+	0x90, 0x1d, 0x75, 0xfa, 0x43, 0x5c, 0x4c, 0xf1, 0x98, 0x78, 0xa9, 0xa5, 0xb6, 0x34, 0xeb, 0x93,
+	0x06, 0x7a, 0xd3, 0xb1, 0x69, 0x84, 0x9d, 0x47, 0x08, 0x0d, 0xd8, 0xb1, 0x67, 0xb4, 0x03, 0x0b,
+	0x67, 0xc4, 0xe9, 0xd9, 0xe7, 0x92, 0xdc, 0xff, 0x71, 0x72, 0x11, 0x78, 0xa5, 0xce, 0xeb, 0x04,
+	0x3f, 0x09, 0x32, 0x1f, 0x82, 0x1e, 0x09, 0xcf, 0xc4, 0xee, 0x83, 0x06, 0x86, 0x68, 0xef, 0xbb,
+	0xc4, 0xf1, 0x31, 0x2a, 0x43, 0x61, 0x44, 0xfa, 0xc3, 0x01, 0x6e, 0x13, 0xd7, 0x6f, 0x63, 0xcf,
+	0x23, 0x9e, 0xec, 0xb4, 0x24, 0xe2, 0x47, 0xae, 0xdf, 0x60, 0x51, 0x74, 0x17, 0x90, 0xef, 0x74,
+	0x5c, 0xff, 0x15, 0xa1, 0x91, 0x5a, 0xb1, 0x42, 0x21, 0xc8, 0x84, 0xd5, 0x65, 0x28, 0x9c, 0x76,
+	0xce, 0x2e, 0x86, 0x6e, 0xa4, 0x36, 0x2d, 0xfa, 0x8a, 0x78, 0x50, 0x69, 0xbd, 0xe5, 0x94, 0x7a,
+	0x24, 0xa4, 0xb4, 0x05, 0x19, 0xdb, 0xe9, 0x91, 0xa2, 0xc6, 0xb5, 0xf9, 0xef, 0xb2, 0x36, 0x93,
+	0x4a, 0xfe, 0x22, 0xa4, 0xe1, 0x08, 0x73, 0x13, 0xf2, 0x61, 0x68, 0x26, 0x59, 0xbe, 0x68, 0x60,
+	0x1c, 0xda, 0xfe, 0x44, 0x96, 0x47, 0x90, 0xb5, 0x29, 0x1e, 0xf8, 0x45, 0x4d, 0xb5, 0x41, 0xd1,
+	0xd2, 0x4a, 0x93, 0xd5, 0x09, 0x16, 0x02, 0x63, 0x3e, 0x07, 0x98, 0x04, 0x15, 0x3c, 0xee, 0x45,
+	0x79, 0xe8, 0x55, 0x73, 0xfa, 0x17, 0x46, 0x39, 0xbe, 0x01, 0x5d, 0xac, 0x2b, 0x7c, 0xb5, 0x09,
+	0x19, 0xe2, 0xd2, 0x80, 0xe0, 0xbf, 0x2a, 0x82, 0xa1, 0xc5, 0x25, 0x3d, 0x0e, 0x60, 0x22, 0x85,
+	0xa1, 0x99, 0x44, 0x7a, 0x00, 0xe8, 0x09, 0x19, 0x3a, 0xf4, 0x29, 0xb1, 0x9d, 0x89, 0x52, 0x25,
+	0xd0, 0x07, 0x2c, 0xda, 0x76, 0x59, 0x58, 0x76, 0x82, 0x41, 0x58, 0x68, 0xdd, 0x82, 0xe5, 0x13,
+	0xee, 0x24, 0xf1, 0x61, 0x53, 0xcf, 0xac, 0xf5, 0x3e, 0x05, 0x2b, 0x75, 0x0f, 0x77, 0x28, 0xde,
+	0xe5, 0x0e, 0x09, 0x6a, 0x4b, 0xa0, 0x87, 0xc6, 0xb3, 0xbb, 0xc1, 0x0a, 0x41, 0xa8, 0xd9, 0x45,
+	0x7f, 0x43, 0x5e, 0x7a, 0xd8, 0xee, 0x4a, 0xda, 0x8b, 0x22, 0xd0, 0xec, 0xa2, 0x35, 0x58, 0xec,
+	0x62, 0x9f, 0xb6, 0x87, 0x5e, 0x5f, 0x1a, 0x30, 0xc7, 0xde, 0x5b, 0x5e, 0x1f, 0x6d, 0x80, 0x81,
+	0x9d, 0x2e, 0xe7, 0xcd, 0xd3, 0x19, 0x9e, 0xd6, 0x83, 0x18, 0x2b, 0x79, 0x2c, 0x55, 0xce, 0x72,
+	0x95, 0xef, 0xc4, 0x55, 0x56, 0x90, 0xbd, 0x49, 0xb5, 0x57, 0xe3, 0xfd, 0xa5, 0xde, 0xff, 0x00,
+	0xc8, 0x83, 0xc5, 0x28, 0x8b, 0x56, 0x79, 0x11, 0x69, 0x79, 0x7d, 0xeb, 0xbb, 0x06, 0x2b, 0x7b,
+	0xb8, 0x8f, 0x2f, 0x8b, 0x98, 0x0c, 0xbb, 0x22, 0x45, 0x6a, 0xba, 0x14, 0x69, 0x95, 0x14, 0x8a,
+	0x25, 0x6f, 0x4e, 0x8a, 0x17, 0xb0, 0x7a, 0x80, 0xa9, 0x68, 0x1e, 0x35, 0xd1, 0x2f, 0x7f, 0x93,
+	0xf5, 0x55, 0x83, 0x65, 0x76, 0x56, 0xe2, 0x5a, 0x45, 0x2d, 0xa3, 0x25, 0x5b, 0x46, 0xa1, 0xd3,
+	0x4e, 0x4c, 0xa7, 0xdb, 0x57, 0x0f, 0xe6, 0xef, 0x51, 0xa9, 0xfa, 0x4e, 0x03, 0xa3, 0x4e, 0x9c,
+	0x11, 0x19, 0xef, 0xf1, 0x15, 0x19, 0x11, 0x36, 0xea, 0xd1, 0xda, 0xd4, 0xdb, 0xc5, 0x34, 0x55,
+	0x29, 0x69, 0xb4, 0x4d, 0x06, 0xef, 0x11, 0xb4, 0x12, 0xaf, 0xe1, 0xf7, 0xaf, 0x99, 0x30, 0xb3,
+	0xaa, 0x3f, 0xd2, 0x50, 0x38, 0x91, 0x77, 0x07, 0xf6, 0x3a, 0xfc, 0x1e, 0x45, 0x35, 0x30, 0x84,
+	0x9d, 0x45, 0x06, 0xfd, 0xa5, 0xbc, 0x8f, 0x4d, 0xd5, 0x62, 0x0c, 0x2b, 0xfc, 0x35, 0x07, 0x76,
+	0x1f, 0x74, 0x3e, 0xb4, 0x92, 0xa1, 0xeb, 0xf1, 0xb0, 0x62, 0xcc, 0xd5, 0xc0, 0x68, 0x0d, 0xae,
+	0x6f, 0xa4, 0xe4, 0xd0, 0x00, 0x98, 0x74, 0x9c, 0x9f, 0xc2, 0x21, 0xfc, 0x71, 0x80, 0xe9, 0x64,
+	0x96, 0xa2, 0x52, 0x1c, 0x72, 0x65, 0xca, 0x26, 0xed, 0x12, 0xaa, 0x03, 0x30, 0x33, 0xca, 0xcf,
+	0x59, 0x9b, 0x7a, 0x7f, 0x98, 0xa6, 0x2a, 0x25, 0xb7, 0xfa, 0x73, 0x0a, 0xd0, 0xb3, 0xf0, 0xea,
+	0x0f, 0x37, 0x7b, 0x1b, 0x96, 0xc4, 0x66, 0x07, 0xb9, 0x99, 0xe4, 0xda, 0x86, 0x25, 0xb1, 0xdd,
+	0x73, 0xa1, 0xf7, 0xe0, 0xcf, 0x03, 0x4c, 0x03, 0x28, 0xd7, 0x69, 0x0a, 0x3c, 0x49, 0x9d, 0x86,
+	0xf8, 0x3d, 0x10, 0x32, 0x98, 0x53, 0x9f, 0x6f, 0x29, 0x28, 0xec, 0xca, 0x9f, 0x3b, 0xa1, 0x3a,
+	0xad, 0xe0, 0x28, 0x88, 0x0c, 0xda, 0xb8, 0xf6, 0x56, 0x31, 0xad, 0xa4, 0x12, 0x49, 0x79, 0x3f,
+	0x38, 0x25, 0xea, 0xb6, 0x8a, 0x09, 0xad, 0x16, 0xf0, 0x88, 0xdb, 0x6c, 0x32, 0x6d, 0xd1, 0xa5,
+	0xc5, 0x55, 0xa3, 0x38, 0x51, 0xcb, 0xa6, 0x70, 0x9a, 0xa4, 0x55, 0xba, 0x66, 0x20, 0x26, 0xe9,
+	0xb9, 0x9b, 0x7b, 0x99, 0xe5, 0xff, 0x16, 0x9c, 0x2e, 0xf0, 0x3f, 0xf7, 0x7f, 0x0e, 0x00, 0x47,
+	0xf7, 0xd8, 0xba, 0x2d, 0x0c, 0x00, 0x00,
+}
@@ -0,0 +1,26 @@
+/*
+Package driverplugin allows Convoy Drivers to be shipped as standalone
+binaries, and loaded by the daemon without being built into Convoy.
+
+The daemon starts the plugin binary with MAGIC_COOKIE_KEY and
+PROTOCOL_VERSION_KEY set in the environment. The plugin listens on a unix
+socket, and prints one line of handshake "<version>|unix|<socket path>|grpc"
+to stdout. Then the daemon connects to the socket and calls the gRPC services
+defined in proto/plugin.proto, which mirror ConvoyDriver and the operations
+interfaces. The plugin should exit once its stdin is closed.
+
+Plugins written in Go can simply call Serve() with the InitFunc of the driver.
+*/
+package driverplugin
+
+const (
+	MAGIC_COOKIE_KEY   = "CONVOY_DRIVER_PLUGIN_MAGIC_COOKIE"
+	MAGIC_COOKIE_VALUE = "d29f3e6f0a4c4a7f8e5b1c2d3e4f5a6b"
+
+	PROTOCOL_VERSION_KEY = "CONVOY_DRIVER_PLUGIN_PROTOCOL_VERSION"
+	PROTOCOL_VERSION     = "2"
+
+	HANDSHAKE_SEPARATOR = "|"
+	// The RPC protocol in the handshake, which is the only one supported
+	HANDSHAKE_PROTOCOL = "grpc"
+)
@@ -0,0 +1,343 @@
+package driverplugin
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	. "github.com/rancher/convoy/convoydriver"
+	pb "github.com/rancher/convoy/driverplugin/proto"
+)
+
+const (
+	SOCKET_FILE = "plugin.sock"
+)
+
+// Plugin serves a Convoy Driver in the plugin through the gRPC services of
+// the protocol
+type Plugin struct {
+	initFunc InitFunc
+
+	mutex     *sync.RWMutex
+	driver    ConvoyDriver
+	volOps    VolumeOperations
+	snapOps   SnapshotOperations
+	backupOps BackupOperations
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func newInfoResponse(info map[string]string) *pb.InfoResponse {
+	return &pb.InfoResponse{Info: info}
+}
+
+func newListResponse(items map[string]map[string]string) *pb.ListResponse {
+	list := &pb.ListResponse{Items: map[string]*pb.InfoResponse{}}
+	for name, info := range items {
+		list.Items[name] = newInfoResponse(info)
+	}
+	return list
+}
+
+func newRequest(req *pb.Request) Request {
+	return Request{
+		Name:    req.Name,
+		Options: req.Options,
+	}
+}
+
+func (p *Plugin) Init(ctx context.Context, req *pb.InitRequest) (*pb.InitResponse, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.driver != nil {
+		return nil, fmt.Errorf("Driver plugin has already been initialized")
+	}
+	config := req.Config
+	if config == nil {
+		config = map[string]string{}
+	}
+	driver, err := p.initFunc(req.Root, config)
+	if err != nil {
+		return nil, err
+	}
+	p.driver = driver
+	resp := &pb.InitResponse{}
+	p.volOps, err = driver.VolumeOps()
+	resp.VolumeOpsError = errString(err)
+	p.snapOps, err = driver.SnapshotOps()
+	resp.SnapshotOpsError = errString(err)
+	p.backupOps, err = driver.BackupOps()
+	resp.BackupOpsError = errString(err)
+	return resp, nil
+}
+
+func (p *Plugin) getDriver() (ConvoyDriver, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if p.driver == nil {
+		return nil, fmt.Errorf("Driver plugin hasn't been initialized")
+	}
+	return p.driver, nil
+}
+
+func (p *Plugin) getVolumeOps() (VolumeOperations, error) {
+	if _, err := p.getDriver(); err != nil {
+		return nil, err
+	}
+	if p.volOps == nil {
+		return nil, fmt.Errorf("Driver plugin doesn't support volume operations")
+	}
+	return p.volOps, nil
+}
+
+func (p *Plugin) getSnapshotOps() (SnapshotOperations, error) {
+	if _, err := p.getDriver(); err != nil {
+		return nil, err
+	}
+	if p.snapOps == nil {
+		return nil, fmt.Errorf("Driver plugin doesn't support snapshot operations")
+	}
+	return p.snapOps, nil
+}
+
+func (p *Plugin) getBackupOps() (BackupOperations, error) {
+	if _, err := p.getDriver(); err != nil {
+		return nil, err
+	}
+	if p.backupOps == nil {
+		return nil, fmt.Errorf("Driver plugin doesn't support backup operations")
+	}
+	return p.backupOps, nil
+}
+
+func (p *Plugin) Info(ctx context.Context, req *pb.Empty) (*pb.InfoResponse, error) {
+	driver, err := p.getDriver()
+	if err != nil {
+		return nil, err
+	}
+	info, err := driver.Info()
+	if err != nil {
+		return nil, err
+	}
+	return newInfoResponse(info), nil
+}
+
+func (p *Plugin) CreateVolume(ctx context.Context, req *pb.Request) (*pb.Empty, error) {
+	ops, err := p.getVolumeOps()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Empty{}, ops.CreateVolume(newRequest(req))
+}
+
+func (p *Plugin) DeleteVolume(ctx context.Context, req *pb.Request) (*pb.Empty, error) {
+	ops, err := p.getVolumeOps()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Empty{}, ops.DeleteVolume(newRequest(req))
+}
+
+func (p *Plugin) MountVolume(ctx context.Context, req *pb.Request) (*pb.MountPointResponse, error) {
+	ops, err := p.getVolumeOps()
+	if err != nil {
+		return nil, err
+	}
+	mountPoint, err := ops.MountVolume(newRequest(req))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.MountPointResponse{MountPoint: mountPoint}, nil
+}
+
+func (p *Plugin) UmountVolume(ctx context.Context, req *pb.Request) (*pb.Empty, error) {
+	ops, err := p.getVolumeOps()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Empty{}, ops.UmountVolume(newRequest(req))
+}
+
+func (p *Plugin) MountPoint(ctx context.Context, req *pb.Request) (*pb.MountPointResponse, error) {
+	ops, err := p.getVolumeOps()
+	if err != nil {
+		return nil, err
+	}
+	mountPoint, err := ops.MountPoint(newRequest(req))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.MountPointResponse{MountPoint: mountPoint}, nil
+}
+
+func (p *Plugin) GetVolumeInfo(ctx context.Context, req *pb.VolumeInfoRequest) (*pb.InfoResponse, error) {
+	ops, err := p.getVolumeOps()
+	if err != nil {
+		return nil, err
+	}
+	info, err := ops.GetVolumeInfo(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return newInfoResponse(info), nil
+}
+
+func (p *Plugin) ListVolume(ctx context.Context, req *pb.ListRequest) (*pb.ListResponse, error) {
+	ops, err := p.getVolumeOps()
+	if err != nil {
+		return nil, err
+	}
+	volumes, err := ops.ListVolume(req.Opts)
+	if err != nil {
+		return nil, err
+	}
+	return newListResponse(volumes), nil
+}
+
+func (p *Plugin) CreateSnapshot(ctx context.Context, req *pb.Request) (*pb.Empty, error) {
+	ops, err := p.getSnapshotOps()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Empty{}, ops.CreateSnapshot(newRequest(req))
+}
+
+func (p *Plugin) DeleteSnapshot(ctx context.Context, req *pb.Request) (*pb.Empty, error) {
+	ops, err := p.getSnapshotOps()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Empty{}, ops.DeleteSnapshot(newRequest(req))
+}
+
+func (p *Plugin) GetSnapshotInfo(ctx context.Context, req *pb.Request) (*pb.InfoResponse, error) {
+	ops, err := p.getSnapshotOps()
+	if err != nil {
+		return nil, err
+	}
+	info, err := ops.GetSnapshotInfo(newRequest(req))
+	if err != nil {
+		return nil, err
+	}
+	return newInfoResponse(info), nil
+}
+
+func (p *Plugin) ListSnapshot(ctx context.Context, req *pb.ListRequest) (*pb.ListResponse, error) {
+	ops, err := p.getSnapshotOps()
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := ops.ListSnapshot(req.Opts)
+	if err != nil {
+		return nil, err
+	}
+	return newListResponse(snapshots), nil
+}
+
+func (p *Plugin) CreateBackup(ctx context.Context, req *pb.CreateBackupRequest) (*pb.CreateBackupResponse, error) {
+	ops, err := p.getBackupOps()
+	if err != nil {
+		return nil, err
+	}
+	backupURL, err := ops.CreateBackup(req.SnapshotId, req.VolumeId, req.DestUrl, req.EndpointUrl, req.Opts)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.CreateBackupResponse{BackupUrl: backupURL}, nil
+}
+
+func (p *Plugin) DeleteBackup(ctx context.Context, req *pb.DeleteBackupRequest) (*pb.Empty, error) {
+	ops, err := p.getBackupOps()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Empty{}, ops.DeleteBackup(req.BackupUrl, req.EndpointUrl, req.Opts)
+}
+
+func (p *Plugin) GetBackupInfo(ctx context.Context, req *pb.GetBackupInfoRequest) (*pb.InfoResponse, error) {
+	ops, err := p.getBackupOps()
+	if err != nil {
+		return nil, err
+	}
+	info, err := ops.GetBackupInfo(req.BackupUrl, req.EndpointUrl)
+	if err != nil {
+		return nil, err
+	}
+	return newInfoResponse(info), nil
+}
+
+func (p *Plugin) ListBackup(ctx context.Context, req *pb.ListBackupRequest) (*pb.ListResponse, error) {
+	ops, err := p.getBackupOps()
+	if err != nil {
+		return nil, err
+	}
+	backups, err := ops.ListBackup(req.DestUrl, req.EndpointUrl, req.Opts)
+	if err != nil {
+		return nil, err
+	}
+	return newListResponse(backups), nil
+}
+
+/*
+Serve runs the driver as a plugin, which should be called by the main() of
+the plugin binary. It returns after the daemon has gone.
+*/
+func Serve(initFunc InitFunc) error {
+	if os.Getenv(MAGIC_COOKIE_KEY) != MAGIC_COOKIE_VALUE {
+		return fmt.Errorf("This binary is a Convoy driver plugin, which should be started by Convoy daemon with --driver-plugins")
+	}
+	if version := os.Getenv(PROTOCOL_VERSION_KEY); version != PROTOCOL_VERSION {
+		return fmt.Errorf("Unsupported driver plugin protocol version %v, the plugin supports version %v", version, PROTOCOL_VERSION)
+	}
+
+	dir, err := ioutil.TempDir("", "convoy-plugin")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, SOCKET_FILE)
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	plugin := &Plugin{
+		initFunc: initFunc,
+		mutex:    &sync.RWMutex{},
+	}
+	server := grpc.NewServer()
+	pb.RegisterConvoyDriverServer(server, plugin)
+	pb.RegisterVolumeOperationsServer(server, plugin)
+	pb.RegisterSnapshotOperationsServer(server, plugin)
+	pb.RegisterBackupOperationsServer(server, plugin)
+
+	// The daemon holds the other end of stdin, stop serving once it's gone
+	go func() {
+		io.Copy(ioutil.Discard, os.Stdin)
+		server.Stop()
+	}()
+
+	handshake := strings.Join([]string{PROTOCOL_VERSION, "unix", socket, HANDSHAKE_PROTOCOL}, HANDSHAKE_SEPARATOR)
+	if _, err := fmt.Fprintln(os.Stdout, handshake); err != nil {
+		return err
+	}
+	// Serve returns once the server has been stopped
+	server.Serve(l)
+	return nil
+}
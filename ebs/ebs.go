@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/objectstore"
 	"github.com/rancher/convoy/util"
 
 	. "github.com/rancher/convoy/convoydriver"
@@ -68,6 +69,7 @@ type Snapshot struct {
 	Name       string
 	VolumeName string
 	EBSID      string
+	SnapshotMetadata
 }
 
 type Volume struct {
@@ -283,11 +285,23 @@ func (d *Driver) getTypeAndIOPS(opts map[string]string) (string, int64, error) {
 	return volumeType, iops, nil
 }
 
+// isObjectStoreBackup checks if the backup is in an objectstore rather than an
+// EBS snapshot, e.g. the backup of a devicemapper volume
+func isObjectStoreBackup(backupURL, endpointURL string) bool {
+	_, err := objectstore.GetObjectStoreDriver(backupURL, endpointURL)
+	return err == nil
+}
+
 func (d *Driver) CreateVolume(req Request) error {
 	var (
 		err        error
 		volumeSize int64
 		format     bool
+		// The delta block backup of another driver in objectstore
+		objVolume *objectstore.Volume
+		// The size of the EBS snapshot restored, the filesystem would be
+		// grown if the volume is larger
+		snapshotVolumeSize int64
 	)
 
 	d.mutex.Lock()
@@ -308,6 +322,7 @@ func (d *Driver) CreateVolume(req Request) error {
 	//EBS volume ID
 	volumeID := opts[OPT_VOLUME_DRIVER_ID]
 	backupURL := opts[OPT_BACKUP_URL]
+	endpointURL := opts[OPT_ENDPOINT_URL]
 	if backupURL != "" && volumeID != "" {
 		return fmt.Errorf("Cannot specify both backup and EBS volume ID")
 	}
@@ -325,6 +340,37 @@ func (d *Driver) CreateVolume(req Request) error {
 		if err := d.ebsService.AddTags(volumeID, newTags); err != nil {
 			log.Debugf("Failed to update tags for volume %v, but continue", volumeID)
 		}
+	} else if backupURL != "" && isObjectStoreBackup(backupURL, endpointURL) {
+		objVolume, err = objectstore.LoadVolume(backupURL, endpointURL)
+		if err != nil {
+			return err
+		}
+		if err := objectstore.CheckRestoreDriver(objVolume, backupURL, endpointURL, d.Name()); err != nil {
+			return err
+		}
+		volumeSize, err = d.getSize(opts, objVolume.Size)
+		if err != nil {
+			return err
+		}
+		if volumeSize < objVolume.Size {
+			return fmt.Errorf("Volume size cannot be less than backup size %v", objVolume.Size)
+		}
+		volumeType, iops, err := d.getTypeAndIOPS(opts)
+		if err != nil {
+			return err
+		}
+		r := &CreateEBSVolumeRequest{
+			Size:       volumeSize,
+			VolumeType: volumeType,
+			IOPS:       iops,
+			Tags:       newTags,
+			KmsKeyID:   d.DefaultKmsKeyID,
+		}
+		volumeID, err = d.ebsService.CreateVolume(r)
+		if err != nil {
+			return err
+		}
+		log.Debugf("Created volume %s from EBS volume %v for restoring backup %v", id, volumeID, backupURL)
 	} else if backupURL != "" {
 		region, ebsSnapshotID, err := decodeURL(backupURL)
 		if err != nil {
@@ -345,7 +391,7 @@ func (d *Driver) CreateVolume(req Request) error {
 			return err
 		}
 
-		snapshotVolumeSize := *ebsSnapshot.VolumeSize * GB
+		snapshotVolumeSize = *ebsSnapshot.VolumeSize * GB
 		volumeSize, err = d.getSize(opts, snapshotVolumeSize)
 		if err != nil {
 			return err
@@ -392,7 +438,8 @@ func (d *Driver) CreateVolume(req Request) error {
 			return err
 		}
 		log.Debugf("Created volume %s from EBS volume %v", id, volumeID)
-		format = true
+		// The device would be left raw if it's used by a layered driver
+		format = opts[OPT_FORMAT] != "false"
 	}
 
 	dev, err := d.ebsService.AttachVolume(volumeID, volumeSize)
@@ -401,6 +448,17 @@ func (d *Driver) CreateVolume(req Request) error {
 	}
 	log.Debugf("Attached EBS volume %v to %v", volumeID, dev)
 
+	if objVolume != nil {
+		if err := objectstore.RestoreDeltaBlockBackupToSize(backupURL, endpointURL, dev, volumeSize); err != nil {
+			return err
+		}
+	} else if snapshotVolumeSize != 0 && volumeSize > snapshotVolumeSize {
+		log.Debugf("Growing filesystem on %v from %v to %v", dev, snapshotVolumeSize, volumeSize)
+		if err := util.GrowFilesystem(dev); err != nil {
+			return err
+		}
+	}
+
 	volume.Name = id
 	volume.EBSID = volumeID
 	volume.Device = dev
@@ -449,6 +507,21 @@ func (d *Driver) DeleteVolume(req Request) error {
 	return util.ObjectDelete(volume)
 }
 
+// AttachVolume returns the device of the volume. EBS volume is attached to
+// the instance since it's created, until it's deleted
+func (d *Driver) AttachVolume(req Request) (string, error) {
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	return volume.GetDevice()
+}
+
+func (d *Driver) DetachVolume(req Request) error {
+	volume := d.blankVolume(req.Name)
+	return util.ObjectLoad(volume)
+}
+
 func (d *Driver) MountVolume(req Request) (string, error) {
 	id := req.Name
 	opts := req.Options
@@ -633,9 +706,10 @@ func (d *Driver) CreateSnapshot(req Request) error {
 	log.Debugf("Creating snapshot %v(%v) of volume %v(%v)", id, ebsSnapshotID, volumeID, volume.EBSID)
 
 	snapshot = Snapshot{
-		Name:       id,
-		VolumeName: volumeID,
-		EBSID:      ebsSnapshotID,
+		Name:             id,
+		VolumeName:       volumeID,
+		EBSID:            ebsSnapshotID,
+		SnapshotMetadata: NewSnapshotMetadata(req.Options),
 	}
 	volume.Snapshots[id] = snapshot
 	return util.ObjectSave(volume)
@@ -692,6 +766,8 @@ func (d *Driver) getSnapshotInfo(id, volumeID string) (map[string]string, error)
 	if !removed {
 		info = map[string]string{
 			OPT_SNAPSHOT_NAME:         snapshot.Name,
+			OPT_SNAPSHOT_LABELS:       snapshot.Labels,
+			OPT_SNAPSHOT_DESCRIPTION:  snapshot.Description,
 			"VolumeName":              volumeID,
 			"EBSSnapshotID":           aws.StringValue(ebsSnapshot.SnapshotId),
 			"EBSVolumeID":             aws.StringValue(ebsSnapshot.VolumeId),
@@ -702,9 +778,11 @@ func (d *Driver) getSnapshotInfo(id, volumeID string) (map[string]string, error)
 		}
 	} else {
 		info = map[string]string{
-			OPT_SNAPSHOT_NAME: snapshot.Name,
-			"VolumeName":      volumeID,
-			"State":           "removed",
+			OPT_SNAPSHOT_NAME:        snapshot.Name,
+			OPT_SNAPSHOT_LABELS:      snapshot.Labels,
+			OPT_SNAPSHOT_DESCRIPTION: snapshot.Description,
+			"VolumeName":             volumeID,
+			"State":                  "removed",
 		}
 	}
 
@@ -793,7 +871,7 @@ func (d *Driver) CreateBackup(snapshotID, volumeID, destURL, endpointURL string,
 	return encodeURL(d.ebsService.Region, snapshot.EBSID), nil
 }
 
-func (d *Driver) DeleteBackup(backupURL, endpointURL string) error {
+func (d *Driver) DeleteBackup(backupURL, endpointURL string, opts map[string]string) error {
 	// Would remove the snapshot
 	region, ebsSnapshotID, err := decodeURL(backupURL)
 	if err != nil {
@@ -0,0 +1,228 @@
+package ftp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/objectstore"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "ftp"})
+)
+
+type FTPObjectStoreDriver struct {
+	kind    string
+	destURL string
+	path    string
+	service FTPService
+}
+
+const (
+	KIND_FTP  = "ftp"
+	KIND_FTPS = "ftps"
+
+	ENV_PASSWORD             = "FTP_PASSWORD"
+	ENV_MAX_CONNECTIONS      = "FTP_MAX_CONNECTIONS"
+	ENV_CA_CERT              = "FTPS_CA_CERT"
+	ENV_INSECURE_SKIP_VERIFY = "FTPS_INSECURE_SKIP_VERIFY"
+
+	DEFAULT_PORT            = "21"
+	DEFAULT_USER            = "anonymous"
+	DEFAULT_MAX_CONNECTIONS = 4
+
+	MAX_CLEANUP_LEVEL = 10
+)
+
+func init() {
+	for _, kind := range []string{KIND_FTP, KIND_FTPS} {
+		if err := objectstore.RegisterDriver(kind, initFunc); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func initFunc(destURL, endpoint string) (objectstore.ObjectStoreDriver, error) {
+	return initFuncWithConnectionCheck(destURL, endpoint, func(d objectstore.ObjectStoreDriver) error {
+		_, err := d.List("")
+		return err
+	})
+}
+
+func initFuncWithConnectionCheck(destURL, endpoint string, connectionTest func(d objectstore.ObjectStoreDriver) error) (objectstore.ObjectStoreDriver, error) {
+	b := &FTPObjectStoreDriver{}
+
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != KIND_FTP && u.Scheme != KIND_FTPS {
+		return nil, fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, KIND_FTP)
+	}
+	b.kind = u.Scheme
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("Invalid URL. Must be %v://[user@]host[:port]/path/", b.kind)
+	}
+	b.service.Address = u.Host
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		b.service.Address = net.JoinHostPort(u.Host, DEFAULT_PORT)
+	}
+	b.service.User = DEFAULT_USER
+	if u.User != nil {
+		b.service.User = u.User.Username()
+	}
+	b.service.Password = os.Getenv(ENV_PASSWORD)
+
+	b.service.MaxConnections = DEFAULT_MAX_CONNECTIONS
+	if value := os.Getenv(ENV_MAX_CONNECTIONS); value != "" {
+		max, err := strconv.Atoi(value)
+		if err != nil || max <= 0 {
+			return nil, fmt.Errorf("Invalid %v %v, must be a positive number", ENV_MAX_CONNECTIONS, value)
+		}
+		b.service.MaxConnections = max
+	}
+
+	if b.kind == KIND_FTPS {
+		if b.service.TLSConfig, err = getTLSConfig(u.Host); err != nil {
+			return nil, err
+		}
+	}
+
+	b.path = filepath.Clean("/" + u.Path)
+
+	if err := connectionTest(b); err != nil {
+		return nil, fmt.Errorf("FTP path %v doesn't exist or is not accessible on %v: %v",
+			b.path, u.Host, err)
+	}
+
+	b.destURL = b.kind + "://" + u.Host + b.path
+	if u.User != nil {
+		b.destURL = b.kind + "://" + b.service.User + "@" + u.Host + b.path
+	}
+	log.Debugf("Loaded driver for %v", b.destURL)
+	return b, nil
+}
+
+func getTLSConfig(host string) (*tls.Config, error) {
+	serverName := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		serverName = h
+	}
+	config := &tls.Config{
+		ServerName: serverName,
+		// The data connections need to resume the session of control
+		// connection for most servers
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+	if caFile := os.Getenv(ENV_CA_CERT); caFile != "" {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("Cannot find certificate in %v", caFile)
+		}
+	}
+	if value := os.Getenv(ENV_INSECURE_SKIP_VERIFY); value != "" {
+		skip, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %v %v", ENV_INSECURE_SKIP_VERIFY, value)
+		}
+		config.InsecureSkipVerify = skip
+	}
+	return config, nil
+}
+
+func (s *FTPObjectStoreDriver) Kind() string {
+	return s.kind
+}
+
+func (s *FTPObjectStoreDriver) GetURL() string {
+	return s.destURL
+}
+
+func (s *FTPObjectStoreDriver) updatePath(path string) string {
+	return filepath.Join(s.path, path)
+}
+
+func (s *FTPObjectStoreDriver) List(listPath string) ([]string, error) {
+	return s.service.ListDir(s.updatePath(listPath))
+}
+
+func (s *FTPObjectStoreDriver) FileExists(filePath string) bool {
+	return s.FileSize(filePath) >= 0
+}
+
+func (s *FTPObjectStoreDriver) FileSize(filePath string) int64 {
+	size, err := s.service.FileSize(s.updatePath(filePath))
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+func (s *FTPObjectStoreDriver) Remove(names ...string) error {
+	for _, name := range names {
+		//Also automatically cleanup upper level directories
+		parents := []string{}
+		dir := s.updatePath(name)
+		for i := 0; i < MAX_CLEANUP_LEVEL; i++ {
+			dir = filepath.Dir(dir)
+			// Don't clean above OBJECTSTORE_BASE
+			if strings.HasSuffix(dir, objectstore.OBJECTSTORE_BASE) || dir == s.path {
+				break
+			}
+			parents = append(parents, dir)
+		}
+		if err := s.service.RemoveAll(s.updatePath(name), parents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FTPObjectStoreDriver) Read(src string) (io.ReadCloser, error) {
+	buf, err := s.service.ReadFile(s.updatePath(src))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(buf), nil
+}
+
+func (s *FTPObjectStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	return s.service.WriteFile(s.updatePath(dst), rs)
+}
+
+func (s *FTPObjectStoreDriver) Upload(src, dst string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return s.service.WriteFile(s.updatePath(dst), file)
+}
+
+func (s *FTPObjectStoreDriver) Download(src, dst string) error {
+	if _, err := os.Stat(dst); err != nil {
+		os.Remove(dst)
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.service.DownloadFile(s.updatePath(src), f)
+}
@@ -0,0 +1,267 @@
+package ftp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DIAL_TIMEOUT = 30 * time.Second
+)
+
+// ftpConn is a control connection to the FTP server. Only passive mode is
+// supported, since active mode rarely works across NAT or firewalls.
+type ftpConn struct {
+	host      string
+	raw       net.Conn
+	conn      *textproto.Conn
+	tlsConfig *tls.Config
+}
+
+func dial(addr, user, password string, tlsConfig *tls.Config) (*ftpConn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := net.DialTimeout("tcp", addr, DIAL_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	c := &ftpConn{
+		host: host,
+		raw:  raw,
+		conn: textproto.NewConn(raw),
+	}
+	if _, _, err := c.conn.ReadResponse(220); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	// Explicit FTPS, upgrade the control connection before login
+	if tlsConfig != nil {
+		if _, err := c.cmd(234, "AUTH TLS"); err != nil {
+			c.Close()
+			return nil, err
+		}
+		tlsConn := tls.Client(raw, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.raw = tlsConn
+		c.conn = textproto.NewConn(tlsConn)
+		c.tlsConfig = tlsConfig
+	}
+
+	code, err := c.cmd(0, "USER %s", user)
+	if err == nil && code == 331 {
+		code, err = c.cmd(230, "PASS %s", password)
+	}
+	if err == nil && code/100 != 2 {
+		err = fmt.Errorf("Unexpected response %v to USER", code)
+	}
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("Failed to login to FTP server %v as %v: %v", addr, user, err)
+	}
+
+	if tlsConfig != nil {
+		if _, err := c.cmd(200, "PBSZ 0"); err != nil {
+			c.Close()
+			return nil, err
+		}
+		if _, err := c.cmd(200, "PROT P"); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if _, err := c.cmd(200, "TYPE I"); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *ftpConn) Close() error {
+	c.conn.PrintfLine("QUIT")
+	return c.conn.Close()
+}
+
+// cmd sends the command and reads the response. Any code would be accepted
+// if expectCode is 0.
+func (c *ftpConn) cmd(expectCode int, format string, args ...interface{}) (int, error) {
+	code, _, err := c.cmdWithMessage(expectCode, format, args...)
+	return code, err
+}
+
+func (c *ftpConn) cmdWithMessage(expectCode int, format string, args ...interface{}) (int, string, error) {
+	if err := c.conn.PrintfLine(format, args...); err != nil {
+		return 0, "", err
+	}
+	code, msg, err := c.conn.ReadResponse(expectCode)
+	if _, ok := err.(*textproto.Error); ok && expectCode == 0 {
+		err = nil
+	}
+	return code, msg, err
+}
+
+// openDataConn uses EPSV, and falls back to PASV. The host in the PASV
+// response is ignored, since it's often a private address behind NAT.
+func (c *ftpConn) openDataConn() (net.Conn, error) {
+	port := 0
+	code, msg, err := c.cmdWithMessage(0, "EPSV")
+	if err != nil {
+		return nil, err
+	}
+	if code == 229 {
+		start := strings.Index(msg, "(|||")
+		end := strings.Index(msg, "|)")
+		if start < 0 || end < start {
+			return nil, fmt.Errorf("Invalid EPSV response: %v", msg)
+		}
+		if port, err = strconv.Atoi(msg[start+4 : end]); err != nil {
+			return nil, fmt.Errorf("Invalid EPSV response: %v", msg)
+		}
+	} else {
+		_, msg, err := c.cmdWithMessage(227, "PASV")
+		if err != nil {
+			return nil, err
+		}
+		start := strings.Index(msg, "(")
+		end := strings.LastIndex(msg, ")")
+		if start < 0 || end < start {
+			return nil, fmt.Errorf("Invalid PASV response: %v", msg)
+		}
+		fields := strings.Split(msg[start+1:end], ",")
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("Invalid PASV response: %v", msg)
+		}
+		p1, err1 := strconv.Atoi(fields[4])
+		p2, err2 := strconv.Atoi(fields[5])
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("Invalid PASV response: %v", msg)
+		}
+		port = p1*256 + p2
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(c.host, strconv.Itoa(port)), DIAL_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	if c.tlsConfig != nil {
+		return tls.Client(conn, c.tlsConfig), nil
+	}
+	return conn, nil
+}
+
+// transfer runs the command with a data connection, fn would be called to
+// read or write the data
+func (c *ftpConn) transfer(fn func(conn net.Conn) error, format string, args ...interface{}) error {
+	dataConn, err := c.openDataConn()
+	if err != nil {
+		return err
+	}
+	if _, err := c.cmd(1, format, args...); err != nil {
+		dataConn.Close()
+		return err
+	}
+	err = fn(dataConn)
+	if closeErr := dataConn.Close(); err == nil {
+		err = closeErr
+	}
+	// Read the reply for the completion of transfer
+	if _, _, respErr := c.conn.ReadResponse(2); err == nil {
+		err = respErr
+	}
+	return err
+}
+
+func (c *ftpConn) NameList(dir string) ([]string, error) {
+	buf := &bytes.Buffer{}
+	if err := c.transfer(func(conn net.Conn) error {
+		_, err := io.Copy(buf, conn)
+		return err
+	}, "NLST %s", dir); err != nil {
+		return nil, err
+	}
+	result := []string{}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		// Some servers return the full paths
+		result = append(result, path.Base(line))
+	}
+	return result, nil
+}
+
+func (c *ftpConn) Size(file string) (int64, error) {
+	_, msg, err := c.cmdWithMessage(213, "SIZE %s", file)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(msg), 10, 64)
+}
+
+func (c *ftpConn) Retrieve(file string, w io.Writer) error {
+	return c.transfer(func(conn net.Conn) error {
+		_, err := io.Copy(w, conn)
+		return err
+	}, "RETR %s", file)
+}
+
+func (c *ftpConn) Store(file string, r io.Reader) error {
+	return c.transfer(func(conn net.Conn) error {
+		_, err := io.Copy(conn, r)
+		return err
+	}, "STOR %s", file)
+}
+
+func (c *ftpConn) Rename(from, to string) error {
+	if _, err := c.cmd(350, "RNFR %s", from); err != nil {
+		return err
+	}
+	_, err := c.cmd(250, "RNTO %s", to)
+	return err
+}
+
+func (c *ftpConn) Delete(file string) error {
+	_, err := c.cmd(250, "DELE %s", file)
+	return err
+}
+
+func (c *ftpConn) RemoveDir(dir string) error {
+	_, err := c.cmd(250, "RMD %s", dir)
+	return err
+}
+
+// MakeDirAll creates the directory and its parents, errors are ignored
+// since they may exist already
+func (c *ftpConn) MakeDirAll(dir string) error {
+	parts := strings.Split(strings.Trim(dir, "/"), "/")
+	current := ""
+	if strings.HasPrefix(dir, "/") {
+		current = "/"
+	}
+	for _, p := range parts {
+		current = path.Join(current, p)
+		if _, err := c.cmd(0, "MKD %s", current); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *ftpConn) Noop() error {
+	_, err := c.cmd(200, "NOOP")
+	return err
+}
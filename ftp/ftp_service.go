@@ -0,0 +1,221 @@
+package ftp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/textproto"
+	"path"
+	"sync"
+)
+
+type FTPService struct {
+	Address        string
+	User           string
+	Password       string
+	TLSConfig      *tls.Config
+	MaxConnections int
+}
+
+// connPool limits the concurrent connections to one FTP server across all the
+// drivers in the process, since the appliances often refuse the connections
+// above a small limit per user
+type connPool struct {
+	slots chan struct{}
+	mutex sync.Mutex
+	idle  []*ftpConn
+}
+
+var (
+	poolsMutex sync.Mutex
+	pools      = map[string]*connPool{}
+)
+
+func (s *FTPService) pool() *connPool {
+	key := fmt.Sprintf("%v:%v@%v,tls=%v", s.User, s.Password, s.Address, s.TLSConfig != nil)
+
+	poolsMutex.Lock()
+	defer poolsMutex.Unlock()
+	p, exists := pools[key]
+	if !exists {
+		size := s.MaxConnections
+		if size <= 0 {
+			size = DEFAULT_MAX_CONNECTIONS
+		}
+		p = &connPool{
+			slots: make(chan struct{}, size),
+		}
+		pools[key] = p
+	}
+	return p
+}
+
+func (p *connPool) get(s *FTPService) (*ftpConn, error) {
+	for {
+		p.mutex.Lock()
+		if len(p.idle) == 0 {
+			p.mutex.Unlock()
+			break
+		}
+		c := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mutex.Unlock()
+
+		// The server may have closed the idle connection
+		if err := c.Noop(); err == nil {
+			return c, nil
+		}
+		c.Close()
+	}
+	return dial(s.Address, s.User, s.Password, s.TLSConfig)
+}
+
+func (p *connPool) put(c *ftpConn) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.idle = append(p.idle, c)
+}
+
+// do runs fn with a connection from the pool. The connection would be reused
+// unless the error is not a FTP reply, e.g. network failure.
+func (s *FTPService) do(fn func(c *ftpConn) error) error {
+	p := s.pool()
+	p.slots <- struct{}{}
+	defer func() {
+		<-p.slots
+	}()
+
+	c, err := p.get(s)
+	if err != nil {
+		return err
+	}
+	err = fn(c)
+	if _, ok := err.(*textproto.Error); err == nil || ok {
+		p.put(c)
+	} else {
+		c.Close()
+	}
+	return err
+}
+
+func isNotFound(err error) bool {
+	protoErr, ok := err.(*textproto.Error)
+	return ok && protoErr.Code == 550
+}
+
+func (s *FTPService) ListDir(dir string) ([]string, error) {
+	var names []string
+	err := s.do(func(c *ftpConn) error {
+		var err error
+		names, err = c.NameList(dir)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := []string{}
+	for _, name := range names {
+		if name != "." && name != ".." {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+func (s *FTPService) FileSize(file string) (int64, error) {
+	var size int64
+	err := s.do(func(c *ftpConn) error {
+		var err error
+		size, err = c.Size(file)
+		return err
+	})
+	return size, err
+}
+
+// ReadFile buffers the whole file, so the connection can be returned to the
+// pool before the caller consumes it
+func (s *FTPService) ReadFile(file string) (*bytes.Buffer, error) {
+	buf := &bytes.Buffer{}
+	if err := s.DownloadFile(file, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (s *FTPService) DownloadFile(file string, w io.Writer) error {
+	return s.do(func(c *ftpConn) error {
+		return c.Retrieve(file, w)
+	})
+}
+
+// WriteFile stores the file to a temporary name first then renames it, so a
+// partial upload would never be taken as the file
+func (s *FTPService) WriteFile(file string, r io.Reader) error {
+	tmpFile := file + ".tmp"
+	return s.do(func(c *ftpConn) error {
+		if err := c.MakeDirAll(path.Dir(file)); err != nil {
+			return err
+		}
+		if err := c.Store(tmpFile, r); err != nil {
+			c.Delete(tmpFile)
+			return err
+		}
+		if err := c.Rename(tmpFile, file); err != nil {
+			// Some servers refuse to overwrite with rename
+			if err := c.Delete(file); err != nil && !isNotFound(err) {
+				return err
+			}
+			return c.Rename(tmpFile, file)
+		}
+		return nil
+	})
+}
+
+// RemoveAll removes the file or directory recursively, then removes the
+// parents if they're empty
+func (s *FTPService) RemoveAll(file string, parents []string) error {
+	return s.do(func(c *ftpConn) error {
+		if err := removeAll(c, file); err != nil {
+			return err
+		}
+		for _, dir := range parents {
+			if err := c.RemoveDir(dir); err != nil {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func removeAll(c *ftpConn, file string) error {
+	err := c.Delete(file)
+	if err == nil || !isNotFound(err) {
+		return err
+	}
+	// Either a directory or doesn't exist
+	names, listErr := c.NameList(file)
+	if listErr != nil {
+		if isNotFound(listErr) {
+			return nil
+		}
+		return listErr
+	}
+	for _, name := range names {
+		if name == "." || name == ".." {
+			continue
+		}
+		if name == path.Base(file) && len(names) == 1 {
+			// NLST of a file returns the file itself, so DELE failed for
+			// another reason
+			return err
+		}
+		if err := removeAll(c, path.Join(file, name)); err != nil {
+			return err
+		}
+	}
+	if err := c.RemoveDir(file); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,394 @@
+package ftp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rancher/convoy/objectstore"
+	"gopkg.in/check.v1"
+)
+
+func TestFTP(t *testing.T) { check.TestingT(t) }
+
+// fakeServer is an in-memory FTP server supporting the passive mode commands
+// used by the driver
+type fakeServer struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	files    map[string][]byte
+	dirs     map[string]bool
+	sessions int
+	active   int
+	peak     int
+	noEPSV   bool
+}
+
+func newFakeServer(c *check.C) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	s := &fakeServer{
+		listener: l,
+		files:    map[string][]byte{},
+		dirs:     map[string]bool{"/": true},
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) Close() {
+	s.listener.Close()
+}
+
+func (s *fakeServer) children(dir string) []string {
+	names := []string{}
+	for _, m := range []map[string]bool{s.dirs, s.fileSet()} {
+		for p := range m {
+			if p != "/" && path.Dir(p) == dir {
+				names = append(names, path.Base(p))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *fakeServer) fileSet() map[string]bool {
+	set := map[string]bool{}
+	for p := range s.files {
+		set[p] = true
+	}
+	return set
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	s.mutex.Lock()
+	s.sessions++
+	s.active++
+	if s.active > s.peak {
+		s.peak = s.active
+	}
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		s.active--
+		s.mutex.Unlock()
+	}()
+
+	var dataListener net.Listener
+	renameFrom := ""
+	reply("220 fake ftp")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd, arg := line, ""
+		if i := strings.Index(line, " "); i >= 0 {
+			cmd, arg = line[:i], line[i+1:]
+		}
+
+		openData := func() net.Conn {
+			if dataListener == nil {
+				reply("425 use PASV first")
+				return nil
+			}
+			reply("150 opening data connection")
+			dataConn, err := dataListener.Accept()
+			dataListener.Close()
+			dataListener = nil
+			if err != nil {
+				return nil
+			}
+			return dataConn
+		}
+
+		s.mutex.Lock()
+		_, isFile := s.files[arg]
+		isDir := s.dirs[arg]
+		s.mutex.Unlock()
+
+		switch cmd {
+		case "USER":
+			reply("331 password please")
+		case "PASS":
+			if arg != "secret" {
+				reply("530 login incorrect")
+				continue
+			}
+			reply("230 logged in")
+		case "TYPE", "NOOP":
+			reply("200 ok")
+		case "EPSV", "PASV":
+			if cmd == "EPSV" && s.noEPSV {
+				reply("500 unknown command")
+				continue
+			}
+			dataListener, _ = net.Listen("tcp", "127.0.0.1:0")
+			port := dataListener.Addr().(*net.TCPAddr).Port
+			if cmd == "EPSV" {
+				reply("229 Entering Extended Passive Mode (|||%d|)", port)
+			} else {
+				reply("227 Entering Passive Mode (10,0,0,1,%d,%d)", port/256, port%256)
+			}
+		case "NLST":
+			if !isDir {
+				reply("550 no such directory")
+				continue
+			}
+			dataConn := openData()
+			s.mutex.Lock()
+			for _, name := range s.children(arg) {
+				fmt.Fprintf(dataConn, "%v\r\n", path.Join(arg, name))
+			}
+			s.mutex.Unlock()
+			dataConn.Close()
+			reply("226 done")
+		case "SIZE":
+			if !isFile {
+				reply("550 no such file")
+				continue
+			}
+			s.mutex.Lock()
+			reply("213 %d", len(s.files[arg]))
+			s.mutex.Unlock()
+		case "RETR":
+			if !isFile {
+				reply("550 no such file")
+				continue
+			}
+			dataConn := openData()
+			s.mutex.Lock()
+			data := s.files[arg]
+			s.mutex.Unlock()
+			dataConn.Write(data)
+			dataConn.Close()
+			reply("226 done")
+		case "STOR":
+			s.mutex.Lock()
+			parentExists := s.dirs[path.Dir(arg)]
+			s.mutex.Unlock()
+			if !parentExists {
+				reply("553 no parent directory")
+				continue
+			}
+			dataConn := openData()
+			data, _ := ioutil.ReadAll(dataConn)
+			dataConn.Close()
+			s.mutex.Lock()
+			s.files[arg] = data
+			s.mutex.Unlock()
+			reply("226 done")
+		case "MKD":
+			if isDir || isFile {
+				reply("550 exists")
+				continue
+			}
+			s.mutex.Lock()
+			s.dirs[arg] = true
+			s.mutex.Unlock()
+			reply("257 created")
+		case "DELE":
+			if !isFile {
+				reply("550 not a file")
+				continue
+			}
+			s.mutex.Lock()
+			delete(s.files, arg)
+			s.mutex.Unlock()
+			reply("250 deleted")
+		case "RMD":
+			s.mutex.Lock()
+			empty := len(s.children(arg)) == 0
+			s.mutex.Unlock()
+			if !isDir || !empty {
+				reply("550 cannot remove")
+				continue
+			}
+			s.mutex.Lock()
+			delete(s.dirs, arg)
+			s.mutex.Unlock()
+			reply("250 removed")
+		case "RNFR":
+			if !isFile {
+				reply("550 no such file")
+				continue
+			}
+			renameFrom = arg
+			reply("350 ready")
+		case "RNTO":
+			s.mutex.Lock()
+			s.files[arg] = s.files[renameFrom]
+			delete(s.files, renameFrom)
+			s.mutex.Unlock()
+			reply("250 renamed")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+type FTPTestSuite struct {
+	server *fakeServer
+}
+
+var _ = check.Suite(&FTPTestSuite{})
+
+func (s *FTPTestSuite) SetUpTest(c *check.C) {
+	s.server = newFakeServer(c)
+	s.server.dirs["/backups"] = true
+
+	os.Setenv(ENV_PASSWORD, "secret")
+	os.Unsetenv(ENV_MAX_CONNECTIONS)
+}
+
+func (s *FTPTestSuite) TearDownTest(c *check.C) {
+	s.server.Close()
+}
+
+func (s *FTPTestSuite) url() string {
+	return "ftp://user@" + s.server.listener.Addr().String() + "/backups"
+}
+
+func (s *FTPTestSuite) TestInitFunc(c *check.C) {
+	driver, err := initFunc(s.url(), "")
+	c.Assert(err, check.IsNil)
+	c.Check(driver.Kind(), check.Equals, KIND_FTP)
+	c.Check(driver.GetURL(), check.Equals, s.url())
+
+	d := driver.(*FTPObjectStoreDriver)
+	c.Check(d.service.User, check.Equals, "user")
+	c.Check(d.service.MaxConnections, check.Equals, DEFAULT_MAX_CONNECTIONS)
+	c.Check(d.service.TLSConfig, check.IsNil)
+
+	_, err = initFunc("ftp://user@"+s.server.listener.Addr().String()+"/nonexist", "")
+	c.Check(err, check.ErrorMatches, "FTP path /nonexist doesn't exist.*")
+
+	os.Setenv(ENV_PASSWORD, "wrong")
+	_, err = initFunc(s.url(), "")
+	c.Check(err, check.ErrorMatches, ".*Failed to login.*")
+}
+
+func (s *FTPTestSuite) TestInitFuncBadConfig(c *check.C) {
+	noCheck := func(d objectstore.ObjectStoreDriver) error {
+		return nil
+	}
+
+	_, err := initFuncWithConnectionCheck("ftp:///path", "", noCheck)
+	c.Check(err, check.NotNil)
+
+	os.Setenv(ENV_MAX_CONNECTIONS, "0")
+	_, err = initFuncWithConnectionCheck("ftp://host/path", "", noCheck)
+	c.Check(err, check.NotNil)
+
+	os.Setenv(ENV_MAX_CONNECTIONS, "8")
+	driver, err := initFuncWithConnectionCheck("ftps://host/path", "", noCheck)
+	c.Assert(err, check.IsNil)
+	d := driver.(*FTPObjectStoreDriver)
+	c.Check(d.Kind(), check.Equals, KIND_FTPS)
+	c.Check(d.service.Address, check.Equals, "host:21")
+	c.Check(d.service.User, check.Equals, DEFAULT_USER)
+	c.Check(d.service.MaxConnections, check.Equals, 8)
+	c.Assert(d.service.TLSConfig, check.NotNil)
+	c.Check(d.service.TLSConfig.ServerName, check.Equals, "host")
+}
+
+func (s *FTPTestSuite) TestReadWriteRemove(c *check.C) {
+	driver, err := initFunc(s.url(), "")
+	c.Assert(err, check.IsNil)
+
+	filePath := "convoy-objectstore/volumes/vol/backups/backup_1.cfg"
+	c.Assert(driver.Write(filePath, bytes.NewReader([]byte("data"))), check.IsNil)
+	c.Check(driver.FileSize(filePath), check.Equals, int64(4))
+	c.Check(driver.FileExists(filePath+".tmp"), check.Equals, false)
+
+	// Overwrite
+	c.Assert(driver.Write(filePath, bytes.NewReader([]byte("new data"))), check.IsNil)
+	rc, err := driver.Read(filePath)
+	c.Assert(err, check.IsNil)
+	data, err := ioutil.ReadAll(rc)
+	c.Assert(err, check.IsNil)
+	c.Check(string(data), check.Equals, "new data")
+
+	names, err := driver.List("convoy-objectstore/volumes/vol/backups")
+	c.Assert(err, check.IsNil)
+	c.Check(names, check.DeepEquals, []string{"backup_1.cfg"})
+
+	_, err = driver.List("convoy-objectstore/volumes/nonexist")
+	c.Check(err, check.NotNil)
+	c.Check(driver.FileExists("convoy-objectstore/volumes/vol/backups"), check.Equals, false)
+
+	c.Assert(driver.Remove("convoy-objectstore/volumes/vol"), check.IsNil)
+	c.Check(driver.FileExists(filePath), check.Equals, false)
+	// Empty parents are removed as well
+	names, err = driver.List("convoy-objectstore")
+	c.Assert(err, check.IsNil)
+	c.Check(names, check.HasLen, 0)
+
+	// Removing nonexistent path is fine
+	c.Assert(driver.Remove("convoy-objectstore/volumes/vol"), check.IsNil)
+}
+
+func (s *FTPTestSuite) TestPASVFallback(c *check.C) {
+	s.server.noEPSV = true
+	driver, err := initFunc(s.url(), "")
+	c.Assert(err, check.IsNil)
+
+	c.Assert(driver.Write("file", bytes.NewReader([]byte("data"))), check.IsNil)
+	names, err := driver.List("")
+	c.Assert(err, check.IsNil)
+	c.Check(names, check.DeepEquals, []string{"file"})
+}
+
+func (s *FTPTestSuite) TestConcurrency(c *check.C) {
+	os.Setenv(ENV_MAX_CONNECTIONS, "2")
+	driver, err := initFunc(s.url(), "")
+	c.Assert(err, check.IsNil)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := driver.Write(fmt.Sprintf("blocks/%v.blk", i), bytes.NewReader([]byte("block")))
+			c.Check(err, check.IsNil)
+		}(i)
+	}
+	wg.Wait()
+
+	names, err := driver.List("blocks")
+	c.Assert(err, check.IsNil)
+	c.Check(names, check.HasLen, 8)
+
+	s.server.mutex.Lock()
+	defer s.server.mutex.Unlock()
+	c.Check(s.server.peak <= 2, check.Equals, true)
+	// Connections are reused
+	c.Check(s.server.sessions <= 2, check.Equals, true)
+}
@@ -0,0 +1,808 @@
+package gce
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	DRIVER_NAME        = "gce"
+	DRIVER_CONFIG_FILE = "gce.cfg"
+
+	VOLUME_CFG_PREFIX = "volume_"
+	CFG_PREFIX        = DRIVER_NAME + "_"
+	CFG_POSTFIX       = ".json"
+
+	GCE_DEFAULT_VOLUME_SIZE = "gce.defaultvolumesize"
+	GCE_DEFAULT_VOLUME_TYPE = "gce.defaultvolumetype"
+	GCE_FSFREEZE            = "gce.fsfreeze"
+
+	DEFAULT_VOLUME_SIZE = "10G"
+	DEFAULT_VOLUME_TYPE = "pd-standard"
+	DEFAULT_FSFREEZE    = "false"
+
+	LABEL_VOLUME   = "convoy-volume"
+	LABEL_SNAPSHOT = "convoy-snapshot"
+
+	MOUNTS_DIR = "mounts"
+
+	// GCE resource names are limited to 63 characters
+	MAX_NAME_LENGTH = 63
+)
+
+var (
+	validName = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+)
+
+type Driver struct {
+	mutex      *sync.RWMutex
+	gceService *gceService
+	Device
+}
+
+type Device struct {
+	Root              string
+	DefaultVolumeSize int64
+	DefaultVolumeType string
+	FsFreeze          bool
+}
+
+func (dev *Device) ConfigFile() (string, error) {
+	if dev.Root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty device config path")
+	}
+	return filepath.Join(dev.Root, DRIVER_CONFIG_FILE), nil
+}
+
+type VolumeSnapshot struct {
+	Name       string
+	VolumeName string
+	GCEName    string
+	SnapshotMetadata
+}
+
+type Volume struct {
+	Name       string
+	DiskName   string
+	Device     string
+	MountPoint string
+	Snapshots  map[string]VolumeSnapshot
+
+	configPath string
+}
+
+func (d *Driver) blankVolume(name string) *Volume {
+	return &Volume{
+		configPath: d.Root,
+		Name:       name,
+	}
+}
+
+func (v *Volume) ConfigFile() (string, error) {
+	if v.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if v.configPath == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume config path")
+	}
+	return filepath.Join(v.configPath, CFG_PREFIX+VOLUME_CFG_PREFIX+v.Name+CFG_POSTFIX), nil
+}
+
+func (v *Volume) GetDevice() (string, error) {
+	return v.Device, nil
+}
+
+func (v *Volume) GetMountOpts() []string {
+	return []string{}
+}
+
+func (v *Volume) GenerateDefaultMountPoint() string {
+	return filepath.Join(v.configPath, MOUNTS_DIR, v.Name)
+}
+
+func init() {
+	if err := Register(DRIVER_NAME, Init); err != nil {
+		panic(err)
+	}
+}
+
+func generateError(fields logrus.Fields, format string, v ...interface{}) error {
+	return ErrorWithFields("gce", fields, format, v...)
+}
+
+func checkVolumeType(volumeType string) error {
+	validVolumeType := map[string]bool{
+		"pd-standard": true,
+		"pd-balanced": true,
+		"pd-ssd":      true,
+	}
+	if !validVolumeType[volumeType] {
+		return fmt.Errorf("Invalid volume type %v", volumeType)
+	}
+	return nil
+}
+
+// labelValue converts the name to a valid GCE label value, which only allows
+// lowercase letters, digits, underscores and dashes
+func labelValue(name string) string {
+	value := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, name)
+	if len(value) > MAX_NAME_LENGTH {
+		value = value[:MAX_NAME_LENGTH]
+	}
+	return value
+}
+
+// generateName generates an unique GCE resource name from the Convoy names,
+// since Convoy names may contain the characters not allowed by GCE
+func generateName(names ...string) string {
+	suffix := "-" + util.NewUUID()[:8]
+	name := "convoy"
+	for _, n := range names {
+		name += "-" + strings.Replace(labelValue(n), "_", "-", -1)
+	}
+	if len(name)+len(suffix) > MAX_NAME_LENGTH {
+		name = name[:MAX_NAME_LENGTH-len(suffix)]
+	}
+	return strings.TrimRight(name, "-") + suffix
+}
+
+func (d *Driver) remountVolumes() error {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return err
+	}
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return err
+		}
+		if volume.MountPoint == "" {
+			continue
+		}
+		req := Request{
+			Name:    id,
+			Options: map[string]string{},
+		}
+		if _, err := d.MountVolume(req); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func Init(root string, config map[string]string) (ConvoyDriver, error) {
+	gceService, err := NewGCEService()
+	if err != nil {
+		return nil, err
+	}
+	dev := &Device{
+		Root: root,
+	}
+	exists, err := util.ObjectExists(dev)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if err := util.ObjectLoad(dev); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := util.MkdirIfNotExists(root); err != nil {
+			return nil, err
+		}
+
+		if config[GCE_DEFAULT_VOLUME_SIZE] == "" {
+			config[GCE_DEFAULT_VOLUME_SIZE] = DEFAULT_VOLUME_SIZE
+		}
+		size, err := util.ParseSize(config[GCE_DEFAULT_VOLUME_SIZE])
+		if err != nil {
+			return nil, err
+		}
+		if config[GCE_DEFAULT_VOLUME_TYPE] == "" {
+			config[GCE_DEFAULT_VOLUME_TYPE] = DEFAULT_VOLUME_TYPE
+		}
+		volumeType := config[GCE_DEFAULT_VOLUME_TYPE]
+		if err := checkVolumeType(volumeType); err != nil {
+			return nil, err
+		}
+		if config[GCE_FSFREEZE] == "" {
+			config[GCE_FSFREEZE] = DEFAULT_FSFREEZE
+		}
+		fsFreeze, err := strconv.ParseBool(config[GCE_FSFREEZE])
+		if err != nil {
+			return nil, err
+		}
+
+		dev = &Device{
+			Root:              root,
+			DefaultVolumeSize: size,
+			DefaultVolumeType: volumeType,
+			FsFreeze:          fsFreeze,
+		}
+		if err := util.ObjectSave(dev); err != nil {
+			return nil, err
+		}
+	}
+	d := &Driver{
+		mutex:      &sync.RWMutex{},
+		gceService: gceService,
+		Device:     *dev,
+	}
+	if err := d.remountVolumes(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Driver) Name() string {
+	return DRIVER_NAME
+}
+
+func (d *Driver) Info() (map[string]string, error) {
+	return map[string]string{
+		"DefaultVolumeSize": strconv.FormatInt(d.DefaultVolumeSize, 10),
+		"DefaultVolumeType": d.DefaultVolumeType,
+		"FsFreeze":          strconv.FormatBool(d.FsFreeze),
+		"Project":           d.gceService.Project,
+		"Zone":              d.gceService.Zone,
+		"Instance":          d.gceService.Instance,
+	}, nil
+}
+
+func (d *Driver) VolumeOps() (VolumeOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) getSize(opts map[string]string, defaultVolumeSize int64) (int64, error) {
+	size := opts[OPT_SIZE]
+	if size == "" || size == "0" {
+		size = strconv.FormatInt(defaultVolumeSize, 10)
+	}
+	return util.ParseSize(size)
+}
+
+func (d *Driver) getType(opts map[string]string) (string, error) {
+	volumeType := opts[OPT_VOLUME_TYPE]
+	if volumeType == "" {
+		volumeType = d.DefaultVolumeType
+	}
+	if err := checkVolumeType(volumeType); err != nil {
+		return "", err
+	}
+	return volumeType, nil
+}
+
+func (d *Driver) CreateVolume(req Request) error {
+	var (
+		err    error
+		format bool
+	)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	opts := req.Options
+
+	volume := d.blankVolume(id)
+	exists, err := util.ObjectExists(volume)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Volume %v already exists", id)
+	}
+
+	// GCE disk name
+	diskName := opts[OPT_VOLUME_DRIVER_ID]
+	backupURL := opts[OPT_BACKUP_URL]
+	if backupURL != "" && diskName != "" {
+		return fmt.Errorf("Cannot specify both backup and GCE disk name")
+	}
+
+	labels := map[string]string{
+		LABEL_VOLUME: labelValue(id),
+	}
+	if diskName != "" {
+		if _, err := d.gceService.GetDisk(diskName); err != nil {
+			return err
+		}
+		log.Debugf("Found GCE disk %v for volume %v, update labels", diskName, id)
+		if err := d.gceService.SetDiskLabels(diskName, labels); err != nil {
+			log.Debugf("Failed to update labels for disk %v, but continue", diskName)
+		}
+	} else {
+		volumeType, err := d.getType(opts)
+		if err != nil {
+			return err
+		}
+		r := &CreateDiskRequest{
+			Name:     generateName(id),
+			DiskType: volumeType,
+			Labels:   labels,
+		}
+		if backupURL != "" {
+			project, snapshotName, err := decodeURL(backupURL)
+			if err != nil {
+				return err
+			}
+			snapshot, err := d.gceService.GetSnapshotWithProject(project, snapshotName)
+			if err != nil {
+				return err
+			}
+			if snapshot.Status != "READY" {
+				return fmt.Errorf("Snapshot %v is not ready, status %v", snapshotName, snapshot.Status)
+			}
+			snapshotVolumeSize := snapshot.DiskSizeGb * GB
+			if r.Size, err = d.getSize(opts, snapshotVolumeSize); err != nil {
+				return err
+			}
+			if r.Size < snapshotVolumeSize {
+				return fmt.Errorf("Volume size cannot be less than snapshot size %v", snapshotVolumeSize)
+			}
+			r.SnapshotProject = project
+			r.SnapshotID = snapshotName
+		} else {
+			if r.Size, err = d.getSize(opts, d.DefaultVolumeSize); err != nil {
+				return err
+			}
+			format = true
+		}
+		if err := d.gceService.CreateDisk(r); err != nil {
+			return err
+		}
+		diskName = r.Name
+		log.Debugf("Created GCE disk %v for volume %v", diskName, id)
+	}
+
+	dev, err := d.gceService.AttachDisk(diskName)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Attached GCE disk %v to %v", diskName, dev)
+
+	volume.Name = id
+	volume.DiskName = diskName
+	volume.Device = dev
+	volume.Snapshots = make(map[string]VolumeSnapshot)
+
+	// We don't format existing or snapshot restored volume
+	if format {
+		if _, err := util.Execute("mkfs", []string{"-t", "ext4", dev}); err != nil {
+			return err
+		}
+	}
+
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) DeleteVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	opts := req.Options
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	referenceOnly, _ := strconv.ParseBool(opts[OPT_REFERENCE_ONLY])
+	if err := d.gceService.DetachDisk(volume.DiskName); err != nil {
+		if !referenceOnly {
+			return err
+		}
+		//Ignore the error, remove the reference
+		log.Warnf("Unable to detached %v(%v) due to %v, but continue with removing the reference",
+			id, volume.DiskName, err)
+	} else {
+		log.Debugf("Detached %v(%v) from %v", id, volume.DiskName, volume.Device)
+	}
+
+	if !referenceOnly {
+		if err := d.gceService.DeleteDisk(volume.DiskName); err != nil {
+			return err
+		}
+		log.Debugf("Deleted %v(%v)", id, volume.DiskName)
+	}
+	return util.ObjectDelete(volume)
+}
+
+func (d *Driver) MountVolume(req Request) (string, error) {
+	id := req.Name
+	opts := req.Options
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+
+	mountPoint, err := util.VolumeMount(volume, opts[OPT_MOUNT_POINT], false)
+	if err != nil {
+		return "", err
+	}
+
+	if err := util.ObjectSave(volume); err != nil {
+		return "", err
+	}
+
+	return mountPoint, nil
+}
+
+func (d *Driver) UmountVolume(req Request) error {
+	id := req.Name
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	if err := util.VolumeUmount(volume); err != nil {
+		return err
+	}
+
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) MountPoint(req Request) (string, error) {
+	id := req.Name
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	return volume.MountPoint, nil
+}
+
+func (d *Driver) GetVolumeInfo(id string) (map[string]string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+
+	disk, err := d.gceService.GetDisk(volume.DiskName)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"Device":                volume.Device,
+		"MountPoint":            volume.MountPoint,
+		"GCEDiskName":           volume.DiskName,
+		"Zone":                  d.gceService.Zone,
+		OPT_VOLUME_NAME:         id,
+		OPT_VOLUME_CREATED_TIME: disk.CreationTime,
+		"Size":                  strconv.FormatInt(disk.SizeGb*GB, 10),
+		"State":                 disk.Status,
+		"Type":                  filepath.Base(disk.Type),
+		"SourceSnapshot":        disk.SourceSnapshot,
+	}, nil
+}
+
+func (d *Driver) listVolumeNames() ([]string, error) {
+	return util.ListConfigIDs(d.Root, CFG_PREFIX+VOLUME_CFG_PREFIX, CFG_POSTFIX)
+}
+
+func (d *Driver) ListVolume(opts map[string]string) (map[string]map[string]string, error) {
+	volumes := make(map[string]map[string]string)
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, uuid := range volumeIDs {
+		volumes[uuid], err = d.GetVolumeInfo(uuid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return volumes, nil
+}
+
+func (d *Driver) SnapshotOps() (SnapshotOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) getSnapshotAndVolume(snapshotID, volumeID string) (*VolumeSnapshot, *Volume, error) {
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, nil, err
+	}
+	snap, exists := volume.Snapshots[snapshotID]
+	if !exists {
+		return nil, nil, generateError(logrus.Fields{
+			LOG_FIELD_VOLUME:   volumeID,
+			LOG_FIELD_SNAPSHOT: snapshotID,
+		}, "cannot find snapshot of volume")
+	}
+	return &snap, volume, nil
+}
+
+func (d *Driver) CreateSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if _, exists := volume.Snapshots[id]; exists {
+		return generateError(logrus.Fields{
+			LOG_FIELD_VOLUME:   volumeID,
+			LOG_FIELD_SNAPSHOT: id,
+		}, "Already has snapshot with uuid")
+	}
+
+	if volume.MountPoint != "" {
+		log.Debugf("syncing filesystems...")
+		if err := util.Sync(); err != nil {
+			return err
+		}
+
+		if d.FsFreeze {
+			log.Debugf("freezing %v", volume.MountPoint)
+			if err := util.Freeze(volume.MountPoint); err != nil {
+				return err
+			}
+			defer func() {
+				log.Debugf("unfreezing %v", volume.MountPoint)
+				if err := util.UnFreeze(volume.MountPoint); err != nil {
+					log.Errorf("Failed to unfreeze %v: %v", volume.MountPoint, err)
+				}
+			}()
+		}
+	}
+
+	request := &CreateSnapshotRequest{
+		DiskName:    volume.DiskName,
+		Name:        generateName(volumeID, id),
+		Description: "Convoy snapshot",
+		Labels: map[string]string{
+			LABEL_VOLUME:   labelValue(volumeID),
+			LABEL_SNAPSHOT: labelValue(id),
+		},
+	}
+	// The operation is done once the snapshot is taken, though the upload
+	// may be still in progress
+	if err := d.gceService.CreateSnapshot(request); err != nil {
+		return err
+	}
+	log.Debugf("Created snapshot %v(%v) of volume %v(%v)", id, request.Name, volumeID, volume.DiskName)
+
+	volume.Snapshots[id] = VolumeSnapshot{
+		Name:             id,
+		VolumeName:       volumeID,
+		GCEName:          request.Name,
+		SnapshotMetadata: NewSnapshotMetadata(req.Options),
+	}
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) DeleteSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return err
+	}
+
+	// The GCE snapshot is the backup as well, so it would only be removed
+	// by DeleteBackup
+	log.Debugf("Removing reference of snapshot %v(%v) of volume %v(%v)", id, snapshot.GCEName, volumeID, volume.DiskName)
+	delete(volume.Snapshots, id)
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) GetSnapshotInfo(req Request) (map[string]string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.getSnapshotInfo(id, volumeID)
+}
+
+func (d *Driver) getSnapshotInfo(id, volumeID string) (map[string]string, error) {
+	snapshot, _, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Snapshot on GCE can be removed by DeleteBackup
+	gceSnapshot, err := d.gceService.GetSnapshot(snapshot.GCEName)
+	if err != nil {
+		if !IsNotFound(err) {
+			return nil, err
+		}
+		return map[string]string{
+			OPT_SNAPSHOT_NAME:        snapshot.Name,
+			OPT_SNAPSHOT_LABELS:      snapshot.Labels,
+			OPT_SNAPSHOT_DESCRIPTION: snapshot.Description,
+			"VolumeName":             volumeID,
+			"State":                  "removed",
+		}, nil
+	}
+
+	return map[string]string{
+		OPT_SNAPSHOT_NAME:         snapshot.Name,
+		OPT_SNAPSHOT_LABELS:       snapshot.Labels,
+		OPT_SNAPSHOT_DESCRIPTION:  snapshot.Description,
+		"VolumeName":              volumeID,
+		"GCESnapshotName":         gceSnapshot.Name,
+		"GCEDiskName":             filepath.Base(gceSnapshot.SourceDisk),
+		OPT_SNAPSHOT_CREATED_TIME: gceSnapshot.CreationTime,
+		OPT_SIZE:                  strconv.FormatInt(gceSnapshot.DiskSizeGb*GB, 10),
+		"State":                   gceSnapshot.Status,
+	}, nil
+}
+
+func (d *Driver) ListSnapshot(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var (
+		volumeIDs []string
+		err       error
+	)
+	snapshots := make(map[string]map[string]string)
+	specifiedVolumeID, _ := util.GetFieldFromOpts(OPT_VOLUME_NAME, opts)
+	if specifiedVolumeID != "" {
+		volumeIDs = []string{
+			specifiedVolumeID,
+		}
+	} else {
+		volumeIDs, err = d.listVolumeNames()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, volumeID := range volumeIDs {
+		volume := d.blankVolume(volumeID)
+		if err := util.ObjectLoad(volume); err != nil {
+			return nil, err
+		}
+		for snapshotID := range volume.Snapshots {
+			snapshots[snapshotID], err = d.getSnapshotInfo(snapshotID, volumeID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return snapshots, nil
+}
+
+func (d *Driver) BackupOps() (BackupOperations, error) {
+	return d, nil
+}
+
+func encodeURL(project, snapshotName string) string {
+	return "gce://" + project + "/" + snapshotName
+}
+
+func decodeURL(backupURL string) (string, string, error) {
+	u, err := url.Parse(backupURL)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != DRIVER_NAME {
+		return "", "", fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, DRIVER_NAME)
+	}
+
+	project := u.Host
+	snapshotName := strings.Trim(u.Path, "/")
+	if project == "" || !validName.MatchString(snapshotName) {
+		return "", "", fmt.Errorf("Invalid GCE backup URL %v, must be gce://<project>/<snapshot>", backupURL)
+	}
+	return project, snapshotName, nil
+}
+
+func (d *Driver) CreateBackup(snapshotID, volumeID, destURL, endpointURL string, opts map[string]string) (string, error) {
+	//destURL is not necessary in GCE case
+	snapshot, _, err := d.getSnapshotAndVolume(snapshotID, volumeID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := d.gceService.WaitForSnapshotReady(snapshot.GCEName); err != nil {
+		return "", err
+	}
+	return encodeURL(d.gceService.Project, snapshot.GCEName), nil
+}
+
+func (d *Driver) DeleteBackup(backupURL, endpointURL string, opts map[string]string) error {
+	// Would remove the snapshot
+	project, snapshotName, err := decodeURL(backupURL)
+	if err != nil {
+		return err
+	}
+	return d.gceService.DeleteSnapshotWithProject(project, snapshotName)
+}
+
+func (d *Driver) GetBackupInfo(backupURL, endpointURL string) (map[string]string, error) {
+	project, snapshotName, err := decodeURL(backupURL)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := d.gceService.GetSnapshotWithProject(project, snapshotName)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"Project":         project,
+		"GCESnapshotName": snapshot.Name,
+		"GCEDiskName":     filepath.Base(snapshot.SourceDisk),
+		"VolumeName":      snapshot.Labels[LABEL_VOLUME],
+		"SnapshotName":    snapshot.Labels[LABEL_SNAPSHOT],
+		"StartTime":       snapshot.CreationTime,
+		"Size":            strconv.FormatInt(snapshot.DiskSizeGb*GB, 10),
+		"State":           snapshot.Status,
+	}, nil
+}
+
+func (d *Driver) ListBackup(destURL, endpointURL string, opts map[string]string) (map[string]map[string]string, error) {
+	// In GCE, the backups are really the snapshots. So list the snapshots
+	// and reformat the output for its consistent with the other drivers
+	snapshots, err := d.ListSnapshot(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make(map[string]map[string]string)
+	for k, v := range snapshots {
+		if v["State"] != "removed" {
+			backupURL := encodeURL(d.gceService.Project, v["GCESnapshotName"])
+			backups[backupURL] = map[string]string{
+				"BackupName":        v["GCESnapshotName"],
+				"BackupURL":         backupURL,
+				"CreatedTime":       v[OPT_SNAPSHOT_CREATED_TIME],
+				"DriverName":        DRIVER_NAME,
+				"SnapshotCreatedAt": v[OPT_SNAPSHOT_CREATED_TIME],
+				"SnapshotName":      k,
+				"VolumeCreatedAt":   "",
+				"VolumeName":        v["VolumeName"],
+				"VolumeSize":        v[OPT_SIZE],
+			}
+		}
+	}
+	return backups, nil
+}
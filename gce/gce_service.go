@@ -0,0 +1,447 @@
+package gce
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	GB = 1073741824
+
+	METADATA_URL = "http://metadata.google.internal/computeMetadata/v1/"
+	COMPUTE_URL  = "https://www.googleapis.com/compute/v1/"
+
+	DEVICE_PATH_PREFIX = "/dev/disk/by-id/google-"
+
+	OPERATION_TIMEOUT = 10 * time.Minute
+	DEVICE_TIMEOUT    = 2 * time.Minute
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "gce"})
+
+	// RetryInterval is the interval of polling operations and devices
+	RetryInterval = 2 * time.Second
+)
+
+type gceService struct {
+	client      *http.Client
+	metadataURL string
+	computeURL  string
+
+	mutex       *sync.Mutex
+	token       string
+	tokenExpiry time.Time
+
+	Project  string
+	Zone     string
+	Instance string
+}
+
+type Disk struct {
+	Name             string            `json:"name"`
+	SizeGb           int64             `json:"sizeGb,string,omitempty"`
+	Type             string            `json:"type,omitempty"`
+	SourceSnapshot   string            `json:"sourceSnapshot,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	LabelFingerprint string            `json:"labelFingerprint,omitempty"`
+	Status           string            `json:"status,omitempty"`
+	Users            []string          `json:"users,omitempty"`
+	Zone             string            `json:"zone,omitempty"`
+	SelfLink         string            `json:"selfLink,omitempty"`
+	CreationTime     string            `json:"creationTimestamp,omitempty"`
+}
+
+type Snapshot struct {
+	Name         string            `json:"name"`
+	Description  string            `json:"description,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Status       string            `json:"status,omitempty"`
+	DiskSizeGb   int64             `json:"diskSizeGb,string,omitempty"`
+	SourceDisk   string            `json:"sourceDisk,omitempty"`
+	SelfLink     string            `json:"selfLink,omitempty"`
+	CreationTime string            `json:"creationTimestamp,omitempty"`
+}
+
+type operation struct {
+	Name     string `json:"name"`
+	Zone     string `json:"zone,omitempty"`
+	Status   string `json:"status"`
+	SelfLink string `json:"selfLink"`
+	Error    *struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	} `json:"error,omitempty"`
+}
+
+type apiError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type NotFoundError struct {
+	Resource string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("Cannot find %v", e.Resource)
+}
+
+func IsNotFound(err error) bool {
+	_, ok := err.(*NotFoundError)
+	return ok
+}
+
+// NewGCEService loads the project, zone and instance from the metadata server,
+// so it can only be used on a GCE instance
+func NewGCEService() (*gceService, error) {
+	return newGCEService(&http.Client{Timeout: time.Minute}, METADATA_URL, COMPUTE_URL)
+}
+
+func newGCEService(client *http.Client, metadataURL, computeURL string) (*gceService, error) {
+	var err error
+	s := &gceService{
+		client:      client,
+		metadataURL: metadataURL,
+		computeURL:  computeURL,
+		mutex:       &sync.Mutex{},
+	}
+	if s.Project, err = s.getMetadata("project/project-id"); err != nil {
+		return nil, fmt.Errorf("Not running on a GCE instance: %v", err)
+	}
+	zone, err := s.getMetadata("instance/zone")
+	if err != nil {
+		return nil, err
+	}
+	// The zone is in format of projects/<number>/zones/<zone>
+	s.Zone = path.Base(zone)
+	if s.Instance, err = s.getMetadata("instance/name"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *gceService) getMetadata(key string) (string, error) {
+	req, err := http.NewRequest("GET", s.metadataURL+key, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Failed to get metadata %v: %v", key, resp.Status)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// getToken returns the access token of the default service account of the
+// instance, which would be refreshed before it expires
+func (s *gceService) getToken() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.token != "" && time.Now().Before(s.tokenExpiry) {
+		return s.token, nil
+	}
+	data, err := s.getMetadata("instance/service-accounts/default/token")
+	if err != nil {
+		return "", err
+	}
+	token := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}{}
+	if err := json.Unmarshal([]byte(data), &token); err != nil {
+		return "", err
+	}
+	s.token = token.AccessToken
+	s.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return s.token, nil
+}
+
+func (s *gceService) zonePath(elem ...string) string {
+	return path.Join(append([]string{"projects", s.Project, "zones", s.Zone}, elem...)...)
+}
+
+func (s *gceService) globalPath(elem ...string) string {
+	return path.Join(append([]string{"projects", s.Project, "global"}, elem...)...)
+}
+
+func (s *gceService) do(method, resource string, query url.Values, in, out interface{}) error {
+	token, err := s.getToken()
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	u := s.computeURL + resource
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return &NotFoundError{Resource: resource}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := &apiError{}
+		if err := json.Unmarshal(data, apiErr); err == nil && apiErr.Error.Message != "" {
+			return fmt.Errorf("GCE Error: %v %v", apiErr.Error.Code, apiErr.Error.Message)
+		}
+		return fmt.Errorf("GCE Error: %v %v %v", method, resource, resp.Status)
+	}
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+// call executes the request of an operation, and waits until the operation
+// is done
+func (s *gceService) call(method, resource string, query url.Values, in interface{}) error {
+	op := &operation{}
+	if err := s.do(method, resource, query, in, op); err != nil {
+		return err
+	}
+	return s.waitForOperation(op)
+}
+
+func (s *gceService) waitForOperation(op *operation) error {
+	opPath := s.globalPath("operations", op.Name)
+	if op.Zone != "" {
+		opPath = s.zonePath("operations", op.Name)
+	}
+	deadline := time.Now().Add(OPERATION_TIMEOUT)
+	for op.Status != "DONE" {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timed out waiting for operation %v", op.Name)
+		}
+		log.Debugf("Waiting for operation %v, status %v", op.Name, op.Status)
+		time.Sleep(RetryInterval)
+		if err := s.do("GET", opPath, nil, nil, op); err != nil {
+			return err
+		}
+	}
+	if op.Error != nil && len(op.Error.Errors) != 0 {
+		messages := []string{}
+		for _, e := range op.Error.Errors {
+			messages = append(messages, e.Code+": "+e.Message)
+		}
+		return fmt.Errorf("Operation %v failed: %v", op.Name, strings.Join(messages, "; "))
+	}
+	return nil
+}
+
+type CreateDiskRequest struct {
+	Name            string
+	Size            int64
+	DiskType        string
+	SnapshotProject string
+	SnapshotID      string
+	Labels          map[string]string
+}
+
+func (s *gceService) CreateDisk(request *CreateDiskRequest) error {
+	disk := &Disk{
+		Name:   request.Name,
+		SizeGb: (request.Size + GB - 1) / GB,
+		Labels: request.Labels,
+	}
+	if request.DiskType != "" {
+		disk.Type = s.zonePath("diskTypes", request.DiskType)
+	}
+	if request.SnapshotID != "" {
+		disk.SourceSnapshot = path.Join("projects", request.SnapshotProject, "global", "snapshots", request.SnapshotID)
+	}
+	return s.call("POST", s.zonePath("disks"), nil, disk)
+}
+
+func (s *gceService) GetDisk(name string) (*Disk, error) {
+	disk := &Disk{}
+	if err := s.do("GET", s.zonePath("disks", name), nil, nil, disk); err != nil {
+		return nil, err
+	}
+	return disk, nil
+}
+
+func (s *gceService) DeleteDisk(name string) error {
+	return s.call("DELETE", s.zonePath("disks", name), nil, nil)
+}
+
+func (s *gceService) SetDiskLabels(name string, labels map[string]string) error {
+	disk, err := s.GetDisk(name)
+	if err != nil {
+		return err
+	}
+	if disk.Labels == nil {
+		disk.Labels = map[string]string{}
+	}
+	for k, v := range labels {
+		disk.Labels[k] = v
+	}
+	request := map[string]interface{}{
+		"labels":           disk.Labels,
+		"labelFingerprint": disk.LabelFingerprint,
+	}
+	return s.call("POST", s.zonePath("disks", name, "setLabels"), nil, request)
+}
+
+// DevicePath returns the path of the disk attached with deviceName
+func DevicePath(deviceName string) string {
+	return DEVICE_PATH_PREFIX + deviceName
+}
+
+// AttachDisk attaches the disk to the current instance with the disk name as
+// the device name, and waits until the device shows up
+func (s *gceService) AttachDisk(name string) (string, error) {
+	dev := DevicePath(name)
+	disk, err := s.GetDisk(name)
+	if err != nil {
+		return "", err
+	}
+	instance := s.zonePath("instances", s.Instance)
+	attached := false
+	for _, user := range disk.Users {
+		if strings.HasSuffix(user, "/"+instance) {
+			attached = true
+			break
+		}
+	}
+	if !attached {
+		if len(disk.Users) != 0 {
+			return "", fmt.Errorf("Disk %v is attached to other instances %v", name, disk.Users)
+		}
+		attach := map[string]interface{}{
+			"source":     s.zonePath("disks", name),
+			"deviceName": name,
+			"mode":       "READ_WRITE",
+			"autoDelete": false,
+		}
+		if err := s.call("POST", s.zonePath("instances", s.Instance, "attachDisk"), nil, attach); err != nil {
+			return "", err
+		}
+	}
+	if err := waitForDevice(dev); err != nil {
+		return "", err
+	}
+	return dev, nil
+}
+
+func waitForDevice(dev string) error {
+	for start := time.Now(); time.Since(start) < DEVICE_TIMEOUT; time.Sleep(RetryInterval) {
+		if _, err := os.Stat(dev); err == nil {
+			// Resolve the symlink to make sure udev has finished
+			_, err := filepath.EvalSymlinks(dev)
+			return err
+		}
+	}
+	return fmt.Errorf("Timed out waiting for device %v", dev)
+}
+
+func (s *gceService) DetachDisk(name string) error {
+	query := url.Values{}
+	query.Set("deviceName", name)
+	return s.call("POST", s.zonePath("instances", s.Instance, "detachDisk"), query, nil)
+}
+
+type CreateSnapshotRequest struct {
+	DiskName    string
+	Name        string
+	Description string
+	Labels      map[string]string
+}
+
+func (s *gceService) CreateSnapshot(request *CreateSnapshotRequest) error {
+	snapshot := &Snapshot{
+		Name:        request.Name,
+		Description: request.Description,
+		Labels:      request.Labels,
+	}
+	return s.call("POST", s.zonePath("disks", request.DiskName, "createSnapshot"), nil, snapshot)
+}
+
+func (s *gceService) GetSnapshotWithProject(project, name string) (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	resource := path.Join("projects", project, "global", "snapshots", name)
+	if err := s.do("GET", resource, nil, nil, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func (s *gceService) GetSnapshot(name string) (*Snapshot, error) {
+	return s.GetSnapshotWithProject(s.Project, name)
+}
+
+func (s *gceService) WaitForSnapshotReady(name string) error {
+	deadline := time.Now().Add(OPERATION_TIMEOUT)
+	for {
+		snapshot, err := s.GetSnapshot(name)
+		if err != nil {
+			return err
+		}
+		switch snapshot.Status {
+		case "READY":
+			return nil
+		case "FAILED":
+			return fmt.Errorf("Snapshot %v failed", name)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Timed out waiting for snapshot %v", name)
+		}
+		log.Debugf("Waiting for snapshot %v, status %v", name, snapshot.Status)
+		time.Sleep(RetryInterval)
+	}
+}
+
+func (s *gceService) DeleteSnapshotWithProject(project, name string) error {
+	return s.call("DELETE", path.Join("projects", project, "global", "snapshots", name), nil, nil)
+}
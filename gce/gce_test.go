@@ -0,0 +1,184 @@
+package gce
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct {
+	server *httptest.Server
+
+	mutex    sync.Mutex
+	requests []string
+	bodies   map[string]map[string]interface{}
+	polls    int
+}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) SetUpTest(c *C) {
+	RetryInterval = time.Millisecond
+	s.requests = nil
+	s.bodies = map[string]map[string]interface{}{}
+	s.polls = 0
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+}
+
+func (s *TestSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *TestSuite) handle(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if strings.HasPrefix(r.URL.Path, "/metadata/") {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch strings.TrimPrefix(r.URL.Path, "/metadata/") {
+		case "project/project-id":
+			fmt.Fprint(w, "proj")
+		case "instance/zone":
+			fmt.Fprint(w, "projects/123/zones/us-central1-a")
+		case "instance/name":
+			fmt.Fprint(w, "host1")
+		case "instance/service-accounts/default/token":
+			fmt.Fprint(w, `{"access_token":"token1","expires_in":3600,"token_type":"Bearer"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer token1" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	p := strings.TrimPrefix(r.URL.Path, "/compute/")
+	s.requests = append(s.requests, r.Method+" "+p+"?"+r.URL.RawQuery)
+	if r.Body != nil {
+		data, _ := ioutil.ReadAll(r.Body)
+		if len(data) != 0 {
+			body := map[string]interface{}{}
+			json.Unmarshal(data, &body)
+			s.bodies[p] = body
+		}
+	}
+
+	switch {
+	case r.Method == "POST" && p == "projects/proj/zones/us-central1-a/disks":
+		fmt.Fprint(w, `{"name":"op1","zone":"us-central1-a","status":"RUNNING"}`)
+	case p == "projects/proj/zones/us-central1-a/operations/op1":
+		s.polls++
+		if s.polls < 2 {
+			fmt.Fprint(w, `{"name":"op1","zone":"us-central1-a","status":"RUNNING"}`)
+			return
+		}
+		fmt.Fprint(w, `{"name":"op1","zone":"us-central1-a","status":"DONE"}`)
+	case r.Method == "DELETE" && p == "projects/proj/global/snapshots/snap1":
+		fmt.Fprint(w, `{"name":"op2","status":"DONE","error":{"errors":[{"code":"RESOURCE_IN_USE","message":"in use"}]}}`)
+	case r.Method == "GET" && p == "projects/other/global/snapshots/snap1":
+		fmt.Fprint(w, `{"name":"snap1","status":"READY","diskSizeGb":"20","labels":{"convoy-volume":"vol1"}}`)
+	case r.Method == "GET" && p == "projects/proj/zones/us-central1-a/disks/disk1":
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":{"code":404,"message":"not found"}}`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"code":400,"message":"unexpected request"}}`)
+	}
+}
+
+func (s *TestSuite) newService(c *C) *gceService {
+	svc, err := newGCEService(http.DefaultClient, s.server.URL+"/metadata/", s.server.URL+"/compute/")
+	c.Assert(err, IsNil)
+	return svc
+}
+
+func (s *TestSuite) TestMetadata(c *C) {
+	svc := s.newService(c)
+	c.Assert(svc.Project, Equals, "proj")
+	c.Assert(svc.Zone, Equals, "us-central1-a")
+	c.Assert(svc.Instance, Equals, "host1")
+
+	_, err := newGCEService(http.DefaultClient, s.server.URL+"/nowhere/", s.server.URL+"/compute/")
+	c.Assert(err, ErrorMatches, "Not running on a GCE instance.*")
+}
+
+func (s *TestSuite) TestDisk(c *C) {
+	svc := s.newService(c)
+	err := svc.CreateDisk(&CreateDiskRequest{
+		Name:            "disk2",
+		Size:            GB + 1,
+		DiskType:        "pd-ssd",
+		SnapshotProject: "other",
+		SnapshotID:      "snap1",
+		Labels:          map[string]string{LABEL_VOLUME: "vol2"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.polls, Equals, 2)
+	body := s.bodies["projects/proj/zones/us-central1-a/disks"]
+	c.Assert(body["name"], Equals, "disk2")
+	c.Assert(body["sizeGb"], Equals, "2")
+	c.Assert(body["type"], Equals, "projects/proj/zones/us-central1-a/diskTypes/pd-ssd")
+	c.Assert(body["sourceSnapshot"], Equals, "projects/other/global/snapshots/snap1")
+
+	_, err = svc.GetDisk("disk1")
+	c.Assert(IsNotFound(err), Equals, true)
+
+	err = svc.DetachDisk("disk1")
+	c.Assert(err, ErrorMatches, "GCE Error: 400 unexpected request")
+	c.Assert(s.requests[len(s.requests)-1], Equals,
+		"POST projects/proj/zones/us-central1-a/instances/host1/detachDisk?deviceName=disk1")
+}
+
+func (s *TestSuite) TestSnapshot(c *C) {
+	svc := s.newService(c)
+	snapshot, err := svc.GetSnapshotWithProject("other", "snap1")
+	c.Assert(err, IsNil)
+	c.Assert(snapshot.DiskSizeGb, Equals, int64(20))
+	c.Assert(snapshot.Labels[LABEL_VOLUME], Equals, "vol1")
+
+	err = svc.DeleteSnapshotWithProject("proj", "snap1")
+	c.Assert(err, ErrorMatches, "Operation op2 failed: RESOURCE_IN_USE: in use")
+}
+
+func (s *TestSuite) TestNames(c *C) {
+	c.Assert(labelValue("My_Volume.1"), Equals, "my_volume-1")
+
+	name := generateName("My_Volume.1", "snap")
+	c.Assert(name, Matches, "convoy-my-volume-1-snap-[0-9a-f]{8}")
+	c.Assert(validName.MatchString(name), Equals, true)
+
+	name = generateName(strings.Repeat("a", 100))
+	c.Assert(len(name), Equals, MAX_NAME_LENGTH)
+	c.Assert(validName.MatchString(name), Equals, true)
+
+	c.Assert(DevicePath("disk1"), Equals, "/dev/disk/by-id/google-disk1")
+}
+
+func (s *TestSuite) TestURL(c *C) {
+	url := encodeURL("proj", "convoy-vol1-snap1-0123abcd")
+	c.Assert(url, Equals, "gce://proj/convoy-vol1-snap1-0123abcd")
+	project, snapshot, err := decodeURL(url)
+	c.Assert(err, IsNil)
+	c.Assert(project, Equals, "proj")
+	c.Assert(snapshot, Equals, "convoy-vol1-snap1-0123abcd")
+
+	_, _, err = decodeURL("gce://proj/Invalid_Name")
+	c.Assert(err, ErrorMatches, "Invalid GCE backup URL.*")
+	_, _, err = decodeURL("ebs://us-west-1/snap-1234")
+	c.Assert(err, ErrorMatches, "BUG: Why dispatch ebs to gce.*")
+}
@@ -1,8 +1,10 @@
 package glusterfs
 
 import (
+	"bytes"
 	"fmt"
 	"math/rand"
+	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -30,6 +32,8 @@ const (
 	GLUSTERFS_DEFAULT_VOLUME_POOL = "glusterfs.defaultvolumepool"
 	GLUSTERFS_DEFAULT_VOLUME_SIZE = "glusterfs.defaultvolumesize"
 	DEFAULT_VOLUME_SIZE           = "100G"
+
+	GLUSTER_BINARY = "gluster"
 )
 
 var (
@@ -66,18 +70,29 @@ func (dev *Device) ConfigFile() (string, error) {
 	return filepath.Join(dev.Root, DRIVER_CONFIG_FILE), nil
 }
 
+// Volume is either a directory in the volume pool, or a dedicated GlusterFS
+// volume if Dedicated is true. Only dedicated volume supports snapshot.
 type Volume struct {
 	Name         string
 	Path         string
 	MountPoint   string
 	VolumePool   string
+	Dedicated    bool
 	Size         int64
 	PrepareForVM bool
 	CreatedTime  string
+	Snapshots    map[string]Snapshot
 
 	configPath string
 }
 
+type Snapshot struct {
+	Name            string
+	GlusterSnapshot string
+	CreatedTime     string
+	SnapshotMetadata
+}
+
 type GlusterFSVolume struct {
 	Name       string
 	MountPoint string
@@ -180,17 +195,13 @@ func Init(root string, config map[string]string) (ConvoyDriver, error) {
 		gVolumes: map[string]*GlusterFSVolume{},
 		Device:   *dev,
 	}
-	gVolume := &GlusterFSVolume{
-		Name:       dev.DefaultVolumePool,
-		Servers:    dev.Servers,
-		configPath: d.Root,
-	}
 	// We would always mount the default volume pool
-	// TODO: Also need to mount any existing volume's pool
-	if _, err := util.VolumeMount(gVolume, "", true); err != nil {
+	if _, err := d.mountGlusterFSVolume(d.DefaultVolumePool); err != nil {
+		return nil, err
+	}
+	if err := d.mountExistingVolumes(); err != nil {
 		return nil, err
 	}
-	d.gVolumes[d.DefaultVolumePool] = gVolume
 
 	if err := util.ObjectSave(dev); err != nil {
 		return nil, err
@@ -198,6 +209,56 @@ func Init(root string, config map[string]string) (ConvoyDriver, error) {
 	return d, nil
 }
 
+func (d *Driver) mountGlusterFSVolume(name string) (*GlusterFSVolume, error) {
+	if gVolume, exists := d.gVolumes[name]; exists {
+		return gVolume, nil
+	}
+	gVolume := &GlusterFSVolume{
+		Name:       name,
+		Servers:    d.Servers,
+		configPath: d.Root,
+	}
+	if _, err := util.VolumeMount(gVolume, "", true); err != nil {
+		return nil, err
+	}
+	d.gVolumes[name] = gVolume
+	return gVolume, nil
+}
+
+func (d *Driver) umountGlusterFSVolume(name string) error {
+	gVolume, exists := d.gVolumes[name]
+	if !exists {
+		return nil
+	}
+	if err := util.VolumeUmount(gVolume); err != nil {
+		return err
+	}
+	delete(d.gVolumes, name)
+	return nil
+}
+
+// mountExistingVolumes mounts the pools used by existing volumes, as well as
+// the dedicated volumes which were mounted before the restart
+func (d *Driver) mountExistingVolumes() error {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return err
+	}
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return err
+		}
+		if volume.Dedicated && volume.MountPoint == "" {
+			continue
+		}
+		if _, err := d.mountGlusterFSVolume(volume.VolumePool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (d *Driver) Info() (map[string]string, error) {
 	return map[string]string{
 		"Root":              d.Root,
@@ -253,6 +314,31 @@ func (d *Driver) CreateVolume(req Request) error {
 		}
 	}
 
+	volume.Name = id
+	volume.Snapshots = map[string]Snapshot{}
+	volume.CreatedTime = util.Now()
+
+	if dedicatedVolume := opts[OPT_VOLUME_DRIVER_ID]; dedicatedVolume != "" {
+		if dedicatedVolume == d.DefaultVolumePool {
+			return fmt.Errorf("Cannot use the default volume pool %v as a dedicated volume", dedicatedVolume)
+		}
+		if err := d.checkDedicatedVolume(dedicatedVolume); err != nil {
+			return err
+		}
+		// Verify the GlusterFS volume can be mounted
+		gVolume, err := d.mountGlusterFSVolume(dedicatedVolume)
+		if err != nil {
+			return err
+		}
+		volume.Path = gVolume.MountPoint
+		volume.VolumePool = gVolume.Name
+		volume.Dedicated = true
+		if err := d.umountGlusterFSVolume(dedicatedVolume); err != nil {
+			return err
+		}
+		return util.ObjectSave(volume)
+	}
+
 	gVolume := d.gVolumes[d.DefaultVolumePool]
 	volumePath := filepath.Join(gVolume.MountPoint, id)
 	if util.VolumeMountPointFileExists(gVolume, id, util.FILE_TYPE_DIRECTORY) {
@@ -260,10 +346,8 @@ func (d *Driver) CreateVolume(req Request) error {
 	} else if err := util.VolumeMountPointDirectoryCreate(gVolume, id); err != nil {
 		return err
 	}
-	volume.Name = id
 	volume.Path = volumePath
 	volume.VolumePool = gVolume.Name
-	volume.CreatedTime = util.Now()
 
 	return util.ObjectSave(volume)
 }
@@ -284,7 +368,17 @@ func (d *Driver) DeleteVolume(req Request) error {
 		return fmt.Errorf("Cannot delete volume %v. It is still mounted", id)
 	}
 	referenceOnly, _ := strconv.ParseBool(opts[OPT_REFERENCE_ONLY])
-	if !referenceOnly {
+	if volume.Dedicated {
+		// The dedicated GlusterFS volume itself is managed outside of
+		// Convoy, only the snapshots created by Convoy would be removed
+		if !referenceOnly {
+			for _, snapshot := range volume.Snapshots {
+				if _, err := d.gluster("snapshot", "delete", snapshot.GlusterSnapshot); err != nil {
+					return err
+				}
+			}
+		}
+	} else if !referenceOnly {
 		log.Debugf("Cleaning up volume %v", id)
 		gVolume := d.gVolumes[d.DefaultVolumePool]
 		if err := util.VolumeMountPointDirectoryRemove(gVolume, volume.Name); err != nil {
@@ -310,6 +404,13 @@ func (d *Driver) MountVolume(req Request) (string, error) {
 	if specifiedPoint != "" {
 		return "", fmt.Errorf("GlusterFS doesn't support specified mount point")
 	}
+	if volume.Dedicated {
+		gVolume, err := d.mountGlusterFSVolume(volume.VolumePool)
+		if err != nil {
+			return "", err
+		}
+		volume.Path = gVolume.MountPoint
+	}
 	if volume.MountPoint == "" {
 		volume.MountPoint = volume.Path
 	}
@@ -338,6 +439,11 @@ func (d *Driver) UmountVolume(req Request) error {
 	if volume.MountPoint != "" {
 		volume.MountPoint = ""
 	}
+	if volume.Dedicated {
+		if err := d.umountGlusterFSVolume(volume.VolumePool); err != nil {
+			return err
+		}
+	}
 	return util.ObjectSave(volume)
 }
 
@@ -368,8 +474,7 @@ func (d *Driver) GetVolumeInfo(id string) (map[string]string, error) {
 		return nil, err
 	}
 
-	gVolume := d.gVolumes[volume.VolumePool]
-	if gVolume == nil {
+	if !volume.Dedicated && d.gVolumes[volume.VolumePool] == nil {
 		return nil, fmt.Errorf("Cannot find volume pool %v", volume.VolumePool)
 	}
 
@@ -387,7 +492,8 @@ func (d *Driver) GetVolumeInfo(id string) (map[string]string, error) {
 		OPT_PREPARE_FOR_VM:      prepareForVM,
 		OPT_VOLUME_CREATED_TIME: volume.CreatedTime,
 		"GlusterFSVolume":       volume.VolumePool,
-		"GlusterFSServers":      fmt.Sprintf("%v", gVolume.Servers),
+		"GlusterFSServers":      fmt.Sprintf("%v", d.Servers),
+		"Dedicated":             strconv.FormatBool(volume.Dedicated),
 	}, nil
 }
 
@@ -405,7 +511,186 @@ func (d *Driver) MountPoint(req Request) (string, error) {
 }
 
 func (d *Driver) SnapshotOps() (SnapshotOperations, error) {
-	return nil, fmt.Errorf("Doesn't support snapshot operations")
+	return d, nil
+}
+
+// gluster executes the gluster CLI against the servers in turn, until one of
+// them succeeds
+func (d *Driver) gluster(args ...string) (string, error) {
+	if _, err := exec.LookPath(GLUSTER_BINARY); err != nil {
+		return "", fmt.Errorf("Cannot find %v binary, please make sure glusterfs-cli is installed", GLUSTER_BINARY)
+	}
+	var lastErr error
+	for _, server := range d.Servers {
+		cmdArgs := append([]string{"--mode=script", "--remote-host=" + server}, args...)
+		cmd := exec.Command(GLUSTER_BINARY, cmdArgs...)
+		stderr := &bytes.Buffer{}
+		cmd.Stderr = stderr
+		out, err := cmd.Output()
+		if err == nil {
+			return strings.TrimSpace(string(out)), nil
+		}
+		lastErr = fmt.Errorf("Failed to execute gluster %v on %v: %v, %v %v",
+			strings.Join(args, " "), server, err, strings.TrimSpace(string(out)), strings.TrimSpace(stderr.String()))
+		log.Debug(lastErr)
+	}
+	return "", lastErr
+}
+
+func (d *Driver) checkDedicatedVolume(name string) error {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return err
+	}
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return err
+		}
+		if volume.VolumePool == name {
+			return fmt.Errorf("GlusterFS volume %v is already used by volume %v", name, id)
+		}
+	}
+	return nil
+}
+
+// glusterSnapshotName returns the snapshot name in GlusterFS, which must be
+// unique across the trusted storage pool
+func glusterSnapshotName(volumeName, snapshotName string) string {
+	return "convoy_" + volumeName + "_" + snapshotName
+}
+
+func (d *Driver) getSnapshotAndVolume(snapshotID, volumeID string) (*Snapshot, *Volume, error) {
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, nil, err
+	}
+	snapshot, exists := volume.Snapshots[snapshotID]
+	if !exists {
+		return nil, nil, fmt.Errorf("Cannot find snapshot %v of volume %v", snapshotID, volumeID)
+	}
+	return &snapshot, volume, nil
+}
+
+func (d *Driver) CreateSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if !volume.Dedicated {
+		return fmt.Errorf("Snapshot is only supported by volume with dedicated GlusterFS volume, volume %v is a directory in %v", volumeID, volume.VolumePool)
+	}
+	if volume.Snapshots == nil {
+		volume.Snapshots = map[string]Snapshot{}
+	}
+	if _, exists := volume.Snapshots[id]; exists {
+		return fmt.Errorf("Snapshot %v already exists for volume %v", id, volumeID)
+	}
+
+	snapshot := Snapshot{
+		Name:             id,
+		GlusterSnapshot:  glusterSnapshotName(volumeID, id),
+		SnapshotMetadata: NewSnapshotMetadata(req.Options),
+	}
+	log.Debugf("Creating GlusterFS snapshot %v of %v", snapshot.GlusterSnapshot, volume.VolumePool)
+	if _, err := d.gluster("snapshot", "create", snapshot.GlusterSnapshot, volume.VolumePool, "no-timestamp"); err != nil {
+		return err
+	}
+	snapshot.CreatedTime = util.Now()
+	volume.Snapshots[id] = snapshot
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) DeleteSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+	snapshot, volume, err := d.getSnapshotAndVolume(req.Name, volumeID)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Removing GlusterFS snapshot %v of %v", snapshot.GlusterSnapshot, volume.VolumePool)
+	if _, err := d.gluster("snapshot", "delete", snapshot.GlusterSnapshot); err != nil {
+		return err
+	}
+	delete(volume.Snapshots, req.Name)
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) GetSnapshotInfo(req Request) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return nil, err
+	}
+	return d.getSnapshotInfo(req.Name, volumeID)
+}
+
+func (d *Driver) getSnapshotInfo(id, volumeID string) (map[string]string, error) {
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		OPT_SNAPSHOT_NAME:         snapshot.Name,
+		OPT_SNAPSHOT_LABELS:       snapshot.Labels,
+		OPT_SNAPSHOT_DESCRIPTION:  snapshot.Description,
+		OPT_SNAPSHOT_CREATED_TIME: snapshot.CreatedTime,
+		"VolumeUUID":              volumeID,
+		"GlusterFSVolume":         volume.VolumePool,
+		"GlusterFSSnapshot":       snapshot.GlusterSnapshot,
+	}, nil
+}
+
+func (d *Driver) ListSnapshot(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	var (
+		volumeIDs []string
+		err       error
+	)
+	snapshots := make(map[string]map[string]string)
+	specifiedVolumeID, _ := util.GetFieldFromOpts(OPT_VOLUME_NAME, opts)
+	if specifiedVolumeID != "" {
+		volumeIDs = []string{
+			specifiedVolumeID,
+		}
+	} else {
+		volumeIDs, err = d.listVolumeNames()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, volumeID := range volumeIDs {
+		volume := d.blankVolume(volumeID)
+		if err := util.ObjectLoad(volume); err != nil {
+			return nil, err
+		}
+		for snapshotID := range volume.Snapshots {
+			snapshots[snapshotID], err = d.getSnapshotInfo(snapshotID, volumeID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return snapshots, nil
 }
 
 func (d *Driver) BackupOps() (BackupOperations, error) {
@@ -0,0 +1,175 @@
+package hdfs
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/objectstore"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "hdfs"})
+)
+
+type HDFSObjectStoreDriver struct {
+	kind    string
+	destURL string
+	path    string
+	service WebHDFSService
+}
+
+const (
+	KIND     = "webhdfs"
+	KIND_TLS = "swebhdfs"
+
+	ENV_USER_NAME = "HADOOP_USER_NAME"
+)
+
+func init() {
+	if err := objectstore.RegisterDriver(KIND, initFunc); err != nil {
+		panic(err)
+	}
+	if err := objectstore.RegisterDriver(KIND_TLS, initFunc); err != nil {
+		panic(err)
+	}
+}
+
+func initFunc(destURL, endpoint string) (objectstore.ObjectStoreDriver, error) {
+	return initFuncWithConnectionCheck(destURL, endpoint, func(d objectstore.ObjectStoreDriver) error {
+		_, err := d.List("")
+		return err
+	})
+}
+
+func initFuncWithConnectionCheck(destURL, endpoint string, connectionTest func(d objectstore.ObjectStoreDriver) error) (objectstore.ObjectStoreDriver, error) {
+	b := &HDFSObjectStoreDriver{}
+
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := ""
+	switch u.Scheme {
+	case KIND:
+		scheme = "http"
+	case KIND_TLS:
+		scheme = "https"
+	default:
+		return nil, fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, KIND)
+	}
+	b.kind = u.Scheme
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("Invalid URL. Must be %v://namenode:port/path/", u.Scheme)
+	}
+	b.path = filepath.Clean("/" + u.Path)
+
+	b.service.NameNodeURL = scheme + "://" + u.Host
+	b.service.User = os.Getenv(ENV_USER_NAME)
+
+	if err := connectionTest(b); err != nil {
+		return nil, err
+	}
+
+	b.destURL = b.kind + "://" + u.Host + b.path
+	log.Debugf("Loaded driver for %v", b.destURL)
+	return b, nil
+}
+
+func (s *HDFSObjectStoreDriver) Kind() string {
+	return s.kind
+}
+
+func (s *HDFSObjectStoreDriver) GetURL() string {
+	return s.destURL
+}
+
+func (s *HDFSObjectStoreDriver) updatePath(path string) string {
+	return filepath.Join(s.path, path)
+}
+
+func (s *HDFSObjectStoreDriver) List(listPath string) ([]string, error) {
+	statuses, err := s.service.ListStatus(s.updatePath(listPath))
+	if err != nil {
+		log.Error("Fail to list hdfs: ", err)
+		return nil, err
+	}
+	result := []string{}
+	for _, st := range statuses {
+		result = append(result, st.PathSuffix)
+	}
+	return result, nil
+}
+
+func (s *HDFSObjectStoreDriver) FileExists(filePath string) bool {
+	return s.FileSize(filePath) >= 0
+}
+
+func (s *HDFSObjectStoreDriver) FileSize(filePath string) int64 {
+	st, err := s.service.GetFileStatus(s.updatePath(filePath))
+	if err != nil || st.Type != FILE_TYPE_FILE {
+		return -1
+	}
+	return st.Length
+}
+
+func (s *HDFSObjectStoreDriver) Remove(names ...string) error {
+	for _, name := range names {
+		if err := s.service.Delete(s.updatePath(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *HDFSObjectStoreDriver) Read(src string) (io.ReadCloser, error) {
+	return s.service.Open(s.updatePath(src))
+}
+
+func (s *HDFSObjectStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	length, err := rs.Seek(0, 2)
+	if err != nil {
+		return err
+	}
+	if _, err := rs.Seek(0, 0); err != nil {
+		return err
+	}
+	return s.service.Create(s.updatePath(dst), rs, length)
+}
+
+func (s *HDFSObjectStoreDriver) Upload(src, dst string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	st, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return s.service.Create(s.updatePath(dst), file, st.Size())
+}
+
+func (s *HDFSObjectStoreDriver) Download(src, dst string) error {
+	if _, err := os.Stat(dst); err != nil {
+		os.Remove(dst)
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rc, err := s.service.Open(s.updatePath(src))
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(f, rc)
+	return err
+}
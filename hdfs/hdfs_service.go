@@ -0,0 +1,195 @@
+package hdfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	WEBHDFS_PREFIX = "/webhdfs/v1"
+
+	FILE_TYPE_FILE      = "FILE"
+	FILE_TYPE_DIRECTORY = "DIRECTORY"
+)
+
+type WebHDFSService struct {
+	// NameNodeURL is the http(s) URL of the NameNode, e.g.
+	// http://namenode:9870
+	NameNodeURL string
+	User        string
+
+	client *http.Client
+}
+
+type fileStatus struct {
+	PathSuffix string `json:"pathSuffix"`
+	Type       string `json:"type"`
+	Length     int64  `json:"length"`
+}
+
+type remoteException struct {
+	RemoteException struct {
+		Exception string `json:"exception"`
+		Message   string `json:"message"`
+	} `json:"RemoteException"`
+}
+
+func (s *WebHDFSService) httpClient() *http.Client {
+	if s.client == nil {
+		s.client = &http.Client{
+			// Redirects to DataNodes are handled by the caller, since
+			// the request body cannot be replayed by default
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	return s.client
+}
+
+func (s *WebHDFSService) url(path, op string, params map[string]string) string {
+	query := url.Values{}
+	query.Set("op", op)
+	if s.User != "" {
+		query.Set("user.name", s.User)
+	}
+	for k, v := range params {
+		query.Set(k, v)
+	}
+	u := &url.URL{Path: WEBHDFS_PREFIX + "/" + strings.TrimLeft(path, "/")}
+	return strings.TrimRight(s.NameNodeURL, "/") + u.EscapedPath() + "?" + query.Encode()
+}
+
+func responseError(op, path string, resp *http.Response) error {
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	e := &remoteException{}
+	if err := json.Unmarshal(data, e); err == nil && e.RemoteException.Exception != "" {
+		return fmt.Errorf("WebHDFS Error: %v %v %v, %v: %v", op, path, resp.Status,
+			e.RemoteException.Exception, e.RemoteException.Message)
+	}
+	return fmt.Errorf("WebHDFS Error: %v %v %v, %v", op, path, resp.Status, strings.TrimSpace(string(data)))
+}
+
+func (s *WebHDFSService) do(method, path, op string, params map[string]string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.url(path, op, params), body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	// Data would be served by DataNode, follow the redirection
+	if resp.StatusCode == http.StatusTemporaryRedirect && body == nil && method == "GET" {
+		resp.Body.Close()
+		location := resp.Header.Get("Location")
+		if location == "" {
+			return nil, fmt.Errorf("WebHDFS Error: %v %v redirected without location", op, path)
+		}
+		return s.httpClient().Get(location)
+	}
+	return resp, nil
+}
+
+func (s *WebHDFSService) ListStatus(path string) ([]fileStatus, error) {
+	resp, err := s.do("GET", path, "LISTSTATUS", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError("LISTSTATUS", path, resp)
+	}
+	defer resp.Body.Close()
+	result := struct {
+		FileStatuses struct {
+			FileStatus []fileStatus `json:"FileStatus"`
+		} `json:"FileStatuses"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.FileStatuses.FileStatus, nil
+}
+
+func (s *WebHDFSService) GetFileStatus(path string) (*fileStatus, error) {
+	resp, err := s.do("GET", path, "GETFILESTATUS", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError("GETFILESTATUS", path, resp)
+	}
+	defer resp.Body.Close()
+	result := struct {
+		FileStatus fileStatus `json:"FileStatus"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result.FileStatus, nil
+}
+
+func (s *WebHDFSService) Open(path string) (io.ReadCloser, error) {
+	resp, err := s.do("GET", path, "OPEN", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError("OPEN", path, resp)
+	}
+	return resp.Body, nil
+}
+
+// Create writes the file in two steps as WebHDFS required: asks NameNode for
+// the DataNode location, then sends the data there. Parent directories would
+// be created automatically.
+func (s *WebHDFSService) Create(path string, r io.Reader, length int64) error {
+	resp, err := s.do("PUT", path, "CREATE", map[string]string{"overwrite": "true"}, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		return responseError("CREATE", path, resp)
+	}
+	resp.Body.Close()
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return fmt.Errorf("WebHDFS Error: CREATE %v redirected without location", path)
+	}
+
+	req, err := http.NewRequest("PUT", location, ioutil.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = length
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err = s.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return responseError("CREATE", path, resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Delete removes the file or directory recursively, it's not an error if
+// the path doesn't exist
+func (s *WebHDFSService) Delete(path string) error {
+	resp, err := s.do("DELETE", path, "DELETE", map[string]string{"recursive": "true"}, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return responseError("DELETE", path, resp)
+	}
+	resp.Body.Close()
+	return nil
+}
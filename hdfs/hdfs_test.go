@@ -0,0 +1,171 @@
+package hdfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"gopkg.in/check.v1"
+)
+
+func TestHDFS(t *testing.T) { check.TestingT(t) }
+
+// fakeWebHDFS serves NameNode requests on WEBHDFS_PREFIX, and redirects the
+// data to the DataNode handler on /datanode
+type fakeWebHDFS struct {
+	c      *check.C
+	files  map[string][]byte
+	server *httptest.Server
+}
+
+func (f *fakeWebHDFS) notFound(w http.ResponseWriter, path string) {
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"RemoteException": map[string]string{
+			"exception": "FileNotFoundException",
+			"message":   "File does not exist: " + path,
+		},
+	})
+}
+
+func (f *fakeWebHDFS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/datanode") {
+		path := strings.TrimPrefix(r.URL.Path, "/datanode")
+		switch r.Method {
+		case "GET":
+			w.Write(f.files[path])
+		case "PUT":
+			data, _ := ioutil.ReadAll(r.Body)
+			f.files[path] = data
+			w.WriteHeader(http.StatusCreated)
+		}
+		return
+	}
+
+	f.c.Check(r.URL.Query().Get("user.name"), check.Equals, "hdfs")
+	path := strings.TrimPrefix(r.URL.Path, WEBHDFS_PREFIX)
+	children := map[string]fileStatus{}
+	for name, data := range f.files {
+		if !strings.HasPrefix(name, path+"/") {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(name, path+"/"), "/", 2)
+		st := fileStatus{PathSuffix: parts[0], Type: FILE_TYPE_FILE, Length: int64(len(data))}
+		if len(parts) > 1 {
+			st = fileStatus{PathSuffix: parts[0], Type: FILE_TYPE_DIRECTORY}
+		}
+		children[parts[0]] = st
+	}
+	data, isFile := f.files[path]
+
+	switch r.URL.Query().Get("op") {
+	case "LISTSTATUS":
+		if len(children) == 0 && path != "/backups" {
+			f.notFound(w, path)
+			return
+		}
+		statuses := []fileStatus{}
+		for _, st := range children {
+			statuses = append(statuses, st)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"FileStatuses": map[string]interface{}{"FileStatus": statuses},
+		})
+	case "GETFILESTATUS":
+		st := fileStatus{Type: FILE_TYPE_FILE, Length: int64(len(data))}
+		if !isFile {
+			if len(children) == 0 {
+				f.notFound(w, path)
+				return
+			}
+			st = fileStatus{Type: FILE_TYPE_DIRECTORY}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"FileStatus": st})
+	case "OPEN":
+		if !isFile {
+			f.notFound(w, path)
+			return
+		}
+		http.Redirect(w, r, f.server.URL+"/datanode"+path, http.StatusTemporaryRedirect)
+	case "CREATE":
+		f.c.Check(r.URL.Query().Get("overwrite"), check.Equals, "true")
+		http.Redirect(w, r, f.server.URL+"/datanode"+path, http.StatusTemporaryRedirect)
+	case "DELETE":
+		for name := range f.files {
+			if name == path || strings.HasPrefix(name, path+"/") {
+				delete(f.files, name)
+			}
+		}
+		w.Write([]byte(`{"boolean": true}`))
+	}
+}
+
+type HDFSTestSuite struct {
+	fake *fakeWebHDFS
+}
+
+var _ = check.Suite(&HDFSTestSuite{})
+
+func (s *HDFSTestSuite) SetUpTest(c *check.C) {
+	s.fake = &fakeWebHDFS{
+		c:     c,
+		files: make(map[string][]byte),
+	}
+	s.fake.server = httptest.NewServer(s.fake)
+	os.Setenv(ENV_USER_NAME, "hdfs")
+}
+
+func (s *HDFSTestSuite) TearDownTest(c *check.C) {
+	s.fake.server.Close()
+}
+
+func (s *HDFSTestSuite) destURL(path string) string {
+	return strings.Replace(s.fake.server.URL, "http://", KIND+"://", 1) + path
+}
+
+func (s *HDFSTestSuite) TestInitFunc(c *check.C) {
+	driver, err := initFunc(s.destURL("/backups/"), "")
+	c.Assert(err, check.IsNil)
+	c.Check(driver.GetURL(), check.Equals, s.destURL("/backups"))
+	c.Check(driver.Kind(), check.Equals, KIND)
+
+	_, err = initFunc(s.destURL("/nonexistent"), "")
+	c.Check(err, check.ErrorMatches, ".*FileNotFoundException.*")
+
+	_, err = initFunc("webhdfs:///backups", "")
+	c.Check(err, check.NotNil)
+}
+
+func (s *HDFSTestSuite) TestReadWriteRemove(c *check.C) {
+	driver, err := initFunc(s.destURL("/backups"), "")
+	c.Assert(err, check.IsNil)
+
+	dst := "convoy-objectstore/volumes/vol/blocks/block"
+	c.Assert(driver.Write(dst, bytes.NewReader([]byte("data"))), check.IsNil)
+	c.Assert(driver.Write(dst+"2", bytes.NewReader([]byte("data2"))), check.IsNil)
+	c.Check(s.fake.files["/backups/"+dst], check.DeepEquals, []byte("data"))
+	c.Check(driver.FileSize(dst), check.Equals, int64(4))
+	c.Check(driver.FileExists("convoy-objectstore/volumes"), check.Equals, false)
+	c.Check(driver.FileExists(dst+"3"), check.Equals, false)
+
+	names, err := driver.List("convoy-objectstore/volumes/vol/blocks")
+	c.Assert(err, check.IsNil)
+	sort.Strings(names)
+	c.Check(names, check.DeepEquals, []string{"block", "block2"})
+
+	rc, err := driver.Read(dst)
+	c.Assert(err, check.IsNil)
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	c.Assert(err, check.IsNil)
+	c.Check(string(data), check.Equals, "data")
+
+	c.Assert(driver.Remove("convoy-objectstore/volumes/vol"), check.IsNil)
+	c.Check(s.fake.files, check.HasLen, 0)
+}
@@ -0,0 +1,177 @@
+package ipfs
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/objectstore"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "ipfs"})
+)
+
+type IPFSObjectStoreDriver struct {
+	destURL        string
+	path           string
+	pinningService string
+	service        IPFSService
+}
+
+const (
+	KIND = "ipfs"
+
+	ENV_PINNING_SERVICE = "IPFS_PINNING_SERVICE"
+
+	PIN_NAME_PREFIX = "convoy:"
+)
+
+func init() {
+	if err := objectstore.RegisterDriver(KIND, initFunc); err != nil {
+		panic(err)
+	}
+}
+
+func initFunc(destURL, endpoint string) (objectstore.ObjectStoreDriver, error) {
+	return initFuncWithConnectionCheck(destURL, endpoint, func(d objectstore.ObjectStoreDriver) error {
+		_, err := d.List("")
+		return err
+	})
+}
+
+func initFuncWithConnectionCheck(destURL, endpoint string, connectionTest func(d objectstore.ObjectStoreDriver) error) (objectstore.ObjectStoreDriver, error) {
+	b := &IPFSObjectStoreDriver{}
+
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != KIND {
+		return nil, fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, KIND)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("Invalid URL. Must be %v://api_host:port/path/", KIND)
+	}
+	b.path = filepath.Clean("/" + u.Path)
+
+	b.service.APIURL = "http://" + u.Host
+	b.pinningService = os.Getenv(ENV_PINNING_SERVICE)
+
+	if err := connectionTest(b); err != nil {
+		return nil, err
+	}
+
+	b.destURL = KIND + "://" + u.Host + b.path
+	log.Debugf("Loaded driver for %v", b.destURL)
+	return b, nil
+}
+
+func (s *IPFSObjectStoreDriver) Kind() string {
+	return KIND
+}
+
+func (s *IPFSObjectStoreDriver) GetURL() string {
+	return s.destURL
+}
+
+func (s *IPFSObjectStoreDriver) updatePath(path string) string {
+	return filepath.Join(s.path, path)
+}
+
+func (s *IPFSObjectStoreDriver) List(listPath string) ([]string, error) {
+	names, err := s.service.List(s.updatePath(listPath))
+	if err != nil {
+		log.Error("Fail to list ipfs: ", err)
+		return nil, err
+	}
+	return names, nil
+}
+
+func (s *IPFSObjectStoreDriver) FileExists(filePath string) bool {
+	return s.FileSize(filePath) >= 0
+}
+
+func (s *IPFSObjectStoreDriver) FileSize(filePath string) int64 {
+	st, err := s.service.Stat(s.updatePath(filePath))
+	if err != nil || st.Type != FILE_TYPE_FILE {
+		return -1
+	}
+	return st.Size
+}
+
+func (s *IPFSObjectStoreDriver) Remove(names ...string) error {
+	for _, name := range names {
+		if err := s.service.Remove(s.updatePath(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *IPFSObjectStoreDriver) Read(src string) (io.ReadCloser, error) {
+	return s.service.Read(s.updatePath(src))
+}
+
+func (s *IPFSObjectStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	if err := s.service.Write(s.updatePath(dst), rs); err != nil {
+		return err
+	}
+	return s.pinVolume(dst)
+}
+
+func (s *IPFSObjectStoreDriver) Upload(src, dst string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := s.service.Write(s.updatePath(dst), file); err != nil {
+		return err
+	}
+	return s.pinVolume(dst)
+}
+
+// pinVolume pins the volume directory to the remote pinning service when the
+// volume config is updated, which is the last step of creating or deleting a
+// backup. So the backups can be retrieved from any gateway even if the node
+// is gone.
+func (s *IPFSObjectStoreDriver) pinVolume(dst string) error {
+	if s.pinningService == "" || filepath.Base(dst) != objectstore.VOLUME_CONFIG_FILE {
+		return nil
+	}
+	volumePath := s.updatePath(filepath.Dir(dst))
+	st, err := s.service.Stat(volumePath)
+	if err != nil {
+		return err
+	}
+	if err := s.service.Pin(st.Hash, s.pinningService, PIN_NAME_PREFIX+volumePath); err != nil {
+		return fmt.Errorf("Failed to pin %v(%v) to %v: %v", volumePath, st.Hash, s.pinningService, err)
+	}
+	log.Debugf("Pinned %v(%v) to %v", volumePath, st.Hash, s.pinningService)
+	return nil
+}
+
+func (s *IPFSObjectStoreDriver) Download(src, dst string) error {
+	if _, err := os.Stat(dst); err != nil {
+		os.Remove(dst)
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rc, err := s.service.Read(s.updatePath(src))
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(f, rc)
+	return err
+}
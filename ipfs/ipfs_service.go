@@ -0,0 +1,185 @@
+package ipfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	API_PREFIX = "/api/v0/"
+
+	FILE_TYPE_FILE      = "file"
+	FILE_TYPE_DIRECTORY = "directory"
+)
+
+// IPFSService talks to the HTTP RPC API of an IPFS node, e.g. Kubo. The files
+// are kept in the Mutable File System(MFS) of the node, which keeps the paths
+// of the objectstore on top of the content addressed blocks.
+type IPFSService struct {
+	// APIURL is the URL of RPC API, e.g. http://127.0.0.1:5001
+	APIURL string
+
+	client *http.Client
+}
+
+type fileStat struct {
+	Hash string
+	Size int64
+	Type string
+}
+
+type apiError struct {
+	Message string
+	Code    int
+}
+
+func (s *IPFSService) httpClient() *http.Client {
+	if s.client == nil {
+		s.client = &http.Client{}
+	}
+	return s.client
+}
+
+func isNotExist(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "does not exist")
+}
+
+// call sends the RPC, which always uses POST
+func (s *IPFSService) call(cmd string, params url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	u := strings.TrimRight(s.APIURL, "/") + API_PREFIX + cmd + "?" + params.Encode()
+	req, err := http.NewRequest("POST", u, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		e := &apiError{}
+		if err := json.Unmarshal(data, e); err == nil && e.Message != "" {
+			return nil, fmt.Errorf("IPFS Error: %v %v %v, %v", cmd, params.Get("arg"), resp.Status, e.Message)
+		}
+		return nil, fmt.Errorf("IPFS Error: %v %v %v, %v", cmd, params.Get("arg"), resp.Status, strings.TrimSpace(string(data)))
+	}
+	return resp, nil
+}
+
+func (s *IPFSService) callJSON(cmd string, params url.Values, v interface{}) error {
+	resp, err := s.call(cmd, params, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if v == nil {
+		_, err := io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (s *IPFSService) List(path string) ([]string, error) {
+	result := struct {
+		Entries []struct {
+			Name string
+		}
+	}{}
+	if err := s.callJSON("files/ls", url.Values{"arg": {path}}, &result); err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range result.Entries {
+		names = append(names, e.Name)
+	}
+	return names, nil
+}
+
+func (s *IPFSService) Stat(path string) (*fileStat, error) {
+	st := &fileStat{}
+	if err := s.callJSON("files/stat", url.Values{"arg": {path}}, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (s *IPFSService) Read(path string) (io.ReadCloser, error) {
+	resp, err := s.call("files/read", url.Values{"arg": {path}}, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Write replaces the file, parent directories would be created as well. The
+// blocks are stored with CIDv1, so they're addressed by SHA-256 like the
+// block files of convoy.
+func (s *IPFSService) Write(path string, r io.Reader) error {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	go func() {
+		part, err := writer.CreateFormFile("file", "data")
+		if err == nil {
+			_, err = io.Copy(part, r)
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	params := url.Values{
+		"arg":         {path},
+		"create":      {"true"},
+		"parents":     {"true"},
+		"truncate":    {"true"},
+		"cid-version": {"1"},
+		"raw-leaves":  {"true"},
+	}
+	resp, err := s.call("files/write", params, pr, writer.FormDataContentType())
+	// Unblock the writer if the request failed early
+	pr.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Remove removes the file or directory recursively, it's not an error if
+// the path doesn't exist
+func (s *IPFSService) Remove(path string) error {
+	err := s.callJSON("files/rm", url.Values{"arg": {path}, "recursive": {"true"}}, nil)
+	if isNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Pin pins the CID to the remote pinning service configured in the node, the
+// existing pins of the same name would be replaced
+func (s *IPFSService) Pin(cid, service, name string) error {
+	if err := s.callJSON("pin/remote/rm", url.Values{
+		"service": {service},
+		"name":    {name},
+		"force":   {"true"},
+	}, nil); err != nil {
+		return err
+	}
+	return s.callJSON("pin/remote/add", url.Values{
+		"arg":        {cid},
+		"service":    {service},
+		"name":       {name},
+		"background": {"true"},
+	}, nil)
+}
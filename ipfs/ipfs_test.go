@@ -0,0 +1,212 @@
+package ipfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rancher/convoy/objectstore"
+	"gopkg.in/check.v1"
+)
+
+func TestIPFS(t *testing.T) { check.TestingT(t) }
+
+type IPFSTestSuite struct {
+	server *httptest.Server
+	files  map[string][]byte
+	pins   map[string]string
+}
+
+var _ = check.Suite(&IPFSTestSuite{})
+
+func (s *IPFSTestSuite) isDir(p string) bool {
+	for f := range s.files {
+		if strings.HasPrefix(f, strings.TrimRight(p, "/")+"/") {
+			return true
+		}
+	}
+	return p == "/"
+}
+
+func (s *IPFSTestSuite) fail(w http.ResponseWriter, msg string) {
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(map[string]interface{}{"Message": msg, "Code": 0, "Type": "error"})
+}
+
+// ServeHTTP fakes the MFS API of IPFS node. Directories are implied by the
+// files in them.
+func (s *IPFSTestSuite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	arg := q.Get("arg")
+	switch strings.TrimPrefix(r.URL.Path, API_PREFIX) {
+	case "files/ls":
+		if !s.isDir(arg) {
+			s.fail(w, "file does not exist")
+			return
+		}
+		names := map[string]bool{}
+		prefix := strings.TrimRight(arg, "/") + "/"
+		for f := range s.files {
+			if strings.HasPrefix(f, prefix) {
+				names[strings.SplitN(strings.TrimPrefix(f, prefix), "/", 2)[0]] = true
+			}
+		}
+		sorted := []string{}
+		for name := range names {
+			sorted = append(sorted, name)
+		}
+		sort.Strings(sorted)
+		entries := []map[string]interface{}{}
+		for _, name := range sorted {
+			entries = append(entries, map[string]interface{}{"Name": name})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"Entries": entries})
+	case "files/stat":
+		if data, exists := s.files[arg]; exists {
+			sum := sha256.Sum256(data)
+			json.NewEncoder(w).Encode(fileStat{Hash: hex.EncodeToString(sum[:]), Size: int64(len(data)), Type: FILE_TYPE_FILE})
+			return
+		}
+		if !s.isDir(arg) {
+			s.fail(w, "file does not exist")
+			return
+		}
+		json.NewEncoder(w).Encode(fileStat{Hash: "dir:" + arg, Type: FILE_TYPE_DIRECTORY})
+	case "files/read":
+		data, exists := s.files[arg]
+		if !exists {
+			s.fail(w, "file does not exist")
+			return
+		}
+		w.Write(data)
+	case "files/write":
+		if q.Get("create") != "true" || q.Get("parents") != "true" || q.Get("truncate") != "true" {
+			s.fail(w, "bad options")
+			return
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			s.fail(w, err.Error())
+			return
+		}
+		s.files[arg], _ = ioutil.ReadAll(file)
+	case "files/rm":
+		if q.Get("recursive") != "true" {
+			s.fail(w, "recursive is required")
+			return
+		}
+		_, exists := s.files[arg]
+		if !exists && !s.isDir(arg) {
+			s.fail(w, "file does not exist")
+			return
+		}
+		for f := range s.files {
+			if f == arg || strings.HasPrefix(f, arg+"/") {
+				delete(s.files, f)
+			}
+		}
+	case "pin/remote/rm":
+		if q.Get("service") != "pinata" {
+			s.fail(w, "unknown service")
+			return
+		}
+		delete(s.pins, q.Get("name"))
+	case "pin/remote/add":
+		s.pins[q.Get("name")] = arg
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *IPFSTestSuite) SetUpTest(c *check.C) {
+	s.files = map[string][]byte{}
+	s.pins = map[string]string{}
+	s.server = httptest.NewServer(s)
+	os.Unsetenv(ENV_PINNING_SERVICE)
+}
+
+func (s *IPFSTestSuite) TearDownTest(c *check.C) {
+	s.server.Close()
+}
+
+func (s *IPFSTestSuite) url() string {
+	return "ipfs://" + strings.TrimPrefix(s.server.URL, "http://") + "/backups"
+}
+
+func (s *IPFSTestSuite) TestInitFunc(c *check.C) {
+	s.files["/backups/file"] = []byte("data")
+	driver, err := initFunc(s.url(), "")
+	c.Assert(err, check.IsNil)
+	c.Check(driver.GetURL(), check.Equals, s.url())
+
+	_, err = initFunc(strings.Replace(s.url(), "/backups", "/nonexist", 1), "")
+	c.Check(err, check.ErrorMatches, "IPFS Error: files/ls /nonexist 500 Internal Server Error, file does not exist")
+
+	_, err = initFuncWithConnectionCheck("ipfs:///backups", "", func(d objectstore.ObjectStoreDriver) error {
+		return nil
+	})
+	c.Check(err, check.NotNil)
+}
+
+func (s *IPFSTestSuite) TestReadWriteRemove(c *check.C) {
+	s.files["/backups/file"] = []byte("data")
+	driver, err := initFunc(s.url(), "")
+	c.Assert(err, check.IsNil)
+
+	filePath := "convoy-objectstore/volumes/vol/blocks/aa.blk"
+	c.Assert(driver.Write(filePath, bytes.NewReader([]byte("block"))), check.IsNil)
+	c.Check(driver.FileSize(filePath), check.Equals, int64(5))
+	c.Check(driver.FileExists("convoy-objectstore/volumes/vol/blocks"), check.Equals, false)
+
+	rc, err := driver.Read(filePath)
+	c.Assert(err, check.IsNil)
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	c.Assert(err, check.IsNil)
+	c.Check(string(data), check.Equals, "block")
+
+	names, err := driver.List("convoy-objectstore/volumes/vol")
+	c.Assert(err, check.IsNil)
+	c.Check(names, check.DeepEquals, []string{"blocks"})
+
+	c.Assert(driver.Remove("convoy-objectstore/volumes/vol"), check.IsNil)
+	c.Check(driver.FileExists(filePath), check.Equals, false)
+	c.Assert(driver.Remove("convoy-objectstore/volumes/vol"), check.IsNil)
+	// No pinning service
+	c.Check(s.pins, check.HasLen, 0)
+}
+
+func (s *IPFSTestSuite) TestPinVolume(c *check.C) {
+	s.files["/backups/file"] = []byte("data")
+	os.Setenv(ENV_PINNING_SERVICE, "pinata")
+	driver, err := initFunc(s.url(), "")
+	c.Assert(err, check.IsNil)
+
+	volumeDir := "convoy-objectstore/volumes/vol"
+	c.Assert(driver.Write(path.Join(volumeDir, "blocks/aa.blk"), bytes.NewReader([]byte("block"))), check.IsNil)
+	c.Check(s.pins, check.HasLen, 0)
+
+	c.Assert(driver.Write(path.Join(volumeDir, objectstore.VOLUME_CONFIG_FILE), bytes.NewReader([]byte("{}"))), check.IsNil)
+	c.Check(s.pins, check.DeepEquals, map[string]string{
+		PIN_NAME_PREFIX + "/backups/" + volumeDir: "dir:/backups/" + volumeDir,
+	})
+
+	os.Setenv(ENV_PINNING_SERVICE, "unknown")
+	driver, err = initFunc(s.url(), "")
+	c.Assert(err, check.IsNil)
+	err = driver.Write(path.Join(volumeDir, objectstore.VOLUME_CONFIG_FILE), bytes.NewReader([]byte("{}")))
+	c.Check(err, check.ErrorMatches, "Failed to pin .* to unknown: .*unknown service")
+}
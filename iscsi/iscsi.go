@@ -0,0 +1,794 @@
+package iscsi
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	DRIVER_NAME        = "iscsi"
+	DRIVER_CONFIG_FILE = "iscsi.cfg"
+
+	VOLUME_CFG_PREFIX = "volume_"
+	ISCSI_CFG_PREFIX  = DRIVER_NAME + "_"
+	CFG_POSTFIX       = ".json"
+
+	MOUNTS_DIR = "mounts"
+
+	ISCSIADM_BINARY = "iscsiadm"
+	BLKID_BINARY    = "blkid"
+
+	ISCSI_PORTAL        = "iscsi.portal"
+	ISCSI_CHAP_USERNAME = "iscsi.chapusername"
+	ISCSI_CHAP_PASSWORD = "iscsi.chappassword"
+	ISCSI_HOOK          = "iscsi.hook"
+	ISCSI_DEFAULT_FS    = "iscsi.fs"
+
+	DEFAULT_PORT    = "3260"
+	DEFAULT_FS_TYPE = "ext4"
+
+	HOOK_CREATE_VOLUME   = "create-volume"
+	HOOK_DELETE_VOLUME   = "delete-volume"
+	HOOK_CREATE_SNAPSHOT = "create-snapshot"
+	HOOK_DELETE_SNAPSHOT = "delete-snapshot"
+
+	DEVICE_WAIT_TIMEOUT  = 30 * time.Second
+	DEVICE_WAIT_INTERVAL = 500 * time.Millisecond
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "iscsi"})
+)
+
+type Driver struct {
+	mutex *sync.RWMutex
+	Device
+}
+
+/*
+Device is the iSCSI portal the LUNs are attached from. The array specific
+operations, e.g. provisioning LUNs and taking snapshots, are delegated to the
+hook executable, which would be called as:
+
+	<hook> create-volume <name> <size>          prints "<target> <lun>"
+	<hook> delete-volume <target> <lun>
+	<hook> create-snapshot <target> <lun> <snapshot>   may print snapshot ID
+	<hook> delete-snapshot <target> <lun> <snapshot ID>
+*/
+type Device struct {
+	Root         string
+	Portal       string
+	ChapUsername string
+	ChapPassword string
+	Hook         string
+	Filesystem   string
+}
+
+func (dev *Device) ConfigFile() (string, error) {
+	if dev.Root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty device config path")
+	}
+	return filepath.Join(dev.Root, DRIVER_CONFIG_FILE), nil
+}
+
+type Volume struct {
+	Name        string
+	Target      string
+	LUN         int
+	Provisioned bool
+	MountPoint  string
+	CreatedTime string
+	Filesystem  string
+	Snapshots   map[string]Snapshot
+
+	configPath string
+	portal     string
+}
+
+type Snapshot struct {
+	Name        string
+	ArrayID     string
+	CreatedTime string
+	SnapshotMetadata
+}
+
+func (v *Volume) ConfigFile() (string, error) {
+	if v.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if v.configPath == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume config path")
+	}
+	return filepath.Join(v.configPath, ISCSI_CFG_PREFIX+VOLUME_CFG_PREFIX+v.Name+CFG_POSTFIX), nil
+}
+
+func (v *Volume) GetDevice() (string, error) {
+	return devicePath(v.portal, v.Target, v.LUN), nil
+}
+
+func (v *Volume) GetMountOpts() []string {
+	return []string{}
+}
+
+func (v *Volume) GenerateDefaultMountPoint() string {
+	return filepath.Join(v.configPath, MOUNTS_DIR, v.Name)
+}
+
+func init() {
+	if err := Register(DRIVER_NAME, Init); err != nil {
+		panic(err)
+	}
+}
+
+func (d *Driver) Name() string {
+	return DRIVER_NAME
+}
+
+func (device *Device) listVolumeNames() ([]string, error) {
+	return util.ListConfigIDs(device.Root, ISCSI_CFG_PREFIX+VOLUME_CFG_PREFIX, CFG_POSTFIX)
+}
+
+func (d *Driver) blankVolume(name string) *Volume {
+	return &Volume{
+		configPath: d.Root,
+		portal:     d.Portal,
+		Name:       name,
+	}
+}
+
+// normalizePortal appends the default port to the portal if it's not
+// specified, as the path of device would always contain the port
+func normalizePortal(portal string) (string, error) {
+	if _, _, err := net.SplitHostPort(portal); err == nil {
+		return portal, nil
+	}
+	if strings.Contains(portal, ":") && !strings.HasPrefix(portal, "[") {
+		portal = "[" + portal + "]"
+	}
+	portal = portal + ":" + DEFAULT_PORT
+	if _, _, err := net.SplitHostPort(portal); err != nil {
+		return "", fmt.Errorf("Invalid iSCSI portal %v", portal)
+	}
+	return portal, nil
+}
+
+// parseLUN parses the volume driver ID in the format of "<target>:<lun>".
+// The target IQN may contain colons as well, so the last one is used.
+func parseLUN(id string) (string, int, error) {
+	idx := strings.LastIndex(id, ":")
+	if idx <= 0 {
+		return "", 0, fmt.Errorf("Invalid LUN %v, must be <target>:<lun>", id)
+	}
+	lun, err := strconv.Atoi(id[idx+1:])
+	if err != nil || lun < 0 {
+		return "", 0, fmt.Errorf("Invalid LUN number in %v", id)
+	}
+	return id[:idx], lun, nil
+}
+
+func devicePath(portal, target string, lun int) string {
+	return fmt.Sprintf("/dev/disk/by-path/ip-%v-iscsi-%v-lun-%v", portal, target, lun)
+}
+
+func execute(binary string, args ...string) (string, error) {
+	cmd := exec.Command(binary, args...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("Failed to execute %v %v: %v, %v", binary, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (d *Driver) iscsiadmNode(target string, args ...string) (string, error) {
+	return execute(ISCSIADM_BINARY, append([]string{"-m", "node", "-T", target, "-p", d.Portal}, args...)...)
+}
+
+func (d *Driver) callHook(args ...string) (string, error) {
+	if d.Hook == "" {
+		return "", fmt.Errorf("No %v specified, cannot %v", ISCSI_HOOK, args[0])
+	}
+	return execute(d.Hook, args...)
+}
+
+func (d *Driver) isLoggedIn(target string) bool {
+	out, err := execute(ISCSIADM_BINARY, "-m", "session")
+	if err != nil {
+		// iscsiadm would fail if there is no session at all
+		return false
+	}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		// e.g. tcp: [1] 10.0.0.1:3260,1 iqn.2016-01.com.example:target (non-flash)
+		if len(fields) >= 4 && fields[3] == target && strings.HasPrefix(fields[2], d.Portal+",") {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Driver) login(target string) error {
+	if d.isLoggedIn(target) {
+		return nil
+	}
+	if _, err := execute(ISCSIADM_BINARY, "-m", "discovery", "-t", "sendtargets", "-p", d.Portal); err != nil {
+		return err
+	}
+	if d.ChapUsername != "" {
+		settings := [][]string{
+			{"node.session.auth.authmethod", "CHAP"},
+			{"node.session.auth.username", d.ChapUsername},
+			{"node.session.auth.password", d.ChapPassword},
+		}
+		for _, s := range settings {
+			if _, err := d.iscsiadmNode(target, "-o", "update", "-n", s[0], "-v", s[1]); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := d.iscsiadmNode(target, "--login"); err != nil {
+		return err
+	}
+	log.Debugf("Logged into iSCSI target %v at %v", target, d.Portal)
+	return nil
+}
+
+// logout from the target if none of the volumes on it is still in use
+func (d *Driver) logout(target, except string) error {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return err
+	}
+	for _, id := range volumeIDs {
+		if id == except {
+			continue
+		}
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return err
+		}
+		if volume.Target == target && volume.MountPoint != "" {
+			return nil
+		}
+	}
+	if !d.isLoggedIn(target) {
+		return nil
+	}
+	if _, err := d.iscsiadmNode(target, "--logout"); err != nil {
+		return err
+	}
+	log.Debugf("Logged out from iSCSI target %v at %v", target, d.Portal)
+	return nil
+}
+
+func waitForDevice(dev string) error {
+	for start := time.Now(); time.Since(start) < DEVICE_WAIT_TIMEOUT; time.Sleep(DEVICE_WAIT_INTERVAL) {
+		if _, err := os.Stat(dev); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("Timed out waiting for device %v", dev)
+}
+
+// hasFilesystem returns false if blkid cannot find any filesystem signature
+// on the device
+func hasFilesystem(dev string) (bool, error) {
+	cmd := exec.Command(BLKID_BINARY, "-o", "value", "-s", "TYPE", dev)
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && !exitErr.Success() && len(out) == 0 {
+			return false, nil
+		}
+		return false, err
+	}
+	return strings.TrimSpace(string(out)) != "", nil
+}
+
+func Init(root string, config map[string]string) (ConvoyDriver, error) {
+	if _, err := exec.LookPath(ISCSIADM_BINARY); err != nil {
+		return nil, fmt.Errorf("Cannot find %v binary, please make sure open-iscsi is installed", ISCSIADM_BINARY)
+	}
+
+	dev := &Device{
+		Root: root,
+	}
+	exists, err := util.ObjectExists(dev)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if err := util.ObjectLoad(dev); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := util.MkdirIfNotExists(root); err != nil {
+			return nil, err
+		}
+		if config[ISCSI_PORTAL] == "" {
+			return nil, fmt.Errorf("Missing required parameter: %v", ISCSI_PORTAL)
+		}
+		if dev.Portal, err = normalizePortal(config[ISCSI_PORTAL]); err != nil {
+			return nil, err
+		}
+		dev.ChapUsername = config[ISCSI_CHAP_USERNAME]
+		dev.ChapPassword = config[ISCSI_CHAP_PASSWORD]
+		if dev.ChapUsername != "" && dev.ChapPassword == "" {
+			return nil, fmt.Errorf("Missing required parameter: %v", ISCSI_CHAP_PASSWORD)
+		}
+		if hook := config[ISCSI_HOOK]; hook != "" {
+			if dev.Hook, err = exec.LookPath(hook); err != nil {
+				return nil, fmt.Errorf("Invalid hook %v: %v", hook, err)
+			}
+		}
+		if _, exists := config[ISCSI_DEFAULT_FS]; !exists {
+			config[ISCSI_DEFAULT_FS] = DEFAULT_FS_TYPE
+		}
+		dev.Filesystem = config[ISCSI_DEFAULT_FS]
+		if dev.Filesystem != "ext4" && dev.Filesystem != "xfs" {
+			return nil, fmt.Errorf("Unsupported filesystem type specified")
+		}
+	}
+
+	if err := util.ObjectSave(dev); err != nil {
+		return nil, err
+	}
+	d := &Driver{
+		mutex:  &sync.RWMutex{},
+		Device: *dev,
+	}
+	if err := d.remountVolumes(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// remountVolumes logs into the targets and mounts the volumes again after the
+// host restarted
+func (d *Driver) remountVolumes() error {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return err
+	}
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return err
+		}
+		if volume.MountPoint == "" {
+			continue
+		}
+		if _, err := d.mountVolume(volume, volume.MountPoint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) Info() (map[string]string, error) {
+	return map[string]string{
+		"Driver":     d.Name(),
+		"Root":       d.Root,
+		"Portal":     d.Portal,
+		"CHAP":       strconv.FormatBool(d.ChapUsername != ""),
+		"Hook":       d.Hook,
+		"Filesystem": d.Filesystem,
+	}, nil
+}
+
+func (d *Driver) VolumeOps() (VolumeOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) SnapshotOps() (SnapshotOperations, error) {
+	if d.Hook == "" {
+		return nil, fmt.Errorf("Snapshot operations need %v to be specified", ISCSI_HOOK)
+	}
+	return d, nil
+}
+
+func (d *Driver) BackupOps() (BackupOperations, error) {
+	return nil, fmt.Errorf("Doesn't support backup operations")
+}
+
+func (d *Driver) CreateVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	opts := req.Options
+
+	volume := d.blankVolume(id)
+	exists, err := util.ObjectExists(volume)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Volume %v already exists", id)
+	}
+	if opts[OPT_BACKUP_URL] != "" {
+		return fmt.Errorf("Doesn't support restoring from backup")
+	}
+
+	if lun := opts[OPT_VOLUME_DRIVER_ID]; lun != "" {
+		if volume.Target, volume.LUN, err = parseLUN(lun); err != nil {
+			return err
+		}
+		if err := d.checkLUNUnused(volume.Target, volume.LUN); err != nil {
+			return err
+		}
+	} else {
+		if d.Hook == "" {
+			return fmt.Errorf("LUN must be specified by --id unless %v is specified", ISCSI_HOOK)
+		}
+		size := opts[OPT_SIZE]
+		if size == "" {
+			size = "0"
+		}
+		sizeInBytes, err := util.ParseSize(size)
+		if err != nil {
+			return err
+		}
+		out, err := d.callHook(HOOK_CREATE_VOLUME, id, strconv.FormatInt(sizeInBytes, 10))
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(out)
+		if len(fields) != 2 {
+			return fmt.Errorf("Invalid output of %v: %v", HOOK_CREATE_VOLUME, out)
+		}
+		if volume.Target, volume.LUN, err = parseLUN(fields[0] + ":" + fields[1]); err != nil {
+			return err
+		}
+		volume.Provisioned = true
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_START,
+		LOG_FIELD_EVENT:  LOG_EVENT_CREATE,
+		LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME: id,
+	}).Debugf("Attaching LUN %v of %v", volume.LUN, volume.Target)
+	if err := d.login(volume.Target); err != nil {
+		return err
+	}
+	dev, _ := volume.GetDevice()
+	if err := waitForDevice(dev); err != nil {
+		return err
+	}
+	formatted, err := hasFilesystem(dev)
+	if err != nil {
+		return err
+	}
+	if formatted {
+		log.Debugf("Found existing filesystem on %v, reuse it", dev)
+	} else {
+		log.Debugf("Formatting device %s with %s filesystem", dev, d.Filesystem)
+		if _, err := util.Execute("mkfs", []string{"-t", d.Filesystem, dev}); err != nil {
+			return err
+		}
+	}
+
+	volume.Filesystem = d.Filesystem
+	volume.CreatedTime = util.Now()
+	volume.Snapshots = make(map[string]Snapshot)
+	if err := util.ObjectSave(volume); err != nil {
+		return err
+	}
+	return d.logout(volume.Target, id)
+}
+
+func (d *Driver) checkLUNUnused(target string, lun int) error {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return err
+	}
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return err
+		}
+		if volume.Target == target && volume.LUN == lun {
+			return fmt.Errorf("LUN %v of %v is already used by volume %v", lun, target, id)
+		}
+	}
+	return nil
+}
+
+func (d *Driver) DeleteVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if volume.MountPoint != "" {
+		return fmt.Errorf("Cannot delete volume %v. It is still mounted", id)
+	}
+
+	referenceOnly, _ := strconv.ParseBool(req.Options[OPT_REFERENCE_ONLY])
+	if volume.Provisioned && !referenceOnly {
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_REASON: LOG_REASON_START,
+			LOG_FIELD_EVENT:  LOG_EVENT_REMOVE,
+			LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
+			LOG_FIELD_VOLUME: id,
+		}).Debugf("Removing LUN %v of %v", volume.LUN, volume.Target)
+		for _, snapshot := range volume.Snapshots {
+			if _, err := d.callHook(HOOK_DELETE_SNAPSHOT, volume.Target, strconv.Itoa(volume.LUN), snapshot.ArrayID); err != nil {
+				return err
+			}
+		}
+		if _, err := d.callHook(HOOK_DELETE_VOLUME, volume.Target, strconv.Itoa(volume.LUN)); err != nil {
+			return err
+		}
+	}
+	return util.ObjectDelete(volume)
+}
+
+func (d *Driver) MountVolume(req Request) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	return d.mountVolume(volume, req.Options[OPT_MOUNT_POINT])
+}
+
+func (d *Driver) mountVolume(volume *Volume, mountPoint string) (string, error) {
+	if err := d.login(volume.Target); err != nil {
+		return "", err
+	}
+	dev, _ := volume.GetDevice()
+	if err := waitForDevice(dev); err != nil {
+		return "", err
+	}
+	mountPoint, err := util.VolumeMount(volume, mountPoint, false)
+	if err != nil {
+		return "", err
+	}
+	if err := util.ObjectSave(volume); err != nil {
+		return "", err
+	}
+	return mountPoint, nil
+}
+
+func (d *Driver) UmountVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if err := util.VolumeUmount(volume); err != nil {
+		return err
+	}
+	if err := util.ObjectSave(volume); err != nil {
+		return err
+	}
+	return d.logout(volume.Target, volume.Name)
+}
+
+func (d *Driver) MountPoint(req Request) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	return volume.MountPoint, nil
+}
+
+func (d *Driver) GetVolumeInfo(id string) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.getVolumeInfo(id)
+}
+
+func (d *Driver) getVolumeInfo(id string) (map[string]string, error) {
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+	dev, _ := volume.GetDevice()
+	return map[string]string{
+		"Target":                volume.Target,
+		"LUN":                   strconv.Itoa(volume.LUN),
+		"Device":                dev,
+		"Provisioned":           strconv.FormatBool(volume.Provisioned),
+		OPT_VOLUME_NAME:         volume.Name,
+		OPT_VOLUME_CREATED_TIME: volume.CreatedTime,
+		OPT_MOUNT_POINT:         volume.MountPoint,
+		OPT_FILESYSTEM:          volume.Filesystem,
+	}, nil
+}
+
+func (d *Driver) ListVolume(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	volumes := make(map[string]map[string]string)
+	for _, id := range volumeIDs {
+		volumes[id], err = d.getVolumeInfo(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return volumes, nil
+}
+
+func (d *Driver) CreateSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if _, exists := volume.Snapshots[id]; exists {
+		return fmt.Errorf("Snapshot %v already exists for volume %v", id, volumeID)
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_CREATE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: id,
+		LOG_FIELD_VOLUME:   volumeID,
+	}).Debugf("Creating snapshot of LUN %v of %v through hook", volume.LUN, volume.Target)
+	if volume.MountPoint != "" {
+		log.Debugf("syncing filesystems...")
+		if err := util.Sync(); err != nil {
+			return err
+		}
+	}
+	arrayID, err := d.callHook(HOOK_CREATE_SNAPSHOT, volume.Target, strconv.Itoa(volume.LUN), id)
+	if err != nil {
+		return err
+	}
+	if arrayID == "" {
+		arrayID = id
+	}
+	if volume.Snapshots == nil {
+		volume.Snapshots = make(map[string]Snapshot)
+	}
+	volume.Snapshots[id] = Snapshot{
+		Name:             id,
+		ArrayID:          arrayID,
+		CreatedTime:      util.Now(),
+		SnapshotMetadata: NewSnapshotMetadata(req.Options),
+	}
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) getSnapshotAndVolume(snapshotID, volumeID string) (*Snapshot, *Volume, error) {
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, nil, err
+	}
+	snapshot, exists := volume.Snapshots[snapshotID]
+	if !exists {
+		return nil, nil, fmt.Errorf("Cannot find snapshot %v of volume %v", snapshotID, volumeID)
+	}
+	return &snapshot, volume, nil
+}
+
+func (d *Driver) DeleteSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+	snapshot, volume, err := d.getSnapshotAndVolume(req.Name, volumeID)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_REMOVE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: req.Name,
+		LOG_FIELD_VOLUME:   volumeID,
+	}).Debugf("Removing snapshot %v through hook", snapshot.ArrayID)
+	if _, err := d.callHook(HOOK_DELETE_SNAPSHOT, volume.Target, strconv.Itoa(volume.LUN), snapshot.ArrayID); err != nil {
+		return err
+	}
+	delete(volume.Snapshots, req.Name)
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) GetSnapshotInfo(req Request) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return nil, err
+	}
+	return d.getSnapshotInfo(req.Name, volumeID)
+}
+
+func (d *Driver) getSnapshotInfo(id, volumeID string) (map[string]string, error) {
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		OPT_SNAPSHOT_NAME:         snapshot.Name,
+		OPT_SNAPSHOT_LABELS:       snapshot.Labels,
+		OPT_SNAPSHOT_DESCRIPTION:  snapshot.Description,
+		OPT_SNAPSHOT_CREATED_TIME: snapshot.CreatedTime,
+		"VolumeUUID":              volumeID,
+		"Target":                  volume.Target,
+		"LUN":                     strconv.Itoa(volume.LUN),
+		"ArrayID":                 snapshot.ArrayID,
+	}, nil
+}
+
+func (d *Driver) ListSnapshot(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	var (
+		volumeIDs []string
+		err       error
+	)
+	snapshots := make(map[string]map[string]string)
+	specifiedVolumeID, _ := util.GetFieldFromOpts(OPT_VOLUME_NAME, opts)
+	if specifiedVolumeID != "" {
+		volumeIDs = []string{
+			specifiedVolumeID,
+		}
+	} else {
+		volumeIDs, err = d.listVolumeNames()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, volumeID := range volumeIDs {
+		volume := d.blankVolume(volumeID)
+		if err := util.ObjectLoad(volume); err != nil {
+			return nil, err
+		}
+		for snapshotID := range volume.Snapshots {
+			snapshots[snapshotID], err = d.getSnapshotInfo(snapshotID, volumeID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return snapshots, nil
+}
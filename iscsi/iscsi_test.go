@@ -0,0 +1,60 @@
+package iscsi
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct{}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) TestPortal(c *C) {
+	portal, err := normalizePortal("10.0.0.1")
+	c.Assert(err, IsNil)
+	c.Assert(portal, Equals, "10.0.0.1:3260")
+
+	portal, err = normalizePortal("10.0.0.1:3261")
+	c.Assert(err, IsNil)
+	c.Assert(portal, Equals, "10.0.0.1:3261")
+
+	portal, err = normalizePortal("fe80::1")
+	c.Assert(err, IsNil)
+	c.Assert(portal, Equals, "[fe80::1]:3260")
+}
+
+func (s *TestSuite) TestLUN(c *C) {
+	target, lun, err := parseLUN("iqn.2016-01.com.example:storage.target1:3")
+	c.Assert(err, IsNil)
+	c.Assert(target, Equals, "iqn.2016-01.com.example:storage.target1")
+	c.Assert(lun, Equals, 3)
+
+	_, _, err = parseLUN("iqn.2016-01.com.example:storage.target1")
+	c.Assert(err, ErrorMatches, "Invalid LUN number in .*")
+	_, _, err = parseLUN("3")
+	c.Assert(err, ErrorMatches, "Invalid LUN 3, must be <target>:<lun>")
+
+	c.Assert(devicePath("10.0.0.1:3260", target, lun), Equals,
+		"/dev/disk/by-path/ip-10.0.0.1:3260-iscsi-iqn.2016-01.com.example:storage.target1-lun-3")
+}
+
+func (s *TestSuite) TestHook(c *C) {
+	d := &Driver{}
+	_, err := d.callHook(HOOK_CREATE_SNAPSHOT, "target", "0", "snap1")
+	c.Assert(err, ErrorMatches, "No iscsi.hook specified, cannot create-snapshot")
+
+	d.Hook = filepath.Join(c.MkDir(), "hook")
+	script := "#!/bin/sh\n[ \"$1\" = create-snapshot ] || exit 1\necho \"$2:$3@$4\"\n"
+	c.Assert(ioutil.WriteFile(d.Hook, []byte(script), 0700), IsNil)
+	out, err := d.callHook(HOOK_CREATE_SNAPSHOT, "target", "0", "snap1")
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, "target:0@snap1")
+
+	_, err = d.callHook(HOOK_DELETE_SNAPSHOT, "target", "0", "snap1")
+	c.Assert(err, ErrorMatches, "Failed to execute .*hook delete-snapshot target 0 snap1: exit status 1.*")
+}
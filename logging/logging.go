@@ -29,6 +29,7 @@ const (
 	LOG_FIELD_FILEPATH      = "filepath"
 	LOG_FIELD_CONTEXT       = "context"
 	LOG_FIELD_OPTS          = "opts"
+	LOG_FIELD_EXPIRES_AT    = "expires_at"
 
 	LOG_FIELD_EVENT      = "event"
 	LOG_EVENT_INIT       = "init"
@@ -52,6 +53,16 @@ const (
 	LOG_EVENT_COMPARE    = "compare"
 	LOG_EVENT_UPLOAD     = "upload"
 	LOG_EVENT_DOWNLOAD   = "download"
+	LOG_EVENT_VERIFY     = "verify"
+	LOG_EVENT_UPGRADE    = "upgrade"
+	LOG_EVENT_EXPORT     = "export"
+	LOG_EVENT_IMPORT     = "import"
+	LOG_EVENT_REPLICATE  = "replicate"
+	LOG_EVENT_EXTRACT    = "extract"
+	LOG_EVENT_REPAIR     = "repair"
+	LOG_EVENT_ROTATE     = "rotate"
+	LOG_EVENT_HOOK       = "hook"
+	LOG_EVENT_REVERT     = "revert"
 
 	LOG_FIELD_REASON    = "reason"
 	LOG_REASON_PREPARE  = "prepare"
@@ -0,0 +1,1006 @@
+package loopback
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/metadata"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	DRIVER_NAME        = "loopback"
+	DRIVER_CONFIG_FILE = "loopback.cfg"
+
+	VOLUME_CFG_PREFIX = "volume_"
+	CFG_PREFIX        = DRIVER_NAME + "_"
+	CFG_POSTFIX       = ".json"
+
+	MOUNTS_DIR    = "mounts"
+	IMAGES_DIR    = "images"
+	SNAPSHOTS_DIR = "snapshots"
+	IMAGE_POSTFIX = ".img"
+
+	LOOPBACK_PATH                = "loopback.path"
+	LOOPBACK_DEFAULT_VOLUME_SIZE = "loopback.defaultvolumesize"
+	LOOPBACK_DEFAULT_FS_TYPE     = "loopback.fs"
+
+	DEFAULT_VOLUME_SIZE = "10G"
+	DEFAULT_FS_TYPE     = "ext4"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "loopback"})
+)
+
+type Driver struct {
+	mutex *sync.RWMutex
+	Device
+}
+
+type Device struct {
+	Root              string
+	Path              string
+	DefaultVolumeSize int64
+	Filesystem        string
+}
+
+func (dev *Device) ConfigFile() (string, error) {
+	if dev.Root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty device config path")
+	}
+	return filepath.Join(dev.Root, DRIVER_CONFIG_FILE), nil
+}
+
+type Volume struct {
+	Name        string
+	File        string
+	Device      string
+	Size        int64
+	MountPoint  string
+	CreatedTime string
+	Filesystem  string
+	Snapshots   map[string]Snapshot
+
+	configPath string
+}
+
+type Snapshot struct {
+	Name        string
+	File        string
+	CreatedTime string
+	// The read-only loopback device and where it's mounted, see
+	// MountSnapshot
+	Device     string `json:",omitempty"`
+	MountPoint string `json:",omitempty"`
+	SnapshotMetadata
+}
+
+func (v *Volume) ConfigFile() (string, error) {
+	if v.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if v.configPath == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume config path")
+	}
+	return filepath.Join(v.configPath, CFG_PREFIX+VOLUME_CFG_PREFIX+v.Name+CFG_POSTFIX), nil
+}
+
+func (v *Volume) GetDevice() (string, error) {
+	if v.Device == "" {
+		return "", fmt.Errorf("Volume %v is not attached to loopback device", v.Name)
+	}
+	return v.Device, nil
+}
+
+func (v *Volume) GetMountOpts() []string {
+	return []string{}
+}
+
+func (v *Volume) GenerateDefaultMountPoint() string {
+	return filepath.Join(v.configPath, MOUNTS_DIR, v.Name)
+}
+
+func init() {
+	if err := Register(DRIVER_NAME, Init); err != nil {
+		panic(err)
+	}
+}
+
+func (d *Driver) Name() string {
+	return DRIVER_NAME
+}
+
+func (d *Driver) listVolumeNames() ([]string, error) {
+	return util.ListConfigIDs(d.Root, CFG_PREFIX+VOLUME_CFG_PREFIX, CFG_POSTFIX)
+}
+
+func (d *Driver) blankVolume(name string) *Volume {
+	return &Volume{
+		configPath: d.Root,
+		Name:       name,
+	}
+}
+
+func (d *Driver) imageFile(name string) string {
+	return filepath.Join(d.Path, name+IMAGE_POSTFIX)
+}
+
+func (d *Driver) snapshotFile(volumeName, name string) string {
+	return filepath.Join(d.Path, SNAPSHOTS_DIR, volumeName, name+IMAGE_POSTFIX)
+}
+
+// attach returns the loopback device of the file, and would attach one if
+// it's not attached yet
+func attach(file string) (string, error) {
+	devices, err := util.ListLoopbackDevice(file)
+	if err != nil {
+		return "", err
+	}
+	if len(devices) != 0 {
+		return devices[0], nil
+	}
+	return util.AttachLoopbackDevice(file, false)
+}
+
+// createSparseFile creates the file with size without allocating any blocks
+func createSparseFile(file string, size int64) error {
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Truncate(size)
+}
+
+// copySparseFile copies the file while keeping the holes, or shares the
+// blocks if the filesystem supports reflink
+func copySparseFile(src, dst string) error {
+	_, err := util.Execute("cp", []string{"--reflink=auto", "--sparse=always", src, dst})
+	return err
+}
+
+func verifyConfig(root string, config map[string]string) (*Device, error) {
+	dev := &Device{
+		Root: root,
+		Path: config[LOOPBACK_PATH],
+	}
+	if dev.Path == "" {
+		dev.Path = filepath.Join(root, IMAGES_DIR)
+	}
+
+	if config[LOOPBACK_DEFAULT_VOLUME_SIZE] == "" {
+		config[LOOPBACK_DEFAULT_VOLUME_SIZE] = DEFAULT_VOLUME_SIZE
+	}
+	size, err := util.ParseSize(config[LOOPBACK_DEFAULT_VOLUME_SIZE])
+	if err != nil || size == 0 {
+		return nil, fmt.Errorf("Illegal default volume size specified")
+	}
+	dev.DefaultVolumeSize = size
+
+	if config[LOOPBACK_DEFAULT_FS_TYPE] == "" {
+		config[LOOPBACK_DEFAULT_FS_TYPE] = DEFAULT_FS_TYPE
+	}
+	fsType := config[LOOPBACK_DEFAULT_FS_TYPE]
+	if _, err := exec.LookPath("mkfs." + fsType); err != nil {
+		return nil, fmt.Errorf("Unsupported filesystem type %v, cannot find mkfs.%v", fsType, fsType)
+	}
+	dev.Filesystem = fsType
+	return dev, nil
+}
+
+func (d *Driver) remountVolumes() error {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return err
+	}
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return err
+		}
+		if volume.MountPoint == "" {
+			continue
+		}
+		req := Request{
+			Name:    id,
+			Options: map[string]string{},
+		}
+		if _, err := d.MountVolume(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Init(root string, config map[string]string) (ConvoyDriver, error) {
+	if _, err := exec.LookPath("losetup"); err != nil {
+		return nil, fmt.Errorf("Cannot find losetup binary, please make sure util-linux is installed")
+	}
+
+	if err := util.MkdirIfNotExists(root); err != nil {
+		return nil, err
+	}
+	dev := &Device{
+		Root: root,
+	}
+	exists, err := util.ObjectExists(dev)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if err := util.ObjectLoad(dev); err != nil {
+			return nil, err
+		}
+	} else {
+		if dev, err = verifyConfig(root, config); err != nil {
+			return nil, err
+		}
+		if err := util.MkdirIfNotExists(dev.Path); err != nil {
+			return nil, err
+		}
+		if err := util.ObjectSave(dev); err != nil {
+			return nil, err
+		}
+	}
+	d := &Driver{
+		mutex:  &sync.RWMutex{},
+		Device: *dev,
+	}
+	if err := d.remountVolumes(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Driver) Info() (map[string]string, error) {
+	return map[string]string{
+		"Driver":            d.Name(),
+		"Root":              d.Root,
+		"Path":              d.Path,
+		"DefaultVolumeSize": strconv.FormatInt(d.DefaultVolumeSize, 10),
+		"Filesystem":        d.Filesystem,
+	}, nil
+}
+
+func (d *Driver) VolumeOps() (VolumeOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) SnapshotOps() (SnapshotOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) getSize(opts map[string]string, defaultVolumeSize int64) (int64, error) {
+	size := opts[OPT_SIZE]
+	if size == "" || size == "0" {
+		size = strconv.FormatInt(defaultVolumeSize, 10)
+	}
+	return util.ParseSize(size)
+}
+
+func (d *Driver) CreateVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var (
+		size int64
+		err  error
+	)
+	id := req.Name
+	opts := req.Options
+
+	backupURL := opts[OPT_BACKUP_URL]
+	endpointURL := opts[OPT_ENDPOINT_URL]
+	if backupURL != "" {
+		objVolume, err := objectstore.LoadVolume(backupURL, endpointURL)
+		if err != nil {
+			return err
+		}
+		if err := objectstore.CheckRestoreDriver(objVolume, backupURL, endpointURL, d.Name()); err != nil {
+			return err
+		}
+		size, err = d.getSize(opts, objVolume.Size)
+		if err != nil {
+			return err
+		}
+		if size < objVolume.Size {
+			return fmt.Errorf("Volume size cannot be less than backup's size %v", objVolume.Size)
+		}
+	} else {
+		size, err = d.getSize(opts, d.DefaultVolumeSize)
+		if err != nil {
+			return err
+		}
+	}
+	if size%objectstore.DEFAULT_BLOCK_SIZE != 0 {
+		return fmt.Errorf("Size must be multiple of %v", objectstore.DEFAULT_BLOCK_SIZE)
+	}
+
+	volume := d.blankVolume(id)
+	exists, err := util.ObjectExists(volume)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Already has volume with specific uuid %v", id)
+	}
+
+	format := opts[OPT_FORMAT] != "false"
+	file := d.imageFile(id)
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_START,
+		LOG_FIELD_EVENT:  LOG_EVENT_CREATE,
+		LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME: id,
+	}).Debugf("Creating sparse file %v", file)
+	if backupURL != "" {
+		// The restore would only write the blocks in the backup, and
+		// truncate the file to the volume size
+		if err := objectstore.RestoreDeltaBlockBackupToSize(backupURL, endpointURL, file, size); err != nil {
+			os.Remove(file)
+			return err
+		}
+	} else {
+		if err := createSparseFile(file, size); err != nil {
+			return err
+		}
+		// The file would be left raw if it's used by a layered driver
+		if format {
+			if err := d.format(file); err != nil {
+				os.Remove(file)
+				return err
+			}
+		}
+	}
+
+	volume.File = file
+	volume.Size = size
+	volume.CreatedTime = util.Now()
+	if format {
+		volume.Filesystem = d.Filesystem
+	}
+	volume.Snapshots = make(map[string]Snapshot)
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) format(file string) error {
+	dev, err := util.AttachLoopbackDevice(file, false)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := util.DetachLoopbackDevice(file, dev); err != nil {
+			log.Warnf("Failed to detach %v from %v: %v", dev, file, err)
+		}
+	}()
+
+	log.Debugf("Formatting device %s with %s filesystem", dev, d.Filesystem)
+	_, err = util.Execute("mkfs", []string{"-t", d.Filesystem, dev})
+	return err
+}
+
+func (d *Driver) DeleteVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	if volume.MountPoint != "" {
+		return fmt.Errorf("Cannot delete volume %s, it hasn't been umounted", id)
+	}
+	for snapshotID := range volume.Snapshots {
+		if err := d.deleteSnapshot(snapshotID, volume); err != nil {
+			return err
+		}
+	}
+	if err := util.DetachAnyLoopbackDevice(volume.File); err != nil {
+		return err
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_START,
+		LOG_FIELD_EVENT:  LOG_EVENT_REMOVE,
+		LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME: id,
+	}).Debugf("Removing sparse file %v", volume.File)
+	if err := os.Remove(volume.File); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	os.Remove(filepath.Dir(d.snapshotFile(id, "")))
+	return util.ObjectDelete(volume)
+}
+
+func (d *Driver) AttachVolume(req Request) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+
+	dev, err := attach(volume.File)
+	if err != nil {
+		return "", err
+	}
+	volume.Device = dev
+	if err := util.ObjectSave(volume); err != nil {
+		return "", err
+	}
+	return dev, nil
+}
+
+func (d *Driver) DetachVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if volume.MountPoint != "" {
+		return fmt.Errorf("Cannot detach volume %v, it hasn't been umounted", req.Name)
+	}
+
+	if err := util.DetachAnyLoopbackDevice(volume.File); err != nil {
+		return err
+	}
+	volume.Device = ""
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) MountVolume(req Request) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+
+	dev, err := attach(volume.File)
+	if err != nil {
+		return "", err
+	}
+	volume.Device = dev
+
+	mountPoint, err := util.VolumeMount(volume, req.Options[OPT_MOUNT_POINT], false)
+	if err != nil {
+		return "", err
+	}
+
+	if err := util.ObjectSave(volume); err != nil {
+		return "", err
+	}
+	return mountPoint, nil
+}
+
+func (d *Driver) UmountVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	if err := util.VolumeUmount(volume); err != nil {
+		return err
+	}
+	// The loopback device is only held when mounted
+	if err := util.DetachAnyLoopbackDevice(volume.File); err != nil {
+		return err
+	}
+	volume.Device = ""
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) MountPoint(req Request) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	return volume.MountPoint, nil
+}
+
+func (d *Driver) GetVolumeInfo(id string) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.getVolumeInfo(id)
+}
+
+// allocatedSize returns the size of the blocks allocated for the sparse file
+func allocatedSize(file string) (int64, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return 0, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("Cannot get allocated blocks of %v", file)
+	}
+	// st_blocks is always in the unit of 512 bytes
+	return stat.Blocks * 512, nil
+}
+
+func (d *Driver) getVolumeInfo(id string) (map[string]string, error) {
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+	allocated, err := allocatedSize(volume.File)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"File":                  volume.File,
+		"Device":                volume.Device,
+		"AllocatedSize":         strconv.FormatInt(allocated, 10),
+		OPT_VOLUME_NAME:         volume.Name,
+		OPT_VOLUME_CREATED_TIME: volume.CreatedTime,
+		OPT_MOUNT_POINT:         volume.MountPoint,
+		OPT_SIZE:                strconv.FormatInt(volume.Size, 10),
+		OPT_FILESYSTEM:          volume.Filesystem,
+	}, nil
+}
+
+func (d *Driver) ListVolume(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	volumes := make(map[string]map[string]string)
+	for _, id := range volumeIDs {
+		volumes[id], err = d.getVolumeInfo(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return volumes, nil
+}
+
+func (d *Driver) CreateSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if _, exists := volume.Snapshots[id]; exists {
+		return fmt.Errorf("Snapshot %v already exists for volume %v", id, volumeID)
+	}
+
+	file := d.snapshotFile(volumeID, id)
+	if err := util.MkdirIfNotExists(filepath.Dir(file)); err != nil {
+		return err
+	}
+
+	// The filesystem must be quiesced while copying, since the copy is
+	// not atomic
+	if volume.MountPoint != "" {
+		if err := util.Sync(); err != nil {
+			return err
+		}
+		if err := util.Freeze(volume.MountPoint); err != nil {
+			return err
+		}
+		defer func() {
+			if err := util.UnFreeze(volume.MountPoint); err != nil {
+				log.Errorf("Failed to unfreeze %v: %v", volume.MountPoint, err)
+			}
+		}()
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_CREATE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: id,
+		LOG_FIELD_VOLUME:   volumeID,
+	}).Debugf("Copying %v to %v", volume.File, file)
+	if err := copySparseFile(volume.File, file); err != nil {
+		os.Remove(file)
+		return err
+	}
+
+	volume.Snapshots[id] = Snapshot{
+		Name:             id,
+		File:             file,
+		CreatedTime:      util.Now(),
+		SnapshotMetadata: NewSnapshotMetadata(req.Options),
+	}
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) DeleteSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	return d.deleteSnapshot(req.Name, volume)
+}
+
+func (d *Driver) deleteSnapshot(id string, volume *Volume) error {
+	snapshot, exists := volume.Snapshots[id]
+	if !exists {
+		return fmt.Errorf("Cannot find snapshot %v of volume %v", id, volume.Name)
+	}
+	if snapshot.MountPoint != "" {
+		return fmt.Errorf("Cannot delete snapshot %v of volume %v, which is mounted at %v", id, volume.Name, snapshot.MountPoint)
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_REMOVE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: id,
+		LOG_FIELD_VOLUME:   volume.Name,
+	}).Debugf("Removing snapshot file %v", snapshot.File)
+	if err := os.Remove(snapshot.File); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(volume.Snapshots, id)
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) snapshotMount(snapshot *Snapshot, volume *Volume) *util.SnapshotMount {
+	return &util.SnapshotMount{
+		Name:       snapshot.Name,
+		MountPoint: snapshot.MountPoint,
+		Device:     snapshot.Device,
+		Filesystem: volume.Filesystem,
+		MountsDir:  filepath.Join(d.Root, util.SNAPSHOT_MOUNTS_DIR, volume.Name),
+	}
+}
+
+// MountSnapshot attaches the file of the snapshot to a read-only loopback
+// device, and mounts it read-only
+func (d *Driver) MountSnapshot(req Request) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return "", err
+	}
+	snapshot, volume, err := d.getSnapshotAndVolume(req.Name, volumeID)
+	if err != nil {
+		return "", err
+	}
+	if volume.Filesystem == "" {
+		return "", fmt.Errorf("Cannot mount snapshot %v of volume %v, which has no filesystem", req.Name, volumeID)
+	}
+
+	attached := false
+	if snapshot.Device == "" {
+		if snapshot.Device, err = util.AttachLoopbackDevice(snapshot.File, true); err != nil {
+			return "", err
+		}
+		attached = true
+	}
+	mountPoint, err := util.VolumeMount(d.snapshotMount(snapshot, volume), req.Options[OPT_MOUNT_POINT], false)
+	if err != nil {
+		if attached {
+			if err := util.DetachLoopbackDevice(snapshot.File, snapshot.Device); err != nil {
+				log.Errorf("Failed to detach %v from %v: %v", snapshot.File, snapshot.Device, err)
+			}
+		}
+		return "", err
+	}
+	snapshot.MountPoint = mountPoint
+	volume.Snapshots[req.Name] = *snapshot
+	if err := util.ObjectSave(volume); err != nil {
+		return "", err
+	}
+	return mountPoint, nil
+}
+
+func (d *Driver) UmountSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+	snapshot, volume, err := d.getSnapshotAndVolume(req.Name, volumeID)
+	if err != nil {
+		return err
+	}
+	if snapshot.MountPoint == "" {
+		return nil
+	}
+	if err := util.SnapshotUmount(d.snapshotMount(snapshot, volume)); err != nil {
+		return err
+	}
+	if err := util.DetachLoopbackDevice(snapshot.File, snapshot.Device); err != nil {
+		// The device may have been detached by reboot
+		log.Warnf("Failed to detach %v from %v: %v", snapshot.File, snapshot.Device, err)
+	}
+	snapshot.Device = ""
+	snapshot.MountPoint = ""
+	volume.Snapshots[req.Name] = *snapshot
+	return util.ObjectSave(volume)
+}
+
+// CreateVolumeFromSnapshot copies the file of the snapshot as the file of the
+// new volume, which shares the blocks if the filesystem supports reflink
+func (d *Driver) CreateVolumeFromSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	snapshotID, err := util.GetFieldFromOpts(OPT_SNAPSHOT_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+	volumeID, err := util.GetFieldFromOpts(OPT_SNAPSHOT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+	snapshot, origin, err := d.getSnapshotAndVolume(snapshotID, volumeID)
+	if err != nil {
+		return err
+	}
+
+	volume := d.blankVolume(id)
+	exists, err := util.ObjectExists(volume)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Already has volume with specific uuid %v", id)
+	}
+
+	file := d.imageFile(id)
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_CREATE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME:   id,
+		LOG_FIELD_SNAPSHOT: snapshotID,
+	}).Debugf("Copying %v to %v", snapshot.File, file)
+	if err := copySparseFile(snapshot.File, file); err != nil {
+		os.Remove(file)
+		return err
+	}
+
+	volume.File = file
+	volume.Size = origin.Size
+	volume.CreatedTime = util.Now()
+	volume.Filesystem = origin.Filesystem
+	volume.Snapshots = make(map[string]Snapshot)
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) getSnapshotAndVolume(snapshotID, volumeID string) (*Snapshot, *Volume, error) {
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, nil, err
+	}
+	snapshot, exists := volume.Snapshots[snapshotID]
+	if !exists {
+		return nil, nil, fmt.Errorf("Cannot find snapshot %v of volume %v", snapshotID, volumeID)
+	}
+	return &snapshot, volume, nil
+}
+
+func (d *Driver) GetSnapshotInfo(req Request) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return nil, err
+	}
+	return d.getSnapshotInfo(req.Name, volumeID)
+}
+
+func (d *Driver) getSnapshotInfo(id, volumeID string) (map[string]string, error) {
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		OPT_SNAPSHOT_NAME:         snapshot.Name,
+		OPT_SNAPSHOT_LABELS:       snapshot.Labels,
+		OPT_SNAPSHOT_DESCRIPTION:  snapshot.Description,
+		OPT_SNAPSHOT_CREATED_TIME: snapshot.CreatedTime,
+		OPT_SIZE:                  strconv.FormatInt(volume.Size, 10),
+		"VolumeUUID":              volumeID,
+		"File":                    snapshot.File,
+		OPT_MOUNT_POINT:           snapshot.MountPoint,
+	}, nil
+}
+
+func (d *Driver) ListSnapshot(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	var (
+		volumeIDs []string
+		err       error
+	)
+	snapshots := make(map[string]map[string]string)
+	specifiedVolumeID, _ := util.GetFieldFromOpts(OPT_VOLUME_NAME, opts)
+	if specifiedVolumeID != "" {
+		volumeIDs = []string{
+			specifiedVolumeID,
+		}
+	} else {
+		volumeIDs, err = d.listVolumeNames()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, volumeID := range volumeIDs {
+		volume := d.blankVolume(volumeID)
+		if err := util.ObjectLoad(volume); err != nil {
+			return nil, err
+		}
+		for snapshotID := range volume.Snapshots {
+			snapshots[snapshotID], err = d.getSnapshotInfo(snapshotID, volumeID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return snapshots, nil
+}
+
+func (d *Driver) BackupOps() (BackupOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) HasSnapshot(id, volumeID string) bool {
+	_, _, err := d.getSnapshotAndVolume(id, volumeID)
+	return err == nil
+}
+
+func (d *Driver) CompareSnapshot(id, compareID, volumeID string) (*metadata.Mappings, error) {
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	compareFile := ""
+	if compareID != "" && compareID != id {
+		compareSnapshot, exists := volume.Snapshots[compareID]
+		if !exists {
+			return nil, fmt.Errorf("Cannot find snapshot %v of volume %v", compareID, volumeID)
+		}
+		compareFile = compareSnapshot.File
+	}
+	return metadata.CompareFiles(snapshot.File, compareFile, objectstore.DEFAULT_BLOCK_SIZE)
+}
+
+func (d *Driver) OpenSnapshot(id, volumeID string) error {
+	return nil
+}
+
+func (d *Driver) ReadSnapshot(id, volumeID string, offset int64, data []byte) error {
+	snapshot, _, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(snapshot.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.ReadAt(data, offset)
+	return err
+}
+
+func (d *Driver) CloseSnapshot(id, volumeID string) error {
+	return nil
+}
+
+func (d *Driver) CreateBackup(snapshotID, volumeID, destURL, endpointURL string, opts map[string]string) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+
+	objVolume := &objectstore.Volume{
+		Name:        volumeID,
+		Driver:      d.Name(),
+		Size:        volume.Size,
+		CreatedTime: opts[OPT_VOLUME_CREATED_TIME],
+	}
+	objSnapshot := &objectstore.Snapshot{
+		Name:        snapshotID,
+		CreatedTime: opts[OPT_SNAPSHOT_CREATED_TIME],
+		ExportImage: opts[OPT_EXPORT_IMAGE],
+		Labels:      objectstore.DecodeLabels(opts[OPT_BACKUP_LABELS]),
+	}
+	return objectstore.CreateDeltaBlockBackup(objVolume, objSnapshot, destURL, endpointURL, d)
+}
+
+func (d *Driver) EstimateBackup(snapshotID, volumeID, destURL, endpointURL string, opts map[string]string) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+
+	objVolume := &objectstore.Volume{
+		Name:   volumeID,
+		Driver: d.Name(),
+		Size:   volume.Size,
+	}
+	objSnapshot := &objectstore.Snapshot{
+		Name: snapshotID,
+	}
+	return objectstore.EstimateDeltaBlockBackup(objVolume, objSnapshot, destURL, endpointURL, d)
+}
+
+func (d *Driver) DeleteBackup(backupURL, endpointURL string, opts map[string]string) error {
+	objVolume, err := objectstore.LoadVolume(backupURL, endpointURL)
+	if err != nil {
+		return err
+	}
+	if objVolume.Driver != d.Name() {
+		return fmt.Errorf("BUG: Wrong driver handling DeleteBackup(), driver should be %v but is %v", objVolume.Driver, d.Name())
+	}
+	force, _ := strconv.ParseBool(opts[OPT_FORCE])
+	return objectstore.DeleteDeltaBlockBackup(backupURL, endpointURL, force)
+}
+
+func (d *Driver) GetBackupInfo(backupURL, endpointURL string) (map[string]string, error) {
+	objVolume, err := objectstore.LoadVolume(backupURL, endpointURL)
+	if err != nil {
+		return nil, err
+	}
+	if objVolume.Driver != d.Name() {
+		return nil, fmt.Errorf("BUG: Wrong driver handling GetBackupInfo(), driver should be %v but is %v", objVolume.Driver, d.Name())
+	}
+	return objectstore.GetBackupInfo(backupURL, endpointURL)
+}
+
+func (d *Driver) ListBackup(destURL, endpointURL string, opts map[string]string) (map[string]map[string]string, error) {
+	return objectstore.List(opts[OPT_VOLUME_NAME], destURL, endpointURL, d.Name())
+}
@@ -0,0 +1,171 @@
+package loopback
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "gopkg.in/check.v1"
+)
+
+const (
+	testBlockSize = 4096
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct {
+	dir string
+}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) SetUpTest(c *C) {
+	var err error
+	s.dir, err = ioutil.TempDir("", "convoy-loopback")
+	c.Assert(err, IsNil)
+}
+
+func (s *TestSuite) TearDownTest(c *C) {
+	os.RemoveAll(s.dir)
+}
+
+func (s *TestSuite) writeAt(c *C, file string, offset int64, data string) {
+	f, err := os.OpenFile(file, os.O_WRONLY, 0)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	_, err = f.WriteAt([]byte(data), offset)
+	c.Assert(err, IsNil)
+}
+
+func (s *TestSuite) TestSparseFile(c *C) {
+	file := filepath.Join(s.dir, "vol1.img")
+	c.Assert(createSparseFile(file, 1024*testBlockSize), IsNil)
+	c.Assert(createSparseFile(file, testBlockSize), NotNil)
+
+	info, err := os.Stat(file)
+	c.Assert(err, IsNil)
+	c.Assert(info.Size(), Equals, int64(1024*testBlockSize))
+	allocated, err := allocatedSize(file)
+	c.Assert(err, IsNil)
+	c.Assert(allocated < info.Size(), Equals, true)
+
+	s.writeAt(c, file, 3*testBlockSize, "data")
+	snapshot := filepath.Join(s.dir, "snap1.img")
+	c.Assert(copySparseFile(file, snapshot), IsNil)
+	info, err = os.Stat(snapshot)
+	c.Assert(err, IsNil)
+	c.Assert(info.Size(), Equals, int64(1024*testBlockSize))
+}
+
+func (s *TestSuite) TestVerifyConfig(c *C) {
+	config := map[string]string{}
+	dev, err := verifyConfig(s.dir, config)
+	if err != nil {
+		c.Skip("mkfs.ext4 is not available")
+	}
+	c.Assert(dev.Path, Equals, filepath.Join(s.dir, IMAGES_DIR))
+	c.Assert(dev.DefaultVolumeSize, Equals, int64(10*1024*1024*1024))
+	c.Assert(dev.Filesystem, Equals, "ext4")
+
+	_, err = verifyConfig(s.dir, map[string]string{LOOPBACK_DEFAULT_FS_TYPE: "nonexistentfs"})
+	c.Assert(err, ErrorMatches, "Unsupported filesystem type nonexistentfs.*")
+	_, err = verifyConfig(s.dir, map[string]string{LOOPBACK_DEFAULT_VOLUME_SIZE: "0"})
+	c.Assert(err, ErrorMatches, "Illegal default volume size specified")
+}
+
+func (s *TestSuite) TestCreateVolumeFromSnapshot(c *C) {
+	d := &Driver{
+		mutex: &sync.RWMutex{},
+		Device: Device{
+			Root: s.dir,
+			Path: filepath.Join(s.dir, IMAGES_DIR),
+		},
+	}
+	snapshotFile := d.snapshotFile("vol1", "snap1")
+	c.Assert(os.MkdirAll(filepath.Dir(snapshotFile), 0700), IsNil)
+	c.Assert(os.MkdirAll(d.Path, 0700), IsNil)
+	c.Assert(createSparseFile(snapshotFile, 1024*testBlockSize), IsNil)
+	s.writeAt(c, snapshotFile, 3*testBlockSize, "data")
+
+	origin := d.blankVolume("vol1")
+	origin.File = d.imageFile("vol1")
+	origin.Size = 1024 * testBlockSize
+	origin.Filesystem = "ext4"
+	origin.Snapshots = map[string]Snapshot{
+		"snap1": {Name: "snap1", File: snapshotFile},
+	}
+	c.Assert(util.ObjectSave(origin), IsNil)
+
+	req := Request{
+		Name: "vol2",
+		Options: map[string]string{
+			OPT_SNAPSHOT_NAME:        "snap1",
+			OPT_SNAPSHOT_VOLUME_NAME: "vol1",
+		},
+	}
+	c.Assert(d.CreateVolumeFromSnapshot(req), IsNil)
+	volume := d.blankVolume("vol2")
+	c.Assert(util.ObjectLoad(volume), IsNil)
+	c.Assert(volume.File, Equals, d.imageFile("vol2"))
+	c.Assert(volume.Size, Equals, origin.Size)
+	c.Assert(volume.Filesystem, Equals, "ext4")
+	c.Assert(volume.Snapshots, HasLen, 0)
+
+	data, err := ioutil.ReadFile(volume.File)
+	c.Assert(err, IsNil)
+	c.Assert(int64(len(data)), Equals, origin.Size)
+	c.Assert(string(data[3*testBlockSize:3*testBlockSize+4]), Equals, "data")
+
+	c.Assert(d.CreateVolumeFromSnapshot(req), ErrorMatches, "Already has volume.*")
+	req.Options[OPT_SNAPSHOT_NAME] = "snap2"
+	req.Name = "vol3"
+	c.Assert(d.CreateVolumeFromSnapshot(req), ErrorMatches, "Cannot find snapshot snap2 of volume vol1")
+}
+
+func (s *TestSuite) TestSnapshotLabels(c *C) {
+	d := &Driver{
+		mutex: &sync.RWMutex{},
+		Device: Device{
+			Root: s.dir,
+			Path: filepath.Join(s.dir, IMAGES_DIR),
+		},
+	}
+	c.Assert(os.MkdirAll(d.Path, 0700), IsNil)
+	volume := d.blankVolume("vol1")
+	volume.File = d.imageFile("vol1")
+	volume.Size = 16 * testBlockSize
+	volume.Snapshots = map[string]Snapshot{}
+	c.Assert(createSparseFile(volume.File, volume.Size), IsNil)
+	c.Assert(util.ObjectSave(volume), IsNil)
+
+	c.Assert(d.CreateSnapshot(Request{
+		Name: "snap1",
+		Options: map[string]string{
+			OPT_VOLUME_NAME:          "vol1",
+			OPT_SNAPSHOT_LABELS:      "app=postgres,env=prod",
+			OPT_SNAPSHOT_DESCRIPTION: "before upgrade",
+		},
+	}), IsNil)
+	info, err := d.GetSnapshotInfo(Request{
+		Name:    "snap1",
+		Options: map[string]string{OPT_VOLUME_NAME: "vol1"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(info[OPT_SNAPSHOT_LABELS], Equals, "app=postgres,env=prod")
+	c.Assert(info[OPT_SNAPSHOT_DESCRIPTION], Equals, "before upgrade")
+
+	c.Assert(d.CreateSnapshot(Request{
+		Name:    "snap2",
+		Options: map[string]string{OPT_VOLUME_NAME: "vol1"},
+	}), IsNil)
+	snapshots, err := d.ListSnapshot(map[string]string{OPT_VOLUME_NAME: "vol1"})
+	c.Assert(err, IsNil)
+	c.Assert(snapshots["snap2"][OPT_SNAPSHOT_LABELS], Equals, "")
+	c.Assert(snapshots["snap2"][OPT_SNAPSHOT_DESCRIPTION], Equals, "")
+}
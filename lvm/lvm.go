@@ -0,0 +1,860 @@
+package lvm
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/metadata"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	DRIVER_NAME        = "lvm"
+	DRIVER_CONFIG_FILE = "lvm.cfg"
+
+	VOLUME_CFG_PREFIX = "volume_"
+	LVM_CFG_PREFIX    = DRIVER_NAME + "_"
+	CFG_POSTFIX       = ".json"
+
+	MOUNTS_DIR = "mounts"
+	DM_DIR     = "/dev/mapper"
+
+	SNAPSHOT_LV_INFIX = "_snap_"
+
+	THIN_DELTA_BINARY      = "thin_delta"
+	THIN_DELTA_MIN_VERSION = "0.5.1"
+
+	LVM_VOLUME_GROUP        = "lvm.volumegroup"
+	LVM_THIN_POOL           = "lvm.thinpool"
+	LVM_CONVERT_POOL        = "lvm.convertpool"
+	LVM_DEFAULT_VOLUME_SIZE = "lvm.defaultvolumesize"
+	LVM_DEFAULT_FS_TYPE     = "lvm.fs"
+
+	DEFAULT_VOLUME_SIZE = "100G"
+	DEFAULT_FS_TYPE     = "ext4"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "lvm"})
+)
+
+type Driver struct {
+	mutex *sync.RWMutex
+	Device
+}
+
+type Device struct {
+	Root              string
+	VolumeGroup       string
+	ThinPool          string
+	ChunkSize         int64
+	DefaultVolumeSize int64
+	Filesystem        string
+}
+
+func (dev *Device) ConfigFile() (string, error) {
+	if dev.Root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty device config path")
+	}
+	return filepath.Join(dev.Root, DRIVER_CONFIG_FILE), nil
+}
+
+type Volume struct {
+	Name        string
+	VolumeGroup string
+	LV          string
+	Size        int64
+	MountPoint  string
+	CreatedTime string
+	Filesystem  string
+	Snapshots   map[string]Snapshot
+
+	configPath string
+}
+
+type Snapshot struct {
+	Name        string
+	LV          string
+	CreatedTime string
+	SnapshotMetadata
+}
+
+func (v *Volume) ConfigFile() (string, error) {
+	if v.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if v.configPath == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume config path")
+	}
+	return filepath.Join(v.configPath, LVM_CFG_PREFIX+VOLUME_CFG_PREFIX+v.Name+CFG_POSTFIX), nil
+}
+
+func (v *Volume) GetDevice() (string, error) {
+	return lvPath(v.VolumeGroup, v.LV), nil
+}
+
+func (v *Volume) GetMountOpts() []string {
+	return []string{}
+}
+
+func (v *Volume) GenerateDefaultMountPoint() string {
+	return filepath.Join(v.configPath, MOUNTS_DIR, v.Name)
+}
+
+func init() {
+	if err := Register(DRIVER_NAME, Init); err != nil {
+		panic(err)
+	}
+}
+
+func (d *Driver) Name() string {
+	return DRIVER_NAME
+}
+
+func (device *Device) listVolumeNames() ([]string, error) {
+	return util.ListConfigIDs(device.Root, LVM_CFG_PREFIX+VOLUME_CFG_PREFIX, CFG_POSTFIX)
+}
+
+func (d *Driver) blankVolume(name string) *Volume {
+	return &Volume{
+		configPath: d.Root,
+		Name:       name,
+	}
+}
+
+func lvPath(vg, lv string) string {
+	return filepath.Join("/dev", vg, lv)
+}
+
+// dmName returns the device mapper name of the LV, with the "-" in the
+// names doubled as LVM does
+func dmName(vg, lv string) string {
+	return strings.Replace(vg, "-", "--", -1) + "-" + strings.Replace(lv, "-", "--", -1)
+}
+
+// lvs returns the field of the LV. The stdout only is used since LVM may
+// print warnings to stderr.
+func lvs(vg, lv, field string) (string, error) {
+	out, err := exec.Command("lvs", "--noheadings", "--units", "b", "--nosuffix",
+		"-o", field, vg+"/"+lv).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("Failed to get %v of LV %v/%v: %v", field, vg, lv, string(exitErr.Stderr))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func lvsInt(vg, lv, field string) (int64, error) {
+	out, err := lvs(vg, lv, field)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(out, 10, 64)
+}
+
+func lvm(binary string, args ...string) error {
+	out, err := util.Execute(binary, args)
+	if err != nil {
+		return fmt.Errorf("Failed to execute %v %v: %v, output: %v", binary, strings.Join(args, " "), err, out)
+	}
+	return nil
+}
+
+// checkLVName rejects the names which are reserved by LVM
+func checkLVName(name string) error {
+	if strings.HasPrefix(name, "snapshot") || strings.HasPrefix(name, "pvmove") {
+		return fmt.Errorf("Invalid name %v for LVM, cannot start with \"snapshot\" or \"pvmove\"", name)
+	}
+	for _, reserved := range []string{"_cdata", "_cmeta", "_mimage", "_mlog", "_pmspare",
+		"_rimage", "_rmeta", "_tdata", "_tmeta", "_vorigin"} {
+		if strings.Contains(name, reserved) {
+			return fmt.Errorf("Invalid name %v for LVM, cannot contain %v", name, reserved)
+		}
+	}
+	return nil
+}
+
+func verifyConfig(config map[string]string) (*Device, error) {
+	dv := &Device{
+		VolumeGroup: config[LVM_VOLUME_GROUP],
+		ThinPool:    config[LVM_THIN_POOL],
+	}
+	if dv.VolumeGroup == "" || dv.ThinPool == "" {
+		return nil, fmt.Errorf("LVM volume group or thin pool unspecified")
+	}
+
+	attr, err := lvs(dv.VolumeGroup, dv.ThinPool, "lv_attr")
+	if err != nil {
+		return nil, err
+	}
+	// The first character of the attributes is "t" for thin pool
+	if !strings.HasPrefix(attr, "t") {
+		convert, _ := strconv.ParseBool(config[LVM_CONVERT_POOL])
+		if !convert {
+			return nil, fmt.Errorf("LV %v/%v is not a thin pool, set %v=true to convert it", dv.VolumeGroup, dv.ThinPool, LVM_CONVERT_POOL)
+		}
+		log.Infof("Converting LV %v/%v to thin pool", dv.VolumeGroup, dv.ThinPool)
+		if err := lvm("lvconvert", "-y", "--type", "thin-pool", dv.VolumeGroup+"/"+dv.ThinPool); err != nil {
+			return nil, err
+		}
+	}
+	if dv.ChunkSize, err = lvsInt(dv.VolumeGroup, dv.ThinPool, "chunk_size"); err != nil {
+		return nil, err
+	}
+
+	if _, exists := config[LVM_DEFAULT_VOLUME_SIZE]; !exists {
+		config[LVM_DEFAULT_VOLUME_SIZE] = DEFAULT_VOLUME_SIZE
+	}
+	volumeSize, err := util.ParseSize(config[LVM_DEFAULT_VOLUME_SIZE])
+	if err != nil || volumeSize == 0 {
+		return nil, fmt.Errorf("Illegal default volume size specified")
+	}
+	dv.DefaultVolumeSize = volumeSize
+
+	if _, exists := config[LVM_DEFAULT_FS_TYPE]; !exists {
+		config[LVM_DEFAULT_FS_TYPE] = DEFAULT_FS_TYPE
+	}
+	fsType := config[LVM_DEFAULT_FS_TYPE]
+	if fsType != "ext4" && fsType != "xfs" {
+		return nil, fmt.Errorf("Unsupported filesystem type specified")
+	}
+	dv.Filesystem = fsType
+	return dv, nil
+}
+
+func (d *Driver) remountVolumes() error {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return err
+	}
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return err
+		}
+		if volume.MountPoint == "" {
+			continue
+		}
+		if err := lvm("lvchange", "-ay", volume.VolumeGroup+"/"+volume.LV); err != nil {
+			return err
+		}
+		req := Request{
+			Name:    id,
+			Options: map[string]string{},
+		}
+		if _, err := d.MountVolume(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Init(root string, config map[string]string) (ConvoyDriver, error) {
+	for _, binary := range []string{"lvcreate", "lvs"} {
+		if _, err := exec.LookPath(binary); err != nil {
+			return nil, fmt.Errorf("Cannot find %v binary, please make sure LVM2 is installed", binary)
+		}
+	}
+
+	if err := util.MkdirIfNotExists(root); err != nil {
+		return nil, err
+	}
+	dev := &Device{
+		Root: root,
+	}
+	exists, err := util.ObjectExists(dev)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if err := util.ObjectLoad(dev); err != nil {
+			return nil, err
+		}
+		d := &Driver{
+			mutex:  &sync.RWMutex{},
+			Device: *dev,
+		}
+		if err := d.remountVolumes(); err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+
+	dev, err = verifyConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	dev.Root = root
+
+	if err := util.ObjectSave(dev); err != nil {
+		return nil, err
+	}
+	d := &Driver{
+		mutex:  &sync.RWMutex{},
+		Device: *dev,
+	}
+	return d, nil
+}
+
+func (d *Driver) Info() (map[string]string, error) {
+	return map[string]string{
+		"Driver":            d.Name(),
+		"Root":              d.Root,
+		"VolumeGroup":       d.VolumeGroup,
+		"ThinPool":          d.ThinPool,
+		"ChunkSize":         strconv.FormatInt(d.ChunkSize, 10),
+		"DefaultVolumeSize": strconv.FormatInt(d.DefaultVolumeSize, 10),
+		"Filesystem":        d.Filesystem,
+	}, nil
+}
+
+func (d *Driver) VolumeOps() (VolumeOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) SnapshotOps() (SnapshotOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) getSize(opts map[string]string, defaultVolumeSize int64) (int64, error) {
+	size := opts[OPT_SIZE]
+	if size == "" || size == "0" {
+		size = strconv.FormatInt(defaultVolumeSize, 10)
+	}
+	return util.ParseSize(size)
+}
+
+func (d *Driver) CreateVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var (
+		size int64
+		err  error
+	)
+	id := req.Name
+	opts := req.Options
+
+	if err := checkLVName(id); err != nil {
+		return err
+	}
+
+	backupURL := opts[OPT_BACKUP_URL]
+	endpointURL := opts[OPT_ENDPOINT_URL]
+	if backupURL != "" {
+		objVolume, err := objectstore.LoadVolume(backupURL, endpointURL)
+		if err != nil {
+			return err
+		}
+		if err := objectstore.CheckRestoreDriver(objVolume, backupURL, endpointURL, d.Name()); err != nil {
+			return err
+		}
+		size, err = d.getSize(opts, objVolume.Size)
+		if err != nil {
+			return err
+		}
+		if size < objVolume.Size {
+			return fmt.Errorf("Volume size cannot be less than backup's size %v", objVolume.Size)
+		}
+	} else {
+		size, err = d.getSize(opts, d.DefaultVolumeSize)
+		if err != nil {
+			return err
+		}
+	}
+	if size%objectstore.DEFAULT_BLOCK_SIZE != 0 {
+		return fmt.Errorf("Size must be multiple of %v", objectstore.DEFAULT_BLOCK_SIZE)
+	}
+
+	volume := d.blankVolume(id)
+	exists, err := util.ObjectExists(volume)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Already has volume with specific uuid %v", id)
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_START,
+		LOG_FIELD_EVENT:  LOG_EVENT_CREATE,
+		LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME: id,
+	}).Debugf("Creating thin LV in %v/%v", d.VolumeGroup, d.ThinPool)
+	if err := lvm("lvcreate", "-V", strconv.FormatInt(size, 10)+"b", "-T", d.VolumeGroup+"/"+d.ThinPool, "-n", id); err != nil {
+		return err
+	}
+
+	volume.VolumeGroup = d.VolumeGroup
+	volume.LV = id
+	// LVM would round up the size to the extents
+	if volume.Size, err = lvsInt(d.VolumeGroup, id, "lv_size"); err != nil {
+		return err
+	}
+	volume.CreatedTime = util.Now()
+	volume.Snapshots = make(map[string]Snapshot)
+	volume.Filesystem = d.Filesystem
+	if err := util.ObjectSave(volume); err != nil {
+		return err
+	}
+
+	dev := lvPath(volume.VolumeGroup, volume.LV)
+	if backupURL != "" {
+		return objectstore.RestoreDeltaBlockBackupToSize(backupURL, endpointURL, dev, size)
+	}
+	log.Debugf("Formatting device %s with %s filesystem", dev, d.Filesystem)
+	if _, err := util.Execute("mkfs", []string{"-t", d.Filesystem, dev}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (d *Driver) DeleteVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	if volume.MountPoint != "" {
+		return fmt.Errorf("Cannot delete volume %s, it hasn't been umounted", id)
+	}
+	for snapshotID := range volume.Snapshots {
+		if err := d.deleteSnapshot(snapshotID, volume); err != nil {
+			return err
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_START,
+		LOG_FIELD_EVENT:  LOG_EVENT_REMOVE,
+		LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME: id,
+	}).Debugf("Removing thin LV")
+	if err := lvm("lvremove", "-y", volume.VolumeGroup+"/"+volume.LV); err != nil {
+		return err
+	}
+	return util.ObjectDelete(volume)
+}
+
+func (d *Driver) MountVolume(req Request) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+
+	mountPoint, err := util.VolumeMount(volume, req.Options[OPT_MOUNT_POINT], false)
+	if err != nil {
+		return "", err
+	}
+
+	if err := util.ObjectSave(volume); err != nil {
+		return "", err
+	}
+	return mountPoint, nil
+}
+
+func (d *Driver) UmountVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	if err := util.VolumeUmount(volume); err != nil {
+		return err
+	}
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) MountPoint(req Request) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	return volume.MountPoint, nil
+}
+
+func (d *Driver) GetVolumeInfo(id string) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.getVolumeInfo(id)
+}
+
+func (d *Driver) getVolumeInfo(id string) (map[string]string, error) {
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"VolumeGroup":           volume.VolumeGroup,
+		"LV":                    volume.LV,
+		"Device":                lvPath(volume.VolumeGroup, volume.LV),
+		OPT_VOLUME_NAME:         volume.Name,
+		OPT_VOLUME_CREATED_TIME: volume.CreatedTime,
+		OPT_MOUNT_POINT:         volume.MountPoint,
+		OPT_SIZE:                strconv.FormatInt(volume.Size, 10),
+		OPT_FILESYSTEM:          volume.Filesystem,
+	}, nil
+}
+
+func (d *Driver) ListVolume(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	volumes := make(map[string]map[string]string)
+	for _, id := range volumeIDs {
+		volumes[id], err = d.getVolumeInfo(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return volumes, nil
+}
+
+func (d *Driver) CreateSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if _, exists := volume.Snapshots[id]; exists {
+		return fmt.Errorf("Snapshot %v already exists for volume %v", id, volumeID)
+	}
+
+	lv := volume.LV + SNAPSHOT_LV_INFIX + id
+	if err := checkLVName(lv); err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_CREATE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: id,
+		LOG_FIELD_VOLUME:   volumeID,
+	}).Debugf("Creating thin snapshot LV %v", lv)
+	// The thin snapshot would be skipped for activation by default
+	if err := lvm("lvcreate", "-s", "-n", lv, volume.VolumeGroup+"/"+volume.LV); err != nil {
+		return err
+	}
+
+	volume.Snapshots[id] = Snapshot{
+		Name:             id,
+		LV:               lv,
+		CreatedTime:      util.Now(),
+		SnapshotMetadata: NewSnapshotMetadata(req.Options),
+	}
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) DeleteSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	return d.deleteSnapshot(req.Name, volume)
+}
+
+func (d *Driver) deleteSnapshot(id string, volume *Volume) error {
+	snapshot, exists := volume.Snapshots[id]
+	if !exists {
+		return fmt.Errorf("Cannot find snapshot %v of volume %v", id, volume.Name)
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_REMOVE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: id,
+		LOG_FIELD_VOLUME:   volume.Name,
+	}).Debugf("Removing thin snapshot LV %v", snapshot.LV)
+	if err := lvm("lvremove", "-y", volume.VolumeGroup+"/"+snapshot.LV); err != nil {
+		return err
+	}
+	delete(volume.Snapshots, id)
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) getSnapshotAndVolume(snapshotID, volumeID string) (*Snapshot, *Volume, error) {
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, nil, err
+	}
+	snapshot, exists := volume.Snapshots[snapshotID]
+	if !exists {
+		return nil, nil, fmt.Errorf("Cannot find snapshot %v of volume %v", snapshotID, volumeID)
+	}
+	return &snapshot, volume, nil
+}
+
+func (d *Driver) GetSnapshotInfo(req Request) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return nil, err
+	}
+	return d.getSnapshotInfo(req.Name, volumeID)
+}
+
+func (d *Driver) getSnapshotInfo(id, volumeID string) (map[string]string, error) {
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		OPT_SNAPSHOT_NAME:         snapshot.Name,
+		OPT_SNAPSHOT_LABELS:       snapshot.Labels,
+		OPT_SNAPSHOT_DESCRIPTION:  snapshot.Description,
+		OPT_SNAPSHOT_CREATED_TIME: snapshot.CreatedTime,
+		OPT_SIZE:                  strconv.FormatInt(volume.Size, 10),
+		"VolumeUUID":              volumeID,
+		"LV":                      snapshot.LV,
+	}, nil
+}
+
+func (d *Driver) ListSnapshot(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	var (
+		volumeIDs []string
+		err       error
+	)
+	snapshots := make(map[string]map[string]string)
+	specifiedVolumeID, _ := util.GetFieldFromOpts(OPT_VOLUME_NAME, opts)
+	if specifiedVolumeID != "" {
+		volumeIDs = []string{
+			specifiedVolumeID,
+		}
+	} else {
+		volumeIDs, err = d.listVolumeNames()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, volumeID := range volumeIDs {
+		volume := d.blankVolume(volumeID)
+		if err := util.ObjectLoad(volume); err != nil {
+			return nil, err
+		}
+		for snapshotID := range volume.Snapshots {
+			snapshots[snapshotID], err = d.getSnapshotInfo(snapshotID, volumeID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return snapshots, nil
+}
+
+func (d *Driver) BackupOps() (BackupOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) HasSnapshot(id, volumeID string) bool {
+	_, _, err := d.getSnapshotAndVolume(id, volumeID)
+	return err == nil
+}
+
+// CompareSnapshot uses thin_delta on the metadata snapshot of the live pool,
+// the result in the unit of the pool's chunks would be aligned to the backup
+// block size
+func (d *Driver) CompareSnapshot(id, compareID, volumeID string) (*metadata.Mappings, error) {
+	if err := util.CheckBinaryVersion(THIN_DELTA_BINARY, THIN_DELTA_MIN_VERSION, []string{"-V"}); err != nil {
+		return nil, err
+	}
+
+	includeSame := false
+	if compareID == "" || compareID == id {
+		compareID = id
+		includeSame = true
+	}
+	snap1, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	snap2, _, err := d.getSnapshotAndVolume(compareID, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	thinID1, err := lvsInt(volume.VolumeGroup, snap1.LV, "thin_id")
+	if err != nil {
+		return nil, err
+	}
+	thinID2, err := lvsInt(volume.VolumeGroup, snap2.LV, "thin_id")
+	if err != nil {
+		return nil, err
+	}
+
+	pool := dmName(d.VolumeGroup, d.ThinPool) + "-tpool"
+	if err := lvm("dmsetup", "message", pool, "0", "reserve_metadata_snap"); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := lvm("dmsetup", "message", pool, "0", "release_metadata_snap"); err != nil {
+			log.Warnf("Failed to release metadata snapshot of %v: %v", pool, err)
+		}
+	}()
+
+	out, err := util.Execute(THIN_DELTA_BINARY, []string{"-m",
+		"--snap1", strconv.FormatInt(thinID1, 10),
+		"--snap2", strconv.FormatInt(thinID2, 10),
+		filepath.Join(DM_DIR, dmName(d.VolumeGroup, d.ThinPool+"_tmeta"))})
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := metadata.DeviceMapperThinDeltaParser([]byte(out), d.ChunkSize, includeSame)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.AlignMappings(mapping, objectstore.DEFAULT_BLOCK_SIZE), nil
+}
+
+func (d *Driver) OpenSnapshot(id, volumeID string) error {
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return err
+	}
+	return lvm("lvchange", "-ay", "-K", volume.VolumeGroup+"/"+snapshot.LV)
+}
+
+func (d *Driver) ReadSnapshot(id, volumeID string, offset int64, data []byte) error {
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return err
+	}
+
+	devFile, err := os.Open(lvPath(volume.VolumeGroup, snapshot.LV))
+	if err != nil {
+		return err
+	}
+	defer devFile.Close()
+
+	_, err = devFile.ReadAt(data, offset)
+	return err
+}
+
+func (d *Driver) CloseSnapshot(id, volumeID string) error {
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return err
+	}
+	return lvm("lvchange", "-an", volume.VolumeGroup+"/"+snapshot.LV)
+}
+
+func (d *Driver) CreateBackup(snapshotID, volumeID, destURL, endpointURL string, opts map[string]string) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+
+	objVolume := &objectstore.Volume{
+		Name:        volumeID,
+		Driver:      d.Name(),
+		Size:        volume.Size,
+		CreatedTime: opts[OPT_VOLUME_CREATED_TIME],
+	}
+	objSnapshot := &objectstore.Snapshot{
+		Name:        snapshotID,
+		CreatedTime: opts[OPT_SNAPSHOT_CREATED_TIME],
+		ExportImage: opts[OPT_EXPORT_IMAGE],
+		Labels:      objectstore.DecodeLabels(opts[OPT_BACKUP_LABELS]),
+	}
+	return objectstore.CreateDeltaBlockBackup(objVolume, objSnapshot, destURL, endpointURL, d)
+}
+
+func (d *Driver) EstimateBackup(snapshotID, volumeID, destURL, endpointURL string, opts map[string]string) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+
+	objVolume := &objectstore.Volume{
+		Name:   volumeID,
+		Driver: d.Name(),
+		Size:   volume.Size,
+	}
+	objSnapshot := &objectstore.Snapshot{
+		Name: snapshotID,
+	}
+	return objectstore.EstimateDeltaBlockBackup(objVolume, objSnapshot, destURL, endpointURL, d)
+}
+
+func (d *Driver) DeleteBackup(backupURL, endpointURL string, opts map[string]string) error {
+	objVolume, err := objectstore.LoadVolume(backupURL, endpointURL)
+	if err != nil {
+		return err
+	}
+	if objVolume.Driver != d.Name() {
+		return fmt.Errorf("BUG: Wrong driver handling DeleteBackup(), driver should be %v but is %v", objVolume.Driver, d.Name())
+	}
+	force, _ := strconv.ParseBool(opts[OPT_FORCE])
+	return objectstore.DeleteDeltaBlockBackup(backupURL, endpointURL, force)
+}
+
+func (d *Driver) GetBackupInfo(backupURL, endpointURL string) (map[string]string, error) {
+	objVolume, err := objectstore.LoadVolume(backupURL, endpointURL)
+	if err != nil {
+		return nil, err
+	}
+	if objVolume.Driver != d.Name() {
+		return nil, fmt.Errorf("BUG: Wrong driver handling GetBackupInfo(), driver should be %v but is %v", objVolume.Driver, d.Name())
+	}
+	return objectstore.GetBackupInfo(backupURL, endpointURL)
+}
+
+func (d *Driver) ListBackup(destURL, endpointURL string, opts map[string]string) (map[string]map[string]string, error) {
+	return objectstore.List(opts[OPT_VOLUME_NAME], destURL, endpointURL, d.Name())
+}
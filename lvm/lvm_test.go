@@ -0,0 +1,24 @@
+package lvm
+
+import (
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct{}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) TestNames(c *C) {
+	c.Assert(dmName("vg0", "pool"), Equals, "vg0-pool")
+	c.Assert(dmName("my-vg", "thin-pool_tmeta"), Equals, "my--vg-thin--pool_tmeta")
+	c.Assert(lvPath("vg0", "vol1"), Equals, "/dev/vg0/vol1")
+
+	c.Assert(checkLVName("vol1"), IsNil)
+	c.Assert(checkLVName("vol1"+SNAPSHOT_LV_INFIX+"snap1"), IsNil)
+	c.Assert(checkLVName("snapshot1"), ErrorMatches, "Invalid name snapshot1 for LVM.*")
+	c.Assert(checkLVName("vol_tmeta"), ErrorMatches, "Invalid name vol_tmeta for LVM, cannot contain _tmeta")
+}
@@ -0,0 +1,463 @@
+package media
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/objectstore"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "media"})
+
+	// freeSpace can be replaced in the tests
+	freeSpace = func(path string) (int64, error) {
+		st := syscall.Statfs_t{}
+		if err := syscall.Statfs(path, &st); err != nil {
+			return 0, err
+		}
+		return int64(st.Bavail) * int64(st.Bsize), nil
+	}
+)
+
+// MediaObjectStoreDriver spans the backups across multiple removable media,
+// e.g. rotating USB drives. The configs are kept in the local catalog
+// directory, along with a catalog entry for each of the data files, which
+// records the media holding it. So the backups can be listed, created and
+// deleted with any of the media attached, and only the media holding the
+// data are needed for restoring.
+type MediaObjectStoreDriver struct {
+	destURL    string
+	path       string
+	catalog    objectstore.ObjectStoreDriver
+	mountPaths []string
+	media      map[string]*Media
+}
+
+// Media is an attached media, identified by the ID in its label file
+type Media struct {
+	ID     string
+	Path   string
+	driver objectstore.ObjectStoreDriver
+}
+
+// CatalogEntry is stored in place of the data file in the catalog directory
+type CatalogEntry struct {
+	Media string
+	Size  int64
+}
+
+// MediaNotAttachedError reports the media which needs to be attached
+type MediaNotAttachedError struct {
+	MediaID    string
+	FilePath   string
+	MountPaths []string
+}
+
+func (e *MediaNotAttachedError) Error() string {
+	return fmt.Sprintf("%v is stored on media %v, which is not attached. Please attach media %v at one of %v and retry",
+		e.FilePath, e.MediaID, e.MediaID, strings.Join(e.MountPaths, ", "))
+}
+
+const (
+	KIND = "media"
+
+	ENV_MOUNT_PATHS     = "MEDIA_MOUNT_PATHS"
+	DEFAULT_MOUNT_PATHS = "/media/*,/mnt/*"
+
+	MEDIA_LABEL_FILE = "convoy-media.id"
+
+	PENDING_REMOVAL_DIRECTORY = "convoy-media-pending"
+
+	// Leave some space on the media for the filesystem
+	RESERVED_SPACE = 64 * 1024 * 1024
+)
+
+func init() {
+	if err := objectstore.RegisterDriver(KIND, initFunc); err != nil {
+		panic(err)
+	}
+}
+
+func initFunc(destURL, endpoint string) (objectstore.ObjectStoreDriver, error) {
+	b := &MediaObjectStoreDriver{}
+
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != KIND {
+		return nil, fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, KIND)
+	}
+
+	if u.Host != "" || u.Path == "" {
+		return nil, fmt.Errorf("Media catalog path must follow: %v:///path/ format", KIND)
+	}
+	b.path = filepath.Clean(u.Path)
+
+	b.catalog, err = objectstore.GetObjectStoreDriver("vfs://"+b.path, "")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load media catalog %v: %v", b.path, err)
+	}
+
+	mountPaths := os.Getenv(ENV_MOUNT_PATHS)
+	if mountPaths == "" {
+		mountPaths = DEFAULT_MOUNT_PATHS
+	}
+	b.mountPaths = strings.Split(mountPaths, ",")
+	if err := b.scanMedia(); err != nil {
+		return nil, err
+	}
+
+	b.destURL = KIND + "://" + b.path
+	log.Debugf("Loaded driver for %v", b.destURL)
+	return b, nil
+}
+
+func (m *MediaObjectStoreDriver) Kind() string {
+	return KIND
+}
+
+func (m *MediaObjectStoreDriver) GetURL() string {
+	return m.destURL
+}
+
+// scanMedia looks for the labeled media under the mount paths, and finishes
+// the removals of the files on them which were deferred
+func (m *MediaObjectStoreDriver) scanMedia() error {
+	m.media = map[string]*Media{}
+	for _, pattern := range m.mountPaths {
+		dirs, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("Invalid media mount path %v: %v", pattern, err)
+		}
+		for _, dir := range dirs {
+			label, err := ioutil.ReadFile(filepath.Join(dir, MEDIA_LABEL_FILE))
+			if err != nil {
+				continue
+			}
+			id := strings.TrimSpace(string(label))
+			if id == "" {
+				log.Warnf("Ignore media at %v with empty label", dir)
+				continue
+			}
+			if media, exists := m.media[id]; exists {
+				log.Warnf("Ignore media %v at %v, which is already attached at %v", id, dir, media.Path)
+				continue
+			}
+			driver, err := objectstore.GetObjectStoreDriver("vfs://"+dir, "")
+			if err != nil {
+				return err
+			}
+			m.media[id] = &Media{
+				ID:     id,
+				Path:   dir,
+				driver: driver,
+			}
+			log.Debugf("Found media %v at %v", id, dir)
+			if err := m.removePending(m.media[id]); err != nil {
+				log.Warnf("Failed to remove the deleted files on media %v: %v", id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// getMedia returns the attached media, or rescan in case it's just attached
+func (m *MediaObjectStoreDriver) getMedia(id string) (*Media, error) {
+	if media, exists := m.media[id]; exists {
+		return media, nil
+	}
+	if err := m.scanMedia(); err != nil {
+		return nil, err
+	}
+	return m.media[id], nil
+}
+
+func (m *MediaObjectStoreDriver) sortedMedia() []*Media {
+	ids := []string{}
+	for id := range m.media {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	result := []*Media{}
+	for _, id := range ids {
+		result = append(result, m.media[id])
+	}
+	return result
+}
+
+// isDataFile returns true for the files which should be stored on the media,
+// the configs and locks are always kept in the catalog
+func isDataFile(filePath string) bool {
+	return !strings.HasSuffix(filePath, objectstore.CFG_SUFFIX) &&
+		!strings.HasSuffix(filePath, objectstore.LOCK_FILE_SUFFIX)
+}
+
+func (m *MediaObjectStoreDriver) loadEntry(filePath string) (*CatalogEntry, error) {
+	rc, err := m.catalog.Read(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	entry := &CatalogEntry{}
+	if err := json.NewDecoder(rc).Decode(entry); err != nil {
+		return nil, fmt.Errorf("Invalid catalog entry for %v: %v", filePath, err)
+	}
+	return entry, nil
+}
+
+func (m *MediaObjectStoreDriver) saveEntry(filePath string, entry *CatalogEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return m.catalog.Write(filePath, bytes.NewReader(data))
+}
+
+// mediaForFile returns the attached media which stores the data file
+func (m *MediaObjectStoreDriver) mediaForFile(filePath string) (*Media, error) {
+	entry, err := m.loadEntry(filePath)
+	if err != nil {
+		return nil, err
+	}
+	media, err := m.getMedia(entry.Media)
+	if err != nil {
+		return nil, err
+	}
+	if media == nil {
+		return nil, &MediaNotAttachedError{
+			MediaID:    entry.Media,
+			FilePath:   filePath,
+			MountPaths: m.mountPaths,
+		}
+	}
+	return media, nil
+}
+
+// SerializeWrites makes the blocks written one by one, since the media is
+// chosen by its free space
+func (m *MediaObjectStoreDriver) SerializeWrites() bool {
+	return true
+}
+
+// chooseMedia returns the first attached media in the order of ID, which has
+// enough space for the file
+func (m *MediaObjectStoreDriver) chooseMedia(size int64) (*Media, error) {
+	for _, media := range m.sortedMedia() {
+		space, err := freeSpace(media.Path)
+		if err != nil {
+			log.Warnf("Failed to get free space of media %v: %v", media.ID, err)
+			continue
+		}
+		if space-RESERVED_SPACE >= size {
+			return media, nil
+		}
+		log.Debugf("Media %v is full, %v bytes left", media.ID, space)
+	}
+	if len(m.media) == 0 {
+		return nil, fmt.Errorf("No media is attached, please attach a media at one of %v",
+			strings.Join(m.mountPaths, ", "))
+	}
+	return nil, fmt.Errorf("No attached media has enough space for %v bytes, please attach a new media at one of %v",
+		size, strings.Join(m.mountPaths, ", "))
+}
+
+func (m *MediaObjectStoreDriver) List(path string) ([]string, error) {
+	return m.catalog.List(path)
+}
+
+func (m *MediaObjectStoreDriver) FileExists(filePath string) bool {
+	return m.FileSize(filePath) >= 0
+}
+
+// FileSize doesn't need the media attached, so the existing blocks would be
+// reused no matter which media is attached
+func (m *MediaObjectStoreDriver) FileSize(filePath string) int64 {
+	if !isDataFile(filePath) {
+		return m.catalog.FileSize(filePath)
+	}
+	if !m.catalog.FileExists(filePath) {
+		return -1
+	}
+	entry, err := m.loadEntry(filePath)
+	if err != nil {
+		log.Warn(err)
+		return -1
+	}
+	return entry.Size
+}
+
+// Remove would defer the removal of the files on the detached media until
+// it's attached again
+func (m *MediaObjectStoreDriver) Remove(names ...string) error {
+	for _, name := range names {
+		files := map[string][]string{}
+		err := filepath.Walk(filepath.Join(m.path, name), func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			filePath, err := filepath.Rel(m.path, path)
+			if err != nil {
+				return err
+			}
+			if !isDataFile(filePath) {
+				return nil
+			}
+			entry, err := m.loadEntry(filePath)
+			if err != nil {
+				return err
+			}
+			files[entry.Media] = append(files[entry.Media], filePath)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for id, filePaths := range files {
+			media, err := m.getMedia(id)
+			if err != nil {
+				return err
+			}
+			if media == nil {
+				log.Infof("Media %v is not attached, defer the removal of %v files on it", id, len(filePaths))
+				if err := m.addPending(id, filePaths); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := media.driver.Remove(filePaths...); err != nil {
+				return err
+			}
+		}
+		if err := m.catalog.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MediaObjectStoreDriver) pendingFile(id string) string {
+	return filepath.Join(m.path, PENDING_REMOVAL_DIRECTORY, id)
+}
+
+func (m *MediaObjectStoreDriver) addPending(id string, filePaths []string) error {
+	if err := os.MkdirAll(filepath.Join(m.path, PENDING_REMOVAL_DIRECTORY), os.ModeDir|0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(m.pendingFile(id), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(strings.Join(filePaths, "\n") + "\n")
+	return err
+}
+
+func (m *MediaObjectStoreDriver) removePending(media *Media) error {
+	data, err := ioutil.ReadFile(m.pendingFile(media.ID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	filePaths := []string{}
+	for _, filePath := range strings.Split(string(data), "\n") {
+		// The file may be written again after it's removed
+		if filePath == "" || m.catalog.FileExists(filePath) {
+			continue
+		}
+		filePaths = append(filePaths, filePath)
+	}
+	if err := media.driver.Remove(filePaths...); err != nil {
+		return err
+	}
+	log.Infof("Removed %v deleted files on media %v", len(filePaths), media.ID)
+	return os.Remove(m.pendingFile(media.ID))
+}
+
+func (m *MediaObjectStoreDriver) Read(src string) (io.ReadCloser, error) {
+	if !isDataFile(src) {
+		return m.catalog.Read(src)
+	}
+	media, err := m.mediaForFile(src)
+	if err != nil {
+		return nil, err
+	}
+	return media.driver.Read(src)
+}
+
+func (m *MediaObjectStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	if !isDataFile(dst) {
+		return m.catalog.Write(dst, rs)
+	}
+	size, err := rs.Seek(0, os.SEEK_END)
+	if err != nil {
+		return err
+	}
+	if _, err := rs.Seek(0, os.SEEK_SET); err != nil {
+		return err
+	}
+	media, err := m.chooseMedia(size)
+	if err != nil {
+		return err
+	}
+	if err := media.driver.Write(dst, rs); err != nil {
+		return err
+	}
+	return m.saveEntry(dst, &CatalogEntry{
+		Media: media.ID,
+		Size:  size,
+	})
+}
+
+func (m *MediaObjectStoreDriver) Upload(src, dst string) error {
+	if !isDataFile(dst) {
+		return m.catalog.Upload(src, dst)
+	}
+	st, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	media, err := m.chooseMedia(st.Size())
+	if err != nil {
+		return err
+	}
+	if err := media.driver.Upload(src, dst); err != nil {
+		return err
+	}
+	return m.saveEntry(dst, &CatalogEntry{
+		Media: media.ID,
+		Size:  st.Size(),
+	})
+}
+
+func (m *MediaObjectStoreDriver) Download(src, dst string) error {
+	if !isDataFile(src) {
+		return m.catalog.Download(src, dst)
+	}
+	media, err := m.mediaForFile(src)
+	if err != nil {
+		return err
+	}
+	return media.driver.Download(src, dst)
+}
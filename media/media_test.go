@@ -0,0 +1,166 @@
+package media
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/convoy/objectstore"
+	"gopkg.in/check.v1"
+
+	_ "github.com/rancher/convoy/vfs"
+)
+
+func TestMedia(t *testing.T) { check.TestingT(t) }
+
+type MediaTestSuite struct {
+	catalog string
+	mounts  string
+	space   map[string]int64
+}
+
+var _ = check.Suite(&MediaTestSuite{})
+
+func (s *MediaTestSuite) SetUpTest(c *check.C) {
+	s.catalog = c.MkDir()
+	s.mounts = c.MkDir()
+	s.space = map[string]int64{}
+	freeSpace = func(path string) (int64, error) {
+		return s.space[filepath.Base(path)] + RESERVED_SPACE, nil
+	}
+	os.Setenv(ENV_MOUNT_PATHS, filepath.Join(s.mounts, "*"))
+}
+
+func (s *MediaTestSuite) TearDownTest(c *check.C) {
+	os.Unsetenv(ENV_MOUNT_PATHS)
+}
+
+// attach creates a labeled media at the mount path with the free space
+func (s *MediaTestSuite) attach(c *check.C, mount, id string, space int64) {
+	dir := filepath.Join(s.mounts, mount)
+	c.Assert(os.MkdirAll(dir, 0700), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, MEDIA_LABEL_FILE), []byte(id+"\n"), 0600), check.IsNil)
+	s.space[mount] = space
+}
+
+func (s *MediaTestSuite) detach(c *check.C, mount string) string {
+	detached := c.MkDir()
+	c.Assert(os.Rename(filepath.Join(s.mounts, mount), filepath.Join(detached, mount)), check.IsNil)
+	return filepath.Join(detached, mount)
+}
+
+func (s *MediaTestSuite) TestInitFunc(c *check.C) {
+	s.attach(c, "usb1", "disk1", 1024)
+	s.attach(c, "usb2", "disk1", 1024)
+	c.Assert(os.MkdirAll(filepath.Join(s.mounts, "unlabeled"), 0700), check.IsNil)
+
+	driver, err := objectstore.GetObjectStoreDriver("media://"+s.catalog, "")
+	c.Assert(err, check.IsNil)
+	c.Check(driver.Kind(), check.Equals, KIND)
+	c.Check(driver.GetURL(), check.Equals, "media://"+s.catalog)
+	media := driver.(*MediaObjectStoreDriver).media
+	c.Assert(media, check.HasLen, 1)
+	c.Check(media["disk1"].Path, check.Equals, filepath.Join(s.mounts, "usb1"))
+
+	_, err = initFunc("media://host/path", "")
+	c.Check(err, check.ErrorMatches, "Media catalog path must follow.*")
+
+	_, err = initFunc("media:///nonexist", "")
+	c.Check(err, check.ErrorMatches, "Failed to load media catalog.*")
+}
+
+func (s *MediaTestSuite) TestSpanning(c *check.C) {
+	s.attach(c, "usb1", "disk1", 1024)
+	s.attach(c, "usb2", "disk2", 1024)
+
+	driver, err := initFunc("media://"+s.catalog, "")
+	c.Assert(err, check.IsNil)
+
+	cfgPath := "convoy-objectstore/volumes/vol/volume.cfg"
+	blk1 := "convoy-objectstore/volumes/vol/blocks/aa/bb/aabb1.blk"
+	blk2 := "convoy-objectstore/volumes/vol/blocks/aa/bb/aabb2.blk"
+	c.Assert(driver.Write(cfgPath, bytes.NewReader([]byte("config"))), check.IsNil)
+	c.Assert(driver.Write(blk1, bytes.NewReader([]byte("block1"))), check.IsNil)
+	s.space["usb1"] = 0
+	c.Assert(driver.Write(blk2, bytes.NewReader([]byte("block2"))), check.IsNil)
+
+	// The config stays in the catalog, and the blocks spill to the next media
+	_, err = os.Stat(filepath.Join(s.catalog, cfgPath))
+	c.Check(err, check.IsNil)
+	_, err = os.Stat(filepath.Join(s.mounts, "usb1", blk1))
+	c.Check(err, check.IsNil)
+	_, err = os.Stat(filepath.Join(s.mounts, "usb2", blk2))
+	c.Check(err, check.IsNil)
+
+	names, err := driver.List("convoy-objectstore/volumes/vol/blocks/aa/bb")
+	c.Assert(err, check.IsNil)
+	c.Check(names, check.DeepEquals, []string{"aabb1.blk", "aabb2.blk"})
+	c.Check(driver.FileSize(blk1), check.Equals, int64(6))
+
+	// The catalog answers without the media
+	usb1 := s.detach(c, "usb1")
+	driver, err = initFunc("media://"+s.catalog, "")
+	c.Assert(err, check.IsNil)
+	c.Check(driver.FileExists(blk1), check.Equals, true)
+
+	rc, err := driver.Read(blk2)
+	c.Assert(err, check.IsNil)
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	c.Assert(err, check.IsNil)
+	c.Check(string(data), check.Equals, "block2")
+
+	_, err = driver.Read(blk1)
+	c.Assert(err, check.FitsTypeOf, &MediaNotAttachedError{})
+	c.Check(err, check.ErrorMatches, ".*aabb1.blk is stored on media disk1, which is not attached.*")
+
+	// Attach the media again, it would be found without reloading the driver
+	c.Assert(os.Rename(usb1, filepath.Join(s.mounts, "usb1")), check.IsNil)
+	dst := filepath.Join(c.MkDir(), "block")
+	c.Assert(driver.Download(blk1, dst), check.IsNil)
+	data, err = ioutil.ReadFile(dst)
+	c.Assert(err, check.IsNil)
+	c.Check(string(data), check.Equals, "block1")
+
+	s.space["usb2"] = 0
+	err = driver.Write(cfgPath+".blk", bytes.NewReader([]byte("block3")))
+	c.Check(err, check.ErrorMatches, "No attached media has enough space.*")
+}
+
+func (s *MediaTestSuite) TestRemove(c *check.C) {
+	s.attach(c, "usb1", "disk1", 1024)
+	s.attach(c, "usb2", "disk2", 1024)
+
+	driver, err := initFunc("media://"+s.catalog, "")
+	c.Assert(err, check.IsNil)
+
+	volumePath := "convoy-objectstore/volumes/vol"
+	blk1 := volumePath + "/blocks/aa/bb/aabb1.blk"
+	blk2 := volumePath + "/blocks/aa/bb/aabb2.blk"
+	c.Assert(driver.Write(volumePath+"/volume.cfg", bytes.NewReader([]byte("config"))), check.IsNil)
+	c.Assert(driver.Write(blk1, bytes.NewReader([]byte("block1"))), check.IsNil)
+	s.space["usb1"] = 0
+	c.Assert(driver.Write(blk2, bytes.NewReader([]byte("block2"))), check.IsNil)
+
+	usb1 := s.detach(c, "usb1")
+	driver, err = initFunc("media://"+s.catalog, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(driver.Remove(volumePath), check.IsNil)
+	c.Check(driver.FileExists(blk1), check.Equals, false)
+	c.Check(driver.FileExists(volumePath+"/volume.cfg"), check.Equals, false)
+	_, err = os.Stat(filepath.Join(s.mounts, "usb2", blk2))
+	c.Check(os.IsNotExist(err), check.Equals, true)
+	_, err = os.Stat(filepath.Join(usb1, blk1))
+	c.Check(err, check.IsNil)
+
+	// The removal on the detached media would be done once it's attached
+	c.Assert(os.Rename(usb1, filepath.Join(s.mounts, "usb1")), check.IsNil)
+	_, err = initFunc("media://"+s.catalog, "")
+	c.Assert(err, check.IsNil)
+	_, err = os.Stat(filepath.Join(s.mounts, "usb1", blk1))
+	c.Check(os.IsNotExist(err), check.Equals, true)
+	_, err = os.Stat(filepath.Join(s.catalog, PENDING_REMOVAL_DIRECTORY, "disk1"))
+	c.Check(os.IsNotExist(err), check.Equals, true)
+}
@@ -0,0 +1,63 @@
+package metadata
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+/*
+CompareFiles returns the blocks differ between the files, which can be block
+devices as well. If compareFile is empty, the blocks with any non-zero data in
+file would be returned. It's used by the drivers which have no better way to
+find out the changes between snapshots.
+*/
+func CompareFiles(file, compareFile string, blockSize int64) (*Mappings, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cf *os.File
+	if compareFile != "" {
+		if cf, err = os.Open(compareFile); err != nil {
+			return nil, err
+		}
+		defer cf.Close()
+	}
+
+	mappings := &Mappings{
+		BlockSize: blockSize,
+	}
+	buf := make([]byte, blockSize)
+	compareBuf := make([]byte, blockSize)
+	zero := make([]byte, blockSize)
+	for offset := int64(0); ; offset += blockSize {
+		n, err := io.ReadFull(f, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+
+		expected := zero[:n]
+		if cf != nil {
+			m, err := io.ReadFull(cf, compareBuf[:n])
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return nil, err
+			}
+			// The missing part of the compare file is zero
+			copy(compareBuf[m:n], zero)
+			expected = compareBuf[:n]
+		}
+		if !bytes.Equal(buf[:n], expected) {
+			mappings.Mappings = append(mappings.Mappings, Mapping{
+				Offset: offset,
+				Size:   blockSize,
+			})
+		}
+	}
+	return mappings, nil
+}
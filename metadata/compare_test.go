@@ -0,0 +1,61 @@
+package metadata
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func writeAt(c *C, file string, offset int64, data string) {
+	f, err := os.OpenFile(file, os.O_WRONLY, 0)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	_, err = f.WriteAt([]byte(data), offset)
+	c.Assert(err, IsNil)
+}
+
+func (s *TestSuite) TestCompareFiles(c *C) {
+	dir, err := ioutil.TempDir("", "convoy-metadata")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	file1 := filepath.Join(dir, "snap1.img")
+	file2 := filepath.Join(dir, "snap2.img")
+	c.Assert(ioutil.WriteFile(file1, []byte{}, 0644), IsNil)
+	c.Assert(os.Truncate(file1, 8*blockSize), IsNil)
+	writeAt(c, file1, 1*blockSize+10, "a")
+	writeAt(c, file1, 5*blockSize, "b")
+
+	mappings, err := CompareFiles(file1, "", blockSize)
+	c.Assert(err, IsNil)
+	c.Assert(mappings, DeepEquals, &Mappings{
+		BlockSize: blockSize,
+		Mappings: []Mapping{
+			{Offset: 1 * blockSize, Size: blockSize},
+			{Offset: 5 * blockSize, Size: blockSize},
+		},
+	})
+
+	// file2 is shorter than file1, and the last block is partial
+	c.Assert(ioutil.WriteFile(file2, []byte{}, 0644), IsNil)
+	c.Assert(os.Truncate(file2, 7*blockSize+100), IsNil)
+	writeAt(c, file2, 1*blockSize+10, "a")
+	writeAt(c, file2, 5*blockSize, "c")
+	writeAt(c, file2, 7*blockSize+1, "d")
+
+	mappings, err = CompareFiles(file2, file1, blockSize)
+	c.Assert(err, IsNil)
+	c.Assert(mappings.Mappings, DeepEquals, []Mapping{
+		{Offset: 5 * blockSize, Size: blockSize},
+		{Offset: 7 * blockSize, Size: blockSize},
+	})
+
+	mappings, err = CompareFiles(file1, file1, blockSize)
+	c.Assert(err, IsNil)
+	c.Assert(mappings.Mappings, HasLen, 0)
+
+	_, err = CompareFiles(file1, filepath.Join(dir, "nonexistent"), blockSize)
+	c.Assert(err, NotNil)
+}
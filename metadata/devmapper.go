@@ -5,6 +5,23 @@ import (
 )
 
 func DeviceMapperThinDeltaParser(data []byte, blockSize int64, includeSame bool) (*Mappings, error) {
+	return parseThinDelta(data, blockSize, func(kind string) bool {
+		return includeSame || kind != "same"
+	})
+}
+
+// DeviceMapperThinDeltaUnsharedParser returns the blocks mapped by the left
+// device of thin_delta which are not shared with the right one, i.e. the
+// left_only and different blocks
+func DeviceMapperThinDeltaUnsharedParser(data []byte, blockSize int64) (*Mappings, error) {
+	return parseThinDelta(data, blockSize, func(kind string) bool {
+		return kind == "left_only" || kind == "different"
+	})
+}
+
+// parseThinDelta returns the entries of the thin_delta output accepted by
+// include by the kind, e.g. "same"
+func parseThinDelta(data []byte, blockSize int64, include func(kind string) bool) (*Mappings, error) {
 	type Entry struct {
 		XMLName xml.Name
 		Begin   int64 `xml:"begin,attr"`
@@ -26,7 +43,7 @@ func DeviceMapperThinDeltaParser(data []byte, blockSize int64, includeSame bool)
 
 	mapping := &Mappings{}
 	for _, d := range superblock.Diff.Entries {
-		if !includeSame && d.XMLName.Local == "same" {
+		if !include(d.XMLName.Local) {
 			continue
 		}
 		var m Mapping
@@ -90,3 +90,75 @@ func (s *TestSuite) TestThinDelta(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(*m, DeepEquals, mDiff)
 }
+
+func (s *TestSuite) TestAlignMappings(c *C) {
+	chunkSize := int64(65536)
+	m := &Mappings{
+		Mappings: []Mapping{
+			{Offset: 0, Size: chunkSize},
+			{Offset: 4 * chunkSize, Size: 2 * chunkSize},
+			{Offset: 2*blockSize + chunkSize, Size: chunkSize},
+			{Offset: blockSize - chunkSize, Size: 2 * chunkSize},
+			{Offset: 5 * blockSize, Size: 0},
+		},
+		BlockSize: chunkSize,
+	}
+	c.Assert(*AlignMappings(m, blockSize), DeepEquals, Mappings{
+		Mappings: []Mapping{
+			{Offset: 0, Size: 3 * blockSize},
+		},
+		BlockSize: blockSize,
+	})
+
+	m.Mappings = append(m.Mappings, Mapping{Offset: 7*blockSize + chunkSize, Size: blockSize})
+	c.Assert(AlignMappings(m, blockSize).Mappings, DeepEquals, []Mapping{
+		{Offset: 0, Size: 3 * blockSize},
+		{Offset: 7 * blockSize, Size: 2 * blockSize},
+	})
+}
+
+func (s *TestSuite) TestThinDeltaUnshared(c *C) {
+	m, err := DeviceMapperThinDeltaUnsharedParser([]byte(thinDeltaOutputMix), blockSize)
+	c.Assert(err, IsNil)
+	c.Assert(*m, DeepEquals, Mappings{
+		Mappings: []Mapping{
+			{Offset: 2 * blockSize, Size: 1 * blockSize},
+			{Offset: 4 * blockSize, Size: 1 * blockSize},
+			{Offset: 6 * blockSize, Size: 1 * blockSize},
+		},
+		BlockSize: blockSize,
+	})
+
+	m, err = DeviceMapperThinDeltaUnsharedParser([]byte(thinDeltaOutputSame), blockSize)
+	c.Assert(err, IsNil)
+	c.Assert(m.Mappings, HasLen, 0)
+}
+
+func (s *TestSuite) TestIntersectMappings(c *C) {
+	a := &Mappings{
+		Mappings: []Mapping{
+			{Offset: 0, Size: 3 * blockSize},
+			{Offset: 6 * blockSize, Size: 2 * blockSize},
+			{Offset: 10 * blockSize, Size: blockSize},
+		},
+		BlockSize: blockSize,
+	}
+	b := &Mappings{
+		Mappings: []Mapping{
+			{Offset: 7 * blockSize, Size: 4 * blockSize},
+			{Offset: blockSize, Size: blockSize},
+		},
+		BlockSize: blockSize,
+	}
+	m := IntersectMappings(a, b)
+	c.Assert(*m, DeepEquals, Mappings{
+		Mappings: []Mapping{
+			{Offset: blockSize, Size: blockSize},
+			{Offset: 7 * blockSize, Size: blockSize},
+			{Offset: 10 * blockSize, Size: blockSize},
+		},
+		BlockSize: blockSize,
+	})
+	c.Assert(GetMappingsSize(m), Equals, int64(3*blockSize))
+	c.Assert(IntersectMappings(a, &Mappings{}).Mappings, HasLen, 0)
+}
@@ -1,5 +1,9 @@
 package metadata
 
+import (
+	"sort"
+)
+
 type Mapping struct {
 	Offset int64
 	Size   int64
@@ -9,3 +13,86 @@ type Mappings struct {
 	Mappings  []Mapping
 	BlockSize int64
 }
+
+type mappingsByOffset []Mapping
+
+func (m mappingsByOffset) Len() int           { return len(m) }
+func (m mappingsByOffset) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m mappingsByOffset) Less(i, j int) bool { return m[i].Offset < m[j].Offset }
+
+/*
+AlignMappings returns the mappings in the unit of blockSize, which covers all
+the ranges in m. The overlapped or adjacent blocks would be merged.
+*/
+func AlignMappings(m *Mappings, blockSize int64) *Mappings {
+	ranges := make([]Mapping, 0, len(m.Mappings))
+	for _, r := range m.Mappings {
+		if r.Size <= 0 {
+			continue
+		}
+		start := r.Offset / blockSize * blockSize
+		end := (r.Offset + r.Size + blockSize - 1) / blockSize * blockSize
+		ranges = append(ranges, Mapping{
+			Offset: start,
+			Size:   end - start,
+		})
+	}
+	sort.Sort(mappingsByOffset(ranges))
+
+	aligned := &Mappings{
+		BlockSize: blockSize,
+	}
+	for i := 0; i < len(ranges); {
+		start, end := ranges[i].Offset, ranges[i].Offset+ranges[i].Size
+		for i++; i < len(ranges) && ranges[i].Offset <= end; i++ {
+			if ranges[i].Offset+ranges[i].Size > end {
+				end = ranges[i].Offset + ranges[i].Size
+			}
+		}
+		aligned.Mappings = append(aligned.Mappings, Mapping{
+			Offset: start,
+			Size:   end - start,
+		})
+	}
+	return aligned
+}
+
+// IntersectMappings returns the ranges covered by both a and b, in the block
+// size of a
+func IntersectMappings(a, b *Mappings) *Mappings {
+	ra := AlignMappings(a, 1).Mappings
+	rb := AlignMappings(b, 1).Mappings
+	result := &Mappings{
+		BlockSize: a.BlockSize,
+	}
+	for i, j := 0, 0; i < len(ra) && j < len(rb); {
+		start, end := ra[i].Offset, ra[i].Offset+ra[i].Size
+		if rb[j].Offset > start {
+			start = rb[j].Offset
+		}
+		if e := rb[j].Offset + rb[j].Size; e < end {
+			end = e
+		}
+		if start < end {
+			result.Mappings = append(result.Mappings, Mapping{
+				Offset: start,
+				Size:   end - start,
+			})
+		}
+		if ra[i].Offset+ra[i].Size < rb[j].Offset+rb[j].Size {
+			i++
+		} else {
+			j++
+		}
+	}
+	return result
+}
+
+// GetMappingsSize returns the total size of the ranges of m
+func GetMappingsSize(m *Mappings) int64 {
+	size := int64(0)
+	for _, r := range m.Mappings {
+		size += r.Size
+	}
+	return size
+}
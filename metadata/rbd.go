@@ -0,0 +1,32 @@
+package metadata
+
+import (
+	"encoding/json"
+)
+
+/*
+RBDDiffParser parses the output of "rbd diff --format json", which lists the
+extents changed since the snapshot, or all the allocated extents without
+"--from-snap". The discarded extents are included as well, since they're
+changed to zero. The extents would be aligned to blockSize.
+*/
+func RBDDiffParser(data []byte, blockSize int64) (*Mappings, error) {
+	type Extent struct {
+		Offset int64 `json:"offset"`
+		Length int64 `json:"length"`
+	}
+
+	extents := []Extent{}
+	if err := json.Unmarshal(data, &extents); err != nil {
+		return nil, err
+	}
+
+	changes := &Mappings{}
+	for _, e := range extents {
+		changes.Mappings = append(changes.Mappings, Mapping{
+			Offset: e.Offset,
+			Size:   e.Length,
+		})
+	}
+	return AlignMappings(changes, blockSize), nil
+}
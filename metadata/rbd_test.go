@@ -0,0 +1,31 @@
+package metadata
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+const (
+	rbdDiffOutput = `[{"offset":0,"length":4194304,"exists":"true"},` +
+		`{"offset":8388608,"length":65536,"exists":"false"},` +
+		`{"offset":12582912,"length":4096,"exists":"true"}]`
+)
+
+func (s *TestSuite) TestRBDDiff(c *C) {
+	m, err := RBDDiffParser([]byte(rbdDiffOutput), blockSize)
+	c.Assert(err, IsNil)
+	c.Assert(*m, DeepEquals, Mappings{
+		Mappings: []Mapping{
+			{Offset: 0, Size: 2 * blockSize},
+			{Offset: 4 * blockSize, Size: 1 * blockSize},
+			{Offset: 6 * blockSize, Size: 1 * blockSize},
+		},
+		BlockSize: blockSize,
+	})
+
+	m, err = RBDDiffParser([]byte("[]"), blockSize)
+	c.Assert(err, IsNil)
+	c.Assert(m.Mappings, HasLen, 0)
+
+	_, err = RBDDiffParser([]byte("rbd: error"), blockSize)
+	c.Assert(err, NotNil)
+}
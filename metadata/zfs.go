@@ -0,0 +1,137 @@
+package metadata
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// The record types and layout of ZFS send stream, see dmu_replay_record_t in
+// zfs_ioctl.h of ZFS
+const (
+	ZFS_DRR_BEGIN = iota
+	ZFS_DRR_OBJECT
+	ZFS_DRR_FREEOBJECTS
+	ZFS_DRR_WRITE
+	ZFS_DRR_FREE
+	ZFS_DRR_END
+	ZFS_DRR_WRITE_BYREF
+	ZFS_DRR_SPILL
+	ZFS_DRR_WRITE_EMBEDDED
+	ZFS_DRR_OBJECT_RANGE
+	ZFS_DRR_REDACT
+
+	ZFS_DRR_RECORD_SIZE = 312
+	ZFS_BACKUP_MAGIC    = 0x2F5bacbac
+
+	// The object holding the data of zvol
+	ZFS_ZVOL_OBJECT = 1
+
+	zfsLengthToEnd = ^uint64(0)
+
+	// The stream is read in large chunks, since it carries the payloads of
+	// all the written blocks, which are skipped right away
+	zfsStreamBufferSize = 1 << 20
+)
+
+func roundUp8(n uint64) uint64 {
+	return (n + 7) &^ 7
+}
+
+/*
+ZFSSendStreamParser returns the blocks of a zvol changed in the stream
+generated by "zfs send", or all the allocated blocks for a full stream. Both
+the written and freed ranges are considered as changed, and the ranges are
+aligned to blockSize and limited by volumeSize.
+
+There is no way to have "zfs send" list the blocks without their data, so the
+cost of the parsing is reading the whole stream, which is about the size of
+the changed data, or of the allocated data for a full stream, but not the
+volume size. The payloads are skipped in the buffer without being copied or
+decoded.
+*/
+func ZFSSendStreamParser(r io.Reader, blockSize, volumeSize int64) (*Mappings, error) {
+	var order binary.ByteOrder
+	br := bufio.NewReaderSize(r, zfsStreamBufferSize)
+	record := make([]byte, ZFS_DRR_RECORD_SIZE)
+	changes := &Mappings{}
+
+	addRange := func(offset, length uint64) {
+		if offset >= uint64(volumeSize) {
+			return
+		}
+		end := uint64(volumeSize)
+		if length != zfsLengthToEnd && offset+length < end {
+			end = offset + length
+		}
+		if end <= offset {
+			return
+		}
+		changes.Mappings = append(changes.Mappings, Mapping{
+			Offset: int64(offset),
+			Size:   int64(end - offset),
+		})
+	}
+
+	for first := true; ; first = false {
+		if _, err := io.ReadFull(br, record); err != nil {
+			if err == io.EOF && !first {
+				return nil, fmt.Errorf("Unexpected end of ZFS send stream")
+			}
+			return nil, err
+		}
+		if first {
+			switch uint64(ZFS_BACKUP_MAGIC) {
+			case binary.LittleEndian.Uint64(record[8:]):
+				order = binary.LittleEndian
+			case binary.BigEndian.Uint64(record[8:]):
+				order = binary.BigEndian
+			default:
+				return nil, fmt.Errorf("Invalid ZFS send stream")
+			}
+		}
+		drrType := order.Uint32(record[0:])
+		u := record[8:]
+
+		payload := uint64(0)
+		switch drrType {
+		case ZFS_DRR_BEGIN:
+			payload = uint64(order.Uint32(record[4:]))
+		case ZFS_DRR_OBJECT:
+			payload = roundUp8(uint64(order.Uint32(u[20:])))
+		case ZFS_DRR_WRITE:
+			payload = order.Uint64(u[24:])
+			if u[42] != 0 {
+				payload = order.Uint64(u[88:])
+			}
+			if order.Uint64(u[0:]) == ZFS_ZVOL_OBJECT {
+				addRange(order.Uint64(u[16:]), order.Uint64(u[24:]))
+			}
+		case ZFS_DRR_FREE:
+			if order.Uint64(u[0:]) == ZFS_ZVOL_OBJECT {
+				addRange(order.Uint64(u[8:]), order.Uint64(u[16:]))
+			}
+		case ZFS_DRR_WRITE_EMBEDDED:
+			payload = roundUp8(uint64(order.Uint32(u[44:])))
+			if order.Uint64(u[0:]) == ZFS_ZVOL_OBJECT {
+				addRange(order.Uint64(u[8:]), order.Uint64(u[16:]))
+			}
+		case ZFS_DRR_SPILL:
+			payload = order.Uint64(u[8:])
+			if u[25] != 0 {
+				payload = order.Uint64(u[32:])
+			}
+		case ZFS_DRR_FREEOBJECTS, ZFS_DRR_WRITE_BYREF, ZFS_DRR_OBJECT_RANGE, ZFS_DRR_REDACT:
+		case ZFS_DRR_END:
+			return AlignMappings(changes, blockSize), nil
+		default:
+			return nil, fmt.Errorf("Unknown record type %v in ZFS send stream", drrType)
+		}
+		if payload != 0 {
+			if _, err := br.Discard(int(payload)); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
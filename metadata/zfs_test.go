@@ -0,0 +1,150 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	. "gopkg.in/check.v1"
+)
+
+type zfsStream struct {
+	bytes.Buffer
+	order binary.ByteOrder
+}
+
+func (s *zfsStream) record(drrType uint32, payloadLen uint32, fields map[int]interface{}, payload int) {
+	record := make([]byte, ZFS_DRR_RECORD_SIZE)
+	s.order.PutUint32(record[0:], drrType)
+	s.order.PutUint32(record[4:], payloadLen)
+	for offset, value := range fields {
+		switch v := value.(type) {
+		case uint64:
+			s.order.PutUint64(record[8+offset:], v)
+		case uint32:
+			s.order.PutUint32(record[8+offset:], v)
+		case uint8:
+			record[8+offset] = v
+		}
+	}
+	s.Write(record)
+	s.Write(make([]byte, payload))
+}
+
+func newZFSStream(order binary.ByteOrder) *zfsStream {
+	s := &zfsStream{order: order}
+	s.record(ZFS_DRR_BEGIN, 16, map[int]interface{}{0: uint64(ZFS_BACKUP_MAGIC)}, 16)
+	// The bonus buffer of the zvol data object would be padded
+	s.record(ZFS_DRR_OBJECT, 0, map[int]interface{}{0: uint64(ZFS_ZVOL_OBJECT), 20: uint32(5)}, 8)
+	return s
+}
+
+func (s *zfsStream) write(object, offset, length uint64) {
+	s.record(ZFS_DRR_WRITE, 0, map[int]interface{}{0: object, 16: offset, 24: length}, int(length))
+}
+
+func (s *zfsStream) free(object, offset, length uint64) {
+	s.record(ZFS_DRR_FREE, 0, map[int]interface{}{0: object, 8: offset, 16: length}, 0)
+}
+
+func (s *zfsStream) end() {
+	s.record(ZFS_DRR_END, 0, nil, 0)
+}
+
+func (t *TestSuite) TestZFSSendStream(c *C) {
+	volumeSize := int64(16 * blockSize)
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		s := newZFSStream(order)
+		s.write(ZFS_ZVOL_OBJECT, 0, 8192)
+		s.write(ZFS_ZVOL_OBJECT, blockSize-8192, 16384)
+		// The properties of zvol shouldn't count
+		s.write(2, 4*blockSize, 512)
+		s.write(ZFS_ZVOL_OBJECT, 5*blockSize+4096, 8192)
+		s.free(ZFS_ZVOL_OBJECT, 6*blockSize, 2*blockSize)
+		s.record(ZFS_DRR_WRITE_EMBEDDED, 0, map[int]interface{}{
+			0: uint64(ZFS_ZVOL_OBJECT), 8: uint64(10 * blockSize), 16: uint64(4096), 44: uint32(100)}, 104)
+		s.free(ZFS_ZVOL_OBJECT, 14*blockSize, ^uint64(0))
+		s.end()
+
+		m, err := ZFSSendStreamParser(&s.Buffer, blockSize, volumeSize)
+		c.Assert(err, IsNil)
+		c.Assert(*m, DeepEquals, Mappings{
+			Mappings: []Mapping{
+				{Offset: 0, Size: 2 * blockSize},
+				{Offset: 5 * blockSize, Size: 3 * blockSize},
+				{Offset: 10 * blockSize, Size: 1 * blockSize},
+				{Offset: 14 * blockSize, Size: 2 * blockSize},
+			},
+			BlockSize: blockSize,
+		})
+	}
+
+	s := newZFSStream(binary.LittleEndian)
+	s.end()
+	m, err := ZFSSendStreamParser(&s.Buffer, blockSize, volumeSize)
+	c.Assert(err, IsNil)
+	c.Assert(m.Mappings, HasLen, 0)
+
+	s = newZFSStream(binary.LittleEndian)
+	s.write(ZFS_ZVOL_OBJECT, 0, 8192)
+	_, err = ZFSSendStreamParser(&s.Buffer, blockSize, volumeSize)
+	c.Assert(err, ErrorMatches, "Unexpected end of ZFS send stream")
+
+	_, err = ZFSSendStreamParser(bytes.NewReader(make([]byte, ZFS_DRR_RECORD_SIZE)), blockSize, volumeSize)
+	c.Assert(err, ErrorMatches, "Invalid ZFS send stream")
+}
+
+// zfsPayload returns the payloads of the large streams without holding them,
+// their content doesn't matter to the parser
+type zfsPayload struct{}
+
+func (zfsPayload) Read(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// newLargeZFSStream returns the stream of 128KiB writes to every other block
+// of the zvol, and the length of the stream
+func newLargeZFSStream(writes int) (io.Reader, int64) {
+	const writeSize = 128 * 1024
+
+	s := newZFSStream(binary.LittleEndian)
+	readers := []io.Reader{bytes.NewReader(s.Bytes())}
+	length := int64(s.Len())
+	for i := 0; i < writes; i++ {
+		w := &zfsStream{order: binary.LittleEndian}
+		w.record(ZFS_DRR_WRITE, 0, map[int]interface{}{
+			0: uint64(ZFS_ZVOL_OBJECT), 16: uint64(2 * i * blockSize), 24: uint64(writeSize)}, 0)
+		readers = append(readers, bytes.NewReader(w.Bytes()), io.LimitReader(zfsPayload{}, writeSize))
+		length += int64(w.Len()) + writeSize
+	}
+	end := &zfsStream{order: binary.LittleEndian}
+	end.end()
+	readers = append(readers, bytes.NewReader(end.Bytes()))
+	length += int64(end.Len())
+	return io.MultiReader(readers...), length
+}
+
+func (t *TestSuite) TestZFSSendStreamLarge(c *C) {
+	// About 1GiB of stream
+	writes := 8192
+	r, _ := newLargeZFSStream(writes)
+	m, err := ZFSSendStreamParser(r, blockSize, int64(2*writes*blockSize))
+	c.Assert(err, IsNil)
+	c.Assert(m.Mappings, HasLen, writes)
+	for i, mapping := range m.Mappings {
+		c.Assert(mapping, Equals, Mapping{Offset: int64(2 * i * blockSize), Size: blockSize})
+	}
+}
+
+func (t *TestSuite) BenchmarkZFSSendStreamParser(c *C) {
+	writes := 1024
+	for i := 0; i < c.N; i++ {
+		c.StopTimer()
+		r, length := newLargeZFSStream(writes)
+		c.SetBytes(length)
+		c.StartTimer()
+		if _, err := ZFSSendStreamParser(r, blockSize, int64(2*writes*blockSize)); err != nil {
+			c.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package mirror
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/objectstore"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "mirror"})
+)
+
+// MirrorObjectStoreDriver mirrors the updates to all the destinations. The
+// reads would be served by the first destination which succeeds.
+type MirrorObjectStoreDriver struct {
+	destURL      string
+	destinations []objectstore.ObjectStoreDriver
+}
+
+// MirrorError reports the failure of each destination
+type MirrorError struct {
+	Op     string
+	Errors map[string]error
+}
+
+func (e *MirrorError) Error() string {
+	urls := []string{}
+	for u := range e.Errors {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+	msgs := []string{}
+	for _, u := range urls {
+		msgs = append(msgs, fmt.Sprintf("%v: %v", u, e.Errors[u]))
+	}
+	return fmt.Sprintf("Failed to %v on %d of the mirror destinations: %v",
+		e.Op, len(e.Errors), strings.Join(msgs, "; "))
+}
+
+const (
+	KIND = "mirror"
+
+	DESTINATION_SEPARATOR = ","
+)
+
+func init() {
+	if err := objectstore.RegisterDriver(KIND, initFunc); err != nil {
+		panic(err)
+	}
+}
+
+// initFunc accepts URL like "mirror:vfs:///var/backup,s3://bucket@region/path".
+// The destination URLs cannot contain query, since the backup URL would be
+// appended with one.
+func initFunc(destURL, endpoint string) (objectstore.ObjectStoreDriver, error) {
+	b := &MirrorObjectStoreDriver{}
+
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != KIND {
+		return nil, fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, KIND)
+	}
+
+	urls := strings.Split(u.Opaque, DESTINATION_SEPARATOR)
+	if u.Opaque == "" || len(urls) < 2 {
+		return nil, fmt.Errorf("Invalid URL. Must be %v:<destination URL>,<destination URL>[,...]", KIND)
+	}
+
+	destURLs := []string{}
+	for _, childURL := range urls {
+		if strings.HasPrefix(childURL, KIND+":") {
+			return nil, fmt.Errorf("Cannot mirror to another mirror %v", childURL)
+		}
+		d, err := objectstore.GetObjectStoreDriver(childURL, "")
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load mirror destination %v: %v", childURL, err)
+		}
+		b.destinations = append(b.destinations, d)
+		destURLs = append(destURLs, d.GetURL())
+	}
+
+	b.destURL = KIND + ":" + strings.Join(destURLs, DESTINATION_SEPARATOR)
+	log.Debugf("Loaded driver for %v", b.destURL)
+	return b, nil
+}
+
+func (m *MirrorObjectStoreDriver) Kind() string {
+	return KIND
+}
+
+func (m *MirrorObjectStoreDriver) GetURL() string {
+	return m.destURL
+}
+
+// forAll runs fn on every destination in parallel
+func (m *MirrorObjectStoreDriver) forAll(op string, fn func(d objectstore.ObjectStoreDriver) error) error {
+	mutex := sync.Mutex{}
+	errs := map[string]error{}
+	wg := sync.WaitGroup{}
+	for _, d := range m.destinations {
+		wg.Add(1)
+		go func(d objectstore.ObjectStoreDriver) {
+			defer wg.Done()
+			if err := fn(d); err != nil {
+				log.Errorf("Failed to %v on mirror destination %v: %v", op, d.GetURL(), err)
+				mutex.Lock()
+				errs[d.GetURL()] = err
+				mutex.Unlock()
+			}
+		}(d)
+	}
+	wg.Wait()
+	if len(errs) != 0 {
+		return &MirrorError{
+			Op:     op,
+			Errors: errs,
+		}
+	}
+	return nil
+}
+
+// List returns the union of the destinations, so the backups missing in some
+// destinations would still be found
+func (m *MirrorObjectStoreDriver) List(path string) ([]string, error) {
+	var firstErr error
+	found := false
+	names := map[string]bool{}
+	result := []string{}
+	for _, d := range m.destinations {
+		list, err := d.List(path)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		found = true
+		for _, name := range list {
+			if !names[name] {
+				names[name] = true
+				result = append(result, name)
+			}
+		}
+	}
+	if !found {
+		return nil, firstErr
+	}
+	return result, nil
+}
+
+// FileExists only returns true if the file exists in all the destinations,
+// otherwise the missing blocks won't be uploaded to the new destinations
+func (m *MirrorObjectStoreDriver) FileExists(filePath string) bool {
+	return m.FileSize(filePath) >= 0
+}
+
+func (m *MirrorObjectStoreDriver) FileSize(filePath string) int64 {
+	size := int64(-1)
+	for _, d := range m.destinations {
+		s := d.FileSize(filePath)
+		if s < 0 {
+			return -1
+		}
+		if size < 0 {
+			size = s
+		}
+	}
+	return size
+}
+
+func (m *MirrorObjectStoreDriver) Remove(names ...string) error {
+	return m.forAll("remove "+strings.Join(names, ", "), func(d objectstore.ObjectStoreDriver) error {
+		return d.Remove(names...)
+	})
+}
+
+func (m *MirrorObjectStoreDriver) Read(src string) (io.ReadCloser, error) {
+	var firstErr error
+	for _, d := range m.destinations {
+		rc, err := d.Read(src)
+		if err == nil {
+			return rc, nil
+		}
+		log.Warnf("Failed to read %v from mirror destination %v: %v", src, d.GetURL(), err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+func (m *MirrorObjectStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	// The reader cannot be shared by the destinations
+	data, err := ioutil.ReadAll(rs)
+	if err != nil {
+		return err
+	}
+	return m.forAll("write "+dst, func(d objectstore.ObjectStoreDriver) error {
+		return d.Write(dst, bytes.NewReader(data))
+	})
+}
+
+func (m *MirrorObjectStoreDriver) Upload(src, dst string) error {
+	return m.forAll("upload "+dst, func(d objectstore.ObjectStoreDriver) error {
+		return d.Upload(src, dst)
+	})
+}
+
+func (m *MirrorObjectStoreDriver) Download(src, dst string) error {
+	var firstErr error
+	for _, d := range m.destinations {
+		err := d.Download(src, dst)
+		if err == nil {
+			return nil
+		}
+		log.Warnf("Failed to download %v from mirror destination %v: %v", src, d.GetURL(), err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// SerializeWrites returns true if any of the destinations cannot be written
+// concurrently
+func (m *MirrorObjectStoreDriver) SerializeWrites() bool {
+	for _, d := range m.destinations {
+		if serializer, ok := d.(objectstore.ObjectStoreSerializer); ok && serializer.SerializeWrites() {
+			return true
+		}
+	}
+	return false
+}
+
+// Lock acquires the locks of all the destinations which support locking, in
+// order
+func (m *MirrorObjectStoreDriver) Lock(lockPath string) error {
+	locked := []objectstore.ObjectStoreLocker{}
+	for _, d := range m.destinations {
+		locker, ok := d.(objectstore.ObjectStoreLocker)
+		if !ok {
+			continue
+		}
+		if err := locker.Lock(lockPath); err != nil {
+			for i := len(locked) - 1; i >= 0; i-- {
+				locked[i].Unlock(lockPath)
+			}
+			return err
+		}
+		locked = append(locked, locker)
+	}
+	return nil
+}
+
+func (m *MirrorObjectStoreDriver) Unlock(lockPath string) error {
+	var firstErr error
+	for i := len(m.destinations) - 1; i >= 0; i-- {
+		locker, ok := m.destinations[i].(objectstore.ObjectStoreLocker)
+		if !ok {
+			continue
+		}
+		if err := locker.Unlock(lockPath); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,102 @@
+package mirror
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/convoy/objectstore"
+	"gopkg.in/check.v1"
+
+	_ "github.com/rancher/convoy/vfs"
+)
+
+func TestMirror(t *testing.T) { check.TestingT(t) }
+
+type MirrorTestSuite struct {
+	dirs []string
+}
+
+var _ = check.Suite(&MirrorTestSuite{})
+
+func (s *MirrorTestSuite) SetUpTest(c *check.C) {
+	s.dirs = []string{c.MkDir(), c.MkDir()}
+}
+
+func (s *MirrorTestSuite) url() string {
+	return "mirror:vfs://" + s.dirs[0] + ",vfs://" + s.dirs[1]
+}
+
+func (s *MirrorTestSuite) TestInitFunc(c *check.C) {
+	driver, err := objectstore.GetObjectStoreDriver(s.url(), "")
+	c.Assert(err, check.IsNil)
+	c.Check(driver.Kind(), check.Equals, KIND)
+	c.Check(driver.GetURL(), check.Equals, s.url())
+	c.Check(driver.(*MirrorObjectStoreDriver).destinations, check.HasLen, 2)
+
+	_, err = initFunc("mirror:vfs://"+s.dirs[0], "")
+	c.Check(err, check.ErrorMatches, "Invalid URL.*")
+
+	_, err = initFunc("mirror:vfs://"+s.dirs[0]+",vfs:///nonexist", "")
+	c.Check(err, check.ErrorMatches, "Failed to load mirror destination vfs:///nonexist.*")
+
+	_, err = initFunc("mirror:vfs://"+s.dirs[0]+",mirror:vfs://"+s.dirs[1], "")
+	c.Check(err, check.ErrorMatches, "Cannot mirror to another mirror.*")
+}
+
+func (s *MirrorTestSuite) TestMirror(c *check.C) {
+	driver, err := initFunc(s.url(), "")
+	c.Assert(err, check.IsNil)
+
+	filePath := "convoy-objectstore/volumes/vol/backups/backup_1.cfg"
+	c.Assert(driver.Write(filePath, bytes.NewReader([]byte("data"))), check.IsNil)
+	for _, dir := range s.dirs {
+		data, err := ioutil.ReadFile(filepath.Join(dir, filePath))
+		c.Assert(err, check.IsNil)
+		c.Check(string(data), check.Equals, "data")
+	}
+	c.Check(driver.FileSize(filePath), check.Equals, int64(4))
+
+	// Missing in one destination
+	c.Assert(os.Remove(filepath.Join(s.dirs[1], filePath)), check.IsNil)
+	c.Check(driver.FileExists(filePath), check.Equals, false)
+	names, err := driver.List("convoy-objectstore/volumes/vol/backups")
+	c.Assert(err, check.IsNil)
+	c.Check(names, check.DeepEquals, []string{"backup_1.cfg"})
+
+	// Read falls back to other destinations
+	c.Assert(os.Remove(filepath.Join(s.dirs[0], filePath)), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.dirs[1], filePath), []byte("data"), 0600), check.IsNil)
+	rc, err := driver.Read(filePath)
+	c.Assert(err, check.IsNil)
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	c.Assert(err, check.IsNil)
+	c.Check(string(data), check.Equals, "data")
+
+	c.Assert(driver.Remove("convoy-objectstore/volumes/vol"), check.IsNil)
+	for _, dir := range s.dirs {
+		_, err := os.Stat(filepath.Join(dir, filePath))
+		c.Check(os.IsNotExist(err), check.Equals, true)
+	}
+}
+
+func (s *MirrorTestSuite) TestWriteFailure(c *check.C) {
+	driver, err := initFunc(s.url(), "")
+	c.Assert(err, check.IsNil)
+
+	// The directory cannot be created in the second destination
+	c.Assert(ioutil.WriteFile(filepath.Join(s.dirs[1], "blocks"), []byte{}, 0600), check.IsNil)
+	err = driver.Write("blocks/a.blk", bytes.NewReader([]byte("data")))
+	c.Assert(err, check.NotNil)
+	mirrorErr, ok := err.(*MirrorError)
+	c.Assert(ok, check.Equals, true)
+	c.Check(mirrorErr.Errors, check.HasLen, 1)
+	c.Check(mirrorErr.Errors["vfs://"+s.dirs[1]], check.NotNil)
+	c.Check(err, check.ErrorMatches, "Failed to write blocks/a.blk on 1 of the mirror destinations: vfs://.*")
+
+	_, err = os.Stat(filepath.Join(s.dirs[0], "blocks/a.blk"))
+	c.Check(err, check.IsNil)
+}
@@ -0,0 +1,254 @@
+package nfs
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+
+	// The files in the mounted export are accessed through vfs driver
+	_ "github.com/rancher/convoy/vfs"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "nfs"})
+)
+
+const (
+	KIND = "nfs"
+
+	ENV_MOUNT_OPTIONS = "NFS_MOUNT_OPTIONS"
+
+	MOUNT_BINARY = "mount"
+	MOUNTS_FILE  = "/proc/self/mounts"
+)
+
+var (
+	// MountRoot is where the exports would be mounted, as
+	// MountRoot/<server>/<export>
+	MountRoot = "/var/lib/rancher/convoy/objectstore-mounts/nfs"
+
+	// The lock would be refreshed every LockRefreshInterval, and considered
+	// stale if it hasn't been refreshed for LockStaleTimeout, e.g. the host
+	// holding it has crashed
+	LockRefreshInterval = 30 * time.Second
+	LockStaleTimeout    = 5 * time.Minute
+	LockRetryInterval   = 5 * time.Second
+	LockWaitTimeout     = 30 * time.Minute
+
+	mountMutex = &sync.Mutex{}
+)
+
+type NFSObjectStoreDriver struct {
+	// Operations on the files are delegated to vfs driver of the mount point
+	objectstore.ObjectStoreDriver
+
+	destURL    string
+	mountPoint string
+
+	mutex *sync.Mutex
+	locks map[string]chan struct{}
+}
+
+func init() {
+	if err := objectstore.RegisterDriver(KIND, initFunc); err != nil {
+		panic(err)
+	}
+}
+
+func initFunc(destURL, endpoint string) (objectstore.ObjectStoreDriver, error) {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != KIND {
+		return nil, fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, KIND)
+	}
+
+	if u.Host == "" || u.Path == "" {
+		return nil, fmt.Errorf("Invalid URL. Must be nfs://server/export/")
+	}
+	export := filepath.Clean(u.Path)
+
+	mountPoint := filepath.Join(MountRoot, u.Host, export)
+	if err := mountExport(u.Host+":"+export, mountPoint, os.Getenv(ENV_MOUNT_OPTIONS)); err != nil {
+		return nil, err
+	}
+	return newDriver(u, mountPoint)
+}
+
+func newDriver(u *url.URL, mountPoint string) (*NFSObjectStoreDriver, error) {
+	vfsDriver, err := objectstore.GetObjectStoreDriver("vfs://"+mountPoint, "")
+	if err != nil {
+		return nil, err
+	}
+
+	b := &NFSObjectStoreDriver{
+		ObjectStoreDriver: vfsDriver,
+		destURL:           KIND + "://" + u.Host + filepath.Clean(u.Path),
+		mountPoint:        mountPoint,
+		mutex:             &sync.Mutex{},
+		locks:             make(map[string]chan struct{}),
+	}
+	log.Debugf("Loaded driver for %v", b.destURL)
+	return b, nil
+}
+
+func isMounted(mountPoint string) (bool, error) {
+	f, err := os.Open(MOUNTS_FILE)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && fields[1] == mountPoint {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// mountExport mounts the export if it's not mounted yet. The mount would be
+// kept for the following operations.
+func mountExport(source, mountPoint, options string) error {
+	mountMutex.Lock()
+	defer mountMutex.Unlock()
+
+	mounted, err := isMounted(mountPoint)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		return nil
+	}
+	if err := os.MkdirAll(mountPoint, 0700); err != nil {
+		return err
+	}
+	args := []string{"-t", "nfs"}
+	if options != "" {
+		args = append(args, "-o", options)
+	}
+	args = append(args, source, mountPoint)
+	if _, err := util.Execute(MOUNT_BINARY, args); err != nil {
+		return fmt.Errorf("Failed to mount %v at %v: %v", source, mountPoint, err)
+	}
+	log.Debugf("Mounted %v at %v", source, mountPoint)
+	return nil
+}
+
+func (n *NFSObjectStoreDriver) Kind() string {
+	return KIND
+}
+
+func (n *NFSObjectStoreDriver) GetURL() string {
+	return n.destURL
+}
+
+func lockOwner() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%v:%v", hostname, os.Getpid())
+}
+
+// tryLock creates the lock file exclusively, which is atomic across NFS
+// clients since NFSv3. A stale lock would be taken over.
+func (n *NFSObjectStoreDriver) tryLock(lockFile string) (bool, error) {
+	f, err := os.OpenFile(lockFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err == nil {
+		defer f.Close()
+		_, err = f.WriteString(lockOwner())
+		return err == nil, err
+	}
+	if !os.IsExist(err) {
+		return false, err
+	}
+
+	st, err := os.Stat(lockFile)
+	if err != nil {
+		// Released just now
+		return false, nil
+	}
+	if time.Since(st.ModTime()) < LockStaleTimeout {
+		return false, nil
+	}
+	owner, _ := ioutil.ReadFile(lockFile)
+	log.Warnf("Removing stale lock %v held by %v, last refreshed at %v", lockFile, string(owner), st.ModTime())
+	if err := os.Remove(lockFile); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return false, nil
+}
+
+func (n *NFSObjectStoreDriver) refreshLock(lockFile string, stop chan struct{}) {
+	ticker := time.NewTicker(LockRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			now := time.Now()
+			if err := os.Chtimes(lockFile, now, now); err != nil {
+				log.Warnf("Failed to refresh lock %v: %v", lockFile, err)
+			}
+		}
+	}
+}
+
+func (n *NFSObjectStoreDriver) Lock(lockPath string) error {
+	lockFile := filepath.Join(n.mountPoint, lockPath)
+	if err := os.MkdirAll(filepath.Dir(lockFile), 0700); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(LockWaitTimeout)
+	for {
+		locked, err := n.tryLock(lockFile)
+		if err != nil {
+			return err
+		}
+		if locked {
+			break
+		}
+		if time.Now().After(deadline) {
+			owner, _ := ioutil.ReadFile(lockFile)
+			return fmt.Errorf("Timed out waiting for lock %v held by %v", lockPath, string(owner))
+		}
+		log.Debugf("Waiting for lock %v", lockPath)
+		time.Sleep(LockRetryInterval)
+	}
+
+	stop := make(chan struct{})
+	n.mutex.Lock()
+	n.locks[lockPath] = stop
+	n.mutex.Unlock()
+	go n.refreshLock(lockFile, stop)
+	return nil
+}
+
+func (n *NFSObjectStoreDriver) Unlock(lockPath string) error {
+	n.mutex.Lock()
+	stop, exists := n.locks[lockPath]
+	delete(n.locks, lockPath)
+	n.mutex.Unlock()
+	if !exists {
+		return fmt.Errorf("BUG: Lock %v is not held", lockPath)
+	}
+	close(stop)
+
+	lockFile := filepath.Join(n.mountPoint, lockPath)
+	if err := os.Remove(lockFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package nfs
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rancher/convoy/objectstore"
+	"gopkg.in/check.v1"
+)
+
+func TestNFS(t *testing.T) { check.TestingT(t) }
+
+type NFSTestSuite struct {
+	mountPoint string
+}
+
+var _ = check.Suite(&NFSTestSuite{})
+
+func (s *NFSTestSuite) SetUpTest(c *check.C) {
+	s.mountPoint = c.MkDir()
+	LockRetryInterval = 10 * time.Millisecond
+	LockWaitTimeout = 100 * time.Millisecond
+	LockStaleTimeout = time.Minute
+}
+
+func (s *NFSTestSuite) newDriver(c *check.C) *NFSObjectStoreDriver {
+	u, err := url.Parse("nfs://server/export/")
+	c.Assert(err, check.IsNil)
+	driver, err := newDriver(u, s.mountPoint)
+	c.Assert(err, check.IsNil)
+	return driver
+}
+
+func (s *NFSTestSuite) TestDriver(c *check.C) {
+	driver := s.newDriver(c)
+	c.Check(driver.Kind(), check.Equals, KIND)
+	c.Check(driver.GetURL(), check.Equals, "nfs://server/export")
+
+	c.Assert(ioutil.WriteFile(filepath.Join(s.mountPoint, "file"), []byte("data"), 0600), check.IsNil)
+	c.Check(driver.FileSize("file"), check.Equals, int64(4))
+
+	var _ objectstore.ObjectStoreLocker = driver
+}
+
+func (s *NFSTestSuite) TestLock(c *check.C) {
+	driver1 := s.newDriver(c)
+	driver2 := s.newDriver(c)
+	lockPath := "convoy-objectstore/locks/volume_vol.lock"
+	lockFile := filepath.Join(s.mountPoint, lockPath)
+
+	c.Assert(driver1.Lock(lockPath), check.IsNil)
+	_, err := os.Stat(lockFile)
+	c.Assert(err, check.IsNil)
+
+	// Another host would wait until timed out
+	err = driver2.Lock(lockPath)
+	c.Assert(err, check.NotNil)
+	c.Check(err, check.ErrorMatches, "Timed out waiting for lock.*")
+
+	c.Assert(driver1.Unlock(lockPath), check.IsNil)
+	_, err = os.Stat(lockFile)
+	c.Check(os.IsNotExist(err), check.Equals, true)
+	c.Check(driver1.Unlock(lockPath), check.NotNil)
+
+	c.Assert(driver2.Lock(lockPath), check.IsNil)
+	c.Assert(driver2.Unlock(lockPath), check.IsNil)
+}
+
+func (s *NFSTestSuite) TestStaleLock(c *check.C) {
+	driver := s.newDriver(c)
+	lockPath := "convoy-objectstore/locks/volume_vol.lock"
+	lockFile := filepath.Join(s.mountPoint, lockPath)
+
+	c.Assert(os.MkdirAll(filepath.Dir(lockFile), 0700), check.IsNil)
+	c.Assert(ioutil.WriteFile(lockFile, []byte("crashed:1"), 0600), check.IsNil)
+	c.Check(driver.Lock(lockPath), check.NotNil)
+
+	stale := time.Now().Add(-2 * LockStaleTimeout)
+	c.Assert(os.Chtimes(lockFile, stale, stale), check.IsNil)
+	c.Assert(driver.Lock(lockPath), check.IsNil)
+	owner, err := ioutil.ReadFile(lockFile)
+	c.Assert(err, check.IsNil)
+	c.Check(string(owner), check.Equals, lockOwner())
+	c.Assert(driver.Unlock(lockPath), check.IsNil)
+}
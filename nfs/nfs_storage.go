@@ -0,0 +1,281 @@
+package nfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rancher/convoy/util"
+	"github.com/rancher/convoy/vfs"
+
+	. "github.com/rancher/convoy/convoydriver"
+)
+
+const (
+	DRIVER_NAME        = "nfs"
+	DRIVER_CONFIG_FILE = "nfs.cfg"
+
+	MOUNTS_DIR = "mounts"
+	VFS_DIR    = "vfs"
+
+	UMOUNT_BINARY = "umount"
+
+	NFS_SERVER              = "nfs.server"
+	NFS_EXPORT              = "nfs.export"
+	NFS_MOUNT_OPTIONS       = "nfs.mountoptions"
+	NFS_DEFAULT_VOLUME_SIZE = "nfs.defaultvolumesize"
+
+	VFS_DEFAULT_VOLUME_SIZE = "vfs.defaultvolumesize"
+)
+
+var (
+	// The export would be checked every HealthCheckInterval, and considered
+	// unhealthy if it cannot be accessed in HealthCheckTimeout, e.g. the
+	// server is down with a hard mount
+	HealthCheckInterval = 30 * time.Second
+	HealthCheckTimeout  = 10 * time.Second
+)
+
+/*
+Driver mounts the NFS export by itself, and manages the volumes as the
+subdirectories of the export through vfs driver. The mount would be checked
+periodically, and remounted if the server is unreachable or the mount is gone.
+*/
+type Driver struct {
+	ConvoyDriver
+	Device
+
+	mutex       *sync.RWMutex
+	healthy     bool
+	lastChecked time.Time
+	lastError   error
+}
+
+type Device struct {
+	Root         string
+	Server       string
+	Export       string
+	MountOptions string
+	MountPoint   string
+}
+
+func (dev *Device) ConfigFile() (string, error) {
+	if dev.Root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty device config path")
+	}
+	return filepath.Join(dev.Root, DRIVER_CONFIG_FILE), nil
+}
+
+func (dev *Device) source() string {
+	return dev.Server + ":" + dev.Export
+}
+
+func init() {
+	if err := Register(DRIVER_NAME, Init); err != nil {
+		panic(err)
+	}
+}
+
+func Init(root string, config map[string]string) (ConvoyDriver, error) {
+	dev := &Device{
+		Root: root,
+	}
+	exists, err := util.ObjectExists(dev)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if err := util.ObjectLoad(dev); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := util.MkdirIfNotExists(root); err != nil {
+			return nil, err
+		}
+		dev.Server = config[NFS_SERVER]
+		if dev.Server == "" {
+			return nil, fmt.Errorf("Missing required parameter: %v", NFS_SERVER)
+		}
+		if config[NFS_EXPORT] == "" {
+			return nil, fmt.Errorf("Missing required parameter: %v", NFS_EXPORT)
+		}
+		dev.Export = filepath.Clean(config[NFS_EXPORT])
+		dev.MountOptions = config[NFS_MOUNT_OPTIONS]
+		dev.MountPoint = filepath.Join(root, MOUNTS_DIR, dev.Server, dev.Export)
+	}
+
+	if err := mountExport(dev.source(), dev.MountPoint, dev.MountOptions); err != nil {
+		return nil, err
+	}
+
+	vfsConfig := map[string]string{
+		vfs.VFS_PATH: dev.MountPoint,
+	}
+	if size, exists := config[NFS_DEFAULT_VOLUME_SIZE]; exists {
+		vfsConfig[VFS_DEFAULT_VOLUME_SIZE] = size
+	}
+	vfsDriver, err := vfs.Init(filepath.Join(root, VFS_DIR), vfsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := util.ObjectSave(dev); err != nil {
+		return nil, err
+	}
+	d := &Driver{
+		ConvoyDriver: vfsDriver,
+		Device:       *dev,
+		mutex:        &sync.RWMutex{},
+		healthy:      true,
+		lastChecked:  time.Now(),
+	}
+	go d.monitor()
+	return d, nil
+}
+
+func (d *Driver) Name() string {
+	return DRIVER_NAME
+}
+
+func (d *Driver) Info() (map[string]string, error) {
+	info, err := d.ConvoyDriver.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	info["Driver"] = d.Name()
+	info["Root"] = d.Root
+	info["Server"] = d.Server
+	info["Export"] = d.Export
+	info["MountOptions"] = d.MountOptions
+	info["MountPoint"] = d.MountPoint
+	info["Healthy"] = strconv.FormatBool(d.healthy)
+	info["LastHealthCheck"] = d.lastChecked.UTC().Format(time.RFC3339)
+	if d.lastError != nil {
+		info["LastHealthError"] = d.lastError.Error()
+	}
+	return info, nil
+}
+
+type volumeOps struct {
+	VolumeOperations
+	d *Driver
+}
+
+// VolumeOps makes sure the export is mounted before the volume is created or
+// mounted, rather than waiting for the next health check
+func (d *Driver) VolumeOps() (VolumeOperations, error) {
+	ops, err := d.ConvoyDriver.VolumeOps()
+	if err != nil {
+		return nil, err
+	}
+	return &volumeOps{
+		VolumeOperations: ops,
+		d:                d,
+	}, nil
+}
+
+func (v *volumeOps) CreateVolume(req Request) error {
+	if err := v.d.ensureMounted(); err != nil {
+		return err
+	}
+	return v.VolumeOperations.CreateVolume(req)
+}
+
+func (v *volumeOps) MountVolume(req Request) (string, error) {
+	if err := v.d.ensureMounted(); err != nil {
+		return "", err
+	}
+	return v.VolumeOperations.MountVolume(req)
+}
+
+func (d *Driver) monitor() {
+	ticker := time.NewTicker(HealthCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := d.ensureMounted(); err != nil {
+			log.Errorf("Failed to recover NFS export %v: %v", d.source(), err)
+		}
+	}
+}
+
+// ensureMounted checks the health of the export, and remounts it if it's
+// unhealthy
+func (d *Driver) ensureMounted() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.lastChecked = time.Now()
+	err := checkHealth(d.MountPoint, HealthCheckTimeout)
+	if err == nil {
+		if !d.healthy {
+			log.Infof("NFS export %v is healthy again", d.source())
+		}
+		d.healthy = true
+		d.lastError = nil
+		return nil
+	}
+
+	log.Warnf("NFS export %v is unhealthy: %v, remounting", d.source(), err)
+	d.healthy = false
+	d.lastError = err
+	if err := remountExport(d.source(), d.MountPoint, d.MountOptions); err != nil {
+		d.lastError = err
+		return err
+	}
+	if err := checkHealth(d.MountPoint, HealthCheckTimeout); err != nil {
+		d.lastError = err
+		return err
+	}
+	log.Infof("NFS export %v has been remounted at %v", d.source(), d.MountPoint)
+	d.healthy = true
+	d.lastError = nil
+	return nil
+}
+
+// checkHealth verifies the mount point is still mounted and accessible.
+// Accessing a hard mounted export of an unreachable server would hang, so it
+// would be considered as failure after timeout.
+func checkHealth(mountPoint string, timeout time.Duration) error {
+	mounted, err := isMounted(mountPoint)
+	if err != nil {
+		return err
+	}
+	if !mounted {
+		return fmt.Errorf("%v is not mounted", mountPoint)
+	}
+	return statWithTimeout(mountPoint, timeout)
+}
+
+func statWithTimeout(path string, timeout time.Duration) error {
+	result := make(chan error, 1)
+	go func() {
+		_, err := os.Stat(path)
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("Timed out accessing %v", path)
+	}
+}
+
+func remountExport(source, mountPoint, options string) error {
+	mounted, err := isMounted(mountPoint)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		// Lazy umount won't block on the unreachable server
+		if _, err := util.Execute(UMOUNT_BINARY, []string{"-f", "-l", mountPoint}); err != nil {
+			return fmt.Errorf("Failed to umount %v: %v", mountPoint, err)
+		}
+	}
+	return mountExport(source, mountPoint, options)
+}
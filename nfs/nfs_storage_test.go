@@ -0,0 +1,17 @@
+package nfs
+
+import (
+	"path/filepath"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *NFSTestSuite) TestHealthCheck(c *check.C) {
+	err := checkHealth(s.mountPoint, time.Second)
+	c.Assert(err, check.NotNil)
+	c.Check(err, check.ErrorMatches, ".* is not mounted")
+
+	c.Check(statWithTimeout(s.mountPoint, time.Second), check.IsNil)
+	c.Check(statWithTimeout(filepath.Join(s.mountPoint, "missing"), time.Second), check.NotNil)
+}
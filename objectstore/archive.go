@@ -0,0 +1,113 @@
+package objectstore
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	ARCHIVE_STATUS_AVAILABLE  = "available"
+	ARCHIVE_STATUS_ARCHIVED   = "archived"
+	ARCHIVE_STATUS_RETRIEVING = "retrieving"
+)
+
+// ObjectStoreArchiver is an optional interface of ObjectStoreDriver, for the
+// destinations with archive storage tier, e.g. S3 Glacier. The archived files
+// cannot be read until they're retrieved, which may take hours.
+type ObjectStoreArchiver interface {
+	ArchiveStatus(filePath string) (string, error)
+	// RetrieveFile would only start the retrieval
+	RetrieveFile(filePath string) error
+}
+
+// stageBackup starts the retrieval of all the archived blocks of the backup,
+// and returns the status of retrieval
+func stageBackup(backup *Backup, archiver ObjectStoreArchiver) (map[string]string, error) {
+	blkFiles := map[string]bool{}
+	available, retrieving := 0, 0
+	for _, block := range getStoredBlocks(backup.Blocks) {
+		blkFile := getBlockFilePath(backup.VolumeName, block)
+		if blkFiles[blkFile] {
+			continue
+		}
+		blkFiles[blkFile] = true
+
+		status, err := archiver.ArchiveStatus(blkFile)
+		if err != nil {
+			return nil, err
+		}
+		switch status {
+		case ARCHIVE_STATUS_AVAILABLE:
+			available++
+		case ARCHIVE_STATUS_ARCHIVED:
+			if err := archiver.RetrieveFile(blkFile); err != nil {
+				return nil, err
+			}
+			retrieving++
+		case ARCHIVE_STATUS_RETRIEVING:
+			retrieving++
+		default:
+			return nil, fmt.Errorf("BUG: Unknown archive status %v of %v", status, blkFile)
+		}
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_EVENT:  LOG_EVENT_RESTORE,
+		LOG_FIELD_VOLUME: backup.VolumeName,
+	}).Debugf("Staged backup %v from archive, %v blocks available, %v blocks retrieving",
+		backup.Name, available, retrieving)
+
+	return map[string]string{
+		"BackupName":       backup.Name,
+		"VolumeName":       backup.VolumeName,
+		"TotalBlocks":      strconv.Itoa(len(blkFiles)),
+		"AvailableBlocks":  strconv.Itoa(available),
+		"RetrievingBlocks": strconv.Itoa(retrieving),
+		"Ready":            strconv.FormatBool(retrieving == 0),
+	}, nil
+}
+
+func stagingError(info map[string]string) error {
+	return fmt.Errorf("Backup %v is being retrieved from archive, %v of %v blocks are available, please retry later",
+		info["BackupName"], info["AvailableBlocks"], info["TotalBlocks"])
+}
+
+// RetrieveBackup starts the retrieval of the archived blocks of the backup.
+// It can be called repeatedly to poll the status, until "Ready" is "true".
+func RetrieveBackup(backupURL, endpoint string) (map[string]string, error) {
+	driver, err := GetObjectStoreDriver(backupURL, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	backupName, volumeName, err := decodeBackupURL(backupURL)
+	if err != nil {
+		return nil, err
+	}
+	backup, err := loadBackup(backupName, volumeName, driver)
+	if err != nil {
+		return nil, err
+	}
+
+	var info map[string]string
+	if archiver, ok := unthrottled(driver).(ObjectStoreArchiver); ok {
+		if info, err = stageBackup(backup, archiver); err != nil {
+			return nil, err
+		}
+	} else {
+		// Nothing would be archived
+		total := strconv.Itoa(len(getStoredBlocks(backup.Blocks)))
+		info = map[string]string{
+			"BackupName":       backup.Name,
+			"VolumeName":       backup.VolumeName,
+			"TotalBlocks":      total,
+			"AvailableBlocks":  total,
+			"RetrievingBlocks": "0",
+			"Ready":            "true",
+		}
+	}
+	info["BackupURL"] = encodeBackupURL(backup.Name, backup.VolumeName, driver.GetURL())
+	return info, nil
+}
@@ -0,0 +1,49 @@
+package objectstore
+
+import (
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) archiveBlocks(checksums ...string) {
+	for _, checksum := range checksums {
+		memStore.archived[testBlockFile(checksum)] = ARCHIVE_STATUS_ARCHIVED
+	}
+}
+
+func (s *TestSuite) TestRetrieveBackup(c *check.C) {
+	s.createTestBackupChain(c)
+	s.archiveBlocks("aaaa3333", "bbbb2222")
+	backupURL := encodeBackupURL("backup-3", testVolumeName, MEM_URL)
+
+	info, err := RetrieveBackup(backupURL, "")
+	c.Assert(err, check.IsNil)
+	c.Check(info["BackupURL"], check.Equals, backupURL)
+	c.Check(info["TotalBlocks"], check.Equals, "2")
+	c.Check(info["AvailableBlocks"], check.Equals, "0")
+	c.Check(info["RetrievingBlocks"], check.Equals, "2")
+	c.Check(info["Ready"], check.Equals, "false")
+	c.Check(memStore.archived[testBlockFile("aaaa3333")], check.Equals, ARCHIVE_STATUS_RETRIEVING)
+
+	// Retrieval completed
+	memStore.archived = map[string]string{}
+	info, err = RetrieveBackup(backupURL, "")
+	c.Assert(err, check.IsNil)
+	c.Check(info["AvailableBlocks"], check.Equals, "2")
+	c.Check(info["Ready"], check.Equals, "true")
+}
+
+func (s *TestSuite) TestRestoreArchivedBackup(c *check.C) {
+	s.createTestBackupChain(c)
+	volume, err := loadVolume(testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	volume.Size = 2 * DEFAULT_BLOCK_SIZE
+	c.Assert(saveVolume(volume, memStore), check.IsNil)
+
+	s.archiveBlocks("aaaa3333")
+	backupURL := encodeBackupURL("backup-3", testVolumeName, MEM_URL)
+	err = RestoreDeltaBlockBackup(backupURL, "", filepath.Join(c.MkDir(), "volume"))
+	c.Assert(err, check.ErrorMatches, "Backup backup-3 is being retrieved from archive, 1 of 2 blocks are available, please retry later")
+	c.Check(memStore.archived[testBlockFile("aaaa3333")], check.Equals, ARCHIVE_STATUS_RETRIEVING)
+}
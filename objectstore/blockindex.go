@@ -0,0 +1,181 @@
+package objectstore
+
+import (
+	"path/filepath"
+	"sort"
+)
+
+const (
+	BLOCK_INDEX_FILE = "block_index.cfg"
+)
+
+/*
+BlockIndex is the reference index of a volume, which counts the backups
+referencing each block file, so deleting a backup only needs to look at the
+blocks of that backup rather than all the backups of the volume. The backups
+counted are recorded as well, and the index would be rebuilt from the
+backups if they don't match the ones in the objectstore, e.g. the index was
+written by older versions, or the backup creation or deletion was
+interrupted. The index of a backup is added before its config is saved and
+removed before its config is removed, so it would never miss a block in use
+without being rebuilt.
+*/
+type BlockIndex struct {
+	VolumeName string
+	Backups    []string
+	Refs       map[string]int
+}
+
+func newBlockIndex(volumeName string) *BlockIndex {
+	return &BlockIndex{
+		VolumeName: volumeName,
+		Backups:    []string{},
+		Refs:       map[string]int{},
+	}
+}
+
+func getBlockIndexFilePath(volumeName string) string {
+	return filepath.Join(getVolumePath(volumeName), BLOCK_INDEX_FILE)
+}
+
+// getBackupBlockFiles returns the distinct block files used by the backup
+func getBackupBlockFiles(backup *Backup) map[string]bool {
+	result := map[string]bool{}
+	for _, blk := range getStoredBlocks(backup.Blocks) {
+		result[getBlockFilePath(backup.VolumeName, blk)] = true
+	}
+	return result
+}
+
+func (index *BlockIndex) hasBackup(backupName string) bool {
+	for _, name := range index.Backups {
+		if name == backupName {
+			return true
+		}
+	}
+	return false
+}
+
+func (index *BlockIndex) add(backup *Backup) {
+	if index.hasBackup(backup.Name) {
+		return
+	}
+	for blkFile := range getBackupBlockFiles(backup) {
+		index.Refs[blkFile]++
+	}
+	index.Backups = append(index.Backups, backup.Name)
+	sort.Strings(index.Backups)
+}
+
+// remove returns the block files no longer referenced by any backup
+func (index *BlockIndex) remove(backup *Backup) []string {
+	result := []string{}
+	if !index.hasBackup(backup.Name) {
+		return result
+	}
+	for blkFile := range getBackupBlockFiles(backup) {
+		index.Refs[blkFile]--
+		if index.Refs[blkFile] <= 0 {
+			delete(index.Refs, blkFile)
+			result = append(result, blkFile)
+		}
+	}
+	backups := []string{}
+	for _, name := range index.Backups {
+		if name != backup.Name {
+			backups = append(backups, name)
+		}
+	}
+	index.Backups = backups
+	sort.Strings(result)
+	return result
+}
+
+// matches checks if the index counts exactly the backups in backupNames
+func (index *BlockIndex) matches(backupNames []string) bool {
+	if len(index.Backups) != len(backupNames) {
+		return false
+	}
+	names := append([]string{}, backupNames...)
+	sort.Strings(names)
+	for i := range names {
+		if names[i] != index.Backups[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// loadBlockIndex returns the reference index of the volume, it would be
+// rebuilt from the backups of the volume if it's missing or out of date
+func loadBlockIndex(volumeName string, driver ObjectStoreDriver) (*BlockIndex, error) {
+	backupNames, err := getBackupNamesForVolume(volumeName, driver)
+	if err != nil {
+		return nil, err
+	}
+	filePath := getBlockIndexFilePath(volumeName)
+	if driver.FileExists(filePath) {
+		aead, err := getObjectStoreCipher(driver)
+		if err != nil {
+			return nil, err
+		}
+		index := &BlockIndex{}
+		if err := loadEncryptedConfigInObjectStore(filePath, driver, aead, index); err != nil {
+			return nil, err
+		}
+		if index.Refs == nil {
+			index.Refs = map[string]int{}
+		}
+		if index.matches(backupNames) {
+			return index, nil
+		}
+		log.Debugf("Block index of volume %v is out of date, rebuilding it", volumeName)
+	}
+
+	index := newBlockIndex(volumeName)
+	for _, backupName := range backupNames {
+		backup, err := loadBackup(backupName, volumeName, driver)
+		if err != nil {
+			return nil, err
+		}
+		index.add(backup)
+	}
+	return index, nil
+}
+
+func saveBlockIndex(index *BlockIndex, driver ObjectStoreDriver) error {
+	aead, err := getObjectStoreCipher(driver)
+	if err != nil {
+		return err
+	}
+	return saveEncryptedConfigInObjectStore(getBlockIndexFilePath(index.VolumeName), driver, aead, index)
+}
+
+// indexBackup adds the blocks of the backup to the reference index of the
+// volume, it must be called before the backup config is saved
+func indexBackup(backup *Backup, driver ObjectStoreDriver) error {
+	index, err := loadBlockIndex(backup.VolumeName, driver)
+	if err != nil {
+		return err
+	}
+	if index.hasBackup(backup.Name) {
+		return nil
+	}
+	index.add(backup)
+	return saveBlockIndex(index, driver)
+}
+
+// unindexBackup removes the blocks of the backup from the reference index of
+// the volume, and returns the block files no longer referenced by any other
+// backup. It must be called before the backup config is removed.
+func unindexBackup(backup *Backup, driver ObjectStoreDriver) ([]string, error) {
+	index, err := loadBlockIndex(backup.VolumeName, driver)
+	if err != nil {
+		return nil, err
+	}
+	unused := index.remove(backup)
+	if err := saveBlockIndex(index, driver); err != nil {
+		return nil, err
+	}
+	return unused, nil
+}
@@ -0,0 +1,42 @@
+package objectstore
+
+import (
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestBlockIndex(c *check.C) {
+	s.createTestBackupChain(c)
+
+	// The index missing would be rebuilt from the backups
+	index, err := loadBlockIndex(testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(index.Backups, check.DeepEquals, []string{"backup-1", "backup-2", "backup-3"})
+	c.Assert(index.Refs[testBlockFile("aaaa1111")], check.Equals, 2)
+	c.Assert(index.Refs[testBlockFile("bbbb2222")], check.Equals, 2)
+	c.Assert(index.Refs[testBlockFile("aaaa3333")], check.Equals, 1)
+
+	c.Assert(DeleteDeltaBlockBackup(encodeBackupURL("backup-3", testVolumeName, MEM_URL), "", false), check.IsNil)
+	c.Assert(memStore.FileExists(getBlockIndexFilePath(testVolumeName)), check.Equals, true)
+	c.Assert(memStore.FileExists(testBlockFile("aaaa3333")), check.Equals, false)
+	index, err = loadBlockIndex(testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(index.Backups, check.DeepEquals, []string{"backup-1", "backup-2"})
+	c.Assert(index.Refs[testBlockFile("bbbb2222")], check.Equals, 1)
+	_, exists := index.Refs[testBlockFile("aaaa3333")]
+	c.Assert(exists, check.Equals, false)
+
+	// The backup saved without updating the index, e.g. by an older
+	// version, would make the index rebuilt rather than its blocks removed
+	s.createTestBackup(c, "backup-4", "backup-2", "aaaa1111", "bbbb2222")
+	c.Assert(DeleteDeltaBlockBackup(encodeBackupURL("backup-2", testVolumeName, MEM_URL), "", true), check.IsNil)
+	c.Assert(memStore.FileExists(testBlockFile("aaaa1111")), check.Equals, true)
+	c.Assert(memStore.FileExists(testBlockFile("bbbb2222")), check.Equals, true)
+
+	c.Assert(DeleteDeltaBlockBackup(encodeBackupURL("backup-4", testVolumeName, MEM_URL), "", false), check.IsNil)
+	c.Assert(memStore.FileExists(testBlockFile("aaaa1111")), check.Equals, true)
+	c.Assert(memStore.FileExists(testBlockFile("bbbb2222")), check.Equals, false)
+	index, err = loadBlockIndex(testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(index.Backups, check.DeepEquals, []string{"backup-1"})
+	c.Assert(index.Refs, check.HasLen, 2)
+}
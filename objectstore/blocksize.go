@@ -0,0 +1,52 @@
+package objectstore
+
+import (
+	"fmt"
+)
+
+const (
+	MIN_BLOCK_SIZE = 64 * 1024
+	MAX_BLOCK_SIZE = 64 * 1024 * 1024
+)
+
+var (
+	defaultBlockSize int64 = DEFAULT_BLOCK_SIZE
+)
+
+// ValidateBlockSize checks the block size is a power of 2 between
+// MIN_BLOCK_SIZE and MAX_BLOCK_SIZE
+func ValidateBlockSize(blockSize int64) error {
+	if blockSize < MIN_BLOCK_SIZE || blockSize > MAX_BLOCK_SIZE || blockSize&(blockSize-1) != 0 {
+		return fmt.Errorf("Invalid block size %v, should be a power of 2 between %v and %v",
+			blockSize, MIN_BLOCK_SIZE, MAX_BLOCK_SIZE)
+	}
+	return nil
+}
+
+// SetDefaultBlockSize sets the block size used by the objectstores which
+// haven't been configured, e.g. the new ones
+func SetDefaultBlockSize(blockSize int64) error {
+	if err := ValidateBlockSize(blockSize); err != nil {
+		return err
+	}
+	defaultBlockSize = blockSize
+	return nil
+}
+
+// getBlockSize returns the block size of the objectstore. The objectstores
+// configured before the block size was configurable use DEFAULT_BLOCK_SIZE.
+func (config *ObjectStoreConfig) getBlockSize() int64 {
+	if config.BlockSize == 0 {
+		return DEFAULT_BLOCK_SIZE
+	}
+	return config.BlockSize
+}
+
+// getBlockSize returns the block size of the backup. The backups created
+// before the block size was recorded use DEFAULT_BLOCK_SIZE.
+func (backup *Backup) getBlockSize() int64 {
+	if backup.BlockSize == 0 {
+		return DEFAULT_BLOCK_SIZE
+	}
+	return backup.BlockSize
+}
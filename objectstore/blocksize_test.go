@@ -0,0 +1,74 @@
+package objectstore
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/rancher/convoy/metadata"
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestValidateBlockSize(c *check.C) {
+	c.Assert(ValidateBlockSize(DEFAULT_BLOCK_SIZE), check.IsNil)
+	c.Assert(ValidateBlockSize(MIN_BLOCK_SIZE), check.IsNil)
+	c.Assert(ValidateBlockSize(MAX_BLOCK_SIZE), check.IsNil)
+	c.Assert(ValidateBlockSize(MIN_BLOCK_SIZE/2), check.ErrorMatches, "Invalid block size .*")
+	c.Assert(ValidateBlockSize(MAX_BLOCK_SIZE*2), check.ErrorMatches, "Invalid block size .*")
+	c.Assert(ValidateBlockSize(3*MIN_BLOCK_SIZE), check.ErrorMatches, "Invalid block size .*")
+	c.Assert(SetDefaultBlockSize(0), check.ErrorMatches, "Invalid block size .*")
+}
+
+func (s *TestSuite) TestBackupBlockSize(c *check.C) {
+	defer SetDefaultBlockSize(DEFAULT_BLOCK_SIZE)
+	c.Assert(SetDefaultBlockSize(2*DEFAULT_BLOCK_SIZE), check.IsNil)
+
+	// The volume size is not a multiple of the block size of objectstore
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: 0, Size: DEFAULT_BLOCK_SIZE},
+			{Offset: 2 * DEFAULT_BLOCK_SIZE, Size: DEFAULT_BLOCK_SIZE},
+		},
+	}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+		Size:   3 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL1, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-1"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	config, err := loadObjectStoreConfig(memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(config.BlockSize, check.Equals, int64(2*DEFAULT_BLOCK_SIZE))
+
+	backupName1, _, err := decodeBackupURL(backupURL1)
+	c.Assert(err, check.IsNil)
+	backup1, err := loadBackup(backupName1, testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup1.BlockSize, check.Equals, int64(2*DEFAULT_BLOCK_SIZE))
+	c.Assert(backup1.Blocks, check.HasLen, 2)
+	c.Assert(backup1.Blocks[1].Offset, check.Equals, int64(2*DEFAULT_BLOCK_SIZE))
+
+	volFile := filepath.Join(c.MkDir(), "volume")
+	c.Assert(RestoreDeltaBlockBackup(backupURL1, "", volFile), check.IsNil)
+	data, err := ioutil.ReadFile(volFile)
+	c.Assert(err, check.IsNil)
+	c.Assert(data, check.HasLen, 3*DEFAULT_BLOCK_SIZE)
+	// Read with the block at offset 0
+	c.Assert(data[DEFAULT_BLOCK_SIZE], check.Equals, byte(1))
+	c.Assert(data[2*DEFAULT_BLOCK_SIZE], check.Equals, byte(3))
+
+	// The objectstore configured later wouldn't be affected by the default
+	config.BlockSize = DEFAULT_BLOCK_SIZE
+	c.Assert(saveConfigInObjectStore(getObjectStoreConfigPath(), memStore, config), check.IsNil)
+	backupURL2, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-2"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	backupName2, _, err := decodeBackupURL(backupURL2)
+	c.Assert(err, check.IsNil)
+	backup2, err := loadBackup(backupName2, testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	// Blocks of different sizes cannot be merged with the last backup
+	c.Assert(backup2.ParentBackupName, check.Equals, "")
+	c.Assert(backup2.BlockSize, check.Equals, int64(DEFAULT_BLOCK_SIZE))
+	c.Assert(backup2.Blocks, check.HasLen, 2)
+	c.Assert(backup2.Blocks[1].Offset, check.Equals, int64(2*DEFAULT_BLOCK_SIZE))
+}
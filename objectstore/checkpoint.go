@@ -0,0 +1,218 @@
+package objectstore
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sync"
+
+	"github.com/rancher/convoy/metadata"
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	CHECKPOINT_DIRECTORY     = "checkpoints"
+	CHECKPOINT_CONFIG_PREFIX = "checkpoint_"
+
+	// The checkpoint would be saved every time these many more blocks have
+	// been written
+	CHECKPOINT_INTERVAL_BLOCKS = 256
+)
+
+/*
+Checkpoint records the blocks which have been written to objectstore by an
+interrupted backup of the snapshot. Backing up the same snapshot again would
+resume from it, without reading and hashing these blocks again, as long as
+the changed blocks compared to the last snapshot are still the same.
+*/
+type Checkpoint struct {
+	VolumeName       string
+	SnapshotName     string
+	LastSnapshotName string
+	// The checksum of the mappings of the changed blocks
+	DeltaChecksum string
+	// The hash algorithm of the block checksums, HASH_SHA512 if it's empty
+	Hash string `json:",omitempty"`
+	// The completed blocks, in the order of offsets. The next block to back
+	// up is the one at index len(Blocks) of the delta.
+	Blocks      []BlockMapping
+	UpdatedTime string
+}
+
+func getCheckpointPath(volumeName string) string {
+	return filepath.Join(getVolumePath(volumeName), CHECKPOINT_DIRECTORY) + "/"
+}
+
+func getCheckpointConfigPath(snapshotName, volumeName string) string {
+	return filepath.Join(getCheckpointPath(volumeName), CHECKPOINT_CONFIG_PREFIX+snapshotName+CFG_SUFFIX)
+}
+
+func getCheckpointNamesForVolume(volumeName string, driver ObjectStoreDriver) ([]string, error) {
+	result := []string{}
+	fileList, err := driver.List(getCheckpointPath(volumeName))
+	if err != nil {
+		// path doesn't exist
+		return result, nil
+	}
+	return util.ExtractNames(fileList, CHECKPOINT_CONFIG_PREFIX, CFG_SUFFIX)
+}
+
+// loadCheckpoint returns nil if there is no checkpoint of the snapshot
+func loadCheckpoint(snapshotName, volumeName string, driver ObjectStoreDriver) (*Checkpoint, error) {
+	filePath := getCheckpointConfigPath(snapshotName, volumeName)
+	if !driver.FileExists(filePath) {
+		return nil, nil
+	}
+	aead, err := getObjectStoreCipher(driver)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := &Checkpoint{}
+	if err := loadEncryptedConfigInObjectStore(filePath, driver, aead, checkpoint); err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+func saveCheckpoint(checkpoint *Checkpoint, driver ObjectStoreDriver) error {
+	aead, err := getObjectStoreCipher(driver)
+	if err != nil {
+		return err
+	}
+	checkpoint.UpdatedTime = util.Now()
+	filePath := getCheckpointConfigPath(checkpoint.SnapshotName, checkpoint.VolumeName)
+	return saveEncryptedConfigInObjectStore(filePath, driver, aead, checkpoint)
+}
+
+func removeCheckpoint(snapshotName, volumeName string, driver ObjectStoreDriver) error {
+	filePath := getCheckpointConfigPath(snapshotName, volumeName)
+	if !driver.FileExists(filePath) {
+		return nil
+	}
+	if err := driver.Remove(filePath); err != nil {
+		return err
+	}
+	log.Debugf("Removed checkpoint %v on objectstore", filePath)
+	return nil
+}
+
+// getCheckpointBlockFiles returns the block files referenced by the
+// checkpoints of the volume, which shouldn't be removed since the backups
+// resumed from the checkpoints would refer to them
+func getCheckpointBlockFiles(volumeName string, driver ObjectStoreDriver) (map[string]bool, error) {
+	names, err := getCheckpointNamesForVolume(volumeName, driver)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]bool{}
+	for _, name := range names {
+		checkpoint, err := loadCheckpoint(name, volumeName, driver)
+		if err != nil {
+			return nil, err
+		}
+		if checkpoint == nil {
+			continue
+		}
+		for _, blk := range getStoredBlocks(checkpoint.Blocks) {
+			result[getBlockFilePath(volumeName, blk)] = true
+		}
+	}
+	return result, nil
+}
+
+// truncate drops the blocks since the first one doesn't exist in
+// objectstore, e.g. the one being written by another worker when the backup
+// failed, and returns the number of the remaining blocks
+func (c *Checkpoint) truncate(driver ObjectStoreDriver) int {
+	checked := map[string]bool{}
+	for i, blk := range c.Blocks {
+		blkFile := getBlockFilePath(c.VolumeName, blk)
+		if checked[blkFile] || isZeroBlock(blk) {
+			continue
+		}
+		if driver.FileSize(blkFile) < 0 {
+			log.Debugf("Cannot find block %v of checkpoint of snapshot %v", blkFile, c.SnapshotName)
+			c.Blocks = c.Blocks[:i]
+			break
+		}
+		checked[blkFile] = true
+	}
+	return len(c.Blocks)
+}
+
+func (c *Checkpoint) getHash() string {
+	if c.Hash == "" {
+		return HASH_SHA512
+	}
+	return c.Hash
+}
+
+func getDeltaChecksum(delta *metadata.Mappings) (string, error) {
+	j, err := json.Marshal(delta)
+	if err != nil {
+		return "", err
+	}
+	return util.GetChecksum(j), nil
+}
+
+// checkpointer tracks the blocks completed by the backup workers, which may
+// complete out of order, and saves the completed ones in the order of offsets
+type checkpointer struct {
+	mutex      *sync.Mutex
+	checkpoint *Checkpoint
+	blocks     []BlockMapping
+	completed  []bool
+	next       int
+	saved      int
+	driver     ObjectStoreDriver
+}
+
+func newCheckpointer(checkpoint *Checkpoint, blocks []BlockMapping, driver ObjectStoreDriver) *checkpointer {
+	cp := &checkpointer{
+		mutex:      &sync.Mutex{},
+		checkpoint: checkpoint,
+		blocks:     blocks,
+		completed:  make([]bool, len(blocks)),
+		driver:     driver,
+	}
+	for i := range checkpoint.Blocks {
+		cp.completed[i] = true
+	}
+	cp.next = len(checkpoint.Blocks)
+	cp.saved = cp.next
+	return cp
+}
+
+func (cp *checkpointer) complete(index int, mapping BlockMapping) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	cp.blocks[index] = mapping
+	cp.completed[index] = true
+	for cp.next < len(cp.completed) && cp.completed[cp.next] {
+		cp.next++
+	}
+	if cp.next-cp.saved >= CHECKPOINT_INTERVAL_BLOCKS {
+		cp.saveLocked()
+	}
+}
+
+// save records the completed blocks, it's called when the backup failed
+func (cp *checkpointer) save() {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	if cp.next > cp.saved {
+		cp.saveLocked()
+	}
+}
+
+func (cp *checkpointer) saveLocked() {
+	cp.checkpoint.Blocks = append([]BlockMapping{}, cp.blocks[:cp.next]...)
+	if err := saveCheckpoint(cp.checkpoint, cp.driver); err != nil {
+		// The backup could still go on, it just can't resume from here
+		log.Warnf("Failed to save checkpoint of snapshot %v: %v", cp.checkpoint.SnapshotName, err)
+		return
+	}
+	cp.saved = cp.next
+	log.Debugf("Saved checkpoint of snapshot %v at block %v/%v", cp.checkpoint.SnapshotName, cp.next, len(cp.blocks))
+}
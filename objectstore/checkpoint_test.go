@@ -0,0 +1,96 @@
+package objectstore
+
+import (
+	"github.com/rancher/convoy/metadata"
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestResumeDeltaBlockBackup(c *check.C) {
+	defer SetBackupWorkers(DEFAULT_BACKUP_WORKERS)
+	c.Assert(SetBackupWorkers(1), check.IsNil)
+
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: 0, Size: 16 * DEFAULT_BLOCK_SIZE},
+		},
+		failAt: 10 * DEFAULT_BLOCK_SIZE,
+	}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+	}
+	snapshot := &Snapshot{
+		Name: "snapshot",
+	}
+	_, err := CreateDeltaBlockBackup(volume, snapshot, MEM_URL, "", deltaOps)
+	c.Assert(err, check.ErrorMatches, "Failed to read at .*")
+
+	checkpoint, err := loadCheckpoint("snapshot", testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(checkpoint, check.NotNil)
+	c.Assert(checkpoint.Blocks, check.HasLen, 10)
+
+	// The blocks of the checkpoint are still in use
+	report, err := GarbageCollect(MEM_URL, "", testVolumeName, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.UnusedBlocks, check.Equals, 0)
+	c.Assert(volumeExists(testVolumeName, memStore), check.Equals, true)
+
+	deltaOps.failAt = 0
+	deltaOps.reads = nil
+	backupURL, err := CreateDeltaBlockBackup(volume, snapshot, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	c.Assert(deltaOps.reads, check.HasLen, 6)
+	c.Assert(deltaOps.reads[0], check.Equals, int64(10*DEFAULT_BLOCK_SIZE))
+
+	backupName, _, err := decodeBackupURL(backupURL)
+	c.Assert(err, check.IsNil)
+	backup, err := loadBackup(backupName, testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup.Blocks, check.HasLen, 16)
+	for i, block := range backup.Blocks {
+		c.Assert(block.Offset, check.Equals, int64(i)*DEFAULT_BLOCK_SIZE)
+		c.Assert(memStore.FileExists(getBlockFilePath(testVolumeName, block)), check.Equals, true)
+	}
+
+	checkpoint, err = loadCheckpoint("snapshot", testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(checkpoint, check.IsNil)
+}
+
+func (s *TestSuite) TestResumeDeltaBlockBackupChanged(c *check.C) {
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: 0, Size: 8 * DEFAULT_BLOCK_SIZE},
+		},
+		failAt: 6 * DEFAULT_BLOCK_SIZE,
+	}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+	}
+	snapshot := &Snapshot{
+		Name: "snapshot",
+	}
+	_, err := CreateDeltaBlockBackup(volume, snapshot, MEM_URL, "", deltaOps)
+	c.Assert(err, check.NotNil)
+
+	// The checkpoint doesn't match the changed blocks any more
+	deltaOps.mappings = []metadata.Mapping{
+		{Offset: 0, Size: 4 * DEFAULT_BLOCK_SIZE},
+	}
+	deltaOps.failAt = 0
+	deltaOps.reads = nil
+	_, err = CreateDeltaBlockBackup(volume, snapshot, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	c.Assert(deltaOps.reads, check.HasLen, 4)
+
+	// The missing blocks of the checkpoint would be backed up again
+	checkpoint := &Checkpoint{
+		VolumeName: testVolumeName,
+		Blocks: []BlockMapping{
+			{Offset: 0, BlockChecksum: "aaaa1111"},
+		},
+	}
+	c.Assert(checkpoint.truncate(memStore), check.Equals, 0)
+}
@@ -0,0 +1,402 @@
+package objectstore
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/rancher/convoy/metadata"
+)
+
+const (
+	CHUNKING_FIXED = "fixed"
+	CHUNKING_CDC   = "cdc"
+
+	DEFAULT_CHUNKING = CHUNKING_FIXED
+
+	// The seed of the gear table, which must never be changed, otherwise
+	// the chunks of the same content would no longer be deduplicated
+	gearSeed = 0x436f6e766f794344
+)
+
+var (
+	defaultChunking = DEFAULT_CHUNKING
+
+	gearTable [256]uint64
+)
+
+func init() {
+	// splitmix64, so the table is the same everywhere
+	x := uint64(gearSeed)
+	for i := range gearTable {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gearTable[i] = z ^ (z >> 31)
+	}
+}
+
+// ValidateChunking checks the chunking mode of backups is supported
+func ValidateChunking(chunking string) error {
+	switch chunking {
+	case CHUNKING_FIXED, CHUNKING_CDC:
+		return nil
+	}
+	return fmt.Errorf("Unsupported chunking %v, should be %v or %v", chunking, CHUNKING_FIXED, CHUNKING_CDC)
+}
+
+// SetDefaultChunking sets the chunking mode used by the objectstores which
+// haven't been configured, e.g. the new ones
+func SetDefaultChunking(chunking string) error {
+	if err := ValidateChunking(chunking); err != nil {
+		return err
+	}
+	defaultChunking = chunking
+	return nil
+}
+
+// getChunking returns the chunking mode of the objectstore. The objectstores
+// configured before the chunking was configurable use fixed blocks.
+func (config *ObjectStoreConfig) getChunking() string {
+	if config.Chunking == "" {
+		return CHUNKING_FIXED
+	}
+	return config.Chunking
+}
+
+// getChunking returns the chunking mode of the backup
+func (backup *Backup) getChunking() string {
+	if backup.Chunking == "" {
+		return CHUNKING_FIXED
+	}
+	return backup.Chunking
+}
+
+// getBlockLength returns the length of the block in the volume, which is
+// recorded for the chunks, and is the block size for the fixed blocks
+func getBlockLength(block BlockMapping, blockSize int64) int64 {
+	if block.Length != 0 {
+		return block.Length
+	}
+	return blockSize
+}
+
+/*
+chunker finds the boundaries of the chunks by the gear rolling hash, as
+FastCDC does. The boundary is where the hash of the bytes before matches the
+mask, so it only depends on the content near it, and the boundaries after an
+insertion or deletion would be found again at the same content. The chunks
+are between 1/4 and 2 times of the block size, and average around 3/4 of
+it.
+*/
+type chunker struct {
+	minSize int64
+	maxSize int64
+	mask    uint64
+}
+
+func newChunker(blockSize int64) *chunker {
+	bits := uint(0)
+	for s := blockSize / 2; s > 1; s >>= 1 {
+		bits++
+	}
+	return &chunker{
+		minSize: blockSize / 4,
+		maxSize: blockSize * 2,
+		mask:    (uint64(1) << bits) - 1,
+	}
+}
+
+// cut returns the length of the first chunk of data. The data must be no
+// shorter than maxSize unless it's the end of the range.
+func (c *chunker) cut(data []byte) int64 {
+	length := int64(len(data))
+	if length <= c.minSize {
+		return length
+	}
+	if length > c.maxSize {
+		length = c.maxSize
+	}
+	h := uint64(0)
+	for i := c.minSize; i < length; i++ {
+		h = (h << 1) + gearTable[data[i]]
+		if h&c.mask == 0 {
+			return i + 1
+		}
+	}
+	return length
+}
+
+// chunkRange is a range of the volume would be chunked from the beginning
+type chunkRange struct {
+	offset int64
+	length int64
+}
+
+func (r chunkRange) end() int64 {
+	return r.offset + r.length
+}
+
+/*
+getChunkRanges returns the ranges of the volume would be chunked for the
+changed blocks. The chunks of the last backup overlapping the changed
+blocks would be chunked again as a whole, so every chunk of the last backup
+is either replaced or kept as it is. The ranges are aligned to the block
+size except at the end of the volume, since the drivers read the snapshot by
+blocks.
+*/
+func getChunkRanges(delta *metadata.Mappings, lastBlocks []BlockMapping, blockSize, volumeSize int64) []chunkRange {
+	ranges := []chunkRange{}
+	for _, d := range delta.Mappings {
+		ranges = append(ranges, chunkRange{offset: d.Offset, length: d.Size})
+	}
+	for {
+		ranges = mergeChunkRanges(ranges, blockSize, volumeSize)
+		extended := false
+		for i := range ranges {
+			for _, blk := range lastBlocks {
+				end := blk.Offset + getBlockLength(blk, blockSize)
+				if blk.Offset >= ranges[i].end() || end <= ranges[i].offset {
+					continue
+				}
+				if blk.Offset < ranges[i].offset {
+					ranges[i].length += ranges[i].offset - blk.Offset
+					ranges[i].offset = blk.Offset
+					extended = true
+				}
+				if end > ranges[i].end() {
+					ranges[i].length = end - ranges[i].offset
+					extended = true
+				}
+			}
+		}
+		if !extended {
+			return ranges
+		}
+	}
+}
+
+// mergeChunkRanges aligns the ranges to the block size, and merges the
+// overlapping and adjacent ones in the order of offsets
+func mergeChunkRanges(ranges []chunkRange, blockSize, volumeSize int64) []chunkRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].offset < ranges[j].offset })
+	result := []chunkRange{}
+	for _, r := range ranges {
+		start := r.offset / blockSize * blockSize
+		end := (r.end() + blockSize - 1) / blockSize * blockSize
+		if volumeSize > 0 && end > volumeSize {
+			end = volumeSize
+		}
+		if n := len(result); n > 0 && start <= result[n-1].end() {
+			if end > result[n-1].end() {
+				result[n-1].length = end - result[n-1].offset
+			}
+			continue
+		}
+		result = append(result, chunkRange{offset: start, length: end - start})
+	}
+	return result
+}
+
+// getChunkRangesBlocks returns the number of blocks in the ranges, for
+// reporting the progress
+func getChunkRangesBlocks(ranges []chunkRange, blockSize int64) int {
+	total := 0
+	for _, r := range ranges {
+		total += int((r.length + blockSize - 1) / blockSize)
+	}
+	return total
+}
+
+/*
+readChunks reads the ranges of the snapshot by blocks, and calls f with the
+chunks in the order of offsets. The data passed to f would be reused after
+f returns. No more than 3 times of the block size would be held in memory.
+*/
+func readChunks(ranges []chunkRange, blockSize int64, snapshotName, volumeName string,
+	deltaOps DeltaBlockBackupOperations, f func(offset int64, data []byte) error) error {
+	c := newChunker(blockSize)
+	buf := make([]byte, 0, c.maxSize+blockSize)
+	for _, r := range ranges {
+		offset, pos := r.offset, r.offset
+		buf = buf[:0]
+		for offset < r.end() {
+			// Fill the buffer to maxSize, or to the end of the range
+			for int64(len(buf)) < c.maxSize && pos < r.end() {
+				length := blockSize
+				if pos+length > r.end() {
+					length = r.end() - pos
+				}
+				n := len(buf)
+				buf = buf[:n+int(length)]
+				if err := deltaOps.ReadSnapshot(snapshotName, volumeName, pos, buf[n:]); err != nil {
+					return err
+				}
+				pos += length
+			}
+			length := c.cut(buf)
+			if err := f(offset, buf[:length]); err != nil {
+				return err
+			}
+			offset += length
+			buf = buf[:copy(buf, buf[length:])]
+		}
+	}
+	return nil
+}
+
+/*
+backupChunks writes the chunks of the ranges of the snapshot to objectstore,
+and returns the mappings of the chunks in the order of offsets. The chunks
+are processed by backupWorkers goroutines, and counted in stats. The
+checkpoints are not used, since the existing chunks would be skipped anyway
+when the backup is created again.
+*/
+func backupChunks(ranges []chunkRange, blockSize int64, snapshotName, volumeName string, config *ObjectStoreConfig, stats *BackupStats,
+	deltaOps DeltaBlockBackupOperations, bsDriver ObjectStoreDriver) ([]BlockMapping, error) {
+	hash := config.getHash()
+	aead, err := config.getCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	progress := startProgress(BackupProgressKey(volumeName, snapshotName), PROGRESS_BACKUP, getChunkRangesBlocks(ranges, blockSize))
+	defer progress.finish()
+
+	jobs := make(chan blockJob, backupWorkers)
+	abort := make(chan struct{})
+	var (
+		backupErr error
+		errOnce   sync.Once
+		wg        sync.WaitGroup
+		mutex     sync.Mutex
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			backupErr = err
+			close(abort)
+		})
+	}
+
+	blocks := []BlockMapping{}
+	// Chunks have the same content would be written only once
+	written := make(map[string]bool)
+	writeSlots := make(chan struct{}, backupWorkers)
+	if serializeWrites(bsDriver) {
+		writeSlots = make(chan struct{}, 1)
+	}
+	for w := 0; w < backupWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if isZeroData(job.data) {
+					mutex.Lock()
+					blocks = append(blocks, zeroBlockMapping(job.offset, int64(len(job.data))))
+					stats.addBlock(int64(len(job.data)), 0, true)
+					mutex.Unlock()
+					continue
+				}
+				checksum := getChecksum(hash, job.data)
+				mutex.Lock()
+				skip := written[checksum]
+				written[checksum] = true
+				mutex.Unlock()
+				mapping := BlockMapping{
+					Offset:        job.offset,
+					BlockChecksum: checksum,
+					Compression:   config.Compression,
+					Shared:        config.SharedBlocks,
+					Length:        int64(len(job.data)),
+				}
+				var written int64
+				var err error
+				if !skip {
+					mapping.Size, written, err = backupBlock(volumeName, mapping, job.data, aead, bsDriver, writeSlots)
+				}
+				if written > 0 {
+					mapping.EncryptionKeyID = aead.currentKeyID()
+				}
+				if err != nil {
+					fail(err)
+					continue
+				}
+				progress.add(0, written)
+				mutex.Lock()
+				blocks = append(blocks, mapping)
+				stats.addBlock(mapping.Length, written, false)
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	processed, reported := int64(0), 0
+	err = readChunks(ranges, blockSize, snapshotName, volumeName, deltaOps, func(offset int64, data []byte) error {
+		job := blockJob{offset: offset, data: append([]byte{}, data...)}
+		select {
+		case jobs <- job:
+		case <-abort:
+			return backupErr
+		}
+		processed += int64(len(data))
+		if n := int(processed / blockSize); n > reported {
+			progress.add(n-reported, 0)
+			reported = n
+		}
+		return nil
+	})
+	if err != nil {
+		fail(err)
+	}
+	close(jobs)
+	wg.Wait()
+	if backupErr != nil {
+		return nil, backupErr
+	}
+
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].Offset < blocks[j].Offset })
+	fillSkippedBlocks(blocks)
+	return blocks, nil
+}
+
+// mergeChunks keeps the chunks of the last backup outside of the ranges
+// chunked again
+func mergeChunks(deltaBackup, lastBackup *Backup, ranges []chunkRange) *Backup {
+	if lastBackup == nil {
+		return deltaBackup
+	}
+	backup := &Backup{
+		Name:         deltaBackup.Name,
+		VolumeName:   deltaBackup.VolumeName,
+		SnapshotName: deltaBackup.SnapshotName,
+		Blocks:       append([]BlockMapping{}, deltaBackup.Blocks...),
+	}
+	for _, blk := range lastBackup.Blocks {
+		i := sort.Search(len(ranges), func(i int) bool { return ranges[i].end() > blk.Offset })
+		if i < len(ranges) && ranges[i].offset <= blk.Offset {
+			continue
+		}
+		backup.Blocks = append(backup.Blocks, blk)
+	}
+	sort.Slice(backup.Blocks, func(i, j int) bool { return backup.Blocks[i].Offset < backup.Blocks[j].Offset })
+	return backup
+}
+
+// getAlignedBlocks returns the blocks of the block size covering the chunks,
+// in the order of offsets
+func getAlignedBlocks(blocks []BlockMapping, blockSize int64) []BlockMapping {
+	result := []BlockMapping{}
+	next := int64(0)
+	for _, blk := range blocks {
+		start := blk.Offset / blockSize * blockSize
+		if start < next {
+			start = next
+		}
+		for offset := start; offset < blk.Offset+getBlockLength(blk, blockSize); offset += blockSize {
+			result = append(result, BlockMapping{Offset: offset})
+			next = offset + blockSize
+		}
+	}
+	return result
+}
@@ -0,0 +1,153 @@
+package objectstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+
+	"github.com/rancher/convoy/metadata"
+	"gopkg.in/check.v1"
+)
+
+// dataDeltaOps provides a snapshot of data, and reports the whole snapshot
+// as changed
+type dataDeltaOps struct {
+	data []byte
+}
+
+func (d *dataDeltaOps) HasSnapshot(id, volumeID string) bool {
+	return false
+}
+
+func (d *dataDeltaOps) CompareSnapshot(id, compareID, volumeID string) (*metadata.Mappings, error) {
+	return &metadata.Mappings{
+		Mappings: []metadata.Mapping{
+			{Offset: 0, Size: int64(len(d.data))},
+		},
+		BlockSize: DEFAULT_BLOCK_SIZE,
+	}, nil
+}
+
+func (d *dataDeltaOps) OpenSnapshot(id, volumeID string) error {
+	return nil
+}
+
+func (d *dataDeltaOps) ReadSnapshot(id, volumeID string, start int64, data []byte) error {
+	copy(data, d.data[start:])
+	return nil
+}
+
+func (d *dataDeltaOps) CloseSnapshot(id, volumeID string) error {
+	return nil
+}
+
+func randomData(size int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+// shiftData inserts some bytes near the beginning of data, and keeps the size
+func shiftData(data []byte) []byte {
+	shifted := append([]byte{}, data[:1000]...)
+	shifted = append(shifted, bytes.Repeat([]byte{0xff}, 100)...)
+	return append(shifted, data[1000:len(data)-100]...)
+}
+
+func getTestChunks(c *check.C, data []byte) map[string]int64 {
+	chunks := map[string]int64{}
+	ranges := []chunkRange{{offset: 0, length: int64(len(data))}}
+	err := readChunks(ranges, DEFAULT_BLOCK_SIZE, "snapshot", testVolumeName, &dataDeltaOps{data: data},
+		func(offset int64, chunk []byte) error {
+			chunks[getChecksum(HASH_SHA512, chunk)] = offset
+			return nil
+		})
+	c.Assert(err, check.IsNil)
+	return chunks
+}
+
+func (s *TestSuite) TestChunkerBoundaries(c *check.C) {
+	data := randomData(8 * DEFAULT_BLOCK_SIZE)
+	chunks := getTestChunks(c, data)
+	c.Assert(len(chunks) > 4, check.Equals, true)
+
+	// Only the chunks around the insertion would be changed
+	shifted := getTestChunks(c, shiftData(data))
+	same := 0
+	for checksum, offset := range shifted {
+		if _, exists := chunks[checksum]; exists {
+			c.Assert(offset, check.Not(check.Equals), chunks[checksum])
+			same++
+		}
+	}
+	c.Assert(same >= len(chunks)-3, check.Equals, true)
+}
+
+func (s *TestSuite) TestGetChunkRanges(c *check.C) {
+	delta := &metadata.Mappings{
+		Mappings: []metadata.Mapping{
+			{Offset: DEFAULT_BLOCK_SIZE, Size: DEFAULT_BLOCK_SIZE},
+		},
+		BlockSize: DEFAULT_BLOCK_SIZE,
+	}
+	lastBlocks := []BlockMapping{
+		{Offset: 0, Length: DEFAULT_BLOCK_SIZE / 2},
+		{Offset: DEFAULT_BLOCK_SIZE / 2, Length: DEFAULT_BLOCK_SIZE * 3 / 4},
+		{Offset: DEFAULT_BLOCK_SIZE * 5 / 4, Length: DEFAULT_BLOCK_SIZE * 3 / 4},
+		{Offset: 2 * DEFAULT_BLOCK_SIZE, Length: DEFAULT_BLOCK_SIZE},
+		{Offset: 3 * DEFAULT_BLOCK_SIZE, Length: DEFAULT_BLOCK_SIZE / 2},
+	}
+	ranges := getChunkRanges(delta, lastBlocks, DEFAULT_BLOCK_SIZE, DEFAULT_BLOCK_SIZE*7/2)
+	c.Assert(ranges, check.DeepEquals, []chunkRange{{offset: 0, length: 2 * DEFAULT_BLOCK_SIZE}})
+
+	// The range would be clamped to the end of volume
+	delta.Mappings = []metadata.Mapping{
+		{Offset: 3 * DEFAULT_BLOCK_SIZE, Size: DEFAULT_BLOCK_SIZE},
+	}
+	ranges = getChunkRanges(delta, lastBlocks, DEFAULT_BLOCK_SIZE, DEFAULT_BLOCK_SIZE*7/2)
+	c.Assert(ranges, check.DeepEquals, []chunkRange{{offset: 3 * DEFAULT_BLOCK_SIZE, length: DEFAULT_BLOCK_SIZE / 2}})
+}
+
+func (s *TestSuite) TestCreateChunkedBackup(c *check.C) {
+	c.Assert(SetDefaultChunking(CHUNKING_CDC), check.IsNil)
+	defer SetDefaultChunking(DEFAULT_CHUNKING)
+
+	deltaOps := &dataDeltaOps{data: randomData(8 * DEFAULT_BLOCK_SIZE)}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+		Size:   8 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-1"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	backup, err := loadBackup(decodeTestBackupName(c, backupURL), testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup.Chunking, check.Equals, CHUNKING_CDC)
+	files := len(memStore.files)
+
+	volFile := filepath.Join(c.MkDir(), "volume")
+	c.Assert(RestoreDeltaBlockBackup(backupURL, "", volFile), check.IsNil)
+	data, err := ioutil.ReadFile(volFile)
+	c.Assert(err, check.IsNil)
+	c.Assert(bytes.Equal(data, deltaOps.data), check.Equals, true)
+
+	// The shifted chunks would not be written again
+	deltaOps.data = shiftData(deltaOps.data)
+	backupURL, err = CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-2"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	backup, err = loadBackup(decodeTestBackupName(c, backupURL), testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(len(memStore.files)-files < len(backup.Blocks)/2, check.Equals, true)
+
+	c.Assert(RestoreDeltaBlockBackup(backupURL, "", volFile), check.IsNil)
+	data, err = ioutil.ReadFile(volFile)
+	c.Assert(err, check.IsNil)
+	c.Assert(bytes.Equal(data, deltaOps.data), check.Equals, true)
+}
+
+func decodeTestBackupName(c *check.C, backupURL string) string {
+	backupName, _, err := decodeBackupURL(backupURL)
+	c.Assert(err, check.IsNil)
+	return backupName
+}
@@ -0,0 +1,115 @@
+package objectstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	COMPRESSION_NONE = "none"
+	COMPRESSION_GZIP = "gzip"
+	COMPRESSION_ZSTD = "zstd"
+
+	DEFAULT_COMPRESSION = COMPRESSION_GZIP
+)
+
+var (
+	defaultCompression = DEFAULT_COMPRESSION
+
+	// The block files compressed by gzip keep the name used before the
+	// compression was configurable
+	compressionSuffixes = map[string]string{
+		COMPRESSION_NONE: ".raw",
+		COMPRESSION_GZIP: "",
+		COMPRESSION_ZSTD: ".zst",
+	}
+
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+func init() {
+	var err error
+	if zstdEncoder, err = zstd.NewWriter(nil); err != nil {
+		panic(err)
+	}
+	if zstdDecoder, err = zstd.NewReader(nil); err != nil {
+		panic(err)
+	}
+}
+
+func ValidateCompression(compression string) error {
+	if _, ok := compressionSuffixes[compression]; !ok {
+		return fmt.Errorf("Unsupported compression %v, should be one of %v, %v and %v",
+			compression, COMPRESSION_NONE, COMPRESSION_GZIP, COMPRESSION_ZSTD)
+	}
+	return nil
+}
+
+// SetDefaultCompression sets the compression used by the objectstores which
+// haven't been configured, e.g. the new ones
+func SetDefaultCompression(compression string) error {
+	if err := ValidateCompression(compression); err != nil {
+		return err
+	}
+	defaultCompression = compression
+	return nil
+}
+
+// blockCompression returns the compression of the block. The blocks created
+// before the compression was recorded are compressed by gzip.
+func blockCompression(block BlockMapping) string {
+	if block.Compression == "" {
+		return COMPRESSION_GZIP
+	}
+	return block.Compression
+}
+
+func compressBlock(compression string, data []byte) (io.ReadSeeker, error) {
+	switch compression {
+	case COMPRESSION_NONE:
+		return bytes.NewReader(data), nil
+	case COMPRESSION_GZIP:
+		return util.CompressData(data)
+	case COMPRESSION_ZSTD:
+		return bytes.NewReader(zstdEncoder.EncodeAll(data, nil)), nil
+	}
+	return nil, fmt.Errorf("Unsupported compression %v", compression)
+}
+
+// decompressBlock decompresses the block, and verifies its checksum by hash
+func decompressBlock(compression, hash string, src io.Reader, checksum string) (io.Reader, error) {
+	var (
+		block []byte
+		err   error
+	)
+	switch compression {
+	case COMPRESSION_NONE:
+		block, err = ioutil.ReadAll(src)
+	case COMPRESSION_GZIP:
+		var r *gzip.Reader
+		if r, err = gzip.NewReader(src); err == nil {
+			block, err = ioutil.ReadAll(r)
+		}
+	case COMPRESSION_ZSTD:
+		var data []byte
+		if data, err = ioutil.ReadAll(src); err == nil {
+			block, err = zstdDecoder.DecodeAll(data, nil)
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported compression %v", compression)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if getChecksum(hash, block) != checksum {
+		return nil, fmt.Errorf("Checksum verification failed for block!")
+	}
+	return bytes.NewReader(block), nil
+}
@@ -0,0 +1,130 @@
+package objectstore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/convoy/metadata"
+	"github.com/rancher/convoy/util"
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestCompressBlock(c *check.C) {
+	data := bytes.Repeat([]byte("convoy"), DEFAULT_BLOCK_SIZE/6)
+	checksum := util.GetChecksum(data)
+	for _, compression := range []string{COMPRESSION_NONE, COMPRESSION_GZIP, COMPRESSION_ZSTD} {
+		rs, err := compressBlock(compression, data)
+		c.Assert(err, check.IsNil)
+		compressed, err := ioutil.ReadAll(rs)
+		c.Assert(err, check.IsNil)
+		if compression != COMPRESSION_NONE {
+			c.Assert(len(compressed) < len(data), check.Equals, true)
+		}
+
+		r, err := decompressBlock(compression, HASH_SHA512, bytes.NewReader(compressed), checksum)
+		c.Assert(err, check.IsNil)
+		result, err := ioutil.ReadAll(r)
+		c.Assert(err, check.IsNil)
+		c.Assert(bytes.Equal(result, data), check.Equals, true)
+
+		_, err = decompressBlock(compression, HASH_SHA512, bytes.NewReader(compressed), "0000")
+		c.Assert(err, check.ErrorMatches, "Checksum verification failed for block!")
+	}
+
+	_, err := compressBlock("lzma", data)
+	c.Assert(err, check.ErrorMatches, "Unsupported compression lzma")
+	c.Assert(SetDefaultCompression("lzma"), check.ErrorMatches, "Unsupported compression lzma.*")
+}
+
+func (s *TestSuite) TestBlockFilePath(c *check.C) {
+	block := BlockMapping{BlockChecksum: "aabbccdd"}
+	c.Assert(getBlockFilePath(testVolumeName, block), check.Equals,
+		filepath.Join(getBlockPath(testVolumeName), "aa", "bb", "aabbccdd.blk"))
+	block.Compression = COMPRESSION_GZIP
+	c.Assert(getBlockFilePath(testVolumeName, block), check.Equals,
+		filepath.Join(getBlockPath(testVolumeName), "aa", "bb", "aabbccdd.blk"))
+	block.Compression = COMPRESSION_ZSTD
+	c.Assert(getBlockFilePath(testVolumeName, block), check.Equals,
+		filepath.Join(getBlockPath(testVolumeName), "aa", "bb", "aabbccdd.zst.blk"))
+	block.Compression = COMPRESSION_NONE
+	c.Assert(getBlockFilePath(testVolumeName, block), check.Equals,
+		filepath.Join(getBlockPath(testVolumeName), "aa", "bb", "aabbccdd.raw.blk"))
+}
+
+func (s *TestSuite) TestObjectStoreConfig(c *check.C) {
+	defer SetDefaultCompression(DEFAULT_COMPRESSION)
+
+	config, err := loadObjectStoreConfig(memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(config.Compression, check.Equals, DEFAULT_COMPRESSION)
+	c.Assert(memStore.FileExists(getObjectStoreConfigPath()), check.Equals, false)
+
+	c.Assert(SetDefaultCompression(COMPRESSION_ZSTD), check.IsNil)
+	config, err = initObjectStoreConfig(memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(config.Compression, check.Equals, COMPRESSION_ZSTD)
+	c.Assert(memStore.FileExists(getObjectStoreConfigPath()), check.Equals, true)
+
+	// The default only applies to the objectstores haven't been configured
+	c.Assert(SetDefaultCompression(COMPRESSION_NONE), check.IsNil)
+	config, err = initObjectStoreConfig(memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(config.Compression, check.Equals, COMPRESSION_ZSTD)
+
+	config.Compression = "lzma"
+	c.Assert(saveConfigInObjectStore(getObjectStoreConfigPath(), memStore, config), check.IsNil)
+	_, err = loadObjectStoreConfig(memStore)
+	c.Assert(err, check.ErrorMatches, "Invalid config .*: Unsupported compression lzma.*")
+}
+
+func (s *TestSuite) TestBackupCompressionChange(c *check.C) {
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: 0, Size: 3 * DEFAULT_BLOCK_SIZE},
+		},
+	}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+		Size:   3 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL1, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-1"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+
+	config := &ObjectStoreConfig{Compression: COMPRESSION_ZSTD}
+	c.Assert(saveConfigInObjectStore(getObjectStoreConfigPath(), memStore, config), check.IsNil)
+	backupURL2, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-2"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+
+	backupName2, _, err := decodeBackupURL(backupURL2)
+	c.Assert(err, check.IsNil)
+	backup2, err := loadBackup(backupName2, testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	for _, block := range backup2.Blocks {
+		c.Assert(block.Compression, check.Equals, COMPRESSION_ZSTD)
+		blkFile := getBlockFilePath(testVolumeName, block)
+		c.Assert(strings.HasSuffix(blkFile, ".zst.blk"), check.Equals, true)
+		c.Assert(memStore.FileExists(blkFile), check.Equals, true)
+	}
+
+	dir := c.MkDir()
+	for i, backupURL := range []string{backupURL1, backupURL2} {
+		volFile := filepath.Join(dir, fmt.Sprintf("volume-%v", i))
+		c.Assert(RestoreDeltaBlockBackup(backupURL, "", volFile), check.IsNil)
+		data, err := ioutil.ReadFile(volFile)
+		c.Assert(err, check.IsNil)
+		c.Assert(data, check.HasLen, 3*DEFAULT_BLOCK_SIZE)
+		c.Assert(data[2*DEFAULT_BLOCK_SIZE], check.Equals, byte(3))
+	}
+
+	// The gzip blocks only used by the first backup should be removed
+	c.Assert(DeleteDeltaBlockBackup(backupURL1, "", true), check.IsNil)
+	for _, block := range backup2.Blocks {
+		c.Assert(memStore.FileExists(getBlockFilePath(testVolumeName, block)), check.Equals, true)
+		block.Compression = ""
+		c.Assert(memStore.FileExists(getBlockFilePath(testVolumeName, block)), check.Equals, false)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"path/filepath"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/rancher/convoy/util"
@@ -13,14 +14,20 @@ import (
 )
 
 const (
-	OBJECTSTORE_BASE       = "convoy-objectstore"
-	VOLUME_SEPARATE_LAYER1 = 2
-	VOLUME_SEPARATE_LAYER2 = 4
+	OBJECTSTORE_BASE        = "convoy-objectstore"
+	OBJECTSTORE_CONFIG_FILE = "objectstore.cfg"
+	VOLUME_SEPARATE_LAYER1  = 2
+	VOLUME_SEPARATE_LAYER2  = 4
 
 	VOLUME_DIRECTORY     = "volumes"
 	VOLUME_CONFIG_FILE   = "volume.cfg"
 	BACKUP_DIRECTORY     = "backups"
 	BACKUP_CONFIG_PREFIX = "backup_"
+	LOCKS_DIRECTORY      = "locks"
+	VOLUME_LOCK_PREFIX   = "volume_"
+	LOCK_FILE_SUFFIX     = ".lock"
+
+	OBJECTSTORE_LOCK_FILE = "objectstore.lock"
 
 	CFG_SUFFIX = ".cfg"
 )
@@ -81,6 +88,113 @@ func saveConfigInObjectStore(filePath string, driver ObjectStoreDriver, v interf
 	return nil
 }
 
+// ObjectStoreConfig is the settings shared by all the volumes in the
+// objectstore
+type ObjectStoreConfig struct {
+	Compression string
+	// The size of the blocks of the backups, DEFAULT_BLOCK_SIZE if it's 0
+	BlockSize int64 `json:",omitempty"`
+	// Store the blocks in the pool shared by all the volumes, so the same
+	// content of different volumes would be stored only once
+	SharedBlocks bool `json:",omitempty"`
+	// The hash algorithm to address the blocks, HASH_SHA512 if it's empty
+	Hash string `json:",omitempty"`
+	// How the volumes are split into blocks, CHUNKING_FIXED if it's empty
+	Chunking string `json:",omitempty"`
+	// The format version of the config, see FORMAT_VERSION
+	FormatVersion int `json:",omitempty"`
+
+	// The key used to encrypt the blocks and backup configs, the volume
+	// configs are not encrypted
+	EncryptionKeyID string `json:",omitempty"`
+	EncryptionSalt  string `json:",omitempty"`
+	// The keys may still have encrypted objects since the key was rotated,
+	// including the current one, see RotateEncryptionKey
+	EncryptionKeyIDs []string `json:",omitempty"`
+}
+
+func getObjectStoreConfigPath() string {
+	return filepath.Join(OBJECTSTORE_BASE, OBJECTSTORE_CONFIG_FILE)
+}
+
+// loadObjectStoreConfig returns the config of the objectstore, or the default
+// settings if the objectstore hasn't been configured
+func loadObjectStoreConfig(driver ObjectStoreDriver) (*ObjectStoreConfig, error) {
+	config := &ObjectStoreConfig{}
+	filePath := getObjectStoreConfigPath()
+	if !driver.FileExists(filePath) {
+		config.Compression = defaultCompression
+		config.BlockSize = defaultBlockSize
+		config.SharedBlocks = defaultSharedBlocks
+		config.Hash = defaultHash
+		config.Chunking = defaultChunking
+		config.FormatVersion = FORMAT_VERSION
+		return config, nil
+	}
+	if err := loadConfigInObjectStore(filePath, driver, config); err != nil {
+		return nil, err
+	}
+	if err := checkFormatVersion(filePath, config.FormatVersion); err != nil {
+		return nil, err
+	}
+	if err := ValidateCompression(config.Compression); err != nil {
+		return nil, fmt.Errorf("Invalid config %v in objectstore: %v", filePath, err)
+	}
+	if err := ValidateBlockSize(config.getBlockSize()); err != nil {
+		return nil, fmt.Errorf("Invalid config %v in objectstore: %v", filePath, err)
+	}
+	if err := ValidateHash(config.getHash()); err != nil {
+		return nil, fmt.Errorf("Invalid config %v in objectstore: %v", filePath, err)
+	}
+	if err := ValidateChunking(config.getChunking()); err != nil {
+		return nil, fmt.Errorf("Invalid config %v in objectstore: %v", filePath, err)
+	}
+	return config, nil
+}
+
+// initObjectStoreConfig loads the config of the objectstore, and saves the
+// default settings in the objectstore if it hasn't been configured
+func initObjectStoreConfig(driver ObjectStoreDriver) (*ObjectStoreConfig, error) {
+	filePath := getObjectStoreConfigPath()
+	if driver.FileExists(filePath) {
+		return loadObjectStoreConfig(driver)
+	}
+
+	// Backups of other volumes may be initializing the objectstore as well
+	if locker := getLocker(driver); locker != nil {
+		lockPath := getObjectStoreLockPath()
+		if err := locker.Lock(lockPath); err != nil {
+			return nil, err
+		}
+		defer locker.Unlock(lockPath)
+	}
+	exists := driver.FileExists(filePath)
+	config, err := loadObjectStoreConfig(driver)
+	if err != nil || exists {
+		return config, err
+	}
+
+	// The existing backup configs cannot be encrypted
+	volumeNames, err := getVolumeNames(driver)
+	if err != nil {
+		return nil, err
+	}
+	if len(volumeNames) == 0 {
+		if err := config.initEncryption(); err != nil {
+			return nil, err
+		}
+	} else if len(encryptionKeys) != 0 {
+		log.Warnf("Objectstore %v has backups before it's configured, it would not be encrypted", driver.GetURL())
+	}
+
+	if err := saveConfigInObjectStore(filePath, driver, config); err != nil {
+		return nil, err
+	}
+	log.Debugf("Initialized objectstore %v with compression %v, block size %v, shared blocks %v, hash %v, encryption key %v",
+		driver.GetURL(), config.Compression, config.BlockSize, config.SharedBlocks, config.Hash, config.EncryptionKeyID)
+	return config, nil
+}
+
 func volumeExists(volumeName string, driver ObjectStoreDriver) bool {
 	volumeFile := getVolumeFilePath(volumeName)
 	return driver.FileExists(volumeFile)
@@ -97,6 +211,16 @@ func getVolumePath(volumeName string) string {
 	return filepath.Join(OBJECTSTORE_BASE, VOLUME_DIRECTORY, volumeLayer1, volumeLayer2, name)
 }
 
+func getObjectStoreLockPath() string {
+	return filepath.Join(OBJECTSTORE_BASE, LOCKS_DIRECTORY, OBJECTSTORE_LOCK_FILE)
+}
+
+// The lock is outside of volume directory, since the directory would be
+// removed with the last backup of the volume
+func getVolumeLockPath(volumeName string) string {
+	return filepath.Join(OBJECTSTORE_BASE, LOCKS_DIRECTORY, VOLUME_LOCK_PREFIX+volumeName+LOCK_FILE_SUFFIX)
+}
+
 func getVolumeFilePath(volumeName string) string {
 	volumePath := getVolumePath(volumeName)
 	volumeCfg := VOLUME_CONFIG_FILE
@@ -136,6 +260,9 @@ func loadVolume(volumeName string, driver ObjectStoreDriver) (*Volume, error) {
 	if err := loadConfigInObjectStore(file, driver, v); err != nil {
 		return nil, err
 	}
+	if err := checkFormatVersion(file, v.FormatVersion); err != nil {
+		return nil, err
+	}
 	return v, nil
 }
 
@@ -172,22 +299,39 @@ func backupExists(backupName, volumeName string, bsDriver ObjectStoreDriver) boo
 }
 
 func loadBackup(backupName, volumeName string, bsDriver ObjectStoreDriver) (*Backup, error) {
+	aead, err := getObjectStoreCipher(bsDriver)
+	if err != nil {
+		return nil, err
+	}
 	backup := &Backup{}
-	if err := loadConfigInObjectStore(getBackupConfigPath(backupName, volumeName), bsDriver, backup); err != nil {
+	filePath := getBackupConfigPath(backupName, volumeName)
+	if err := loadEncryptedConfigInObjectStore(filePath, bsDriver, aead, backup); err != nil {
+		return nil, err
+	}
+	if err := checkFormatVersion(filePath, backup.FormatVersion); err != nil {
 		return nil, err
 	}
 	return backup, nil
 }
 
 func saveBackup(backup *Backup, bsDriver ObjectStoreDriver) error {
+	aead, err := getObjectStoreCipher(bsDriver)
+	if err != nil {
+		return err
+	}
 	filePath := getBackupConfigPath(backup.Name, backup.VolumeName)
-	if bsDriver.FileExists(filePath) {
+	// The retained config cannot be removed, but it would be retained again
+	// when overwritten
+	retainer := getRetainer(bsDriver)
+	if retainer != nil {
+		backup.RetainedUntil = time.Now().Add(retainer.RetentionPeriod()).Format(time.RFC3339)
+	} else if bsDriver.FileExists(filePath) {
 		log.Warnf("Snapshot configuration file %v already exists, would remove it\n", filePath)
 		if err := bsDriver.Remove(filePath); err != nil {
 			return err
 		}
 	}
-	if err := saveConfigInObjectStore(filePath, bsDriver, backup); err != nil {
+	if err := saveEncryptedConfigInObjectStore(filePath, bsDriver, aead, backup); err != nil {
 		return err
 	}
 	return nil
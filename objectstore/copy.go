@@ -0,0 +1,203 @@
+package objectstore
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	COPY_STATUS_PENDING   = "pending"
+	COPY_STATUS_COMPLETED = "completed"
+	COPY_STATUS_FAILED    = "failed"
+)
+
+// BackupCopy records the copy of the backup in the second objectstore, e.g.
+// a bucket in another region, which can be restored from as well
+type BackupCopy struct {
+	URL         string
+	Status      string
+	Error       string `json:",omitempty"`
+	UpdatedTime string
+}
+
+// ObjectStoreCopier is an optional interface of ObjectStoreDriver, for the
+// destinations which can copy the files from another objectstore on the
+// server side, e.g. between S3 buckets, without transferring the data
+// through the host
+type ObjectStoreCopier interface {
+	CanCopyFrom(srcURL, srcEndpoint string) bool
+	CopyFrom(srcURL, src, dst string) error
+}
+
+type backupCopyDest struct {
+	destURL string
+	copyURL string
+}
+
+var (
+	backupCopyMutex sync.RWMutex
+	backupCopyDests []*backupCopyDest
+
+	// The copies run in background one at a time, since each of them would
+	// replicate all the missing backups of the volume
+	backupCopyRunMutex sync.Mutex
+	backupCopyWG       sync.WaitGroup
+)
+
+// ParseBackupCopy parses the copy destination in the form of
+// "<dest URL>=<copy URL>", e.g. "s3://backups@us-east-1/=s3://dr@us-west-2/"
+func ParseBackupCopy(spec string) (string, string, error) {
+	i := strings.Index(spec, "=")
+	if i <= 0 || i == len(spec)-1 {
+		return "", "", fmt.Errorf("Invalid backup copy %v, should be in the form of <dest URL>=<copy URL>", spec)
+	}
+	return spec[:i], spec[i+1:], nil
+}
+
+// RegisterBackupCopy makes the backups created in the objectstore at destURL
+// copied to the objectstore at copyURL in background once completed
+func RegisterBackupCopy(destURL, copyURL string) error {
+	for _, u := range []string{destURL, copyURL} {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return err
+		}
+		if _, exists := initializers[parsed.Scheme]; !exists {
+			return fmt.Errorf("Driver %v is not supported!", parsed.Scheme)
+		}
+	}
+	if normalizeURL(destURL) == normalizeURL(copyURL) {
+		return fmt.Errorf("Cannot copy backups of objectstore %v to itself", destURL)
+	}
+
+	backupCopyMutex.Lock()
+	defer backupCopyMutex.Unlock()
+	backupCopyDests = append(backupCopyDests, &backupCopyDest{
+		destURL: normalizeURL(destURL),
+		copyURL: copyURL,
+	})
+	log.Debugf("Registered backup copy from objectstore %v to %v", destURL, copyURL)
+	return nil
+}
+
+// getBackupCopyURL returns the URL of the objectstore the backups in destURLs
+// should be copied to, or empty if there is none
+func getBackupCopyURL(destURLs ...string) string {
+	backupCopyMutex.RLock()
+	defer backupCopyMutex.RUnlock()
+	for _, dest := range backupCopyDests {
+		for _, destURL := range destURLs {
+			if normalizeURL(destURL) == dest.destURL {
+				return dest.copyURL
+			}
+		}
+	}
+	return ""
+}
+
+func newBackupCopy(backup *Backup, copyURL string) *BackupCopy {
+	return &BackupCopy{
+		URL:         encodeBackupURL(backup.Name, backup.VolumeName, copyURL),
+		Status:      COPY_STATUS_PENDING,
+		UpdatedTime: util.Now(),
+	}
+}
+
+// startBackupCopy copies the backup to the objectstore at copyURL in
+// background, it should be called after the volume has been unlocked. It
+// does nothing if either URL is empty, e.g. the backup failed.
+func startBackupCopy(backupURL, endpoint, copyURL string) {
+	if backupURL == "" || copyURL == "" {
+		return
+	}
+	backupCopyWG.Add(1)
+	go func() {
+		defer backupCopyWG.Done()
+		backupCopyRunMutex.Lock()
+		defer backupCopyRunMutex.Unlock()
+		if err := copyBackup(backupURL, endpoint, copyURL); err != nil {
+			log.Errorf("Failed to copy backup %v to %v: %v", backupURL, copyURL, err)
+		}
+	}()
+}
+
+func copyBackup(backupURL, endpoint, copyURL string) error {
+	backupName, volumeName, err := decodeBackupURL(backupURL)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:     LOG_REASON_START,
+		LOG_FIELD_EVENT:      LOG_EVENT_REPLICATE,
+		LOG_FIELD_BACKUP_URL: backupURL,
+		LOG_FIELD_DEST_URL:   copyURL,
+	}).Debug("Copying backup")
+	// The copy destination uses the default endpoint, e.g. AWS S3 in
+	// another region
+	report, err := ReplicateVolume(backupURL, endpoint, copyURL, "", volumeName)
+	if err != nil {
+		if updateErr := updateBackupCopies(backupURL, endpoint, []string{backupName}, copyURL, err); updateErr != nil {
+			log.Warnf("Failed to record the failed copy of backup %v: %v", backupURL, updateErr)
+		}
+		return err
+	}
+	if err := updateBackupCopies(backupURL, endpoint, report.CopiedBackups, copyURL, nil); err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:     LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:      LOG_EVENT_REPLICATE,
+		LOG_FIELD_BACKUP_URL: backupURL,
+		LOG_FIELD_DEST_URL:   copyURL,
+	}).Debugf("Copied %v backups", len(report.CopiedBackups))
+	return nil
+}
+
+// updateBackupCopies records the result of the copy in the backups
+func updateBackupCopies(backupURL, endpoint string, backupNames []string, copyURL string, copyErr error) error {
+	driver, err := GetObjectStoreDriver(backupURL, endpoint)
+	if err != nil {
+		return err
+	}
+	_, volumeName, err := decodeBackupURL(backupURL)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockVolume(volumeName, driver)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for _, backupName := range backupNames {
+		// The backup may have been deleted in the meantime
+		if !backupExists(backupName, volumeName, driver) {
+			continue
+		}
+		backup, err := loadBackup(backupName, volumeName, driver)
+		if err != nil {
+			return err
+		}
+		backup.Copy = &BackupCopy{
+			URL:         encodeBackupURL(backupName, volumeName, copyURL),
+			Status:      COPY_STATUS_COMPLETED,
+			UpdatedTime: util.Now(),
+		}
+		if copyErr != nil {
+			backup.Copy.Status = COPY_STATUS_FAILED
+			backup.Copy.Error = copyErr.Error()
+		}
+		if err := saveBackup(backup, driver); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package objectstore
+
+import (
+	"sync"
+
+	"github.com/rancher/convoy/metadata"
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestBackupCopy(c *check.C) {
+	defer func() {
+		backupCopyDests = nil
+	}()
+	remoteMemStore = &remoteMemObjectStoreDriver{&memObjectStoreDriver{
+		mutex:    &sync.Mutex{},
+		files:    make(map[string][]byte),
+		locks:    make(map[string]bool),
+		archived: make(map[string]string),
+	}}
+
+	_, _, err := ParseBackupCopy("mem:///")
+	c.Assert(err, check.ErrorMatches, "Invalid backup copy.*")
+	destURL, copyURL, err := ParseBackupCopy(MEM_URL + "=" + REMOTE_MEM_URL)
+	c.Assert(err, check.IsNil)
+	c.Assert(RegisterBackupCopy(destURL, destURL), check.ErrorMatches, "Cannot copy backups of objectstore .* to itself")
+	c.Assert(RegisterBackupCopy(destURL, copyURL), check.IsNil)
+
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: 0, Size: 2 * DEFAULT_BLOCK_SIZE},
+		},
+	}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-1"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	backupCopyWG.Wait()
+
+	backupName, _, err := decodeBackupURL(backupURL)
+	c.Assert(err, check.IsNil)
+	c.Assert(backupExists(backupName, testVolumeName, remoteMemStore), check.Equals, true)
+	info, err := GetBackupInfo(backupURL, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(info["CopyURL"], check.Equals, encodeBackupURL(backupName, testVolumeName, REMOTE_MEM_URL))
+	c.Assert(info["CopyStatus"], check.Equals, COPY_STATUS_COMPLETED)
+	// The copy doesn't record copies itself
+	info, err = GetBackupInfo(info["CopyURL"], "")
+	c.Assert(err, check.IsNil)
+	c.Assert(info["CopyURL"], check.Equals, "")
+
+	// The failed copy would be recorded as well
+	c.Assert(RegisterReadOnly(REMOTE_MEM_URL), check.IsNil)
+	backupURL, err = CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-2"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	backupCopyWG.Wait()
+	info, err = GetBackupInfo(backupURL, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(info["CopyStatus"], check.Equals, COPY_STATUS_FAILED)
+	c.Assert(info["CopyError"], check.Matches, ".*read-only.*")
+}
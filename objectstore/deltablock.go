@@ -1,10 +1,15 @@
 package objectstore
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/rancher/convoy/metadata"
@@ -16,6 +21,20 @@ import (
 type BlockMapping struct {
 	Offset        int64
 	BlockChecksum string
+	Compression   string `json:",omitempty"`
+	// The block is in the pool shared by all the volumes
+	Shared bool `json:",omitempty"`
+	// The size of the block file, after compression and encryption. It's 0
+	// if the block was created by older versions.
+	Size int64 `json:",omitempty"`
+	// The length of the chunk in the volume, 0 for the fixed blocks of the
+	// block size
+	Length int64 `json:",omitempty"`
+	// The ID of the key encrypted the block file when it was written. It's
+	// empty if the block is not encrypted, or the key is unknown, e.g. the
+	// block existed already or was created before format version 2. The
+	// header of the block file is still the authority, see objectCipher.
+	EncryptionKeyID string `json:",omitempty"`
 }
 
 type DeltaBlockBackupOperations interface {
@@ -29,7 +48,11 @@ type DeltaBlockBackupOperations interface {
 const (
 	DEFAULT_BLOCK_SIZE = 2097152
 
+	DEFAULT_BACKUP_WORKERS  = 4
+	DEFAULT_RESTORE_WORKERS = 4
+
 	BLOCKS_DIRECTORY      = "blocks"
+	BLOCK_FILE_SUFFIX     = ".blk"
 	BLOCK_SEPARATE_LAYER1 = 2
 	BLOCK_SEPARATE_LAYER2 = 4
 )
@@ -38,11 +61,40 @@ func CreateDeltaBlockBackup(volume *Volume, snapshot *Snapshot, destURL, endpoin
 	if deltaOps == nil {
 		return "", fmt.Errorf("Missing DeltaBlockBackupOperations")
 	}
+	deltaOps = throttleSnapshotReads(deltaOps)
+	start := time.Now()
 
 	bsDriver, err := GetObjectStoreDriver(destURL, endpoint)
 	if err != nil {
 		return "", err
 	}
+	if err := checkWritable(bsDriver); err != nil {
+		return "", err
+	}
+
+	// The copy of the backup would be started once the volume is unlocked
+	copyURL := getBackupCopyURL(destURL, bsDriver.GetURL())
+	var backupURL string
+	defer func() {
+		startBackupCopy(backupURL, endpoint, copyURL)
+	}()
+
+	unlock, err := lockVolume(volume.Name, bsDriver)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	config, err := initObjectStoreConfig(bsDriver)
+	if err != nil {
+		return "", err
+	}
+
+	if snapshot.ExportImage != "" {
+		if err := checkImageExport(snapshot.ExportImage, volume, config); err != nil {
+			return "", err
+		}
+	}
 
 	if err := addVolume(volume, bsDriver); err != nil {
 		return "", err
@@ -54,23 +106,191 @@ func CreateDeltaBlockBackup(volume *Volume, snapshot *Snapshot, destURL, endpoin
 		return "", err
 	}
 
-	lastBackupName := volume.LastBackupName
-
 	if err := deltaOps.OpenSnapshot(snapshot.Name, volume.Name); err != nil {
 		return "", err
 	}
 	defer deltaOps.CloseSnapshot(snapshot.Name, volume.Name)
 
+	lastBackup, lastSnapshotName, err := getLastBackup(volume, snapshot, config, deltaOps, bsDriver)
+	if err != nil {
+		return "", err
+	}
+	delta, err := compareSnapshot(snapshot.Name, lastSnapshotName, volume.Name, config, deltaOps)
+	if err != nil {
+		return "", err
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_BACKUP,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: snapshot.Name,
+	}).Debug("Creating backup")
+
+	deltaBackup := &Backup{
+		Name:         util.GenerateName("backup"),
+		VolumeName:   volume.Name,
+		SnapshotName: snapshot.Name,
+		Blocks:       []BlockMapping{},
+	}
+	stats := &BackupStats{}
+	cdc := config.getChunking() == CHUNKING_CDC
+	var ranges []chunkRange
+	if cdc {
+		var lastBlocks []BlockMapping
+		if lastBackup != nil {
+			lastBlocks = lastBackup.Blocks
+		}
+		ranges = getChunkRanges(delta, lastBlocks, delta.BlockSize, volume.Size)
+		if deltaBackup.Blocks, err = backupChunks(ranges, delta.BlockSize, snapshot.Name, volume.Name, config, stats, deltaOps, bsDriver); err != nil {
+			return "", err
+		}
+	} else {
+		deltaChecksum, err := getDeltaChecksum(delta)
+		if err != nil {
+			return "", err
+		}
+		checkpoint, err := loadCheckpoint(snapshot.Name, volume.Name, bsDriver)
+		if err != nil {
+			return "", err
+		}
+		if checkpoint != nil && (checkpoint.LastSnapshotName != lastSnapshotName || checkpoint.DeltaChecksum != deltaChecksum ||
+			checkpoint.getHash() != config.getHash()) {
+			log.Debugf("Checkpoint of snapshot %v doesn't match the changed blocks, would back up from the beginning", snapshot.Name)
+			checkpoint = nil
+		}
+		if checkpoint == nil {
+			checkpoint = &Checkpoint{
+				VolumeName:       volume.Name,
+				SnapshotName:     snapshot.Name,
+				LastSnapshotName: lastSnapshotName,
+				DeltaChecksum:    deltaChecksum,
+				Hash:             config.getHash(),
+				Blocks:           []BlockMapping{},
+			}
+		} else {
+			log.Debugf("Resuming backup of snapshot %v from block %v", snapshot.Name, checkpoint.truncate(bsDriver))
+		}
+
+		if deltaBackup.Blocks, err = backupBlocks(delta, volume.Size, checkpoint, config, stats, deltaOps, bsDriver); err != nil {
+			return "", err
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:    LOG_EVENT_BACKUP,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: snapshot.Name,
+	}).Debug("Created snapshot changed blocks")
+
+	var backup *Backup
+	if cdc {
+		backup = mergeChunks(deltaBackup, lastBackup, ranges)
+	} else {
+		backup = mergeSnapshotMap(deltaBackup, lastBackup)
+	}
+	if lastSnapshotName != "" {
+		backup.ParentBackupName = lastBackup.Name
+	}
+	backup.SnapshotName = snapshot.Name
+	backup.Labels = snapshot.Labels
+	backup.BlockSize = delta.BlockSize
+	backup.Hash = config.getHash()
+	if cdc {
+		backup.Chunking = CHUNKING_CDC
+	}
+	upgradeBackup(backup)
+
+	if snapshot.ExportImage != "" {
+		image, err := exportImage(backup, volume, snapshot.ExportImage, deltaOps, bsDriver)
+		if err != nil {
+			return "", err
+		}
+		backup.Image = image
+	}
+	backup.SnapshotCreatedAt = snapshot.CreatedTime
+	backup.CreatedTime = util.Now()
+	stats.TotalBlocks = len(backup.Blocks)
+	stats.Duration = time.Since(start).Round(time.Millisecond).String()
+	backup.Stats = stats
+	backup.Host = getHostname()
+	if copyURL != "" {
+		backup.Copy = newBackupCopy(backup, copyURL)
+	}
+
+	if retainer := getRetainer(bsDriver); retainer != nil {
+		if err := retainBackupBlocks(backup, lastBackup, retainer); err != nil {
+			return "", err
+		}
+	}
+
+	// The references must be recorded before the backup, so the shared
+	// blocks in use would never be collected
+	if err := addBlockRefs(volume.Name, backup.Blocks, bsDriver); err != nil {
+		return "", err
+	}
+	if err := indexBackup(backup, bsDriver); err != nil {
+		return "", err
+	}
+	if err := saveBackup(backup, bsDriver); err != nil {
+		return "", err
+	}
+
+	volume.LastBackupName = backup.Name
+	if err := saveVolume(volume, bsDriver); err != nil {
+		return "", err
+	}
+
+	if err := removeCheckpoint(snapshot.Name, volume.Name, bsDriver); err != nil {
+		log.Warnf("Failed to remove checkpoint of snapshot %v: %v", snapshot.Name, err)
+	}
+
+	backupURL = encodeBackupURL(backup.Name, volume.Name, destURL)
+	return backupURL, nil
+}
+
+var (
+	backupWorkers  = DEFAULT_BACKUP_WORKERS
+	restoreWorkers = DEFAULT_RESTORE_WORKERS
+
+	growFilesystem = util.GrowFilesystem
+)
+
+// getLastBackup returns the last backup of the volume, and the name of its
+// snapshot the changed blocks would be compared with, which is empty if a
+// full backup would be created
+func getLastBackup(volume *Volume, snapshot *Snapshot, config *ObjectStoreConfig,
+	deltaOps DeltaBlockBackupOperations, bsDriver ObjectStoreDriver) (*Backup, string, error) {
+	var err error
 	var lastSnapshotName string
 	var lastBackup *Backup
-	if lastBackupName != "" {
+	if lastBackupName := volume.LastBackupName; lastBackupName != "" {
 		lastBackup, err = loadBackup(lastBackupName, volume.Name, bsDriver)
 		if err != nil {
-			return "", err
+			return nil, "", err
 		}
 
 		lastSnapshotName = lastBackup.SnapshotName
-		if lastSnapshotName == snapshot.Name {
+		if lastBackup.getBlockSize() != config.getBlockSize() {
+			// The blocks of different sizes cannot be merged
+			log.Debugf("Block size of objectstore has been changed from %v to %v, would create full backup",
+				lastBackup.getBlockSize(), config.getBlockSize())
+			lastBackup = nil
+			lastSnapshotName = ""
+		} else if lastBackup.getChunking() != config.getChunking() {
+			// The chunks and the fixed blocks cannot be merged
+			log.Debugf("Chunking of objectstore has been changed from %v to %v, would create full backup",
+				lastBackup.getChunking(), config.getChunking())
+			lastBackup = nil
+			lastSnapshotName = ""
+		} else if lastBackup.getHash() != config.getHash() {
+			// Every backup must address all its blocks by the same hash
+			log.Debugf("Hash of objectstore has been changed from %v to %v, would create full backup",
+				lastBackup.getHash(), config.getHash())
+			lastBackup = nil
+			lastSnapshotName = ""
+		} else if lastSnapshotName == snapshot.Name {
 			//Generate full snapshot if the snapshot has been backed up last time
 			lastSnapshotName = ""
 			log.Debug("Would create full snapshot metadata")
@@ -86,110 +306,292 @@ func CreateDeltaBlockBackup(volume *Volume, snapshot *Snapshot, destURL, endpoin
 			}).Debug("Cannot find last snapshot in local storage, would process with full backup")
 		}
 	}
+	return lastBackup, lastSnapshotName, nil
+}
 
+// compareSnapshot returns the changed blocks of the snapshot since the last
+// snapshot, aligned to the block size of objectstore
+func compareSnapshot(snapshotName, lastSnapshotName, volumeName string, config *ObjectStoreConfig,
+	deltaOps DeltaBlockBackupOperations) (*metadata.Mappings, error) {
 	log.WithFields(logrus.Fields{
 		LOG_FIELD_REASON:        LOG_REASON_START,
 		LOG_FIELD_OBJECT:        LOG_OBJECT_SNAPSHOT,
 		LOG_FIELD_EVENT:         LOG_EVENT_COMPARE,
-		LOG_FIELD_SNAPSHOT:      snapshot.Name,
+		LOG_FIELD_SNAPSHOT:      snapshotName,
 		LOG_FIELD_LAST_SNAPSHOT: lastSnapshotName,
 	}).Debug("Generating snapshot changed blocks metadata")
 
-	delta, err := deltaOps.CompareSnapshot(snapshot.Name, lastSnapshotName, volume.Name)
+	delta, err := deltaOps.CompareSnapshot(snapshotName, lastSnapshotName, volumeName)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	if delta.BlockSize != DEFAULT_BLOCK_SIZE {
-		return "", fmt.Errorf("Currently doesn't support different block sizes driver other than %v", DEFAULT_BLOCK_SIZE)
+	if blockSize := config.getBlockSize(); delta.BlockSize != blockSize {
+		log.Debugf("Aligning changed blocks of snapshot %v from block size %v of driver to %v of objectstore",
+			snapshotName, delta.BlockSize, blockSize)
+		delta = metadata.AlignMappings(delta, blockSize)
 	}
 	log.WithFields(logrus.Fields{
 		LOG_FIELD_REASON:        LOG_REASON_COMPLETE,
 		LOG_FIELD_OBJECT:        LOG_OBJECT_SNAPSHOT,
 		LOG_FIELD_EVENT:         LOG_EVENT_COMPARE,
-		LOG_FIELD_SNAPSHOT:      snapshot.Name,
+		LOG_FIELD_SNAPSHOT:      snapshotName,
 		LOG_FIELD_LAST_SNAPSHOT: lastSnapshotName,
 	}).Debug("Generated snapshot changed blocks metadata")
 
-	log.WithFields(logrus.Fields{
-		LOG_FIELD_REASON:   LOG_REASON_START,
-		LOG_FIELD_EVENT:    LOG_EVENT_BACKUP,
-		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
-		LOG_FIELD_SNAPSHOT: snapshot.Name,
-	}).Debug("Creating backup")
+	return delta, nil
+}
 
-	deltaBackup := &Backup{
-		Name:         util.GenerateName("backup"),
-		VolumeName:   volume.Name,
-		SnapshotName: snapshot.Name,
-		Blocks:       []BlockMapping{},
+// SetBackupWorkers sets the number of blocks would be hashed, compressed and
+// written to objectstore concurrently when creating backup
+func SetBackupWorkers(workers int) error {
+	if workers < 1 {
+		return fmt.Errorf("Invalid number of backup workers %v, must be at least 1", workers)
 	}
-	mCounts := len(delta.Mappings)
-	for m, d := range delta.Mappings {
+	backupWorkers = workers
+	return nil
+}
+
+type blockJob struct {
+	index  int
+	offset int64
+	data   []byte
+}
+
+/*
+backupBlocks writes the changed blocks of the snapshot to objectstore. The
+snapshot is read in order, and the blocks are processed by backupWorkers
+goroutines. The buffers of blocks are reused, so no more than backupWorkers*2
+blocks would be held in memory. The returned mappings are in the order of
+offsets. The blocks completed in the checkpoint would be skipped, and the
+checkpoint would be saved periodically and when failed. The last block would
+be shorter if the volume size is not a multiple of the block size. The blocks
+of all zeros are recorded as zero blocks without being written. The blocks
+processed are counted in stats.
+*/
+func backupBlocks(delta *metadata.Mappings, volumeSize int64, checkpoint *Checkpoint, config *ObjectStoreConfig, stats *BackupStats,
+	deltaOps DeltaBlockBackupOperations, bsDriver ObjectStoreDriver) ([]BlockMapping, error) {
+	snapshotName, volumeName := checkpoint.SnapshotName, checkpoint.VolumeName
+	hash := config.getHash()
+	aead, err := config.getCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	total := 0
+	for _, d := range delta.Mappings {
 		if d.Size%delta.BlockSize != 0 {
-			return "", fmt.Errorf("Mapping's size %v is not multiples of backup block size %v",
+			return nil, fmt.Errorf("Mapping's size %v is not multiples of backup block size %v",
 				d.Size, delta.BlockSize)
 		}
-		block := make([]byte, DEFAULT_BLOCK_SIZE)
-		blkCounts := d.Size / delta.BlockSize
-		for i := int64(0); i < blkCounts; i++ {
-			offset := d.Offset + i*delta.BlockSize
-			log.Debugf("Backup for %v: segment %v/%v, blocks %v/%v", snapshot.Name, m+1, mCounts, i+1, blkCounts)
-			err := deltaOps.ReadSnapshot(snapshot.Name, volume.Name, offset, block)
-			if err != nil {
-				return "", err
-			}
-			checksum := util.GetChecksum(block)
-			blkFile := getBlockFilePath(volume.Name, checksum)
-			if bsDriver.FileSize(blkFile) >= 0 {
-				blockMapping := BlockMapping{
-					Offset:        offset,
+		total += int(d.Size / delta.BlockSize)
+	}
+	if len(checkpoint.Blocks) > total {
+		return nil, fmt.Errorf("Invalid checkpoint of snapshot %v with %v blocks, more than %v changed blocks",
+			snapshotName, len(checkpoint.Blocks), total)
+	}
+	blocks := make([]BlockMapping, total)
+	copy(blocks, checkpoint.Blocks)
+	cp := newCheckpointer(checkpoint, blocks, bsDriver)
+	resumed := len(checkpoint.Blocks)
+	for _, blk := range checkpoint.Blocks {
+		stats.ChangedBlocks++
+		stats.ChangedSize += getBlockLength(blk, delta.BlockSize)
+	}
+
+	progress := startProgress(BackupProgressKey(volumeName, snapshotName), PROGRESS_BACKUP, total)
+	defer progress.finish()
+	progress.add(resumed, 0)
+
+	workers := backupWorkers
+	if workers > total-resumed {
+		workers = total - resumed
+	}
+	buffers := make(chan []byte, workers*2)
+	for i := 0; i < cap(buffers); i++ {
+		buffers <- make([]byte, delta.BlockSize)
+	}
+	jobs := make(chan blockJob)
+	abort := make(chan struct{})
+	var (
+		backupErr error
+		errOnce   sync.Once
+		wg        sync.WaitGroup
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			backupErr = err
+			close(abort)
+		})
+	}
+
+	// Blocks have the same content would be written only once
+	written := make(map[string]bool)
+	writtenMutex := &sync.Mutex{}
+	writeSlots := make(chan struct{}, workers)
+	if serializeWrites(bsDriver) {
+		writeSlots = make(chan struct{}, 1)
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if isZeroData(job.data) {
+					writtenMutex.Lock()
+					stats.addBlock(int64(len(job.data)), 0, true)
+					writtenMutex.Unlock()
+					progress.add(1, 0)
+					cp.complete(job.index, zeroBlockMapping(job.offset, 0))
+					buffers <- job.data[:cap(job.data)]
+					continue
+				}
+				checksum := getChecksum(hash, job.data)
+				writtenMutex.Lock()
+				skip := written[checksum]
+				written[checksum] = true
+				writtenMutex.Unlock()
+				mapping := BlockMapping{
+					Offset:        job.offset,
 					BlockChecksum: checksum,
+					Compression:   config.Compression,
+					Shared:        config.SharedBlocks,
 				}
-				deltaBackup.Blocks = append(deltaBackup.Blocks, blockMapping)
-				log.Debugf("Found existed block match at %v", blkFile)
-				continue
+				var written int64
+				var err error
+				if !skip {
+					mapping.Size, written, err = backupBlock(volumeName, mapping, job.data, aead, bsDriver, writeSlots)
+				}
+				if written > 0 {
+					mapping.EncryptionKeyID = aead.currentKeyID()
+				}
+				if err != nil {
+					fail(err)
+				} else {
+					writtenMutex.Lock()
+					stats.addBlock(int64(len(job.data)), written, false)
+					writtenMutex.Unlock()
+					progress.add(1, written)
+					cp.complete(job.index, mapping)
+				}
+				buffers <- job.data[:cap(job.data)]
 			}
+		}()
+	}
 
-			rs, err := util.CompressData(block)
-			if err != nil {
-				return "", err
+	mCounts := len(delta.Mappings)
+	index := 0
+read:
+	for m, d := range delta.Mappings {
+		blkCounts := d.Size / delta.BlockSize
+		for i := int64(0); i < blkCounts; i++ {
+			if index < resumed {
+				index++
+				continue
 			}
-
-			if err := bsDriver.Write(blkFile, rs); err != nil {
-				return "", err
+			offset := d.Offset + i*delta.BlockSize
+			log.Debugf("Backup for %v: segment %v/%v, blocks %v/%v", snapshotName, m+1, mCounts, i+1, blkCounts)
+			var block []byte
+			select {
+			case block = <-buffers:
+			case <-abort:
+				break read
 			}
-			log.Debugf("Created new block file at %v", blkFile)
-
-			blockMapping := BlockMapping{
-				Offset:        offset,
-				BlockChecksum: checksum,
+			if volumeSize > 0 && offset+delta.BlockSize > volumeSize {
+				block = block[:volumeSize-offset]
+			}
+			if err := deltaOps.ReadSnapshot(snapshotName, volumeName, offset, block); err != nil {
+				fail(err)
+				break read
+			}
+			select {
+			case jobs <- blockJob{index: index, offset: offset, data: block}:
+			case <-abort:
+				break read
 			}
-			deltaBackup.Blocks = append(deltaBackup.Blocks, blockMapping)
+			index++
 		}
 	}
+	close(jobs)
+	wg.Wait()
 
-	log.WithFields(logrus.Fields{
-		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
-		LOG_FIELD_EVENT:    LOG_EVENT_BACKUP,
-		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
-		LOG_FIELD_SNAPSHOT: snapshot.Name,
-	}).Debug("Created snapshot changed blocks")
-
-	backup := mergeSnapshotMap(deltaBackup, lastBackup)
-	backup.SnapshotName = snapshot.Name
-	backup.SnapshotCreatedAt = snapshot.CreatedTime
-	backup.CreatedTime = util.Now()
+	if backupErr != nil {
+		cp.save()
+		return nil, backupErr
+	}
+	fillSkippedBlocks(blocks)
+	return blocks, nil
+}
 
-	if err := saveBackup(backup, bsDriver); err != nil {
-		return "", err
+// fillSkippedBlocks records the size and the key of the block file in the
+// blocks skipped since they have the same content as the others
+func fillSkippedBlocks(blocks []BlockMapping) {
+	written := make(map[string]BlockMapping)
+	for _, blk := range blocks {
+		if blk.Size > 0 {
+			written[blk.BlockChecksum] = blk
+		}
 	}
+	for i := range blocks {
+		if blocks[i].Size == 0 {
+			blocks[i].Size = written[blocks[i].BlockChecksum].Size
+			blocks[i].EncryptionKeyID = written[blocks[i].BlockChecksum].EncryptionKeyID
+		}
+	}
+}
 
-	volume.LastBackupName = backup.Name
-	if err := saveVolume(volume, bsDriver); err != nil {
-		return "", err
+// backupBlock writes the block to objectstore if it doesn't exist, and
+// returns the size of the block file and the bytes written. writeSlots limits
+// the number of the accesses to objectstore at the same time.
+func backupBlock(volumeName string, mapping BlockMapping, block []byte, aead *objectCipher,
+	bsDriver ObjectStoreDriver, writeSlots chan struct{}) (int64, int64, error) {
+	blkFile := getBlockFilePath(volumeName, mapping)
+	writeSlots <- struct{}{}
+	existingSize := bsDriver.FileSize(blkFile)
+	<-writeSlots
+	if existingSize >= 0 {
+		log.Debugf("Found existed block match at %v", blkFile)
+		return existingSize, 0, nil
+	}
+
+	rs, size, err := encodeBlock(blkFile, mapping.Compression, block, aead)
+	if err != nil {
+		return 0, 0, err
 	}
+	writeSlots <- struct{}{}
+	defer func() { <-writeSlots }()
+	if err := bsDriver.Write(blkFile, rs); err != nil {
+		return 0, 0, err
+	}
+	log.Debugf("Created new block file at %v", blkFile)
+	return size, size, nil
+}
 
-	return encodeBackupURL(backup.Name, volume.Name, destURL), nil
+// encodeBlock compresses and encrypts the block as it would be stored at
+// blkFile, and returns the content with its size
+func encodeBlock(blkFile, compression string, block []byte, aead *objectCipher) (io.ReadSeeker, int64, error) {
+	rs, err := compressBlock(compression, block)
+	if err != nil {
+		return nil, 0, err
+	}
+	if aead != nil {
+		data, err := ioutil.ReadAll(rs)
+		if err != nil {
+			return nil, 0, err
+		}
+		if data, err = encryptData(aead, blkFile, data); err != nil {
+			return nil, 0, err
+		}
+		rs = bytes.NewReader(data)
+	}
+	size, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	return rs, size, nil
 }
 
 func mergeSnapshotMap(deltaBackup, lastBackup *Backup) *Backup {
@@ -229,43 +631,113 @@ func mergeSnapshotMap(deltaBackup, lastBackup *Backup) *Backup {
 	return backup
 }
 
-func RestoreDeltaBlockBackup(backupURL, endpoint, volDevName string) error {
+/*
+CheckRestoreDriver checks if the backup of the volume can be restored to a
+volume of the driver. The delta block backups are the raw images of the
+volumes, so they can be restored by any driver writing them to a device or
+an image file, e.g. the backup of a devicemapper volume to a loopback or ebs
+volume. The single file backups can only be restored by the driver created
+them.
+*/
+func CheckRestoreDriver(volume *Volume, backupURL, endpoint, driverName string) error {
+	if volume.Driver == driverName {
+		return nil
+	}
 	bsDriver, err := GetObjectStoreDriver(backupURL, endpoint)
 	if err != nil {
 		return err
 	}
+	backupName, volumeName, err := decodeBackupURL(backupURL)
+	if err != nil {
+		return err
+	}
+	backup, err := loadBackup(backupName, volumeName, bsDriver)
+	if err != nil {
+		return err
+	}
+	if backup.SingleFile.FilePath != "" {
+		return fmt.Errorf("Cannot restore backup of %v to %v", volume.Driver, driverName)
+	}
+	log.Debugf("Restoring delta block backup %v of %v volume to %v volume", backupName, volume.Driver, driverName)
+	return nil
+}
 
-	srcBackupName, srcVolumeName, err := decodeBackupURL(backupURL)
+func RestoreDeltaBlockBackup(backupURL, endpoint, volDevName string) error {
+	_, err := restoreDeltaBlockBackup(backupURL, endpoint, volDevName, 0)
+	return err
+}
+
+/*
+RestoreDeltaBlockBackupToSize restores the backup to the device or file of
+size, which can be larger than the volume backed up, e.g. the volume ran out
+of space. The blocks would be written at their original offsets, then the
+filesystem would be grown to fill the device, see util.GrowFilesystem.
+*/
+func RestoreDeltaBlockBackupToSize(backupURL, endpoint, volDevName string, size int64) error {
+	vol, err := restoreDeltaBlockBackup(backupURL, endpoint, volDevName, size)
 	if err != nil {
 		return err
 	}
+	if size <= vol.Size {
+		return nil
+	}
+	log.Debugf("Growing filesystem on %v from %v to %v", volDevName, vol.Size, size)
+	return growFilesystem(volDevName)
+}
+
+// restoreDeltaBlockBackup restores the backup to volDevName, the file would
+// be truncated to size, or the size of the volume backed up if it's 0
+func restoreDeltaBlockBackup(backupURL, endpoint, volDevName string, size int64) (*Volume, error) {
+	bsDriver, err := GetObjectStoreDriver(backupURL, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	srcBackupName, srcVolumeName, err := decodeBackupURL(backupURL)
+	if err != nil {
+		return nil, err
+	}
 
 	vol, err := loadVolume(srcVolumeName, bsDriver)
 	if err != nil {
-		return generateError(logrus.Fields{
+		return nil, generateError(logrus.Fields{
 			LOG_FIELD_VOLUME:     srcVolumeName,
 			LOG_FIELD_BACKUP_URL: backupURL,
 		}, "Volume doesn't exist in objectstore: %v", err)
 	}
 
-	if vol.Size == 0 || vol.Size%DEFAULT_BLOCK_SIZE != 0 {
-		return fmt.Errorf("Read invalid volume size %v", vol.Size)
+	if vol.Size <= 0 {
+		return nil, fmt.Errorf("Read invalid volume size %v", vol.Size)
+	}
+	if size == 0 {
+		size = vol.Size
+	}
+	if size < vol.Size {
+		return nil, fmt.Errorf("Volume size %v cannot be less than backup's size %v", size, vol.Size)
 	}
 
 	volDev, err := os.Create(volDevName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer volDev.Close()
 
 	stat, err := volDev.Stat()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	backup, err := loadBackup(srcBackupName, srcVolumeName, bsDriver)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	hash := backup.getHash()
+	if err := ValidateHash(hash); err != nil {
+		return nil, err
+	}
+	aead, err := getObjectStoreCipher(bsDriver)
+	if err != nil {
+		return nil, err
 	}
 
 	log.WithFields(logrus.Fields{
@@ -277,49 +749,90 @@ func RestoreDeltaBlockBackup(backupURL, endpoint, volDevName string) error {
 		LOG_FIELD_VOLUME_DEV:  volDevName,
 		LOG_FIELD_BACKUP_URL:  backupURL,
 	}).Debug()
-	blkCounts := len(backup.Blocks)
-	for i, block := range backup.Blocks {
-		log.Debugf("Restore for %v: block %v, %v/%v", volDevName, block.BlockChecksum, i+1, blkCounts)
-		blkFile := getBlockFilePath(srcVolumeName, block.BlockChecksum)
-		rc, err := bsDriver.Read(blkFile)
-		if err != nil {
-			return err
+	progress := startProgress(RestoreProgressKey(backupURL), PROGRESS_RESTORE, len(backup.Blocks))
+	defer progress.finish()
+	restorer := &blockRestorer{
+		volDev:     volDev,
+		volumeSize: vol.Size,
+		volumeName: srcVolumeName,
+		backup:     backup,
+		hash:       hash,
+		aead:       aead,
+		bsDriver:   bsDriver,
+		// The regular file has just been truncated, so the zero blocks
+		// are left as holes in it
+		writeZeroBlocks: stat.Mode()&os.ModeType != 0,
+	}
+	if err := restorer.restore(backup.Blocks, progress); err != nil {
+		return nil, err
+	}
+
+	// We want to truncate regular files, but not device
+	if stat.Mode()&os.ModeType == 0 {
+		log.Debugf("Truncate %v to size %v", volDevName, size)
+		if err := volDev.Truncate(size); err != nil {
+			return nil, err
 		}
-		r, err := util.DecompressAndVerify(rc, block.BlockChecksum)
-		rc.Close()
+	}
+
+	return vol, nil
+}
+
+func readBlock(blkFile string, block BlockMapping, hash string, rc io.Reader, aead *objectCipher) (io.Reader, error) {
+	if aead != nil {
+		data, err := ioutil.ReadAll(rc)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if _, err := volDev.Seek(block.Offset, 0); err != nil {
-			return err
-		}
-		if _, err := io.CopyN(volDev, r, DEFAULT_BLOCK_SIZE); err != nil {
-			return err
+		if data, err = decryptData(aead, blkFile, data); err != nil {
+			return nil, err
 		}
+		rc = bytes.NewReader(data)
 	}
+	return decompressBlock(blockCompression(block), hash, rc, block.BlockChecksum)
+}
 
-	// We want to truncate regular files, but not device
-	if stat.Mode()&os.ModeType == 0 {
-		log.Debugf("Truncate %v to size %v", volDevName, vol.Size)
-		if err := volDev.Truncate(vol.Size); err != nil {
-			return err
+func getDependentBackupNames(backupName, volumeName string, driver ObjectStoreDriver) ([]string, error) {
+	result := []string{}
+	backupNames, err := getBackupNamesForVolume(volumeName, driver)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range backupNames {
+		if name == backupName {
+			continue
+		}
+		backup, err := loadBackup(name, volumeName, driver)
+		if err != nil {
+			return nil, err
+		}
+		if backup.ParentBackupName == backupName {
+			result = append(result, name)
 		}
 	}
-
-	return nil
+	return result, nil
 }
 
-func DeleteDeltaBlockBackup(backupURL, endpoint string) error {
+func DeleteDeltaBlockBackup(backupURL, endpoint string, force bool) error {
 	bsDriver, err := GetObjectStoreDriver(backupURL, endpoint)
 	if err != nil {
 		return err
 	}
+	if err := checkWritable(bsDriver); err != nil {
+		return err
+	}
 
 	backupName, volumeName, err := decodeBackupURL(backupURL)
 	if err != nil {
 		return err
 	}
 
+	unlock, err := lockVolume(volumeName, bsDriver)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	v, err := loadVolume(volumeName, bsDriver)
 	if err != nil {
 		return fmt.Errorf("Cannot find volume %v in objectstore", volumeName, err)
@@ -329,16 +842,53 @@ func DeleteDeltaBlockBackup(backupURL, endpoint string) error {
 	if err != nil {
 		return err
 	}
-	discardBlockSet := make(map[string]bool)
-	for _, blk := range backup.Blocks {
-		discardBlockSet[blk.BlockChecksum] = true
+	if err := checkBackupRetention(backup, bsDriver); err != nil {
+		return err
+	}
+
+	dependents, err := getDependentBackupNames(backupName, volumeName, bsDriver)
+	if err != nil {
+		return err
+	}
+	if len(dependents) != 0 {
+		if !force {
+			return fmt.Errorf("Backup %v is the base of backups %v, use force to remove it anyway", backupName, dependents)
+		}
+		log.WithFields(logrus.Fields{
+			LOG_FIELD_VOLUME:     volumeName,
+			LOG_FIELD_BACKUP_URL: backupURL,
+		}).Warnf("Removing backup %v, backups %v would lose their base backup", backupName, dependents)
+	}
+
+	// Only the blocks of the backup need to be checked against the reference
+	// index, rather than the blocks of all the other backups
+	unusedBlkFiles, err := unindexBackup(backup, bsDriver)
+	if err != nil {
+		return err
 	}
-	discardBlockCounts := len(discardBlockSet)
 
+	if backup.Image != nil {
+		if err := bsDriver.Remove(backup.Image.FilePath); err != nil {
+			return err
+		}
+		log.Debugf("Removed image %v of backup %v", backup.Image.FilePath, backup.Name)
+	}
 	if err := removeBackup(backup, bsDriver); err != nil {
 		return err
 	}
 
+	// Link the orphaned backups to the base of the removed one
+	for _, name := range dependents {
+		dependent, err := loadBackup(name, volumeName, bsDriver)
+		if err != nil {
+			return err
+		}
+		dependent.ParentBackupName = backup.ParentBackupName
+		if err := saveBackup(dependent, bsDriver); err != nil {
+			return err
+		}
+	}
+
 	if backup.Name == v.LastBackupName {
 		v.LastBackupName = ""
 		if err := saveVolume(v, bsDriver); err != nil {
@@ -350,43 +900,62 @@ func DeleteDeltaBlockBackup(backupURL, endpoint string) error {
 	if err != nil {
 		return err
 	}
-	if len(backupNames) == 0 {
+	// The retained blocks cannot be removed with the volume, the volume
+	// would be removed once they're removed
+	if len(backupNames) == 0 && getRetainer(bsDriver) == nil {
 		log.Debugf("No snapshot existed for the volume %v, removing volume", volumeName)
 		if err := removeVolume(volumeName, bsDriver); err != nil {
 			log.Warningf("Failed to remove volume %v due to: %v", volumeName, err.Error())
 		}
 		return nil
 	}
+	// The shared blocks may be used by other volumes, they're left to the
+	// garbage collection
+	discardBlockSet := make(map[string]bool)
+	sharedBlkFiles := []string{}
+	for _, blkFile := range unusedBlkFiles {
+		if strings.HasPrefix(blkFile, getSharedBlockPath()) {
+			sharedBlkFiles = append(sharedBlkFiles, blkFile)
+			continue
+		}
+		discardBlockSet[blkFile] = true
+	}
+	if err := removeBlockRefs(volumeName, sharedBlkFiles, bsDriver); err != nil {
+		return err
+	}
 
 	log.Debug("GC started")
-	for _, backupName := range backupNames {
-		backup, err := loadBackup(backupName, volumeName, bsDriver)
-		if err != nil {
-			return err
-		}
-		for _, blk := range backup.Blocks {
-			if _, exists := discardBlockSet[blk.BlockChecksum]; exists {
-				delete(discardBlockSet, blk.BlockChecksum)
-				discardBlockCounts--
-				if discardBlockCounts == 0 {
-					break
-				}
-			}
-		}
-		if discardBlockCounts == 0 {
-			break
-		}
+	checkpointBlkFiles, err := getCheckpointBlockFiles(volumeName, bsDriver)
+	if err != nil {
+		return err
+	}
+	for blkFile := range checkpointBlkFiles {
+		delete(discardBlockSet, blkFile)
 	}
 
 	var blkFileList []string
-	for blk := range discardBlockSet {
-		blkFileList = append(blkFileList, getBlockFilePath(volumeName, blk))
-		log.Debugf("Found unused blocks %v for volume %v", blk, volumeName)
+	for blkFile := range discardBlockSet {
+		blkFileList = append(blkFileList, blkFile)
+		log.Debugf("Found unused blocks %v for volume %v", blkFile, volumeName)
+	}
+	blkFileList, retained, err := filterRetainedFiles(blkFileList, bsDriver)
+	if err != nil {
+		return err
+	}
+	if retained != 0 {
+		log.Debugf("%v unused blocks of volume %v are still retained, they're left to the garbage collection",
+			retained, volumeName)
 	}
 	if err := bsDriver.Remove(blkFileList...); err != nil {
 		return err
 	}
 	log.Debug("Removed unused blocks for volume ", volumeName)
+	if len(backupNames) == 0 && retained == 0 {
+		log.Debugf("No snapshot existed for the volume %v, removing volume", volumeName)
+		if err := removeVolume(volumeName, bsDriver); err != nil {
+			log.Warningf("Failed to remove volume %v due to: %v", volumeName, err.Error())
+		}
+	}
 
 	log.Debug("GC completed")
 	log.Debug("Removed objectstore backup ", backupName)
@@ -398,11 +967,16 @@ func getBlockPath(volumeName string) string {
 	return filepath.Join(getVolumePath(volumeName), BLOCKS_DIRECTORY) + "/"
 }
 
-func getBlockFilePath(volumeName, checksum string) string {
+func getBlockFilePath(volumeName string, block BlockMapping) string {
+	checksum := block.BlockChecksum
 	blockSubDirLayer1 := checksum[0:BLOCK_SEPARATE_LAYER1]
 	blockSubDirLayer2 := checksum[BLOCK_SEPARATE_LAYER1:BLOCK_SEPARATE_LAYER2]
-	path := filepath.Join(getBlockPath(volumeName), blockSubDirLayer1, blockSubDirLayer2)
-	fileName := checksum + ".blk"
+	blockPath := getBlockPath(volumeName)
+	if block.Shared {
+		blockPath = getSharedBlockPath()
+	}
+	path := filepath.Join(blockPath, blockSubDirLayer1, blockSubDirLayer2)
+	fileName := checksum + compressionSuffixes[blockCompression(block)] + BLOCK_FILE_SUFFIX
 
 	return filepath.Join(path, fileName)
 }
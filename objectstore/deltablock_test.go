@@ -0,0 +1,278 @@
+package objectstore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/rancher/convoy/metadata"
+	"github.com/rancher/convoy/util"
+	"gopkg.in/check.v1"
+)
+
+const (
+	testVolumeName = "test-volume"
+)
+
+// testBlockFile returns the path of the block compressed by gzip, which is
+// the compression used by createTestBackup
+func testBlockFile(checksum string) string {
+	return getBlockFilePath(testVolumeName, BlockMapping{BlockChecksum: checksum})
+}
+
+func (s *TestSuite) createTestBackup(c *check.C, name, parent string, checksums ...string) {
+	backup := &Backup{
+		Name:             name,
+		Driver:           "test",
+		VolumeName:       testVolumeName,
+		ParentBackupName: parent,
+		Blocks:           []BlockMapping{},
+	}
+	for i, checksum := range checksums {
+		backup.Blocks = append(backup.Blocks, BlockMapping{
+			Offset:        int64(i) * DEFAULT_BLOCK_SIZE,
+			BlockChecksum: checksum,
+		})
+		err := memStore.Write(testBlockFile(checksum), bytes.NewReader([]byte(checksum)))
+		c.Assert(err, check.IsNil)
+	}
+	c.Assert(saveBackup(backup, memStore), check.IsNil)
+
+	volume := &Volume{
+		Name:           testVolumeName,
+		Driver:         "test",
+		LastBackupName: name,
+	}
+	c.Assert(saveVolume(volume, memStore), check.IsNil)
+}
+
+func (s *TestSuite) createTestBackupChain(c *check.C) {
+	s.createTestBackup(c, "backup-1", "", "aaaa1111", "bbbb1111")
+	s.createTestBackup(c, "backup-2", "backup-1", "aaaa1111", "bbbb2222")
+	s.createTestBackup(c, "backup-3", "backup-2", "aaaa3333", "bbbb2222")
+}
+
+func (s *TestSuite) TestDeleteLeafBackup(c *check.C) {
+	s.createTestBackupChain(c)
+
+	err := DeleteDeltaBlockBackup(encodeBackupURL("backup-3", testVolumeName, MEM_URL), "", false)
+	c.Assert(err, check.IsNil)
+
+	c.Assert(backupExists("backup-3", testVolumeName, memStore), check.Equals, false)
+	c.Assert(memStore.FileExists(testBlockFile("aaaa3333")), check.Equals, false)
+	c.Assert(memStore.FileExists(testBlockFile("bbbb2222")), check.Equals, true)
+
+	volume, err := loadVolume(testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(volume.LastBackupName, check.Equals, "")
+}
+
+func (s *TestSuite) TestDeleteInteriorBackup(c *check.C) {
+	s.createTestBackupChain(c)
+	backupURL := encodeBackupURL("backup-2", testVolumeName, MEM_URL)
+
+	err := DeleteDeltaBlockBackup(backupURL, "", false)
+	c.Assert(err, check.ErrorMatches, "Backup backup-2 is the base of backups \\[backup-3\\].*")
+	c.Assert(backupExists("backup-2", testVolumeName, memStore), check.Equals, true)
+
+	err = DeleteDeltaBlockBackup(backupURL, "", true)
+	c.Assert(err, check.IsNil)
+	c.Assert(backupExists("backup-2", testVolumeName, memStore), check.Equals, false)
+	c.Assert(memStore.FileExists(testBlockFile("bbbb2222")), check.Equals, true)
+
+	backup, err := loadBackup("backup-3", testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup.ParentBackupName, check.Equals, "backup-1")
+}
+
+func (s *TestSuite) TestDeleteLockedBackup(c *check.C) {
+	s.createTestBackupChain(c)
+	backupURL := encodeBackupURL("backup-3", testVolumeName, MEM_URL)
+
+	lockPath := getVolumeLockPath(testVolumeName)
+	c.Assert(memStore.Lock(lockPath), check.IsNil)
+	err := DeleteDeltaBlockBackup(backupURL, "", false)
+	c.Assert(err, check.ErrorMatches, ".* is locked")
+	c.Assert(backupExists("backup-3", testVolumeName, memStore), check.Equals, true)
+
+	c.Assert(memStore.Unlock(lockPath), check.IsNil)
+	c.Assert(DeleteDeltaBlockBackup(backupURL, "", false), check.IsNil)
+	c.Assert(memStore.locks, check.HasLen, 0)
+}
+
+func (s *TestSuite) TestGetDependentBackupNames(c *check.C) {
+	s.createTestBackupChain(c)
+
+	dependents, err := getDependentBackupNames("backup-1", testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(dependents, check.DeepEquals, []string{"backup-2"})
+
+	dependents, err = getDependentBackupNames("backup-3", testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(dependents, check.HasLen, 0)
+}
+
+// fakeDeltaOps provides a snapshot whose block i is filled with byte i%3+1,
+// so the blocks have duplicated content but none of them is zero
+type fakeDeltaOps struct {
+	mappings []metadata.Mapping
+	failAt   int64
+	// The offsets have been read, in order
+	reads []int64
+}
+
+func (f *fakeDeltaOps) HasSnapshot(id, volumeID string) bool {
+	return false
+}
+
+func (f *fakeDeltaOps) CompareSnapshot(id, compareID, volumeID string) (*metadata.Mappings, error) {
+	return &metadata.Mappings{
+		Mappings:  f.mappings,
+		BlockSize: DEFAULT_BLOCK_SIZE,
+	}, nil
+}
+
+func (f *fakeDeltaOps) OpenSnapshot(id, volumeID string) error {
+	return nil
+}
+
+func (f *fakeDeltaOps) ReadSnapshot(id, volumeID string, start int64, data []byte) error {
+	if f.failAt > 0 && start == f.failAt {
+		return fmt.Errorf("Failed to read at %v", start)
+	}
+	f.reads = append(f.reads, start)
+	for i := range data {
+		data[i] = byte(start/DEFAULT_BLOCK_SIZE%3 + 1)
+	}
+	return nil
+}
+
+func (f *fakeDeltaOps) CloseSnapshot(id, volumeID string) error {
+	return nil
+}
+
+func (s *TestSuite) TestCreateDeltaBlockBackup(c *check.C) {
+	defer SetBackupWorkers(DEFAULT_BACKUP_WORKERS)
+
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: 0, Size: 4 * DEFAULT_BLOCK_SIZE},
+			{Offset: 6 * DEFAULT_BLOCK_SIZE, Size: 3 * DEFAULT_BLOCK_SIZE},
+		},
+	}
+	offsets := []int64{0, 1, 2, 3, 6, 7, 8}
+	for _, workers := range []int{1, 3, 16} {
+		c.Assert(SetBackupWorkers(workers), check.IsNil)
+		memStore.files = make(map[string][]byte)
+
+		volume := &Volume{
+			Name:   testVolumeName,
+			Driver: "test",
+		}
+		snapshot := &Snapshot{
+			Name: fmt.Sprintf("snapshot-%v", workers),
+		}
+		backupURL, err := CreateDeltaBlockBackup(volume, snapshot, MEM_URL, "", deltaOps)
+		c.Assert(err, check.IsNil)
+
+		backupName, _, err := decodeBackupURL(backupURL)
+		c.Assert(err, check.IsNil)
+		backup, err := loadBackup(backupName, testVolumeName, memStore)
+		c.Assert(err, check.IsNil)
+		c.Assert(backup.Blocks, check.HasLen, len(offsets))
+		checksums := map[string]bool{}
+		for i, block := range backup.Blocks {
+			c.Assert(block.Offset, check.Equals, offsets[i]*DEFAULT_BLOCK_SIZE)
+			c.Assert(memStore.FileExists(getBlockFilePath(testVolumeName, block)), check.Equals, true)
+			checksums[block.BlockChecksum] = true
+		}
+		c.Assert(checksums, check.HasLen, 3)
+		c.Assert(backup.Blocks[0].BlockChecksum, check.Equals, backup.Blocks[3].BlockChecksum)
+	}
+
+	c.Assert(SetBackupWorkers(0), check.ErrorMatches, "Invalid number of backup workers.*")
+}
+
+func (s *TestSuite) TestCreateDeltaBlockBackupFailure(c *check.C) {
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: 0, Size: 16 * DEFAULT_BLOCK_SIZE},
+		},
+		failAt: 10 * DEFAULT_BLOCK_SIZE,
+	}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+	}
+	snapshot := &Snapshot{
+		Name: "snapshot",
+	}
+	_, err := CreateDeltaBlockBackup(volume, snapshot, MEM_URL, "", deltaOps)
+	c.Assert(err, check.ErrorMatches, "Failed to read at .*")
+
+	volume, err = loadVolume(testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(volume.LastBackupName, check.Equals, "")
+	c.Assert(memStore.locks, check.HasLen, 0)
+}
+
+func (s *TestSuite) TestCheckRestoreDriver(c *check.C) {
+	s.createTestBackup(c, "backup-1", "", "aaaa1111")
+	volume, err := loadVolume(testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	backupURL := encodeBackupURL("backup-1", testVolumeName, MEM_URL)
+	c.Assert(CheckRestoreDriver(volume, backupURL, "", "test"), check.IsNil)
+	c.Assert(CheckRestoreDriver(volume, backupURL, "", "other"), check.IsNil)
+
+	// The single file backups can only be restored by the same driver
+	backup := &Backup{
+		Name:       "backup-2",
+		Driver:     "test",
+		VolumeName: testVolumeName,
+		SingleFile: BackupFile{FilePath: "test.tar.gz"},
+	}
+	c.Assert(saveBackup(backup, memStore), check.IsNil)
+	backupURL = encodeBackupURL("backup-2", testVolumeName, MEM_URL)
+	c.Assert(CheckRestoreDriver(volume, backupURL, "", "test"), check.IsNil)
+	c.Assert(CheckRestoreDriver(volume, backupURL, "", "other"), check.ErrorMatches, "Cannot restore backup of test to other")
+}
+
+func (s *TestSuite) TestRestoreDeltaBlockBackupToSize(c *check.C) {
+	grown := []string{}
+	defer func() {
+		growFilesystem = util.GrowFilesystem
+	}()
+	growFilesystem = func(dev string) error {
+		grown = append(grown, dev)
+		return nil
+	}
+
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: 0, Size: 2 * DEFAULT_BLOCK_SIZE},
+		},
+	}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+
+	volFile := filepath.Join(c.MkDir(), "volume")
+	err = RestoreDeltaBlockBackupToSize(backupURL, "", volFile, DEFAULT_BLOCK_SIZE)
+	c.Assert(err, check.ErrorMatches, "Volume size .* cannot be less than backup's size .*")
+
+	// The filesystem would only be grown for the larger volume
+	c.Assert(RestoreDeltaBlockBackupToSize(backupURL, "", volFile, volume.Size), check.IsNil)
+	c.Assert(grown, check.HasLen, 0)
+	c.Assert(RestoreDeltaBlockBackupToSize(backupURL, "", volFile, 4*DEFAULT_BLOCK_SIZE), check.IsNil)
+	c.Assert(grown, check.DeepEquals, []string{volFile})
+	data, err := ioutil.ReadFile(volFile)
+	c.Assert(err, check.IsNil)
+	c.Assert(data, check.HasLen, 4*DEFAULT_BLOCK_SIZE)
+	c.Assert(data[DEFAULT_BLOCK_SIZE], check.Equals, byte(2))
+	c.Assert(data[3*DEFAULT_BLOCK_SIZE], check.Equals, byte(0))
+}
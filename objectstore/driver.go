@@ -25,6 +25,28 @@ type ObjectStoreDriver interface {
 	Download(src, dst string) error
 }
 
+// ObjectStoreLocker is an optional interface of ObjectStoreDriver, for the
+// destinations which can be written by multiple hosts at the same time. Lock
+// would wait until the lock is acquired, or return error when timed out.
+type ObjectStoreLocker interface {
+	Lock(lockPath string) error
+	Unlock(lockPath string) error
+}
+
+// ObjectStoreSerializer is an optional interface of ObjectStoreDriver, for the
+// destinations which cannot be written by multiple goroutines at the same
+// time. The blocks of backup would still be hashed and compressed
+// concurrently, but written to the destination one by one if SerializeWrites
+// returns true.
+type ObjectStoreSerializer interface {
+	SerializeWrites() bool
+}
+
+func serializeWrites(driver ObjectStoreDriver) bool {
+	serializer, ok := unthrottled(driver).(ObjectStoreSerializer)
+	return ok && serializer.SerializeWrites()
+}
+
 var (
 	initializers map[string]InitFunc
 )
@@ -69,5 +91,13 @@ func GetObjectStoreDriver(destURL, endpoint string) (ObjectStoreDriver, error) {
 			return nil, err
 		}
 	}
-	return initializers[u.Scheme](destURL, endpoint)
+	driver, err := initializers[u.Scheme](destURL, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	driver = throttleDriver(driver, destURL, driver.GetURL())
+	if isReadOnly(destURL, driver.GetURL()) {
+		return &readOnlyDriver{driver}, nil
+	}
+	return driver, nil
 }
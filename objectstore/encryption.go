@@ -0,0 +1,346 @@
+package objectstore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	ENCRYPTION_KEY_SIZE  = 32
+	ENCRYPTION_SALT_SIZE = 16
+	KEY_ID_SIZE          = 8
+
+	// Iterations of PBKDF2 to derive the key from passphrase
+	PBKDF2_ITERATIONS = 100000
+
+	// The encrypted objects start with the magic and the ID of the key
+	// encrypted them. The objects written by the older versions have no
+	// header, see ObjectStoreConfig.getEncryptionKeyIDs.
+	ENCRYPTION_HEADER_MAGIC = "CVE1"
+	ENCRYPTION_HEADER_SIZE  = len(ENCRYPTION_HEADER_MAGIC) + KEY_ID_SIZE
+)
+
+/*
+EncryptionKey is the key used to encrypt the objectstores. The key file
+contains either 64 hex digits as the raw AES-256 key, or a passphrase. The key
+would be derived from the passphrase using the salt of each objectstore.
+*/
+type EncryptionKey struct {
+	File       string
+	raw        []byte
+	passphrase []byte
+}
+
+var (
+	encryptionKeys = []*EncryptionKey{}
+
+	derivedKeysMutex = &sync.Mutex{}
+	derivedKeys      = map[string][]byte{}
+)
+
+func LoadEncryptionKey(file string) (*EncryptionKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read encryption key: %v", err)
+	}
+	content := strings.TrimSpace(string(data))
+	if content == "" {
+		return nil, fmt.Errorf("Encryption key file %v is empty", file)
+	}
+	key := &EncryptionKey{
+		File: file,
+	}
+	if raw, err := hex.DecodeString(content); err == nil && len(raw) == ENCRYPTION_KEY_SIZE {
+		key.raw = raw
+	} else {
+		key.passphrase = []byte(content)
+	}
+	return key, nil
+}
+
+// SetEncryptionKeys loads the key files. The first key would be used to
+// encrypt the objectstores used for the first time, and the others are only
+// used to access the objectstores encrypted by them.
+func SetEncryptionKeys(files []string) error {
+	keys := []*EncryptionKey{}
+	for _, file := range files {
+		key, err := LoadEncryptionKey(file)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	encryptionKeys = keys
+	return nil
+}
+
+// pbkdf2 implements PBKDF2 with HMAC-SHA256 as RFC 2898
+func pbkdf2(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+	result := make([]byte, 0, blocks*hashLen)
+	buf := make([]byte, 4)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf, uint32(block))
+		prf.Write(buf)
+		u := prf.Sum(nil)
+		t := make([]byte, len(u))
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		result = append(result, t...)
+	}
+	return result[:keyLen]
+}
+
+// derive returns the AES key for the objectstore has the salt
+func (k *EncryptionKey) derive(salt []byte) []byte {
+	if k.raw != nil {
+		return k.raw
+	}
+	cacheKey := k.File + ":" + hex.EncodeToString(salt)
+	derivedKeysMutex.Lock()
+	defer derivedKeysMutex.Unlock()
+	if key, ok := derivedKeys[cacheKey]; ok {
+		return key
+	}
+	key := pbkdf2(k.passphrase, salt, PBKDF2_ITERATIONS, ENCRYPTION_KEY_SIZE)
+	derivedKeys[cacheKey] = key
+	return key
+}
+
+// DeriveKey returns the AES key derived by the salt, so the key files of the
+// objectstores can be used to encrypt the other data as well
+func (k *EncryptionKey) DeriveKey(salt []byte) []byte {
+	return k.derive(salt)
+}
+
+// getKeyID identifies the key without revealing it
+func getKeyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte("convoy-objectstore-key:"), key...))
+	return hex.EncodeToString(sum[:KEY_ID_SIZE])
+}
+
+// initEncryption encrypts the new objectstore by the first key, if any key
+// has been loaded
+func (config *ObjectStoreConfig) initEncryption() error {
+	if len(encryptionKeys) == 0 {
+		return nil
+	}
+	salt := make([]byte, ENCRYPTION_SALT_SIZE)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+	config.EncryptionSalt = hex.EncodeToString(salt)
+	config.EncryptionKeyID = getKeyID(encryptionKeys[0].derive(salt))
+	return nil
+}
+
+// getEncryptionKeyIDs returns the IDs of all the keys which may have
+// encrypted objects in the objectstore. The first one is the key encrypted
+// the objects without header.
+func (config *ObjectStoreConfig) getEncryptionKeyIDs() []string {
+	if len(config.EncryptionKeyIDs) == 0 {
+		return []string{config.EncryptionKeyID}
+	}
+	return config.EncryptionKeyIDs
+}
+
+/*
+objectCipher encrypts the objects by the current key of the objectstore, and
+decrypts them by the key recorded in their headers. The keys retired from the
+objectstore are not accepted any more, even if they're still loaded.
+*/
+type objectCipher struct {
+	keyID       string
+	legacyKeyID string
+	aeads       map[string]cipher.AEAD
+}
+
+// getCipher returns nil if the objectstore is not encrypted, otherwise the
+// cipher using the loaded keys match the key IDs of the objectstore. The
+// current key must be loaded, while the others are only needed to decrypt
+// the objects encrypted by them.
+func (config *ObjectStoreConfig) getCipher() (*objectCipher, error) {
+	if config.EncryptionKeyID == "" {
+		return nil, nil
+	}
+	salt, err := hex.DecodeString(config.EncryptionSalt)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid encryption salt of objectstore: %v", err)
+	}
+	keyIDs := config.getEncryptionKeyIDs()
+	c := &objectCipher{
+		keyID:       config.EncryptionKeyID,
+		legacyKeyID: keyIDs[0],
+		aeads:       map[string]cipher.AEAD{},
+	}
+	for _, keyID := range keyIDs {
+		c.aeads[keyID] = nil
+	}
+	for _, k := range encryptionKeys {
+		key := k.derive(salt)
+		keyID := getKeyID(key)
+		if aead, ok := c.aeads[keyID]; !ok || aead != nil {
+			continue
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if c.aeads[keyID], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	if c.aeads[c.keyID] == nil {
+		return nil, fmt.Errorf("Objectstore is encrypted by key %v, which hasn't been loaded by --backup-keys", config.EncryptionKeyID)
+	}
+	return c, nil
+}
+
+// currentKeyID returns the ID of the key encrypting the new objects, or empty
+// if the objectstore is not encrypted
+func (c *objectCipher) currentKeyID() string {
+	if c == nil {
+		return ""
+	}
+	return c.keyID
+}
+
+// getObjectKeyID returns the ID of the key encrypted the data, and the
+// encrypted content after the header
+func (c *objectCipher) getObjectKeyID(data []byte) (string, []byte) {
+	if len(data) >= ENCRYPTION_HEADER_SIZE && string(data[:len(ENCRYPTION_HEADER_MAGIC)]) == ENCRYPTION_HEADER_MAGIC {
+		keyID := hex.EncodeToString(data[len(ENCRYPTION_HEADER_MAGIC):ENCRYPTION_HEADER_SIZE])
+		if _, ok := c.aeads[keyID]; ok {
+			return keyID, data[ENCRYPTION_HEADER_SIZE:]
+		}
+	}
+	return c.legacyKeyID, data
+}
+
+func getObjectStoreCipher(driver ObjectStoreDriver) (*objectCipher, error) {
+	config, err := loadObjectStoreConfig(driver)
+	if err != nil {
+		return nil, err
+	}
+	return config.getCipher()
+}
+
+// encryptData seals the data by the current key, with the header and a random
+// nonce prepended. The path of the file is authenticated as well, so the
+// files cannot be swapped.
+func encryptData(c *objectCipher, filePath string, data []byte) ([]byte, error) {
+	aead := c.aeads[c.keyID]
+	keyID, err := hex.DecodeString(c.keyID)
+	if err != nil || len(keyID) != KEY_ID_SIZE {
+		return nil, fmt.Errorf("Invalid encryption key ID %v of objectstore", c.keyID)
+	}
+	result := make([]byte, 0, ENCRYPTION_HEADER_SIZE+aead.NonceSize()+len(data)+aead.Overhead())
+	result = append(append(result, ENCRYPTION_HEADER_MAGIC...), keyID...)
+	nonce := result[len(result) : len(result)+aead.NonceSize()]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(result[:len(result)+len(nonce)], nonce, data, []byte(filePath)), nil
+}
+
+func decryptData(c *objectCipher, filePath string, data []byte) ([]byte, error) {
+	keyID, data := c.getObjectKeyID(data)
+	aead := c.aeads[keyID]
+	if aead == nil {
+		return nil, fmt.Errorf("Failed to decrypt %v, it's encrypted by key %v, which hasn't been loaded by --backup-keys",
+			filePath, keyID)
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("Failed to decrypt %v: file is too short", filePath)
+	}
+	nonce := data[:aead.NonceSize()]
+	result, err := aead.Open(nil, nonce, data[aead.NonceSize():], []byte(filePath))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decrypt %v, it may be corrupted: %v", filePath, err)
+	}
+	return result, nil
+}
+
+// loadEncryptedConfigInObjectStore is the same as loadConfigInObjectStore if
+// aead is nil
+func loadEncryptedConfigInObjectStore(filePath string, driver ObjectStoreDriver, aead *objectCipher, v interface{}) error {
+	if aead == nil {
+		return loadConfigInObjectStore(filePath, driver, v)
+	}
+	if driver.FileSize(filePath) < 0 {
+		return fmt.Errorf("cannot find %v in objectstore", filePath)
+	}
+	rc, err := driver.Read(filePath)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return err
+	}
+	j, err := decryptData(aead, filePath, data)
+	if err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_CONFIG,
+		LOG_FIELD_KIND:     driver.Kind(),
+		LOG_FIELD_FILEPATH: filePath,
+	}).Debug("Loaded encrypted config")
+	return json.Unmarshal(j, v)
+}
+
+// saveEncryptedConfigInObjectStore is the same as saveConfigInObjectStore if
+// aead is nil
+func saveEncryptedConfigInObjectStore(filePath string, driver ObjectStoreDriver, aead *objectCipher, v interface{}) error {
+	if aead == nil {
+		return saveConfigInObjectStore(filePath, driver, v)
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	data, err := encryptData(aead, filePath, j)
+	if err != nil {
+		return err
+	}
+	if err := driver.Write(filePath, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_CONFIG,
+		LOG_FIELD_KIND:     driver.Kind(),
+		LOG_FIELD_FILEPATH: filePath,
+	}).Debug("Saved encrypted config")
+	return nil
+}
@@ -0,0 +1,208 @@
+package objectstore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/convoy/metadata"
+	"gopkg.in/check.v1"
+)
+
+func writeKeyFile(c *check.C, content string) string {
+	file := filepath.Join(c.MkDir(), "key")
+	c.Assert(ioutil.WriteFile(file, []byte(content), 0600), check.IsNil)
+	return file
+}
+
+func (s *TestSuite) TestPBKDF2(c *check.C) {
+	// Test vectors from RFC 7914
+	key := pbkdf2([]byte("passwd"), []byte("salt"), 1, 64)
+	c.Assert(hex.EncodeToString(key), check.Equals,
+		"55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"+
+			"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783")
+	key = pbkdf2([]byte("Password"), []byte("NaCl"), 80000, 64)
+	c.Assert(hex.EncodeToString(key), check.Equals,
+		"4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56"+
+			"a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d")
+}
+
+func (s *TestSuite) TestLoadEncryptionKey(c *check.C) {
+	rawKey := strings.Repeat("0f", ENCRYPTION_KEY_SIZE)
+	key, err := LoadEncryptionKey(writeKeyFile(c, rawKey+"\n"))
+	c.Assert(err, check.IsNil)
+	c.Assert(hex.EncodeToString(key.derive([]byte("salt"))), check.Equals, rawKey)
+
+	key, err = LoadEncryptionKey(writeKeyFile(c, "correct horse battery staple\n"))
+	c.Assert(err, check.IsNil)
+	c.Assert(key.passphrase, check.DeepEquals, []byte("correct horse battery staple"))
+	key1 := key.derive([]byte("salt-1"))
+	c.Assert(key1, check.HasLen, ENCRYPTION_KEY_SIZE)
+	c.Assert(bytes.Equal(key1, key.derive([]byte("salt-2"))), check.Equals, false)
+
+	_, err = LoadEncryptionKey(writeKeyFile(c, " \n"))
+	c.Assert(err, check.ErrorMatches, "Encryption key file .* is empty")
+	_, err = LoadEncryptionKey(filepath.Join(c.MkDir(), "missing"))
+	c.Assert(err, check.ErrorMatches, "Failed to read encryption key: .*")
+}
+
+func (s *TestSuite) TestEncryptedBackup(c *check.C) {
+	defer SetEncryptionKeys(nil)
+
+	rightKey := writeKeyFile(c, "right passphrase")
+	wrongKey := writeKeyFile(c, strings.Repeat("ab", ENCRYPTION_KEY_SIZE))
+	c.Assert(SetEncryptionKeys([]string{rightKey}), check.IsNil)
+
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: 0, Size: 2 * DEFAULT_BLOCK_SIZE},
+		},
+	}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+
+	config, err := loadObjectStoreConfig(memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(config.EncryptionKeyID, check.HasLen, KEY_ID_SIZE*2)
+	c.Assert(config.EncryptionSalt, check.HasLen, ENCRYPTION_SALT_SIZE*2)
+
+	// Backup config should be not readable without the key
+	backupName, _, err := decodeBackupURL(backupURL)
+	c.Assert(err, check.IsNil)
+	backupFile := getBackupConfigPath(backupName, testVolumeName)
+	c.Assert(strings.Contains(string(memStore.files[backupFile]), "Blocks"), check.Equals, false)
+	backup, err := loadBackup(backupName, testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup.Blocks, check.HasLen, 2)
+
+	volFile := filepath.Join(c.MkDir(), "volume")
+	c.Assert(RestoreDeltaBlockBackup(backupURL, "", volFile), check.IsNil)
+	data, err := ioutil.ReadFile(volFile)
+	c.Assert(err, check.IsNil)
+	c.Assert(data[DEFAULT_BLOCK_SIZE], check.Equals, byte(2))
+
+	c.Assert(SetEncryptionKeys([]string{wrongKey}), check.IsNil)
+	err = RestoreDeltaBlockBackup(backupURL, "", volFile)
+	c.Assert(err, check.ErrorMatches, "Objectstore is encrypted by key "+config.EncryptionKeyID+", which hasn't been loaded.*")
+
+	// Any of the loaded keys can be used to access the objectstore
+	c.Assert(SetEncryptionKeys([]string{wrongKey, rightKey}), check.IsNil)
+	c.Assert(RestoreDeltaBlockBackup(backupURL, "", volFile), check.IsNil)
+
+	// Blocks cannot be swapped
+	blkFile0 := getBlockFilePath(testVolumeName, backup.Blocks[0])
+	blkFile1 := getBlockFilePath(testVolumeName, backup.Blocks[1])
+	memStore.files[blkFile0], memStore.files[blkFile1] = memStore.files[blkFile1], memStore.files[blkFile0]
+	err = RestoreDeltaBlockBackup(backupURL, "", volFile)
+	c.Assert(err, check.ErrorMatches, "Failed to decrypt .*")
+
+	_, err = CreateSingleFileBackup(volume, &Snapshot{Name: "snapshot"}, volFile, MEM_URL, "")
+	c.Assert(err, check.ErrorMatches, "Single file backup cannot be created in encrypted objectstore")
+}
+
+func (s *TestSuite) TestEncryptionOfConfiguredObjectStore(c *check.C) {
+	defer SetEncryptionKeys(nil)
+
+	// Objectstores have backups before the key is loaded stay unencrypted
+	s.createTestBackupChain(c)
+	c.Assert(SetEncryptionKeys([]string{writeKeyFile(c, "passphrase")}), check.IsNil)
+	config, err := initObjectStoreConfig(memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(config.EncryptionKeyID, check.Equals, "")
+
+	backup, err := loadBackup("backup-3", testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup.ParentBackupName, check.Equals, "backup-2")
+}
+
+func (s *TestSuite) TestRotateEncryptionKey(c *check.C) {
+	defer SetEncryptionKeys(nil)
+
+	oldKey := writeKeyFile(c, "old passphrase")
+	newKey := writeKeyFile(c, "new passphrase")
+	c.Assert(SetEncryptionKeys([]string{oldKey, newKey}), check.IsNil)
+
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: 0, Size: 2 * DEFAULT_BLOCK_SIZE},
+		},
+	}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	config, err := loadObjectStoreConfig(memStore)
+	c.Assert(err, check.IsNil)
+	oldKeyID := config.EncryptionKeyID
+	backup, err := loadBackup(decodeTestBackupName(c, backupURL), testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup.Blocks[0].EncryptionKeyID, check.Equals, oldKeyID)
+	c.Assert(backup.Blocks[1].EncryptionKeyID, check.Equals, oldKeyID)
+
+	// The block written without header by the older versions is encrypted
+	// by the original key
+	blkFile0 := getBlockFilePath(testVolumeName, backup.Blocks[0])
+	blkFile1 := getBlockFilePath(testVolumeName, backup.Blocks[1])
+	c.Assert(string(memStore.files[blkFile0][:len(ENCRYPTION_HEADER_MAGIC)]), check.Equals, ENCRYPTION_HEADER_MAGIC)
+	memStore.files[blkFile0] = memStore.files[blkFile0][ENCRYPTION_HEADER_SIZE:]
+	volFile := filepath.Join(c.MkDir(), "volume")
+	c.Assert(RestoreDeltaBlockBackup(backupURL, "", volFile), check.IsNil)
+
+	_, err = RotateEncryptionKey(MEM_URL, "", filepath.Join(c.MkDir(), "missing"))
+	c.Assert(err, check.ErrorMatches, "Encryption key .* hasn't been loaded by --backup-keys")
+
+	// The old key is kept if any object failed to be re-encrypted
+	block1 := memStore.files[blkFile1]
+	memStore.files[blkFile1] = []byte("corrupted")
+	report, err := RotateEncryptionKey(MEM_URL, "", newKey)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Complete, check.Equals, false)
+	c.Assert(report.FailedObjects, check.HasLen, 1)
+	c.Assert(report.FailedObjects[0].File, check.Equals, blkFile1)
+	c.Assert(report.KeyID, check.Not(check.Equals), oldKeyID)
+	config, err = loadObjectStoreConfig(memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(config.EncryptionKeyID, check.Equals, report.KeyID)
+	c.Assert(config.EncryptionKeyIDs, check.DeepEquals, []string{oldKeyID, report.KeyID})
+	reencrypted := report.ReencryptedObjects
+	c.Assert(reencrypted >= 2, check.Equals, true)
+	backup, err = loadBackup(backup.Name, testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup.Blocks[0].EncryptionKeyID, check.Equals, report.KeyID)
+	c.Assert(backup.Blocks[1].EncryptionKeyID, check.Equals, oldKeyID)
+
+	// The rotation can be resumed, and the old key is retired at last
+	memStore.files[blkFile1] = block1
+	report, err = RotateEncryptionKey(MEM_URL, "", newKey)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Complete, check.Equals, true)
+	c.Assert(report.ReencryptedObjects, check.Equals, 1)
+	c.Assert(report.RetiredKeyIDs, check.DeepEquals, []string{oldKeyID})
+	config, err = loadObjectStoreConfig(memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(config.EncryptionKeyID, check.Equals, report.KeyID)
+	c.Assert(config.EncryptionKeyIDs, check.HasLen, 0)
+	backup, err = loadBackup(backup.Name, testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup.Blocks[1].EncryptionKeyID, check.Equals, report.KeyID)
+
+	c.Assert(SetEncryptionKeys([]string{newKey}), check.IsNil)
+	c.Assert(RestoreDeltaBlockBackup(backupURL, "", volFile), check.IsNil)
+	data, err := ioutil.ReadFile(volFile)
+	c.Assert(err, check.IsNil)
+	c.Assert(data[DEFAULT_BLOCK_SIZE], check.Equals, byte(2))
+
+	c.Assert(SetEncryptionKeys([]string{oldKey}), check.IsNil)
+	err = RestoreDeltaBlockBackup(backupURL, "", volFile)
+	c.Assert(err, check.ErrorMatches, "Objectstore is encrypted by key "+report.KeyID+", which hasn't been loaded.*")
+}
@@ -0,0 +1,249 @@
+package objectstore
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+type blockEstimate struct {
+	new  bool
+	size int64
+}
+
+/*
+EstimateDeltaBlockBackup runs the comparison of the snapshot as
+CreateDeltaBlockBackup does, and checks which of the changed blocks already
+exist in the objectstore, but writes nothing to the objectstore. It reports
+the number and the size of the changed blocks, and of the new blocks would be
+transferred by the backup, after they're compressed and encrypted as the
+objectstore configured, so the time of the backup can be estimated.
+*/
+func EstimateDeltaBlockBackup(volume *Volume, snapshot *Snapshot, destURL, endpoint string, deltaOps DeltaBlockBackupOperations) (map[string]string, error) {
+	if deltaOps == nil {
+		return nil, fmt.Errorf("Missing DeltaBlockBackupOperations")
+	}
+	deltaOps = throttleSnapshotReads(deltaOps)
+
+	bsDriver, err := GetObjectStoreDriver(destURL, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	config, err := loadObjectStoreConfig(bsDriver)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := config.getCipher()
+	if err != nil {
+		return nil, err
+	}
+	// The volume would be compared with its last backup in objectstore
+	if volumeExists(volume.Name, bsDriver) {
+		objVolume, err := loadVolume(volume.Name, bsDriver)
+		if err != nil {
+			return nil, err
+		}
+		volume.LastBackupName = objVolume.LastBackupName
+	}
+
+	if err := deltaOps.OpenSnapshot(snapshot.Name, volume.Name); err != nil {
+		return nil, err
+	}
+	defer deltaOps.CloseSnapshot(snapshot.Name, volume.Name)
+
+	lastBackup, lastSnapshotName, err := getLastBackup(volume, snapshot, config, deltaOps, bsDriver)
+	if err != nil {
+		return nil, err
+	}
+	delta, err := compareSnapshot(snapshot.Name, lastSnapshotName, volume.Name, config, deltaOps)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_BACKUP,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: snapshot.Name,
+	}).Debug("Estimating backup")
+
+	total := 0
+	for _, d := range delta.Mappings {
+		if d.Size%delta.BlockSize != 0 {
+			return nil, fmt.Errorf("Mapping's size %v is not multiples of backup block size %v",
+				d.Size, delta.BlockSize)
+		}
+		total += int(d.Size / delta.BlockSize)
+	}
+	// The chunks of the last backup overlapping the changed blocks would be
+	// chunked again as well
+	cdc := config.getChunking() == CHUNKING_CDC
+	var ranges []chunkRange
+	if cdc {
+		var lastBlocks []BlockMapping
+		if lastBackup != nil {
+			lastBlocks = lastBackup.Blocks
+		}
+		ranges = getChunkRanges(delta, lastBlocks, delta.BlockSize, volume.Size)
+		total = getChunkRangesBlocks(ranges, delta.BlockSize)
+	}
+	progress := startProgress(BackupProgressKey(volume.Name, snapshot.Name), PROGRESS_BACKUP, total)
+	defer progress.finish()
+
+	workers := backupWorkers
+	if workers > total {
+		workers = total
+	}
+	buffers := make(chan []byte, workers*2)
+	if !cdc {
+		for i := 0; i < cap(buffers); i++ {
+			buffers <- make([]byte, delta.BlockSize)
+		}
+	}
+	jobs := make(chan blockJob)
+	var (
+		changedBlocks int
+		changedSize   int64
+		newBlocks     int
+		newSize       int64
+		estimateErr   error
+		mutex         sync.Mutex
+		wg            sync.WaitGroup
+	)
+	fail := func(err error) {
+		mutex.Lock()
+		if estimateErr == nil {
+			estimateErr = err
+		}
+		mutex.Unlock()
+	}
+	failed := func() error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return estimateErr
+	}
+	// Blocks have the same content would be transferred only once
+	seen := make(map[string]bool)
+	hash := config.getHash()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				est, err := estimateBlock(volume.Name, job, hash, config, aead, seen, &mutex, bsDriver)
+				if err != nil {
+					fail(err)
+				}
+				mutex.Lock()
+				changedBlocks++
+				changedSize += int64(len(job.data))
+				if est.new {
+					newBlocks++
+					newSize += est.size
+				}
+				mutex.Unlock()
+				if !cdc {
+					progress.add(1, 0)
+					buffers <- job.data[:cap(job.data)]
+				}
+			}
+		}()
+	}
+
+	if cdc {
+		processed, reported := int64(0), 0
+		err = readChunks(ranges, delta.BlockSize, snapshot.Name, volume.Name, deltaOps, func(offset int64, data []byte) error {
+			if err := failed(); err != nil {
+				return err
+			}
+			jobs <- blockJob{offset: offset, data: append([]byte{}, data...)}
+			processed += int64(len(data))
+			if n := int(processed / delta.BlockSize); n > reported {
+				progress.add(n-reported, 0)
+				reported = n
+			}
+			return nil
+		})
+		if err != nil {
+			fail(err)
+		}
+	} else {
+	read:
+		for _, d := range delta.Mappings {
+			for i := int64(0); i < d.Size/delta.BlockSize; i++ {
+				offset := d.Offset + i*delta.BlockSize
+				block := <-buffers
+				if failed() != nil {
+					break read
+				}
+				if volume.Size > 0 && offset+delta.BlockSize > volume.Size {
+					block = block[:volume.Size-offset]
+				}
+				if err := deltaOps.ReadSnapshot(snapshot.Name, volume.Name, offset, block); err != nil {
+					fail(err)
+					break read
+				}
+				jobs <- blockJob{offset: offset, data: block}
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if estimateErr != nil {
+		return nil, estimateErr
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:    LOG_EVENT_BACKUP,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: snapshot.Name,
+	}).Debugf("Estimated backup with %v new blocks of %v changed blocks", newBlocks, changedBlocks)
+
+	return map[string]string{
+		"VolumeName":       volume.Name,
+		"SnapshotName":     snapshot.Name,
+		"LastSnapshotName": lastSnapshotName,
+		"ChangedBlocks":    strconv.Itoa(changedBlocks),
+		"ChangedSize":      strconv.FormatInt(changedSize, 10),
+		"NewBlocks":        strconv.Itoa(newBlocks),
+		"NewSize":          strconv.FormatInt(newSize, 10),
+	}, nil
+}
+
+// estimateBlock checks if the block would be written by the backup, and the
+// size it would take in the objectstore
+func estimateBlock(volumeName string, job blockJob, hash string, config *ObjectStoreConfig, aead *objectCipher,
+	seen map[string]bool, mutex *sync.Mutex, bsDriver ObjectStoreDriver) (blockEstimate, error) {
+	if isZeroData(job.data) {
+		return blockEstimate{}, nil
+	}
+	checksum := getChecksum(hash, job.data)
+	mutex.Lock()
+	skip := seen[checksum]
+	seen[checksum] = true
+	mutex.Unlock()
+	if skip {
+		return blockEstimate{}, nil
+	}
+
+	mapping := BlockMapping{
+		Offset:        job.offset,
+		BlockChecksum: checksum,
+		Compression:   config.Compression,
+		Shared:        config.SharedBlocks,
+	}
+	blkFile := getBlockFilePath(volumeName, mapping)
+	if size := bsDriver.FileSize(blkFile); size >= 0 {
+		return blockEstimate{size: size}, nil
+	}
+	_, size, err := encodeBlock(blkFile, mapping.Compression, job.data, aead)
+	if err != nil {
+		return blockEstimate{}, err
+	}
+	return blockEstimate{new: true, size: size}, nil
+}
@@ -0,0 +1,55 @@
+package objectstore
+
+import (
+	"strconv"
+
+	"github.com/rancher/convoy/metadata"
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestEstimateDeltaBlockBackup(c *check.C) {
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: 0, Size: 4 * DEFAULT_BLOCK_SIZE},
+		},
+	}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+		Size:   4 * DEFAULT_BLOCK_SIZE,
+	}
+	snapshot := &Snapshot{
+		Name: "snapshot",
+	}
+
+	// Nothing would be written
+	estimate, err := EstimateDeltaBlockBackup(volume, snapshot, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	c.Assert(memStore.files, check.HasLen, 0)
+	c.Assert(estimate["ChangedBlocks"], check.Equals, "4")
+	c.Assert(estimate["ChangedSize"], check.Equals, strconv.Itoa(4*DEFAULT_BLOCK_SIZE))
+	c.Assert(estimate["NewBlocks"], check.Equals, "3")
+
+	backupURL, err := CreateDeltaBlockBackup(volume, snapshot, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	info, err := GetBackupInfo(backupURL, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(estimate["NewSize"], check.Equals, info["CompressedSize"])
+
+	// The blocks already in objectstore would not be transferred
+	deltaOps.mappings = []metadata.Mapping{
+		{Offset: 0, Size: 6 * DEFAULT_BLOCK_SIZE},
+	}
+	volume.Size = 6 * DEFAULT_BLOCK_SIZE
+	files := len(memStore.files)
+	estimate, err = EstimateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-2"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	c.Assert(memStore.files, check.HasLen, files)
+	c.Assert(estimate["ChangedBlocks"], check.Equals, "6")
+	c.Assert(estimate["NewBlocks"], check.Equals, "0")
+	c.Assert(estimate["NewSize"], check.Equals, "0")
+
+	deltaOps.failAt = 2 * DEFAULT_BLOCK_SIZE
+	_, err = EstimateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-2"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.ErrorMatches, "Failed to read at .*")
+}
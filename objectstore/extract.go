@@ -0,0 +1,190 @@
+package objectstore
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+// cleanArchivePath returns the path relative to the root of the backup, e.g.
+// "data/a.txt" for both "/data/a.txt" and "./data/a.txt", or empty for the
+// root itself
+func cleanArchivePath(path string) string {
+	path = filepath.Clean("/" + path)
+	return strings.TrimPrefix(path, "/")
+}
+
+/*
+ExtractSingleFileBackup extracts the file or the directory at path inside the
+single file backup to targetPath on the host, without restoring the whole
+volume. The path is relative to the root of the volume, and "/" would
+extract the whole backup. The targetPath must not exist, and would be
+removed if the extraction failed.
+*/
+func ExtractSingleFileBackup(backupURL, endpoint, path, targetPath string) error {
+	driver, err := GetObjectStoreDriver(backupURL, endpoint)
+	if err != nil {
+		return err
+	}
+	backupName, volumeName, err := decodeBackupURL(backupURL)
+	if err != nil {
+		return err
+	}
+	backup, err := loadBackup(backupName, volumeName, driver)
+	if err != nil {
+		return err
+	}
+	if backup.SingleFile.FilePath == "" {
+		return fmt.Errorf("Cannot extract files from backup %v, which is not a single file backup", backupName)
+	}
+	if _, err := os.Lstat(targetPath); err == nil {
+		return fmt.Errorf("Target %v already exists", targetPath)
+	}
+
+	tmpFile, err := ioutil.TempFile("", "convoy-extract-")
+	if err != nil {
+		return err
+	}
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:     LOG_REASON_START,
+		LOG_FIELD_EVENT:      LOG_EVENT_EXTRACT,
+		LOG_FIELD_BACKUP_URL: backupURL,
+		LOG_FIELD_FILEPATH:   targetPath,
+	}).Debugf("Extracting %v from backup", path)
+	if err := driver.Download(backup.SingleFile.FilePath, tmpFile.Name()); err != nil {
+		return err
+	}
+
+	f, err := os.Open(tmpFile.Name())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	count, err := extractArchivePath(f, cleanArchivePath(path), targetPath)
+	if err != nil {
+		os.RemoveAll(targetPath)
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("Cannot find %v in backup %v", path, backupName)
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:     LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:      LOG_EVENT_EXTRACT,
+		LOG_FIELD_BACKUP_URL: backupURL,
+		LOG_FIELD_FILEPATH:   targetPath,
+	}).Debugf("Extracted %v entries of %v from backup", count, path)
+	return nil
+}
+
+// extractArchivePath extracts the entries under path of the gzipped tarball
+// to targetPath, and returns the number of entries extracted
+func extractArchivePath(r io.Reader, path, targetPath string) (int, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+
+	count := 0
+	// The entries under the symlinks extracted would be skipped, so nothing
+	// would be written outside of targetPath through them
+	symlinks := []string{}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+		name := cleanArchivePath(hdr.Name)
+		var rel string
+		switch {
+		case name == path:
+			rel = ""
+		case path == "":
+			rel = name
+		case strings.HasPrefix(name, path+"/"):
+			rel = strings.TrimPrefix(name, path+"/")
+		default:
+			continue
+		}
+		if underSymlink(rel, symlinks) {
+			log.Warnf("Skipped %v under symlink", hdr.Name)
+			continue
+		}
+		if hdr.Typeflag == tar.TypeSymlink {
+			symlinks = append(symlinks, rel)
+		}
+		dst := filepath.Join(targetPath, rel)
+		if err := extractArchiveEntry(tr, hdr, dst); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+func underSymlink(rel string, symlinks []string) bool {
+	for _, link := range symlinks {
+		if link == "" || strings.HasPrefix(rel, link+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func extractArchiveEntry(tr *tar.Reader, hdr *tar.Header, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	mode := os.FileMode(hdr.Mode).Perm()
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(dst, mode); err != nil {
+			return err
+		}
+		if err := os.Chmod(dst, mode); err != nil {
+			return err
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, dst); err != nil {
+			return err
+		}
+		return nil
+	default:
+		log.Warnf("Skipped %v of unsupported type %v", hdr.Name, string(hdr.Typeflag))
+		return nil
+	}
+	if err := os.Lchown(dst, hdr.Uid, hdr.Gid); err != nil {
+		log.Debugf("Cannot change owner of %v: %v", dst, err)
+	}
+	return os.Chtimes(dst, hdr.ModTime, hdr.ModTime)
+}
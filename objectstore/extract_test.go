@@ -0,0 +1,95 @@
+package objectstore
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/rancher/convoy/util"
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestExtractSingleFileBackup(c *check.C) {
+	dir := c.MkDir()
+	volumeDir := filepath.Join(dir, "volume")
+	c.Assert(os.MkdirAll(filepath.Join(volumeDir, "data", "sub"), 0755), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(volumeDir, "data", "a.txt"), []byte("a"), 0640), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(volumeDir, "data", "sub", "b.txt"), []byte("b"), 0600), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(volumeDir, "c.txt"), []byte("c"), 0600), check.IsNil)
+	c.Assert(os.Symlink("/etc", filepath.Join(volumeDir, "link")), check.IsNil)
+	backupFile := filepath.Join(dir, "volume.bak")
+	c.Assert(util.CompressDir(volumeDir, backupFile), check.IsNil)
+
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "vfs",
+	}
+	backupURL, err := CreateSingleFileBackup(volume, &Snapshot{Name: "snapshot"}, backupFile, MEM_URL, "")
+	c.Assert(err, check.IsNil)
+
+	// A single file
+	target := filepath.Join(dir, "a.txt")
+	c.Assert(ExtractSingleFileBackup(backupURL, "", "/data/a.txt", target), check.IsNil)
+	data, err := ioutil.ReadFile(target)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, "a")
+	st, err := os.Stat(target)
+	c.Assert(err, check.IsNil)
+	c.Assert(st.Mode().Perm(), check.Equals, os.FileMode(0640))
+
+	// A directory, without the files outside of it
+	target = filepath.Join(dir, "data")
+	c.Assert(ExtractSingleFileBackup(backupURL, "", "data", target), check.IsNil)
+	data, err = ioutil.ReadFile(filepath.Join(target, "sub", "b.txt"))
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, "b")
+	_, err = os.Stat(filepath.Join(target, "c.txt"))
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+
+	// The whole backup
+	target = filepath.Join(dir, "all")
+	c.Assert(ExtractSingleFileBackup(backupURL, "", "/", target), check.IsNil)
+	link, err := os.Readlink(filepath.Join(target, "link"))
+	c.Assert(err, check.IsNil)
+	c.Assert(link, check.Equals, "/etc")
+
+	err = ExtractSingleFileBackup(backupURL, "", "data", target)
+	c.Assert(err, check.ErrorMatches, "Target .* already exists")
+	target = filepath.Join(dir, "missing")
+	err = ExtractSingleFileBackup(backupURL, "", "/data/missing.txt", target)
+	c.Assert(err, check.ErrorMatches, "Cannot find /data/missing.txt in backup .*")
+	_, err = os.Stat(target)
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+
+	s.createTestBackupChain(c)
+	err = ExtractSingleFileBackup(encodeBackupURL("backup-1", testVolumeName, MEM_URL), "", "/", target)
+	c.Assert(err, check.ErrorMatches, "Cannot extract files from backup backup-1, which is not a single file backup")
+}
+
+func (s *TestSuite) TestExtractArchivePathUnderSymlink(c *check.C) {
+	dir := c.MkDir()
+	outside := filepath.Join(dir, "outside")
+	c.Assert(os.Mkdir(outside, 0755), check.IsNil)
+
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(zw)
+	c.Assert(tw.WriteHeader(&tar.Header{Name: "./data/", Typeflag: tar.TypeDir, Mode: 0755}), check.IsNil)
+	c.Assert(tw.WriteHeader(&tar.Header{Name: "./data/link", Typeflag: tar.TypeSymlink, Linkname: outside}), check.IsNil)
+	c.Assert(writeTarFile(tw, "./data/link/evil", []byte("evil")), check.IsNil)
+	c.Assert(writeTarFile(tw, "./data/../../evil", []byte("evil")), check.IsNil)
+	c.Assert(tw.Close(), check.IsNil)
+	c.Assert(zw.Close(), check.IsNil)
+
+	target := filepath.Join(dir, "target")
+	count, err := extractArchivePath(buf, "", target)
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 3)
+	_, err = os.Stat(filepath.Join(outside, "evil"))
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+	_, err = os.Stat(filepath.Join(target, "evil"))
+	c.Assert(err, check.IsNil)
+}
@@ -0,0 +1,191 @@
+package objectstore
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+// GCReport is the result of the garbage collection of an objectstore
+type GCReport struct {
+	DestURL string
+	DryRun  bool
+
+	Volumes          []string
+	TotalBlocks      int
+	ReferencedBlocks int
+	UnusedBlocks     int
+	// The unused blocks which cannot be removed until their retention
+	// expires, see ObjectStoreRetainer
+	RetainedBlocks int
+	ReclaimedBytes int64
+}
+
+// listBlockFiles returns the paths of all the block files under blockPath
+func listBlockFiles(blockPath string, driver ObjectStoreDriver) ([]string, error) {
+	result := []string{}
+	lv1Dirs, err := driver.List(blockPath)
+	// Directory doesn't exist
+	if err != nil {
+		return result, nil
+	}
+	for _, lv1 := range lv1Dirs {
+		lv1Path := filepath.Join(blockPath, lv1)
+		lv2Dirs, err := driver.List(lv1Path)
+		if err != nil {
+			return nil, err
+		}
+		for _, lv2 := range lv2Dirs {
+			lv2Path := filepath.Join(lv1Path, lv2)
+			files, err := driver.List(lv2Path)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range files {
+				if strings.HasSuffix(f, BLOCK_FILE_SUFFIX) {
+					result = append(result, filepath.Join(lv2Path, f))
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+func gcVolume(volumeName string, driver ObjectStoreDriver, dryRun bool, report *GCReport) error {
+	unlock, err := lockVolume(volumeName, driver)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	blkFiles, err := listBlockFiles(getBlockPath(volumeName), driver)
+	if err != nil {
+		return err
+	}
+	backupNames, err := getBackupNamesForVolume(volumeName, driver)
+	if err != nil {
+		return err
+	}
+	referenced := map[string]bool{}
+	index := newBlockIndex(volumeName)
+	for _, backupName := range backupNames {
+		backup, err := loadBackup(backupName, volumeName, driver)
+		if err != nil {
+			return err
+		}
+		for _, blk := range getStoredBlocks(backup.Blocks) {
+			referenced[getBlockFilePath(volumeName, blk)] = true
+		}
+		index.add(backup)
+	}
+	// The blocks of interrupted backups would be used once they're resumed
+	checkpointBlkFiles, err := getCheckpointBlockFiles(volumeName, driver)
+	if err != nil {
+		return err
+	}
+	for blkFile := range checkpointBlkFiles {
+		referenced[blkFile] = true
+	}
+
+	orphans := []string{}
+	for _, blkFile := range blkFiles {
+		if referenced[blkFile] {
+			report.ReferencedBlocks++
+			continue
+		}
+		orphans = append(orphans, blkFile)
+		log.Debugf("Found unused block %v for volume %v", blkFile, volumeName)
+	}
+	report.TotalBlocks += len(blkFiles)
+	report.UnusedBlocks += len(orphans)
+	orphans, retained, err := filterRetainedFiles(orphans, driver)
+	if err != nil {
+		return err
+	}
+	report.RetainedBlocks += retained
+	for _, blkFile := range orphans {
+		if size := driver.FileSize(blkFile); size > 0 {
+			report.ReclaimedBytes += size
+		}
+	}
+	if dryRun {
+		return nil
+	}
+	// The reference index is rebuilt anyway, in case it's been corrupted
+	if len(backupNames) != 0 {
+		if err := saveBlockIndex(index, driver); err != nil {
+			return err
+		}
+	}
+	if len(orphans) == 0 {
+		return nil
+	}
+
+	if len(backupNames) == 0 && len(checkpointBlkFiles) == 0 && retained == 0 && volumeExists(volumeName, driver) {
+		// Left behind by the failed first backup of the volume
+		log.Debugf("No backup existed for the volume %v, removing volume", volumeName)
+		return removeVolume(volumeName, driver)
+	}
+	if err := driver.Remove(orphans...); err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_COMPLETE,
+		LOG_FIELD_VOLUME: volumeName,
+	}).Debugf("Removed %v unused blocks", len(orphans))
+	return nil
+}
+
+/*
+GarbageCollect removes the block files which are not referenced by any backup
+of the volume, e.g. the ones left behind by failed backups. All the volumes in
+the objectstore would be collected if volumeName is empty. Nothing would be
+removed if dryRun is true, but the report is still generated.
+*/
+func GarbageCollect(destURL, endpoint, volumeName string, dryRun bool) (*GCReport, error) {
+	driver, err := GetObjectStoreDriver(destURL, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if !dryRun {
+		if err := checkWritable(driver); err != nil {
+			return nil, err
+		}
+	}
+
+	volumeNames := []string{volumeName}
+	if volumeName == "" {
+		if volumeNames, err = getVolumeNames(driver); err != nil {
+			return nil, err
+		}
+	} else if !volumeExists(volumeName, driver) {
+		return nil, fmt.Errorf("Cannot find volume %v in objectstore", volumeName)
+	}
+
+	report := &GCReport{
+		DestURL: driver.GetURL(),
+		DryRun:  dryRun,
+		Volumes: volumeNames,
+	}
+	for _, name := range volumeNames {
+		if err := gcVolume(name, driver, dryRun, report); err != nil {
+			return nil, err
+		}
+	}
+	// The shared blocks can only be collected with all the volumes
+	if volumeName == "" {
+		if err := gcSharedBlocks(driver, dryRun, report); err != nil {
+			return nil, err
+		}
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_COMPLETE,
+		LOG_FIELD_KIND:   driver.Kind(),
+	}).Debugf("Garbage collected objectstore %v, %v of %v blocks unused, %v bytes reclaimed",
+		report.DestURL, report.UnusedBlocks, report.TotalBlocks, report.ReclaimedBytes)
+	return report, nil
+}
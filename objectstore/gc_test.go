@@ -0,0 +1,50 @@
+package objectstore
+
+import (
+	"bytes"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestGarbageCollect(c *check.C) {
+	s.createTestBackupChain(c)
+	// Left behind by failed backup
+	for _, checksum := range []string{"cccc1111", "cccc2222"} {
+		err := memStore.Write(testBlockFile(checksum), bytes.NewReader([]byte(checksum)))
+		c.Assert(err, check.IsNil)
+	}
+
+	report, err := GarbageCollect(MEM_URL, "", "", true)
+	c.Assert(err, check.IsNil)
+	c.Check(report.Volumes, check.DeepEquals, []string{testVolumeName})
+	c.Check(report.TotalBlocks, check.Equals, 6)
+	c.Check(report.ReferencedBlocks, check.Equals, 4)
+	c.Check(report.UnusedBlocks, check.Equals, 2)
+	c.Check(report.ReclaimedBytes, check.Equals, int64(16))
+	c.Check(memStore.FileExists(testBlockFile("cccc1111")), check.Equals, true)
+
+	report, err = GarbageCollect(MEM_URL, "", testVolumeName, false)
+	c.Assert(err, check.IsNil)
+	c.Check(report.UnusedBlocks, check.Equals, 2)
+	c.Check(memStore.FileExists(testBlockFile("cccc1111")), check.Equals, false)
+	c.Check(memStore.FileExists(testBlockFile("cccc2222")), check.Equals, false)
+	c.Check(memStore.FileExists(testBlockFile("aaaa1111")), check.Equals, true)
+	c.Check(memStore.FileExists(testBlockFile("bbbb2222")), check.Equals, true)
+
+	report, err = GarbageCollect(MEM_URL, "", "", false)
+	c.Assert(err, check.IsNil)
+	c.Check(report.UnusedBlocks, check.Equals, 0)
+
+	_, err = GarbageCollect(MEM_URL, "", "non-existent", false)
+	c.Assert(err, check.ErrorMatches, "Cannot find volume non-existent in objectstore")
+}
+
+func (s *TestSuite) TestGarbageCollectVolumeWithoutBackup(c *check.C) {
+	s.createTestBackup(c, "backup-1", "", "aaaa1111")
+	c.Assert(removeBackup(&Backup{Name: "backup-1", VolumeName: testVolumeName}, memStore), check.IsNil)
+
+	report, err := GarbageCollect(MEM_URL, "", "", false)
+	c.Assert(err, check.IsNil)
+	c.Check(report.UnusedBlocks, check.Equals, 1)
+	c.Check(volumeExists(testVolumeName, memStore), check.Equals, false)
+}
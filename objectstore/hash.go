@@ -0,0 +1,76 @@
+package objectstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	// SHA-512 truncated to 256 bits, used by the objectstores created before
+	// the hash was configurable
+	HASH_SHA512  = "sha512"
+	HASH_SHA256  = "sha256"
+	HASH_BLAKE2B = "blake2b"
+)
+
+var (
+	defaultHash = HASH_SHA512
+)
+
+// ValidateHash checks the hash algorithm for block addressing is supported
+func ValidateHash(hash string) error {
+	switch hash {
+	case HASH_SHA512, HASH_SHA256, HASH_BLAKE2B:
+		return nil
+	}
+	return fmt.Errorf("Unsupported hash %v, should be one of %v, %v and %v",
+		hash, HASH_SHA512, HASH_SHA256, HASH_BLAKE2B)
+}
+
+// SetDefaultHash sets the hash algorithm used by the objectstores which
+// haven't been configured, e.g. the new ones
+func SetDefaultHash(hash string) error {
+	if err := ValidateHash(hash); err != nil {
+		return err
+	}
+	defaultHash = hash
+	return nil
+}
+
+// getChecksum returns the checksum of the block, which is used as the
+// address of the block in objectstore. All the hashes produce the checksums
+// of the same length.
+func getChecksum(hash string, data []byte) string {
+	switch hash {
+	case HASH_SHA256:
+		sum := sha256.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	case HASH_BLAKE2B:
+		sum := blake2b.Sum256(data)
+		return hex.EncodeToString(sum[:])
+	}
+	return util.GetChecksum(data)
+}
+
+// getHash returns the hash algorithm of the objectstore. The objectstores
+// configured before the hash was configurable use HASH_SHA512.
+func (config *ObjectStoreConfig) getHash() string {
+	if config.Hash == "" {
+		return HASH_SHA512
+	}
+	return config.Hash
+}
+
+// getHash returns the hash algorithm of the blocks of the backup. The
+// backups created before the hash was recorded use HASH_SHA512.
+func (backup *Backup) getHash() string {
+	if backup.Hash == "" {
+		return HASH_SHA512
+	}
+	return backup.Hash
+}
@@ -0,0 +1,82 @@
+package objectstore
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/rancher/convoy/metadata"
+	"github.com/rancher/convoy/util"
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestChecksum(c *check.C) {
+	data := []byte("convoy")
+	c.Assert(getChecksum(HASH_SHA512, data), check.Equals, util.GetChecksum(data))
+	checksums := map[string]bool{}
+	for _, hash := range []string{HASH_SHA512, HASH_SHA256, HASH_BLAKE2B} {
+		c.Assert(ValidateHash(hash), check.IsNil)
+		checksum := getChecksum(hash, data)
+		c.Assert(checksum, check.HasLen, util.PRESERVED_CHECKSUM_LENGTH)
+		checksums[checksum] = true
+	}
+	c.Assert(checksums, check.HasLen, 3)
+	c.Assert(SetDefaultHash("md5"), check.ErrorMatches, "Unsupported hash md5.*")
+}
+
+func (s *TestSuite) TestBackupHash(c *check.C) {
+	defer SetDefaultHash(HASH_SHA512)
+	c.Assert(SetDefaultHash(HASH_BLAKE2B), check.IsNil)
+
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: 0, Size: 2 * DEFAULT_BLOCK_SIZE},
+		},
+	}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL1, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-1"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	config, err := loadObjectStoreConfig(memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(config.Hash, check.Equals, HASH_BLAKE2B)
+	backupName1, _, err := decodeBackupURL(backupURL1)
+	c.Assert(err, check.IsNil)
+	backup1, err := loadBackup(backupName1, testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup1.Hash, check.Equals, HASH_BLAKE2B)
+
+	// Blocks addressed by different hashes cannot be merged
+	config.Hash = HASH_SHA256
+	c.Assert(saveConfigInObjectStore(getObjectStoreConfigPath(), memStore, config), check.IsNil)
+	backupURL2, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-2"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	backupName2, _, err := decodeBackupURL(backupURL2)
+	c.Assert(err, check.IsNil)
+	backup2, err := loadBackup(backupName2, testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup2.ParentBackupName, check.Equals, "")
+	c.Assert(backup2.Hash, check.Equals, HASH_SHA256)
+	c.Assert(backup2.Blocks[0].BlockChecksum, check.Not(check.Equals), backup1.Blocks[0].BlockChecksum)
+
+	// Both can be restored by the hash recorded
+	for _, backupURL := range []string{backupURL1, backupURL2} {
+		volFile := filepath.Join(c.MkDir(), "volume")
+		c.Assert(RestoreDeltaBlockBackup(backupURL, "", volFile), check.IsNil)
+		data, err := ioutil.ReadFile(volFile)
+		c.Assert(err, check.IsNil)
+		c.Assert(data, check.HasLen, 2*DEFAULT_BLOCK_SIZE)
+	}
+
+	backup1.Hash = "md5"
+	c.Assert(saveBackup(backup1, memStore), check.IsNil)
+	err = RestoreDeltaBlockBackup(backupURL1, "", filepath.Join(c.MkDir(), "volume"))
+	c.Assert(err, check.ErrorMatches, "Unsupported hash md5.*")
+
+	config.Hash = "md5"
+	c.Assert(saveConfigInObjectStore(getObjectStoreConfigPath(), memStore, config), check.IsNil)
+	_, err = CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-3"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.ErrorMatches, "Invalid config .*: Unsupported hash md5.*")
+}
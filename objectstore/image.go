@@ -0,0 +1,533 @@
+package objectstore
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	IMAGE_FORMAT_RAW   = "raw"
+	IMAGE_FORMAT_QCOW2 = "qcow2"
+
+	IMAGE_DIRECTORY = "images"
+
+	qcow2Magic       = 0x514649fb
+	qcow2Version     = 2
+	qcow2HeaderSize  = 72
+	qcow2ClusterBits = 16
+	qcow2ClusterSize = 1 << qcow2ClusterBits
+	// Entries of 64-bit in a L2 table, and of 16-bit in a refcount block
+	qcow2L2Entries       = qcow2ClusterSize / 8
+	qcow2RefcountEntries = qcow2ClusterSize / 2
+	qcow2OflagCopied     = uint64(1) << 63
+)
+
+/*
+BackupImage is the snapshot exported as a single image file along with the
+block-based backup, which can be used by the tools outside Convoy, e.g.
+imported into a hypervisor, without restoring. The image is not compressed
+or encrypted.
+*/
+type BackupImage struct {
+	Format   string
+	FilePath string
+	Size     int64
+}
+
+// ValidateImageFormat checks the snapshot can be exported in the format
+func ValidateImageFormat(format string) error {
+	if format != IMAGE_FORMAT_RAW && format != IMAGE_FORMAT_QCOW2 {
+		return fmt.Errorf("Unsupported image format %v, should be %v or %v", format, IMAGE_FORMAT_RAW, IMAGE_FORMAT_QCOW2)
+	}
+	return nil
+}
+
+func getImagePath(volumeName string) string {
+	return filepath.Join(getVolumePath(volumeName), IMAGE_DIRECTORY) + "/"
+}
+
+func getImageFilePath(backupName, volumeName, format string) string {
+	return filepath.Join(getImagePath(volumeName), backupName+"."+format)
+}
+
+// checkImageExport checks the snapshot of the volume can be exported to the
+// objectstore, before anything is written
+func checkImageExport(format string, volume *Volume, config *ObjectStoreConfig) error {
+	if err := ValidateImageFormat(format); err != nil {
+		return err
+	}
+	if config.EncryptionKeyID != "" {
+		return fmt.Errorf("Cannot export image to encrypted objectstore, since it would not be encrypted")
+	}
+	if volume.Size <= 0 {
+		return fmt.Errorf("Cannot export image of volume %v with unknown size", volume.Name)
+	}
+	return nil
+}
+
+/*
+exportImage streams the snapshot to the objectstore as a single image file.
+Only the blocks stored in the backup are read from the snapshot, the others
+are zero as they would be restored.
+*/
+func exportImage(backup *Backup, volume *Volume, format string, deltaOps DeltaBlockBackupOperations,
+	driver ObjectStoreDriver) (*BackupImage, error) {
+	src := &snapshotSource{
+		deltaOps:     deltaOps,
+		snapshotName: backup.SnapshotName,
+		volumeName:   volume.Name,
+		blockSize:    backup.getBlockSize(),
+		volumeSize:   volume.Size,
+		cacheOffset:  -1,
+	}
+	// The chunks are exported by the blocks covering them
+	blocks := getStoredBlocks(backup.Blocks)
+	if backup.getChunking() == CHUNKING_CDC {
+		blocks = getAlignedBlocks(blocks, src.blockSize)
+	}
+	var r *imageReader
+	if format == IMAGE_FORMAT_QCOW2 {
+		r = newQcow2ImageReader(blocks, src)
+	} else {
+		r = newRawImageReader(blocks, src)
+	}
+
+	image := &BackupImage{
+		Format:   format,
+		FilePath: getImageFilePath(backup.Name, volume.Name, format),
+		Size:     r.size,
+	}
+	log.Debugf("Exporting snapshot %v of volume %v as %v image %v", backup.SnapshotName, volume.Name, format, image.FilePath)
+	if err := driver.Write(image.FilePath, r); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+/*
+WriteSnapshotImage streams the snapshot of the volume to w as a raw image of
+the volume size, without any objectstore, e.g. to be piped into the tools of
+the user. Only the ranges allocated in the snapshot are read, the others are
+written as zeros. The image can be restored by RestoreImageStream.
+*/
+func WriteSnapshotImage(w io.Writer, volume *Volume, snapshotName string, deltaOps DeltaBlockBackupOperations) error {
+	if volume.Size <= 0 {
+		return fmt.Errorf("Cannot stream image of volume %v with unknown size", volume.Name)
+	}
+	deltaOps = throttleSnapshotReads(deltaOps)
+	if err := deltaOps.OpenSnapshot(snapshotName, volume.Name); err != nil {
+		return err
+	}
+	defer deltaOps.CloseSnapshot(snapshotName, volume.Name)
+
+	src, blocks, err := newSnapshotSource(volume, snapshotName, deltaOps)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Streaming snapshot %v of volume %v as raw image, %v blocks allocated", snapshotName, volume.Name, len(blocks))
+	_, err = io.Copy(w, newRawImageReader(blocks, src))
+	return err
+}
+
+/*
+ExportSnapshotImage writes the snapshot of the volume to the local file as a
+raw image of the volume size. The same as WriteSnapshotImage, only the ranges
+allocated in the snapshot are read, and the blocks of all zeros are not
+written either, so the file would be sparse. The file must not exist, and
+would be removed if the export failed.
+*/
+func ExportSnapshotImage(filePath string, volume *Volume, snapshotName string, deltaOps DeltaBlockBackupOperations) error {
+	if volume.Size <= 0 {
+		return fmt.Errorf("Cannot export image of volume %v with unknown size", volume.Name)
+	}
+	deltaOps = throttleSnapshotReads(deltaOps)
+	if err := deltaOps.OpenSnapshot(snapshotName, volume.Name); err != nil {
+		return err
+	}
+	defer deltaOps.CloseSnapshot(snapshotName, volume.Name)
+
+	src, blocks, err := newSnapshotSource(volume, snapshotName, deltaOps)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Exporting snapshot %v of volume %v as raw image %v, %v blocks allocated", snapshotName, volume.Name,
+		filePath, len(blocks))
+	if err := writeSparseImage(f, blocks, src); err != nil {
+		f.Close()
+		os.Remove(filePath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(filePath)
+		return err
+	}
+	return nil
+}
+
+/*
+GetSnapshotChecksum reads the snapshot of the volume back by the driver and
+returns the SHA-256 checksum of it as a raw image of the volume size, which
+is the same as the checksum of the image written by WriteSnapshotImage or
+ExportSnapshotImage, e.g. by sha256sum.
+*/
+func GetSnapshotChecksum(volume *Volume, snapshotName string, deltaOps DeltaBlockBackupOperations) (string, error) {
+	if volume.Size <= 0 {
+		return "", fmt.Errorf("Cannot checksum snapshot of volume %v with unknown size", volume.Name)
+	}
+	h := sha256.New()
+	if err := WriteSnapshotImage(h, volume, snapshotName, deltaOps); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeSparseImage writes the non-zero blocks to f at their offsets, and
+// extends f to the volume size, leaving holes for the rest
+func writeSparseImage(f *os.File, blocks []BlockMapping, src *snapshotSource) error {
+	if err := f.Truncate(src.volumeSize); err != nil {
+		return err
+	}
+	data := make([]byte, src.blockSize)
+	for _, blk := range blocks {
+		length := src.blockLength(blk.Offset)
+		if err := src.readAt(data[:length], blk.Offset); err != nil {
+			return err
+		}
+		if isZeroData(data[:length]) {
+			continue
+		}
+		if _, err := f.WriteAt(data[:length], blk.Offset); err != nil {
+			return err
+		}
+	}
+	return f.Sync()
+}
+
+// newSnapshotSource returns the source to read the opened snapshot, and the
+// blocks allocated in it
+func newSnapshotSource(volume *Volume, snapshotName string, deltaOps DeltaBlockBackupOperations) (*snapshotSource, []BlockMapping, error) {
+	delta, err := deltaOps.CompareSnapshot(snapshotName, "", volume.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if delta.BlockSize <= 0 {
+		return nil, nil, fmt.Errorf("Invalid block size %v of snapshot %v", delta.BlockSize, snapshotName)
+	}
+	src := &snapshotSource{
+		deltaOps:     deltaOps,
+		snapshotName: snapshotName,
+		volumeName:   volume.Name,
+		blockSize:    delta.BlockSize,
+		volumeSize:   volume.Size,
+		cacheOffset:  -1,
+	}
+	blocks := []BlockMapping{}
+	for _, d := range delta.Mappings {
+		for offset := d.Offset; offset < d.Offset+d.Size && offset < volume.Size; offset += delta.BlockSize {
+			blocks = append(blocks, BlockMapping{Offset: offset})
+		}
+	}
+	return src, blocks, nil
+}
+
+// snapshotSource reads the snapshot by blocks, and caches the last block
+// read, since the drivers may not support reading part of a block
+type snapshotSource struct {
+	deltaOps     DeltaBlockBackupOperations
+	snapshotName string
+	volumeName   string
+	blockSize    int64
+	volumeSize   int64
+
+	cache       []byte
+	cacheOffset int64
+}
+
+// readAt reads p at off of the volume, p must be within one block
+func (s *snapshotSource) readAt(p []byte, off int64) error {
+	blockOffset := off / s.blockSize * s.blockSize
+	if blockOffset != s.cacheOffset {
+		if s.cache == nil {
+			s.cache = make([]byte, s.blockSize)
+		}
+		length := s.blockSize
+		if blockOffset+length > s.volumeSize {
+			length = s.volumeSize - blockOffset
+		}
+		s.cacheOffset = -1
+		if err := s.deltaOps.ReadSnapshot(s.snapshotName, s.volumeName, blockOffset, s.cache[:length]); err != nil {
+			return err
+		}
+		s.cacheOffset = blockOffset
+	}
+	copy(p, s.cache[off-blockOffset:])
+	return nil
+}
+
+// imageExtent is a range of the image, the read function would fill p with
+// the content at off of the extent
+type imageExtent struct {
+	offset int64
+	length int64
+	read   func(p []byte, off int64) error
+}
+
+func bytesExtent(offset int64, data []byte) imageExtent {
+	return imageExtent{
+		offset: offset,
+		length: int64(len(data)),
+		read: func(p []byte, off int64) error {
+			copy(p, data[off:])
+			return nil
+		},
+	}
+}
+
+// imageReader is an io.ReadSeeker of the image composed of the extents in
+// the order of offsets. The ranges not covered by any extent are zero.
+type imageReader struct {
+	extents []imageExtent
+	size    int64
+	pos     int64
+}
+
+func (r *imageReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.size-r.pos {
+		p = p[:r.size-r.pos]
+	}
+	i := sort.Search(len(r.extents), func(i int) bool {
+		return r.extents[i].offset+r.extents[i].length > r.pos
+	})
+	if i == len(r.extents) || r.extents[i].offset > r.pos {
+		if i < len(r.extents) && r.extents[i].offset-r.pos < int64(len(p)) {
+			p = p[:r.extents[i].offset-r.pos]
+		}
+		for j := range p {
+			p[j] = 0
+		}
+		r.pos += int64(len(p))
+		return len(p), nil
+	}
+	e := r.extents[i]
+	if e.offset+e.length-r.pos < int64(len(p)) {
+		p = p[:e.offset+e.length-r.pos]
+	}
+	if err := e.read(p, r.pos-e.offset); err != nil {
+		return 0, err
+	}
+	r.pos += int64(len(p))
+	return len(p), nil
+}
+
+func (r *imageReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("Invalid whence %v", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("Invalid offset %v", offset)
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// blockLength returns the length of the block in the volume, the last block
+// would be shorter if the volume size is not a multiple of the block size
+func (s *snapshotSource) blockLength(offset int64) int64 {
+	if offset+s.blockSize > s.volumeSize {
+		return s.volumeSize - offset
+	}
+	return s.blockSize
+}
+
+func newRawImageReader(blocks []BlockMapping, src *snapshotSource) *imageReader {
+	r := &imageReader{
+		size: src.volumeSize,
+	}
+	for _, blk := range blocks {
+		offset := blk.Offset
+		r.extents = append(r.extents, imageExtent{
+			offset: offset,
+			length: src.blockLength(offset),
+			read: func(p []byte, off int64) error {
+				return src.readAt(p, offset+off)
+			},
+		})
+	}
+	return r
+}
+
+// qcow2Run is the clusters of a block, which are contiguous in both the
+// volume and the image
+type qcow2Run struct {
+	guest int64
+	host  int64
+	count int64
+}
+
+func divRoundUp(a, b int64) int64 {
+	return (a + b - 1) / b
+}
+
+/*
+newQcow2ImageReader lays out the qcow2 image, so it can be streamed without
+knowing the content of the blocks in advance. The image consists of the
+header, L1 table, refcount table, refcount blocks, L2 tables and the data
+clusters in order, and only the blocks in the backup are allocated. Every
+cluster is referenced once, so the image can be used without checking.
+*/
+func newQcow2ImageReader(blocks []BlockMapping, src *snapshotSource) *imageReader {
+	runs := []qcow2Run{}
+	l2Indexes := []int64{}
+	dataClusters := int64(0)
+	for _, blk := range blocks {
+		run := qcow2Run{
+			guest: blk.Offset / qcow2ClusterSize,
+			host:  dataClusters,
+			count: divRoundUp(src.blockLength(blk.Offset), qcow2ClusterSize),
+		}
+		runs = append(runs, run)
+		dataClusters += run.count
+		for l2 := run.guest / qcow2L2Entries; l2 <= (run.guest+run.count-1)/qcow2L2Entries; l2++ {
+			if len(l2Indexes) == 0 || l2Indexes[len(l2Indexes)-1] < l2 {
+				l2Indexes = append(l2Indexes, l2)
+			}
+		}
+	}
+
+	l1Size := divRoundUp(divRoundUp(src.volumeSize, qcow2ClusterSize), qcow2L2Entries)
+	l1Clusters := divRoundUp(l1Size*8, qcow2ClusterSize)
+	// The refcount blocks need to cover themselves
+	rtClusters, rbClusters, totalClusters := int64(1), int64(1), int64(0)
+	for {
+		totalClusters = 1 + l1Clusters + rtClusters + rbClusters + int64(len(l2Indexes)) + dataClusters
+		rb := divRoundUp(totalClusters, qcow2RefcountEntries)
+		rt := divRoundUp(rb*8, qcow2ClusterSize)
+		if rb == rbClusters && rt == rtClusters {
+			break
+		}
+		rbClusters, rtClusters = rb, rt
+	}
+	l1Start := int64(1)
+	rtStart := l1Start + l1Clusters
+	rbStart := rtStart + rtClusters
+	l2Start := rbStart + rbClusters
+	dataStart := l2Start + int64(len(l2Indexes))
+	for i := range runs {
+		runs[i].host += dataStart
+	}
+
+	r := &imageReader{
+		size: totalClusters * qcow2ClusterSize,
+	}
+
+	header := make([]byte, qcow2HeaderSize)
+	binary.BigEndian.PutUint32(header[0:], qcow2Magic)
+	binary.BigEndian.PutUint32(header[4:], qcow2Version)
+	binary.BigEndian.PutUint32(header[20:], qcow2ClusterBits)
+	binary.BigEndian.PutUint64(header[24:], uint64(src.volumeSize))
+	binary.BigEndian.PutUint32(header[36:], uint32(l1Size))
+	binary.BigEndian.PutUint64(header[40:], uint64(l1Start*qcow2ClusterSize))
+	binary.BigEndian.PutUint64(header[48:], uint64(rtStart*qcow2ClusterSize))
+	binary.BigEndian.PutUint32(header[56:], uint32(rtClusters))
+	r.extents = append(r.extents, bytesExtent(0, header))
+
+	l1 := make([]byte, l1Size*8)
+	for i, l2 := range l2Indexes {
+		binary.BigEndian.PutUint64(l1[l2*8:], uint64((l2Start+int64(i))*qcow2ClusterSize)|qcow2OflagCopied)
+	}
+	r.extents = append(r.extents, bytesExtent(l1Start*qcow2ClusterSize, l1))
+
+	rt := make([]byte, rbClusters*8)
+	for i := int64(0); i < rbClusters; i++ {
+		binary.BigEndian.PutUint64(rt[i*8:], uint64((rbStart+i)*qcow2ClusterSize))
+	}
+	r.extents = append(r.extents, bytesExtent(rtStart*qcow2ClusterSize, rt))
+
+	// Every cluster has refcount 1 in big endian 16-bit
+	r.extents = append(r.extents, imageExtent{
+		offset: rbStart * qcow2ClusterSize,
+		length: rbClusters * qcow2ClusterSize,
+		read: func(p []byte, off int64) error {
+			for i := range p {
+				p[i] = 0
+				if index := (off + int64(i)) / 2; (off+int64(i))%2 == 1 && index < totalClusters {
+					p[i] = 1
+				}
+			}
+			return nil
+		},
+	})
+
+	for i, l2 := range l2Indexes {
+		first := l2 * qcow2L2Entries
+		r.extents = append(r.extents, imageExtent{
+			offset: (l2Start + int64(i)) * qcow2ClusterSize,
+			length: qcow2ClusterSize,
+			read: func(p []byte, off int64) error {
+				table := make([]byte, qcow2ClusterSize)
+				j := sort.Search(len(runs), func(j int) bool {
+					return runs[j].guest+runs[j].count > first
+				})
+				for ; j < len(runs) && runs[j].guest < first+qcow2L2Entries; j++ {
+					for c := int64(0); c < runs[j].count; c++ {
+						guest := runs[j].guest + c
+						if guest < first || guest >= first+qcow2L2Entries {
+							continue
+						}
+						host := uint64((runs[j].host + c) * qcow2ClusterSize)
+						binary.BigEndian.PutUint64(table[(guest-first)*8:], host|qcow2OflagCopied)
+					}
+				}
+				copy(p, table[off:])
+				return nil
+			},
+		})
+	}
+
+	for _, run := range runs {
+		volumeOffset := run.guest * qcow2ClusterSize
+		length := src.blockLength(volumeOffset)
+		r.extents = append(r.extents, imageExtent{
+			offset: run.host * qcow2ClusterSize,
+			length: run.count * qcow2ClusterSize,
+			read: func(p []byte, off int64) error {
+				// The last cluster is padded with zero
+				n := int64(len(p))
+				if off+n > length {
+					n = length - off
+					if n < 0 {
+						n = 0
+					}
+					for i := n; i < int64(len(p)); i++ {
+						p[i] = 0
+					}
+				}
+				if n == 0 {
+					return nil
+				}
+				return src.readAt(p[:n], volumeOffset+off)
+			},
+		})
+	}
+	return r
+}
@@ -0,0 +1,196 @@
+package objectstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/rancher/convoy/metadata"
+	"gopkg.in/check.v1"
+)
+
+// readQcow2 returns the content of the volume in the qcow2 image, and checks
+// every cluster of the image is referenced once
+func readQcow2(c *check.C, image []byte) []byte {
+	be := binary.BigEndian
+	c.Assert(be.Uint32(image[0:]), check.Equals, uint32(qcow2Magic))
+	c.Assert(be.Uint32(image[20:]), check.Equals, uint32(qcow2ClusterBits))
+	size := int64(be.Uint64(image[24:]))
+	l1Size := int64(be.Uint32(image[36:]))
+	l1Offset := int64(be.Uint64(image[40:]))
+	rtOffset := int64(be.Uint64(image[48:]))
+	c.Assert(int64(len(image))%qcow2ClusterSize, check.Equals, int64(0))
+
+	clusters := int64(len(image)) / qcow2ClusterSize
+	for i := int64(0); i < clusters; i++ {
+		rb := int64(be.Uint64(image[rtOffset+i/qcow2RefcountEntries*8:]))
+		c.Assert(rb, check.Not(check.Equals), int64(0))
+		refcount := be.Uint16(image[rb+i%qcow2RefcountEntries*2:])
+		c.Assert(refcount, check.Equals, uint16(1))
+	}
+
+	data := make([]byte, size)
+	mask := ^qcow2OflagCopied
+	for i := int64(0); i < l1Size; i++ {
+		l2 := int64(be.Uint64(image[l1Offset+i*8:]) & mask)
+		if l2 == 0 {
+			continue
+		}
+		for j := int64(0); j < qcow2L2Entries; j++ {
+			host := int64(be.Uint64(image[l2+j*8:]) & mask)
+			if host == 0 {
+				continue
+			}
+			guest := (i*qcow2L2Entries + j) * qcow2ClusterSize
+			copy(data[guest:], image[host:host+qcow2ClusterSize])
+		}
+	}
+	return data
+}
+
+func (s *TestSuite) TestExportImage(c *check.C) {
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: DEFAULT_BLOCK_SIZE, Size: 2 * DEFAULT_BLOCK_SIZE},
+		},
+	}
+	// The last block is shorter
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+		Size:   3*DEFAULT_BLOCK_SIZE - 4096,
+	}
+	expected := make([]byte, volume.Size)
+	for i := DEFAULT_BLOCK_SIZE; i < len(expected); i++ {
+		expected[i] = byte(i/DEFAULT_BLOCK_SIZE%3 + 1)
+	}
+
+	for _, format := range []string{IMAGE_FORMAT_RAW, IMAGE_FORMAT_QCOW2} {
+		snapshot := &Snapshot{Name: "snapshot-" + format, ExportImage: format}
+		backupURL, err := CreateDeltaBlockBackup(volume, snapshot, MEM_URL, "", deltaOps)
+		c.Assert(err, check.IsNil)
+		backupName, _, err := decodeBackupURL(backupURL)
+		c.Assert(err, check.IsNil)
+		backup, err := loadBackup(backupName, testVolumeName, memStore)
+		c.Assert(err, check.IsNil)
+		c.Assert(backup.Image, check.NotNil)
+		c.Assert(backup.Image.Format, check.Equals, format)
+		c.Assert(backup.Image.FilePath, check.Equals, getImageFilePath(backupName, testVolumeName, format))
+
+		image := memStore.files[backup.Image.FilePath]
+		c.Assert(int64(len(image)), check.Equals, backup.Image.Size)
+		if format == IMAGE_FORMAT_QCOW2 {
+			image = readQcow2(c, image)
+		}
+		c.Assert(bytes.Equal(image, expected), check.Equals, true)
+
+		info, err := GetBackupInfo(backupURL, "")
+		c.Assert(err, check.IsNil)
+		c.Assert(info["ImagePath"], check.Equals, backup.Image.FilePath)
+
+		c.Assert(DeleteDeltaBlockBackup(backupURL, "", false), check.IsNil)
+		c.Assert(memStore.FileExists(backup.Image.FilePath), check.Equals, false)
+	}
+
+	snapshot := &Snapshot{Name: "snapshot", ExportImage: "vmdk"}
+	_, err := CreateDeltaBlockBackup(volume, snapshot, MEM_URL, "", deltaOps)
+	c.Assert(err, check.ErrorMatches, "Unsupported image format vmdk.*")
+}
+
+func (s *TestSuite) TestStreamSnapshotImage(c *check.C) {
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: DEFAULT_BLOCK_SIZE, Size: 2 * DEFAULT_BLOCK_SIZE},
+		},
+	}
+	volume := &Volume{
+		Name: testVolumeName,
+		Size: 4*DEFAULT_BLOCK_SIZE - 100,
+	}
+	buf := &bytes.Buffer{}
+	c.Assert(WriteSnapshotImage(buf, volume, "snapshot", deltaOps), check.IsNil)
+	c.Assert(int64(buf.Len()), check.Equals, volume.Size)
+	c.Assert(deltaOps.reads, check.DeepEquals, []int64{DEFAULT_BLOCK_SIZE, 2 * DEFAULT_BLOCK_SIZE})
+	expected := make([]byte, volume.Size)
+	copy(expected[DEFAULT_BLOCK_SIZE:], bytes.Repeat([]byte{2}, DEFAULT_BLOCK_SIZE))
+	copy(expected[2*DEFAULT_BLOCK_SIZE:], bytes.Repeat([]byte{3}, DEFAULT_BLOCK_SIZE))
+	c.Assert(bytes.Equal(buf.Bytes(), expected), check.Equals, true)
+
+	// The image is written over the old content of the volume
+	volFile := filepath.Join(c.MkDir(), "volume")
+	c.Assert(ioutil.WriteFile(volFile, bytes.Repeat([]byte{9}, int(volume.Size)), 0600), check.IsNil)
+	size, err := RestoreImageStream(bytes.NewReader(expected), volFile)
+	c.Assert(err, check.IsNil)
+	c.Assert(size, check.Equals, volume.Size)
+	restored, err := ioutil.ReadFile(volFile)
+	c.Assert(err, check.IsNil)
+	c.Assert(bytes.Equal(restored, expected), check.Equals, true)
+
+	_, err = RestoreImageStream(bytes.NewReader(append(expected, 0)), volFile)
+	c.Assert(err, check.ErrorMatches, "Image is larger than .*")
+
+	deltaOps.failAt = 2 * DEFAULT_BLOCK_SIZE
+	c.Assert(WriteSnapshotImage(&bytes.Buffer{}, volume, "snapshot", deltaOps), check.ErrorMatches, "Failed to read at .*")
+}
+
+func (s *TestSuite) TestExportSnapshotImage(c *check.C) {
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: DEFAULT_BLOCK_SIZE, Size: 2 * DEFAULT_BLOCK_SIZE},
+		},
+	}
+	volume := &Volume{
+		Name: testVolumeName,
+		Size: 4*DEFAULT_BLOCK_SIZE - 100,
+	}
+	imageFile := filepath.Join(c.MkDir(), "image")
+	c.Assert(ExportSnapshotImage(imageFile, volume, "snapshot", deltaOps), check.IsNil)
+	c.Assert(deltaOps.reads, check.DeepEquals, []int64{DEFAULT_BLOCK_SIZE, 2 * DEFAULT_BLOCK_SIZE})
+	expected := make([]byte, volume.Size)
+	copy(expected[DEFAULT_BLOCK_SIZE:], bytes.Repeat([]byte{2}, DEFAULT_BLOCK_SIZE))
+	copy(expected[2*DEFAULT_BLOCK_SIZE:], bytes.Repeat([]byte{3}, DEFAULT_BLOCK_SIZE))
+	data, err := ioutil.ReadFile(imageFile)
+	c.Assert(err, check.IsNil)
+	c.Assert(bytes.Equal(data, expected), check.Equals, true)
+
+	// The existing file would not be overwritten
+	c.Assert(ExportSnapshotImage(imageFile, volume, "snapshot", deltaOps), check.ErrorMatches, ".*file exists")
+
+	// The incomplete image would be removed
+	failedFile := filepath.Join(c.MkDir(), "failed")
+	deltaOps.failAt = 2 * DEFAULT_BLOCK_SIZE
+	c.Assert(ExportSnapshotImage(failedFile, volume, "snapshot", deltaOps), check.ErrorMatches, "Failed to read at .*")
+	_, err = os.Stat(failedFile)
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+}
+
+func (s *TestSuite) TestGetSnapshotChecksum(c *check.C) {
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: DEFAULT_BLOCK_SIZE, Size: 2 * DEFAULT_BLOCK_SIZE},
+		},
+	}
+	volume := &Volume{
+		Name: testVolumeName,
+		Size: 4*DEFAULT_BLOCK_SIZE - 100,
+	}
+	expected := make([]byte, volume.Size)
+	copy(expected[DEFAULT_BLOCK_SIZE:], bytes.Repeat([]byte{2}, DEFAULT_BLOCK_SIZE))
+	copy(expected[2*DEFAULT_BLOCK_SIZE:], bytes.Repeat([]byte{3}, DEFAULT_BLOCK_SIZE))
+	sum := sha256.Sum256(expected)
+
+	checksum, err := GetSnapshotChecksum(volume, "snapshot", deltaOps)
+	c.Assert(err, check.IsNil)
+	c.Assert(checksum, check.Equals, hex.EncodeToString(sum[:]))
+
+	deltaOps.failAt = 2 * DEFAULT_BLOCK_SIZE
+	_, err = GetSnapshotChecksum(volume, "snapshot", deltaOps)
+	c.Assert(err, check.ErrorMatches, "Failed to read at .*")
+
+	_, err = GetSnapshotChecksum(&Volume{Name: testVolumeName}, "snapshot", deltaOps)
+	c.Assert(err, check.ErrorMatches, "Cannot checksum snapshot .* unknown size")
+}
@@ -0,0 +1,325 @@
+package objectstore
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+// KeyRotationReport is the result of the rotation of the encryption key of
+// an objectstore, see RotateEncryptionKey
+type KeyRotationReport struct {
+	DestURL            string
+	KeyID              string
+	ReencryptedObjects int
+	FailedObjects      []BlockReport
+	// The keys no longer used by any object once the re-encryption is
+	// complete, which can be removed from --backup-keys
+	RetiredKeyIDs []string
+	Complete      bool
+}
+
+// findEncryptionKey returns the loaded key of the key file
+func findEncryptionKey(keyFile string) (*EncryptionKey, error) {
+	for _, k := range encryptionKeys {
+		if filepath.Clean(k.File) == filepath.Clean(keyFile) {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("Encryption key %v hasn't been loaded by --backup-keys", keyFile)
+}
+
+// updateObjectStoreConfig applies the change to the config of the objectstore
+// with the objectstore locked, so it won't be overwritten by other hosts
+func updateObjectStoreConfig(driver ObjectStoreDriver, update func(config *ObjectStoreConfig) error) (*ObjectStoreConfig, error) {
+	if locker := getLocker(driver); locker != nil {
+		lockPath := getObjectStoreLockPath()
+		if err := locker.Lock(lockPath); err != nil {
+			return nil, err
+		}
+		defer locker.Unlock(lockPath)
+	}
+	config, err := loadObjectStoreConfig(driver)
+	if err != nil {
+		return nil, err
+	}
+	if err := update(config); err != nil {
+		return nil, err
+	}
+	if err := saveConfigInObjectStore(getObjectStoreConfigPath(), driver, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+/*
+RotateEncryptionKey makes the key of keyFile, which must have been loaded by
+--backup-keys, the current key of the encrypted objectstore, then re-encrypts
+all the objects encrypted by the other keys. The new objects are encrypted by
+the new key as soon as it's added, and every object records the key encrypted
+it, so the backups can go on during the re-encryption, and the interrupted
+rotation can be simply run again with the same key. Once all the objects have
+been re-encrypted, the other keys are retired, and the objectstore cannot be
+read by them any more.
+*/
+func RotateEncryptionKey(destURL, endpoint, keyFile string) (*KeyRotationReport, error) {
+	driver, err := GetObjectStoreDriver(destURL, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkWritable(driver); err != nil {
+		return nil, err
+	}
+	key, err := findEncryptionKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := updateObjectStoreConfig(driver, func(config *ObjectStoreConfig) error {
+		if config.EncryptionKeyID == "" {
+			return fmt.Errorf("Objectstore %v is not encrypted", driver.GetURL())
+		}
+		// The current key is needed to decrypt the objects encrypted by it
+		if _, err := config.getCipher(); err != nil {
+			return err
+		}
+		salt, err := hex.DecodeString(config.EncryptionSalt)
+		if err != nil {
+			return err
+		}
+		keyID := getKeyID(key.derive(salt))
+		keyIDs := config.getEncryptionKeyIDs()
+		added := false
+		for _, id := range keyIDs {
+			if id == keyID {
+				added = true
+			}
+		}
+		// The rotation to the key may be resumed
+		if !added {
+			config.EncryptionKeyIDs = append(keyIDs, keyID)
+		}
+		config.EncryptionKeyID = keyID
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	aead, err := config.getCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &KeyRotationReport{
+		DestURL:       driver.GetURL(),
+		KeyID:         config.EncryptionKeyID,
+		FailedObjects: []BlockReport{},
+		RetiredKeyIDs: []string{},
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_START,
+		LOG_FIELD_EVENT:  LOG_EVENT_ROTATE,
+		LOG_FIELD_KIND:   driver.Kind(),
+	}).Debugf("Rotating encryption key of objectstore %v to %v", report.DestURL, report.KeyID)
+
+	volumeNames, err := getVolumeNames(driver)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(volumeNames)
+	for _, volumeName := range volumeNames {
+		if err := reencryptVolume(volumeName, aead, driver, report); err != nil {
+			return nil, err
+		}
+	}
+	if err := reencryptSharedBlocks(volumeNames, aead, driver, report); err != nil {
+		return nil, err
+	}
+
+	if len(report.FailedObjects) == 0 {
+		_, err := updateObjectStoreConfig(driver, func(config *ObjectStoreConfig) error {
+			if config.EncryptionKeyID != report.KeyID {
+				return fmt.Errorf("Encryption key of objectstore %v has been rotated to %v in the meantime",
+					report.DestURL, config.EncryptionKeyID)
+			}
+			for _, keyID := range config.getEncryptionKeyIDs() {
+				if keyID != config.EncryptionKeyID {
+					report.RetiredKeyIDs = append(report.RetiredKeyIDs, keyID)
+				}
+			}
+			config.EncryptionKeyIDs = nil
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		report.Complete = true
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:  LOG_EVENT_ROTATE,
+		LOG_FIELD_KIND:   driver.Kind(),
+	}).Debugf("Rotated encryption key of objectstore %v to %v, %v objects re-encrypted, %v failed, retired keys %v",
+		report.DestURL, report.KeyID, report.ReencryptedObjects, len(report.FailedObjects), report.RetiredKeyIDs)
+	return report, nil
+}
+
+// getEncryptedVolumeFiles returns the paths of the encrypted objects of the
+// volume, which are all the files of the volume except the volume config
+func getEncryptedVolumeFiles(volumeName string, driver ObjectStoreDriver) ([]string, error) {
+	files, err := listBlockFiles(getBlockPath(volumeName), driver)
+	if err != nil {
+		return nil, err
+	}
+	backupNames, err := getBackupNamesForVolume(volumeName, driver)
+	if err != nil {
+		return nil, err
+	}
+	for _, backupName := range backupNames {
+		files = append(files, getBackupConfigPath(backupName, volumeName))
+	}
+	snapshotNames, err := getCheckpointNamesForVolume(volumeName, driver)
+	if err != nil {
+		return nil, err
+	}
+	for _, snapshotName := range snapshotNames {
+		files = append(files, getCheckpointConfigPath(snapshotName, volumeName))
+	}
+	for _, filePath := range []string{getBlockIndexFilePath(volumeName), getBlockRefsFilePath(volumeName)} {
+		if driver.FileExists(filePath) {
+			files = append(files, filePath)
+		}
+	}
+	return files, nil
+}
+
+func reencryptVolume(volumeName string, aead *objectCipher, driver ObjectStoreDriver, report *KeyRotationReport) error {
+	unlock, err := lockVolume(volumeName, driver)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	files, err := getEncryptedVolumeFiles(volumeName, driver)
+	if err != nil {
+		return err
+	}
+	reencryptFiles(files, aead, driver, report)
+	return updateBlockKeyIDs(volumeName, false, aead, driver, report)
+}
+
+// reencryptSharedBlocks re-encrypts the shared block pool with all the
+// volumes locked, since any backup may add blocks to the pool
+func reencryptSharedBlocks(volumeNames []string, aead *objectCipher, driver ObjectStoreDriver, report *KeyRotationReport) error {
+	for _, volumeName := range volumeNames {
+		unlock, err := lockVolume(volumeName, driver)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	files, err := listBlockFiles(getSharedBlockPath(), driver)
+	if err != nil {
+		return err
+	}
+	reencryptFiles(files, aead, driver, report)
+	for _, volumeName := range volumeNames {
+		if err := updateBlockKeyIDs(volumeName, true, aead, driver, report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateBlockKeyIDs records the current key in the blocks of the backups of
+// the volume, whose block files have been re-encrypted by it. The shared
+// blocks and the others are updated separately, since they're re-encrypted
+// separately. The volume must be locked.
+func updateBlockKeyIDs(volumeName string, shared bool, aead *objectCipher, driver ObjectStoreDriver,
+	report *KeyRotationReport) error {
+	failed := map[string]bool{}
+	for _, object := range report.FailedObjects {
+		failed[object.File] = true
+	}
+	backupNames, err := getBackupNamesForVolume(volumeName, driver)
+	if err != nil {
+		return err
+	}
+	for _, backupName := range backupNames {
+		if failed[getBackupConfigPath(backupName, volumeName)] {
+			continue
+		}
+		backup, err := loadBackup(backupName, volumeName, driver)
+		if err != nil {
+			return err
+		}
+		updated := false
+		for i, blk := range backup.Blocks {
+			if isZeroBlock(blk) || blk.Shared != shared || blk.EncryptionKeyID == aead.keyID ||
+				failed[getBlockFilePath(volumeName, blk)] {
+				continue
+			}
+			backup.Blocks[i].EncryptionKeyID = aead.keyID
+			updated = true
+		}
+		if !updated {
+			continue
+		}
+		if err := saveBackup(backup, driver); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reencryptFiles re-encrypts the files not encrypted by the current key. The
+// failed files, e.g. archived ones, are reported and skipped.
+func reencryptFiles(files []string, aead *objectCipher, driver ObjectStoreDriver, report *KeyRotationReport) {
+	for _, filePath := range files {
+		reencrypted, err := reencryptFile(filePath, aead, driver)
+		if err != nil {
+			log.Warnf("Failed to re-encrypt %v: %v", filePath, err)
+			report.FailedObjects = append(report.FailedObjects, BlockReport{
+				File:  filePath,
+				Error: err.Error(),
+			})
+			continue
+		}
+		if reencrypted {
+			report.ReencryptedObjects++
+		}
+	}
+}
+
+func reencryptFile(filePath string, aead *objectCipher, driver ObjectStoreDriver) (bool, error) {
+	rc, err := driver.Read(filePath)
+	if err != nil {
+		return false, err
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return false, err
+	}
+	if keyID, _ := aead.getObjectKeyID(data); keyID == aead.keyID {
+		return false, nil
+	}
+	if data, err = decryptData(aead, filePath, data); err != nil {
+		return false, err
+	}
+	if data, err = encryptData(aead, filePath, data); err != nil {
+		return false, err
+	}
+	if err := driver.Write(filePath, bytes.NewReader(data)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,78 @@
+package objectstore
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	labelKeyRegex   = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9._/-]*[a-zA-Z0-9])?$`)
+	labelValueRegex = regexp.MustCompile(`^[a-zA-Z0-9._/:@-]*$`)
+)
+
+/*
+ParseLabels parses the labels of backup in the form of "<key>=<value>", e.g.
+"app=postgres". The key consists of alphanumeric characters, '.', '_', '/'
+and '-', and the value may contain ':' and '@' as well, or be empty.
+*/
+func ParseLabels(specs []string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, spec := range specs {
+		i := strings.Index(spec, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("Invalid label %v, should be in the form of <key>=<value>", spec)
+		}
+		key, value := spec[:i], spec[i+1:]
+		if !labelKeyRegex.MatchString(key) {
+			return nil, fmt.Errorf("Invalid label key %v", key)
+		}
+		if !labelValueRegex.MatchString(value) {
+			return nil, fmt.Errorf("Invalid label value %v of key %v", value, key)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// EncodeLabels encodes the labels as "<key>=<value>" separated by ',' in the
+// order of keys, which can be decoded by DecodeLabels
+func EncodeLabels(labels map[string]string) string {
+	specs := []string{}
+	for key, value := range labels {
+		specs = append(specs, key+"="+value)
+	}
+	sort.Strings(specs)
+	return strings.Join(specs, ",")
+}
+
+// DecodeLabels decodes the labels encoded by EncodeLabels, the invalid ones
+// would be skipped
+func DecodeLabels(s string) map[string]string {
+	labels := map[string]string{}
+	if s == "" {
+		return labels
+	}
+	for _, spec := range strings.Split(s, ",") {
+		parsed, err := ParseLabels([]string{spec})
+		if err != nil {
+			log.Warnf("Skipped invalid label %v", spec)
+			continue
+		}
+		for key, value := range parsed {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+// MatchLabels checks if the labels contain all the labels of selector
+func MatchLabels(labels, selector map[string]string) bool {
+	for key, value := range selector {
+		if v, exists := labels[key]; !exists || v != value {
+			return false
+		}
+	}
+	return true
+}
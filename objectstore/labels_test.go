@@ -0,0 +1,60 @@
+package objectstore
+
+import (
+	"github.com/rancher/convoy/metadata"
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestParseLabels(c *check.C) {
+	labels, err := ParseLabels([]string{"app=postgres", "env=prod", "owner=dba@example.com", "empty="})
+	c.Assert(err, check.IsNil)
+	c.Assert(labels, check.DeepEquals, map[string]string{
+		"app":   "postgres",
+		"env":   "prod",
+		"owner": "dba@example.com",
+		"empty": "",
+	})
+	encoded := EncodeLabels(labels)
+	c.Assert(encoded, check.Equals, "app=postgres,empty=,env=prod,owner=dba@example.com")
+	c.Assert(DecodeLabels(encoded), check.DeepEquals, labels)
+	c.Assert(DecodeLabels(""), check.HasLen, 0)
+
+	for _, spec := range []string{"app", "=postgres", "-app=postgres", "app=post,gres", "app=a=b"} {
+		_, err := ParseLabels([]string{spec})
+		c.Assert(err, check.NotNil, check.Commentf("label %v", spec))
+	}
+
+	c.Assert(MatchLabels(labels, map[string]string{}), check.Equals, true)
+	c.Assert(MatchLabels(labels, map[string]string{"app": "postgres", "env": "prod"}), check.Equals, true)
+	c.Assert(MatchLabels(labels, map[string]string{"env": "dev"}), check.Equals, false)
+	c.Assert(MatchLabels(labels, map[string]string{"team": ""}), check.Equals, false)
+}
+
+func (s *TestSuite) TestBackupLabels(c *check.C) {
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: 0, Size: DEFAULT_BLOCK_SIZE},
+		},
+	}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+		Size:   DEFAULT_BLOCK_SIZE,
+	}
+	snapshot := &Snapshot{
+		Name:   "snapshot",
+		Labels: map[string]string{"app": "postgres"},
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, snapshot, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	info, err := GetBackupInfo(backupURL, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(info["Labels"], check.Equals, "app=postgres")
+
+	backupURL, err = CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-2"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	info, err = GetBackupInfo(backupURL, "")
+	c.Assert(err, check.IsNil)
+	_, exists := info["Labels"]
+	c.Assert(exists, check.Equals, false)
+}
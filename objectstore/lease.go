@@ -0,0 +1,251 @@
+package objectstore
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	DEFAULT_LEASE_TTL = 5 * time.Minute
+)
+
+var (
+	leaseTTL = DEFAULT_LEASE_TTL
+	// The lease would be checked again after this delay since written, in
+	// case another host wrote its lease at the same time
+	leaseSettleDelay   = time.Second
+	leaseRetryInterval = 5 * time.Second
+	leaseWaitTimeout   = 30 * time.Minute
+)
+
+/*
+Lease is the lock object in the objectstore, for the destinations which
+cannot lock by themselves, e.g. S3. It expires if the holder hasn't renewed
+it in time, e.g. the host crashed, so the lock would never be held forever.
+The clocks of the hosts need to be synchronized, since the expiration time
+is compared with the local time.
+*/
+type Lease struct {
+	ID         string
+	Owner      string
+	AcquiredAt string
+	ExpiresAt  time.Time
+}
+
+// SetLeaseTTL sets how long the lease would be valid without renewal, it
+// would be renewed every third of it
+func SetLeaseTTL(ttl time.Duration) error {
+	if ttl < time.Minute {
+		return fmt.Errorf("Invalid lock TTL %v, must be at least 1 minute", ttl)
+	}
+	leaseTTL = ttl
+	return nil
+}
+
+func leaseOwner() string {
+	return fmt.Sprintf("%v:%v", getHostname(), os.Getpid())
+}
+
+// leaseLocker implements ObjectStoreLocker by the leases in the objectstore
+type leaseLocker struct {
+	mutex  *sync.Mutex
+	driver ObjectStoreDriver
+	held   map[string]*heldLease
+}
+
+type heldLease struct {
+	lease *Lease
+	stop  chan struct{}
+}
+
+// getLocker returns the locker of the objectstore, which is the driver itself
+// if it supports locking, or the leases in the objectstore otherwise. It
+// returns nil for the read-only objectstores, which cannot be updated anyway.
+func getLocker(driver ObjectStoreDriver) ObjectStoreLocker {
+	if locker, ok := unthrottled(driver).(ObjectStoreLocker); ok {
+		return locker
+	}
+	if checkWritable(driver) != nil {
+		return nil
+	}
+	return newLeaseLocker(driver)
+}
+
+func newLeaseLocker(driver ObjectStoreDriver) *leaseLocker {
+	return &leaseLocker{
+		mutex:  &sync.Mutex{},
+		driver: driver,
+		held:   map[string]*heldLease{},
+	}
+}
+
+// loadLease returns nil if there is no lease
+func loadLease(lockPath string, driver ObjectStoreDriver) (*Lease, error) {
+	if !driver.FileExists(lockPath) {
+		return nil, nil
+	}
+	lease := &Lease{}
+	if err := loadConfigInObjectStore(lockPath, driver, lease); err != nil {
+		// Released in the meantime
+		if !driver.FileExists(lockPath) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return lease, nil
+}
+
+func (l *leaseLocker) tryLock(lockPath string, lease *Lease) (bool, error) {
+	existing, err := loadLease(lockPath, l.driver)
+	if err != nil {
+		return false, err
+	}
+	if existing != nil {
+		if time.Now().Before(existing.ExpiresAt) {
+			return false, nil
+		}
+		log.Warnf("Taking over expired lock %v held by %v since %v", lockPath, existing.Owner, existing.AcquiredAt)
+	}
+
+	lease.ExpiresAt = time.Now().Add(leaseTTL)
+	if err := saveConfigInObjectStore(lockPath, l.driver, lease); err != nil {
+		return false, err
+	}
+	// The last writer wins if multiple hosts wrote at the same time
+	time.Sleep(leaseSettleDelay)
+	existing, err = loadLease(lockPath, l.driver)
+	if err != nil {
+		return false, err
+	}
+	return existing != nil && existing.ID == lease.ID, nil
+}
+
+func (l *leaseLocker) Lock(lockPath string) error {
+	lease := &Lease{
+		ID:         util.NewUUID(),
+		Owner:      leaseOwner(),
+		AcquiredAt: util.Now(),
+	}
+	deadline := time.Now().Add(leaseWaitTimeout)
+	for {
+		locked, err := l.tryLock(lockPath, lease)
+		if err != nil {
+			return err
+		}
+		if locked {
+			break
+		}
+		if time.Now().After(deadline) {
+			owner := "unknown"
+			if existing, err := loadLease(lockPath, l.driver); err == nil && existing != nil {
+				owner = existing.Owner
+			}
+			return fmt.Errorf("Timed out waiting for lock %v held by %v", lockPath, owner)
+		}
+		log.Debugf("Waiting for lock %v", lockPath)
+		time.Sleep(leaseRetryInterval)
+	}
+
+	held := &heldLease{
+		lease: lease,
+		stop:  make(chan struct{}),
+	}
+	l.mutex.Lock()
+	l.held[lockPath] = held
+	l.mutex.Unlock()
+	go l.renew(lockPath, held)
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
+		LOG_FIELD_FILEPATH: lockPath,
+	}).Debugf("Acquired lease until %v", lease.ExpiresAt)
+	return nil
+}
+
+func (l *leaseLocker) renew(lockPath string, held *heldLease) {
+	ticker := time.NewTicker(leaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-held.stop:
+			return
+		case <-ticker.C:
+			held.lease.ExpiresAt = time.Now().Add(leaseTTL)
+			if err := saveConfigInObjectStore(lockPath, l.driver, held.lease); err != nil {
+				log.Warnf("Failed to renew lock %v: %v", lockPath, err)
+			}
+		}
+	}
+}
+
+func (l *leaseLocker) Unlock(lockPath string) error {
+	l.mutex.Lock()
+	held, exists := l.held[lockPath]
+	delete(l.held, lockPath)
+	l.mutex.Unlock()
+	if !exists {
+		return fmt.Errorf("BUG: Lock %v is not held", lockPath)
+	}
+	close(held.stop)
+
+	// The lease may have been broken and taken by another host
+	existing, err := loadLease(lockPath, l.driver)
+	if err != nil {
+		return err
+	}
+	if existing == nil || existing.ID != held.lease.ID {
+		log.Warnf("Lock %v has been taken over by %v", lockPath, leaseOwnerOf(existing))
+		return nil
+	}
+	return l.driver.Remove(lockPath)
+}
+
+func leaseOwnerOf(lease *Lease) string {
+	if lease == nil {
+		return "nobody"
+	}
+	return lease.Owner
+}
+
+/*
+BreakLock removes the lock of the volume in the objectstore, or the lock of
+the objectstore itself if volumeName is empty, e.g. the one left by the host
+which has been removed permanently. It's only needed if the lock cannot wait
+to expire, and must not be used when the holder is still running.
+*/
+func BreakLock(destURL, endpoint, volumeName string) (*Lease, error) {
+	driver, err := GetObjectStoreDriver(destURL, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkWritable(driver); err != nil {
+		return nil, err
+	}
+	lockPath := getObjectStoreLockPath()
+	if volumeName != "" {
+		lockPath = getVolumeLockPath(volumeName)
+	}
+	if !driver.FileExists(lockPath) {
+		return nil, fmt.Errorf("Cannot find lock %v in objectstore", lockPath)
+	}
+	// The locks of the drivers locking by themselves are not leases
+	lease, err := loadLease(lockPath, driver)
+	if err != nil {
+		lease = nil
+	}
+	if err := driver.Remove(lockPath); err != nil {
+		return nil, err
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
+		LOG_FIELD_FILEPATH: lockPath,
+		LOG_FIELD_DEST_URL: destURL,
+	}).Warnf("Broke lock held by %v", leaseOwnerOf(lease))
+	return lease, nil
+}
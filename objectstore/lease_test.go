@@ -0,0 +1,56 @@
+package objectstore
+
+import (
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestLease(c *check.C) {
+	defer func(settle, retry, wait time.Duration) {
+		leaseSettleDelay, leaseRetryInterval, leaseWaitTimeout = settle, retry, wait
+	}(leaseSettleDelay, leaseRetryInterval, leaseWaitTimeout)
+	leaseSettleDelay = 0
+	leaseRetryInterval = time.Millisecond
+	leaseWaitTimeout = 10 * time.Millisecond
+
+	lockPath := getVolumeLockPath(testVolumeName)
+	l1 := newLeaseLocker(memStore)
+	l2 := newLeaseLocker(memStore)
+	c.Assert(l1.Lock(lockPath), check.IsNil)
+	lease, err := loadLease(lockPath, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(lease.Owner, check.Equals, leaseOwner())
+	c.Assert(lease.ExpiresAt.After(time.Now()), check.Equals, true)
+	c.Assert(l2.Lock(lockPath), check.ErrorMatches, "Timed out waiting for lock .* held by .*")
+
+	// The expired lease would be taken over
+	lease.ExpiresAt = time.Now().Add(-time.Second)
+	c.Assert(saveConfigInObjectStore(lockPath, memStore, lease), check.IsNil)
+	c.Assert(l2.Lock(lockPath), check.IsNil)
+	c.Assert(l1.Unlock(lockPath), check.IsNil)
+	c.Assert(memStore.FileExists(lockPath), check.Equals, true)
+	c.Assert(l2.Unlock(lockPath), check.IsNil)
+	c.Assert(memStore.FileExists(lockPath), check.Equals, false)
+	c.Assert(l2.Unlock(lockPath), check.ErrorMatches, "BUG: Lock .* is not held")
+
+	// The broken lease would be released without removing the new one
+	c.Assert(l1.Lock(lockPath), check.IsNil)
+	_, err = BreakLock(MEM_URL, "", "volume-2")
+	c.Assert(err, check.ErrorMatches, "Cannot find lock .*")
+	broken, err := BreakLock(MEM_URL, "", testVolumeName)
+	c.Assert(err, check.IsNil)
+	c.Assert(broken.Owner, check.Equals, leaseOwner())
+	c.Assert(memStore.FileExists(lockPath), check.Equals, false)
+	c.Assert(l2.Lock(lockPath), check.IsNil)
+	c.Assert(l1.Unlock(lockPath), check.IsNil)
+	c.Assert(memStore.FileExists(lockPath), check.Equals, true)
+	c.Assert(l2.Unlock(lockPath), check.IsNil)
+
+	// The driver locking by itself wouldn't use leases
+	c.Assert(getLocker(memStore), check.Equals, memStore)
+	c.Assert(RegisterReadOnly(MEM_URL), check.IsNil)
+	driver, err := GetObjectStoreDriver(MEM_URL, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(getLocker(driver), check.IsNil)
+}
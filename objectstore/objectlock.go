@@ -0,0 +1,122 @@
+package objectstore
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+ObjectStoreRetainer is an optional interface of ObjectStoreDriver, for the
+destinations which can lock the files against deletion and overwriting for a
+retention period, e.g. S3 Object Lock. The driver retains the block files and
+the backup configs when they're written, see IsRetainedFile, so the backups
+cannot be destroyed within the period even if the credentials are stolen.
+*/
+type ObjectStoreRetainer interface {
+	// RetentionPeriod returns how long the files are retained since they're
+	// written, 0 if the retention is disabled
+	RetentionPeriod() time.Duration
+	// RetainedUntil returns when the retention of the file expires, zero
+	// time if it's not retained
+	RetainedUntil(filePath string) (time.Time, error)
+	// ExtendRetention retains the file until the time, nothing would be done
+	// if it's already retained longer
+	ExtendRetention(filePath string, until time.Time) error
+}
+
+// IsRetainedFile returns true for the files should be retained by the
+// ObjectStoreRetainer when written, which are the block files and the backup
+// configs
+func IsRetainedFile(filePath string) bool {
+	if strings.HasSuffix(filePath, BLOCK_FILE_SUFFIX) {
+		return true
+	}
+	dir, name := filepath.Split(filepath.Clean(filePath))
+	return filepath.Base(dir) == BACKUP_DIRECTORY && strings.HasPrefix(name, BACKUP_CONFIG_PREFIX) &&
+		strings.HasSuffix(name, CFG_SUFFIX)
+}
+
+// getRetainer returns nil if the retention is not enabled for the objectstore
+func getRetainer(driver ObjectStoreDriver) ObjectStoreRetainer {
+	if retainer, ok := unthrottled(driver).(ObjectStoreRetainer); ok && retainer.RetentionPeriod() > 0 {
+		return retainer
+	}
+	return nil
+}
+
+/*
+retainBackupBlocks extends the retention of the blocks of the backup, so the
+blocks shared with the earlier backups would be retained as long as the new
+backup. The blocks are extended for two periods at once, and the time is
+recorded as BlocksRetainedUntil of the backup. Only the blocks not used by the
+last backup need to be extended until the blocks of the last backup expire
+within a period, so most of the backups would only extend their new blocks.
+*/
+func retainBackupBlocks(backup, lastBackup *Backup, retainer ObjectStoreRetainer) error {
+	now := time.Now()
+	period := retainer.RetentionPeriod()
+	blkFiles := getBackupBlockFiles(backup)
+	until := now.Add(2 * period)
+	if lastBackup != nil {
+		lastUntil, err := ParseBackupTime(lastBackup.BlocksRetainedUntil)
+		if err == nil && !lastUntil.Before(now.Add(period)) {
+			until = lastUntil
+			for blkFile := range getBackupBlockFiles(lastBackup) {
+				delete(blkFiles, blkFile)
+			}
+		}
+	}
+	for blkFile := range blkFiles {
+		if err := retainer.ExtendRetention(blkFile, until); err != nil {
+			return err
+		}
+	}
+	log.Debugf("Retained %v blocks of backup %v until %v", len(blkFiles), backup.Name, until)
+	backup.BlocksRetainedUntil = until.Format(time.RFC3339)
+	return nil
+}
+
+// checkBackupRetention refuses to remove the backup whose config is still
+// retained, since it would be only hidden rather than removed, e.g. by a
+// delete marker of S3
+func checkBackupRetention(backup *Backup, driver ObjectStoreDriver) error {
+	retainer := getRetainer(driver)
+	if retainer == nil {
+		return nil
+	}
+	until, err := retainer.RetainedUntil(getBackupConfigPath(backup.Name, backup.VolumeName))
+	if err != nil {
+		return err
+	}
+	if until.After(time.Now()) {
+		return fmt.Errorf("Backup %v is retained until %v, it cannot be removed before that", backup.Name,
+			until.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// filterRetainedFiles returns the files can be removed, and the number of
+// the files still retained, which would be left to the later garbage
+// collection
+func filterRetainedFiles(files []string, driver ObjectStoreDriver) ([]string, int, error) {
+	retainer := getRetainer(driver)
+	if retainer == nil {
+		return files, 0, nil
+	}
+	now := time.Now()
+	result := []string{}
+	for _, f := range files {
+		until, err := retainer.RetainedUntil(f)
+		if err != nil {
+			return nil, 0, err
+		}
+		if until.After(now) {
+			log.Debugf("Skip %v which is retained until %v", f, until.Format(time.RFC3339))
+			continue
+		}
+		result = append(result, f)
+	}
+	return result, len(files) - len(result), nil
+}
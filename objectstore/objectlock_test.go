@@ -0,0 +1,83 @@
+package objectstore
+
+import (
+	"time"
+
+	"github.com/rancher/convoy/metadata"
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestIsRetainedFile(c *check.C) {
+	c.Assert(IsRetainedFile(getBlockFilePath(testVolumeName, BlockMapping{BlockChecksum: "aabbcc"})), check.Equals, true)
+	c.Assert(IsRetainedFile(getBackupConfigPath("backup-1", testVolumeName)), check.Equals, true)
+	c.Assert(IsRetainedFile(getVolumeFilePath(testVolumeName)), check.Equals, false)
+	c.Assert(IsRetainedFile(getCheckpointConfigPath("snapshot", testVolumeName)), check.Equals, false)
+	c.Assert(IsRetainedFile(getBlockIndexFilePath(testVolumeName)), check.Equals, false)
+	c.Assert(IsRetainedFile(getObjectStoreConfigPath()), check.Equals, false)
+}
+
+func (s *TestSuite) TestRetainedBackup(c *check.C) {
+	memStore.retention = 24 * time.Hour
+
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: 0, Size: 2 * DEFAULT_BLOCK_SIZE},
+		},
+	}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+		Size:   2 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL1, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-1"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	backup1, err := loadBackup(decodeTestBackupName(c, backupURL1), testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+
+	// The blocks are retained for two periods, and the config for one
+	now := time.Now()
+	blocksUntil, err := ParseBackupTime(backup1.BlocksRetainedUntil)
+	c.Assert(err, check.IsNil)
+	c.Assert(blocksUntil.After(now.Add(47*time.Hour)), check.Equals, true)
+	for _, blk := range backup1.Blocks {
+		until := memStore.retained[getBlockFilePath(testVolumeName, blk)]
+		c.Assert(until.After(now.Add(47*time.Hour)), check.Equals, true)
+	}
+	configUntil := memStore.retained[getBackupConfigPath(backup1.Name, testVolumeName)]
+	c.Assert(configUntil.After(now.Add(23*time.Hour)), check.Equals, true)
+	c.Assert(configUntil.Before(now.Add(25*time.Hour)), check.Equals, true)
+	c.Assert(backup1.RetainedUntil, check.Not(check.Equals), "")
+	info, err := GetBackupInfo(backupURL1, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(info["RetainedUntil"], check.Equals, backup1.RetainedUntil)
+
+	// The blocks of the last backup are retained long enough already
+	backupURL2, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-2"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	backup2, err := loadBackup(decodeTestBackupName(c, backupURL2), testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup2.BlocksRetainedUntil, check.Equals, backup1.BlocksRetainedUntil)
+
+	err = DeleteDeltaBlockBackup(backupURL2, "", false)
+	c.Assert(err, check.ErrorMatches, "Backup .* is retained until .*, it cannot be removed before that")
+
+	// The unused blocks still retained are left to the later collection
+	orphan := getBlockFilePath(testVolumeName, BlockMapping{BlockChecksum: "aabbcc"})
+	memStore.files[orphan] = []byte("orphan")
+	memStore.retained[orphan] = now.Add(time.Hour)
+	report, err := GarbageCollect(MEM_URL, "", testVolumeName, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.UnusedBlocks, check.Equals, 1)
+	c.Assert(report.RetainedBlocks, check.Equals, 1)
+	c.Assert(report.ReclaimedBytes, check.Equals, int64(0))
+	c.Assert(memStore.FileExists(orphan), check.Equals, true)
+
+	// The backups can be removed once the retention expired
+	for f := range memStore.retained {
+		memStore.retained[f] = now.Add(-time.Hour)
+	}
+	c.Assert(DeleteDeltaBlockBackup(backupURL2, "", false), check.IsNil)
+	c.Assert(DeleteDeltaBlockBackup(backupURL1, "", false), check.IsNil)
+	c.Assert(volumeExists(testVolumeName, memStore), check.Equals, false)
+	c.Assert(memStore.FileExists(orphan), check.Equals, false)
+}
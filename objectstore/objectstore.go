@@ -3,6 +3,7 @@ package objectstore
 import (
 	"fmt"
 	"net/url"
+	"os"
 	"strconv"
 
 	"github.com/rancher/convoy/util"
@@ -14,11 +15,18 @@ type Volume struct {
 	Size           int64
 	CreatedTime    string
 	LastBackupName string
+	// The format version of the config, see FORMAT_VERSION
+	FormatVersion int `json:",omitempty"`
 }
 
 type Snapshot struct {
 	Name        string
 	CreatedTime string
+	// Export the snapshot as a single image in the format along with the
+	// backup, see BackupImage
+	ExportImage string
+	// The user-defined labels of the backup, see ParseLabels
+	Labels map[string]string
 }
 
 type Backup struct {
@@ -28,9 +36,29 @@ type Backup struct {
 	SnapshotName      string
 	SnapshotCreatedAt string
 	CreatedTime       string
+	// The host created the backup
+	Host             string            `json:",omitempty"`
+	Labels           map[string]string `json:",omitempty"`
+	ParentBackupName string            `json:",omitempty"`
+	// The format version of the config, see FORMAT_VERSION
+	FormatVersion int `json:",omitempty"`
+	// The size of the blocks, DEFAULT_BLOCK_SIZE if it's 0
+	BlockSize int64 `json:",omitempty"`
+	// The hash algorithm of the block checksums, HASH_SHA512 if it's empty
+	Hash string `json:",omitempty"`
+	// How the volume was split into blocks, CHUNKING_FIXED if it's empty
+	Chunking string `json:",omitempty"`
 
 	Blocks     []BlockMapping `json:",omitempty"`
 	SingleFile BackupFile     `json:",omitempty"`
+	Image      *BackupImage   `json:",omitempty"`
+	Copy       *BackupCopy    `json:",omitempty"`
+	Stats      *BackupStats   `json:",omitempty"`
+
+	// The backup config cannot be removed until RetainedUntil, and the
+	// blocks until BlocksRetainedUntil, see ObjectStoreRetainer
+	RetainedUntil       string `json:",omitempty"`
+	BlocksRetainedUntil string `json:",omitempty"`
 }
 
 func addVolume(volume *Volume, driver ObjectStoreDriver) error {
@@ -38,6 +66,7 @@ func addVolume(volume *Volume, driver ObjectStoreDriver) error {
 		return nil
 	}
 
+	volume.FormatVersion = FORMAT_VERSION
 	if err := saveVolume(volume, driver); err != nil {
 		log.Error("Fail add volume ", volume.Name)
 		return err
@@ -47,6 +76,27 @@ func addVolume(volume *Volume, driver ObjectStoreDriver) error {
 	return nil
 }
 
+// lockVolume serializes the updates of the volume in the objectstore, see
+// getLocker. The returned function would release the lock.
+func lockVolume(volumeName string, driver ObjectStoreDriver) (func(), error) {
+	locker := getLocker(driver)
+	if locker == nil {
+		return func() {}, nil
+	}
+	lockPath := getVolumeLockPath(volumeName)
+	if err := locker.Lock(lockPath); err != nil {
+		return nil, err
+	}
+	log.Debugf("Locked volume %v in objectstore", volumeName)
+	return func() {
+		if err := locker.Unlock(lockPath); err != nil {
+			log.Warnf("Failed to unlock volume %v in objectstore: %v", volumeName, err)
+			return
+		}
+		log.Debugf("Unlocked volume %v in objectstore", volumeName)
+	}, nil
+}
+
 func removeVolume(volumeName string, driver ObjectStoreDriver) error {
 	if !volumeExists(volumeName, driver) {
 		return fmt.Errorf("Volume %v doesn't exist in objectstore", volumeName)
@@ -62,6 +112,15 @@ func removeVolume(volumeName string, driver ObjectStoreDriver) error {
 	return nil
 }
 
+// getHostname returns the name of the host, or empty if it's unknown
+func getHostname() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return hostname
+}
+
 func encodeBackupURL(backupName, volumeName, destURL string) string {
 	v := url.Values{}
 	v.Add("volume", volumeName)
@@ -98,7 +157,7 @@ func addListVolume(resp map[string]map[string]string, volumeName string, driver
 		return err
 	}
 	//Skip any volumes not owned by specified storage driver
-	if volume.Driver != storageDriverName {
+	if storageDriverName != "" && volume.Driver != storageDriverName {
 		return nil
 	}
 
@@ -113,6 +172,9 @@ func addListVolume(resp map[string]map[string]string, volumeName string, driver
 	return nil
 }
 
+// List returns the info of the backups in the objectstore, of the volume if
+// volumeName is specified, and of the volumes owned by storageDriverName if
+// it's specified, see fillBackupInfo
 func List(volumeName, destURL, endpointURL, storageDriverName string) (map[string]map[string]string, error) {
 	driver, err := GetObjectStoreDriver(destURL, endpointURL)
 	if err != nil {
@@ -138,7 +200,7 @@ func List(volumeName, destURL, endpointURL, storageDriverName string) (map[strin
 }
 
 func fillBackupInfo(backup *Backup, volume *Volume, destURL string) map[string]string {
-	return map[string]string{
+	info := map[string]string{
 		"BackupName":        backup.Name,
 		"BackupURL":         encodeBackupURL(backup.Name, backup.VolumeName, destURL),
 		"DriverName":        volume.Driver,
@@ -148,7 +210,64 @@ func fillBackupInfo(backup *Backup, volume *Volume, destURL string) map[string]s
 		"SnapshotName":      backup.SnapshotName,
 		"SnapshotCreatedAt": backup.SnapshotCreatedAt,
 		"CreatedTime":       backup.CreatedTime,
+		"BlockCount":        strconv.Itoa(len(backup.Blocks)),
+	}
+	if backup.Host != "" {
+		info["Host"] = backup.Host
+	}
+	if len(backup.Labels) != 0 {
+		info["Labels"] = EncodeLabels(backup.Labels)
+	}
+	if size := getBackupCompressedSize(backup); size > 0 {
+		info["CompressedSize"] = strconv.FormatInt(size, 10)
+	}
+	if backup.Image != nil {
+		info["ImageFormat"] = backup.Image.Format
+		info["ImagePath"] = backup.Image.FilePath
+		info["ImageSize"] = strconv.FormatInt(backup.Image.Size, 10)
+	}
+	if backup.Stats != nil {
+		info["ChangedBlocks"] = strconv.Itoa(backup.Stats.ChangedBlocks)
+		info["ChangedSize"] = strconv.FormatInt(backup.Stats.ChangedSize, 10)
+		info["NewBlocks"] = strconv.Itoa(backup.Stats.NewBlocks)
+		info["DedupedBlocks"] = strconv.Itoa(backup.Stats.DedupedBlocks)
+		info["ZeroBlocks"] = strconv.Itoa(backup.Stats.ZeroBlocks)
+		info["UploadedSize"] = strconv.FormatInt(backup.Stats.UploadedSize, 10)
+		info["Duration"] = backup.Stats.Duration
+	}
+	if backup.RetainedUntil != "" {
+		info["RetainedUntil"] = backup.RetainedUntil
+	}
+	if backup.Copy != nil {
+		info["CopyURL"] = backup.Copy.URL
+		info["CopyStatus"] = backup.Copy.Status
+		if backup.Copy.Error != "" {
+			info["CopyError"] = backup.Copy.Error
+		}
+	}
+	return info
+}
+
+// getBackupCompressedSize returns the bytes stored in the objectstore for the
+// backup, counting the blocks shared with the other backups as well. It's 0
+// if unknown, e.g. some blocks were created by older versions.
+func getBackupCompressedSize(backup *Backup) int64 {
+	if backup.SingleFile.FilePath != "" {
+		return backup.SingleFile.Size
+	}
+	size := int64(0)
+	counted := make(map[string]bool)
+	for _, blk := range getStoredBlocks(backup.Blocks) {
+		if blk.Size == 0 {
+			return 0
+		}
+		if counted[blk.BlockChecksum] {
+			continue
+		}
+		counted[blk.BlockChecksum] = true
+		size += blk.Size
 	}
+	return size
 }
 
 func GetBackupInfo(backupURL, endpointURL string) (map[string]string, error) {
@@ -0,0 +1,286 @@
+package objectstore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rancher/convoy/metadata"
+	"gopkg.in/check.v1"
+)
+
+const (
+	MEM_KIND = "mem"
+	MEM_URL  = "mem:///"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type TestSuite struct{}
+
+var _ = check.Suite(&TestSuite{})
+
+var memStore *memObjectStoreDriver
+
+// memObjectStoreDriver is an in-memory ObjectStoreDriver used for testing
+type memObjectStoreDriver struct {
+	mutex    *sync.Mutex
+	files    map[string][]byte
+	locks    map[string]bool
+	archived map[string]string
+	// The retained files cannot be removed, see ObjectStoreRetainer
+	retention time.Duration
+	retained  map[string]time.Time
+}
+
+func init() {
+	if err := RegisterDriver(MEM_KIND, func(destURL, endpoint string) (ObjectStoreDriver, error) {
+		return memStore, nil
+	}); err != nil {
+		panic(err)
+	}
+}
+
+func (s *TestSuite) SetUpTest(c *check.C) {
+	memStore = &memObjectStoreDriver{
+		mutex:    &sync.Mutex{},
+		files:    make(map[string][]byte),
+		locks:    make(map[string]bool),
+		archived: make(map[string]string),
+		retained: make(map[string]time.Time),
+	}
+	readOnlyURLs = nil
+}
+
+func (m *memObjectStoreDriver) Kind() string {
+	return MEM_KIND
+}
+
+func (m *memObjectStoreDriver) GetURL() string {
+	return MEM_URL
+}
+
+func (m *memObjectStoreDriver) FileExists(filePath string) bool {
+	return m.FileSize(filePath) >= 0
+}
+
+func (m *memObjectStoreDriver) FileSize(filePath string) int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	data, exists := m.files[filepath.Clean(filePath)]
+	if !exists {
+		return -1
+	}
+	return int64(len(data))
+}
+
+func (m *memObjectStoreDriver) Remove(names ...string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, name := range names {
+		name = filepath.Clean(name)
+		for f := range m.files {
+			if (f == name || strings.HasPrefix(f, name+"/")) && m.retained[f].After(time.Now()) {
+				return fmt.Errorf("%v is retained", f)
+			}
+		}
+		for f := range m.files {
+			if f == name || strings.HasPrefix(f, name+"/") {
+				delete(m.files, f)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *memObjectStoreDriver) Read(src string) (io.ReadCloser, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	data, exists := m.files[filepath.Clean(src)]
+	if !exists {
+		return nil, fmt.Errorf("Cannot find %v", src)
+	}
+	if _, archived := m.archived[filepath.Clean(src)]; archived {
+		return nil, fmt.Errorf("%v is archived", src)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memObjectStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	data, err := ioutil.ReadAll(rs)
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	m.files[filepath.Clean(dst)] = data
+	if m.retention > 0 && IsRetainedFile(dst) {
+		m.retained[filepath.Clean(dst)] = time.Now().Add(m.retention)
+	}
+	m.mutex.Unlock()
+	return nil
+}
+
+func (m *memObjectStoreDriver) List(path string) ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	prefix := filepath.Clean(path) + "/"
+	if prefix == "./" {
+		prefix = ""
+	}
+	names := map[string]bool{}
+	for f := range m.files {
+		if !strings.HasPrefix(f, prefix) {
+			continue
+		}
+		names[strings.SplitN(strings.TrimPrefix(f, prefix), "/", 2)[0]] = true
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("Cannot find %v", path)
+	}
+	result := []string{}
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func (m *memObjectStoreDriver) Upload(src, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	return m.Write(dst, bytes.NewReader(data))
+}
+
+func (m *memObjectStoreDriver) Download(src, dst string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	data, exists := m.files[filepath.Clean(src)]
+	if !exists {
+		return fmt.Errorf("Cannot find %v", src)
+	}
+	return ioutil.WriteFile(dst, data, 0600)
+}
+
+func (m *memObjectStoreDriver) Lock(lockPath string) error {
+	if m.locks[lockPath] {
+		return fmt.Errorf("%v is locked", lockPath)
+	}
+	m.locks[lockPath] = true
+	return nil
+}
+
+func (m *memObjectStoreDriver) Unlock(lockPath string) error {
+	if !m.locks[lockPath] {
+		return fmt.Errorf("%v is not locked", lockPath)
+	}
+	delete(m.locks, lockPath)
+	return nil
+}
+
+func (m *memObjectStoreDriver) ArchiveStatus(filePath string) (string, error) {
+	if !m.FileExists(filePath) {
+		return "", fmt.Errorf("Cannot find %v", filePath)
+	}
+	if status, archived := m.archived[filepath.Clean(filePath)]; archived {
+		return status, nil
+	}
+	return ARCHIVE_STATUS_AVAILABLE, nil
+}
+
+func (m *memObjectStoreDriver) RetrieveFile(filePath string) error {
+	m.archived[filepath.Clean(filePath)] = ARCHIVE_STATUS_RETRIEVING
+	return nil
+}
+
+func (m *memObjectStoreDriver) RetentionPeriod() time.Duration {
+	return m.retention
+}
+
+func (m *memObjectStoreDriver) RetainedUntil(filePath string) (time.Time, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.retained[filepath.Clean(filePath)], nil
+}
+
+func (m *memObjectStoreDriver) ExtendRetention(filePath string, until time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.retained[filepath.Clean(filePath)].Before(until) {
+		m.retained[filepath.Clean(filePath)] = until
+	}
+	return nil
+}
+
+func (s *TestSuite) TestBackupInfo(c *check.C) {
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: 0, Size: 4 * DEFAULT_BLOCK_SIZE},
+		},
+	}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+		Size:   4 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+
+	info, err := GetBackupInfo(backupURL, "")
+	c.Assert(err, check.IsNil)
+	hostname, err := os.Hostname()
+	c.Assert(err, check.IsNil)
+	c.Assert(info["Host"], check.Equals, hostname)
+	c.Assert(info["VolumeSize"], check.Equals, strconv.FormatInt(volume.Size, 10))
+	c.Assert(info["BlockCount"], check.Equals, "4")
+	// Block 0 and 3 have the same content, and are stored only once
+	backupName, _, err := decodeBackupURL(backupURL)
+	c.Assert(err, check.IsNil)
+	backup, err := loadBackup(backupName, testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	size := int64(0)
+	for _, blk := range backup.Blocks[:3] {
+		blkSize := memStore.FileSize(getBlockFilePath(testVolumeName, blk))
+		c.Assert(blk.Size, check.Equals, blkSize)
+		size += blkSize
+	}
+	c.Assert(backup.Blocks[3].Size, check.Equals, backup.Blocks[0].Size)
+	c.Assert(info["CompressedSize"], check.Equals, strconv.FormatInt(size, 10))
+
+	// The blocks created by older versions have no size
+	backup.Blocks[1].Size = 0
+	c.Assert(saveBackup(backup, memStore), check.IsNil)
+	info, err = GetBackupInfo(backupURL, "")
+	c.Assert(err, check.IsNil)
+	_, exists := info["CompressedSize"]
+	c.Assert(exists, check.Equals, false)
+
+	// The backups of all the drivers would be listed without driver name
+	backups, err := List("", MEM_URL, "", "other")
+	c.Assert(err, check.IsNil)
+	c.Assert(backups, check.HasLen, 0)
+	backups, err = List("", MEM_URL, "", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(backups, check.HasLen, 1)
+	c.Assert(backups[backupURL]["BlockCount"], check.Equals, "4")
+}
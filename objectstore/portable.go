@@ -0,0 +1,320 @@
+package objectstore
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+/*
+The portable archive of a backup is a tarball of the volume config, the
+backup config and the block files referenced by the backup, in this order,
+so it can be imported in one pass. The configs and blocks are decrypted, so
+the archive can be imported into any objectstore, while the blocks are kept
+compressed as they are in the objectstore.
+*/
+const (
+	PORTABLE_VOLUME_CONFIG = "volume.cfg"
+	PORTABLE_BACKUP_CONFIG = "backup.cfg"
+	PORTABLE_BLOCKS_PREFIX = "blocks/"
+)
+
+func getPortableBlockName(block BlockMapping) string {
+	return PORTABLE_BLOCKS_PREFIX + block.BlockChecksum + compressionSuffixes[blockCompression(block)] + BLOCK_FILE_SUFFIX
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// ExportBackup writes the backup and the blocks it references to filePath as
+// a portable archive, which can be imported by ImportBackup
+func ExportBackup(backupURL, endpoint, filePath string) error {
+	driver, err := GetObjectStoreDriver(backupURL, endpoint)
+	if err != nil {
+		return err
+	}
+	backupName, volumeName, err := decodeBackupURL(backupURL)
+	if err != nil {
+		return err
+	}
+	volume, err := loadVolume(volumeName, driver)
+	if err != nil {
+		return err
+	}
+	backup, err := loadBackup(backupName, volumeName, driver)
+	if err != nil {
+		return err
+	}
+	if backup.SingleFile.FilePath != "" {
+		return fmt.Errorf("Cannot export single file backup %v", backupName)
+	}
+	aead, err := getObjectStoreCipher(driver)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	if err := exportBackup(f, volume, backup, aead, driver); err != nil {
+		f.Close()
+		os.Remove(filePath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(filePath)
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:     LOG_REASON_COMPLETE,
+		LOG_FIELD_BACKUP_URL: backupURL,
+		LOG_FIELD_FILEPATH:   filePath,
+	}).Debugf("Exported backup with %v blocks", len(backup.Blocks))
+	return nil
+}
+
+func exportBackup(w io.Writer, volume *Volume, backup *Backup, aead *objectCipher, driver ObjectStoreDriver) error {
+	tw := tar.NewWriter(w)
+
+	// The backup would be the only one of the volume in the archive
+	volume.LastBackupName = ""
+	backup.ParentBackupName = ""
+	backup.Image = nil
+	backup.Copy = nil
+	for _, v := range []struct {
+		name string
+		obj  interface{}
+	}{
+		{PORTABLE_VOLUME_CONFIG, volume},
+		{PORTABLE_BACKUP_CONFIG, backup},
+	} {
+		j, err := json.Marshal(v.obj)
+		if err != nil {
+			return err
+		}
+		if err := writeTarFile(tw, v.name, j); err != nil {
+			return err
+		}
+	}
+
+	written := map[string]bool{}
+	for _, blk := range getStoredBlocks(backup.Blocks) {
+		name := getPortableBlockName(blk)
+		if written[name] {
+			continue
+		}
+		written[name] = true
+
+		blkFile := getBlockFilePath(backup.VolumeName, blk)
+		rc, err := driver.Read(blkFile)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		if aead != nil {
+			if data, err = decryptData(aead, blkFile, data); err != nil {
+				return err
+			}
+		}
+		if err := writeTarFile(tw, name, data); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+/*
+ImportBackup imports the backup in the portable archive at filePath into the
+objectstore at destURL, and returns the URL of the imported backup. The
+volume would be added if it doesn't exist in the objectstore. The backup is
+imported as a full backup not based on any other backup, and its blocks are
+verified before written. The backup with the same name cannot be imported
+twice.
+*/
+func ImportBackup(filePath, destURL, endpoint string) (string, error) {
+	driver, err := GetObjectStoreDriver(destURL, endpoint)
+	if err != nil {
+		return "", err
+	}
+	if err := checkWritable(driver); err != nil {
+		return "", err
+	}
+	config, err := initObjectStoreConfig(driver)
+	if err != nil {
+		return "", err
+	}
+	aead, err := config.getCipher()
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+
+	volume := &Volume{}
+	backup := &Backup{}
+	for _, v := range []struct {
+		name string
+		obj  interface{}
+	}{
+		{PORTABLE_VOLUME_CONFIG, volume},
+		{PORTABLE_BACKUP_CONFIG, backup},
+	} {
+		hdr, err := tr.Next()
+		if err != nil {
+			return "", fmt.Errorf("Invalid backup archive %v: %v", filePath, err)
+		}
+		if hdr.Name != v.name {
+			return "", fmt.Errorf("Invalid backup archive %v: expect %v but got %v", filePath, v.name, hdr.Name)
+		}
+		if err := json.NewDecoder(tr).Decode(v.obj); err != nil {
+			return "", fmt.Errorf("Invalid backup archive %v: %v", filePath, err)
+		}
+	}
+	if err := checkFormatVersion(PORTABLE_BACKUP_CONFIG, backup.FormatVersion); err != nil {
+		return "", err
+	}
+	if err := ValidateHash(backup.getHash()); err != nil {
+		return "", err
+	}
+	upgradeBackup(backup)
+	if backup.VolumeName != volume.Name || len(backup.Blocks) == 0 {
+		return "", fmt.Errorf("Invalid backup archive %v: no block of volume %v", filePath, volume.Name)
+	}
+
+	unlock, err := lockVolume(volume.Name, driver)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	// The encryption key may have been rotated while waiting for the lock
+	if aead, err = getObjectStoreCipher(driver); err != nil {
+		return "", err
+	}
+
+	if backupExists(backup.Name, volume.Name, driver) {
+		return "", fmt.Errorf("Backup %v of volume %v already exists in objectstore", backup.Name, volume.Name)
+	}
+	if volumeExists(volume.Name, driver) {
+		existing, err := loadVolume(volume.Name, driver)
+		if err != nil {
+			return "", err
+		}
+		if existing.Driver != volume.Driver {
+			return "", fmt.Errorf("Volume %v exists in objectstore with driver %v rather than %v",
+				volume.Name, existing.Driver, volume.Driver)
+		}
+	} else if err := addVolume(volume, driver); err != nil {
+		return "", err
+	}
+
+	// The blocks are placed as the objectstore configured
+	blocks := map[string]BlockMapping{}
+	for i := range backup.Blocks {
+		if isZeroBlock(backup.Blocks[i]) {
+			continue
+		}
+		backup.Blocks[i].Shared = config.SharedBlocks
+		blocks[getPortableBlockName(backup.Blocks[i])] = backup.Blocks[i]
+	}
+	total := len(blocks)
+	keyIDs := map[string]string{}
+	for len(blocks) != 0 {
+		hdr, err := tr.Next()
+		if err != nil {
+			return "", fmt.Errorf("Invalid backup archive %v: %v, %v of %v blocks imported",
+				filePath, err, total-len(blocks), total)
+		}
+		block, ok := blocks[hdr.Name]
+		if !ok || !strings.HasPrefix(hdr.Name, PORTABLE_BLOCKS_PREFIX) {
+			return "", fmt.Errorf("Invalid backup archive %v: unexpected file %v", filePath, hdr.Name)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return "", err
+		}
+		keyID, err := importBlock(volume.Name, block, backup.getHash(), data, aead, driver)
+		if err != nil {
+			return "", err
+		}
+		keyIDs[hdr.Name] = keyID
+		delete(blocks, hdr.Name)
+	}
+	for i := range backup.Blocks {
+		if !isZeroBlock(backup.Blocks[i]) {
+			backup.Blocks[i].EncryptionKeyID = keyIDs[getPortableBlockName(backup.Blocks[i])]
+		}
+	}
+
+	if err := addBlockRefs(volume.Name, backup.Blocks, driver); err != nil {
+		return "", err
+	}
+	if err := indexBackup(backup, driver); err != nil {
+		return "", err
+	}
+	if err := saveBackup(backup, driver); err != nil {
+		return "", err
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
+		LOG_FIELD_VOLUME:   volume.Name,
+		LOG_FIELD_FILEPATH: filePath,
+		LOG_FIELD_DEST_URL: destURL,
+	}).Debugf("Imported backup %v with %v blocks", backup.Name, total)
+	return encodeBackupURL(backup.Name, volume.Name, destURL), nil
+}
+
+// importBlock verifies the block in the archive, and writes it to the
+// objectstore if it doesn't exist yet. It returns the ID of the key encrypted
+// the block written, see BlockMapping.EncryptionKeyID.
+func importBlock(volumeName string, block BlockMapping, hash string, data []byte, aead *objectCipher,
+	driver ObjectStoreDriver) (string, error) {
+	if _, err := decompressBlock(blockCompression(block), hash, bytes.NewReader(data), block.BlockChecksum); err != nil {
+		return "", fmt.Errorf("Invalid block %v in backup archive: %v", block.BlockChecksum, err)
+	}
+	blkFile := getBlockFilePath(volumeName, block)
+	if driver.FileSize(blkFile) >= 0 {
+		log.Debugf("Found existed block match at %v", blkFile)
+		return "", nil
+	}
+	if aead != nil {
+		var err error
+		if data, err = encryptData(aead, blkFile, data); err != nil {
+			return "", err
+		}
+	}
+	if err := driver.Write(blkFile, bytes.NewReader(data)); err != nil {
+		return "", err
+	}
+	return aead.currentKeyID(), nil
+}
@@ -0,0 +1,69 @@
+package objectstore
+
+import (
+	"io/ioutil"
+	"sync"
+
+	"github.com/rancher/convoy/metadata"
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestExportImportBackup(c *check.C) {
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: 0, Size: 3 * DEFAULT_BLOCK_SIZE},
+		},
+	}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+		Size:   3 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	backupName, _, err := decodeBackupURL(backupURL)
+	c.Assert(err, check.IsNil)
+	backup, err := loadBackup(backupName, testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+
+	filePath := c.MkDir() + "/backup.tar"
+	c.Assert(ExportBackup(backupURL, "", filePath), check.IsNil)
+
+	// Import into an empty objectstore
+	memStore = &memObjectStoreDriver{
+		mutex:    &sync.Mutex{},
+		files:    make(map[string][]byte),
+		locks:    make(map[string]bool),
+		archived: make(map[string]string),
+	}
+	importedURL, err := ImportBackup(filePath, MEM_URL, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(importedURL, check.Equals, backupURL)
+
+	imported, err := loadBackup(backupName, testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(imported.ParentBackupName, check.Equals, "")
+	c.Assert(imported.Blocks, check.DeepEquals, backup.Blocks)
+	importedVolume, err := loadVolume(testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(importedVolume.Size, check.Equals, volume.Size)
+	c.Assert(importedVolume.LastBackupName, check.Equals, "")
+
+	report, err := VerifyBackup(importedURL, "", 100)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.MissingBlocks, check.HasLen, 0)
+	c.Assert(report.CorruptBlocks, check.HasLen, 0)
+
+	_, err = ImportBackup(filePath, MEM_URL, "")
+	c.Assert(err, check.ErrorMatches, "Backup .* already exists.*")
+
+	// The corrupted block would be rejected, the last block file is followed
+	// by two empty records of 512 bytes
+	c.Assert(DeleteDeltaBlockBackup(importedURL, "", false), check.IsNil)
+	data, err := ioutil.ReadFile(filePath)
+	c.Assert(err, check.IsNil)
+	data[len(data)-1536+16] ^= 0xff
+	c.Assert(ioutil.WriteFile(filePath, data, 0600), check.IsNil)
+	_, err = ImportBackup(filePath, MEM_URL, "")
+	c.Assert(err, check.ErrorMatches, "Invalid block .* in backup archive.*")
+}
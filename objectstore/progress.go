@@ -0,0 +1,110 @@
+package objectstore
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	PROGRESS_BACKUP  = "backup"
+	PROGRESS_RESTORE = "restore"
+)
+
+// Progress is the status of a running backup or restore
+type Progress struct {
+	Operation        string
+	TotalBlocks      int
+	ProcessedBlocks  int
+	TransferredBytes int64
+	StartedAt        time.Time
+}
+
+// ETA estimates the remaining time by the average speed so far. It returns
+// -1 if nothing has been processed yet.
+func (p Progress) ETA(now time.Time) time.Duration {
+	if p.ProcessedBlocks == 0 {
+		return -1
+	}
+	elapsed := now.Sub(p.StartedAt)
+	remaining := p.TotalBlocks - p.ProcessedBlocks
+	return elapsed * time.Duration(remaining) / time.Duration(p.ProcessedBlocks)
+}
+
+type progressTracker struct {
+	key      string
+	mutex    *sync.Mutex
+	progress Progress
+}
+
+var (
+	progressesMutex = &sync.Mutex{}
+	progresses      = map[string]*progressTracker{}
+)
+
+// BackupProgressKey identifies the progress of backing up the snapshot
+func BackupProgressKey(volumeName, snapshotName string) string {
+	return PROGRESS_BACKUP + "/" + volumeName + "/" + snapshotName
+}
+
+// RestoreProgressKey identifies the progress of restoring the backup
+func RestoreProgressKey(backupURL string) string {
+	return PROGRESS_RESTORE + "/" + backupURL
+}
+
+func startProgress(key, operation string, totalBlocks int) *progressTracker {
+	t := &progressTracker{
+		key:   key,
+		mutex: &sync.Mutex{},
+		progress: Progress{
+			Operation:   operation,
+			TotalBlocks: totalBlocks,
+			StartedAt:   time.Now(),
+		},
+	}
+	progressesMutex.Lock()
+	progresses[key] = t
+	progressesMutex.Unlock()
+	return t
+}
+
+func (t *progressTracker) add(blocks int, bytes int64) {
+	t.mutex.Lock()
+	t.progress.ProcessedBlocks += blocks
+	t.progress.TransferredBytes += bytes
+	t.mutex.Unlock()
+}
+
+func (t *progressTracker) finish() {
+	progressesMutex.Lock()
+	if progresses[t.key] == t {
+		delete(progresses, t.key)
+	}
+	progressesMutex.Unlock()
+}
+
+// GetProgress returns the progress of the running operation identified by
+// key, or false if it's not running
+func GetProgress(key string) (Progress, bool) {
+	progressesMutex.Lock()
+	t, ok := progresses[key]
+	progressesMutex.Unlock()
+	if !ok {
+		return Progress{}, false
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.progress, true
+}
+
+// countingReader counts the bytes read from the objectstore
+type countingReader struct {
+	io.Reader
+	count int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
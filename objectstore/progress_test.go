@@ -0,0 +1,58 @@
+package objectstore
+
+import (
+	"time"
+
+	"github.com/rancher/convoy/metadata"
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestProgress(c *check.C) {
+	key := BackupProgressKey(testVolumeName, "snapshot")
+	_, ok := GetProgress(key)
+	c.Assert(ok, check.Equals, false)
+
+	tracker := startProgress(key, PROGRESS_BACKUP, 4)
+	tracker.add(1, 100)
+	tracker.add(1, 50)
+	progress, ok := GetProgress(key)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(progress.Operation, check.Equals, PROGRESS_BACKUP)
+	c.Assert(progress.TotalBlocks, check.Equals, 4)
+	c.Assert(progress.ProcessedBlocks, check.Equals, 2)
+	c.Assert(progress.TransferredBytes, check.Equals, int64(150))
+
+	tracker.finish()
+	_, ok = GetProgress(key)
+	c.Assert(ok, check.Equals, false)
+
+	// Backup would remove its progress once completed
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: 0, Size: 2 * DEFAULT_BLOCK_SIZE},
+		},
+	}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+	}
+	_, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	_, ok = GetProgress(key)
+	c.Assert(ok, check.Equals, false)
+}
+
+func (s *TestSuite) TestProgressETA(c *check.C) {
+	start := time.Date(2016, 2, 27, 10, 0, 0, 0, time.UTC)
+	progress := Progress{
+		TotalBlocks: 10,
+		StartedAt:   start,
+	}
+	c.Assert(progress.ETA(start.Add(time.Minute)), check.Equals, time.Duration(-1))
+
+	progress.ProcessedBlocks = 4
+	c.Assert(progress.ETA(start.Add(2*time.Minute)), check.Equals, 3*time.Minute)
+
+	progress.ProcessedBlocks = 10
+	c.Assert(progress.ETA(start.Add(5*time.Minute)), check.Equals, time.Duration(0))
+}
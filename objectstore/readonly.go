@@ -0,0 +1,79 @@
+package objectstore
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+var (
+	readOnlyMutex sync.RWMutex
+	readOnlyURLs  []string
+)
+
+// readOnlyDriver rejects all the updates to the objectstore registered as
+// read-only, e.g. the primary backup repository seen from a DR site
+type readOnlyDriver struct {
+	ObjectStoreDriver
+}
+
+// RegisterReadOnly registers the destination URL and everything under it as
+// read-only. Backups in it can still be listed, inspected and restored.
+func RegisterReadOnly(destURL string) error {
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return err
+	}
+	if _, exists := initializers[u.Scheme]; !exists {
+		return fmt.Errorf("Driver %v is not supported!", u.Scheme)
+	}
+
+	readOnlyMutex.Lock()
+	defer readOnlyMutex.Unlock()
+	readOnlyURLs = append(readOnlyURLs, normalizeURL(destURL))
+	log.Debugf("Registered objectstore %v as read-only", destURL)
+	return nil
+}
+
+func normalizeURL(destURL string) string {
+	if i := strings.Index(destURL, "?"); i >= 0 {
+		destURL = destURL[:i]
+	}
+	return strings.TrimRight(destURL, "/")
+}
+
+func isReadOnly(destURLs ...string) bool {
+	readOnlyMutex.RLock()
+	defer readOnlyMutex.RUnlock()
+	for _, destURL := range destURLs {
+		u := normalizeURL(destURL)
+		for _, r := range readOnlyURLs {
+			if u == r || strings.HasPrefix(u, r+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkWritable is used to fail early, before any work has been done
+func checkWritable(driver ObjectStoreDriver) error {
+	if _, ok := driver.(*readOnlyDriver); ok {
+		return fmt.Errorf("Objectstore %v is registered as read-only", driver.GetURL())
+	}
+	return nil
+}
+
+func (d *readOnlyDriver) Remove(names ...string) error {
+	return checkWritable(d)
+}
+
+func (d *readOnlyDriver) Write(dst string, rs io.ReadSeeker) error {
+	return checkWritable(d)
+}
+
+func (d *readOnlyDriver) Upload(src, dst string) error {
+	return checkWritable(d)
+}
@@ -0,0 +1,55 @@
+package objectstore
+
+import (
+	"bytes"
+
+	"gopkg.in/check.v1"
+)
+
+// unusedDeltaOps would panic if called
+type unusedDeltaOps struct {
+	DeltaBlockBackupOperations
+}
+
+func (s *TestSuite) TestRegisterReadOnly(c *check.C) {
+	c.Assert(RegisterReadOnly("vfs:///var/backup/"), check.ErrorMatches, "Driver vfs is not supported!")
+	c.Assert(RegisterReadOnly(MEM_URL+"primary/"), check.IsNil)
+
+	c.Check(isReadOnly(MEM_URL+"primary"), check.Equals, true)
+	c.Check(isReadOnly(MEM_URL+"primary/?backup=backup-1&volume=vol"), check.Equals, true)
+	c.Check(isReadOnly(MEM_URL+"primary/sub"), check.Equals, true)
+	c.Check(isReadOnly(MEM_URL+"primary2"), check.Equals, false)
+	c.Check(isReadOnly(MEM_URL), check.Equals, false)
+}
+
+func (s *TestSuite) TestReadOnlyDriver(c *check.C) {
+	s.createTestBackupChain(c)
+	c.Assert(RegisterReadOnly(MEM_URL), check.IsNil)
+
+	backupURL := encodeBackupURL("backup-3", testVolumeName, MEM_URL)
+	info, err := GetBackupInfo(backupURL, "")
+	c.Assert(err, check.IsNil)
+	c.Check(info["BackupName"], check.Equals, "backup-3")
+
+	backups, err := List(testVolumeName, MEM_URL, "", "test")
+	c.Assert(err, check.IsNil)
+	c.Check(backups, check.HasLen, 3)
+
+	err = DeleteDeltaBlockBackup(backupURL, "", false)
+	c.Assert(err, check.ErrorMatches, "Objectstore mem:/// is registered as read-only")
+	c.Check(backupExists("backup-3", testVolumeName, memStore), check.Equals, true)
+
+	err = DeleteSingleFileBackup(backupURL, "")
+	c.Assert(err, check.ErrorMatches, ".* is registered as read-only")
+
+	_, err = CreateDeltaBlockBackup(&Volume{Name: testVolumeName}, &Snapshot{Name: "snapshot"}, MEM_URL, "", &unusedDeltaOps{})
+	c.Assert(err, check.ErrorMatches, ".* is registered as read-only")
+
+	driver, err := GetObjectStoreDriver(MEM_URL, "")
+	c.Assert(err, check.IsNil)
+	c.Check(driver.Write("file", bytes.NewReader([]byte("data"))), check.NotNil)
+	c.Check(driver.Upload("/dev/null", "file"), check.NotNil)
+	c.Check(driver.Remove(getVolumePath(testVolumeName)), check.NotNil)
+	c.Check(memStore.FileExists("file"), check.Equals, false)
+	c.Check(volumeExists(testVolumeName, memStore), check.Equals, true)
+}
@@ -0,0 +1,183 @@
+package objectstore
+
+import (
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+// RepairReport is the result of the repair of the blocks reported missing or
+// corrupted by the verification of a backup, see RepairDeltaBlockBackup
+type RepairReport struct {
+	BackupURL      string
+	BackupName     string
+	VolumeName     string
+	RepairedBlocks []BlockReport
+	FailedBlocks   []BlockReport
+	Valid          bool
+}
+
+/*
+RepairDeltaBlockBackup uploads the blocks reported missing or corrupted in
+the verify report again, by reading them from the snapshot of the backup,
+which must still exist in the local storage. A block can only be repaired if
+the snapshot still has the same content at one of its offsets, which is
+checked against the checksum of the block. The block files are shared by the
+backups, so the other backups using them would be repaired as well. The
+backup is valid once all the damaged blocks have been repaired.
+*/
+func RepairDeltaBlockBackup(report *VerifyReport, endpoint string, deltaOps DeltaBlockBackupOperations) (*RepairReport, error) {
+	if deltaOps == nil {
+		return nil, fmt.Errorf("Missing DeltaBlockBackupOperations")
+	}
+	deltaOps = throttleSnapshotReads(deltaOps)
+	driver, err := GetObjectStoreDriver(report.BackupURL, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkWritable(driver); err != nil {
+		return nil, err
+	}
+	backupName, volumeName, err := decodeBackupURL(report.BackupURL)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := lockVolume(volumeName, driver)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	volume, err := loadVolume(volumeName, driver)
+	if err != nil {
+		return nil, err
+	}
+	backup, err := loadBackup(backupName, volumeName, driver)
+	if err != nil {
+		return nil, err
+	}
+	if backup.SingleFile.FilePath != "" {
+		return nil, fmt.Errorf("Cannot repair backup %v, which is a single file backup", backupName)
+	}
+	hash := backup.getHash()
+	if err := ValidateHash(hash); err != nil {
+		return nil, err
+	}
+	aead, err := getObjectStoreCipher(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RepairReport{
+		BackupURL:      report.BackupURL,
+		BackupName:     backupName,
+		VolumeName:     volumeName,
+		RepairedBlocks: []BlockReport{},
+		FailedBlocks:   []BlockReport{},
+	}
+	damaged := append(append([]BlockReport{}, report.MissingBlocks...), report.CorruptBlocks...)
+	if len(damaged) == 0 {
+		result.Valid = true
+		return result, nil
+	}
+	if !deltaOps.HasSnapshot(backup.SnapshotName, volumeName) {
+		return nil, fmt.Errorf("Cannot repair backup %v, snapshot %v of volume %v doesn't exist any more",
+			backupName, backup.SnapshotName, volumeName)
+	}
+	if err := deltaOps.OpenSnapshot(backup.SnapshotName, volumeName); err != nil {
+		return nil, err
+	}
+	defer deltaOps.CloseSnapshot(backup.SnapshotName, volumeName)
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_REPAIR,
+		LOG_FIELD_VOLUME:   volumeName,
+		LOG_FIELD_SNAPSHOT: backup.SnapshotName,
+	}).Debugf("Repairing %v blocks of backup %v", len(damaged), backupName)
+
+	blocks := map[string][]BlockMapping{}
+	for _, blk := range getStoredBlocks(backup.Blocks) {
+		blkFile := getBlockFilePath(volumeName, blk)
+		blocks[blkFile] = append(blocks[blkFile], blk)
+	}
+	for _, d := range damaged {
+		err := fmt.Errorf("%v is not used by backup %v", d.File, backupName)
+		if mappings, ok := blocks[d.File]; ok {
+			err = repairBlock(d.File, mappings, backup, volume, hash, aead, deltaOps, driver)
+		}
+		if err != nil {
+			d.Error = err.Error()
+			result.FailedBlocks = append(result.FailedBlocks, d)
+			continue
+		}
+		d.Error = ""
+		result.RepairedBlocks = append(result.RepairedBlocks, d)
+	}
+	result.Valid = len(result.FailedBlocks) == 0
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:    LOG_EVENT_REPAIR,
+		LOG_FIELD_VOLUME:   volumeName,
+		LOG_FIELD_SNAPSHOT: backup.SnapshotName,
+	}).Debugf("Repaired %v blocks of backup %v, %v failed", len(result.RepairedBlocks), backupName,
+		len(result.FailedBlocks))
+	return result, nil
+}
+
+// repairBlock writes the block file again from the content of the snapshot
+// at the offsets of the mappings, the first one matches the checksum is used
+func repairBlock(blkFile string, mappings []BlockMapping, backup *Backup, volume *Volume, hash string, aead *objectCipher,
+	deltaOps DeltaBlockBackupOperations, driver ObjectStoreDriver) error {
+	blockSize := backup.getBlockSize()
+	for _, blk := range mappings {
+		length := getBlockLength(blk, blockSize)
+		if volume.Size > 0 && blk.Offset+length > volume.Size {
+			length = volume.Size - blk.Offset
+		}
+		data, err := readSnapshotRange(blk.Offset, length, blockSize, volume.Size, backup.SnapshotName, volume.Name, deltaOps)
+		if err != nil {
+			return err
+		}
+		if getChecksum(hash, data) != blk.BlockChecksum {
+			log.Debugf("Content of snapshot %v at %v doesn't match block %v", backup.SnapshotName, blk.Offset, blkFile)
+			continue
+		}
+		rs, _, err := encodeBlock(blkFile, blockCompression(blk), data, aead)
+		if err != nil {
+			return err
+		}
+		if err := driver.Write(blkFile, rs); err != nil {
+			return err
+		}
+		log.Debugf("Repaired block %v from snapshot %v at %v", blkFile, backup.SnapshotName, blk.Offset)
+		return nil
+	}
+	return fmt.Errorf("snapshot %v no longer has the content of the block", backup.SnapshotName)
+}
+
+// readSnapshotRange reads the range of the snapshot by the blocks covering
+// it, since the drivers read the snapshot by blocks
+func readSnapshotRange(offset, length, blockSize, volumeSize int64, snapshotName, volumeName string,
+	deltaOps DeltaBlockBackupOperations) ([]byte, error) {
+	start := offset / blockSize * blockSize
+	end := (offset + length + blockSize - 1) / blockSize * blockSize
+	if volumeSize > 0 && end > volumeSize {
+		end = volumeSize
+	}
+	data := make([]byte, end-start)
+	for pos := start; pos < end; pos += blockSize {
+		n := blockSize
+		if pos+n > end {
+			n = end - pos
+		}
+		if err := deltaOps.ReadSnapshot(snapshotName, volumeName, pos, data[pos-start:pos-start+n]); err != nil {
+			return nil, err
+		}
+	}
+	return data[offset-start : offset-start+length], nil
+}
@@ -0,0 +1,91 @@
+package objectstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/rancher/convoy/metadata"
+	"gopkg.in/check.v1"
+)
+
+// liveDeltaOps keeps the snapshots of fakeDeltaOps in local storage
+type liveDeltaOps struct {
+	*fakeDeltaOps
+	removed bool
+}
+
+func (l *liveDeltaOps) HasSnapshot(id, volumeID string) bool {
+	return !l.removed
+}
+
+func (s *TestSuite) TestRepairDeltaBlockBackup(c *check.C) {
+	deltaOps := &liveDeltaOps{
+		fakeDeltaOps: &fakeDeltaOps{
+			mappings: []metadata.Mapping{
+				{Offset: 0, Size: 3 * DEFAULT_BLOCK_SIZE},
+			},
+		},
+	}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+		Size:   3 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	backup, err := loadBackup(decodeTestBackupName(c, backupURL), testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	missing := getBlockFilePath(testVolumeName, backup.Blocks[0])
+	corrupt := getBlockFilePath(testVolumeName, backup.Blocks[1])
+	c.Assert(memStore.Remove(missing), check.IsNil)
+	c.Assert(memStore.Write(corrupt, bytes.NewReader([]byte("corrupt"))), check.IsNil)
+
+	report, err := VerifyBackup(backupURL, "", 100)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Valid, check.Equals, false)
+
+	deltaOps.removed = true
+	_, err = RepairDeltaBlockBackup(report, "", deltaOps)
+	c.Assert(err, check.ErrorMatches, "Cannot repair backup .*, snapshot snapshot of volume test-volume doesn't exist any more")
+
+	deltaOps.removed = false
+	repaired, err := RepairDeltaBlockBackup(report, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	c.Assert(repaired.Valid, check.Equals, true)
+	c.Assert(repaired.RepairedBlocks, check.HasLen, 2)
+	c.Assert(repaired.FailedBlocks, check.HasLen, 0)
+
+	report, err = VerifyBackup(backupURL, "", 100)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Valid, check.Equals, true)
+	volFile := filepath.Join(c.MkDir(), "volume")
+	c.Assert(RestoreDeltaBlockBackup(backupURL, "", volFile), check.IsNil)
+	data, err := ioutil.ReadFile(volFile)
+	c.Assert(err, check.IsNil)
+	c.Assert(data[DEFAULT_BLOCK_SIZE], check.Equals, byte(2))
+
+	// The block cannot be repaired once the snapshot has been changed
+	c.Assert(memStore.Remove(missing), check.IsNil)
+	report, err = VerifyBackup(backupURL, "", 100)
+	c.Assert(err, check.IsNil)
+	changed := &corruptDeltaOps{&liveDeltaOps{fakeDeltaOps: &fakeDeltaOps{}}}
+	repaired, err = RepairDeltaBlockBackup(report, "", changed)
+	c.Assert(err, check.IsNil)
+	c.Assert(repaired.Valid, check.Equals, false)
+	c.Assert(repaired.FailedBlocks, check.HasLen, 1)
+	c.Assert(repaired.FailedBlocks[0].Error, check.Equals, "snapshot snapshot no longer has the content of the block")
+	c.Assert(memStore.FileExists(missing), check.Equals, false)
+}
+
+// corruptDeltaOps reads the snapshot with different content
+type corruptDeltaOps struct {
+	*liveDeltaOps
+}
+
+func (d *corruptDeltaOps) ReadSnapshot(id, volumeID string, start int64, data []byte) error {
+	for i := range data {
+		data[i] = 0xff
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package objectstore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+// ReplicateReport is the result of the replication of a volume between
+// objectstores
+type ReplicateReport struct {
+	SrcURL     string
+	DestURL    string
+	VolumeName string
+
+	CopiedBackups  []string
+	SkippedBackups []string
+	CopiedBlocks   int
+	ExistedBlocks  int
+	CopiedBytes    int64
+}
+
+// replicator copies the backups of a volume from src to dest
+type replicator struct {
+	volumeName string
+	src        ObjectStoreDriver
+	dest       ObjectStoreDriver
+	srcCipher  *objectCipher
+	destCipher *objectCipher
+	// Place the blocks in the shared pool of dest
+	shared bool
+	// The blocks can be copied as they are on the server side, if set
+	copier ObjectStoreCopier
+	report *ReplicateReport
+}
+
+/*
+ReplicateVolume copies the backups of the volume in the objectstore at
+srcURL, which don't exist in the objectstore at destURL yet, to it. Only the
+blocks missing in dest would be copied, so the replication can be run
+repeatedly, e.g. after each backup, and an interrupted one would resume from
+the blocks have been copied. The backups are copied in the order of creation
+so the incremental ones are copied after their parents. The images exported
+along with the backups are not copied.
+*/
+func ReplicateVolume(srcURL, srcEndpoint, destURL, destEndpoint, volumeName string) (*ReplicateReport, error) {
+	src, err := GetObjectStoreDriver(srcURL, srcEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	dest, err := GetObjectStoreDriver(destURL, destEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	if src.GetURL() == dest.GetURL() {
+		return nil, fmt.Errorf("Cannot replicate volume %v to the same objectstore %v", volumeName, srcURL)
+	}
+	if err := checkWritable(dest); err != nil {
+		return nil, err
+	}
+	if !volumeExists(volumeName, src) {
+		return nil, fmt.Errorf("Cannot find volume %v in objectstore", volumeName)
+	}
+	srcConfig, err := loadObjectStoreConfig(src)
+	if err != nil {
+		return nil, err
+	}
+	srcCipher, err := srcConfig.getCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	// The cipher is got with the volume locked, since the encryption key of
+	// the objectstore may be rotated
+	unlock, err := lockVolume(volumeName, dest)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	destConfig, err := initObjectStoreConfig(dest)
+	if err != nil {
+		return nil, err
+	}
+	destCipher, err := destConfig.getCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	volume, err := loadVolume(volumeName, src)
+	if err != nil {
+		return nil, err
+	}
+	if volumeExists(volumeName, dest) {
+		existing, err := loadVolume(volumeName, dest)
+		if err != nil {
+			return nil, err
+		}
+		if existing.Driver != volume.Driver {
+			return nil, fmt.Errorf("Volume %v exists in objectstore %v with driver %v rather than %v",
+				volumeName, destURL, existing.Driver, volume.Driver)
+		}
+	}
+
+	backups, err := loadBackupsByCreation(volumeName, src)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &replicator{
+		volumeName: volumeName,
+		src:        src,
+		dest:       dest,
+		srcCipher:  srcCipher,
+		destCipher: destCipher,
+		shared:     destConfig.SharedBlocks,
+		report: &ReplicateReport{
+			SrcURL:         src.GetURL(),
+			DestURL:        dest.GetURL(),
+			VolumeName:     volumeName,
+			CopiedBackups:  []string{},
+			SkippedBackups: []string{},
+		},
+	}
+	// The blocks can only be copied as they are if they're encrypted by the
+	// same single key in both objectstores
+	if copier, ok := unthrottled(dest).(ObjectStoreCopier); ok && copier.CanCopyFrom(src.GetURL(), srcEndpoint) &&
+		srcConfig.EncryptionKeyID == destConfig.EncryptionKeyID &&
+		len(srcConfig.getEncryptionKeyIDs()) == 1 && len(destConfig.getEncryptionKeyIDs()) == 1 {
+		r.copier = copier
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_REPLICATE,
+		LOG_FIELD_VOLUME:   volumeName,
+		LOG_FIELD_DEST_URL: destURL,
+	}).Debugf("Replicating %v backups from %v", len(backups), srcURL)
+
+	// The volume is added before any backup, like the backup creation
+	destVolume := *volume
+	destVolume.LastBackupName = ""
+	if err := addVolume(&destVolume, dest); err != nil {
+		return nil, err
+	}
+	for _, backup := range backups {
+		if backupExists(backup.Name, volumeName, dest) {
+			r.report.SkippedBackups = append(r.report.SkippedBackups, backup.Name)
+			continue
+		}
+		if err := r.copyBackup(backup); err != nil {
+			return nil, err
+		}
+		r.report.CopiedBackups = append(r.report.CopiedBackups, backup.Name)
+	}
+
+	// The next backup to dest can be based on the last one of src as well
+	if volume.LastBackupName != "" && backupExists(volume.LastBackupName, volumeName, dest) {
+		destVolume, err := loadVolume(volumeName, dest)
+		if err != nil {
+			return nil, err
+		}
+		if destVolume.LastBackupName != volume.LastBackupName {
+			destVolume.LastBackupName = volume.LastBackupName
+			if err := saveVolume(destVolume, dest); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:    LOG_EVENT_REPLICATE,
+		LOG_FIELD_VOLUME:   volumeName,
+		LOG_FIELD_DEST_URL: destURL,
+	}).Debugf("Replicated %v backups with %v blocks, %v bytes",
+		len(r.report.CopiedBackups), r.report.CopiedBlocks, r.report.CopiedBytes)
+	return r.report, nil
+}
+
+func loadBackupsByCreation(volumeName string, driver ObjectStoreDriver) ([]*Backup, error) {
+	backupNames, err := getBackupNamesForVolume(volumeName, driver)
+	if err != nil {
+		return nil, err
+	}
+	backups := []*Backup{}
+	for _, backupName := range backupNames {
+		backup, err := loadBackup(backupName, volumeName, driver)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, backup)
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		ti, erri := ParseBackupTime(backups[i].CreatedTime)
+		tj, errj := ParseBackupTime(backups[j].CreatedTime)
+		if erri != nil || errj != nil {
+			return backups[i].CreatedTime < backups[j].CreatedTime
+		}
+		return ti.Before(tj)
+	})
+	return backups, nil
+}
+
+func (r *replicator) copyBackup(backup *Backup) error {
+	backup.Image = nil
+	backup.Copy = nil
+	if backup.SingleFile.FilePath != "" {
+		if err := r.copySingleFile(backup); err != nil {
+			return err
+		}
+		return saveBackup(backup, r.dest)
+	}
+
+	// The key of the block is only known if it's copied
+	copied := map[string]string{}
+	for i := range backup.Blocks {
+		if isZeroBlock(backup.Blocks[i]) {
+			continue
+		}
+		srcFile := getBlockFilePath(r.volumeName, backup.Blocks[i])
+		backup.Blocks[i].Shared = r.shared
+		destFile := getBlockFilePath(r.volumeName, backup.Blocks[i])
+		if _, ok := copied[destFile]; ok {
+			continue
+		}
+		copied[destFile] = ""
+		if r.dest.FileSize(destFile) >= 0 {
+			r.report.ExistedBlocks++
+			continue
+		}
+		if err := r.copyBlock(srcFile, destFile); err != nil {
+			return err
+		}
+		copied[destFile] = r.destCipher.currentKeyID()
+	}
+	for i := range backup.Blocks {
+		if !isZeroBlock(backup.Blocks[i]) {
+			backup.Blocks[i].EncryptionKeyID = copied[getBlockFilePath(r.volumeName, backup.Blocks[i])]
+		}
+	}
+
+	// The blocks must be referenced before the backup, see addBlockRefs
+	if err := addBlockRefs(r.volumeName, backup.Blocks, r.dest); err != nil {
+		return err
+	}
+	if err := indexBackup(backup, r.dest); err != nil {
+		return err
+	}
+	if err := saveBackup(backup, r.dest); err != nil {
+		return err
+	}
+	log.Debugf("Replicated backup %v of volume %v", backup.Name, r.volumeName)
+	return nil
+}
+
+func (r *replicator) copyBlock(srcFile, destFile string) error {
+	// The encrypted block can only be copied as it is to the same path
+	if r.copier != nil && srcFile == destFile {
+		if err := r.copier.CopyFrom(r.src.GetURL(), srcFile, destFile); err != nil {
+			return err
+		}
+		r.report.CopiedBlocks++
+		if size := r.dest.FileSize(destFile); size > 0 {
+			r.report.CopiedBytes += size
+		}
+		return nil
+	}
+
+	rc, err := r.src.Read(srcFile)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	// The block is re-encrypted since the path is authenticated as well
+	if r.srcCipher != nil {
+		if data, err = decryptData(r.srcCipher, srcFile, data); err != nil {
+			return err
+		}
+	}
+	if r.destCipher != nil {
+		if data, err = encryptData(r.destCipher, destFile, data); err != nil {
+			return err
+		}
+	}
+	if err := r.dest.Write(destFile, bytes.NewReader(data)); err != nil {
+		return err
+	}
+	r.report.CopiedBlocks++
+	r.report.CopiedBytes += int64(len(data))
+	return nil
+}
+
+func (r *replicator) copySingleFile(backup *Backup) error {
+	if r.destCipher != nil {
+		return fmt.Errorf("Single file backup %v cannot be replicated to encrypted objectstore", backup.Name)
+	}
+	f, err := ioutil.TempFile("", "convoy-replicate-")
+	if err != nil {
+		return err
+	}
+	tmpFile := f.Name()
+	f.Close()
+	defer os.Remove(tmpFile)
+
+	if err := r.src.Download(backup.SingleFile.FilePath, tmpFile); err != nil {
+		return err
+	}
+	if err := r.dest.Upload(tmpFile, backup.SingleFile.FilePath); err != nil {
+		return err
+	}
+	if st, err := os.Stat(tmpFile); err == nil {
+		r.report.CopiedBytes += st.Size()
+	}
+	return nil
+}
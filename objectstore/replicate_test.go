@@ -0,0 +1,100 @@
+package objectstore
+
+import (
+	"sync"
+
+	"github.com/rancher/convoy/metadata"
+	"gopkg.in/check.v1"
+)
+
+const (
+	REMOTE_MEM_KIND = "remotemem"
+	REMOTE_MEM_URL  = "remotemem:///"
+)
+
+var remoteMemStore *remoteMemObjectStoreDriver
+
+// remoteMemObjectStoreDriver is another in-memory objectstore, as the
+// destination of replication
+type remoteMemObjectStoreDriver struct {
+	*memObjectStoreDriver
+}
+
+func (m *remoteMemObjectStoreDriver) Kind() string {
+	return REMOTE_MEM_KIND
+}
+
+func (m *remoteMemObjectStoreDriver) GetURL() string {
+	return REMOTE_MEM_URL
+}
+
+func init() {
+	if err := RegisterDriver(REMOTE_MEM_KIND, func(destURL, endpoint string) (ObjectStoreDriver, error) {
+		return remoteMemStore, nil
+	}); err != nil {
+		panic(err)
+	}
+}
+
+func (s *TestSuite) TestReplicateVolume(c *check.C) {
+	remoteMemStore = &remoteMemObjectStoreDriver{&memObjectStoreDriver{
+		mutex:    &sync.Mutex{},
+		files:    make(map[string][]byte),
+		locks:    make(map[string]bool),
+		archived: make(map[string]string),
+	}}
+
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: 0, Size: 4 * DEFAULT_BLOCK_SIZE},
+		},
+	}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+		Size:   4 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL1, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-1"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	backupName1, _, err := decodeBackupURL(backupURL1)
+	c.Assert(err, check.IsNil)
+
+	_, err = ReplicateVolume(MEM_URL, "", MEM_URL, "", testVolumeName)
+	c.Assert(err, check.ErrorMatches, "Cannot replicate volume .* to the same objectstore.*")
+
+	report, err := ReplicateVolume(MEM_URL, "", REMOTE_MEM_URL, "", testVolumeName)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.CopiedBackups, check.DeepEquals, []string{backupName1})
+	// Block 0 and 3 have the same content
+	c.Assert(report.CopiedBlocks, check.Equals, 3)
+
+	backup, err := loadBackup(backupName1, testVolumeName, remoteMemStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup.Blocks, check.HasLen, 4)
+	for _, blk := range backup.Blocks {
+		c.Assert(remoteMemStore.FileExists(getBlockFilePath(testVolumeName, blk)), check.Equals, true)
+	}
+	remoteVolume, err := loadVolume(testVolumeName, remoteMemStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(remoteVolume.LastBackupName, check.Equals, backupName1)
+
+	verifyReport, err := VerifyBackup(encodeBackupURL(backupName1, testVolumeName, REMOTE_MEM_URL), "", 100)
+	c.Assert(err, check.IsNil)
+	c.Assert(verifyReport.MissingBlocks, check.HasLen, 0)
+	c.Assert(verifyReport.CorruptBlocks, check.HasLen, 0)
+
+	// Only the new backup would be copied, without the existing blocks
+	backupURL2, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-2"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	backupName2, _, err := decodeBackupURL(backupURL2)
+	c.Assert(err, check.IsNil)
+	report, err = ReplicateVolume(MEM_URL, "", REMOTE_MEM_URL, "", testVolumeName)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.CopiedBackups, check.DeepEquals, []string{backupName2})
+	c.Assert(report.SkippedBackups, check.DeepEquals, []string{backupName1})
+	c.Assert(report.CopiedBlocks, check.Equals, 0)
+	c.Assert(report.ExistedBlocks, check.Equals, 3)
+	remoteVolume, err = loadVolume(testVolumeName, remoteMemStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(remoteVolume.LastBackupName, check.Equals, backupName2)
+}
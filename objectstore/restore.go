@@ -0,0 +1,385 @@
+package objectstore
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+/*
+RestoreDeltaBlockBackupIncrementally restores the backup to volDevName, which
+has the content of lastBackupURL, another backup of the same volume in the
+same objectstore, e.g. the device was restored from it. The snapshot maps of
+the two backups are compared, and only the blocks differing from the last
+backup would be written, after zeroing the ranges which only have data in the
+last backup. It's up to the caller to make sure the device hasn't been
+changed since the last backup was restored, and that the ranges without any
+block in the backups read as zeros, e.g. thin provisioned devices.
+*/
+func RestoreDeltaBlockBackupIncrementally(backupURL, lastBackupURL, endpoint, volDevName string) error {
+	if err := checkSameBackupChain(backupURL, lastBackupURL); err != nil {
+		return err
+	}
+	bsDriver, err := GetObjectStoreDriver(backupURL, endpoint)
+	if err != nil {
+		return err
+	}
+	backupName, volumeName, err := decodeBackupURL(backupURL)
+	if err != nil {
+		return err
+	}
+	lastBackupName, _, err := decodeBackupURL(lastBackupURL)
+	if err != nil {
+		return err
+	}
+
+	vol, err := loadVolume(volumeName, bsDriver)
+	if err != nil {
+		return err
+	}
+	if vol.Size <= 0 {
+		return fmt.Errorf("Read invalid volume size %v", vol.Size)
+	}
+	backup, err := loadBackup(backupName, volumeName, bsDriver)
+	if err != nil {
+		return err
+	}
+	lastBackup, err := loadBackup(lastBackupName, volumeName, bsDriver)
+	if err != nil {
+		return err
+	}
+	if backup.SingleFile.FilePath != "" || lastBackup.SingleFile.FilePath != "" {
+		return fmt.Errorf("Cannot restore single file backup %v incrementally", backupName)
+	}
+	hash := backup.getHash()
+	if err := ValidateHash(hash); err != nil {
+		return err
+	}
+	if lastBackup.getHash() != hash {
+		return fmt.Errorf("Cannot restore backup %v incrementally, the blocks are hashed by %v but %v by backup %v",
+			backupName, hash, lastBackup.getHash(), lastBackupName)
+	}
+	aead, err := getObjectStoreCipher(bsDriver)
+	if err != nil {
+		return err
+	}
+
+	volDev, err := os.OpenFile(volDevName, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer volDev.Close()
+
+	changed, removed := diffBackupBlocks(backup.Blocks, lastBackup.Blocks, backup.getBlockSize(),
+		lastBackup.getBlockSize())
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:      LOG_REASON_START,
+		LOG_FIELD_EVENT:       LOG_EVENT_RESTORE,
+		LOG_FIELD_OBJECT:      LOG_FIELD_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT:    backupName,
+		LOG_FIELD_ORIN_VOLUME: volumeName,
+		LOG_FIELD_VOLUME_DEV:  volDevName,
+		LOG_FIELD_BACKUP_URL:  backupURL,
+	}).Debugf("Restoring %v of %v blocks over backup %v, zeroing %v blocks", len(changed), len(backup.Blocks),
+		lastBackupName, len(removed))
+
+	lastBlockSize := lastBackup.getBlockSize()
+	for _, block := range removed {
+		size := getBlockLength(block, lastBlockSize)
+		if block.Offset+size > vol.Size {
+			size = vol.Size - block.Offset
+		}
+		if err := writeZeros(volDev, block.Offset, size); err != nil {
+			return err
+		}
+	}
+
+	progress := startProgress(RestoreProgressKey(backupURL), PROGRESS_RESTORE, len(changed))
+	defer progress.finish()
+	restorer := &blockRestorer{
+		volDev:          volDev,
+		volumeSize:      vol.Size,
+		volumeName:      volumeName,
+		backup:          backup,
+		hash:            hash,
+		aead:            aead,
+		bsDriver:        bsDriver,
+		writeZeroBlocks: true,
+	}
+	if err := restorer.restore(changed, progress); err != nil {
+		return err
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:     LOG_REASON_COMPLETE,
+		LOG_FIELD_EVENT:      LOG_EVENT_RESTORE,
+		LOG_FIELD_OBJECT:     LOG_FIELD_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT:   backupName,
+		LOG_FIELD_VOLUME_DEV: volDevName,
+	}).Debug()
+	return nil
+}
+
+// checkSameBackupChain checks if the backups are of the same volume in the
+// same objectstore
+func checkSameBackupChain(backupURL, lastBackupURL string) error {
+	u, err := url.Parse(backupURL)
+	if err != nil {
+		return err
+	}
+	lastURL, err := url.Parse(lastBackupURL)
+	if err != nil {
+		return err
+	}
+	volume, lastVolume := u.Query().Get("volume"), lastURL.Query().Get("volume")
+	u.RawQuery, lastURL.RawQuery = "", ""
+	if u.String() != lastURL.String() || volume != lastVolume {
+		return fmt.Errorf("Cannot restore backup %v incrementally over %v, which is not a backup of the same volume",
+			backupURL, lastBackupURL)
+	}
+	return nil
+}
+
+/*
+diffBackupBlocks compares the snapshot maps of two backups. The changed
+blocks are the blocks which differ from the block of the last backup at the
+same offset, or have no block of the same length there. The removed blocks
+are the non-zero blocks of the last backup without a block of the same
+length at the same offset in blocks, whose ranges have to be zeroed before
+writing the changed blocks. The ranges without any block are zeros, so the
+zero blocks only need to be written over the blocks of the same length.
+*/
+func diffBackupBlocks(blocks, lastBlocks []BlockMapping, blockSize, lastBlockSize int64) ([]BlockMapping, []BlockMapping) {
+	current := map[int64]BlockMapping{}
+	for _, blk := range blocks {
+		current[blk.Offset] = blk
+	}
+	last := map[int64]BlockMapping{}
+	removed := []BlockMapping{}
+	for _, blk := range lastBlocks {
+		last[blk.Offset] = blk
+		if isZeroBlock(blk) {
+			continue
+		}
+		b, exists := current[blk.Offset]
+		if !exists || getBlockLength(b, blockSize) != getBlockLength(blk, lastBlockSize) {
+			removed = append(removed, blk)
+		}
+	}
+
+	changed := []BlockMapping{}
+	for _, blk := range blocks {
+		l, exists := last[blk.Offset]
+		if exists && getBlockLength(l, lastBlockSize) == getBlockLength(blk, blockSize) {
+			if l.BlockChecksum == blk.BlockChecksum {
+				continue
+			}
+		} else if isZeroBlock(blk) {
+			continue
+		}
+		changed = append(changed, blk)
+	}
+	return changed, removed
+}
+
+/*
+RestoreImageStream writes the raw image read from r to the device or file,
+e.g. streamed by WriteSnapshotImage through the tools of the user, and
+returns the size of the image. The image cannot be larger than the device,
+and the rest of a larger device would be left as it is.
+*/
+func RestoreImageStream(r io.Reader, volDevName string) (int64, error) {
+	volDev, err := os.OpenFile(volDevName, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer volDev.Close()
+
+	// The size of a block device can only be found by seeking
+	size, err := volDev.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := volDev.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	log.Debugf("Restoring image stream to %v of size %v", volDevName, size)
+	n, err := io.CopyN(volDev, r, size)
+	if err == io.EOF {
+		return n, volDev.Sync()
+	}
+	if err != nil {
+		return n, err
+	}
+	// The image has been written up to the end of the device
+	if m, _ := io.ReadFull(r, make([]byte, 1)); m > 0 {
+		return n, fmt.Errorf("Image is larger than %v of size %v", volDevName, size)
+	}
+	return n, volDev.Sync()
+}
+
+// SetRestoreWorkers sets the number of blocks would be read from objectstore
+// and written to the device concurrently when restoring backup
+func SetRestoreWorkers(workers int) error {
+	if workers < 1 {
+		return fmt.Errorf("Invalid number of restore workers %v, must be at least 1", workers)
+	}
+	restoreWorkers = workers
+	return nil
+}
+
+// blockRestorer writes the blocks of the backup to the device
+type blockRestorer struct {
+	volDev     *os.File
+	volumeSize int64
+	volumeName string
+	backup     *Backup
+	hash       string
+	aead       *objectCipher
+	bsDriver   ObjectStoreDriver
+	// Write zeros for the zero blocks, rather than skipping them
+	writeZeroBlocks bool
+
+	stageMutex sync.Mutex
+	staged     bool
+	stageErr   error
+}
+
+/*
+restore writes the blocks to the device by restoreWorkers goroutines. Each of
+them reads a block from objectstore and writes it at its offset, so the
+blocks would be written in any order, and no more than restoreWorkers blocks
+would be held in memory. The first failure stops the restore.
+*/
+func (r *blockRestorer) restore(blocks []BlockMapping, progress *progressTracker) error {
+	workers := restoreWorkers
+	if workers > len(blocks) {
+		workers = len(blocks)
+	}
+	jobs := make(chan BlockMapping)
+	abort := make(chan struct{})
+	var (
+		restoreErr error
+		errOnce    sync.Once
+		wg         sync.WaitGroup
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			restoreErr = err
+			close(abort)
+		})
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for block := range jobs {
+				read, err := r.restoreBlock(block)
+				if err != nil {
+					fail(err)
+					continue
+				}
+				progress.add(1, read)
+			}
+		}()
+	}
+
+	blkCounts := len(blocks)
+send:
+	for i, block := range blocks {
+		log.Debugf("Restore for %v: block %v, %v/%v", r.volDev.Name(), block.BlockChecksum, i+1, blkCounts)
+		select {
+		case jobs <- block:
+		case <-abort:
+			break send
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return restoreErr
+}
+
+// restoreBlock writes the block to the device at its offset, and returns the
+// bytes read from the objectstore
+func (r *blockRestorer) restoreBlock(block BlockMapping) (int64, error) {
+	size := getBlockLength(block, r.backup.getBlockSize())
+	if block.Offset+size > r.volumeSize {
+		size = r.volumeSize - block.Offset
+	}
+	if isZeroBlock(block) {
+		if !r.writeZeroBlocks {
+			return 0, nil
+		}
+		return 0, writeZeros(r.volDev, block.Offset, size)
+	}
+
+	blkFile := getBlockFilePath(r.volumeName, block)
+	rc, err := r.bsDriver.Read(blkFile)
+	if err != nil {
+		if stageErr := r.stage(blkFile); stageErr != nil {
+			return 0, stageErr
+		}
+		return 0, err
+	}
+	defer rc.Close()
+	cr := &countingReader{Reader: rc}
+	br, err := readBlock(blkFile, block, r.hash, cr, r.aead)
+	if err != nil {
+		return 0, err
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(br, size))
+	if err != nil {
+		return 0, err
+	}
+	if int64(len(data)) != size {
+		return 0, fmt.Errorf("Block %v has %v bytes, expected %v", blkFile, len(data), size)
+	}
+	if _, err := r.volDev.WriteAt(data, block.Offset); err != nil {
+		return 0, err
+	}
+	return cr.count, nil
+}
+
+// stage starts the retrieval of the backup if the block file cannot be read
+// since it's archived. Only the status of the block failed first would be
+// checked, since it costs a request per block, and the retrieval would only
+// be started once.
+func (r *blockRestorer) stage(blkFile string) error {
+	archiver, ok := unthrottled(r.bsDriver).(ObjectStoreArchiver)
+	if !ok {
+		return nil
+	}
+	r.stageMutex.Lock()
+	defer r.stageMutex.Unlock()
+	if r.staged {
+		return r.stageErr
+	}
+	r.staged = true
+	status, err := archiver.ArchiveStatus(blkFile)
+	if err != nil || status == ARCHIVE_STATUS_AVAILABLE {
+		return nil
+	}
+	info, err := stageBackup(r.backup, archiver)
+	if err != nil {
+		r.stageErr = err
+	} else if info["Ready"] != "true" {
+		r.stageErr = stagingError(info)
+	}
+	return r.stageErr
+}
+
+// writeZeros writes size bytes of zeros to the device at offset
+func writeZeros(volDev *os.File, offset, size int64) error {
+	zeros := make([]byte, size)
+	_, err := volDev.WriteAt(zeros, offset)
+	return err
+}
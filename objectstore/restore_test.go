@@ -0,0 +1,113 @@
+package objectstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestDiffBackupBlocks(c *check.C) {
+	lastBlocks := []BlockMapping{
+		{Offset: 0, BlockChecksum: "a"},
+		{Offset: DEFAULT_BLOCK_SIZE, BlockChecksum: "b"},
+		{Offset: 2 * DEFAULT_BLOCK_SIZE, BlockChecksum: "c"},
+		zeroBlockMapping(3*DEFAULT_BLOCK_SIZE, 0),
+	}
+	blocks := []BlockMapping{
+		{Offset: 0, BlockChecksum: "a"},
+		{Offset: DEFAULT_BLOCK_SIZE, BlockChecksum: "d"},
+		zeroBlockMapping(3*DEFAULT_BLOCK_SIZE, 0),
+		{Offset: 4 * DEFAULT_BLOCK_SIZE, BlockChecksum: "e"},
+	}
+	changed, removed := diffBackupBlocks(blocks, lastBlocks, DEFAULT_BLOCK_SIZE, DEFAULT_BLOCK_SIZE)
+	c.Assert(changed, check.DeepEquals, []BlockMapping{blocks[1], blocks[3]})
+	c.Assert(removed, check.DeepEquals, []BlockMapping{lastBlocks[2]})
+
+	// The zero block only needs to be written over the block of the same
+	// length, otherwise the range has been zeroed
+	lastBlocks[3] = BlockMapping{Offset: 3 * DEFAULT_BLOCK_SIZE, BlockChecksum: "f"}
+	changed, removed = diffBackupBlocks(blocks, lastBlocks, DEFAULT_BLOCK_SIZE, DEFAULT_BLOCK_SIZE)
+	c.Assert(changed, check.DeepEquals, []BlockMapping{blocks[1], blocks[2], blocks[3]})
+	c.Assert(removed, check.DeepEquals, []BlockMapping{lastBlocks[2]})
+
+	// The chunks of different lengths at the same offset are replaced
+	lastBlocks = []BlockMapping{{Offset: 0, BlockChecksum: "a", Length: 100}}
+	blocks = []BlockMapping{zeroBlockMapping(0, 50), {Offset: 50, BlockChecksum: "b", Length: 50}}
+	changed, removed = diffBackupBlocks(blocks, lastBlocks, DEFAULT_BLOCK_SIZE, DEFAULT_BLOCK_SIZE)
+	c.Assert(changed, check.DeepEquals, []BlockMapping{blocks[1]})
+	c.Assert(removed, check.DeepEquals, lastBlocks)
+}
+
+func (s *TestSuite) TestRestoreIncrementally(c *check.C) {
+	data := randomData(8 * DEFAULT_BLOCK_SIZE)
+	copy(data[6*DEFAULT_BLOCK_SIZE:7*DEFAULT_BLOCK_SIZE], make([]byte, DEFAULT_BLOCK_SIZE))
+	deltaOps := &dataDeltaOps{data: data}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+		Size:   8 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-1"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	volFile := filepath.Join(c.MkDir(), "volume")
+	c.Assert(RestoreDeltaBlockBackup(backupURL, "", volFile), check.IsNil)
+
+	// Change a block, zero two blocks and fill the zero block
+	newData := append([]byte{}, data...)
+	copy(newData[DEFAULT_BLOCK_SIZE:], randomData(DEFAULT_BLOCK_SIZE/2))
+	copy(newData[3*DEFAULT_BLOCK_SIZE:4*DEFAULT_BLOCK_SIZE], make([]byte, DEFAULT_BLOCK_SIZE))
+	copy(newData[7*DEFAULT_BLOCK_SIZE:], make([]byte, DEFAULT_BLOCK_SIZE))
+	copy(newData[6*DEFAULT_BLOCK_SIZE:], bytes.Repeat([]byte{1}, DEFAULT_BLOCK_SIZE))
+	deltaOps.data = newData
+	newBackupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-2"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+
+	c.Assert(RestoreDeltaBlockBackupIncrementally(newBackupURL, backupURL, "", volFile), check.IsNil)
+	restored, err := ioutil.ReadFile(volFile)
+	c.Assert(err, check.IsNil)
+	c.Assert(bytes.Equal(restored, newData), check.Equals, true)
+
+	// Roll back to the earlier backup
+	c.Assert(RestoreDeltaBlockBackupIncrementally(backupURL, newBackupURL, "", volFile), check.IsNil)
+	restored, err = ioutil.ReadFile(volFile)
+	c.Assert(err, check.IsNil)
+	c.Assert(bytes.Equal(restored, data), check.Equals, true)
+
+	otherURL := encodeBackupURL(decodeTestBackupName(c, backupURL), "other-volume", MEM_URL)
+	err = RestoreDeltaBlockBackupIncrementally(otherURL, backupURL, "", volFile)
+	c.Assert(err, check.ErrorMatches, "Cannot restore backup .* incrementally over .*, which is not a backup of the same volume")
+}
+
+func (s *TestSuite) TestRestoreWorkers(c *check.C) {
+	defer SetRestoreWorkers(DEFAULT_RESTORE_WORKERS)
+
+	data := randomData(8 * DEFAULT_BLOCK_SIZE)
+	copy(data[2*DEFAULT_BLOCK_SIZE:3*DEFAULT_BLOCK_SIZE], make([]byte, DEFAULT_BLOCK_SIZE))
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+		Size:   8 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-1"}, MEM_URL, "",
+		&dataDeltaOps{data: data})
+	c.Assert(err, check.IsNil)
+
+	volFile := filepath.Join(c.MkDir(), "volume")
+	for _, workers := range []int{1, 3, 16} {
+		c.Assert(SetRestoreWorkers(workers), check.IsNil)
+		c.Assert(RestoreDeltaBlockBackup(backupURL, "", volFile), check.IsNil)
+		restored, err := ioutil.ReadFile(volFile)
+		c.Assert(err, check.IsNil)
+		c.Assert(bytes.Equal(restored, data), check.Equals, true)
+	}
+
+	// The restore stops at the missing block
+	backup, err := loadBackup(decodeTestBackupName(c, backupURL), testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(memStore.Remove(getBlockFilePath(testVolumeName, backup.Blocks[5])), check.IsNil)
+	c.Assert(RestoreDeltaBlockBackup(backupURL, "", volFile), check.ErrorMatches, "Cannot find .*")
+
+	c.Assert(SetRestoreWorkers(0), check.ErrorMatches, "Invalid number of restore workers.*")
+}
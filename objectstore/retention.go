@@ -0,0 +1,118 @@
+package objectstore
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+/*
+RetentionPolicy decides which backups of a volume would be kept. The latest
+KeepLast backups are kept, as well as the latest backup of each of the latest
+KeepDaily days, KeepWeekly weeks and KeepMonthly months which have backups.
+A backup is kept if any of the rules keeps it.
+*/
+type RetentionPolicy struct {
+	KeepLast    int `json:",omitempty"`
+	KeepDaily   int `json:",omitempty"`
+	KeepWeekly  int `json:",omitempty"`
+	KeepMonthly int `json:",omitempty"`
+}
+
+func (p *RetentionPolicy) Validate() error {
+	if p.KeepLast < 0 || p.KeepDaily < 0 || p.KeepWeekly < 0 || p.KeepMonthly < 0 {
+		return fmt.Errorf("Invalid retention policy %+v, the numbers cannot be negative", *p)
+	}
+	if p.IsEmpty() {
+		return fmt.Errorf("Invalid retention policy, at least one backup should be kept")
+	}
+	return nil
+}
+
+func (p *RetentionPolicy) IsEmpty() bool {
+	return p.KeepLast == 0 && p.KeepDaily == 0 && p.KeepWeekly == 0 && p.KeepMonthly == 0
+}
+
+// ParseBackupTime parses CreatedTime in the info of backup
+func ParseBackupTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RubyDate, time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid backup time %v", value)
+}
+
+type retentionBucket func(t time.Time) string
+
+func dailyBucket(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+func weeklyBucket(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+func monthlyBucket(t time.Time) string {
+	return t.Format("2006-01")
+}
+
+/*
+ExpiredBackups returns the backups should be removed according to the policy,
+from the creation time of the backups keyed by the backup URLs. Nothing would
+be expired if the policy is empty.
+*/
+func (p *RetentionPolicy) ExpiredBackups(backups map[string]time.Time) []string {
+	if p.IsEmpty() {
+		return []string{}
+	}
+
+	urls := []string{}
+	for url := range backups {
+		urls = append(urls, url)
+	}
+	// Latest first
+	sort.Slice(urls, func(i, j int) bool {
+		ti, tj := backups[urls[i]], backups[urls[j]]
+		if ti.Equal(tj) {
+			return urls[i] > urls[j]
+		}
+		return ti.After(tj)
+	})
+
+	keep := map[string]bool{}
+	for i := 0; i < p.KeepLast && i < len(urls); i++ {
+		keep[urls[i]] = true
+	}
+	rules := []struct {
+		count  int
+		bucket retentionBucket
+	}{
+		{p.KeepDaily, dailyBucket},
+		{p.KeepWeekly, weeklyBucket},
+		{p.KeepMonthly, monthlyBucket},
+	}
+	for _, rule := range rules {
+		seen := map[string]bool{}
+		for _, url := range urls {
+			if len(seen) >= rule.count {
+				break
+			}
+			bucket := rule.bucket(backups[url])
+			if seen[bucket] {
+				continue
+			}
+			seen[bucket] = true
+			keep[url] = true
+		}
+	}
+
+	expired := []string{}
+	for _, url := range urls {
+		if !keep[url] {
+			expired = append(expired, url)
+		}
+	}
+	return expired
+}
@@ -0,0 +1,75 @@
+package objectstore
+
+import (
+	"sort"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestRetentionPolicyValidate(c *check.C) {
+	c.Assert((&RetentionPolicy{KeepLast: 1}).Validate(), check.IsNil)
+	c.Assert((&RetentionPolicy{}).Validate(), check.ErrorMatches, "Invalid retention policy, at least one backup should be kept")
+	c.Assert((&RetentionPolicy{KeepLast: 1, KeepDaily: -1}).Validate(), check.ErrorMatches, "Invalid retention policy .* cannot be negative")
+}
+
+func (s *TestSuite) TestParseBackupTime(c *check.C) {
+	now := time.Now().Truncate(time.Second)
+	t, err := ParseBackupTime(now.Format(time.RubyDate))
+	c.Assert(err, check.IsNil)
+	c.Check(t.Equal(now), check.Equals, true)
+
+	t, err = ParseBackupTime(now.Format(time.RFC3339))
+	c.Assert(err, check.IsNil)
+	c.Check(t.Equal(now), check.Equals, true)
+
+	_, err = ParseBackupTime("yesterday")
+	c.Assert(err, check.ErrorMatches, "Invalid backup time yesterday")
+}
+
+func (s *TestSuite) TestExpiredBackups(c *check.C) {
+	// Two backups a day at 01:00 and 13:00, from 2016-01-01 to 2016-03-31
+	backups := map[string]time.Time{}
+	start := time.Date(2016, 1, 1, 1, 0, 0, 0, time.UTC)
+	for i := 0; i < 91*2; i++ {
+		t := start.Add(time.Duration(i) * 12 * time.Hour)
+		backups[t.Format("backup-2006-01-02-15")] = t
+	}
+	kept := func(policy *RetentionPolicy) []string {
+		expired := map[string]bool{}
+		for _, url := range policy.ExpiredBackups(backups) {
+			expired[url] = true
+		}
+		result := []string{}
+		for url := range backups {
+			if !expired[url] {
+				result = append(result, url)
+			}
+		}
+		sort.Strings(result)
+		return result
+	}
+
+	c.Check(kept(&RetentionPolicy{}), check.HasLen, len(backups))
+	c.Check(kept(&RetentionPolicy{KeepLast: 3}), check.DeepEquals, []string{
+		"backup-2016-03-30-13", "backup-2016-03-31-01", "backup-2016-03-31-13",
+	})
+	c.Check(kept(&RetentionPolicy{KeepDaily: 2}), check.DeepEquals, []string{
+		"backup-2016-03-30-13", "backup-2016-03-31-13",
+	})
+	// 2016-03-27 is Sunday, the last day of the week
+	c.Check(kept(&RetentionPolicy{KeepWeekly: 2}), check.DeepEquals, []string{
+		"backup-2016-03-27-13", "backup-2016-03-31-13",
+	})
+	c.Check(kept(&RetentionPolicy{KeepMonthly: 12}), check.DeepEquals, []string{
+		"backup-2016-01-31-13", "backup-2016-02-29-13", "backup-2016-03-31-13",
+	})
+	c.Check(kept(&RetentionPolicy{KeepLast: 2, KeepMonthly: 2}), check.DeepEquals, []string{
+		"backup-2016-02-29-13", "backup-2016-03-31-01", "backup-2016-03-31-13",
+	})
+
+	expired := (&RetentionPolicy{KeepLast: 1}).ExpiredBackups(backups)
+	c.Check(expired, check.HasLen, len(backups)-1)
+	// Latest first
+	c.Check(expired[0], check.Equals, "backup-2016-03-31-01")
+}
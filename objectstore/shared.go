@@ -0,0 +1,224 @@
+package objectstore
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	BLOCK_REFS_FILE = "block_refs.cfg"
+)
+
+var (
+	defaultSharedBlocks = false
+)
+
+/*
+BlockRefs is the reference map of a volume, which records the blocks in the
+shared pool used by the backups of the volume. It's updated before the backup
+config is saved, and after the backup is removed, so it may contain the
+blocks no longer used, but never misses the ones in use.
+*/
+type BlockRefs struct {
+	VolumeName string
+	Blocks     []string
+}
+
+// SetDefaultSharedBlocks sets whether the objectstores which haven't been
+// configured would store the blocks of all the volumes in a shared pool
+func SetDefaultSharedBlocks(shared bool) {
+	defaultSharedBlocks = shared
+}
+
+// getSharedBlockPath returns the path of the content-addressed block pool
+// shared by all the volumes in the objectstore
+func getSharedBlockPath() string {
+	return filepath.Join(OBJECTSTORE_BASE, BLOCKS_DIRECTORY) + "/"
+}
+
+func getBlockRefsFilePath(volumeName string) string {
+	return filepath.Join(getVolumePath(volumeName), BLOCK_REFS_FILE)
+}
+
+// loadBlockRefs returns the shared block files referenced by the volume
+func loadBlockRefs(volumeName string, driver ObjectStoreDriver) (map[string]bool, error) {
+	result := map[string]bool{}
+	filePath := getBlockRefsFilePath(volumeName)
+	if !driver.FileExists(filePath) {
+		return result, nil
+	}
+	aead, err := getObjectStoreCipher(driver)
+	if err != nil {
+		return nil, err
+	}
+	refs := &BlockRefs{}
+	if err := loadEncryptedConfigInObjectStore(filePath, driver, aead, refs); err != nil {
+		return nil, err
+	}
+	for _, blkFile := range refs.Blocks {
+		result[blkFile] = true
+	}
+	return result, nil
+}
+
+func saveBlockRefs(volumeName string, blkFiles map[string]bool, driver ObjectStoreDriver) error {
+	aead, err := getObjectStoreCipher(driver)
+	if err != nil {
+		return err
+	}
+	refs := &BlockRefs{
+		VolumeName: volumeName,
+		Blocks:     []string{},
+	}
+	for blkFile := range blkFiles {
+		refs.Blocks = append(refs.Blocks, blkFile)
+	}
+	sort.Strings(refs.Blocks)
+	return saveEncryptedConfigInObjectStore(getBlockRefsFilePath(volumeName), driver, aead, refs)
+}
+
+// addBlockRefs adds the shared blocks to the reference map of the volume
+func addBlockRefs(volumeName string, blocks []BlockMapping, driver ObjectStoreDriver) error {
+	var refs map[string]bool
+	changed := false
+	for _, blk := range blocks {
+		if !blk.Shared {
+			continue
+		}
+		if refs == nil {
+			var err error
+			if refs, err = loadBlockRefs(volumeName, driver); err != nil {
+				return err
+			}
+		}
+		blkFile := getBlockFilePath(volumeName, blk)
+		if !refs[blkFile] {
+			refs[blkFile] = true
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return saveBlockRefs(volumeName, refs, driver)
+}
+
+// removeBlockRefs removes the shared blocks no longer used by the volume from
+// its reference map
+func removeBlockRefs(volumeName string, blkFiles []string, driver ObjectStoreDriver) error {
+	if len(blkFiles) == 0 {
+		return nil
+	}
+	refs, err := loadBlockRefs(volumeName, driver)
+	if err != nil {
+		return err
+	}
+	for _, blkFile := range blkFiles {
+		delete(refs, blkFile)
+	}
+	return saveBlockRefs(volumeName, refs, driver)
+}
+
+// updateBlockRefs rebuilds the reference map of the volume from its backups
+func updateBlockRefs(volumeName string, driver ObjectStoreDriver) error {
+	backupNames, err := getBackupNamesForVolume(volumeName, driver)
+	if err != nil {
+		return err
+	}
+	refs := map[string]bool{}
+	for _, backupName := range backupNames {
+		backup, err := loadBackup(backupName, volumeName, driver)
+		if err != nil {
+			return err
+		}
+		for _, blk := range backup.Blocks {
+			if blk.Shared {
+				refs[getBlockFilePath(volumeName, blk)] = true
+			}
+		}
+	}
+	return saveBlockRefs(volumeName, refs, driver)
+}
+
+/*
+gcSharedBlocks removes the blocks in the shared pool which are not referenced
+by any volume. All the volumes would be locked, so no backup could start
+using the blocks being removed.
+*/
+func gcSharedBlocks(driver ObjectStoreDriver, dryRun bool, report *GCReport) error {
+	blkFiles, err := listBlockFiles(getSharedBlockPath(), driver)
+	if err != nil {
+		return err
+	}
+	if len(blkFiles) == 0 {
+		return nil
+	}
+
+	volumeNames, err := getVolumeNames(driver)
+	if err != nil {
+		return err
+	}
+	// Always lock in the same order to avoid deadlock
+	sort.Strings(volumeNames)
+	for _, volumeName := range volumeNames {
+		unlock, err := lockVolume(volumeName, driver)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	referenced := map[string]bool{}
+	for _, volumeName := range volumeNames {
+		refs, err := loadBlockRefs(volumeName, driver)
+		if err != nil {
+			return err
+		}
+		checkpointBlkFiles, err := getCheckpointBlockFiles(volumeName, driver)
+		if err != nil {
+			return err
+		}
+		for blkFile := range refs {
+			referenced[blkFile] = true
+		}
+		for blkFile := range checkpointBlkFiles {
+			referenced[blkFile] = true
+		}
+	}
+
+	orphans := []string{}
+	for _, blkFile := range blkFiles {
+		if referenced[blkFile] {
+			report.ReferencedBlocks++
+			continue
+		}
+		orphans = append(orphans, blkFile)
+		log.Debugf("Found unused shared block %v", blkFile)
+	}
+	report.TotalBlocks += len(blkFiles)
+	report.UnusedBlocks += len(orphans)
+	orphans, retained, err := filterRetainedFiles(orphans, driver)
+	if err != nil {
+		return err
+	}
+	report.RetainedBlocks += retained
+	for _, blkFile := range orphans {
+		if size := driver.FileSize(blkFile); size > 0 {
+			report.ReclaimedBytes += size
+		}
+	}
+	if dryRun || len(orphans) == 0 {
+		return nil
+	}
+	if err := driver.Remove(orphans...); err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_COMPLETE,
+	}).Debugf("Removed %v unused shared blocks", len(orphans))
+	return nil
+}
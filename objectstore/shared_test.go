@@ -0,0 +1,66 @@
+package objectstore
+
+import (
+	"gopkg.in/check.v1"
+
+	"github.com/rancher/convoy/metadata"
+)
+
+func (s *TestSuite) TestSharedBlocks(c *check.C) {
+	defer SetDefaultSharedBlocks(false)
+	SetDefaultSharedBlocks(true)
+
+	// Both volumes have the same content
+	backupURLs := []string{}
+	for _, volumeName := range []string{testVolumeName, "volume-2"} {
+		deltaOps := &fakeDeltaOps{
+			mappings: []metadata.Mapping{
+				{Offset: 0, Size: 2 * DEFAULT_BLOCK_SIZE},
+			},
+		}
+		volume := &Volume{
+			Name:   volumeName,
+			Driver: "test",
+		}
+		backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot"}, MEM_URL, "", deltaOps)
+		c.Assert(err, check.IsNil)
+		backupURLs = append(backupURLs, backupURL)
+	}
+
+	blkFiles, err := listBlockFiles(getSharedBlockPath(), memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(blkFiles, check.HasLen, 2)
+	for _, volumeName := range []string{testVolumeName, "volume-2"} {
+		volBlkFiles, err := listBlockFiles(getBlockPath(volumeName), memStore)
+		c.Assert(err, check.IsNil)
+		c.Assert(volBlkFiles, check.HasLen, 0)
+		refs, err := loadBlockRefs(volumeName, memStore)
+		c.Assert(err, check.IsNil)
+		c.Assert(refs, check.HasLen, 2)
+	}
+
+	// The shared blocks are still used by the other volume
+	c.Assert(DeleteDeltaBlockBackup(backupURLs[0], "", false), check.IsNil)
+	report, err := GarbageCollect(MEM_URL, "", "", false)
+	c.Assert(err, check.IsNil)
+	c.Check(report.UnusedBlocks, check.Equals, 0)
+	for _, blkFile := range blkFiles {
+		c.Check(memStore.FileExists(blkFile), check.Equals, true)
+	}
+
+	// Only the garbage collection of all the volumes would remove them
+	c.Assert(DeleteDeltaBlockBackup(backupURLs[1], "", false), check.IsNil)
+	for _, blkFile := range blkFiles {
+		c.Check(memStore.FileExists(blkFile), check.Equals, true)
+	}
+	report, err = GarbageCollect(MEM_URL, "", "", true)
+	c.Assert(err, check.IsNil)
+	c.Check(report.UnusedBlocks, check.Equals, 2)
+	c.Check(memStore.FileExists(blkFiles[0]), check.Equals, true)
+	report, err = GarbageCollect(MEM_URL, "", "", false)
+	c.Assert(err, check.IsNil)
+	c.Check(report.UnusedBlocks, check.Equals, 2)
+	for _, blkFile := range blkFiles {
+		c.Check(memStore.FileExists(blkFile), check.Equals, false)
+	}
+}
@@ -2,6 +2,7 @@ package objectstore
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 
 	"github.com/Sirupsen/logrus"
@@ -16,6 +17,8 @@ const (
 
 type BackupFile struct {
 	FilePath string
+	// The size of the file, 0 if it was created by older versions
+	Size int64 `json:",omitempty"`
 }
 
 func getSingleFileBackupFilePath(sfBackup *Backup) string {
@@ -28,6 +31,17 @@ func CreateSingleFileBackup(volume *Volume, snapshot *Snapshot, filePath, destUR
 	if err != nil {
 		return "", err
 	}
+	if err := checkWritable(driver); err != nil {
+		return "", err
+	}
+
+	config, err := loadObjectStoreConfig(driver)
+	if err != nil {
+		return "", err
+	}
+	if config.EncryptionKeyID != "" {
+		return "", fmt.Errorf("Single file backup cannot be created in encrypted objectstore")
+	}
 
 	if err := addVolume(volume, driver); err != nil {
 		return "", err
@@ -51,14 +65,24 @@ func CreateSingleFileBackup(volume *Volume, snapshot *Snapshot, filePath, destUR
 		VolumeName:        volume.Name,
 		SnapshotName:      snapshot.Name,
 		SnapshotCreatedAt: snapshot.CreatedTime,
+		Labels:            snapshot.Labels,
+		FormatVersion:     FORMAT_VERSION,
 	}
 	backup.SingleFile.FilePath = getSingleFileBackupFilePath(backup)
 
 	if err := driver.Upload(filePath, backup.SingleFile.FilePath); err != nil {
 		return "", err
 	}
+	if st, err := os.Stat(filePath); err == nil {
+		backup.SingleFile.Size = st.Size()
+	}
 
 	backup.CreatedTime = util.Now()
+	backup.Host = getHostname()
+	copyURL := getBackupCopyURL(destURL, driver.GetURL())
+	if copyURL != "" {
+		backup.Copy = newBackupCopy(backup, copyURL)
+	}
 	if err := saveBackup(backup, driver); err != nil {
 		return "", err
 	}
@@ -70,7 +94,9 @@ func CreateSingleFileBackup(volume *Volume, snapshot *Snapshot, filePath, destUR
 		LOG_FIELD_SNAPSHOT: snapshot.Name,
 	}).Debug("Created backup")
 
-	return encodeBackupURL(backup.Name, volume.Name, destURL), nil
+	backupURL := encodeBackupURL(backup.Name, volume.Name, destURL)
+	startBackupCopy(backupURL, endpoint, copyURL)
+	return backupURL, nil
 }
 
 func RestoreSingleFileBackup(backupURL, endpoint, path string) (string, error) {
@@ -109,6 +135,9 @@ func DeleteSingleFileBackup(backupURL, endpoint string) error {
 	if err != nil {
 		return err
 	}
+	if err := checkWritable(driver); err != nil {
+		return err
+	}
 
 	backupName, volumeName, err := decodeBackupURL(backupURL)
 	if err != nil {
@@ -124,6 +153,9 @@ func DeleteSingleFileBackup(backupURL, endpoint string) error {
 	if err != nil {
 		return err
 	}
+	if err := checkBackupRetention(backup, driver); err != nil {
+		return err
+	}
 
 	if err := driver.Remove(backup.SingleFile.FilePath); err != nil {
 		return err
@@ -0,0 +1,176 @@
+package objectstore
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+/*
+BackupStats is recorded in the backup when it's created, for the capacity
+planning and the trending of the change rate. The changed blocks are the
+blocks read from the snapshot, each of which is either new and uploaded,
+deduplicated against the blocks already in the objectstore or in the same
+backup, or zero and not stored at all. The blocks resumed from a checkpoint
+were uploaded by the interrupted backup, so they are only counted as changed.
+*/
+type BackupStats struct {
+	TotalBlocks   int
+	ChangedBlocks int
+	ChangedSize   int64
+	NewBlocks     int
+	DedupedBlocks int
+	ZeroBlocks    int
+	UploadedSize  int64
+	// How long the backup took, e.g. 1m30s
+	Duration string
+}
+
+// addBlock counts a changed block of length, which was zero, or uploaded
+// with the written bytes, or deduplicated if nothing was written
+func (s *BackupStats) addBlock(length, written int64, zero bool) {
+	s.ChangedBlocks++
+	s.ChangedSize += length
+	switch {
+	case zero:
+		s.ZeroBlocks++
+	case written > 0:
+		s.NewBlocks++
+		s.UploadedSize += written
+	default:
+		s.DedupedBlocks++
+	}
+}
+
+// getDedupRatio returns the ratio of the changed blocks which were not
+// uploaded since they were already stored, not counting the zero blocks
+func (s *BackupStats) getDedupRatio() float64 {
+	stored := s.NewBlocks + s.DedupedBlocks
+	if stored == 0 {
+		return 0
+	}
+	return float64(s.DedupedBlocks) / float64(stored)
+}
+
+type BackupStatsEntry struct {
+	BackupName   string
+	SnapshotName string
+	CreatedTime  string
+	BackupStats
+	DedupRatio float64
+	// The changed bytes per hour since the snapshot of the previous backup,
+	// 0 if it's unknown, e.g. the first backup
+	ChangeRate int64 `json:",omitempty"`
+}
+
+// VolumeStats summarizes the stats of the backups of a volume, in the order
+// of creation. The backups created by the older versions have no stats and
+// are skipped.
+type VolumeStats struct {
+	VolumeName         string
+	Backups            []BackupStatsEntry
+	UploadedSize       int64
+	AverageChangedSize int64
+	AverageChangeRate  int64
+	DedupRatio         float64
+}
+
+func getVolumeStats(volumeName string, driver ObjectStoreDriver) (*VolumeStats, error) {
+	backupNames, err := getBackupNamesForVolume(volumeName, driver)
+	if err != nil {
+		return nil, err
+	}
+	backups := []*Backup{}
+	for _, backupName := range backupNames {
+		backup, err := loadBackup(backupName, volumeName, driver)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, backup)
+	}
+	// The backups created in the same second are ordered by their snapshots
+	sort.SliceStable(backups, func(i, j int) bool {
+		ti, _ := ParseBackupTime(backups[i].CreatedTime)
+		tj, _ := ParseBackupTime(backups[j].CreatedTime)
+		if ti.Equal(tj) {
+			si, _ := ParseBackupTime(backups[i].SnapshotCreatedAt)
+			sj, _ := ParseBackupTime(backups[j].SnapshotCreatedAt)
+			return si.Before(sj)
+		}
+		return ti.Before(tj)
+	})
+
+	stats := &VolumeStats{
+		VolumeName: volumeName,
+		Backups:    []BackupStatsEntry{},
+	}
+	var lastSnapshotTime time.Time
+	total := BackupStats{}
+	changedSize, rates, rated := int64(0), int64(0), int64(0)
+	for _, backup := range backups {
+		last := lastSnapshotTime
+		snapshotTime, err := ParseBackupTime(backup.SnapshotCreatedAt)
+		if err != nil {
+			snapshotTime = time.Time{}
+		}
+		lastSnapshotTime = snapshotTime
+		if backup.Stats == nil {
+			continue
+		}
+		entry := BackupStatsEntry{
+			BackupName:   backup.Name,
+			SnapshotName: backup.SnapshotName,
+			CreatedTime:  backup.CreatedTime,
+			BackupStats:  *backup.Stats,
+			DedupRatio:   backup.Stats.getDedupRatio(),
+		}
+		if !last.IsZero() && !snapshotTime.IsZero() {
+			if hours := snapshotTime.Sub(last).Hours(); hours > 0 {
+				entry.ChangeRate = int64(float64(entry.ChangedSize) / hours)
+				rates += entry.ChangeRate
+				rated++
+			}
+		}
+		stats.Backups = append(stats.Backups, entry)
+
+		stats.UploadedSize += entry.UploadedSize
+		changedSize += entry.ChangedSize
+		total.NewBlocks += entry.NewBlocks
+		total.DedupedBlocks += entry.DedupedBlocks
+	}
+	if n := int64(len(stats.Backups)); n > 0 {
+		stats.AverageChangedSize = changedSize / n
+	}
+	if rated > 0 {
+		stats.AverageChangeRate = rates / rated
+	}
+	stats.DedupRatio = total.getDedupRatio()
+	return stats, nil
+}
+
+// GetBackupStats returns the stats of the backups of the volume in the
+// objectstore, or of all the volumes if volumeName is empty, see VolumeStats
+func GetBackupStats(destURL, endpoint, volumeName string) ([]*VolumeStats, error) {
+	driver, err := GetObjectStoreDriver(destURL, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	volumeNames := []string{volumeName}
+	if volumeName == "" {
+		if volumeNames, err = getVolumeNames(driver); err != nil {
+			return nil, err
+		}
+	} else if !volumeExists(volumeName, driver) {
+		return nil, fmt.Errorf("Volume %v doesn't exist in objectstore", volumeName)
+	}
+
+	result := []*VolumeStats{}
+	for _, name := range volumeNames {
+		stats, err := getVolumeStats(name, driver)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, stats)
+	}
+	return result, nil
+}
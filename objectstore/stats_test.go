@@ -0,0 +1,71 @@
+package objectstore
+
+import (
+	"time"
+
+	"github.com/rancher/convoy/metadata"
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestBackupStats(c *check.C) {
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: 0, Size: 4 * DEFAULT_BLOCK_SIZE},
+		},
+	}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+		Size:   4 * DEFAULT_BLOCK_SIZE,
+	}
+	now := time.Now().UTC()
+	snapshot := &Snapshot{
+		Name:        "snapshot-1",
+		CreatedTime: now.Add(-2 * time.Hour).Format(time.RFC3339),
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, snapshot, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	backup, err := loadBackup(decodeTestBackupName(c, backupURL), testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	// Block 0 and 3 have the same content
+	c.Assert(backup.Stats, check.NotNil)
+	c.Assert(backup.Stats.TotalBlocks, check.Equals, 4)
+	c.Assert(backup.Stats.ChangedBlocks, check.Equals, 4)
+	c.Assert(backup.Stats.ChangedSize, check.Equals, int64(4*DEFAULT_BLOCK_SIZE))
+	c.Assert(backup.Stats.NewBlocks, check.Equals, 3)
+	c.Assert(backup.Stats.DedupedBlocks, check.Equals, 1)
+	c.Assert(backup.Stats.Duration, check.Not(check.Equals), "")
+
+	info, err := GetBackupInfo(backupURL, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(info["UploadedSize"], check.Equals, info["CompressedSize"])
+	c.Assert(info["NewBlocks"], check.Equals, "3")
+
+	// The second backup changes 2 blocks an hour later, which are stored
+	deltaOps.mappings = []metadata.Mapping{
+		{Offset: 0, Size: 2 * DEFAULT_BLOCK_SIZE},
+	}
+	snapshot = &Snapshot{
+		Name:        "snapshot-2",
+		CreatedTime: now.Add(-time.Hour).Format(time.RFC3339),
+	}
+	_, err = CreateDeltaBlockBackup(volume, snapshot, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+
+	stats, err := GetBackupStats(MEM_URL, "", testVolumeName)
+	c.Assert(err, check.IsNil)
+	c.Assert(stats, check.HasLen, 1)
+	c.Assert(stats[0].Backups, check.HasLen, 2)
+	second := stats[0].Backups[1]
+	c.Assert(second.SnapshotName, check.Equals, "snapshot-2")
+	c.Assert(second.TotalBlocks, check.Equals, 4)
+	c.Assert(second.NewBlocks, check.Equals, 0)
+	c.Assert(second.DedupRatio, check.Equals, 1.0)
+	c.Assert(second.ChangeRate, check.Equals, int64(2*DEFAULT_BLOCK_SIZE))
+	c.Assert(stats[0].Backups[0].ChangeRate, check.Equals, int64(0))
+	c.Assert(stats[0].AverageChangedSize, check.Equals, int64(3*DEFAULT_BLOCK_SIZE))
+	c.Assert(stats[0].DedupRatio, check.Equals, 0.5)
+
+	_, err = GetBackupStats(MEM_URL, "", "other-volume")
+	c.Assert(err, check.ErrorMatches, "Volume other-volume doesn't exist in objectstore")
+}
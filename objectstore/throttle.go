@@ -0,0 +1,319 @@
+package objectstore
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	BANDWIDTH_UPLOAD   = "upload"
+	BANDWIDTH_DOWNLOAD = "download"
+
+	// The data would be sent or received in chunks of this size at most, so
+	// the transfers are paced smoothly rather than in bursts of blocks
+	throttleChunkSize = 32 * 1024
+)
+
+// rateLimiter paces the transfers of all the goroutines sharing it to rate
+// bytes per second
+type rateLimiter struct {
+	mutex *sync.Mutex
+	rate  int64
+	// The time when the bytes reserved so far would have been transferred
+	next time.Time
+}
+
+type bandwidthLimit struct {
+	destURL   string
+	direction string
+	limiter   *rateLimiter
+}
+
+var (
+	bandwidthMutex  sync.RWMutex
+	bandwidthLimits []*bandwidthLimit
+)
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{
+		mutex: &sync.Mutex{},
+		rate:  rate,
+	}
+}
+
+// reserve returns how long the caller should wait before transferring n
+// bytes at now
+func (l *rateLimiter) reserve(n int, now time.Time) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(n) * time.Second / time.Duration(l.rate))
+	return l.next.Sub(now)
+}
+
+func (l *rateLimiter) wait(n int) {
+	if d := l.reserve(n, time.Now()); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+/*
+ParseBandwidthLimit parses the limit in the form of "[<dest URL>=]<rate>",
+e.g. "s3://backups@us-west-2/=10M". The rate is in bytes per second, and can
+end in K, M or G. The limit without dest URL applies to all the objectstores.
+*/
+func ParseBandwidthLimit(spec string) (string, int64, error) {
+	destURL, rateSpec := "", spec
+	// The dest URL may contain "=" in its query
+	if i := strings.LastIndex(spec, "="); i >= 0 {
+		destURL, rateSpec = spec[:i], spec[i+1:]
+	}
+	rate, err := util.ParseSize(rateSpec)
+	if err != nil || rate <= 0 {
+		return "", 0, fmt.Errorf("Invalid bandwidth limit %v, should be in the form of [<dest URL>=]<rate>", spec)
+	}
+	return destURL, rate, nil
+}
+
+// RegisterBandwidthLimit limits the upload or download rate of the
+// objectstore at destURL and everything under it, or all the objectstores if
+// destURL is empty. The rate is shared by all the operations on the
+// objectstore. The most specific limit would be used if multiple ones match.
+func RegisterBandwidthLimit(destURL, direction string, rate int64) error {
+	if direction != BANDWIDTH_UPLOAD && direction != BANDWIDTH_DOWNLOAD {
+		return fmt.Errorf("Invalid bandwidth limit direction %v", direction)
+	}
+	if rate <= 0 {
+		return fmt.Errorf("Invalid bandwidth limit %v, must be positive", rate)
+	}
+	if destURL != "" {
+		u, err := url.Parse(destURL)
+		if err != nil {
+			return err
+		}
+		if _, exists := initializers[u.Scheme]; !exists {
+			return fmt.Errorf("Driver %v is not supported!", u.Scheme)
+		}
+		destURL = normalizeURL(destURL)
+	}
+
+	bandwidthMutex.Lock()
+	defer bandwidthMutex.Unlock()
+	bandwidthLimits = append(bandwidthLimits, &bandwidthLimit{
+		destURL:   destURL,
+		direction: direction,
+		limiter:   newRateLimiter(rate),
+	})
+	log.Debugf("Registered %v bandwidth limit %v bytes/s for objectstore %v", direction, rate, destURL)
+	return nil
+}
+
+func getRateLimiter(direction string, destURLs ...string) *rateLimiter {
+	bandwidthMutex.RLock()
+	defer bandwidthMutex.RUnlock()
+
+	var result *bandwidthLimit
+	for _, limit := range bandwidthLimits {
+		if limit.direction != direction {
+			continue
+		}
+		matched := limit.destURL == ""
+		for _, destURL := range destURLs {
+			u := normalizeURL(destURL)
+			if u == limit.destURL || strings.HasPrefix(u, limit.destURL+"/") {
+				matched = true
+			}
+		}
+		if matched && (result == nil || len(limit.destURL) > len(result.destURL)) {
+			result = limit
+		}
+	}
+	if result == nil {
+		return nil
+	}
+	return result.limiter
+}
+
+// throttleDriver wraps the driver if there is any bandwidth limit for it
+func throttleDriver(driver ObjectStoreDriver, destURLs ...string) ObjectStoreDriver {
+	upload := getRateLimiter(BANDWIDTH_UPLOAD, destURLs...)
+	download := getRateLimiter(BANDWIDTH_DOWNLOAD, destURLs...)
+	if upload == nil && download == nil {
+		return driver
+	}
+	return &throttledDriver{
+		ObjectStoreDriver: driver,
+		upload:            upload,
+		download:          download,
+	}
+}
+
+// unthrottled returns the driver wrapped by throttledDriver, which should be
+// used to check the optional interfaces, e.g. ObjectStoreLocker
+func unthrottled(driver ObjectStoreDriver) ObjectStoreDriver {
+	if d, ok := driver.(*throttledDriver); ok {
+		return d.ObjectStoreDriver
+	}
+	return driver
+}
+
+// throttledDriver limits the rate of the data read from and written to the
+// objectstore
+type throttledDriver struct {
+	ObjectStoreDriver
+	upload   *rateLimiter
+	download *rateLimiter
+}
+
+type throttledReader struct {
+	io.Reader
+	limiter *rateLimiter
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunkSize {
+		p = p[:throttleChunkSize]
+	}
+	n, err := r.Reader.Read(p)
+	r.limiter.wait(n)
+	return n, err
+}
+
+type throttledReadSeeker struct {
+	throttledReader
+	seeker io.Seeker
+}
+
+func (r *throttledReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return r.seeker.Seek(offset, whence)
+}
+
+type throttledReadCloser struct {
+	throttledReader
+	closer io.Closer
+}
+
+func (r *throttledReadCloser) Close() error {
+	return r.closer.Close()
+}
+
+func (d *throttledDriver) Read(src string) (io.ReadCloser, error) {
+	rc, err := d.ObjectStoreDriver.Read(src)
+	if err != nil || d.download == nil {
+		return rc, err
+	}
+	return &throttledReadCloser{throttledReader{rc, d.download}, rc}, nil
+}
+
+func (d *throttledDriver) Write(dst string, rs io.ReadSeeker) error {
+	if d.upload == nil {
+		return d.ObjectStoreDriver.Write(dst, rs)
+	}
+	return d.ObjectStoreDriver.Write(dst, &throttledReadSeeker{throttledReader{rs, d.upload}, rs})
+}
+
+func (d *throttledDriver) Upload(src, dst string) error {
+	if d.upload == nil {
+		return d.ObjectStoreDriver.Upload(src, dst)
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return d.Write(dst, f)
+}
+
+func (d *throttledDriver) Download(src, dst string) error {
+	if d.download == nil {
+		return d.ObjectStoreDriver.Download(src, dst)
+	}
+	rc, err := d.Read(src)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, rc); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+var (
+	snapshotReadMutex sync.RWMutex
+	snapshotReadRate  *rateLimiter
+	snapshotReadIOPS  *rateLimiter
+)
+
+/*
+SetSnapshotReadLimits limits the reads of the snapshots by the backups to
+rate bytes and iops reads per second, so the workloads sharing the storage
+with the snapshots, e.g. the same thin pool or EBS volume, won't be starved.
+The limits are shared by all the backups, and 0 means no limit.
+*/
+func SetSnapshotReadLimits(rate int64, iops int) error {
+	if rate < 0 {
+		return fmt.Errorf("Invalid snapshot read limit %v, must not be negative", rate)
+	}
+	if iops < 0 {
+		return fmt.Errorf("Invalid snapshot read IOPS limit %v, must not be negative", iops)
+	}
+	snapshotReadMutex.Lock()
+	defer snapshotReadMutex.Unlock()
+	snapshotReadRate, snapshotReadIOPS = nil, nil
+	if rate > 0 {
+		snapshotReadRate = newRateLimiter(rate)
+	}
+	if iops > 0 {
+		snapshotReadIOPS = newRateLimiter(int64(iops))
+	}
+	log.Debugf("Set snapshot read limits to %v bytes/s and %v reads/s", rate, iops)
+	return nil
+}
+
+// throttleSnapshotReads wraps deltaOps if there is any snapshot read limit
+func throttleSnapshotReads(deltaOps DeltaBlockBackupOperations) DeltaBlockBackupOperations {
+	snapshotReadMutex.RLock()
+	defer snapshotReadMutex.RUnlock()
+	if snapshotReadRate == nil && snapshotReadIOPS == nil {
+		return deltaOps
+	}
+	return &throttledDeltaOps{
+		DeltaBlockBackupOperations: deltaOps,
+		rate:                       snapshotReadRate,
+		iops:                       snapshotReadIOPS,
+	}
+}
+
+// throttledDeltaOps paces the reads of the snapshots, each read is counted
+// as one IO regardless of its size
+type throttledDeltaOps struct {
+	DeltaBlockBackupOperations
+	rate *rateLimiter
+	iops *rateLimiter
+}
+
+func (d *throttledDeltaOps) ReadSnapshot(id, volumeID string, start int64, data []byte) error {
+	if d.iops != nil {
+		d.iops.wait(1)
+	}
+	if d.rate != nil {
+		d.rate.wait(len(data))
+	}
+	return d.DeltaBlockBackupOperations.ReadSnapshot(id, volumeID, start, data)
+}
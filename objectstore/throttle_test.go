@@ -0,0 +1,110 @@
+package objectstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestRateLimiter(c *check.C) {
+	now := time.Date(2016, 2, 27, 10, 0, 0, 0, time.UTC)
+	limiter := newRateLimiter(1000)
+	c.Assert(limiter.reserve(500, now), check.Equals, 500*time.Millisecond)
+	c.Assert(limiter.reserve(500, now), check.Equals, time.Second)
+	c.Assert(limiter.reserve(1000, now.Add(500*time.Millisecond)), check.Equals, 1500*time.Millisecond)
+	// The unused bandwidth wouldn't be accumulated
+	c.Assert(limiter.reserve(100, now.Add(time.Minute)), check.Equals, 100*time.Millisecond)
+}
+
+func (s *TestSuite) TestParseBandwidthLimit(c *check.C) {
+	destURL, rate, err := ParseBandwidthLimit("10M")
+	c.Assert(err, check.IsNil)
+	c.Assert(destURL, check.Equals, "")
+	c.Assert(rate, check.Equals, int64(10*1024*1024))
+
+	destURL, rate, err = ParseBandwidthLimit("s3://backups@us-west-2/?path-style=false=512k")
+	c.Assert(err, check.IsNil)
+	c.Assert(destURL, check.Equals, "s3://backups@us-west-2/?path-style=false")
+	c.Assert(rate, check.Equals, int64(512*1024))
+
+	_, _, err = ParseBandwidthLimit("mem:///=0")
+	c.Assert(err, check.ErrorMatches, "Invalid bandwidth limit .*")
+	_, _, err = ParseBandwidthLimit("mem:///=fast")
+	c.Assert(err, check.ErrorMatches, "Invalid bandwidth limit .*")
+}
+
+func (s *TestSuite) TestThrottledDriver(c *check.C) {
+	defer func() {
+		bandwidthLimits = nil
+	}()
+
+	driver, err := GetObjectStoreDriver(MEM_URL, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(driver, check.Equals, memStore)
+
+	c.Assert(RegisterBandwidthLimit("", BANDWIDTH_DOWNLOAD, 1<<30), check.IsNil)
+	c.Assert(RegisterBandwidthLimit(MEM_URL+"path", BANDWIDTH_UPLOAD, 1<<30), check.IsNil)
+	c.Assert(RegisterBandwidthLimit(MEM_URL, BANDWIDTH_UPLOAD, 1<<20), check.IsNil)
+	c.Assert(RegisterBandwidthLimit("unknown:///", BANDWIDTH_UPLOAD, 1<<20), check.ErrorMatches, "Driver unknown is not supported!")
+	c.Assert(RegisterBandwidthLimit(MEM_URL, "sideways", 1<<20), check.ErrorMatches, "Invalid bandwidth limit direction .*")
+
+	c.Assert(getRateLimiter(BANDWIDTH_UPLOAD, "mem:///path/to"), check.Equals, bandwidthLimits[1].limiter)
+	c.Assert(getRateLimiter(BANDWIDTH_UPLOAD, "mem:///other"), check.Equals, bandwidthLimits[2].limiter)
+	c.Assert(getRateLimiter(BANDWIDTH_DOWNLOAD, "mem:///other"), check.Equals, bandwidthLimits[0].limiter)
+
+	driver, err = GetObjectStoreDriver(MEM_URL, "")
+	c.Assert(err, check.IsNil)
+	throttled, ok := driver.(*throttledDriver)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(throttled.upload, check.Equals, bandwidthLimits[2].limiter)
+	c.Assert(unthrottled(driver), check.Equals, memStore)
+
+	data := []byte("throttled data")
+	c.Assert(driver.Write("file", bytes.NewReader(data)), check.IsNil)
+	rc, err := driver.Read("file")
+	c.Assert(err, check.IsNil)
+	read, err := ioutil.ReadAll(rc)
+	c.Assert(err, check.IsNil)
+	c.Assert(rc.Close(), check.IsNil)
+	c.Assert(read, check.DeepEquals, data)
+
+	// The lock of the wrapped driver is still used
+	unlock, err := lockVolume(testVolumeName, driver)
+	c.Assert(err, check.IsNil)
+	c.Assert(memStore.locks, check.HasLen, 1)
+	unlock()
+}
+
+func (s *TestSuite) TestThrottledSnapshotReads(c *check.C) {
+	defer SetSnapshotReadLimits(0, 0)
+
+	deltaOps := &dataDeltaOps{data: randomData(4 * DEFAULT_BLOCK_SIZE)}
+	c.Assert(throttleSnapshotReads(deltaOps), check.Equals, deltaOps)
+
+	c.Assert(SetSnapshotReadLimits(-1, 0), check.ErrorMatches, "Invalid snapshot read limit .*")
+	c.Assert(SetSnapshotReadLimits(0, -1), check.ErrorMatches, "Invalid snapshot read IOPS limit .*")
+	c.Assert(SetSnapshotReadLimits(1<<30, 1000), check.IsNil)
+	throttled, ok := throttleSnapshotReads(deltaOps).(*throttledDeltaOps)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(throttled.rate, check.NotNil)
+	c.Assert(throttled.iops, check.NotNil)
+
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+		Size:   int64(len(deltaOps.data)),
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	volFile := filepath.Join(c.MkDir(), "volume")
+	c.Assert(RestoreDeltaBlockBackup(backupURL, "", volFile), check.IsNil)
+	data, err := ioutil.ReadFile(volFile)
+	c.Assert(err, check.IsNil)
+	c.Assert(bytes.Equal(data, deltaOps.data), check.Equals, true)
+
+	c.Assert(SetSnapshotReadLimits(0, 0), check.IsNil)
+	c.Assert(throttleSnapshotReads(deltaOps), check.Equals, deltaOps)
+}
@@ -0,0 +1,208 @@
+package objectstore
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	/*
+		FORMAT_VERSION is the version of the format of the objectstore config,
+		volume configs and backup configs written by this version of Convoy.
+		The configs without the version are of version 0, which rely on the
+		defaults of the fields added later, e.g. block size and hash. The
+		version needs to be increased, with a new step in the upgrade
+		functions, every time the format is changed incompatibly.
+	*/
+	FORMAT_VERSION = 2
+)
+
+// UpgradeReport is the result of the upgrade of an objectstore
+type UpgradeReport struct {
+	DestURL         string
+	DryRun          bool
+	FormatVersion   int
+	ConfigUpgraded  bool
+	UpgradedVolumes []string
+	UpgradedBackups int
+}
+
+// checkFormatVersion refuses the config written by a newer version of
+// Convoy, which cannot be understood correctly
+func checkFormatVersion(filePath string, version int) error {
+	if version > FORMAT_VERSION {
+		return fmt.Errorf("Format version %v of %v in objectstore is newer than %v supported, please upgrade Convoy",
+			version, filePath, FORMAT_VERSION)
+	}
+	return nil
+}
+
+// upgradeObjectStoreConfig upgrades the config to FORMAT_VERSION, and
+// returns false if it's up to date
+func upgradeObjectStoreConfig(config *ObjectStoreConfig) bool {
+	if config.FormatVersion >= FORMAT_VERSION {
+		return false
+	}
+	for ; config.FormatVersion < FORMAT_VERSION; config.FormatVersion++ {
+		switch config.FormatVersion {
+		case 0:
+			config.BlockSize = config.getBlockSize()
+			config.Hash = config.getHash()
+		}
+	}
+	return true
+}
+
+// upgradeVolumeConfig upgrades the volume config to FORMAT_VERSION, and
+// returns false if it's up to date
+func upgradeVolumeConfig(volume *Volume) bool {
+	if volume.FormatVersion >= FORMAT_VERSION {
+		return false
+	}
+	// Nothing changed in volume configs since version 0 yet
+	volume.FormatVersion = FORMAT_VERSION
+	return true
+}
+
+// upgradeBackup upgrades the backup config to FORMAT_VERSION, and returns
+// false if it's up to date. The blocks merged from the last backup may be
+// of older version, so it's also applied to the new backups.
+func upgradeBackup(backup *Backup) bool {
+	upgraded := false
+	for ; backup.FormatVersion < FORMAT_VERSION; backup.FormatVersion++ {
+		switch backup.FormatVersion {
+		case 0:
+			if len(backup.Blocks) != 0 {
+				backup.BlockSize = backup.getBlockSize()
+				backup.Hash = backup.getHash()
+			}
+			for i := range backup.Blocks {
+				backup.Blocks[i].Compression = blockCompression(backup.Blocks[i])
+			}
+		case 1:
+			// The keys encrypted the existing blocks are unknown without
+			// reading the block files, see BlockMapping.EncryptionKeyID
+		}
+		upgraded = true
+	}
+	return upgraded
+}
+
+/*
+UpgradeObjectStore migrates the configs in the objectstore written by older
+versions of Convoy to FORMAT_VERSION in place, so they won't be orphaned by
+the later format changes. The backups of each volume are upgraded before the
+volume, and the objectstore config is upgraded at last, so the interrupted
+upgrade can be simply run again. Nothing would be written if dryRun is true,
+but the report is still generated.
+*/
+func UpgradeObjectStore(destURL, endpoint string, dryRun bool) (*UpgradeReport, error) {
+	driver, err := GetObjectStoreDriver(destURL, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if !dryRun {
+		if err := checkWritable(driver); err != nil {
+			return nil, err
+		}
+	}
+
+	report := &UpgradeReport{
+		DestURL:         driver.GetURL(),
+		DryRun:          dryRun,
+		FormatVersion:   FORMAT_VERSION,
+		UpgradedVolumes: []string{},
+	}
+
+	volumeNames, err := getVolumeNames(driver)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(volumeNames)
+	for _, volumeName := range volumeNames {
+		if err := upgradeVolume(volumeName, driver, dryRun, report); err != nil {
+			return nil, err
+		}
+	}
+
+	// The objectstore not configured yet would be configured in the current
+	// format by the next backup
+	filePath := getObjectStoreConfigPath()
+	if driver.FileExists(filePath) {
+		config := &ObjectStoreConfig{}
+		if err := loadConfigInObjectStore(filePath, driver, config); err != nil {
+			return nil, err
+		}
+		if err := checkFormatVersion(filePath, config.FormatVersion); err != nil {
+			return nil, err
+		}
+		if upgradeObjectStoreConfig(config) {
+			report.ConfigUpgraded = true
+			if !dryRun {
+				if err := saveConfigInObjectStore(filePath, driver, config); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_COMPLETE,
+		LOG_FIELD_KIND:   driver.Kind(),
+	}).Debugf("Upgraded objectstore %v to format version %v, %v volumes and %v backups upgraded",
+		report.DestURL, FORMAT_VERSION, len(report.UpgradedVolumes), report.UpgradedBackups)
+	return report, nil
+}
+
+func upgradeVolume(volumeName string, driver ObjectStoreDriver, dryRun bool, report *UpgradeReport) error {
+	unlock, err := lockVolume(volumeName, driver)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	volume, err := loadVolume(volumeName, driver)
+	if err != nil {
+		return err
+	}
+	backupNames, err := getBackupNamesForVolume(volumeName, driver)
+	if err != nil {
+		return err
+	}
+	upgraded := false
+	for _, backupName := range backupNames {
+		backup, err := loadBackup(backupName, volumeName, driver)
+		if err != nil {
+			return err
+		}
+		if !upgradeBackup(backup) {
+			continue
+		}
+		upgraded = true
+		report.UpgradedBackups++
+		log.Debugf("Upgrading backup %v of volume %v to format version %v", backupName, volumeName, FORMAT_VERSION)
+		if dryRun {
+			continue
+		}
+		if err := saveBackup(backup, driver); err != nil {
+			return err
+		}
+	}
+
+	if upgradeVolumeConfig(volume) {
+		upgraded = true
+		if !dryRun {
+			if err := saveVolume(volume, driver); err != nil {
+				return err
+			}
+		}
+	}
+	if upgraded {
+		report.UpgradedVolumes = append(report.UpgradedVolumes, volumeName)
+	}
+	return nil
+}
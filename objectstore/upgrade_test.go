@@ -0,0 +1,65 @@
+package objectstore
+
+import (
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestUpgradeObjectStore(c *check.C) {
+	// Written in format version 0
+	s.createTestBackupChain(c)
+	config := &ObjectStoreConfig{Compression: COMPRESSION_GZIP}
+	c.Assert(saveConfigInObjectStore(getObjectStoreConfigPath(), memStore, config), check.IsNil)
+
+	report, err := UpgradeObjectStore(MEM_URL, "", true)
+	c.Assert(err, check.IsNil)
+	c.Check(report.FormatVersion, check.Equals, FORMAT_VERSION)
+	c.Check(report.ConfigUpgraded, check.Equals, true)
+	c.Check(report.UpgradedVolumes, check.DeepEquals, []string{testVolumeName})
+	c.Check(report.UpgradedBackups, check.Equals, 3)
+	backup, err := loadBackup("backup-1", testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Check(backup.FormatVersion, check.Equals, 0)
+
+	report, err = UpgradeObjectStore(MEM_URL, "", false)
+	c.Assert(err, check.IsNil)
+	c.Check(report.UpgradedBackups, check.Equals, 3)
+	backup, err = loadBackup("backup-1", testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Check(backup.FormatVersion, check.Equals, FORMAT_VERSION)
+	c.Check(backup.BlockSize, check.Equals, int64(DEFAULT_BLOCK_SIZE))
+	c.Check(backup.Hash, check.Equals, HASH_SHA512)
+	c.Check(backup.Blocks[0].Compression, check.Equals, COMPRESSION_GZIP)
+	// The blocks are still at the same place
+	c.Check(getBlockFilePath(testVolumeName, backup.Blocks[0]), check.Equals, testBlockFile("aaaa1111"))
+	volume, err := loadVolume(testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Check(volume.FormatVersion, check.Equals, FORMAT_VERSION)
+	config, err = loadObjectStoreConfig(memStore)
+	c.Assert(err, check.IsNil)
+	c.Check(config.FormatVersion, check.Equals, FORMAT_VERSION)
+	c.Check(config.BlockSize, check.Equals, int64(DEFAULT_BLOCK_SIZE))
+	c.Check(config.Hash, check.Equals, HASH_SHA512)
+
+	report, err = UpgradeObjectStore(MEM_URL, "", false)
+	c.Assert(err, check.IsNil)
+	c.Check(report.ConfigUpgraded, check.Equals, false)
+	c.Check(report.UpgradedVolumes, check.HasLen, 0)
+	c.Check(report.UpgradedBackups, check.Equals, 0)
+
+	// Written in format version 1, without the keys of the blocks
+	backup.FormatVersion = 1
+	c.Assert(saveBackup(backup, memStore), check.IsNil)
+	backup, err = loadBackup("backup-1", testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Check(upgradeBackup(backup), check.Equals, true)
+	c.Check(backup.FormatVersion, check.Equals, FORMAT_VERSION)
+	c.Check(backup.Blocks[0].EncryptionKeyID, check.Equals, "")
+
+	// Written by a newer version
+	backup.FormatVersion = FORMAT_VERSION + 1
+	c.Assert(saveBackup(backup, memStore), check.IsNil)
+	_, err = loadBackup("backup-1", testVolumeName, memStore)
+	c.Assert(err, check.ErrorMatches, "Format version 3 of .* is newer than 2 supported.*")
+	_, err = UpgradeObjectStore(MEM_URL, "", false)
+	c.Assert(err, check.ErrorMatches, "Format version 3 of .* is newer than 2 supported.*")
+}
@@ -0,0 +1,220 @@
+package objectstore
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+
+	. "github.com/rancher/convoy/logging"
+)
+
+// BlockReport describes a block file of the backup failed the verification
+type BlockReport struct {
+	File          string
+	BlockChecksum string  `json:",omitempty"`
+	Offsets       []int64 `json:",omitempty"`
+	Error         string
+}
+
+/*
+VerifyReport is the result of the verification of a backup. Every block file
+referenced by the backup would be checked for existence, and the sampled ones
+would be downloaded, decrypted, decompressed and have the checksum verified.
+The archived blocks cannot be downloaded, so they're only checked for
+existence.
+*/
+type VerifyReport struct {
+	BackupURL  string
+	BackupName string
+	VolumeName string
+	// Percentage of the blocks would be downloaded and verified
+	Sample int
+
+	TotalBlocks    int
+	VerifiedBlocks int
+	ArchivedBlocks int
+	MissingBlocks  []BlockReport
+	CorruptBlocks  []BlockReport
+	Valid          bool
+}
+
+type verifyJob struct {
+	file   string
+	block  BlockMapping
+	hash   string
+	sample bool
+}
+
+func verifyBlock(driver ObjectStoreDriver, job verifyJob, aead *objectCipher) (verified, archived bool, missing, corrupt error) {
+	if driver.FileSize(job.file) < 0 {
+		return false, false, fmt.Errorf("cannot find %v in objectstore", job.file), nil
+	}
+	if archiver, ok := unthrottled(driver).(ObjectStoreArchiver); ok {
+		status, err := archiver.ArchiveStatus(job.file)
+		if err != nil {
+			return false, false, nil, err
+		}
+		if status != ARCHIVE_STATUS_AVAILABLE {
+			return false, true, nil, nil
+		}
+	}
+	if !job.sample {
+		return false, false, nil, nil
+	}
+
+	rc, err := driver.Read(job.file)
+	if err != nil {
+		return false, false, nil, err
+	}
+	defer rc.Close()
+	r, err := readBlock(job.file, job.block, job.hash, rc, aead)
+	if err != nil {
+		return false, false, nil, err
+	}
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return false, false, nil, err
+	}
+	return true, false, nil, nil
+}
+
+func verifyBlocks(driver ObjectStoreDriver, backup *Backup, sample int, report *VerifyReport) error {
+	config, err := loadObjectStoreConfig(driver)
+	if err != nil {
+		return err
+	}
+	aead, err := config.getCipher()
+	if err != nil {
+		return err
+	}
+	if err := ValidateHash(backup.getHash()); err != nil {
+		return err
+	}
+
+	offsets := map[string][]int64{}
+	jobs := []verifyJob{}
+	for _, block := range getStoredBlocks(backup.Blocks) {
+		blkFile := getBlockFilePath(backup.VolumeName, block)
+		if _, ok := offsets[blkFile]; !ok {
+			jobs = append(jobs, verifyJob{
+				file:   blkFile,
+				block:  block,
+				hash:   backup.getHash(),
+				sample: rand.Intn(100) < sample,
+			})
+		}
+		offsets[blkFile] = append(offsets[blkFile], block.Offset)
+	}
+	report.TotalBlocks = len(jobs)
+
+	mutex := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	jobCh := make(chan verifyJob)
+	for i := 0; i < backupWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobCh {
+				verified, archived, missing, corrupt := verifyBlock(driver, job, aead)
+
+				mutex.Lock()
+				if verified {
+					report.VerifiedBlocks++
+				}
+				if archived {
+					report.ArchivedBlocks++
+				}
+				if missing != nil {
+					report.MissingBlocks = append(report.MissingBlocks, BlockReport{
+						File:          job.file,
+						BlockChecksum: job.block.BlockChecksum,
+						Offsets:       offsets[job.file],
+						Error:         missing.Error(),
+					})
+				}
+				if corrupt != nil {
+					report.CorruptBlocks = append(report.CorruptBlocks, BlockReport{
+						File:          job.file,
+						BlockChecksum: job.block.BlockChecksum,
+						Offsets:       offsets[job.file],
+						Error:         corrupt.Error(),
+					})
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	for _, job := range jobs {
+		jobCh <- job
+	}
+	close(jobCh)
+	wg.Wait()
+
+	sortBlockReports(report.MissingBlocks)
+	sortBlockReports(report.CorruptBlocks)
+	return nil
+}
+
+func sortBlockReports(reports []BlockReport) {
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Offsets[0] < reports[j].Offsets[0]
+	})
+}
+
+/*
+VerifyBackup audits the blocks referenced by the backup. sample is the
+percentage of the blocks would be downloaded and verified by checksum, the
+others would only be checked for existence. The backup is valid if no block
+is missing or corrupted.
+*/
+func VerifyBackup(backupURL, endpoint string, sample int) (*VerifyReport, error) {
+	if sample < 0 || sample > 100 {
+		return nil, fmt.Errorf("Invalid sample percentage %v, must be between 0 and 100", sample)
+	}
+	driver, err := GetObjectStoreDriver(backupURL, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	backupName, volumeName, err := decodeBackupURL(backupURL)
+	if err != nil {
+		return nil, err
+	}
+	backup, err := loadBackup(backupName, volumeName, driver)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &VerifyReport{
+		BackupURL:     encodeBackupURL(backup.Name, backup.VolumeName, driver.GetURL()),
+		BackupName:    backup.Name,
+		VolumeName:    backup.VolumeName,
+		Sample:        sample,
+		MissingBlocks: []BlockReport{},
+		CorruptBlocks: []BlockReport{},
+	}
+	if backup.SingleFile.FilePath != "" {
+		// No checksum was recorded for single file backup
+		report.TotalBlocks = 1
+		if driver.FileSize(backup.SingleFile.FilePath) < 0 {
+			report.MissingBlocks = append(report.MissingBlocks, BlockReport{
+				File:  backup.SingleFile.FilePath,
+				Error: fmt.Sprintf("cannot find %v in objectstore", backup.SingleFile.FilePath),
+			})
+		}
+	} else if err := verifyBlocks(driver, backup, sample, report); err != nil {
+		return nil, err
+	}
+	report.Valid = len(report.MissingBlocks) == 0 && len(report.CorruptBlocks) == 0
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_COMPLETE,
+		LOG_FIELD_VOLUME: backup.VolumeName,
+	}).Debugf("Verified backup %v, %v of %v blocks verified, %v missing, %v corrupted",
+		backup.Name, report.VerifiedBlocks, report.TotalBlocks,
+		len(report.MissingBlocks), len(report.CorruptBlocks))
+	return report, nil
+}
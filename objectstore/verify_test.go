@@ -0,0 +1,79 @@
+package objectstore
+
+import (
+	"bytes"
+
+	"github.com/rancher/convoy/metadata"
+	"gopkg.in/check.v1"
+)
+
+func (s *TestSuite) createVerifyTestBackup(c *check.C) (string, *Backup) {
+	deltaOps := &fakeDeltaOps{
+		mappings: []metadata.Mapping{
+			{Offset: 0, Size: 4 * DEFAULT_BLOCK_SIZE},
+		},
+	}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+	}
+	snapshot := &Snapshot{
+		Name: "snapshot",
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, snapshot, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	backupName, _, err := decodeBackupURL(backupURL)
+	c.Assert(err, check.IsNil)
+	backup, err := loadBackup(backupName, testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	return backupURL, backup
+}
+
+func (s *TestSuite) TestVerifyBackup(c *check.C) {
+	backupURL, backup := s.createVerifyTestBackup(c)
+
+	report, err := VerifyBackup(backupURL, "", 100)
+	c.Assert(err, check.IsNil)
+	c.Check(report.BackupURL, check.Equals, backupURL)
+	c.Check(report.TotalBlocks, check.Equals, 3)
+	c.Check(report.VerifiedBlocks, check.Equals, 3)
+	c.Check(report.MissingBlocks, check.HasLen, 0)
+	c.Check(report.CorruptBlocks, check.HasLen, 0)
+	c.Check(report.Valid, check.Equals, true)
+
+	// Block 0 and 3 share the same file
+	c.Assert(memStore.Remove(getBlockFilePath(testVolumeName, backup.Blocks[0])), check.IsNil)
+	err = memStore.Write(getBlockFilePath(testVolumeName, backup.Blocks[1]), bytes.NewReader([]byte("corrupted")))
+	c.Assert(err, check.IsNil)
+
+	report, err = VerifyBackup(backupURL, "", 100)
+	c.Assert(err, check.IsNil)
+	c.Check(report.VerifiedBlocks, check.Equals, 1)
+	c.Assert(report.MissingBlocks, check.HasLen, 1)
+	c.Check(report.MissingBlocks[0].BlockChecksum, check.Equals, backup.Blocks[0].BlockChecksum)
+	c.Check(report.MissingBlocks[0].Offsets, check.DeepEquals, []int64{0, 3 * DEFAULT_BLOCK_SIZE})
+	c.Assert(report.CorruptBlocks, check.HasLen, 1)
+	c.Check(report.CorruptBlocks[0].Offsets, check.DeepEquals, []int64{DEFAULT_BLOCK_SIZE})
+	c.Check(report.Valid, check.Equals, false)
+
+	// Only the existence is checked without sampling
+	report, err = VerifyBackup(backupURL, "", 0)
+	c.Assert(err, check.IsNil)
+	c.Check(report.VerifiedBlocks, check.Equals, 0)
+	c.Check(report.MissingBlocks, check.HasLen, 1)
+	c.Check(report.CorruptBlocks, check.HasLen, 0)
+
+	_, err = VerifyBackup(backupURL, "", 101)
+	c.Assert(err, check.ErrorMatches, "Invalid sample percentage.*")
+}
+
+func (s *TestSuite) TestVerifyArchivedBackup(c *check.C) {
+	backupURL, backup := s.createVerifyTestBackup(c)
+	memStore.archived[getBlockFilePath(testVolumeName, backup.Blocks[0])] = ARCHIVE_STATUS_ARCHIVED
+
+	report, err := VerifyBackup(backupURL, "", 100)
+	c.Assert(err, check.IsNil)
+	c.Check(report.VerifiedBlocks, check.Equals, 2)
+	c.Check(report.ArchivedBlocks, check.Equals, 1)
+	c.Check(report.Valid, check.Equals, true)
+}
@@ -0,0 +1,64 @@
+package objectstore
+
+import (
+	"bytes"
+)
+
+const (
+	// The checksum recorded for the blocks of all zeros, which are not
+	// stored in objectstore but synthesized when restoring
+	ZERO_BLOCK_CHECKSUM = "zero"
+)
+
+var zeroPage = make([]byte, 4096)
+
+// isZeroData checks if every byte of data is zero
+func isZeroData(data []byte) bool {
+	for len(data) > 0 {
+		n := len(data)
+		if n > len(zeroPage) {
+			n = len(zeroPage)
+		}
+		if !bytes.Equal(data[:n], zeroPage[:n]) {
+			return false
+		}
+		data = data[n:]
+	}
+	return true
+}
+
+// zeroBlockMapping returns the mapping of the block of all zeros at offset,
+// length is only recorded for the chunks
+func zeroBlockMapping(offset, length int64) BlockMapping {
+	return BlockMapping{
+		Offset:        offset,
+		BlockChecksum: ZERO_BLOCK_CHECKSUM,
+		Length:        length,
+	}
+}
+
+func isZeroBlock(block BlockMapping) bool {
+	return block.BlockChecksum == ZERO_BLOCK_CHECKSUM
+}
+
+// getStoredBlocks returns the blocks have block files in objectstore, which
+// are all the blocks except the zero ones
+func getStoredBlocks(blocks []BlockMapping) []BlockMapping {
+	result := []BlockMapping{}
+	for _, blk := range blocks {
+		if !isZeroBlock(blk) {
+			result = append(result, blk)
+		}
+	}
+	return result
+}
+
+// zeroReader reads zeros endlessly
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,66 @@
+package objectstore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/check.v1"
+)
+
+func getTestBlockFiles() int {
+	count := 0
+	for f := range memStore.files {
+		if strings.HasSuffix(f, BLOCK_FILE_SUFFIX) {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *TestSuite) TestCreateBackupWithZeroBlocks(c *check.C) {
+	data := randomData(4 * DEFAULT_BLOCK_SIZE)
+	copy(data[DEFAULT_BLOCK_SIZE:], make([]byte, DEFAULT_BLOCK_SIZE))
+	copy(data[3*DEFAULT_BLOCK_SIZE:], make([]byte, DEFAULT_BLOCK_SIZE))
+	deltaOps := &dataDeltaOps{data: data}
+	volume := &Volume{
+		Name:   testVolumeName,
+		Driver: "test",
+		Size:   4 * DEFAULT_BLOCK_SIZE,
+	}
+	backupURL, err := CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-1"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	backup, err := loadBackup(decodeTestBackupName(c, backupURL), testVolumeName, memStore)
+	c.Assert(err, check.IsNil)
+	c.Assert(backup.Blocks, check.HasLen, 4)
+	c.Assert(isZeroBlock(backup.Blocks[1]), check.Equals, true)
+	c.Assert(isZeroBlock(backup.Blocks[3]), check.Equals, true)
+	c.Assert(getTestBlockFiles(), check.Equals, 2)
+
+	info, err := GetBackupInfo(backupURL, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(info["CompressedSize"], check.Not(check.Equals), "0")
+
+	// The zero blocks would be holes of the file restored
+	volFile := filepath.Join(c.MkDir(), "volume")
+	c.Assert(ioutil.WriteFile(volFile, bytes.Repeat([]byte{1}, len(data)), 0600), check.IsNil)
+	c.Assert(RestoreDeltaBlockBackup(backupURL, "", volFile), check.IsNil)
+	restored, err := ioutil.ReadFile(volFile)
+	c.Assert(err, check.IsNil)
+	c.Assert(bytes.Equal(restored, data), check.Equals, true)
+
+	// The block becomes zero would replace the one of the last backup
+	deltaOps.data = append([]byte{}, data...)
+	copy(deltaOps.data, make([]byte, DEFAULT_BLOCK_SIZE))
+	backupURL, err = CreateDeltaBlockBackup(volume, &Snapshot{Name: "snapshot-2"}, MEM_URL, "", deltaOps)
+	c.Assert(err, check.IsNil)
+	c.Assert(RestoreDeltaBlockBackup(backupURL, "", volFile), check.IsNil)
+	restored, err = ioutil.ReadFile(volFile)
+	c.Assert(err, check.IsNil)
+	c.Assert(bytes.Equal(restored, deltaOps.data), check.Equals, true)
+
+	report, err := VerifyBackup(backupURL, "", 100)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.TotalBlocks, check.Equals, 1)
+}
@@ -0,0 +1,182 @@
+package oss
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/objectstore"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "oss"})
+)
+
+type OSSObjectStoreDriver struct {
+	destURL string
+	path    string
+	service OSSService
+}
+
+const (
+	KIND = "oss"
+
+	ENV_ACCESS_KEY_ID     = "ALIBABA_CLOUD_ACCESS_KEY_ID"
+	ENV_ACCESS_KEY_SECRET = "ALIBABA_CLOUD_ACCESS_KEY_SECRET"
+	ENV_SECURITY_TOKEN    = "ALIBABA_CLOUD_SECURITY_TOKEN"
+)
+
+func init() {
+	if err := objectstore.RegisterDriver(KIND, initFunc); err != nil {
+		panic(err)
+	}
+}
+
+func initFunc(destURL, endpoint string) (objectstore.ObjectStoreDriver, error) {
+	return initFuncWithConnectionCheck(destURL, endpoint, func(d objectstore.ObjectStoreDriver) error {
+		_, err := d.List("")
+		return err
+	})
+}
+
+func initFuncWithConnectionCheck(destURL, endpoint string, connectionTest func(d objectstore.ObjectStoreDriver) error) (objectstore.ObjectStoreDriver, error) {
+	b := &OSSObjectStoreDriver{}
+
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != KIND {
+		return nil, fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, KIND)
+	}
+
+	if u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return nil, fmt.Errorf("Invalid URL. Must be oss://bucket@region/path/")
+	}
+	b.service.Bucket = u.User.Username()
+	b.service.Region = u.Host
+
+	b.service.AccessKeyID = os.Getenv(ENV_ACCESS_KEY_ID)
+	b.service.AccessKeySecret = os.Getenv(ENV_ACCESS_KEY_SECRET)
+	if b.service.AccessKeyID == "" || b.service.AccessKeySecret == "" {
+		return nil, fmt.Errorf("Cannot find OSS AccessKey, %v and %v need to be set",
+			ENV_ACCESS_KEY_ID, ENV_ACCESS_KEY_SECRET)
+	}
+	b.service.SecurityToken = os.Getenv(ENV_SECURITY_TOKEN)
+
+	b.path = strings.TrimLeft(u.Path, "/")
+
+	if err := connectionTest(b); err != nil {
+		return nil, err
+	}
+
+	b.destURL = KIND + "://" + b.service.Bucket + "@" + b.service.Region + "/" + b.path
+	log.Debugf("Loaded driver for %v", b.destURL)
+	return b, nil
+}
+
+func (o *OSSObjectStoreDriver) Kind() string {
+	return KIND
+}
+
+func (o *OSSObjectStoreDriver) GetURL() string {
+	return o.destURL
+}
+
+func (o *OSSObjectStoreDriver) updatePath(path string) string {
+	return filepath.Join(o.path, path)
+}
+
+func (o *OSSObjectStoreDriver) List(listPath string) ([]string, error) {
+	var result []string
+
+	path := o.updatePath(listPath) + "/"
+	if path == "/" {
+		path = ""
+	}
+	objects, prefixes, err := o.service.ListObjects(path, "/")
+	if err != nil {
+		log.Error("Fail to list oss: ", err)
+		return result, err
+	}
+
+	for _, obj := range objects {
+		r := strings.TrimPrefix(obj.Key, path)
+		if r != "" {
+			result = append(result, r)
+		}
+	}
+	for _, p := range prefixes {
+		r := strings.TrimPrefix(p.Prefix, path)
+		r = strings.TrimSuffix(r, "/")
+		if r != "" {
+			result = append(result, r)
+		}
+	}
+
+	return result, nil
+}
+
+func (o *OSSObjectStoreDriver) FileExists(filePath string) bool {
+	return o.FileSize(filePath) >= 0
+}
+
+func (o *OSSObjectStoreDriver) FileSize(filePath string) int64 {
+	size, err := o.service.HeadObject(o.updatePath(filePath))
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+func (o *OSSObjectStoreDriver) Remove(names ...string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = o.updatePath(name)
+	}
+	return o.service.DeleteObjects(paths)
+}
+
+func (o *OSSObjectStoreDriver) Read(src string) (io.ReadCloser, error) {
+	return o.service.GetObject(o.updatePath(src))
+}
+
+func (o *OSSObjectStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	return o.service.PutObject(o.updatePath(dst), rs)
+}
+
+func (o *OSSObjectStoreDriver) Upload(src, dst string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return o.service.PutObject(o.updatePath(dst), file)
+}
+
+func (o *OSSObjectStoreDriver) Download(src, dst string) error {
+	if _, err := os.Stat(dst); err != nil {
+		os.Remove(dst)
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rc, err := o.service.GetObject(o.updatePath(src))
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(f, rc)
+	return err
+}
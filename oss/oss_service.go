@@ -0,0 +1,278 @@
+package oss
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// Max number of objects can be deleted in one DeleteMultipleObjects
+	MAX_DELETE_OBJECTS = 1000
+)
+
+type OSSService struct {
+	Bucket          string
+	Region          string
+	AccessKeyID     string
+	AccessKeySecret string
+	SecurityToken   string
+	// Endpoint overrides the default endpoint of the bucket
+	Endpoint string
+
+	client *http.Client
+}
+
+type ossObject struct {
+	Key  string
+	Size int64
+}
+
+type ossPrefix struct {
+	Prefix string
+}
+
+type listBucketResult struct {
+	IsTruncated    bool
+	NextMarker     string
+	Contents       []ossObject
+	CommonPrefixes []ossPrefix
+}
+
+type ossError struct {
+	Code    string
+	Message string
+}
+
+func (s *OSSService) httpClient() *http.Client {
+	if s.client == nil {
+		s.client = &http.Client{}
+	}
+	return s.client
+}
+
+func (s *OSSService) baseURL() string {
+	if s.Endpoint != "" {
+		return strings.TrimRight(s.Endpoint, "/")
+	}
+	region := s.Region
+	if !strings.HasPrefix(region, "oss-") {
+		region = "oss-" + region
+	}
+	return fmt.Sprintf("https://%s.%s.aliyuncs.com", s.Bucket, region)
+}
+
+func escapeObjectName(key string) string {
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		parts[i] = strings.Replace(url.QueryEscape(p), "+", "%20", -1)
+	}
+	return strings.Join(parts, "/")
+}
+
+// signRequest signs the request using OSS signature version 1, see
+// https://www.alibabacloud.com/help/doc-detail/31951.htm
+func (s *OSSService) signRequest(req *http.Request, key string) {
+	mac := hmac.New(sha1.New, []byte(s.AccessKeySecret))
+	mac.Write([]byte(s.stringToSign(req, key)))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	req.Header.Set("Authorization", "OSS "+s.AccessKeyID+":"+signature)
+}
+
+func (s *OSSService) stringToSign(req *http.Request, key string) string {
+	ossHeaders := []string{}
+	for k := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-oss-") {
+			ossHeaders = append(ossHeaders, k)
+		}
+	}
+	sort.Strings(ossHeaders)
+	canonicalizedHeaders := ""
+	for _, k := range ossHeaders {
+		canonicalizedHeaders += k + ":" + strings.TrimSpace(req.Header.Get(k)) + "\n"
+	}
+
+	// Only sub-resources are part of the resource, not the parameters
+	// like prefix or marker
+	canonicalizedResource := "/" + s.Bucket + "/" + key
+	if _, exists := req.URL.Query()["delete"]; exists {
+		canonicalizedResource += "?delete"
+	}
+
+	return strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		req.Header.Get("Date"),
+	}, "\n") + "\n" + canonicalizedHeaders + canonicalizedResource
+}
+
+func (s *OSSService) do(method, key string, query url.Values, body io.Reader, length int64, headers map[string]string) (*http.Response, error) {
+	u := s.baseURL() + "/" + escapeObjectName(key)
+	if len(query) != 0 {
+		u += "?" + strings.Replace(query.Encode(), "delete=", "delete", 1)
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = length
+	}
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	if s.SecurityToken != "" {
+		req.Header.Set("x-oss-security-token", s.SecurityToken)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	s.signRequest(req, key)
+
+	resp, err := s.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(resp.Body)
+		e := &ossError{}
+		if err := xml.Unmarshal(msg, e); err == nil && e.Code != "" {
+			return nil, fmt.Errorf("OSS Error: %v %v %v, %v: %v", method, key, resp.Status, e.Code, e.Message)
+		}
+		return nil, fmt.Errorf("OSS Error: %v %v %v", method, key, resp.Status)
+	}
+	return resp, nil
+}
+
+func (s *OSSService) ListObjects(prefix, delimiter string) ([]ossObject, []ossPrefix, error) {
+	var (
+		objects  []ossObject
+		prefixes []ossPrefix
+	)
+	marker := ""
+	for {
+		query := url.Values{}
+		query.Set("prefix", prefix)
+		query.Set("max-keys", "1000")
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		resp, err := s.do("GET", "", query, nil, 0, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		result := &listBucketResult{}
+		err = xml.NewDecoder(resp.Body).Decode(result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		objects = append(objects, result.Contents...)
+		prefixes = append(prefixes, result.CommonPrefixes...)
+		if !result.IsTruncated || result.NextMarker == "" {
+			break
+		}
+		marker = result.NextMarker
+	}
+	return objects, prefixes, nil
+}
+
+func (s *OSSService) HeadObject(key string) (int64, error) {
+	resp, err := s.do("HEAD", key, nil, nil, 0, nil)
+	if err != nil {
+		return -1, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+func (s *OSSService) GetObject(key string) (io.ReadCloser, error) {
+	resp, err := s.do("GET", key, nil, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *OSSService) PutObject(key string, rs io.ReadSeeker) error {
+	length, err := rs.Seek(0, 2)
+	if err != nil {
+		return err
+	}
+	if _, err := rs.Seek(0, 0); err != nil {
+		return err
+	}
+	resp, err := s.do("PUT", key, nil, ioutil.NopCloser(rs), length, map[string]string{
+		"Content-Type": "application/octet-stream",
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *OSSService) deleteMultipleObjects(keys []string) error {
+	var b bytes.Buffer
+	b.WriteString(xml.Header + "<Delete><Quiet>true</Quiet>")
+	for _, key := range keys {
+		b.WriteString("<Object><Key>")
+		if err := xml.EscapeText(&b, []byte(key)); err != nil {
+			return err
+		}
+		b.WriteString("</Key></Object>")
+	}
+	b.WriteString("</Delete>")
+
+	checksum := md5.Sum(b.Bytes())
+	query := url.Values{}
+	query.Set("delete", "")
+	resp, err := s.do("POST", "", query, &b, int64(b.Len()), map[string]string{
+		"Content-MD5":  base64.StdEncoding.EncodeToString(checksum[:]),
+		"Content-Type": "application/xml",
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *OSSService) DeleteObjects(keys []string) error {
+	var keyList []string
+	for _, key := range keys {
+		objects, _, err := s.ListObjects(key, "")
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			keyList = append(keyList, obj.Key)
+		}
+	}
+	for len(keyList) != 0 {
+		n := len(keyList)
+		if n > MAX_DELETE_OBJECTS {
+			n = MAX_DELETE_OBJECTS
+		}
+		if err := s.deleteMultipleObjects(keyList[:n]); err != nil {
+			return err
+		}
+		keyList = keyList[n:]
+	}
+	return nil
+}
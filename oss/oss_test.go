@@ -0,0 +1,97 @@
+package oss
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/rancher/convoy/objectstore"
+	"gopkg.in/check.v1"
+)
+
+func TestOSS(t *testing.T) { check.TestingT(t) }
+
+type OSSTestSuite struct{}
+
+var _ = check.Suite(&OSSTestSuite{})
+
+func (s *OSSTestSuite) SetUpTest(c *check.C) {
+	os.Setenv(ENV_ACCESS_KEY_ID, "44CF9590006BF252F707")
+	os.Setenv(ENV_ACCESS_KEY_SECRET, "OtxrzxIsfpFjA7SwPzILwy8Bw21TLhquhboDYROV")
+	os.Unsetenv(ENV_SECURITY_TOKEN)
+}
+
+func runInitFunc(destURL string) (objectstore.ObjectStoreDriver, error) {
+	return initFuncWithConnectionCheck(destURL, "", func(d objectstore.ObjectStoreDriver) error {
+		return nil
+	})
+}
+
+func (s *OSSTestSuite) TestInitFunc(c *check.C) {
+	driver, err := runInitFunc("oss://backups@cn-hangzhou//path")
+	c.Assert(err, check.IsNil)
+	c.Check(driver.GetURL(), check.Equals, "oss://backups@cn-hangzhou/path")
+
+	d := driver.(*OSSObjectStoreDriver)
+	c.Check(d.path, check.Equals, "path")
+	c.Check(d.service.baseURL(), check.Equals, "https://backups.oss-cn-hangzhou.aliyuncs.com")
+
+	_, err = runInitFunc("oss://cn-hangzhou/path")
+	c.Check(err, check.NotNil)
+
+	os.Unsetenv(ENV_ACCESS_KEY_SECRET)
+	_, err = runInitFunc("oss://backups@cn-hangzhou/path")
+	c.Check(err, check.NotNil)
+}
+
+func (s *OSSTestSuite) TestSignRequest(c *check.C) {
+	// The example in OSS signature documentation
+	service := &OSSService{
+		Bucket:          "oss-example",
+		AccessKeyID:     "44CF9590006BF252F707",
+		AccessKeySecret: "OtxrzxIsfpFjA7SwPzILwy8Bw21TLhquhboDYROV",
+	}
+	req, err := http.NewRequest("PUT", "http://oss-example.oss-cn-hangzhou.aliyuncs.com/nelson", nil)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Content-MD5", "ODBGOERFMDMzQTczRUY3NUE3NzA5QzdFNUYzMDQxNEM=")
+	req.Header.Set("Content-Type", "text/html")
+	req.Header.Set("Date", "Thu, 17 Nov 2005 18:49:58 GMT")
+	req.Header.Set("X-OSS-Meta-Author", "foo@bar.com")
+	req.Header.Set("X-OSS-Magic", "abracadabra")
+
+	service.signRequest(req, "nelson")
+	c.Check(req.Header.Get("Authorization"), check.Equals, "OSS 44CF9590006BF252F707:26NBxoKdsyly4EDv6inkoDft/yA=")
+}
+
+func (s *OSSTestSuite) TestDeleteObjects(c *check.C) {
+	var deleted []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("Authorization"), check.Matches, "OSS 44CF9590006BF252F707:.*")
+		switch r.Method {
+		case "GET":
+			c.Check(r.URL.Query().Get("prefix"), check.Equals, "path/volume")
+			w.Write([]byte(`<ListBucketResult><IsTruncated>false</IsTruncated>
+				<Contents><Key>path/volume/a&amp;b</Key><Size>1</Size></Contents>
+				<Contents><Key>path/volume/c</Key><Size>1</Size></Contents>
+				</ListBucketResult>`))
+		case "POST":
+			c.Check(r.URL.RawQuery, check.Equals, "delete")
+			c.Check(r.Header.Get("Content-MD5"), check.Not(check.Equals), "")
+			deleted, _ = ioutil.ReadAll(r.Body)
+		}
+	}))
+	defer server.Close()
+
+	service := &OSSService{
+		Bucket:          "backups",
+		AccessKeyID:     "44CF9590006BF252F707",
+		AccessKeySecret: "OtxrzxIsfpFjA7SwPzILwy8Bw21TLhquhboDYROV",
+		Endpoint:        server.URL,
+	}
+	c.Assert(service.DeleteObjects([]string{"path/volume"}), check.IsNil)
+	c.Check(bytes.Count(deleted, []byte("<Object>")), check.Equals, 2)
+	c.Check(bytes.Contains(deleted, []byte("<Key>path/volume/a&amp;b</Key>")), check.Equals, true)
+}
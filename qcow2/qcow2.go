@@ -0,0 +1,856 @@
+package qcow2
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/metadata"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	DRIVER_NAME        = "qcow2"
+	DRIVER_CONFIG_FILE = "qcow2.cfg"
+
+	VOLUME_CFG_PREFIX = "volume_"
+	CFG_PREFIX        = DRIVER_NAME + "_"
+	CFG_POSTFIX       = ".json"
+
+	MOUNTS_DIR    = "mounts"
+	IMAGES_DIR    = "images"
+	SNAPSHOTS_DIR = "snapshots"
+	IMAGE_POSTFIX = ".qcow2"
+	MAP_POSTFIX   = ".map"
+
+	QCOW2_PATH                = "qcow2.path"
+	QCOW2_DEFAULT_VOLUME_SIZE = "qcow2.defaultvolumesize"
+	QCOW2_DEFAULT_FS_TYPE     = "qcow2.fs"
+
+	DEFAULT_VOLUME_SIZE = "10G"
+	DEFAULT_FS_TYPE     = "ext4"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "qcow2"})
+)
+
+type Driver struct {
+	mutex *sync.RWMutex
+	Device
+
+	// nbd devices of the snapshots opened for backup
+	nbdMutex        *sync.Mutex
+	snapshotDevices map[string]string
+}
+
+type Device struct {
+	Root              string
+	Path              string
+	DefaultVolumeSize int64
+	Filesystem        string
+}
+
+func (dev *Device) ConfigFile() (string, error) {
+	if dev.Root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty device config path")
+	}
+	return filepath.Join(dev.Root, DRIVER_CONFIG_FILE), nil
+}
+
+type Volume struct {
+	Name        string
+	File        string
+	Device      string
+	Size        int64
+	MountPoint  string
+	CreatedTime string
+	Filesystem  string
+	Snapshots   map[string]Snapshot
+
+	configPath string
+}
+
+// Snapshot is an internal snapshot of the image. MapFile records the layout
+// of the image when the snapshot was taken, which is used to find out the
+// changed blocks between snapshots.
+type Snapshot struct {
+	Name        string
+	MapFile     string
+	CreatedTime string
+	SnapshotMetadata
+}
+
+func (v *Volume) ConfigFile() (string, error) {
+	if v.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if v.configPath == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume config path")
+	}
+	return filepath.Join(v.configPath, CFG_PREFIX+VOLUME_CFG_PREFIX+v.Name+CFG_POSTFIX), nil
+}
+
+func (v *Volume) GetDevice() (string, error) {
+	if v.Device == "" {
+		return "", fmt.Errorf("Volume %v is not connected to nbd device", v.Name)
+	}
+	return v.Device, nil
+}
+
+func (v *Volume) GetMountOpts() []string {
+	return []string{}
+}
+
+func (v *Volume) GenerateDefaultMountPoint() string {
+	return filepath.Join(v.configPath, MOUNTS_DIR, v.Name)
+}
+
+func init() {
+	if err := Register(DRIVER_NAME, Init); err != nil {
+		panic(err)
+	}
+}
+
+func (d *Driver) Name() string {
+	return DRIVER_NAME
+}
+
+func (d *Driver) listVolumeNames() ([]string, error) {
+	return util.ListConfigIDs(d.Root, CFG_PREFIX+VOLUME_CFG_PREFIX, CFG_POSTFIX)
+}
+
+func (d *Driver) blankVolume(name string) *Volume {
+	return &Volume{
+		configPath: d.Root,
+		Name:       name,
+	}
+}
+
+func (d *Driver) imageFile(name string) string {
+	return filepath.Join(d.Path, name+IMAGE_POSTFIX)
+}
+
+func (d *Driver) mapFile(volumeName, name string) string {
+	return filepath.Join(d.Path, SNAPSHOTS_DIR, volumeName, name+MAP_POSTFIX)
+}
+
+// connect returns the nbd device of the volume, and would connect one if
+// it's not connected yet
+func (d *Driver) connect(volume *Volume) (string, error) {
+	d.nbdMutex.Lock()
+	defer d.nbdMutex.Unlock()
+
+	if volume.Device != "" && util.NBDConnectedTo(volume.Device, volume.File) {
+		return volume.Device, nil
+	}
+	return connectNBD(volume.File, "", false)
+}
+
+func (d *Driver) disconnect(dev string) error {
+	d.nbdMutex.Lock()
+	defer d.nbdMutex.Unlock()
+
+	return util.DisconnectNBD(dev)
+}
+
+func verifyConfig(root string, config map[string]string) (*Device, error) {
+	dev := &Device{
+		Root: root,
+		Path: config[QCOW2_PATH],
+	}
+	if dev.Path == "" {
+		dev.Path = filepath.Join(root, IMAGES_DIR)
+	}
+
+	if config[QCOW2_DEFAULT_VOLUME_SIZE] == "" {
+		config[QCOW2_DEFAULT_VOLUME_SIZE] = DEFAULT_VOLUME_SIZE
+	}
+	size, err := util.ParseSize(config[QCOW2_DEFAULT_VOLUME_SIZE])
+	if err != nil || size == 0 {
+		return nil, fmt.Errorf("Illegal default volume size specified")
+	}
+	if size%objectstore.DEFAULT_BLOCK_SIZE != 0 {
+		return nil, fmt.Errorf("Default volume size must be multiple of %v", objectstore.DEFAULT_BLOCK_SIZE)
+	}
+	dev.DefaultVolumeSize = size
+
+	if config[QCOW2_DEFAULT_FS_TYPE] == "" {
+		config[QCOW2_DEFAULT_FS_TYPE] = DEFAULT_FS_TYPE
+	}
+	fsType := config[QCOW2_DEFAULT_FS_TYPE]
+	if _, err := exec.LookPath("mkfs." + fsType); err != nil {
+		return nil, fmt.Errorf("Unsupported filesystem type %v, cannot find mkfs.%v", fsType, fsType)
+	}
+	dev.Filesystem = fsType
+	return dev, nil
+}
+
+func (d *Driver) remountVolumes() error {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return err
+	}
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return err
+		}
+		if volume.MountPoint == "" {
+			continue
+		}
+		req := Request{
+			Name:    id,
+			Options: map[string]string{},
+		}
+		if _, err := d.MountVolume(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Init(root string, config map[string]string) (ConvoyDriver, error) {
+	for _, binary := range []string{QEMU_IMG_BINARY, util.QEMU_NBD_BINARY} {
+		if _, err := exec.LookPath(binary); err != nil {
+			return nil, fmt.Errorf("Cannot find %v binary, please make sure qemu-utils is installed", binary)
+		}
+	}
+	if err := util.CheckNBD(); err != nil {
+		return nil, err
+	}
+
+	if err := util.MkdirIfNotExists(root); err != nil {
+		return nil, err
+	}
+	dev := &Device{
+		Root: root,
+	}
+	exists, err := util.ObjectExists(dev)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if err := util.ObjectLoad(dev); err != nil {
+			return nil, err
+		}
+	} else {
+		if dev, err = verifyConfig(root, config); err != nil {
+			return nil, err
+		}
+		if err := util.MkdirIfNotExists(dev.Path); err != nil {
+			return nil, err
+		}
+		if err := util.ObjectSave(dev); err != nil {
+			return nil, err
+		}
+	}
+	d := &Driver{
+		mutex:           &sync.RWMutex{},
+		Device:          *dev,
+		nbdMutex:        &sync.Mutex{},
+		snapshotDevices: map[string]string{},
+	}
+	if err := d.remountVolumes(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Driver) Info() (map[string]string, error) {
+	return map[string]string{
+		"Driver":            d.Name(),
+		"Root":              d.Root,
+		"Path":              d.Path,
+		"DefaultVolumeSize": strconv.FormatInt(d.DefaultVolumeSize, 10),
+		"Filesystem":        d.Filesystem,
+	}, nil
+}
+
+func (d *Driver) VolumeOps() (VolumeOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) SnapshotOps() (SnapshotOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) getSize(opts map[string]string, defaultVolumeSize int64) (int64, error) {
+	size := opts[OPT_SIZE]
+	if size == "" || size == "0" {
+		size = strconv.FormatInt(defaultVolumeSize, 10)
+	}
+	return util.ParseSize(size)
+}
+
+func (d *Driver) CreateVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var (
+		size int64
+		err  error
+	)
+	id := req.Name
+	opts := req.Options
+
+	backupURL := opts[OPT_BACKUP_URL]
+	endpointURL := opts[OPT_ENDPOINT_URL]
+	if backupURL != "" {
+		objVolume, err := objectstore.LoadVolume(backupURL, endpointURL)
+		if err != nil {
+			return err
+		}
+		if err := objectstore.CheckRestoreDriver(objVolume, backupURL, endpointURL, d.Name()); err != nil {
+			return err
+		}
+		size, err = d.getSize(opts, objVolume.Size)
+		if err != nil {
+			return err
+		}
+		if size < objVolume.Size {
+			return fmt.Errorf("Volume size cannot be less than backup's size %v", objVolume.Size)
+		}
+	} else {
+		size, err = d.getSize(opts, d.DefaultVolumeSize)
+		if err != nil {
+			return err
+		}
+	}
+	if size%objectstore.DEFAULT_BLOCK_SIZE != 0 {
+		return fmt.Errorf("Size must be multiple of %v", objectstore.DEFAULT_BLOCK_SIZE)
+	}
+
+	volume := d.blankVolume(id)
+	exists, err := util.ObjectExists(volume)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Already has volume with specific uuid %v", id)
+	}
+
+	file := d.imageFile(id)
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_START,
+		LOG_FIELD_EVENT:  LOG_EVENT_CREATE,
+		LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME: id,
+	}).Debugf("Creating qcow2 image %v", file)
+	if err := createImage(file, size); err != nil {
+		return err
+	}
+	if err := d.prepareImage(file, size, backupURL, endpointURL); err != nil {
+		os.Remove(file)
+		return err
+	}
+
+	volume.File = file
+	volume.Size = size
+	volume.CreatedTime = util.Now()
+	volume.Filesystem = d.Filesystem
+	volume.Snapshots = make(map[string]Snapshot)
+	return util.ObjectSave(volume)
+}
+
+// prepareImage would restore the backup to the image of size, or format it if
+// backupURL is empty
+func (d *Driver) prepareImage(file string, size int64, backupURL, endpointURL string) error {
+	d.nbdMutex.Lock()
+	dev, err := connectNBD(file, "", false)
+	d.nbdMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := d.disconnect(dev); err != nil {
+			log.Warnf("Failed to disconnect %v from %v: %v", dev, file, err)
+		}
+	}()
+
+	if backupURL != "" {
+		// Only the blocks in the backup would be written, the rest of
+		// the image remains unallocated
+		return objectstore.RestoreDeltaBlockBackupToSize(backupURL, endpointURL, dev, size)
+	}
+	log.Debugf("Formatting device %s with %s filesystem", dev, d.Filesystem)
+	_, err = util.Execute("mkfs", []string{"-t", d.Filesystem, dev})
+	return err
+}
+
+func (d *Driver) DeleteVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	if volume.MountPoint != "" {
+		return fmt.Errorf("Cannot delete volume %s, it hasn't been umounted", id)
+	}
+	if volume.Device != "" && util.NBDConnectedTo(volume.Device, volume.File) {
+		if err := d.disconnect(volume.Device); err != nil {
+			return err
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_START,
+		LOG_FIELD_EVENT:  LOG_EVENT_REMOVE,
+		LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME: id,
+	}).Debugf("Removing qcow2 image %v", volume.File)
+	if err := os.Remove(volume.File); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Internal snapshots are gone with the image
+	if err := os.RemoveAll(filepath.Dir(d.mapFile(id, ""))); err != nil {
+		return err
+	}
+	return util.ObjectDelete(volume)
+}
+
+func (d *Driver) MountVolume(req Request) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+
+	dev, err := d.connect(volume)
+	if err != nil {
+		return "", err
+	}
+	volume.Device = dev
+
+	mountPoint, err := util.VolumeMount(volume, req.Options[OPT_MOUNT_POINT], false)
+	if err != nil {
+		return "", err
+	}
+
+	if err := util.ObjectSave(volume); err != nil {
+		return "", err
+	}
+	return mountPoint, nil
+}
+
+func (d *Driver) UmountVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	if err := util.VolumeUmount(volume); err != nil {
+		return err
+	}
+	// The nbd device is only held when mounted
+	if volume.Device != "" && util.NBDConnectedTo(volume.Device, volume.File) {
+		if err := d.disconnect(volume.Device); err != nil {
+			return err
+		}
+	}
+	volume.Device = ""
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) MountPoint(req Request) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	return volume.MountPoint, nil
+}
+
+func (d *Driver) GetVolumeInfo(id string) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.getVolumeInfo(id)
+}
+
+func (d *Driver) getVolumeInfo(id string) (map[string]string, error) {
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+	allocated, err := allocatedSize(volume.File)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"File":                  volume.File,
+		"Device":                volume.Device,
+		"AllocatedSize":         strconv.FormatInt(allocated, 10),
+		OPT_VOLUME_NAME:         volume.Name,
+		OPT_VOLUME_CREATED_TIME: volume.CreatedTime,
+		OPT_MOUNT_POINT:         volume.MountPoint,
+		OPT_SIZE:                strconv.FormatInt(volume.Size, 10),
+		OPT_FILESYSTEM:          volume.Filesystem,
+	}, nil
+}
+
+func (d *Driver) ListVolume(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	volumes := make(map[string]map[string]string)
+	for _, id := range volumeIDs {
+		volumes[id], err = d.getVolumeInfo(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return volumes, nil
+}
+
+func (d *Driver) CreateSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if _, exists := volume.Snapshots[id]; exists {
+		return fmt.Errorf("Snapshot %v already exists for volume %v", id, volumeID)
+	}
+	// qemu-nbd holds the write lock of the image, and there is no way to
+	// ask it to take an internal snapshot
+	if volume.MountPoint != "" {
+		return fmt.Errorf("Cannot create snapshot of volume %v, it hasn't been umounted", volumeID)
+	}
+
+	file := d.mapFile(volumeID, id)
+	if err := util.MkdirIfNotExists(filepath.Dir(file)); err != nil {
+		return err
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_CREATE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: id,
+		LOG_FIELD_VOLUME:   volumeID,
+	}).Debugf("Creating internal snapshot of %v", volume.File)
+	if err := createInternalSnapshot(volume.File, id); err != nil {
+		return err
+	}
+	// The image is not in use, so the current layout is exactly the
+	// layout of the snapshot, and it won't change later
+	data, err := mapImage(volume.File)
+	if err == nil {
+		err = ioutil.WriteFile(file, data, 0600)
+	}
+	if err != nil {
+		if err := deleteInternalSnapshot(volume.File, id); err != nil {
+			log.Errorf("Failed to cleanup snapshot %v of %v: %v", id, volume.File, err)
+		}
+		return err
+	}
+
+	volume.Snapshots[id] = Snapshot{
+		Name:             id,
+		MapFile:          file,
+		CreatedTime:      util.Now(),
+		SnapshotMetadata: NewSnapshotMetadata(req.Options),
+	}
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) DeleteSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return err
+	}
+	if volume.MountPoint != "" {
+		return fmt.Errorf("Cannot delete snapshot %v of volume %v, the volume hasn't been umounted", id, volumeID)
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_REMOVE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: id,
+		LOG_FIELD_VOLUME:   volumeID,
+	}).Debugf("Removing internal snapshot of %v", volume.File)
+	if err := deleteInternalSnapshot(volume.File, id); err != nil {
+		return err
+	}
+	if err := os.Remove(snapshot.MapFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(volume.Snapshots, id)
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) getSnapshotAndVolume(snapshotID, volumeID string) (*Snapshot, *Volume, error) {
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, nil, err
+	}
+	snapshot, exists := volume.Snapshots[snapshotID]
+	if !exists {
+		return nil, nil, fmt.Errorf("Cannot find snapshot %v of volume %v", snapshotID, volumeID)
+	}
+	return &snapshot, volume, nil
+}
+
+func (d *Driver) GetSnapshotInfo(req Request) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return nil, err
+	}
+	return d.getSnapshotInfo(req.Name, volumeID)
+}
+
+func (d *Driver) getSnapshotInfo(id, volumeID string) (map[string]string, error) {
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		OPT_SNAPSHOT_NAME:         snapshot.Name,
+		OPT_SNAPSHOT_LABELS:       snapshot.Labels,
+		OPT_SNAPSHOT_DESCRIPTION:  snapshot.Description,
+		OPT_SNAPSHOT_CREATED_TIME: snapshot.CreatedTime,
+		OPT_SIZE:                  strconv.FormatInt(volume.Size, 10),
+		"VolumeUUID":              volumeID,
+		"File":                    volume.File,
+	}, nil
+}
+
+func (d *Driver) ListSnapshot(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	var (
+		volumeIDs []string
+		err       error
+	)
+	snapshots := make(map[string]map[string]string)
+	specifiedVolumeID, _ := util.GetFieldFromOpts(OPT_VOLUME_NAME, opts)
+	if specifiedVolumeID != "" {
+		volumeIDs = []string{
+			specifiedVolumeID,
+		}
+	} else {
+		volumeIDs, err = d.listVolumeNames()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, volumeID := range volumeIDs {
+		volume := d.blankVolume(volumeID)
+		if err := util.ObjectLoad(volume); err != nil {
+			return nil, err
+		}
+		for snapshotID := range volume.Snapshots {
+			snapshots[snapshotID], err = d.getSnapshotInfo(snapshotID, volumeID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return snapshots, nil
+}
+
+func (d *Driver) BackupOps() (BackupOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) HasSnapshot(id, volumeID string) bool {
+	_, _, err := d.getSnapshotAndVolume(id, volumeID)
+	return err == nil
+}
+
+func (d *Driver) CompareSnapshot(id, compareID, volumeID string) (*metadata.Mappings, error) {
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	extents, err := loadExtents(snapshot.MapFile)
+	if err != nil {
+		return nil, err
+	}
+	var baseExtents []Extent
+	if compareID != "" && compareID != id {
+		compareSnapshot, exists := volume.Snapshots[compareID]
+		if !exists {
+			return nil, fmt.Errorf("Cannot find snapshot %v of volume %v", compareID, volumeID)
+		}
+		if baseExtents, err = loadExtents(compareSnapshot.MapFile); err != nil {
+			return nil, err
+		}
+	}
+	return compareExtents(extents, baseExtents, objectstore.DEFAULT_BLOCK_SIZE), nil
+}
+
+func snapshotKey(id, volumeID string) string {
+	return volumeID + "/" + id
+}
+
+func (d *Driver) OpenSnapshot(id, volumeID string) error {
+	_, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return err
+	}
+
+	d.nbdMutex.Lock()
+	defer d.nbdMutex.Unlock()
+
+	// The clusters of an internal snapshot would never be modified, so it's
+	// safe to read them while the image is being written by another qemu-nbd
+	dev, err := connectNBD(volume.File, id, volume.MountPoint != "")
+	if err != nil {
+		return err
+	}
+	d.snapshotDevices[snapshotKey(id, volumeID)] = dev
+	return nil
+}
+
+func (d *Driver) ReadSnapshot(id, volumeID string, offset int64, data []byte) error {
+	d.nbdMutex.Lock()
+	dev, exists := d.snapshotDevices[snapshotKey(id, volumeID)]
+	d.nbdMutex.Unlock()
+	if !exists {
+		return fmt.Errorf("BUG: Snapshot %v of volume %v is not opened", id, volumeID)
+	}
+
+	devFile, err := os.Open(dev)
+	if err != nil {
+		return err
+	}
+	defer devFile.Close()
+
+	_, err = devFile.ReadAt(data, offset)
+	return err
+}
+
+func (d *Driver) CloseSnapshot(id, volumeID string) error {
+	d.nbdMutex.Lock()
+	defer d.nbdMutex.Unlock()
+
+	key := snapshotKey(id, volumeID)
+	dev, exists := d.snapshotDevices[key]
+	if !exists {
+		return nil
+	}
+	if err := util.DisconnectNBD(dev); err != nil {
+		return err
+	}
+	delete(d.snapshotDevices, key)
+	return nil
+}
+
+func (d *Driver) CreateBackup(snapshotID, volumeID, destURL, endpointURL string, opts map[string]string) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+
+	objVolume := &objectstore.Volume{
+		Name:        volumeID,
+		Driver:      d.Name(),
+		Size:        volume.Size,
+		CreatedTime: opts[OPT_VOLUME_CREATED_TIME],
+	}
+	objSnapshot := &objectstore.Snapshot{
+		Name:        snapshotID,
+		CreatedTime: opts[OPT_SNAPSHOT_CREATED_TIME],
+		ExportImage: opts[OPT_EXPORT_IMAGE],
+		Labels:      objectstore.DecodeLabels(opts[OPT_BACKUP_LABELS]),
+	}
+	return objectstore.CreateDeltaBlockBackup(objVolume, objSnapshot, destURL, endpointURL, d)
+}
+
+func (d *Driver) EstimateBackup(snapshotID, volumeID, destURL, endpointURL string, opts map[string]string) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+
+	objVolume := &objectstore.Volume{
+		Name:   volumeID,
+		Driver: d.Name(),
+		Size:   volume.Size,
+	}
+	objSnapshot := &objectstore.Snapshot{
+		Name: snapshotID,
+	}
+	return objectstore.EstimateDeltaBlockBackup(objVolume, objSnapshot, destURL, endpointURL, d)
+}
+
+func (d *Driver) DeleteBackup(backupURL, endpointURL string, opts map[string]string) error {
+	objVolume, err := objectstore.LoadVolume(backupURL, endpointURL)
+	if err != nil {
+		return err
+	}
+	if objVolume.Driver != d.Name() {
+		return fmt.Errorf("BUG: Wrong driver handling DeleteBackup(), driver should be %v but is %v", objVolume.Driver, d.Name())
+	}
+	force, _ := strconv.ParseBool(opts[OPT_FORCE])
+	return objectstore.DeleteDeltaBlockBackup(backupURL, endpointURL, force)
+}
+
+func (d *Driver) GetBackupInfo(backupURL, endpointURL string) (map[string]string, error) {
+	objVolume, err := objectstore.LoadVolume(backupURL, endpointURL)
+	if err != nil {
+		return nil, err
+	}
+	if objVolume.Driver != d.Name() {
+		return nil, fmt.Errorf("BUG: Wrong driver handling GetBackupInfo(), driver should be %v but is %v", objVolume.Driver, d.Name())
+	}
+	return objectstore.GetBackupInfo(backupURL, endpointURL)
+}
+
+func (d *Driver) ListBackup(destURL, endpointURL string, opts map[string]string) (map[string]map[string]string, error) {
+	return objectstore.List(opts[OPT_VOLUME_NAME], destURL, endpointURL, d.Name())
+}
@@ -0,0 +1,166 @@
+package qcow2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/rancher/convoy/metadata"
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	QEMU_IMG_BINARY = "qemu-img"
+)
+
+// Extent is an entry of the output of "qemu-img map --output=json". Offset
+// is the position of the data in the image file, which is only available
+// for the allocated and uncompressed clusters.
+type Extent struct {
+	Start  int64  `json:"start"`
+	Length int64  `json:"length"`
+	Depth  int    `json:"depth"`
+	Zero   bool   `json:"zero"`
+	Data   bool   `json:"data"`
+	Offset *int64 `json:"offset,omitempty"`
+}
+
+func qemuImg(args ...string) (string, error) {
+	return util.Execute(QEMU_IMG_BINARY, args)
+}
+
+func createImage(file string, size int64) error {
+	_, err := qemuImg("create", "-f", "qcow2", file, strconv.FormatInt(size, 10))
+	return err
+}
+
+func createInternalSnapshot(file, name string) error {
+	_, err := qemuImg("snapshot", "-c", name, file)
+	return err
+}
+
+func deleteInternalSnapshot(file, name string) error {
+	_, err := qemuImg("snapshot", "-d", name, file)
+	return err
+}
+
+// mapImage returns the layout of the current state of the image
+func mapImage(file string) ([]byte, error) {
+	output, err := qemuImg("map", "--output=json", "-f", "qcow2", file)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(output), nil
+}
+
+func parseExtents(data []byte) ([]Extent, error) {
+	extents := []Extent{}
+	if err := json.Unmarshal(data, &extents); err != nil {
+		return nil, fmt.Errorf("Cannot parse output of qemu-img map: %v", err)
+	}
+	return extents, nil
+}
+
+func loadExtents(file string) ([]Extent, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseExtents(data)
+}
+
+func readsZero(e *Extent) bool {
+	return e == nil || e.Zero || !e.Data
+}
+
+// extentChanged tells if the data at pos differs between the extents. The
+// clusters are copied on write once shared with an internal snapshot, so the
+// same position in the image file means the same data.
+func extentChanged(e, base *Extent, pos int64) bool {
+	if readsZero(e) {
+		return !readsZero(base)
+	}
+	if readsZero(base) {
+		return true
+	}
+	if e.Offset == nil || base.Offset == nil {
+		return true
+	}
+	return *e.Offset+(pos-e.Start) != *base.Offset+(pos-base.Start)
+}
+
+// compareExtents returns the blocks differ between the layouts of two
+// snapshots. If baseExtents is nil, the blocks contain any data would be
+// returned.
+func compareExtents(extents, baseExtents []Extent, blockSize int64) *metadata.Mappings {
+	mappings := &metadata.Mappings{
+		BlockSize: blockSize,
+	}
+	last := int64(-1)
+	mark := func(start, end int64) {
+		for block := start / blockSize * blockSize; block < end; block += blockSize {
+			if block > last {
+				mappings.Mappings = append(mappings.Mappings, metadata.Mapping{
+					Offset: block,
+					Size:   blockSize,
+				})
+				last = block
+			}
+		}
+	}
+
+	i, j := 0, 0
+	pos := int64(0)
+	for i < len(extents) {
+		e := &extents[i]
+		if pos < e.Start {
+			pos = e.Start
+		}
+		end := e.Start + e.Length
+		for j < len(baseExtents) && baseExtents[j].Start+baseExtents[j].Length <= pos {
+			j++
+		}
+		var base *Extent
+		if j < len(baseExtents) && baseExtents[j].Start <= pos {
+			base = &baseExtents[j]
+			if base.Start+base.Length < end {
+				end = base.Start + base.Length
+			}
+		} else if j < len(baseExtents) && baseExtents[j].Start < end {
+			end = baseExtents[j].Start
+		}
+
+		if extentChanged(e, base, pos) {
+			mark(pos, end)
+		}
+		pos = end
+		if pos >= e.Start+e.Length {
+			i++
+		}
+	}
+	return mappings
+}
+
+// connectNBD exports the image through a free nbd device. If snapshot is
+// specified, the internal snapshot would be exported read only.
+func connectNBD(file, snapshot string, forceShare bool) (string, error) {
+	opts := []string{"-f", "qcow2"}
+	if snapshot != "" {
+		opts = append(opts, "-r", "-l", "snapshot.name="+snapshot)
+		if forceShare {
+			opts = append(opts, "--force-share")
+		}
+	}
+	return util.ConnectNBD(file, opts)
+}
+
+// allocatedSize returns the size of the image file on disk
+func allocatedSize(file string) (int64, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
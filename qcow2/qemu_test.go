@@ -0,0 +1,91 @@
+package qcow2
+
+import (
+	"testing"
+
+	"github.com/rancher/convoy/metadata"
+
+	. "gopkg.in/check.v1"
+)
+
+const (
+	testBlockSize = 4096
+	MB            = 1024 * 1024
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct{}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) TestParseExtents(c *C) {
+	extents, err := parseExtents([]byte(`[
+{ "start": 0, "length": 65536, "depth": 0, "present": true, "zero": false, "data": true, "offset": 327680},
+{ "start": 65536, "length": 1048576, "depth": 0, "present": false, "zero": true, "data": false}]`))
+	c.Assert(err, IsNil)
+	c.Assert(extents, HasLen, 2)
+	c.Assert(extents[0].Data, Equals, true)
+	c.Assert(*extents[0].Offset, Equals, int64(327680))
+	c.Assert(extents[1].Zero, Equals, true)
+	c.Assert(extents[1].Offset, IsNil)
+
+	_, err = parseExtents([]byte("qemu-img: Could not open"))
+	c.Assert(err, ErrorMatches, "Cannot parse output of qemu-img map.*")
+}
+
+func data(start, length, offset int64) Extent {
+	return Extent{Start: start, Length: length, Data: true, Offset: &offset}
+}
+
+func zero(start, length int64) Extent {
+	return Extent{Start: start, Length: length, Zero: true}
+}
+
+func blocks(offsets ...int64) []metadata.Mapping {
+	mappings := []metadata.Mapping{}
+	for _, offset := range offsets {
+		mappings = append(mappings, metadata.Mapping{
+			Offset: offset * testBlockSize,
+			Size:   testBlockSize,
+		})
+	}
+	return mappings
+}
+
+func (s *TestSuite) TestCompareExtents(c *C) {
+	base := []Extent{
+		data(0, 2*testBlockSize, 1*MB),
+		zero(2*testBlockSize, 4*testBlockSize),
+		data(6*testBlockSize, 2*testBlockSize, 2*MB),
+	}
+
+	mappings := compareExtents(base, nil, testBlockSize)
+	c.Assert(mappings.BlockSize, Equals, int64(testBlockSize))
+	c.Assert(mappings.Mappings, DeepEquals, blocks(0, 1, 6, 7))
+
+	mappings = compareExtents(base, base, testBlockSize)
+	c.Assert(mappings.Mappings, HasLen, 0)
+
+	// Block 1 was rewritten, so was moved to a new cluster, block 3 was
+	// written, and block 7 was discarded
+	extents := []Extent{
+		data(0, testBlockSize, 1*MB),
+		data(testBlockSize, testBlockSize, 3*MB),
+		zero(2*testBlockSize, testBlockSize),
+		data(3*testBlockSize, testBlockSize/2, 4*MB),
+		zero(3*testBlockSize+testBlockSize/2, 2*testBlockSize+testBlockSize/2),
+		data(6*testBlockSize, testBlockSize, 2*MB),
+		zero(7*testBlockSize, testBlockSize),
+	}
+	mappings = compareExtents(extents, base, testBlockSize)
+	c.Assert(mappings.Mappings, DeepEquals, blocks(1, 3, 7))
+
+	// Same data at different offsets of the image is still a change
+	moved := []Extent{
+		data(0, 2*testBlockSize, 1*MB+testBlockSize),
+		zero(2*testBlockSize, 6*testBlockSize),
+	}
+	mappings = compareExtents(moved, base, testBlockSize)
+	c.Assert(mappings.Mappings, DeepEquals, blocks(0, 1, 6, 7))
+}
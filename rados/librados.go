@@ -0,0 +1,254 @@
+// +build rados
+
+package rados
+
+/*
+#cgo LDFLAGS: -lrados
+#include <errno.h>
+#include <stdlib.h>
+#include <rados/librados.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// Keys returned by each omap read
+	OMAP_PAGE_SIZE = 1000
+)
+
+type radosIOContext struct {
+	cluster C.rados_t
+	ioctx   C.rados_ioctx_t
+}
+
+func init() {
+	openIOContext = openRadosIOContext
+}
+
+func radosError(op, oid string, ret C.int) error {
+	if ret == -C.ENOENT {
+		return errNotFound
+	}
+	return fmt.Errorf("RADOS Error: %v %v failed: %v", op, oid, syscall.Errno(-ret))
+}
+
+func openRadosIOContext(config *ClusterConfig) (ioContext, error) {
+	c := &radosIOContext{}
+
+	id := C.CString(config.ClientID)
+	defer C.free(unsafe.Pointer(id))
+	if ret := C.rados_create(&c.cluster, id); ret < 0 {
+		return nil, radosError("create cluster handle for", config.ClientID, ret)
+	}
+
+	conf := C.CString(config.ConfFile)
+	defer C.free(unsafe.Pointer(conf))
+	if ret := C.rados_conf_read_file(c.cluster, conf); ret < 0 {
+		C.rados_shutdown(c.cluster)
+		return nil, radosError("read config", config.ConfFile, ret)
+	}
+	if config.Keyring != "" {
+		option := C.CString("keyring")
+		defer C.free(unsafe.Pointer(option))
+		keyring := C.CString(config.Keyring)
+		defer C.free(unsafe.Pointer(keyring))
+		if ret := C.rados_conf_set(c.cluster, option, keyring); ret < 0 {
+			C.rados_shutdown(c.cluster)
+			return nil, radosError("set keyring", config.Keyring, ret)
+		}
+	}
+	if ret := C.rados_connect(c.cluster); ret < 0 {
+		C.rados_shutdown(c.cluster)
+		return nil, radosError("connect to cluster as", config.ClientID, ret)
+	}
+
+	pool := C.CString(config.Pool)
+	defer C.free(unsafe.Pointer(pool))
+	if ret := C.rados_ioctx_create(c.cluster, pool, &c.ioctx); ret < 0 {
+		C.rados_shutdown(c.cluster)
+		return nil, radosError("open pool", config.Pool, ret)
+	}
+	if config.Namespace != "" {
+		ns := C.CString(config.Namespace)
+		defer C.free(unsafe.Pointer(ns))
+		C.rados_ioctx_set_namespace(c.ioctx, ns)
+	}
+	return c, nil
+}
+
+func (c *radosIOContext) Destroy() {
+	C.rados_ioctx_destroy(c.ioctx)
+	C.rados_shutdown(c.cluster)
+}
+
+func bufPointer(data []byte) *C.char {
+	if len(data) == 0 {
+		return nil
+	}
+	return (*C.char)(unsafe.Pointer(&data[0]))
+}
+
+func (c *radosIOContext) WriteFull(oid string, data []byte) error {
+	coid := C.CString(oid)
+	defer C.free(unsafe.Pointer(coid))
+	if ret := C.rados_write_full(c.ioctx, coid, bufPointer(data), C.size_t(len(data))); ret < 0 {
+		return radosError("write", oid, ret)
+	}
+	return nil
+}
+
+func (c *radosIOContext) Write(oid string, data []byte, offset uint64) error {
+	coid := C.CString(oid)
+	defer C.free(unsafe.Pointer(coid))
+	if ret := C.rados_write(c.ioctx, coid, bufPointer(data), C.size_t(len(data)), C.uint64_t(offset)); ret < 0 {
+		return radosError("write", oid, ret)
+	}
+	return nil
+}
+
+func (c *radosIOContext) Read(oid string, data []byte, offset uint64) (int, error) {
+	coid := C.CString(oid)
+	defer C.free(unsafe.Pointer(coid))
+	ret := C.rados_read(c.ioctx, coid, bufPointer(data), C.size_t(len(data)), C.uint64_t(offset))
+	if ret < 0 {
+		return 0, radosError("read", oid, ret)
+	}
+	return int(ret), nil
+}
+
+func (c *radosIOContext) Stat(oid string) (uint64, error) {
+	coid := C.CString(oid)
+	defer C.free(unsafe.Pointer(coid))
+	var size C.uint64_t
+	var mtime C.time_t
+	if ret := C.rados_stat(c.ioctx, coid, &size, &mtime); ret < 0 {
+		return 0, radosError("stat", oid, ret)
+	}
+	return uint64(size), nil
+}
+
+func (c *radosIOContext) Remove(oid string) error {
+	coid := C.CString(oid)
+	defer C.free(unsafe.Pointer(coid))
+	if ret := C.rados_remove(c.ioctx, coid); ret < 0 {
+		return radosError("remove", oid, ret)
+	}
+	return nil
+}
+
+// cStrings allocates the strings in C memory, the caller needs to free them
+func cStrings(strs []string) []*C.char {
+	result := make([]*C.char, len(strs))
+	for i, s := range strs {
+		result[i] = C.CString(s)
+	}
+	return result
+}
+
+func freeCStrings(strs []*C.char) {
+	for _, s := range strs {
+		C.free(unsafe.Pointer(s))
+	}
+}
+
+func (c *radosIOContext) operateWriteOp(oid string, fn func(op C.rados_write_op_t)) error {
+	coid := C.CString(oid)
+	defer C.free(unsafe.Pointer(coid))
+	op := C.rados_create_write_op()
+	defer C.rados_release_write_op(op)
+	fn(op)
+	if ret := C.rados_write_op_operate(op, c.ioctx, coid, nil, 0); ret < 0 {
+		return radosError("update omap of", oid, ret)
+	}
+	return nil
+}
+
+func (c *radosIOContext) SetOmapKeys(oid string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	ckeys := cStrings(keys)
+	defer freeCStrings(ckeys)
+	// Only the keys matter
+	empty := C.CString("")
+	defer C.free(unsafe.Pointer(empty))
+	cvals := make([]*C.char, len(keys))
+	lens := make([]C.size_t, len(keys))
+	for i := range cvals {
+		cvals[i] = empty
+	}
+	return c.operateWriteOp(oid, func(op C.rados_write_op_t) {
+		C.rados_write_op_omap_set(op, &ckeys[0], &cvals[0], &lens[0], C.size_t(len(keys)))
+	})
+}
+
+func (c *radosIOContext) RemoveOmapKeys(oid string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	ckeys := cStrings(keys)
+	defer freeCStrings(ckeys)
+	return c.operateWriteOp(oid, func(op C.rados_write_op_t) {
+		C.rados_write_op_omap_rm_keys(op, &ckeys[0], C.size_t(len(keys)))
+	})
+}
+
+func (c *radosIOContext) ListOmapKeys(oid string) ([]string, error) {
+	coid := C.CString(oid)
+	defer C.free(unsafe.Pointer(coid))
+
+	keys := []string{}
+	startAfter := ""
+	for {
+		more, err := c.listOmapKeysPage(coid, oid, startAfter, &keys)
+		if err != nil {
+			return nil, err
+		}
+		if !more || len(keys) == 0 {
+			break
+		}
+		startAfter = keys[len(keys)-1]
+	}
+	return keys, nil
+}
+
+func (c *radosIOContext) listOmapKeysPage(coid *C.char, oid, startAfter string, keys *[]string) (bool, error) {
+	cstart := C.CString(startAfter)
+	defer C.free(unsafe.Pointer(cstart))
+
+	op := C.rados_create_read_op()
+	defer C.rados_release_read_op(op)
+	var (
+		iter  C.rados_omap_iter_t
+		more  C.uchar
+		prval C.int
+	)
+	C.rados_read_op_omap_get_keys2(op, cstart, OMAP_PAGE_SIZE, &iter, &more, &prval)
+	if ret := C.rados_read_op_operate(op, c.ioctx, coid, 0); ret < 0 {
+		return false, radosError("list omap of", oid, ret)
+	}
+	if prval < 0 {
+		return false, radosError("list omap of", oid, prval)
+	}
+	defer C.rados_omap_get_end(iter)
+	for {
+		var (
+			key    *C.char
+			val    *C.char
+			valLen C.size_t
+		)
+		if ret := C.rados_omap_get_next(iter, &key, &val, &valLen); ret < 0 {
+			return false, radosError("iterate omap of", oid, ret)
+		}
+		if key == nil {
+			break
+		}
+		*keys = append(*keys, C.GoString(key))
+	}
+	return more != 0, nil
+}
@@ -0,0 +1,277 @@
+package rados
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/objectstore"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "rados"})
+)
+
+// RadosObjectStoreDriver stores each file as an object named by its path.
+// The directories are objects named with the trailing "/", and the names in
+// the directory are kept as the keys of its omap.
+type RadosObjectStoreDriver struct {
+	destURL string
+	path    string
+	config  ClusterConfig
+	ioctx   ioContext
+}
+
+const (
+	KIND = "rados"
+
+	ENV_CEPH_CONF = "CEPH_CONF"
+	ENV_KEYRING   = "CEPH_KEYRING"
+	ENV_CLIENT_ID = "CEPH_CLIENT_ID"
+
+	DEFAULT_CEPH_CONF = "/etc/ceph/ceph.conf"
+	DEFAULT_CLIENT_ID = "admin"
+
+	// Large files would be written and read in chunks of this size
+	CHUNK_SIZE = 4 << 20
+)
+
+func init() {
+	if err := objectstore.RegisterDriver(KIND, initFunc); err != nil {
+		panic(err)
+	}
+}
+
+func initFunc(destURL, endpoint string) (objectstore.ObjectStoreDriver, error) {
+	return initFuncWithIOContext(destURL, newIOContext)
+}
+
+func getEnvWithDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func initFuncWithIOContext(destURL string, open func(config *ClusterConfig) (ioContext, error)) (objectstore.ObjectStoreDriver, error) {
+	b := &RadosObjectStoreDriver{}
+
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != KIND {
+		return nil, fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, KIND)
+	}
+
+	// rados://[namespace@]pool/path
+	b.config.Pool = u.Host
+	if b.config.Pool == "" {
+		return nil, fmt.Errorf("Invalid URL. Must be %v://[namespace@]pool/path/", KIND)
+	}
+	if u.User != nil {
+		b.config.Namespace = u.User.Username()
+	}
+	b.path = strings.Trim(filepath.Clean("/"+u.Path), "/")
+
+	b.config.ConfFile = getEnvWithDefault(ENV_CEPH_CONF, DEFAULT_CEPH_CONF)
+	b.config.Keyring = os.Getenv(ENV_KEYRING)
+	b.config.ClientID = getEnvWithDefault(ENV_CLIENT_ID, DEFAULT_CLIENT_ID)
+
+	// Opening the pool would verify the connection and permission
+	if b.ioctx, err = open(&b.config); err != nil {
+		return nil, err
+	}
+
+	b.destURL = KIND + "://" + u.Host + "/" + b.path
+	if b.config.Namespace != "" {
+		b.destURL = KIND + "://" + b.config.Namespace + "@" + u.Host + "/" + b.path
+	}
+	log.Debugf("Loaded driver for %v", b.destURL)
+	return b, nil
+}
+
+func (r *RadosObjectStoreDriver) Kind() string {
+	return KIND
+}
+
+func (r *RadosObjectStoreDriver) GetURL() string {
+	return r.destURL
+}
+
+func (r *RadosObjectStoreDriver) updatePath(path string) string {
+	return filepath.Join(r.path, path)
+}
+
+func dirObject(dir string) string {
+	if dir == "." || dir == "" {
+		return "/"
+	}
+	return dir + "/"
+}
+
+// parentDir returns "" for the objects at the top level
+func parentDir(path string) string {
+	dir := filepath.Dir(path)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// addToIndex adds the object to the directories up to the top level
+func (r *RadosObjectStoreDriver) addToIndex(path string) error {
+	for path != "" {
+		dir := parentDir(path)
+		if err := r.ioctx.SetOmapKeys(dirObject(dir), []string{filepath.Base(path)}); err != nil {
+			return err
+		}
+		path = dir
+	}
+	return nil
+}
+
+func (r *RadosObjectStoreDriver) List(listPath string) ([]string, error) {
+	keys, err := r.ioctx.ListOmapKeys(dirObject(r.updatePath(listPath)))
+	if err == errNotFound {
+		return nil, fmt.Errorf("Cannot find directory %v in pool %v", r.updatePath(listPath), r.config.Pool)
+	}
+	if err != nil {
+		log.Error("Fail to list rados: ", err)
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *RadosObjectStoreDriver) FileExists(filePath string) bool {
+	return r.FileSize(filePath) >= 0
+}
+
+func (r *RadosObjectStoreDriver) FileSize(filePath string) int64 {
+	size, err := r.ioctx.Stat(r.updatePath(filePath))
+	if err != nil {
+		return -1
+	}
+	return int64(size)
+}
+
+func (r *RadosObjectStoreDriver) Remove(names ...string) error {
+	for _, name := range names {
+		path := r.updatePath(name)
+		if err := r.removeAll(path); err != nil {
+			return err
+		}
+		if err := r.ioctx.RemoveOmapKeys(dirObject(parentDir(path)), []string{filepath.Base(path)}); err != nil && err != errNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RadosObjectStoreDriver) removeAll(path string) error {
+	if err := r.ioctx.Remove(path); err != nil && err != errNotFound {
+		return err
+	}
+	keys, err := r.ioctx.ListOmapKeys(dirObject(path))
+	if err == errNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := r.removeAll(filepath.Join(path, key)); err != nil {
+			return err
+		}
+	}
+	if err := r.ioctx.Remove(dirObject(path)); err != nil && err != errNotFound {
+		return err
+	}
+	return nil
+}
+
+func (r *RadosObjectStoreDriver) readTo(path string, w io.Writer) error {
+	buf := make([]byte, CHUNK_SIZE)
+	offset := uint64(0)
+	for {
+		n, err := r.ioctx.Read(path, buf, offset)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(buf[:n]); err != nil {
+			return err
+		}
+		if n < len(buf) {
+			return nil
+		}
+		offset += uint64(n)
+	}
+}
+
+func (r *RadosObjectStoreDriver) Read(src string) (io.ReadCloser, error) {
+	buf := &bytes.Buffer{}
+	if err := r.readTo(r.updatePath(src), buf); err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(buf), nil
+}
+
+// writeFrom writes the object in chunks, the object size is limited by
+// osd_max_object_size of the cluster
+func (r *RadosObjectStoreDriver) writeFrom(path string, rd io.Reader) error {
+	buf := make([]byte, CHUNK_SIZE)
+	offset := uint64(0)
+	for {
+		n, err := io.ReadFull(rd, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if offset == 0 {
+			// Truncate the existing object
+			if err := r.ioctx.WriteFull(path, buf[:n]); err != nil {
+				return err
+			}
+		} else if n != 0 {
+			if err := r.ioctx.Write(path, buf[:n], offset); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			break
+		}
+		offset += uint64(n)
+	}
+	return r.addToIndex(path)
+}
+
+func (r *RadosObjectStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	return r.writeFrom(r.updatePath(dst), rs)
+}
+
+func (r *RadosObjectStoreDriver) Upload(src, dst string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return r.writeFrom(r.updatePath(dst), file)
+}
+
+func (r *RadosObjectStoreDriver) Download(src, dst string) error {
+	if _, err := os.Stat(dst); err != nil {
+		os.Remove(dst)
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return r.readTo(r.updatePath(src), f)
+}
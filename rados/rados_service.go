@@ -0,0 +1,48 @@
+package rados
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	errNotFound = errors.New("RADOS object not found")
+
+	// openIOContext would be set if convoy was built with librados, by
+	// "-tags rados"
+	openIOContext func(config *ClusterConfig) (ioContext, error)
+)
+
+type ClusterConfig struct {
+	ConfFile  string
+	Keyring   string
+	ClientID  string
+	Pool      string
+	Namespace string
+}
+
+// ioContext is the subset of librados used by the driver, bound to a pool
+type ioContext interface {
+	WriteFull(oid string, data []byte) error
+	Write(oid string, data []byte, offset uint64) error
+	// Read returns the number of bytes read, which is less than len(data)
+	// at the end of object
+	Read(oid string, data []byte, offset uint64) (int, error)
+	Stat(oid string) (uint64, error)
+	Remove(oid string) error
+
+	// The omap of the directory objects are used as the index of the
+	// objects in the directory, since RADOS has no hierarchy
+	SetOmapKeys(oid string, keys []string) error
+	RemoveOmapKeys(oid string, keys []string) error
+	ListOmapKeys(oid string) ([]string, error)
+
+	Destroy()
+}
+
+func newIOContext(config *ClusterConfig) (ioContext, error) {
+	if openIOContext == nil {
+		return nil, fmt.Errorf("Convoy was built without librados support, rebuild it with \"-tags rados\"")
+	}
+	return openIOContext(config)
+}
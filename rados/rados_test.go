@@ -0,0 +1,233 @@
+package rados
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rancher/convoy/objectstore"
+	"gopkg.in/check.v1"
+)
+
+func TestRados(t *testing.T) { check.TestingT(t) }
+
+type RadosTestSuite struct {
+	objects map[string][]byte
+	omaps   map[string]map[string]bool
+	config  *ClusterConfig
+}
+
+var _ = check.Suite(&RadosTestSuite{})
+
+// fakeIOContext keeps the objects and omaps of a pool in memory
+type fakeIOContext struct {
+	s *RadosTestSuite
+}
+
+func (f *fakeIOContext) WriteFull(oid string, data []byte) error {
+	f.s.objects[oid] = append([]byte{}, data...)
+	return nil
+}
+
+func (f *fakeIOContext) Write(oid string, data []byte, offset uint64) error {
+	obj := f.s.objects[oid]
+	for uint64(len(obj)) < offset+uint64(len(data)) {
+		obj = append(obj, 0)
+	}
+	copy(obj[offset:], data)
+	f.s.objects[oid] = obj
+	return nil
+}
+
+func (f *fakeIOContext) Read(oid string, data []byte, offset uint64) (int, error) {
+	obj, exists := f.s.objects[oid]
+	if !exists {
+		return 0, errNotFound
+	}
+	if offset >= uint64(len(obj)) {
+		return 0, nil
+	}
+	return copy(data, obj[offset:]), nil
+}
+
+func (f *fakeIOContext) Stat(oid string) (uint64, error) {
+	obj, exists := f.s.objects[oid]
+	if !exists {
+		return 0, errNotFound
+	}
+	return uint64(len(obj)), nil
+}
+
+func (f *fakeIOContext) Remove(oid string) error {
+	_, isObject := f.s.objects[oid]
+	_, isOmap := f.s.omaps[oid]
+	if !isObject && !isOmap {
+		return errNotFound
+	}
+	delete(f.s.objects, oid)
+	delete(f.s.omaps, oid)
+	return nil
+}
+
+func (f *fakeIOContext) SetOmapKeys(oid string, keys []string) error {
+	if f.s.omaps[oid] == nil {
+		f.s.omaps[oid] = map[string]bool{}
+	}
+	for _, key := range keys {
+		f.s.omaps[oid][key] = true
+	}
+	return nil
+}
+
+func (f *fakeIOContext) RemoveOmapKeys(oid string, keys []string) error {
+	omap, exists := f.s.omaps[oid]
+	if !exists {
+		return errNotFound
+	}
+	for _, key := range keys {
+		delete(omap, key)
+	}
+	return nil
+}
+
+func (f *fakeIOContext) ListOmapKeys(oid string) ([]string, error) {
+	omap, exists := f.s.omaps[oid]
+	if !exists {
+		return nil, errNotFound
+	}
+	keys := []string{}
+	for key := range omap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (f *fakeIOContext) Destroy() {
+}
+
+func (s *RadosTestSuite) SetUpTest(c *check.C) {
+	s.objects = map[string][]byte{}
+	s.omaps = map[string]map[string]bool{}
+	s.config = nil
+}
+
+func (s *RadosTestSuite) open(config *ClusterConfig) (ioContext, error) {
+	s.config = config
+	return &fakeIOContext{s}, nil
+}
+
+func (s *RadosTestSuite) newDriver(c *check.C, destURL string) objectstore.ObjectStoreDriver {
+	driver, err := initFuncWithIOContext(destURL, s.open)
+	c.Assert(err, check.IsNil)
+	return driver
+}
+
+func (s *RadosTestSuite) TestInit(c *check.C) {
+	os.Setenv(ENV_KEYRING, "/etc/ceph/ceph.client.backup.keyring")
+	os.Setenv(ENV_CLIENT_ID, "backup")
+	defer os.Unsetenv(ENV_KEYRING)
+	defer os.Unsetenv(ENV_CLIENT_ID)
+
+	driver := s.newDriver(c, "rados://backups/convoy/")
+	c.Assert(driver.GetURL(), check.Equals, "rados://backups/convoy")
+	c.Assert(*s.config, check.DeepEquals, ClusterConfig{
+		ConfFile: DEFAULT_CEPH_CONF,
+		Keyring:  "/etc/ceph/ceph.client.backup.keyring",
+		ClientID: "backup",
+		Pool:     "backups",
+	})
+
+	driver = s.newDriver(c, "rados://tenant@backups")
+	c.Assert(driver.GetURL(), check.Equals, "rados://tenant@backups/")
+	c.Assert(s.config.Namespace, check.Equals, "tenant")
+
+	_, err := initFuncWithIOContext("rados:///path", s.open)
+	c.Assert(err, check.ErrorMatches, "Invalid URL.*")
+
+	_, err = newIOContext(&ClusterConfig{})
+	if openIOContext == nil {
+		c.Assert(err, check.ErrorMatches, ".*without librados support.*")
+	}
+}
+
+func (s *RadosTestSuite) TestReadWrite(c *check.C) {
+	driver := s.newDriver(c, "rados://backups/convoy")
+
+	data := strings.Repeat("0123456789abcdef", CHUNK_SIZE/16+1)
+	c.Assert(driver.Write("volumes/vol1/volume.cfg", strings.NewReader(data)), check.IsNil)
+	c.Assert(s.objects["convoy/volumes/vol1/volume.cfg"], check.HasLen, len(data))
+	c.Assert(driver.FileSize("volumes/vol1/volume.cfg"), check.Equals, int64(len(data)))
+	c.Assert(driver.FileExists("volumes/vol1/volume.cfg"), check.Equals, true)
+	c.Assert(driver.FileSize("volumes/vol1/none.cfg"), check.Equals, int64(-1))
+
+	rc, err := driver.Read("volumes/vol1/volume.cfg")
+	c.Assert(err, check.IsNil)
+	read, err := ioutil.ReadAll(rc)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(read), check.Equals, data)
+
+	// Overwrite would truncate the object
+	c.Assert(driver.Write("volumes/vol1/volume.cfg", strings.NewReader("short")), check.IsNil)
+	rc, err = driver.Read("volumes/vol1/volume.cfg")
+	c.Assert(err, check.IsNil)
+	read, err = ioutil.ReadAll(rc)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(read), check.Equals, "short")
+
+	_, err = driver.Read("volumes/vol1/none.cfg")
+	c.Assert(err, check.Equals, errNotFound)
+
+	dir, err := ioutil.TempDir("", "convoy-rados")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	c.Assert(ioutil.WriteFile(src, []byte(data), 0644), check.IsNil)
+	c.Assert(driver.Upload(src, "volumes/vol1/backups/backup.cfg"), check.IsNil)
+	c.Assert(driver.Download("volumes/vol1/backups/backup.cfg", dst), check.IsNil)
+	read, err = ioutil.ReadFile(dst)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(read), check.Equals, data)
+}
+
+func (s *RadosTestSuite) TestListRemove(c *check.C) {
+	driver := s.newDriver(c, "rados://backups/convoy")
+
+	for _, f := range []string{
+		"volumes/vol1/volume.cfg",
+		"volumes/vol1/backups/backup_1.cfg",
+		"volumes/vol2/volume.cfg",
+	} {
+		c.Assert(driver.Write(f, strings.NewReader(f)), check.IsNil)
+	}
+
+	names, err := driver.List("")
+	c.Assert(err, check.IsNil)
+	c.Assert(names, check.DeepEquals, []string{"volumes"})
+	names, err = driver.List("volumes")
+	c.Assert(err, check.IsNil)
+	c.Assert(names, check.DeepEquals, []string{"vol1", "vol2"})
+	names, err = driver.List("volumes/vol1")
+	c.Assert(err, check.IsNil)
+	c.Assert(names, check.DeepEquals, []string{"backups", "volume.cfg"})
+
+	_, err = driver.List("volumes/vol3")
+	c.Assert(err, check.ErrorMatches, "Cannot find directory.*")
+
+	c.Assert(driver.Remove("volumes/vol1"), check.IsNil)
+	names, err = driver.List("volumes")
+	c.Assert(err, check.IsNil)
+	c.Assert(names, check.DeepEquals, []string{"vol2"})
+	c.Assert(driver.FileExists("volumes/vol1/backups/backup_1.cfg"), check.Equals, false)
+	_, exists := s.omaps["convoy/volumes/vol1/backups/"]
+	c.Assert(exists, check.Equals, false)
+
+	c.Assert(driver.Remove("volumes/vol2/volume.cfg", "volumes/vol3"), check.IsNil)
+	names, err = driver.List("volumes/vol2")
+	c.Assert(err, check.IsNil)
+	c.Assert(names, check.HasLen, 0)
+}
@@ -0,0 +1,849 @@
+package rbd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/metadata"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	DRIVER_NAME        = "rbd"
+	DRIVER_CONFIG_FILE = "rbd.cfg"
+
+	VOLUME_CFG_PREFIX = "volume_"
+	RBD_CFG_PREFIX    = DRIVER_NAME + "_"
+	CFG_POSTFIX       = ".json"
+
+	MOUNTS_DIR = "mounts"
+
+	RBD_BINARY = "rbd"
+
+	RBD_POOL                = "rbd.pool"
+	RBD_CLIENT_ID           = "rbd.id"
+	RBD_CONF                = "rbd.conf"
+	RBD_DEFAULT_VOLUME_SIZE = "rbd.defaultvolumesize"
+	RBD_DEFAULT_FS_TYPE     = "rbd.fs"
+
+	DEFAULT_VOLUME_SIZE = "100G"
+	DEFAULT_FS_TYPE     = "ext4"
+
+	MB = 1024 * 1024
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "rbd"})
+)
+
+type Driver struct {
+	mutex *sync.RWMutex
+	Device
+}
+
+type Device struct {
+	Root              string
+	Pool              string
+	ClientID          string
+	ConfFile          string
+	DefaultVolumeSize int64
+	Filesystem        string
+}
+
+func (dev *Device) ConfigFile() (string, error) {
+	if dev.Root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty device config path")
+	}
+	return filepath.Join(dev.Root, DRIVER_CONFIG_FILE), nil
+}
+
+// Volume is mapped to the host only when it's in use, so it can be used by
+// another host after umounted
+type Volume struct {
+	Name        string
+	Image       string
+	Size        int64
+	MappedDev   string
+	MountPoint  string
+	CreatedTime string
+	Filesystem  string
+	Snapshots   map[string]Snapshot
+
+	configPath string
+}
+
+type Snapshot struct {
+	Name        string
+	CreatedTime string
+	MappedDev   string
+	SnapshotMetadata
+}
+
+func (v *Volume) ConfigFile() (string, error) {
+	if v.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if v.configPath == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume config path")
+	}
+	return filepath.Join(v.configPath, RBD_CFG_PREFIX+VOLUME_CFG_PREFIX+v.Name+CFG_POSTFIX), nil
+}
+
+func (v *Volume) GetDevice() (string, error) {
+	if v.MappedDev == "" {
+		return "", fmt.Errorf("RBD image %v of volume %v is not mapped", v.Image, v.Name)
+	}
+	return v.MappedDev, nil
+}
+
+func (v *Volume) GetMountOpts() []string {
+	return []string{}
+}
+
+func (v *Volume) GenerateDefaultMountPoint() string {
+	return filepath.Join(v.configPath, MOUNTS_DIR, v.Name)
+}
+
+func init() {
+	if err := Register(DRIVER_NAME, Init); err != nil {
+		panic(err)
+	}
+}
+
+func (d *Driver) Name() string {
+	return DRIVER_NAME
+}
+
+func (device *Device) listVolumeNames() ([]string, error) {
+	return util.ListConfigIDs(device.Root, RBD_CFG_PREFIX+VOLUME_CFG_PREFIX, CFG_POSTFIX)
+}
+
+func (d *Driver) blankVolume(name string) *Volume {
+	return &Volume{
+		configPath: d.Root,
+		Name:       name,
+	}
+}
+
+// rbd executes the command with the cluster options. Only stdout would be
+// returned, since warnings may be printed to stderr.
+func (dev *Device) rbd(args ...string) (string, error) {
+	if dev.ConfFile != "" {
+		args = append([]string{"-c", dev.ConfFile}, args...)
+	}
+	if dev.ClientID != "" {
+		args = append([]string{"--id", dev.ClientID}, args...)
+	}
+	cmd := exec.Command(RBD_BINARY, args...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("Failed to execute rbd %v: %v, %v", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (d *Driver) imageSpec(image string) string {
+	return d.Pool + "/" + image
+}
+
+func (d *Driver) snapshotSpec(image, snapshot string) string {
+	return d.Pool + "/" + image + "@" + snapshot
+}
+
+func (d *Driver) mapImage(spec string, readOnly bool) (string, error) {
+	args := []string{"map", spec}
+	if readOnly {
+		args = append(args, "--read-only")
+	}
+	dev, err := d.rbd(args...)
+	if err != nil {
+		return "", err
+	}
+	log.Debugf("Mapped RBD %v to %v", spec, dev)
+	return dev, nil
+}
+
+func (d *Driver) unmapImage(dev string) error {
+	if _, err := d.rbd("unmap", dev); err != nil {
+		return err
+	}
+	log.Debugf("Unmapped RBD device %v", dev)
+	return nil
+}
+
+func verifyConfig(config map[string]string) (*Device, error) {
+	dv := &Device{
+		Pool:     config[RBD_POOL],
+		ClientID: config[RBD_CLIENT_ID],
+		ConfFile: config[RBD_CONF],
+	}
+	if dv.Pool == "" {
+		return nil, fmt.Errorf("RBD pool unspecified")
+	}
+	// Verify the connection to the cluster and the pool
+	if _, err := dv.rbd("ls", dv.Pool); err != nil {
+		return nil, err
+	}
+
+	if _, exists := config[RBD_DEFAULT_VOLUME_SIZE]; !exists {
+		config[RBD_DEFAULT_VOLUME_SIZE] = DEFAULT_VOLUME_SIZE
+	}
+	volumeSize, err := util.ParseSize(config[RBD_DEFAULT_VOLUME_SIZE])
+	if err != nil || volumeSize == 0 {
+		return nil, fmt.Errorf("Illegal default volume size specified")
+	}
+	dv.DefaultVolumeSize = volumeSize
+
+	if _, exists := config[RBD_DEFAULT_FS_TYPE]; !exists {
+		config[RBD_DEFAULT_FS_TYPE] = DEFAULT_FS_TYPE
+	}
+	fsType := config[RBD_DEFAULT_FS_TYPE]
+	if fsType != "ext4" && fsType != "xfs" {
+		return nil, fmt.Errorf("Unsupported filesystem type specified")
+	}
+	dv.Filesystem = fsType
+	return dv, nil
+}
+
+// remountVolumes maps and mounts the volumes again after the host restarted
+func (d *Driver) remountVolumes() error {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return err
+	}
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return err
+		}
+		if volume.MountPoint == "" {
+			continue
+		}
+		if _, err := os.Stat(volume.MappedDev); err != nil {
+			volume.MappedDev = ""
+		}
+		req := Request{
+			Name: id,
+			Options: map[string]string{
+				OPT_MOUNT_POINT: volume.MountPoint,
+			},
+		}
+		if _, err := d.mountVolume(volume, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Init(root string, config map[string]string) (ConvoyDriver, error) {
+	if _, err := exec.LookPath(RBD_BINARY); err != nil {
+		return nil, fmt.Errorf("Cannot find %v binary, please make sure ceph-common is installed", RBD_BINARY)
+	}
+
+	if err := util.MkdirIfNotExists(root); err != nil {
+		return nil, err
+	}
+	dev := &Device{
+		Root: root,
+	}
+	exists, err := util.ObjectExists(dev)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if err := util.ObjectLoad(dev); err != nil {
+			return nil, err
+		}
+		d := &Driver{
+			mutex:  &sync.RWMutex{},
+			Device: *dev,
+		}
+		if err := d.remountVolumes(); err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+
+	dev, err = verifyConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	dev.Root = root
+
+	if err := util.ObjectSave(dev); err != nil {
+		return nil, err
+	}
+	d := &Driver{
+		mutex:  &sync.RWMutex{},
+		Device: *dev,
+	}
+	return d, nil
+}
+
+func (d *Driver) Info() (map[string]string, error) {
+	return map[string]string{
+		"Driver":            d.Name(),
+		"Root":              d.Root,
+		"Pool":              d.Pool,
+		"ClientID":          d.ClientID,
+		"ConfFile":          d.ConfFile,
+		"DefaultVolumeSize": strconv.FormatInt(d.DefaultVolumeSize, 10),
+		"Filesystem":        d.Filesystem,
+	}, nil
+}
+
+func (d *Driver) VolumeOps() (VolumeOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) SnapshotOps() (SnapshotOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) getSize(opts map[string]string, defaultVolumeSize int64) (int64, error) {
+	size := opts[OPT_SIZE]
+	if size == "" || size == "0" {
+		size = strconv.FormatInt(defaultVolumeSize, 10)
+	}
+	return util.ParseSize(size)
+}
+
+func (d *Driver) CreateVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var (
+		size int64
+		err  error
+	)
+	id := req.Name
+	opts := req.Options
+
+	backupURL := opts[OPT_BACKUP_URL]
+	endpointURL := opts[OPT_ENDPOINT_URL]
+	if backupURL != "" {
+		objVolume, err := objectstore.LoadVolume(backupURL, endpointURL)
+		if err != nil {
+			return err
+		}
+		if err := objectstore.CheckRestoreDriver(objVolume, backupURL, endpointURL, d.Name()); err != nil {
+			return err
+		}
+		size, err = d.getSize(opts, objVolume.Size)
+		if err != nil {
+			return err
+		}
+		if size < objVolume.Size {
+			return fmt.Errorf("Volume size cannot be less than backup's size %v", objVolume.Size)
+		}
+	} else {
+		size, err = d.getSize(opts, d.DefaultVolumeSize)
+		if err != nil {
+			return err
+		}
+	}
+	if size%objectstore.DEFAULT_BLOCK_SIZE != 0 {
+		return fmt.Errorf("Size must be multiple of %v", objectstore.DEFAULT_BLOCK_SIZE)
+	}
+
+	volume := d.blankVolume(id)
+	exists, err := util.ObjectExists(volume)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Already has volume with specific uuid %v", id)
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_START,
+		LOG_FIELD_EVENT:  LOG_EVENT_CREATE,
+		LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME: id,
+	}).Debugf("Creating RBD image in pool %v", d.Pool)
+	if _, err := d.rbd("create", "--size", strconv.FormatInt(size/MB, 10), d.imageSpec(id)); err != nil {
+		return err
+	}
+
+	volume.Image = id
+	volume.Size = size
+	volume.CreatedTime = util.Now()
+	volume.Snapshots = make(map[string]Snapshot)
+	volume.Filesystem = d.Filesystem
+	if err := util.ObjectSave(volume); err != nil {
+		return err
+	}
+
+	dev, err := d.mapImage(d.imageSpec(id), false)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := d.unmapImage(dev); err != nil {
+			log.Warnf("Failed to unmap %v: %v", dev, err)
+		}
+	}()
+	if backupURL != "" {
+		return objectstore.RestoreDeltaBlockBackupToSize(backupURL, endpointURL, dev, size)
+	}
+	log.Debugf("Formatting device %s with %s filesystem", dev, d.Filesystem)
+	if _, err := util.Execute("mkfs", []string{"-t", d.Filesystem, dev}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (d *Driver) DeleteVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	if volume.MountPoint != "" {
+		return fmt.Errorf("Cannot delete volume %s, it hasn't been umounted", id)
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_START,
+		LOG_FIELD_EVENT:  LOG_EVENT_REMOVE,
+		LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME: id,
+	}).Debugf("Removing RBD image and its snapshots")
+	if len(volume.Snapshots) != 0 {
+		if _, err := d.rbd("snap", "purge", d.imageSpec(volume.Image)); err != nil {
+			return err
+		}
+	}
+	if _, err := d.rbd("rm", d.imageSpec(volume.Image)); err != nil {
+		return err
+	}
+	return util.ObjectDelete(volume)
+}
+
+func (d *Driver) MountVolume(req Request) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	return d.mountVolume(volume, req)
+}
+
+func (d *Driver) mountVolume(volume *Volume, req Request) (string, error) {
+	var err error
+	mapped := false
+	if volume.MappedDev == "" {
+		if volume.MappedDev, err = d.mapImage(d.imageSpec(volume.Image), false); err != nil {
+			return "", err
+		}
+		mapped = true
+	}
+
+	mountPoint, err := util.VolumeMount(volume, req.Options[OPT_MOUNT_POINT], false)
+	if err != nil {
+		if mapped {
+			if err := d.unmapImage(volume.MappedDev); err != nil {
+				log.Warnf("Failed to unmap %v: %v", volume.MappedDev, err)
+			}
+		}
+		return "", err
+	}
+
+	if err := util.ObjectSave(volume); err != nil {
+		return "", err
+	}
+	return mountPoint, nil
+}
+
+func (d *Driver) UmountVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	if err := util.VolumeUmount(volume); err != nil {
+		return err
+	}
+	if volume.MappedDev != "" {
+		if err := d.unmapImage(volume.MappedDev); err != nil {
+			return err
+		}
+		volume.MappedDev = ""
+	}
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) MountPoint(req Request) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	return volume.MountPoint, nil
+}
+
+func (d *Driver) GetVolumeInfo(id string) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.getVolumeInfo(id)
+}
+
+func (d *Driver) getVolumeInfo(id string) (map[string]string, error) {
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"Pool":                  d.Pool,
+		"Image":                 volume.Image,
+		"Device":                volume.MappedDev,
+		OPT_VOLUME_NAME:         volume.Name,
+		OPT_VOLUME_CREATED_TIME: volume.CreatedTime,
+		OPT_MOUNT_POINT:         volume.MountPoint,
+		OPT_SIZE:                strconv.FormatInt(volume.Size, 10),
+		OPT_FILESYSTEM:          volume.Filesystem,
+	}, nil
+}
+
+func (d *Driver) ListVolume(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	volumes := make(map[string]map[string]string)
+	for _, id := range volumeIDs {
+		volumes[id], err = d.getVolumeInfo(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return volumes, nil
+}
+
+func (d *Driver) CreateSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if _, exists := volume.Snapshots[id]; exists {
+		return fmt.Errorf("Snapshot %v already exists for volume %v", id, volumeID)
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_CREATE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: id,
+		LOG_FIELD_VOLUME:   volumeID,
+	}).Debugf("Creating RBD snapshot")
+	if volume.MountPoint != "" {
+		log.Debugf("syncing filesystems...")
+		if err := util.Sync(); err != nil {
+			return err
+		}
+	}
+	if _, err := d.rbd("snap", "create", d.snapshotSpec(volume.Image, id)); err != nil {
+		return err
+	}
+
+	volume.Snapshots[id] = Snapshot{
+		Name:             id,
+		CreatedTime:      util.Now(),
+		SnapshotMetadata: NewSnapshotMetadata(req.Options),
+	}
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) DeleteSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+	snapshot, volume, err := d.getSnapshotAndVolume(req.Name, volumeID)
+	if err != nil {
+		return err
+	}
+	if snapshot.MappedDev != "" {
+		return fmt.Errorf("Cannot delete snapshot %v, it's still mapped at %v", req.Name, snapshot.MappedDev)
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_REMOVE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: req.Name,
+		LOG_FIELD_VOLUME:   volumeID,
+	}).Debugf("Removing RBD snapshot")
+	if _, err := d.rbd("snap", "rm", d.snapshotSpec(volume.Image, req.Name)); err != nil {
+		return err
+	}
+	delete(volume.Snapshots, req.Name)
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) getSnapshotAndVolume(snapshotID, volumeID string) (*Snapshot, *Volume, error) {
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, nil, err
+	}
+	snapshot, exists := volume.Snapshots[snapshotID]
+	if !exists {
+		return nil, nil, fmt.Errorf("Cannot find snapshot %v of volume %v", snapshotID, volumeID)
+	}
+	return &snapshot, volume, nil
+}
+
+func (d *Driver) GetSnapshotInfo(req Request) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return nil, err
+	}
+	return d.getSnapshotInfo(req.Name, volumeID)
+}
+
+func (d *Driver) getSnapshotInfo(id, volumeID string) (map[string]string, error) {
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		OPT_SNAPSHOT_NAME:         snapshot.Name,
+		OPT_SNAPSHOT_LABELS:       snapshot.Labels,
+		OPT_SNAPSHOT_DESCRIPTION:  snapshot.Description,
+		OPT_SNAPSHOT_CREATED_TIME: snapshot.CreatedTime,
+		OPT_SIZE:                  strconv.FormatInt(volume.Size, 10),
+		"VolumeUUID":              volumeID,
+		"Snapshot":                d.snapshotSpec(volume.Image, id),
+	}, nil
+}
+
+func (d *Driver) ListSnapshot(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	var (
+		volumeIDs []string
+		err       error
+	)
+	snapshots := make(map[string]map[string]string)
+	specifiedVolumeID, _ := util.GetFieldFromOpts(OPT_VOLUME_NAME, opts)
+	if specifiedVolumeID != "" {
+		volumeIDs = []string{
+			specifiedVolumeID,
+		}
+	} else {
+		volumeIDs, err = d.listVolumeNames()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, volumeID := range volumeIDs {
+		volume := d.blankVolume(volumeID)
+		if err := util.ObjectLoad(volume); err != nil {
+			return nil, err
+		}
+		for snapshotID := range volume.Snapshots {
+			snapshots[snapshotID], err = d.getSnapshotInfo(snapshotID, volumeID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return snapshots, nil
+}
+
+func (d *Driver) BackupOps() (BackupOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) HasSnapshot(id, volumeID string) bool {
+	_, _, err := d.getSnapshotAndVolume(id, volumeID)
+	return err == nil
+}
+
+// CompareSnapshot uses "rbd diff", which only lists the allocated extents for
+// full backup
+func (d *Driver) CompareSnapshot(id, compareID, volumeID string) (*metadata.Mappings, error) {
+	_, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"diff", "--format", "json"}
+	if compareID != "" && compareID != id {
+		if _, _, err := d.getSnapshotAndVolume(compareID, volumeID); err != nil {
+			return nil, err
+		}
+		args = append(args, "--from-snap", compareID)
+	}
+	args = append(args, d.snapshotSpec(volume.Image, id))
+
+	out, err := d.rbd(args...)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.RBDDiffParser([]byte(out), objectstore.DEFAULT_BLOCK_SIZE)
+}
+
+func (d *Driver) OpenSnapshot(id, volumeID string) error {
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return err
+	}
+	if snapshot.MappedDev != "" {
+		return nil
+	}
+	if snapshot.MappedDev, err = d.mapImage(d.snapshotSpec(volume.Image, id), true); err != nil {
+		return err
+	}
+	volume.Snapshots[id] = *snapshot
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) ReadSnapshot(id, volumeID string, offset int64, data []byte) error {
+	snapshot, _, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return err
+	}
+	if snapshot.MappedDev == "" {
+		return fmt.Errorf("BUG: Snapshot %v of volume %v is not mapped", id, volumeID)
+	}
+
+	devFile, err := os.Open(snapshot.MappedDev)
+	if err != nil {
+		return err
+	}
+	defer devFile.Close()
+
+	_, err = devFile.ReadAt(data, offset)
+	return err
+}
+
+func (d *Driver) CloseSnapshot(id, volumeID string) error {
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return err
+	}
+	if snapshot.MappedDev == "" {
+		return nil
+	}
+	if err := d.unmapImage(snapshot.MappedDev); err != nil {
+		return err
+	}
+	snapshot.MappedDev = ""
+	volume.Snapshots[id] = *snapshot
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) CreateBackup(snapshotID, volumeID, destURL, endpointURL string, opts map[string]string) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+
+	objVolume := &objectstore.Volume{
+		Name:        volumeID,
+		Driver:      d.Name(),
+		Size:        volume.Size,
+		CreatedTime: opts[OPT_VOLUME_CREATED_TIME],
+	}
+	objSnapshot := &objectstore.Snapshot{
+		Name:        snapshotID,
+		CreatedTime: opts[OPT_SNAPSHOT_CREATED_TIME],
+		ExportImage: opts[OPT_EXPORT_IMAGE],
+		Labels:      objectstore.DecodeLabels(opts[OPT_BACKUP_LABELS]),
+	}
+	return objectstore.CreateDeltaBlockBackup(objVolume, objSnapshot, destURL, endpointURL, d)
+}
+
+func (d *Driver) EstimateBackup(snapshotID, volumeID, destURL, endpointURL string, opts map[string]string) (map[string]string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+
+	objVolume := &objectstore.Volume{
+		Name:   volumeID,
+		Driver: d.Name(),
+		Size:   volume.Size,
+	}
+	objSnapshot := &objectstore.Snapshot{
+		Name: snapshotID,
+	}
+	return objectstore.EstimateDeltaBlockBackup(objVolume, objSnapshot, destURL, endpointURL, d)
+}
+
+func (d *Driver) DeleteBackup(backupURL, endpointURL string, opts map[string]string) error {
+	objVolume, err := objectstore.LoadVolume(backupURL, endpointURL)
+	if err != nil {
+		return err
+	}
+	if objVolume.Driver != d.Name() {
+		return fmt.Errorf("BUG: Wrong driver handling DeleteBackup(), driver should be %v but is %v", objVolume.Driver, d.Name())
+	}
+	force, _ := strconv.ParseBool(opts[OPT_FORCE])
+	return objectstore.DeleteDeltaBlockBackup(backupURL, endpointURL, force)
+}
+
+func (d *Driver) GetBackupInfo(backupURL, endpointURL string) (map[string]string, error) {
+	objVolume, err := objectstore.LoadVolume(backupURL, endpointURL)
+	if err != nil {
+		return nil, err
+	}
+	if objVolume.Driver != d.Name() {
+		return nil, fmt.Errorf("BUG: Wrong driver handling GetBackupInfo(), driver should be %v but is %v", objVolume.Driver, d.Name())
+	}
+	return objectstore.GetBackupInfo(backupURL, endpointURL)
+}
+
+func (d *Driver) ListBackup(destURL, endpointURL string, opts map[string]string) (map[string]map[string]string, error) {
+	return objectstore.List(opts[OPT_VOLUME_NAME], destURL, endpointURL, d.Name())
+}
@@ -0,0 +1,561 @@
+package reflink
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+)
+
+const (
+	DRIVER_NAME        = "reflink"
+	DRIVER_CONFIG_FILE = "reflink.cfg"
+
+	VOLUME_CFG_PREFIX = "volume_"
+	CFG_PREFIX        = DRIVER_NAME + "_"
+	CFG_POSTFIX       = ".json"
+
+	VOLUMES_DIR    = "volumes"
+	SNAPSHOTS_DIR  = "snapshots"
+	BACKUP_POSTFIX = ".tar.gz"
+
+	REFLINK_PATH = "reflink.path"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "reflink"})
+)
+
+type Driver struct {
+	mutex *sync.RWMutex
+	Device
+}
+
+type Device struct {
+	Root string
+	Path string
+}
+
+func (dev *Device) ConfigFile() (string, error) {
+	if dev.Root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty device config path")
+	}
+	return filepath.Join(dev.Root, DRIVER_CONFIG_FILE), nil
+}
+
+type Volume struct {
+	Name        string
+	Path        string
+	MountPoint  string
+	CreatedTime string
+	Snapshots   map[string]Snapshot
+
+	configPath string
+}
+
+type Snapshot struct {
+	Name        string
+	Path        string
+	CreatedTime string
+	SnapshotMetadata
+}
+
+func (v *Volume) ConfigFile() (string, error) {
+	if v.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if v.configPath == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume config path")
+	}
+	return filepath.Join(v.configPath, CFG_PREFIX+VOLUME_CFG_PREFIX+v.Name+CFG_POSTFIX), nil
+}
+
+func init() {
+	if err := Register(DRIVER_NAME, Init); err != nil {
+		panic(err)
+	}
+}
+
+func (d *Driver) Name() string {
+	return DRIVER_NAME
+}
+
+func (d *Driver) listVolumeNames() ([]string, error) {
+	return util.ListConfigIDs(d.Root, CFG_PREFIX+VOLUME_CFG_PREFIX, CFG_POSTFIX)
+}
+
+func (d *Driver) blankVolume(name string) *Volume {
+	return &Volume{
+		configPath: d.Root,
+		Name:       name,
+	}
+}
+
+func (d *Driver) volumePath(name string) string {
+	return filepath.Join(d.Path, VOLUMES_DIR, name)
+}
+
+func (d *Driver) snapshotPath(volumeName, name string) string {
+	return filepath.Join(d.Path, SNAPSHOTS_DIR, volumeName, name)
+}
+
+// reflinkCopy clones src to dst recursively. The data blocks would be shared
+// between them until either side is modified, so it's instant regardless of
+// the size of src. It would fail if the filesystem doesn't support reflink,
+// rather than falling back to a full copy.
+func reflinkCopy(src, dst string) error {
+	_, err := util.Execute("cp", []string{"-a", "--reflink=always", src, dst})
+	return err
+}
+
+// checkReflink verifies the filesystem of path supports reflink, e.g. XFS
+// created with reflink=1 or Btrfs
+func checkReflink(path string) error {
+	dir, err := ioutil.TempDir(path, ".reflink-check")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, []byte(DRIVER_NAME), 0600); err != nil {
+		return err
+	}
+	if err := reflinkCopy(src, filepath.Join(dir, "dst")); err != nil {
+		return fmt.Errorf("Filesystem of %v doesn't support reflink, please use XFS with reflink enabled or Btrfs: %v", path, err)
+	}
+	return nil
+}
+
+func verifyConfig(root string, config map[string]string) (*Device, error) {
+	dev := &Device{
+		Root: root,
+		Path: config[REFLINK_PATH],
+	}
+	if dev.Path == "" {
+		return nil, fmt.Errorf("Reflink driver base path unspecified")
+	}
+	if err := util.MkdirIfNotExists(dev.Path); err != nil {
+		return nil, err
+	}
+	if err := checkReflink(dev.Path); err != nil {
+		return nil, err
+	}
+	return dev, nil
+}
+
+func Init(root string, config map[string]string) (ConvoyDriver, error) {
+	if err := util.MkdirIfNotExists(root); err != nil {
+		return nil, err
+	}
+	dev := &Device{
+		Root: root,
+	}
+	exists, err := util.ObjectExists(dev)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if err := util.ObjectLoad(dev); err != nil {
+			return nil, err
+		}
+	} else {
+		if dev, err = verifyConfig(root, config); err != nil {
+			return nil, err
+		}
+		if err := util.MkdirIfNotExists(filepath.Join(dev.Path, VOLUMES_DIR)); err != nil {
+			return nil, err
+		}
+		if err := util.MkdirIfNotExists(filepath.Join(dev.Path, SNAPSHOTS_DIR)); err != nil {
+			return nil, err
+		}
+		if err := util.ObjectSave(dev); err != nil {
+			return nil, err
+		}
+	}
+	d := &Driver{
+		mutex:  &sync.RWMutex{},
+		Device: *dev,
+	}
+	return d, nil
+}
+
+func (d *Driver) Info() (map[string]string, error) {
+	return map[string]string{
+		"Driver": d.Name(),
+		"Root":   d.Root,
+		"Path":   d.Path,
+	}, nil
+}
+
+func (d *Driver) VolumeOps() (VolumeOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) CreateVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	opts := req.Options
+
+	backupURL := opts[OPT_BACKUP_URL]
+	endpointURL := opts[OPT_ENDPOINT_URL]
+	if backupURL != "" {
+		objVolume, err := objectstore.LoadVolume(backupURL, endpointURL)
+		if err != nil {
+			return err
+		}
+		if objVolume.Driver != d.Name() {
+			return fmt.Errorf("Cannot restore backup of %v to %v", objVolume.Driver, d.Name())
+		}
+	}
+
+	volume := d.blankVolume(id)
+	exists, err := util.ObjectExists(volume)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Already has volume with specific uuid %v", id)
+	}
+
+	volumePath := d.volumePath(id)
+	if err := util.MkdirIfNotExists(volumePath); err != nil {
+		return err
+	}
+	if backupURL != "" {
+		file, err := objectstore.RestoreSingleFileBackup(backupURL, endpointURL, volumePath)
+		if err != nil {
+			return err
+		}
+		// file would be removed after this because it's under volumePath
+		if err := util.DecompressDir(file, volumePath); err != nil {
+			return err
+		}
+	}
+
+	volume.Path = volumePath
+	volume.CreatedTime = util.Now()
+	volume.Snapshots = make(map[string]Snapshot)
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) DeleteVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	opts := req.Options
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if volume.MountPoint != "" {
+		return fmt.Errorf("Cannot delete volume %v. It is still mounted", id)
+	}
+
+	referenceOnly, _ := strconv.ParseBool(opts[OPT_REFERENCE_ONLY])
+	if !referenceOnly {
+		log.Debugf("Cleaning up %v for volume %v", volume.Path, id)
+		if err := os.RemoveAll(volume.Path); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(filepath.Join(d.Path, SNAPSHOTS_DIR, id)); err != nil {
+			return err
+		}
+	}
+	return util.ObjectDelete(volume)
+}
+
+func (d *Driver) MountVolume(req Request) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	opts := req.Options
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+
+	if opts[OPT_MOUNT_POINT] != "" {
+		return "", fmt.Errorf("Reflink driver doesn't support specified mount point")
+	}
+	volume.MountPoint = volume.Path
+	if err := util.ObjectSave(volume); err != nil {
+		return "", err
+	}
+	return volume.MountPoint, nil
+}
+
+func (d *Driver) UmountVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	volume.MountPoint = ""
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) MountPoint(req Request) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	return volume.MountPoint, nil
+}
+
+func (d *Driver) GetVolumeInfo(name string) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.getVolumeInfo(name)
+}
+
+func (d *Driver) getVolumeInfo(name string) (map[string]string, error) {
+	volume := d.blankVolume(name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"Path":                  volume.Path,
+		OPT_MOUNT_POINT:         volume.MountPoint,
+		OPT_VOLUME_NAME:         volume.Name,
+		OPT_VOLUME_CREATED_TIME: volume.CreatedTime,
+	}, nil
+}
+
+func (d *Driver) ListVolume(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]map[string]string{}
+	for _, id := range volumeIDs {
+		result[id], err = d.getVolumeInfo(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (d *Driver) SnapshotOps() (SnapshotOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) CreateSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if _, exists := volume.Snapshots[id]; exists {
+		return fmt.Errorf("Snapshot %v already exists for volume %v", id, volumeID)
+	}
+
+	snapshotPath := d.snapshotPath(volumeID, id)
+	if err := util.MkdirIfNotExists(filepath.Dir(snapshotPath)); err != nil {
+		return err
+	}
+	if volume.MountPoint != "" {
+		log.Debugf("syncing filesystems...")
+		if err := util.Sync(); err != nil {
+			return err
+		}
+	}
+	log.Debugf("Cloning %v to %v", volume.Path, snapshotPath)
+	if err := reflinkCopy(volume.Path, snapshotPath); err != nil {
+		os.RemoveAll(snapshotPath)
+		return err
+	}
+
+	volume.Snapshots[id] = Snapshot{
+		Name:             id,
+		Path:             snapshotPath,
+		CreatedTime:      util.Now(),
+		SnapshotMetadata: NewSnapshotMetadata(req.Options),
+	}
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) DeleteSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	snapshot, exists := volume.Snapshots[id]
+	if !exists {
+		return fmt.Errorf("Snapshot %v doesn't exists for volume %v", id, volumeID)
+	}
+	if err := os.RemoveAll(snapshot.Path); err != nil {
+		return err
+	}
+	delete(volume.Snapshots, id)
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) GetSnapshotInfo(req Request) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return nil, err
+	}
+	return d.getSnapshotInfo(req.Name, volumeID)
+}
+
+func (d *Driver) getSnapshotInfo(id, volumeID string) (map[string]string, error) {
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+	snapshot, exists := volume.Snapshots[id]
+	if !exists {
+		return nil, fmt.Errorf("Snapshot %v doesn't exists for volume %v", id, volumeID)
+	}
+	return map[string]string{
+		OPT_SNAPSHOT_NAME:         snapshot.Name,
+		OPT_SNAPSHOT_LABELS:       snapshot.Labels,
+		OPT_SNAPSHOT_DESCRIPTION:  snapshot.Description,
+		OPT_SNAPSHOT_CREATED_TIME: snapshot.CreatedTime,
+		OPT_VOLUME_NAME:           volumeID,
+		"Path":                    snapshot.Path,
+	}, nil
+}
+
+func (d *Driver) ListSnapshot(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	var (
+		volumeIDs []string
+		err       error
+	)
+	snapshots := make(map[string]map[string]string)
+	specifiedVolumeID, _ := util.GetFieldFromOpts(OPT_VOLUME_NAME, opts)
+	if specifiedVolumeID != "" {
+		volumeIDs = []string{
+			specifiedVolumeID,
+		}
+	} else {
+		volumeIDs, err = d.listVolumeNames()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, volumeID := range volumeIDs {
+		volume := d.blankVolume(volumeID)
+		if err := util.ObjectLoad(volume); err != nil {
+			return nil, err
+		}
+		for snapshotID := range volume.Snapshots {
+			snapshots[snapshotID], err = d.getSnapshotInfo(snapshotID, volumeID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return snapshots, nil
+}
+
+func (d *Driver) BackupOps() (BackupOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) CreateBackup(snapshotID, volumeID, destURL, endpointURL string, opts map[string]string) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	snapshot, exists := volume.Snapshots[snapshotID]
+	if !exists {
+		return "", fmt.Errorf("Cannot find snapshot %v for volume %v", snapshotID, volumeID)
+	}
+
+	// The snapshot is a plain directory, so it only need to be archived at
+	// the time of backup, in the same format as vfs
+	file := snapshot.Path + BACKUP_POSTFIX
+	if err := util.CompressDir(snapshot.Path, file); err != nil {
+		return "", err
+	}
+	defer os.Remove(file)
+
+	objVolume := &objectstore.Volume{
+		Name:        volume.Name,
+		Driver:      d.Name(),
+		CreatedTime: opts[OPT_VOLUME_CREATED_TIME],
+	}
+	objSnapshot := &objectstore.Snapshot{
+		Name:        snapshotID,
+		CreatedTime: opts[OPT_SNAPSHOT_CREATED_TIME],
+		Labels:      objectstore.DecodeLabels(opts[OPT_BACKUP_LABELS]),
+	}
+	return objectstore.CreateSingleFileBackup(objVolume, objSnapshot, file, destURL, endpointURL)
+}
+
+func (d *Driver) DeleteBackup(backupURL, endpointURL string, opts map[string]string) error {
+	objVolume, err := objectstore.LoadVolume(backupURL, endpointURL)
+	if err != nil {
+		return err
+	}
+	if objVolume.Driver != d.Name() {
+		return fmt.Errorf("BUG: Wrong driver handling DeleteBackup(), driver should be %v but is %v", objVolume.Driver, d.Name())
+	}
+	return objectstore.DeleteSingleFileBackup(backupURL, endpointURL)
+}
+
+func (d *Driver) GetBackupInfo(backupURL, endpointURL string) (map[string]string, error) {
+	objVolume, err := objectstore.LoadVolume(backupURL, endpointURL)
+	if err != nil {
+		return nil, err
+	}
+	if objVolume.Driver != d.Name() {
+		return nil, fmt.Errorf("BUG: Wrong driver handling GetBackupInfo(), driver should be %v but is %v", objVolume.Driver, d.Name())
+	}
+	return objectstore.GetBackupInfo(backupURL, endpointURL)
+}
+
+func (d *Driver) ListBackup(destURL, endpointURL string, opts map[string]string) (map[string]map[string]string, error) {
+	return objectstore.List(opts[OPT_VOLUME_NAME], destURL, endpointURL, d.Name())
+}
@@ -0,0 +1,67 @@
+package reflink
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct {
+	dir string
+}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) SetUpTest(c *C) {
+	var err error
+	s.dir, err = ioutil.TempDir("", "convoy-reflink")
+	c.Assert(err, IsNil)
+}
+
+func (s *TestSuite) TearDownTest(c *C) {
+	os.RemoveAll(s.dir)
+}
+
+func (s *TestSuite) TestVerifyConfig(c *C) {
+	_, err := verifyConfig(s.dir, map[string]string{})
+	c.Assert(err, ErrorMatches, "Reflink driver base path unspecified")
+
+	path := filepath.Join(s.dir, "data")
+	dev, err := verifyConfig(s.dir, map[string]string{REFLINK_PATH: path})
+	if err != nil {
+		c.Assert(err, ErrorMatches, "(?s)Filesystem of "+path+" doesn't support reflink.*")
+		c.Skip("filesystem of test directory doesn't support reflink")
+	}
+	c.Assert(dev.Path, Equals, path)
+
+	// The probe files should be cleaned up
+	files, err := ioutil.ReadDir(path)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 0)
+}
+
+func (s *TestSuite) TestReflinkCopy(c *C) {
+	if err := checkReflink(s.dir); err != nil {
+		c.Skip("filesystem of test directory doesn't support reflink")
+	}
+
+	src := filepath.Join(s.dir, "vol1")
+	c.Assert(os.MkdirAll(filepath.Join(src, "sub"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(src, "sub", "file1"), []byte("data1"), 0640), IsNil)
+
+	dst := filepath.Join(s.dir, "snap1")
+	c.Assert(reflinkCopy(src, dst), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(src, "sub", "file1"), []byte("data2"), 0640), IsNil)
+
+	data, err := ioutil.ReadFile(filepath.Join(dst, "sub", "file1"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "data1")
+	info, err := os.Stat(filepath.Join(dst, "sub", "file1"))
+	c.Assert(err, IsNil)
+	c.Assert(info.Mode().Perm(), Equals, os.FileMode(0640))
+}
@@ -6,7 +6,9 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/rancher/convoy/objectstore"
@@ -20,10 +22,42 @@ type S3ObjectStoreDriver struct {
 	destURL string
 	path    string
 	service S3Service
+
+	// Block files would be put in this storage class if specified, e.g.
+	// GLACIER, while the configs stay in the default class for listing
+	blockStorageClass string
+	restoreDays       int64
+
+	// The block files and backup configs would be locked by Object Lock in
+	// objectLockMode for objectLockDays since written, if it's set
+	objectLockMode string
+	objectLockDays int64
 }
 
 const (
 	KIND = "s3"
+
+	ENDPOINT_OPT_PATH_STYLE  = "path-style"
+	ENDPOINT_OPT_SKIP_VERIFY = "skip-verify"
+
+	ENV_BLOCK_STORAGE_CLASS = "S3_BLOCK_STORAGE_CLASS"
+	ENV_RESTORE_DAYS        = "S3_RESTORE_DAYS"
+	ENV_MULTIPART_THRESHOLD = "S3_MULTIPART_THRESHOLD"
+	ENV_OBJECT_LOCK_MODE    = "S3_OBJECT_LOCK_MODE"
+	ENV_OBJECT_LOCK_DAYS    = "S3_OBJECT_LOCK_DAYS"
+	ENV_ACCESS_KEY_ID       = "S3_ACCESS_KEY_ID"
+	ENV_SECRET_ACCESS_KEY   = "S3_SECRET_ACCESS_KEY"
+	ENV_SESSION_TOKEN       = "S3_SESSION_TOKEN"
+
+	DEFAULT_RESTORE_DAYS = 1
+)
+
+var (
+	// The objects in these storage classes need to be restored before read
+	archiveStorageClasses = map[string]bool{
+		"GLACIER":      true,
+		"DEEP_ARCHIVE": true,
+	}
 )
 
 func init() {
@@ -47,29 +81,36 @@ func initFuncWithConnectionCheck(destURL, endpoint string, connectionTest func(d
 		return nil, err
 	}
 
-	if _, err := url.Parse(endpoint); err != nil {
+	if err := b.service.setEndpoint(endpoint); err != nil {
 		return nil, err
 	}
-	b.service.Endpoint = endpoint
 
 	if u.Scheme != KIND {
 		return nil, fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, KIND)
 	}
 
-	if u.User != nil {
-		b.service.Region = u.Host
-		b.service.Bucket = u.User.Username()
-	} else {
-		//We would depends on AWS_REGION environment variable
-		b.service.Bucket = u.Host
-	}
-	b.path = u.Path
-	if b.service.Bucket == "" || b.path == "" {
-		return nil, fmt.Errorf("Invalid URL. Must be either s3://bucket@region/path/, or s3://bucket/path")
+	b.service.Bucket, b.service.Region, b.path, err = parseURL(u)
+	if err != nil {
+		return nil, err
 	}
 
-	//Leading '/' can cause mystery problems for s3
-	b.path = strings.TrimLeft(b.path, "/")
+	b.blockStorageClass = os.Getenv(ENV_BLOCK_STORAGE_CLASS)
+	if value := os.Getenv(ENV_RESTORE_DAYS); value != "" {
+		days, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || days <= 0 {
+			return nil, fmt.Errorf("Invalid %v %v, must be a positive number", ENV_RESTORE_DAYS, value)
+		}
+		b.restoreDays = days
+	}
+	if b.service.MultipartThreshold, err = getMultipartThreshold(); err != nil {
+		return nil, err
+	}
+	if b.objectLockMode, b.objectLockDays, err = getObjectLock(); err != nil {
+		return nil, err
+	}
+	if err := b.service.setCredentials(); err != nil {
+		return nil, err
+	}
 
 	//Test connection
 	if err := connectionTest(b); err != nil {
@@ -86,6 +127,121 @@ func initFuncWithConnectionCheck(destURL, endpoint string, connectionTest func(d
 	return b, nil
 }
 
+// getMultipartThreshold returns 0 if ENV_MULTIPART_THRESHOLD is not set, so the
+// default one would be used
+func getMultipartThreshold() (int64, error) {
+	value := os.Getenv(ENV_MULTIPART_THRESHOLD)
+	if value == "" {
+		return 0, nil
+	}
+	threshold, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || threshold < MIN_PART_SIZE {
+		return 0, fmt.Errorf("Invalid %v %v, must be a number no less than %v", ENV_MULTIPART_THRESHOLD, value, MIN_PART_SIZE)
+	}
+	return threshold, nil
+}
+
+// getObjectLock returns the mode and days of Object Lock, the mode defaults
+// to COMPLIANCE if only the days are set
+func getObjectLock() (string, int64, error) {
+	mode := os.Getenv(ENV_OBJECT_LOCK_MODE)
+	value := os.Getenv(ENV_OBJECT_LOCK_DAYS)
+	if value == "" {
+		if mode != "" {
+			return "", 0, fmt.Errorf("%v is required by %v", ENV_OBJECT_LOCK_DAYS, ENV_OBJECT_LOCK_MODE)
+		}
+		return "", 0, nil
+	}
+	days, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || days <= 0 {
+		return "", 0, fmt.Errorf("Invalid %v %v, must be a positive number", ENV_OBJECT_LOCK_DAYS, value)
+	}
+	switch mode {
+	case "":
+		mode = OBJECT_LOCK_MODE_COMPLIANCE
+	case OBJECT_LOCK_MODE_COMPLIANCE, OBJECT_LOCK_MODE_GOVERNANCE:
+	default:
+		return "", 0, fmt.Errorf("Invalid %v %v, must be %v or %v", ENV_OBJECT_LOCK_MODE, mode,
+			OBJECT_LOCK_MODE_COMPLIANCE, OBJECT_LOCK_MODE_GOVERNANCE)
+	}
+	return mode, days, nil
+}
+
+// setCredentials sets the static credentials of the destination if they're
+// specified, otherwise AWS SDK would find them in the standard ways, e.g. the
+// AWS_* environment variables, the shared credentials file or the instance
+// profile
+func (s *S3Service) setCredentials() error {
+	accessKeyID := os.Getenv(ENV_ACCESS_KEY_ID)
+	secretAccessKey := os.Getenv(ENV_SECRET_ACCESS_KEY)
+	sessionToken := os.Getenv(ENV_SESSION_TOKEN)
+	if accessKeyID == "" && secretAccessKey == "" {
+		if sessionToken != "" {
+			return fmt.Errorf("%v and %v are required by %v", ENV_ACCESS_KEY_ID, ENV_SECRET_ACCESS_KEY, ENV_SESSION_TOKEN)
+		}
+		return nil
+	}
+	if accessKeyID == "" || secretAccessKey == "" {
+		return fmt.Errorf("Both %v and %v need to be set", ENV_ACCESS_KEY_ID, ENV_SECRET_ACCESS_KEY)
+	}
+	s.AccessKeyID = accessKeyID
+	s.SecretAccessKey = secretAccessKey
+	s.SessionToken = sessionToken
+	return nil
+}
+
+// parseURL returns the bucket, region and path in the URL
+func parseURL(u *url.URL) (string, string, string, error) {
+	var bucket, region string
+	if u.User != nil {
+		region = u.Host
+		bucket = u.User.Username()
+	} else {
+		//We would depends on AWS_REGION environment variable
+		bucket = u.Host
+	}
+	if bucket == "" || u.Path == "" {
+		return "", "", "", fmt.Errorf("Invalid URL. Must be either s3://bucket@region/path/, or s3://bucket/path")
+	}
+
+	//Leading '/' can cause mystery problems for s3
+	return bucket, region, strings.TrimLeft(u.Path, "/"), nil
+}
+
+// setEndpoint accepts endpoint options as query parameters, e.g.
+// https://minio.example.com:9000?path-style=false&skip-verify=true
+func (s *S3Service) setEndpoint(endpoint string) error {
+	if endpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+
+	q := u.Query()
+	if v := q.Get(ENDPOINT_OPT_PATH_STYLE); v != "" {
+		pathStyle, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("Invalid value %v for endpoint option %v", v, ENDPOINT_OPT_PATH_STYLE)
+		}
+		s.VirtualHostStyle = !pathStyle
+	}
+	if v := q.Get(ENDPOINT_OPT_SKIP_VERIFY); v != "" {
+		skipVerify, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("Invalid value %v for endpoint option %v", v, ENDPOINT_OPT_SKIP_VERIFY)
+		}
+		s.InsecureSkipVerify = skipVerify
+	}
+	q.Del(ENDPOINT_OPT_PATH_STYLE)
+	q.Del(ENDPOINT_OPT_SKIP_VERIFY)
+	u.RawQuery = q.Encode()
+
+	s.Endpoint = u.String()
+	return nil
+}
+
 func (s *S3ObjectStoreDriver) Kind() string {
 	return KIND
 }
@@ -167,9 +323,21 @@ func (s *S3ObjectStoreDriver) Read(src string) (io.ReadCloser, error) {
 	return rc, nil
 }
 
+// getPutOptions returns the storage class and Object Lock of the file
+func (s *S3ObjectStoreDriver) getPutOptions(filePath string) PutOptions {
+	opts := PutOptions{}
+	if strings.HasSuffix(filePath, objectstore.BLOCK_FILE_SUFFIX) {
+		opts.StorageClass = s.blockStorageClass
+	}
+	if s.objectLockMode != "" && objectstore.IsRetainedFile(filePath) {
+		opts.LockMode = s.objectLockMode
+		opts.RetainUntil = time.Now().Add(s.RetentionPeriod())
+	}
+	return opts
+}
+
 func (s *S3ObjectStoreDriver) Write(dst string, rs io.ReadSeeker) error {
-	path := s.updatePath(dst)
-	return s.service.PutObject(path, rs)
+	return s.service.PutObjectWithOptions(s.updatePath(dst), rs, s.getPutOptions(dst))
 }
 
 func (s *S3ObjectStoreDriver) Upload(src, dst string) error {
@@ -204,3 +372,71 @@ func (s *S3ObjectStoreDriver) Download(src, dst string) error {
 	}
 	return nil
 }
+
+func (s *S3ObjectStoreDriver) ArchiveStatus(filePath string) (string, error) {
+	head, err := s.service.HeadObject(s.updatePath(filePath))
+	if err != nil {
+		return "", err
+	}
+	if head.StorageClass == nil || !archiveStorageClasses[*head.StorageClass] {
+		return objectstore.ARCHIVE_STATUS_AVAILABLE, nil
+	}
+	if head.Restore == nil {
+		return objectstore.ARCHIVE_STATUS_ARCHIVED, nil
+	}
+	if strings.Contains(*head.Restore, `ongoing-request="true"`) {
+		return objectstore.ARCHIVE_STATUS_RETRIEVING, nil
+	}
+	return objectstore.ARCHIVE_STATUS_AVAILABLE, nil
+}
+
+func (s *S3ObjectStoreDriver) RetrieveFile(filePath string) error {
+	days := s.restoreDays
+	if days == 0 {
+		days = DEFAULT_RESTORE_DAYS
+	}
+	return s.service.RestoreObject(s.updatePath(filePath), days)
+}
+
+// CanCopyFrom returns true if the source is another AWS S3 bucket, which can
+// be in a different region
+func (s *S3ObjectStoreDriver) CanCopyFrom(srcURL, srcEndpoint string) bool {
+	u, err := url.Parse(srcURL)
+	if err != nil || u.Scheme != KIND {
+		return false
+	}
+	// Server side copy across endpoints is not possible
+	return srcEndpoint == "" && s.service.Endpoint == ""
+}
+
+func (s *S3ObjectStoreDriver) CopyFrom(srcURL, src, dst string) error {
+	u, err := url.Parse(srcURL)
+	if err != nil {
+		return err
+	}
+	bucket, _, path, err := parseURL(u)
+	if err != nil {
+		return err
+	}
+	return s.service.CopyObject(bucket, filepath.Join(path, src), s.updatePath(dst), s.getPutOptions(dst))
+}
+
+func (s *S3ObjectStoreDriver) RetentionPeriod() time.Duration {
+	return time.Duration(s.objectLockDays) * 24 * time.Hour
+}
+
+func (s *S3ObjectStoreDriver) RetainedUntil(filePath string) (time.Time, error) {
+	return s.service.GetObjectRetention(s.updatePath(filePath))
+}
+
+func (s *S3ObjectStoreDriver) ExtendRetention(filePath string, until time.Time) error {
+	path := s.updatePath(filePath)
+	current, err := s.service.GetObjectRetention(path)
+	if err != nil {
+		return err
+	}
+	if !current.Before(until) {
+		return nil
+	}
+	return s.service.PutObjectRetention(path, s.objectLockMode, until)
+}
@@ -1,19 +1,112 @@
 package s3
 
 import (
+	"bytes"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/base64"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
+const (
+	// Objects larger than DEFAULT_MULTIPART_THRESHOLD would be uploaded in
+	// parts, each part would be retried up to MAX_PART_RETRIES times
+	DEFAULT_MULTIPART_THRESHOLD = 64 << 20
+	MIN_PART_SIZE               = 5 << 20
+	DEFAULT_PART_SIZE           = 16 << 20
+	MAX_PARTS                   = 10000
+	MAX_PART_RETRIES            = 3
+
+	OBJECT_LOCK_MODE_GOVERNANCE = "GOVERNANCE"
+	OBJECT_LOCK_MODE_COMPLIANCE = "COMPLIANCE"
+
+	// The headers of Object Lock, which are not supported by the vendored
+	// AWS SDK yet
+	HEADER_OBJECT_LOCK_MODE         = "x-amz-object-lock-mode"
+	HEADER_OBJECT_LOCK_RETAIN_UNTIL = "x-amz-object-lock-retain-until-date"
+)
+
 type S3Service struct {
 	Region   string
 	Bucket   string
 	Endpoint string
+
+	// Custom endpoints use path-style addressing unless VirtualHostStyle
+	// is set
+	VirtualHostStyle   bool
+	InsecureSkipVerify bool
+
+	// Static credentials, otherwise AWS SDK would find the credentials.
+	// SessionToken is only for the temporary credentials, e.g. of STS
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// MaxRetries overrides the default retries of AWS SDK if set
+	MaxRetries int
+	// Requests to the same endpoint would be throttled if
+	// RequestsPerSecond is set
+	RequestsPerSecond int
+
+	// MultipartThreshold overrides DEFAULT_MULTIPART_THRESHOLD if set
+	MultipartThreshold int64
+}
+
+var (
+	rateLimiters     = map[string]*rateLimiter{}
+	rateLimiterMutex = &sync.Mutex{}
+
+	partRetryInterval = time.Second
+)
+
+// rateLimiter spaces out the requests evenly. It's shared by all the drivers
+// of the same endpoint, since the limits are usually per client.
+type rateLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func getRateLimiter(endpoint string, requestsPerSecond int) *rateLimiter {
+	rateLimiterMutex.Lock()
+	defer rateLimiterMutex.Unlock()
+	interval := time.Second / time.Duration(requestsPerSecond)
+	l, exists := rateLimiters[endpoint]
+	if !exists || l.interval != interval {
+		l = &rateLimiter{interval: interval}
+		rateLimiters[endpoint] = l
+	}
+	return l
+}
+
+// reserve returns how long the caller needs to wait before sending request
+func (l *rateLimiter) reserve() time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	return wait
+}
+
+func (l *rateLimiter) Wait() {
+	time.Sleep(l.reserve())
 }
 
 func (s *S3Service) New() (*s3.S3, error) {
@@ -23,9 +116,31 @@ func (s *S3Service) New() (*s3.S3, error) {
 	if s.Endpoint != "" {
 		config = config.
 			WithEndpoint(s.Endpoint).
-			WithS3ForcePathStyle(true)
+			WithS3ForcePathStyle(!s.VirtualHostStyle)
+	}
+	if s.InsecureSkipVerify {
+		config = config.WithHTTPClient(&http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		})
+	}
+	if s.AccessKeyID != "" {
+		config = config.WithCredentials(credentials.NewStaticCredentials(s.AccessKeyID, s.SecretAccessKey, s.SessionToken))
+	}
+	if s.MaxRetries != 0 {
+		config = config.WithMaxRetries(s.MaxRetries)
+	}
+	svc := s3.New(session.New(), config)
+	if s.RequestsPerSecond > 0 {
+		limiter := getRateLimiter(s.Endpoint, s.RequestsPerSecond)
+		// Retries would be throttled as well
+		svc.Handlers.Send.PushFront(func(r *request.Request) {
+			limiter.Wait()
+		})
 	}
-	return s3.New(session.New(), config), nil
+	return svc, nil
 }
 
 func (s *S3Service) Close() {
@@ -80,7 +195,72 @@ func (s *S3Service) HeadObject(key string) (*s3.HeadObjectOutput, error) {
 	return resp, nil
 }
 
+// PutOptions are the optional settings of the objects put or copied
+type PutOptions struct {
+	// The default storage class of the bucket would be used if it's empty
+	StorageClass string
+	// The object would be locked in LockMode until RetainUntil if LockMode
+	// is set, the bucket must have Object Lock enabled
+	LockMode    string
+	RetainUntil time.Time
+}
+
+// setObjectLock adds the headers of Object Lock to the request, with
+// Content-MD5 which is required by S3 for the objects locked
+func (opts PutOptions) setObjectLock(req *request.Request) {
+	if opts.LockMode == "" {
+		return
+	}
+	req.Handlers.Build.PushBack(func(r *request.Request) {
+		r.HTTPRequest.Header.Set(HEADER_OBJECT_LOCK_MODE, opts.LockMode)
+		r.HTTPRequest.Header.Set(HEADER_OBJECT_LOCK_RETAIN_UNTIL, opts.RetainUntil.UTC().Format(time.RFC3339))
+	})
+	req.Handlers.Build.PushBack(setContentMD5)
+}
+
+// setContentMD5 computes Content-MD5 of the body of the request
+func setContentMD5(r *request.Request) {
+	if r.Body == nil {
+		return
+	}
+	h := md5.New()
+	if _, err := io.Copy(h, r.Body); err != nil {
+		r.Error = err
+		return
+	}
+	if _, err := r.Body.Seek(0, io.SeekStart); err != nil {
+		r.Error = err
+		return
+	}
+	r.HTTPRequest.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(h.Sum(nil)))
+}
+
 func (s *S3Service) PutObject(key string, reader io.ReadSeeker) error {
+	return s.PutObjectWithOptions(key, reader, PutOptions{})
+}
+
+// PutObjectWithStorageClass uses the default storage class of the bucket if
+// storageClass is empty
+func (s *S3Service) PutObjectWithStorageClass(key string, reader io.ReadSeeker, storageClass string) error {
+	return s.PutObjectWithOptions(key, reader, PutOptions{StorageClass: storageClass})
+}
+
+func (s *S3Service) PutObjectWithOptions(key string, reader io.ReadSeeker, opts PutOptions) error {
+	size, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	threshold := s.MultipartThreshold
+	if threshold <= 0 {
+		threshold = DEFAULT_MULTIPART_THRESHOLD
+	}
+	if size > threshold {
+		return s.putMultipartObject(key, reader, size, opts)
+	}
+
 	svc, err := s.New()
 	if err != nil {
 		return err
@@ -92,9 +272,260 @@ func (s *S3Service) PutObject(key string, reader io.ReadSeeker) error {
 		Key:    aws.String(key),
 		Body:   reader,
 	}
+	if opts.StorageClass != "" {
+		params.StorageClass = aws.String(opts.StorageClass)
+	}
+
+	req, resp := svc.PutObjectRequest(params)
+	opts.setObjectLock(req)
+	if err := req.Send(); err != nil {
+		return parseAwsError(resp.String(), err)
+	}
+	return nil
+}
+
+func getPartSize(size int64) int64 {
+	partSize := int64(DEFAULT_PART_SIZE)
+	if threshold := (size + MAX_PARTS - 1) / MAX_PARTS; threshold > partSize {
+		partSize = threshold
+	}
+	return partSize
+}
+
+/*
+putMultipartObject uploads the object in parts, so only the failed part
+would be sent again rather than the whole object on the flaky links. The
+parts are read into memory one by one, and the upload would be aborted if
+any part failed after retries, so no incomplete parts would be left in the
+bucket.
+*/
+func (s *S3Service) putMultipartObject(key string, reader io.Reader, size int64, opts PutOptions) error {
+	svc, err := s.New()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	params := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	}
+	if opts.StorageClass != "" {
+		params.StorageClass = aws.String(opts.StorageClass)
+	}
+	req, resp := svc.CreateMultipartUploadRequest(params)
+	opts.setObjectLock(req)
+	if err := req.Send(); err != nil {
+		return parseAwsError(resp.String(), err)
+	}
+	uploadID := resp.UploadId
+
+	parts := []*s3.CompletedPart{}
+	partSize := getPartSize(size)
+	data := make([]byte, partSize)
+	for offset, partNumber := int64(0), int64(1); offset < size; partNumber++ {
+		n := partSize
+		if size-offset < n {
+			n = size - offset
+		}
+		if _, err := io.ReadFull(reader, data[:n]); err != nil {
+			s.abortMultipartUpload(svc, key, uploadID)
+			return err
+		}
+		etag, err := s.uploadPart(svc, key, uploadID, partNumber, data[:n], opts.LockMode != "")
+		if err != nil {
+			s.abortMultipartUpload(svc, key, uploadID)
+			return err
+		}
+		parts = append(parts, &s3.CompletedPart{
+			ETag:       etag,
+			PartNumber: aws.Int64(partNumber),
+		})
+		offset += n
+	}
 
-	resp, err := svc.PutObject(params)
+	completeResp, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(s.Bucket),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+		MultipartUpload: &s3.CompletedMultipartUpload{
+			Parts: parts,
+		},
+	})
+	if err != nil {
+		s.abortMultipartUpload(svc, key, uploadID)
+		return parseAwsError(completeResp.String(), err)
+	}
+	return nil
+}
+
+// uploadPart returns the ETag of the part, which would be retried up to
+// MAX_PART_RETRIES times if failed. The parts of the locked objects need
+// Content-MD5.
+func (s *S3Service) uploadPart(svc *s3.S3, key string, uploadID *string, partNumber int64, data []byte, locked bool) (*string, error) {
+	for retry := 0; ; retry++ {
+		req, resp := svc.UploadPartRequest(&s3.UploadPartInput{
+			Bucket:     aws.String(s.Bucket),
+			Key:        aws.String(key),
+			UploadId:   uploadID,
+			PartNumber: aws.Int64(partNumber),
+			Body:       bytes.NewReader(data),
+		})
+		if locked {
+			req.Handlers.Build.PushBack(setContentMD5)
+		}
+		err := req.Send()
+		if err == nil {
+			return resp.ETag, nil
+		}
+		err = parseAwsError(resp.String(), err)
+		if retry >= MAX_PART_RETRIES {
+			return nil, fmt.Errorf("Failed to upload part %v of %v after %v retries: %v", partNumber, key, retry, err)
+		}
+		log.Warnf("Failed to upload part %v of %v, would retry: %v", partNumber, key, err)
+		time.Sleep(partRetryInterval * time.Duration(retry+1))
+	}
+}
+
+func (s *S3Service) abortMultipartUpload(svc *s3.S3, key string, uploadID *string) {
+	if _, err := svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.Bucket),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	}); err != nil {
+		log.Warnf("Failed to abort multipart upload of %v: %v", key, err)
+	}
+}
+
+// CopyObject copies the object in the source bucket, which can be in another
+// region, to the key in the bucket, with the options of the copy
+func (s *S3Service) CopyObject(srcBucket, srcKey, key string, opts PutOptions) error {
+	svc, err := s.New()
 	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	source := &url.URL{Path: srcBucket + "/" + srcKey}
+	params := &s3.CopyObjectInput{
+		Bucket:     aws.String(s.Bucket),
+		Key:        aws.String(key),
+		CopySource: aws.String(source.EscapedPath()),
+	}
+	if opts.StorageClass != "" {
+		params.StorageClass = aws.String(opts.StorageClass)
+	}
+
+	req, resp := svc.CopyObjectRequest(params)
+	opts.setObjectLock(req)
+	if err := req.Send(); err != nil {
+		return parseAwsError(resp.String(), err)
+	}
+	return nil
+}
+
+// GetObjectRetention returns the time until which the object is locked, zero
+// time if it's not locked
+func (s *S3Service) GetObjectRetention(key string) (time.Time, error) {
+	svc, err := s.New()
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer s.Close()
+
+	req, resp := svc.HeadObjectRequest(&s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err := req.Send(); err != nil {
+		return time.Time{}, parseAwsError(resp.String(), err)
+	}
+	value := req.HTTPResponse.Header.Get(HEADER_OBJECT_LOCK_RETAIN_UNTIL)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	until, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid retention %v of %v: %v", value, key, err)
+	}
+	return until, nil
+}
+
+type objectLockRetention struct {
+	_ struct{} `type:"structure"`
+
+	Mode            *string    `type:"string"`
+	RetainUntilDate *time.Time `type:"timestamp" timestampFormat:"iso8601"`
+}
+
+type putObjectRetentionInput struct {
+	_ struct{} `type:"structure" payload:"Retention"`
+
+	Bucket    *string              `location:"uri" locationName:"Bucket" type:"string" required:"true"`
+	Key       *string              `location:"uri" locationName:"Key" type:"string" required:"true"`
+	Retention *objectLockRetention `locationName:"Retention" type:"structure" required:"true" xmlURI:"http://s3.amazonaws.com/doc/2006-03-01/"`
+}
+
+type putObjectRetentionOutput struct {
+	_ struct{} `type:"structure"`
+}
+
+func (s putObjectRetentionOutput) String() string {
+	return awsutil.Prettify(s)
+}
+
+// PutObjectRetention locks the object in the mode until the time. The
+// retention of the objects in COMPLIANCE mode can only be extended.
+func (s *S3Service) PutObjectRetention(key, mode string, until time.Time) error {
+	svc, err := s.New()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	op := &request.Operation{
+		Name:       "PutObjectRetention",
+		HTTPMethod: "PUT",
+		HTTPPath:   "/{Bucket}/{Key+}?retention",
+	}
+	output := &putObjectRetentionOutput{}
+	req := svc.NewRequest(op, &putObjectRetentionInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+		Retention: &objectLockRetention{
+			Mode:            aws.String(mode),
+			RetainUntilDate: aws.Time(until.UTC()),
+		},
+	}, output)
+	req.Handlers.Build.PushBack(setContentMD5)
+	if err := req.Send(); err != nil {
+		return parseAwsError(output.String(), err)
+	}
+	return nil
+}
+
+// RestoreObject starts to restore a temporary copy of the archived object,
+// which would be kept for the specified days
+func (s *S3Service) RestoreObject(key string, days int64) error {
+	svc, err := s.New()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	params := &s3.RestoreObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+		RestoreRequest: &s3.RestoreRequest{
+			Days: aws.Int64(days),
+		},
+	}
+	resp, err := svc.RestoreObject(params)
+	if err != nil {
+		// The restoration is already in progress
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "RestoreAlreadyInProgress" {
+			return nil
+		}
 		return parseAwsError(resp.String(), err)
 	}
 	return nil
@@ -1,8 +1,19 @@
 package s3
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/rancher/convoy/objectstore"
 	"gopkg.in/check.v1"
@@ -48,6 +59,36 @@ func (s *S3TestSuite) TestInitFuncBasicURL(c *check.C) {
 	c.Check(&expectedDriver, check.DeepEquals, driver)
 }
 
+func (s *S3TestSuite) TestInitFuncCredentials(c *check.C) {
+	os.Setenv(ENV_ACCESS_KEY_ID, "key")
+	os.Setenv(ENV_SECRET_ACCESS_KEY, "secret")
+	os.Setenv(ENV_SESSION_TOKEN, "token")
+	defer os.Unsetenv(ENV_ACCESS_KEY_ID)
+	defer os.Unsetenv(ENV_SECRET_ACCESS_KEY)
+	defer os.Unsetenv(ENV_SESSION_TOKEN)
+
+	_, driver, err := runInitFunc(c, "s3://test@us-east-1/path", "", false)
+	c.Assert(err, check.IsNil)
+	service := driver.(*S3ObjectStoreDriver).service
+	c.Check(service.AccessKeyID, check.Equals, "key")
+	c.Check(service.SecretAccessKey, check.Equals, "secret")
+	c.Check(service.SessionToken, check.Equals, "token")
+	svc, err := service.New()
+	c.Assert(err, check.IsNil)
+	value, err := svc.Config.Credentials.Get()
+	c.Assert(err, check.IsNil)
+	c.Check(value.AccessKeyID, check.Equals, "key")
+	c.Check(value.SecretAccessKey, check.Equals, "secret")
+	c.Check(value.SessionToken, check.Equals, "token")
+
+	os.Unsetenv(ENV_SECRET_ACCESS_KEY)
+	_, _, err = runInitFunc(c, "s3://test@us-east-1/path", "", false)
+	c.Check(err, check.ErrorMatches, "Both S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY need to be set")
+	os.Unsetenv(ENV_ACCESS_KEY_ID)
+	_, _, err = runInitFunc(c, "s3://test@us-east-1/path", "", false)
+	c.Check(err, check.ErrorMatches, "S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required by S3_SESSION_TOKEN")
+}
+
 func (s *S3TestSuite) TestInitFuncNoPath(c *check.C) {
 	attemptedConnection, driver, err := runInitFunc(c, "s3://test@us-east-1/", "", false)
 	expectedDriver := S3ObjectStoreDriver{
@@ -91,6 +132,29 @@ func (s *S3TestSuite) TestInitFuncCustomEndpoint(c *check.C) {
 	c.Check(&expectedDriver, check.DeepEquals, driver)
 }
 
+func (s *S3TestSuite) TestInitFuncCustomEndpointOptions(c *check.C) {
+	attemptedConnection, driver, err := runInitFunc(c, "s3://test@us-east-1/", "https://minio.example.com:9000?path-style=false&skip-verify=true", false)
+	expectedDriver := S3ObjectStoreDriver{
+		destURL: "s3://test@us-east-1/",
+		service: S3Service{
+			Bucket:             "test",
+			Endpoint:           "https://minio.example.com:9000",
+			Region:             "us-east-1",
+			VirtualHostStyle:   true,
+			InsecureSkipVerify: true,
+		},
+	}
+
+	c.Check(err, check.IsNil)
+	c.Check(attemptedConnection, check.Equals, true)
+	c.Check(&expectedDriver, check.DeepEquals, driver)
+}
+
+func (s *S3TestSuite) TestInitFuncBadEndpointOption(c *check.C) {
+	_, _, err := runInitFunc(c, "s3://test@us-east-1/", "https://minio.example.com:9000?skip-verify=maybe", false)
+	c.Check(err, check.NotNil)
+}
+
 func (s *S3TestSuite) TestInitFuncBasicURLConnectionError(c *check.C) {
 	_, _, err := runInitFunc(c, "s3://test@us-east-1/", "", true)
 	c.Check(err, check.Equals, errFakeConnection)
@@ -136,3 +200,357 @@ func (s *S3TestSuite) TestInitFuncTrimLeadingSlashes(c *check.C) {
 	c.Check(attemptedConnection, check.Equals, true)
 	c.Check(&expectedDriver, check.DeepEquals, driver)
 }
+
+// archiveServer fakes the S3 API of archived objects
+type archiveServer struct {
+	storageClasses map[string]string
+	restores       map[string]string
+}
+
+func (a *archiveServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	switch r.Method {
+	case "PUT":
+		a.storageClasses[key] = r.Header.Get("x-amz-storage-class")
+	case "HEAD":
+		storageClass, exists := a.storageClasses[key]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if storageClass != "" {
+			w.Header().Set("x-amz-storage-class", storageClass)
+		}
+		if restore, exists := a.restores[key]; exists {
+			w.Header().Set("x-amz-restore", restore)
+		}
+	case "POST":
+		if _, exists := r.URL.Query()["restore"]; !exists {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if !strings.Contains(string(body), "<Days>3</Days>") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		a.restores[key] = `ongoing-request="true"`
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (s *S3TestSuite) TestArchive(c *check.C) {
+	a := &archiveServer{
+		storageClasses: map[string]string{},
+		restores:       map[string]string{},
+	}
+	server := httptest.NewServer(a)
+	defer server.Close()
+
+	os.Setenv(ENV_BLOCK_STORAGE_CLASS, "DEEP_ARCHIVE")
+	os.Setenv(ENV_RESTORE_DAYS, "3")
+	defer os.Unsetenv(ENV_BLOCK_STORAGE_CLASS)
+	defer os.Unsetenv(ENV_RESTORE_DAYS)
+	_, driver, err := runInitFunc(c, "s3://bucket@us-east-1/path", server.URL, false)
+	c.Assert(err, check.IsNil)
+	d := driver.(*S3ObjectStoreDriver)
+	d.service.AccessKeyID = "key"
+	d.service.SecretAccessKey = "secret"
+
+	blkFile := "blocks/aa/bb/aabbcc.blk"
+	c.Assert(d.Write(blkFile, bytes.NewReader([]byte("block"))), check.IsNil)
+	c.Assert(d.Write("volume.cfg", bytes.NewReader([]byte("cfg"))), check.IsNil)
+	c.Check(a.storageClasses["path/"+blkFile], check.Equals, "DEEP_ARCHIVE")
+	c.Check(a.storageClasses["path/volume.cfg"], check.Equals, "")
+
+	status, err := d.ArchiveStatus("volume.cfg")
+	c.Assert(err, check.IsNil)
+	c.Check(status, check.Equals, objectstore.ARCHIVE_STATUS_AVAILABLE)
+
+	status, err = d.ArchiveStatus(blkFile)
+	c.Assert(err, check.IsNil)
+	c.Check(status, check.Equals, objectstore.ARCHIVE_STATUS_ARCHIVED)
+
+	c.Assert(d.RetrieveFile(blkFile), check.IsNil)
+	status, err = d.ArchiveStatus(blkFile)
+	c.Assert(err, check.IsNil)
+	c.Check(status, check.Equals, objectstore.ARCHIVE_STATUS_RETRIEVING)
+
+	a.restores["path/"+blkFile] = `ongoing-request="false", expiry-date="Fri, 23 Dec 2012 00:00:00 GMT"`
+	status, err = d.ArchiveStatus(blkFile)
+	c.Assert(err, check.IsNil)
+	c.Check(status, check.Equals, objectstore.ARCHIVE_STATUS_AVAILABLE)
+
+	_, err = d.ArchiveStatus("nonexist.blk")
+	c.Check(err, check.NotNil)
+
+	os.Setenv(ENV_RESTORE_DAYS, "0")
+	_, _, err = runInitFunc(c, "s3://bucket@us-east-1/path", server.URL, false)
+	c.Check(err, check.NotNil)
+}
+
+// copyServer fakes the S3 API of copying objects
+type copyServer struct {
+	sources        map[string]string
+	storageClasses map[string]string
+}
+
+func (s *copyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	if r.Method != "PUT" || r.Header.Get("x-amz-copy-source") == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.sources[key] = r.Header.Get("x-amz-copy-source")
+	s.storageClasses[key] = r.Header.Get("x-amz-storage-class")
+	w.Write([]byte("<CopyObjectResult><ETag>\"etag\"</ETag></CopyObjectResult>"))
+}
+
+func (s *S3TestSuite) TestCopyFrom(c *check.C) {
+	_, driver, err := runInitFunc(c, "s3://bucket@us-west-2/path", "", false)
+	c.Assert(err, check.IsNil)
+	d := driver.(*S3ObjectStoreDriver)
+	c.Check(d.CanCopyFrom("s3://src@us-east-1/backups", ""), check.Equals, true)
+	c.Check(d.CanCopyFrom("s3://src@us-east-1/backups", "http://example.com"), check.Equals, false)
+	c.Check(d.CanCopyFrom("vfs:///var/lib/backups", ""), check.Equals, false)
+
+	cs := &copyServer{
+		sources:        map[string]string{},
+		storageClasses: map[string]string{},
+	}
+	server := httptest.NewServer(cs)
+	defer server.Close()
+
+	os.Setenv(ENV_BLOCK_STORAGE_CLASS, "STANDARD_IA")
+	defer os.Unsetenv(ENV_BLOCK_STORAGE_CLASS)
+	_, driver, err = runInitFunc(c, "s3://bucket@us-west-2/path", server.URL, false)
+	c.Assert(err, check.IsNil)
+	d = driver.(*S3ObjectStoreDriver)
+	d.service.AccessKeyID = "key"
+	d.service.SecretAccessKey = "secret"
+	// Server side copy across endpoints is not possible
+	c.Check(d.CanCopyFrom("s3://src@us-east-1/backups", ""), check.Equals, false)
+
+	blkFile := "blocks/aa/bb/aabbcc.blk"
+	c.Assert(d.CopyFrom("s3://src@us-east-1/backups/", blkFile, blkFile), check.IsNil)
+	c.Assert(d.CopyFrom("s3://src@us-east-1/backups/", "volume.cfg", "volume.cfg"), check.IsNil)
+	c.Check(cs.sources["path/"+blkFile], check.Equals, "src/backups/"+blkFile)
+	c.Check(cs.storageClasses["path/"+blkFile], check.Equals, "STANDARD_IA")
+	c.Check(cs.storageClasses["path/volume.cfg"], check.Equals, "")
+}
+
+// multipartServer fakes the S3 API of multipart uploads, the first attempts
+// of the parts in failures would fail
+type multipartServer struct {
+	mutex          sync.Mutex
+	objects        map[string][]byte
+	parts          map[int][]byte
+	storageClasses map[string]string
+	failures       map[int]int
+	aborted        bool
+}
+
+func (m *multipartServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	query := r.URL.Query()
+	body, _ := ioutil.ReadAll(r.Body)
+	switch {
+	case r.Method == "POST" && query.Get("uploadId") == "" && hasQuery(query, "uploads"):
+		m.parts = map[int][]byte{}
+		m.storageClasses[key] = r.Header.Get("x-amz-storage-class")
+		w.Write([]byte("<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>" + key +
+			"</Key><UploadId>upload</UploadId></InitiateMultipartUploadResult>"))
+	case r.Method == "PUT" && query.Get("uploadId") == "upload":
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		if m.failures[partNumber] > 0 {
+			m.failures[partNumber]--
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("<Error><Code>BadDigest</Code><Message>Simulated failure</Message></Error>"))
+			return
+		}
+		m.parts[partNumber] = body
+		w.Header().Set("ETag", fmt.Sprintf("\"etag-%v\"", partNumber))
+	case r.Method == "POST" && query.Get("uploadId") == "upload":
+		data := []byte{}
+		for i := 1; i <= len(m.parts); i++ {
+			if !strings.Contains(string(body), fmt.Sprintf("etag-%v", i)) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			data = append(data, m.parts[i]...)
+		}
+		m.objects[key] = data
+		w.Write([]byte("<CompleteMultipartUploadResult><Key>" + key + "</Key></CompleteMultipartUploadResult>"))
+	case r.Method == "DELETE" && query.Get("uploadId") == "upload":
+		m.aborted = true
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "PUT":
+		m.objects[key] = body
+		m.storageClasses[key] = r.Header.Get("x-amz-storage-class")
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func hasQuery(query url.Values, key string) bool {
+	_, exists := query[key]
+	return exists
+}
+
+func (s *S3TestSuite) TestMultipartUpload(c *check.C) {
+	defer func(interval time.Duration) { partRetryInterval = interval }(partRetryInterval)
+	partRetryInterval = 0
+
+	m := &multipartServer{
+		objects:        map[string][]byte{},
+		storageClasses: map[string]string{},
+		failures:       map[int]int{2: MAX_PART_RETRIES},
+	}
+	server := httptest.NewServer(m)
+	defer server.Close()
+
+	os.Setenv(ENV_BLOCK_STORAGE_CLASS, "STANDARD_IA")
+	os.Setenv(ENV_MULTIPART_THRESHOLD, strconv.Itoa(MIN_PART_SIZE))
+	defer os.Unsetenv(ENV_BLOCK_STORAGE_CLASS)
+	defer os.Unsetenv(ENV_MULTIPART_THRESHOLD)
+	_, driver, err := runInitFunc(c, "s3://bucket@us-east-1/path", server.URL, false)
+	c.Assert(err, check.IsNil)
+	d := driver.(*S3ObjectStoreDriver)
+	d.service.AccessKeyID = "key"
+	d.service.SecretAccessKey = "secret"
+	c.Check(d.service.MultipartThreshold, check.Equals, int64(MIN_PART_SIZE))
+
+	// The small objects are put in one request
+	c.Assert(d.Write("volume.cfg", bytes.NewReader([]byte("cfg"))), check.IsNil)
+	c.Check(string(m.objects["path/volume.cfg"]), check.Equals, "cfg")
+
+	// The failed part would be retried
+	data := make([]byte, DEFAULT_PART_SIZE+MIN_PART_SIZE)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	blkFile := "blocks/aa/bb/aabbcc.blk"
+	c.Assert(d.Write(blkFile, bytes.NewReader(data)), check.IsNil)
+	c.Check(m.parts, check.HasLen, 2)
+	c.Check(bytes.Equal(m.objects["path/"+blkFile], data), check.Equals, true)
+	c.Check(m.storageClasses["path/"+blkFile], check.Equals, "STANDARD_IA")
+	c.Check(m.aborted, check.Equals, false)
+
+	// The upload would be aborted if the part still failed after retries
+	m.failures[1] = MAX_PART_RETRIES + 1
+	err = d.Write("image.img", bytes.NewReader(data))
+	c.Check(err, check.ErrorMatches, "(?s)Failed to upload part 1 of path/image.img after 3 retries: .*BadDigest.*")
+	c.Check(m.aborted, check.Equals, true)
+	_, exists := m.objects["path/image.img"]
+	c.Check(exists, check.Equals, false)
+
+	c.Check(getPartSize(MAX_PARTS*DEFAULT_PART_SIZE), check.Equals, int64(DEFAULT_PART_SIZE))
+	c.Check(getPartSize(MAX_PARTS*DEFAULT_PART_SIZE+1), check.Equals, int64(DEFAULT_PART_SIZE+1))
+
+	os.Setenv(ENV_MULTIPART_THRESHOLD, "1024")
+	_, _, err = runInitFunc(c, "s3://bucket@us-east-1/path", server.URL, false)
+	c.Check(err, check.NotNil)
+}
+
+// objectLockServer fakes the S3 API of Object Lock
+type objectLockServer struct {
+	modes    map[string]string
+	retained map[string]string
+	md5s     map[string]string
+}
+
+func (o *objectLockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	switch {
+	case r.Method == "PUT" && hasQuery(r.URL.Query(), "retention"):
+		body, _ := ioutil.ReadAll(r.Body)
+		start := strings.Index(string(body), "<RetainUntilDate>")
+		end := strings.Index(string(body), "</RetainUntilDate>")
+		if start < 0 || end < 0 || !strings.Contains(string(body), "<Mode>COMPLIANCE</Mode>") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		o.retained[key] = string(body[start+len("<RetainUntilDate>") : end])
+		o.md5s[key] = r.Header.Get("Content-MD5")
+	case r.Method == "PUT":
+		o.modes[key] = r.Header.Get(HEADER_OBJECT_LOCK_MODE)
+		o.retained[key] = r.Header.Get(HEADER_OBJECT_LOCK_RETAIN_UNTIL)
+		o.md5s[key] = r.Header.Get("Content-MD5")
+	case r.Method == "HEAD":
+		until, exists := o.retained[key]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if until != "" {
+			w.Header().Set(HEADER_OBJECT_LOCK_RETAIN_UNTIL, until)
+		}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (s *S3TestSuite) TestObjectLock(c *check.C) {
+	o := &objectLockServer{
+		modes:    map[string]string{},
+		retained: map[string]string{},
+		md5s:     map[string]string{},
+	}
+	server := httptest.NewServer(o)
+	defer server.Close()
+
+	os.Setenv(ENV_OBJECT_LOCK_DAYS, "7")
+	defer os.Unsetenv(ENV_OBJECT_LOCK_DAYS)
+	_, driver, err := runInitFunc(c, "s3://bucket@us-east-1/path", server.URL, false)
+	c.Assert(err, check.IsNil)
+	d := driver.(*S3ObjectStoreDriver)
+	d.service.AccessKeyID = "key"
+	d.service.SecretAccessKey = "secret"
+	c.Check(d.objectLockMode, check.Equals, OBJECT_LOCK_MODE_COMPLIANCE)
+	c.Check(d.RetentionPeriod(), check.Equals, 7*24*time.Hour)
+
+	// Only the blocks and backup configs are locked
+	blkFile := "blocks/aa/bb/aabbcc.blk"
+	backupFile := "volumes/te/st/test/backups/backup_aaaa.cfg"
+	c.Assert(d.Write(blkFile, bytes.NewReader([]byte("block"))), check.IsNil)
+	c.Assert(d.Write(backupFile, bytes.NewReader([]byte("backup"))), check.IsNil)
+	c.Assert(d.Write("volume.cfg", bytes.NewReader([]byte("cfg"))), check.IsNil)
+	c.Check(o.modes["path/"+blkFile], check.Equals, OBJECT_LOCK_MODE_COMPLIANCE)
+	c.Check(o.modes["path/"+backupFile], check.Equals, OBJECT_LOCK_MODE_COMPLIANCE)
+	c.Check(o.md5s["path/"+blkFile], check.Equals, "FFEfL1VkZQ0SnKfKvDMyeA==")
+	c.Check(o.modes["path/volume.cfg"], check.Equals, "")
+	c.Check(o.md5s["path/volume.cfg"], check.Equals, "")
+
+	until, err := d.RetainedUntil(blkFile)
+	c.Assert(err, check.IsNil)
+	c.Check(until.After(time.Now().Add(6*24*time.Hour)), check.Equals, true)
+	unlocked, err := d.RetainedUntil("volume.cfg")
+	c.Assert(err, check.IsNil)
+	c.Check(unlocked.IsZero(), check.Equals, true)
+
+	// The retention can only be extended
+	c.Assert(d.ExtendRetention(blkFile, time.Now().Add(time.Hour)), check.IsNil)
+	c.Check(o.retained["path/"+blkFile], check.Equals, until.UTC().Format(time.RFC3339))
+	extended := time.Now().Add(30 * 24 * time.Hour).UTC().Truncate(time.Second)
+	c.Assert(d.ExtendRetention(blkFile, extended), check.IsNil)
+	c.Check(o.retained["path/"+blkFile], check.Equals, extended.Format(time.RFC3339))
+	c.Check(o.md5s["path/"+blkFile], check.Not(check.Equals), "")
+	until, err = d.RetainedUntil(blkFile)
+	c.Assert(err, check.IsNil)
+	c.Check(until.Equal(extended), check.Equals, true)
+
+	os.Setenv(ENV_OBJECT_LOCK_MODE, "WORM")
+	_, _, err = runInitFunc(c, "s3://bucket@us-east-1/path", server.URL, false)
+	c.Check(err, check.ErrorMatches, "Invalid S3_OBJECT_LOCK_MODE WORM.*")
+	os.Setenv(ENV_OBJECT_LOCK_MODE, OBJECT_LOCK_MODE_GOVERNANCE)
+	os.Setenv(ENV_OBJECT_LOCK_DAYS, "0")
+	defer os.Unsetenv(ENV_OBJECT_LOCK_MODE)
+	_, _, err = runInitFunc(c, "s3://bucket@us-east-1/path", server.URL, false)
+	c.Check(err, check.ErrorMatches, "Invalid S3_OBJECT_LOCK_DAYS 0.*")
+	os.Unsetenv(ENV_OBJECT_LOCK_DAYS)
+	_, _, err = runInitFunc(c, "s3://bucket@us-east-1/path", server.URL, false)
+	c.Check(err, check.ErrorMatches, "S3_OBJECT_LOCK_DAYS is required by S3_OBJECT_LOCK_MODE")
+}
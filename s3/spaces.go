@@ -0,0 +1,103 @@
+package s3
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/convoy/objectstore"
+)
+
+// SpacesObjectStoreDriver is the driver for DigitalOcean Spaces, which
+// shares the implementation with S3 but with its own endpoints and keys
+type SpacesObjectStoreDriver struct {
+	S3ObjectStoreDriver
+}
+
+const (
+	KIND_SPACES = "spaces"
+
+	SPACES_ENDPOINT_FORMAT = "https://%s.digitaloceanspaces.com"
+	// Spaces doesn't use the region in signature, but AWS SDK requires one
+	SPACES_SIGNING_REGION = "us-east-1"
+
+	ENV_SPACES_ACCESS_KEY_ID        = "SPACES_ACCESS_KEY_ID"
+	ENV_SPACES_SECRET_ACCESS_KEY    = "SPACES_SECRET_ACCESS_KEY"
+	ENV_SPACES_REQUESTS_PER_SECOND  = "SPACES_REQUESTS_PER_SECOND"
+	DEFAULT_SPACES_REQUESTS_PER_SEC = 200
+	// Spaces responses 503 SlowDown when rate limited, retry more than
+	// default with backoff
+	DEFAULT_SPACES_MAX_RETRIES = 10
+)
+
+func init() {
+	if err := objectstore.RegisterDriver(KIND_SPACES, initSpacesFunc); err != nil {
+		panic(err)
+	}
+}
+
+func initSpacesFunc(destURL, endpoint string) (objectstore.ObjectStoreDriver, error) {
+	return initSpacesFuncWithConnectionCheck(destURL, func(d objectstore.ObjectStoreDriver) error {
+		_, err := d.List("")
+		return err
+	})
+}
+
+func initSpacesFuncWithConnectionCheck(destURL string, connectionTest func(d objectstore.ObjectStoreDriver) error) (objectstore.ObjectStoreDriver, error) {
+	b := &SpacesObjectStoreDriver{}
+
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != KIND_SPACES {
+		return nil, fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, KIND_SPACES)
+	}
+
+	if u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return nil, fmt.Errorf("Invalid URL. Must be spaces://bucket@region/path/")
+	}
+	region := u.Host
+	b.service.Bucket = u.User.Username()
+	b.service.Region = SPACES_SIGNING_REGION
+	b.service.Endpoint = fmt.Sprintf(SPACES_ENDPOINT_FORMAT, region)
+	b.service.VirtualHostStyle = true
+
+	b.service.AccessKeyID = os.Getenv(ENV_SPACES_ACCESS_KEY_ID)
+	b.service.SecretAccessKey = os.Getenv(ENV_SPACES_SECRET_ACCESS_KEY)
+	if b.service.AccessKeyID == "" || b.service.SecretAccessKey == "" {
+		return nil, fmt.Errorf("Cannot find Spaces keys, %v and %v need to be set",
+			ENV_SPACES_ACCESS_KEY_ID, ENV_SPACES_SECRET_ACCESS_KEY)
+	}
+
+	b.service.MaxRetries = DEFAULT_SPACES_MAX_RETRIES
+	b.service.RequestsPerSecond = DEFAULT_SPACES_REQUESTS_PER_SEC
+	if v := os.Getenv(ENV_SPACES_REQUESTS_PER_SECOND); v != "" {
+		rps, err := strconv.Atoi(v)
+		if err != nil || rps < 0 {
+			return nil, fmt.Errorf("Invalid value %v for %v", v, ENV_SPACES_REQUESTS_PER_SECOND)
+		}
+		b.service.RequestsPerSecond = rps
+	}
+	if b.service.MultipartThreshold, err = getMultipartThreshold(); err != nil {
+		return nil, err
+	}
+
+	//Leading '/' can cause mystery problems for s3
+	b.path = strings.TrimLeft(u.Path, "/")
+
+	if err := connectionTest(b); err != nil {
+		return nil, err
+	}
+
+	b.destURL = KIND_SPACES + "://" + b.service.Bucket + "@" + region + "/" + b.path
+	log.Debugf("Loaded driver for %v", b.destURL)
+	return b, nil
+}
+
+func (s *SpacesObjectStoreDriver) Kind() string {
+	return KIND_SPACES
+}
@@ -0,0 +1,75 @@
+package s3
+
+import (
+	"os"
+	"time"
+
+	"github.com/rancher/convoy/objectstore"
+	"gopkg.in/check.v1"
+)
+
+func runSpacesInitFunc(destURL string) (objectstore.ObjectStoreDriver, error) {
+	return initSpacesFuncWithConnectionCheck(destURL, func(d objectstore.ObjectStoreDriver) error {
+		return nil
+	})
+}
+
+func (s *S3TestSuite) TestSpacesInitFunc(c *check.C) {
+	os.Setenv(ENV_SPACES_ACCESS_KEY_ID, "key")
+	os.Setenv(ENV_SPACES_SECRET_ACCESS_KEY, "secret")
+	os.Unsetenv(ENV_SPACES_REQUESTS_PER_SECOND)
+
+	driver, err := runSpacesInitFunc("spaces://test@nyc3//path")
+	expectedDriver := &SpacesObjectStoreDriver{
+		S3ObjectStoreDriver{
+			destURL: "spaces://test@nyc3/path",
+			path:    "path",
+			service: S3Service{
+				Bucket:            "test",
+				Region:            SPACES_SIGNING_REGION,
+				Endpoint:          "https://nyc3.digitaloceanspaces.com",
+				VirtualHostStyle:  true,
+				AccessKeyID:       "key",
+				SecretAccessKey:   "secret",
+				MaxRetries:        DEFAULT_SPACES_MAX_RETRIES,
+				RequestsPerSecond: DEFAULT_SPACES_REQUESTS_PER_SEC,
+			},
+		},
+	}
+	c.Assert(err, check.IsNil)
+	c.Check(driver, check.DeepEquals, expectedDriver)
+	c.Check(driver.Kind(), check.Equals, KIND_SPACES)
+
+	os.Setenv(ENV_SPACES_REQUESTS_PER_SECOND, "50")
+	driver, err = runSpacesInitFunc("spaces://test@nyc3/")
+	c.Assert(err, check.IsNil)
+	c.Check(driver.(*SpacesObjectStoreDriver).service.RequestsPerSecond, check.Equals, 50)
+
+	os.Setenv(ENV_SPACES_REQUESTS_PER_SECOND, "many")
+	_, err = runSpacesInitFunc("spaces://test@nyc3/")
+	c.Check(err, check.NotNil)
+}
+
+func (s *S3TestSuite) TestSpacesInitFuncBadConfig(c *check.C) {
+	os.Setenv(ENV_SPACES_ACCESS_KEY_ID, "key")
+	os.Setenv(ENV_SPACES_SECRET_ACCESS_KEY, "secret")
+	os.Unsetenv(ENV_SPACES_REQUESTS_PER_SECOND)
+
+	_, err := runSpacesInitFunc("spaces://test/path")
+	c.Check(err, check.NotNil)
+
+	os.Unsetenv(ENV_SPACES_SECRET_ACCESS_KEY)
+	_, err = runSpacesInitFunc("spaces://test@nyc3/path")
+	c.Check(err, check.NotNil)
+}
+
+func (s *S3TestSuite) TestRateLimiter(c *check.C) {
+	l := getRateLimiter("https://test.example.com", 10)
+	c.Check(getRateLimiter("https://test.example.com", 10), check.Equals, l)
+
+	c.Check(l.reserve(), check.Equals, time.Duration(0))
+	wait := l.reserve()
+	c.Check(wait > 90*time.Millisecond && wait <= 100*time.Millisecond, check.Equals, true)
+	wait = l.reserve()
+	c.Check(wait > 190*time.Millisecond && wait <= 200*time.Millisecond, check.Equals, true)
+}
@@ -0,0 +1,39 @@
+package sheepdog
+
+import (
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct{}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) TestTarget(c *C) {
+	dev := &Device{
+		Address: "10.0.0.1",
+		Port:    "7000",
+	}
+	c.Assert(dev.target("vol1", ""), Equals, "sheepdog:10.0.0.1:7000:vol1")
+	c.Assert(dev.target("vol1", "snap1"), Equals, "sheepdog:10.0.0.1:7000:vol1:snap1")
+}
+
+func (s *TestSuite) TestVerifyConfig(c *C) {
+	_, err := verifyConfig(map[string]string{
+		SHEEPDOG_PORT: "70000",
+	})
+	c.Assert(err, ErrorMatches, "Invalid Sheepdog port 70000")
+
+	_, err = verifyConfig(map[string]string{
+		SHEEPDOG_DEFAULT_VOLUME_SIZE: "1000",
+	})
+	c.Assert(err, ErrorMatches, "Default volume size must be multiple of .*")
+
+	_, err = verifyConfig(map[string]string{
+		SHEEPDOG_DEFAULT_FS_TYPE: "btrfs",
+	})
+	c.Assert(err, ErrorMatches, "Unsupported filesystem type specified")
+}
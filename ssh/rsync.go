@@ -0,0 +1,203 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+)
+
+// RsyncObjectStoreDriver works like SSHObjectStoreDriver, except the block
+// files are staged and pushed to the server by rsync in batches, rather than
+// one ssh session per block
+type RsyncObjectStoreDriver struct {
+	SSHObjectStoreDriver
+
+	rsyncBinary string
+	batchSize   int64
+
+	mutex      *sync.Mutex
+	staged     map[string][]byte
+	stagedSize int64
+}
+
+const (
+	KIND_RSYNC = "rsync+ssh"
+
+	RSYNC_BINARY = "rsync"
+
+	ENV_RSYNC_BATCH_SIZE     = "RSYNC_BATCH_SIZE"
+	DEFAULT_RSYNC_BATCH_SIZE = 64 << 20
+)
+
+func init() {
+	if err := objectstore.RegisterDriver(KIND_RSYNC, initRsyncFunc); err != nil {
+		panic(err)
+	}
+}
+
+func initRsyncFunc(destURL, endpoint string) (objectstore.ObjectStoreDriver, error) {
+	return initRsyncFuncWithService(destURL, SSHService{}, RSYNC_BINARY)
+}
+
+func initRsyncFuncWithService(destURL string, service SSHService, rsyncBinary string) (objectstore.ObjectStoreDriver, error) {
+	batchSize := int64(DEFAULT_RSYNC_BATCH_SIZE)
+	if v := os.Getenv(ENV_RSYNC_BATCH_SIZE); v != "" {
+		size, err := util.ParseSize(v)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("Invalid value %v for %v", v, ENV_RSYNC_BATCH_SIZE)
+		}
+		batchSize = size
+	}
+
+	sshDriver, err := loadDriver(KIND_RSYNC, destURL, service)
+	if err != nil {
+		return nil, err
+	}
+	return &RsyncObjectStoreDriver{
+		SSHObjectStoreDriver: *sshDriver,
+		rsyncBinary:          rsyncBinary,
+		batchSize:            batchSize,
+		mutex:                &sync.Mutex{},
+		staged:               make(map[string][]byte),
+	}, nil
+}
+
+func (r *RsyncObjectStoreDriver) Kind() string {
+	return KIND_RSYNC
+}
+
+// rsh returns the ssh command line used by rsync
+func (r *RsyncObjectStoreDriver) rsh() string {
+	words := []string{shellQuote(r.service.binary())}
+	for _, opt := range r.service.options() {
+		words = append(words, shellQuote(opt))
+	}
+	return strings.Join(words, " ")
+}
+
+// flush pushes all the staged files to the server in one rsync run
+func (r *RsyncObjectStoreDriver) flush() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.staged) == 0 {
+		return nil
+	}
+	dir, err := ioutil.TempDir("", "convoy-rsync-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	for name, data := range r.staged {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(file, data, 0600); err != nil {
+			return err
+		}
+	}
+
+	// --protect-args prevents the remote shell from interpreting the path
+	args := []string{"-r", "--protect-args", "--rsh", r.rsh(),
+		dir + "/", r.service.target() + ":" + r.path + "/"}
+	if output, err := exec.Command(r.rsyncBinary, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("Failed to rsync %v files to %v: %v, %v", len(r.staged), r.destURL,
+			err, strings.TrimSpace(string(output)))
+	}
+	log.Debugf("Pushed %v files with %v bytes to %v", len(r.staged), r.stagedSize, r.destURL)
+
+	r.staged = make(map[string][]byte)
+	r.stagedSize = 0
+	return nil
+}
+
+func (r *RsyncObjectStoreDriver) getStaged(filePath string) ([]byte, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	data, exists := r.staged[filepath.Clean(filePath)]
+	return data, exists
+}
+
+func (r *RsyncObjectStoreDriver) FileExists(filePath string) bool {
+	return r.FileSize(filePath) >= 0
+}
+
+func (r *RsyncObjectStoreDriver) FileSize(filePath string) int64 {
+	if data, exists := r.getStaged(filePath); exists {
+		return int64(len(data))
+	}
+	return r.SSHObjectStoreDriver.FileSize(filePath)
+}
+
+func (r *RsyncObjectStoreDriver) Read(src string) (io.ReadCloser, error) {
+	if data, exists := r.getStaged(src); exists {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	return r.SSHObjectStoreDriver.Read(src)
+}
+
+// Write stages the block files. Other files, e.g. configs, would only be
+// written after all the staged blocks have been pushed, so a config would
+// never refer to blocks not on the server yet.
+func (r *RsyncObjectStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	if filepath.Ext(dst) != objectstore.BLOCK_FILE_SUFFIX {
+		if err := r.flush(); err != nil {
+			return err
+		}
+		return r.SSHObjectStoreDriver.Write(dst, rs)
+	}
+
+	data, err := ioutil.ReadAll(rs)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	name := filepath.Clean(dst)
+	r.stagedSize += int64(len(data)) - int64(len(r.staged[name]))
+	r.staged[name] = data
+	full := r.stagedSize >= r.batchSize
+	r.mutex.Unlock()
+
+	if full {
+		return r.flush()
+	}
+	return nil
+}
+
+func (r *RsyncObjectStoreDriver) Remove(names ...string) error {
+	if err := r.flush(); err != nil {
+		return err
+	}
+	return r.SSHObjectStoreDriver.Remove(names...)
+}
+
+func (r *RsyncObjectStoreDriver) List(path string) ([]string, error) {
+	if err := r.flush(); err != nil {
+		return nil, err
+	}
+	return r.SSHObjectStoreDriver.List(path)
+}
+
+func (r *RsyncObjectStoreDriver) Upload(src, dst string) error {
+	if err := r.flush(); err != nil {
+		return err
+	}
+	return r.SSHObjectStoreDriver.Upload(src, dst)
+}
+
+func (r *RsyncObjectStoreDriver) Download(src, dst string) error {
+	if err := r.flush(); err != nil {
+		return err
+	}
+	return r.SSHObjectStoreDriver.Download(src, dst)
+}
@@ -0,0 +1,89 @@
+package ssh
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rancher/convoy/objectstore"
+	"gopkg.in/check.v1"
+)
+
+// fakeRsync copies the source directory to the path of the destination
+// locally, and logs the arguments
+const fakeRsync = `#!/bin/sh
+for last; do :; done
+eval src=\${$(($# - 1))}
+echo "$@" >> "$(dirname "$0")/rsync.log"
+cp -r "$src." "${last#*:}"
+`
+
+func (s *SSHTestSuite) newRsyncDriver(c *check.C) *RsyncObjectStoreDriver {
+	binary := filepath.Join(s.dir, "fake-rsync")
+	c.Assert(ioutil.WriteFile(binary, []byte(fakeRsync), 0755), check.IsNil)
+	driver, err := initRsyncFuncWithService("rsync+ssh://convoy@localhost:2222"+s.dir+"/backups", s.service, binary)
+	c.Assert(err, check.IsNil)
+	return driver.(*RsyncObjectStoreDriver)
+}
+
+func (s *SSHTestSuite) rsyncCalls(c *check.C) []string {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, "rsync.log"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	c.Assert(err, check.IsNil)
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func (s *SSHTestSuite) TestRsyncBatch(c *check.C) {
+	os.Setenv(ENV_RSYNC_BATCH_SIZE, "10")
+	defer os.Unsetenv(ENV_RSYNC_BATCH_SIZE)
+	driver := s.newRsyncDriver(c)
+	c.Check(driver.Kind(), check.Equals, KIND_RSYNC)
+	c.Check(driver.GetURL(), check.Equals, "rsync+ssh://convoy@localhost:2222"+s.dir+"/backups")
+	c.Check(driver.batchSize, check.Equals, int64(10))
+
+	block1 := "convoy-objectstore/volumes/vol/blocks/aa/bb/aabb1" + objectstore.BLOCK_FILE_SUFFIX
+	block2 := "convoy-objectstore/volumes/vol/blocks/aa/bb/aabb2" + objectstore.BLOCK_FILE_SUFFIX
+	c.Assert(driver.Write(block1, bytes.NewReader([]byte("12345"))), check.IsNil)
+	c.Check(s.rsyncCalls(c), check.HasLen, 0)
+	// Staged blocks are visible before pushed
+	c.Check(driver.FileSize(block1), check.Equals, int64(5))
+	rc, err := driver.Read(block1)
+	c.Assert(err, check.IsNil)
+	data, err := ioutil.ReadAll(rc)
+	c.Assert(err, check.IsNil)
+	c.Check(string(data), check.Equals, "12345")
+
+	c.Assert(driver.Write(block2, bytes.NewReader([]byte("67890"))), check.IsNil)
+	calls := s.rsyncCalls(c)
+	c.Assert(calls, check.HasLen, 1)
+	c.Check(calls[0], check.Matches, "-r --protect-args --rsh '.*fake-ssh' '-o' 'BatchMode=yes' '-p' '2222' .* convoy@localhost:.*/backups/")
+	c.Check(driver.staged, check.HasLen, 0)
+
+	data, err = ioutil.ReadFile(filepath.Join(s.dir, "backups", block2))
+	c.Assert(err, check.IsNil)
+	c.Check(string(data), check.Equals, "67890")
+}
+
+func (s *SSHTestSuite) TestRsyncFlushBeforeConfig(c *check.C) {
+	driver := s.newRsyncDriver(c)
+	c.Check(driver.batchSize, check.Equals, int64(DEFAULT_RSYNC_BATCH_SIZE))
+
+	block := "convoy-objectstore/volumes/vol/blocks/aa/bb/aabb1" + objectstore.BLOCK_FILE_SUFFIX
+	c.Assert(driver.Write(block, bytes.NewReader([]byte("block"))), check.IsNil)
+	c.Check(s.rsyncCalls(c), check.HasLen, 0)
+
+	cfg := "convoy-objectstore/volumes/vol/backups/backup_1.cfg"
+	c.Assert(driver.Write(cfg, bytes.NewReader([]byte("{}"))), check.IsNil)
+	c.Check(s.rsyncCalls(c), check.HasLen, 1)
+	c.Check(driver.SSHObjectStoreDriver.FileSize(block), check.Equals, int64(5))
+	c.Check(driver.SSHObjectStoreDriver.FileSize(cfg), check.Equals, int64(2))
+
+	os.Setenv(ENV_RSYNC_BATCH_SIZE, "zero")
+	defer os.Unsetenv(ENV_RSYNC_BATCH_SIZE)
+	_, err := initRsyncFuncWithService("rsync+ssh://localhost"+s.dir+"/backups", s.service, "rsync")
+	c.Check(err, check.NotNil)
+}
@@ -0,0 +1,183 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/objectstore"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "ssh"})
+)
+
+type SSHObjectStoreDriver struct {
+	destURL string
+	path    string
+	service SSHService
+}
+
+const (
+	KIND = "ssh"
+
+	ENV_KEY_FILE = "SSH_KEY_FILE"
+
+	MAX_CLEANUP_LEVEL = 10
+)
+
+func init() {
+	if err := objectstore.RegisterDriver(KIND, initFunc); err != nil {
+		panic(err)
+	}
+}
+
+func initFunc(destURL, endpoint string) (objectstore.ObjectStoreDriver, error) {
+	return initFuncWithService(destURL, SSHService{})
+}
+
+func initFuncWithService(destURL string, service SSHService) (objectstore.ObjectStoreDriver, error) {
+	return loadDriver(KIND, destURL, service)
+}
+
+func loadDriver(kind, destURL string, service SSHService) (*SSHObjectStoreDriver, error) {
+	b := &SSHObjectStoreDriver{
+		service: service,
+	}
+
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != kind {
+		return nil, fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, kind)
+	}
+
+	b.service.Host = u.Host
+	if host, port, err := splitHostPort(u.Host); err == nil {
+		b.service.Host = host
+		b.service.Port = port
+	}
+	if b.service.Host == "" {
+		return nil, fmt.Errorf("Invalid URL. Must be %v://[user@]host[:port]/path/", kind)
+	}
+	if u.User != nil {
+		b.service.User = u.User.Username()
+	}
+	b.service.KeyFile = os.Getenv(ENV_KEY_FILE)
+
+	b.path = filepath.Clean(u.Path)
+	if u.Path == "" || b.path == "/" {
+		return nil, fmt.Errorf("Cannot find ssh path")
+	}
+
+	if _, err := b.List(""); err != nil {
+		return nil, fmt.Errorf("SSH path %v doesn't exist or is not a directory on %v: %v",
+			b.path, b.service.Host, err)
+	}
+
+	b.destURL = kind + "://" + u.Host + b.path
+	if b.service.User != "" {
+		b.destURL = kind + "://" + b.service.User + "@" + u.Host + b.path
+	}
+	log.Debugf("Loaded driver for %v", b.destURL)
+	return b, nil
+}
+
+func splitHostPort(hostport string) (string, string, error) {
+	i := strings.LastIndex(hostport, ":")
+	if i < 0 || strings.HasSuffix(hostport, "]") {
+		return "", "", fmt.Errorf("No port in %v", hostport)
+	}
+	return strings.Trim(hostport[:i], "[]"), hostport[i+1:], nil
+}
+
+func (s *SSHObjectStoreDriver) Kind() string {
+	return KIND
+}
+
+func (s *SSHObjectStoreDriver) GetURL() string {
+	return s.destURL
+}
+
+func (s *SSHObjectStoreDriver) updatePath(path string) string {
+	return filepath.Join(s.path, path)
+}
+
+func (s *SSHObjectStoreDriver) List(listPath string) ([]string, error) {
+	return s.service.ListDir(s.updatePath(listPath))
+}
+
+func (s *SSHObjectStoreDriver) FileExists(filePath string) bool {
+	return s.FileSize(filePath) >= 0
+}
+
+func (s *SSHObjectStoreDriver) FileSize(filePath string) int64 {
+	size, err := s.service.FileSize(s.updatePath(filePath))
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+func (s *SSHObjectStoreDriver) Remove(names ...string) error {
+	for _, name := range names {
+		//Also automatically cleanup upper level directories
+		parents := []string{}
+		dir := s.updatePath(name)
+		for i := 0; i < MAX_CLEANUP_LEVEL; i++ {
+			dir = filepath.Dir(dir)
+			// Don't clean above OBJECTSTORE_BASE
+			if strings.HasSuffix(dir, objectstore.OBJECTSTORE_BASE) || dir == s.path {
+				break
+			}
+			parents = append(parents, dir)
+		}
+		if err := s.service.RemoveAll(s.updatePath(name), parents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SSHObjectStoreDriver) Read(src string) (io.ReadCloser, error) {
+	buf := &bytes.Buffer{}
+	if err := s.service.ReadFile(s.updatePath(src), buf); err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(buf), nil
+}
+
+func (s *SSHObjectStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	path := s.updatePath(dst)
+	return s.service.WriteFile(path, filepath.Dir(path), rs)
+}
+
+func (s *SSHObjectStoreDriver) Upload(src, dst string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	path := s.updatePath(dst)
+	return s.service.WriteFile(path, filepath.Dir(path), file)
+}
+
+func (s *SSHObjectStoreDriver) Download(src, dst string) error {
+	if _, err := os.Stat(dst); err != nil {
+		os.Remove(dst)
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.service.ReadFile(s.updatePath(src), f)
+}
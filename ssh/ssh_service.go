@@ -0,0 +1,132 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+const (
+	SSH_BINARY = "ssh"
+)
+
+type SSHService struct {
+	Host    string
+	Port    string
+	User    string
+	KeyFile string
+	// Binary is the ssh client to use, SSH_BINARY if not specified
+	Binary string
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func (s *SSHService) binary() string {
+	if s.Binary == "" {
+		return SSH_BINARY
+	}
+	return s.Binary
+}
+
+func (s *SSHService) options() []string {
+	// BatchMode makes ssh fail rather than prompting for password or
+	// unknown host keys
+	opts := []string{"-o", "BatchMode=yes"}
+	if s.Port != "" {
+		opts = append(opts, "-p", s.Port)
+	}
+	if s.KeyFile != "" {
+		opts = append(opts, "-i", s.KeyFile, "-o", "IdentitiesOnly=yes")
+	}
+	return opts
+}
+
+func (s *SSHService) target() string {
+	if s.User != "" {
+		return s.User + "@" + s.Host
+	}
+	return s.Host
+}
+
+func (s *SSHService) args(command string) []string {
+	return append(s.options(), s.target(), "--", command)
+}
+
+// Run executes the command on the remote host through ssh, with stdin and
+// stdout of the command connected to the specified reader and writer
+func (s *SSHService) Run(command string, stdin io.Reader, stdout io.Writer) error {
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command(s.binary(), s.args(command)...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Failed to execute %v on %v: %v, %v", command, s.Host, err,
+			strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (s *SSHService) Output(command string) (string, error) {
+	stdout := &bytes.Buffer{}
+	if err := s.Run(command, nil, stdout); err != nil {
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+func (s *SSHService) ListDir(path string) ([]string, error) {
+	out, err := s.Output("ls -1 -- " + shellQuote(path))
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	if len(strings.TrimSpace(out)) == 0 {
+		return result, nil
+	}
+	return strings.Split(strings.TrimSpace(out), "\n"), nil
+}
+
+func (s *SSHService) FileSize(path string) (int64, error) {
+	p := shellQuote(path)
+	out, err := s.Output("test -f " + p + " && wc -c < " + p)
+	if err != nil {
+		return -1, err
+	}
+	var size int64
+	if _, err := fmt.Sscan(strings.TrimSpace(out), &size); err != nil {
+		return -1, err
+	}
+	return size, nil
+}
+
+// RemoveAll removes the path, then tries to remove the specified parent
+// directories in order, stopping at the first one which is not empty
+func (s *SSHService) RemoveAll(path string, parents []string) error {
+	command := "rm -rf -- " + shellQuote(path)
+	if len(parents) != 0 {
+		dirs := make([]string, len(parents))
+		for i, dir := range parents {
+			dirs[i] = shellQuote(dir)
+		}
+		command += " && { rmdir -- " + strings.Join(dirs, " ") + " 2>/dev/null; true; }"
+	}
+	return s.Run(command, nil, nil)
+}
+
+// WriteFile writes the content to a temporary file first, then renames it
+// to the destination, so readers would never see a partial file
+func (s *SSHService) WriteFile(path, dir string, r io.Reader) error {
+	tmp := shellQuote(path + ".tmp")
+	command := "mkdir -p -- " + shellQuote(dir) + " && cat > " + tmp +
+		" && mv -f -- " + tmp + " " + shellQuote(path)
+	return s.Run(command, r, nil)
+}
+
+func (s *SSHService) ReadFile(path string, w io.Writer) error {
+	return s.Run("cat -- "+shellQuote(path), nil, w)
+}
@@ -0,0 +1,99 @@
+package ssh
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/convoy/objectstore"
+	"gopkg.in/check.v1"
+)
+
+func TestSSH(t *testing.T) { check.TestingT(t) }
+
+type SSHTestSuite struct {
+	dir     string
+	service SSHService
+}
+
+var _ = check.Suite(&SSHTestSuite{})
+
+// fakeSSH runs the remote command locally instead
+const fakeSSH = `#!/bin/sh
+for last; do :; done
+exec sh -c "$last"
+`
+
+func (s *SSHTestSuite) SetUpTest(c *check.C) {
+	s.dir = c.MkDir()
+	binary := filepath.Join(s.dir, "fake-ssh")
+	c.Assert(ioutil.WriteFile(binary, []byte(fakeSSH), 0755), check.IsNil)
+	s.service = SSHService{Binary: binary}
+	c.Assert(os.Mkdir(filepath.Join(s.dir, "backups"), 0700), check.IsNil)
+	os.Unsetenv(ENV_KEY_FILE)
+}
+
+func (s *SSHTestSuite) TestArgs(c *check.C) {
+	service := &SSHService{
+		Host:    "backup.example.com",
+		Port:    "2222",
+		User:    "convoy",
+		KeyFile: "/root/.ssh/backup_key",
+	}
+	c.Check(service.args("ls -1 -- '/backups'"), check.DeepEquals, []string{
+		"-o", "BatchMode=yes", "-p", "2222", "-i", "/root/.ssh/backup_key",
+		"-o", "IdentitiesOnly=yes", "convoy@backup.example.com", "--", "ls -1 -- '/backups'"})
+
+	c.Check(shellQuote("it's"), check.Equals, `'it'\''s'`)
+}
+
+func (s *SSHTestSuite) TestInitFunc(c *check.C) {
+	os.Setenv(ENV_KEY_FILE, "/root/.ssh/backup_key")
+	driver, err := initFuncWithService("ssh://convoy@localhost:2222"+s.dir+"/backups/", s.service)
+	c.Assert(err, check.IsNil)
+	c.Check(driver.GetURL(), check.Equals, "ssh://convoy@localhost:2222"+s.dir+"/backups")
+
+	d := driver.(*SSHObjectStoreDriver)
+	c.Check(d.service.Host, check.Equals, "localhost")
+	c.Check(d.service.Port, check.Equals, "2222")
+	c.Check(d.service.User, check.Equals, "convoy")
+	c.Check(d.service.KeyFile, check.Equals, "/root/.ssh/backup_key")
+
+	_, err = initFuncWithService("ssh://localhost"+s.dir+"/nonexistent", s.service)
+	c.Check(err, check.NotNil)
+	_, err = initFuncWithService("ssh:///backups", s.service)
+	c.Check(err, check.NotNil)
+}
+
+func (s *SSHTestSuite) TestReadWriteRemove(c *check.C) {
+	driver, err := initFuncWithService("ssh://localhost"+s.dir+"/backups", s.service)
+	c.Assert(err, check.IsNil)
+
+	dst := filepath.Join(objectstore.OBJECTSTORE_BASE, "volumes", "it's", "blocks", "block")
+	c.Assert(driver.Write(dst, bytes.NewReader([]byte("data"))), check.IsNil)
+	c.Check(driver.FileExists(dst), check.Equals, true)
+	c.Check(driver.FileSize(dst), check.Equals, int64(4))
+	c.Check(driver.FileExists(filepath.Dir(dst)), check.Equals, false)
+
+	names, err := driver.List(filepath.Dir(dst))
+	c.Assert(err, check.IsNil)
+	c.Check(names, check.DeepEquals, []string{"block"})
+
+	rc, err := driver.Read(dst)
+	c.Assert(err, check.IsNil)
+	data, err := ioutil.ReadAll(rc)
+	c.Assert(err, check.IsNil)
+	c.Check(string(data), check.Equals, "data")
+
+	_, err = driver.Read(dst + ".nonexistent")
+	c.Check(err, check.NotNil)
+
+	c.Assert(driver.Remove(dst), check.IsNil)
+	c.Check(driver.FileExists(dst), check.Equals, false)
+	// Empty parent directories would be cleaned up, up to OBJECTSTORE_BASE
+	names, err = driver.List(objectstore.OBJECTSTORE_BASE)
+	c.Assert(err, check.IsNil)
+	c.Check(names, check.HasLen, 0)
+}
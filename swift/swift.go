@@ -0,0 +1,206 @@
+package swift
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/objectstore"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "swift"})
+)
+
+type SwiftObjectStoreDriver struct {
+	destURL string
+	path    string
+	service SwiftService
+}
+
+const (
+	KIND = "swift"
+
+	ENV_AUTH_URL            = "OS_AUTH_URL"
+	ENV_IDENTITY_VERSION    = "OS_IDENTITY_API_VERSION"
+	ENV_USERNAME            = "OS_USERNAME"
+	ENV_PASSWORD            = "OS_PASSWORD"
+	ENV_PROJECT_NAME        = "OS_PROJECT_NAME"
+	ENV_TENANT_NAME         = "OS_TENANT_NAME"
+	ENV_USER_DOMAIN_NAME    = "OS_USER_DOMAIN_NAME"
+	ENV_PROJECT_DOMAIN_NAME = "OS_PROJECT_DOMAIN_NAME"
+	ENV_REGION_NAME         = "OS_REGION_NAME"
+
+	DEFAULT_DOMAIN = "Default"
+)
+
+func init() {
+	if err := objectstore.RegisterDriver(KIND, initFunc); err != nil {
+		panic(err)
+	}
+}
+
+func initFunc(destURL, endpoint string) (objectstore.ObjectStoreDriver, error) {
+	return initFuncWithConnectionCheck(destURL, endpoint, func(d objectstore.ObjectStoreDriver) error {
+		_, err := d.List("")
+		return err
+	})
+}
+
+func getEnvWithDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func initFuncWithConnectionCheck(destURL, endpoint string, connectionTest func(d objectstore.ObjectStoreDriver) error) (objectstore.ObjectStoreDriver, error) {
+	b := &SwiftObjectStoreDriver{}
+
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != KIND {
+		return nil, fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, KIND)
+	}
+
+	b.service.Container = u.Host
+	if b.service.Container == "" {
+		return nil, fmt.Errorf("Invalid URL. Must be swift://container/path/")
+	}
+	b.path = strings.TrimLeft(u.Path, "/")
+
+	b.service.AuthURL = os.Getenv(ENV_AUTH_URL)
+	b.service.Username = os.Getenv(ENV_USERNAME)
+	b.service.Password = os.Getenv(ENV_PASSWORD)
+	if b.service.AuthURL == "" || b.service.Username == "" || b.service.Password == "" {
+		return nil, fmt.Errorf("Cannot find Keystone credentials, %v, %v and %v need to be set",
+			ENV_AUTH_URL, ENV_USERNAME, ENV_PASSWORD)
+	}
+	b.service.ProjectName = getEnvWithDefault(ENV_PROJECT_NAME, os.Getenv(ENV_TENANT_NAME))
+	b.service.UserDomain = getEnvWithDefault(ENV_USER_DOMAIN_NAME, DEFAULT_DOMAIN)
+	b.service.ProjectDomain = getEnvWithDefault(ENV_PROJECT_DOMAIN_NAME, DEFAULT_DOMAIN)
+	b.service.Region = os.Getenv(ENV_REGION_NAME)
+
+	b.service.AuthVersion = os.Getenv(ENV_IDENTITY_VERSION)
+	if b.service.AuthVersion == "" {
+		b.service.AuthVersion = "3"
+		if strings.HasSuffix(strings.TrimRight(b.service.AuthURL, "/"), "/v2.0") {
+			b.service.AuthVersion = "2"
+		}
+	}
+	if b.service.AuthVersion != "2" && b.service.AuthVersion != "3" {
+		return nil, fmt.Errorf("Unsupported Keystone API version %v", b.service.AuthVersion)
+	}
+
+	if err := connectionTest(b); err != nil {
+		return nil, err
+	}
+
+	b.destURL = KIND + "://" + b.service.Container + "/" + b.path
+	log.Debugf("Loaded driver for %v", b.destURL)
+	return b, nil
+}
+
+func (s *SwiftObjectStoreDriver) Kind() string {
+	return KIND
+}
+
+func (s *SwiftObjectStoreDriver) GetURL() string {
+	return s.destURL
+}
+
+func (s *SwiftObjectStoreDriver) updatePath(path string) string {
+	return filepath.Join(s.path, path)
+}
+
+func (s *SwiftObjectStoreDriver) List(listPath string) ([]string, error) {
+	var result []string
+
+	path := s.updatePath(listPath) + "/"
+	if path == "/" {
+		path = ""
+	}
+	objects, err := s.service.ListObjects(path, "/")
+	if err != nil {
+		log.Error("Fail to list swift: ", err)
+		return result, err
+	}
+
+	for _, obj := range objects {
+		name := obj.Name
+		if obj.Subdir != "" {
+			name = strings.TrimSuffix(obj.Subdir, "/")
+		}
+		r := strings.TrimPrefix(name, path)
+		if r != "" {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+
+func (s *SwiftObjectStoreDriver) FileExists(filePath string) bool {
+	return s.FileSize(filePath) >= 0
+}
+
+func (s *SwiftObjectStoreDriver) FileSize(filePath string) int64 {
+	size, err := s.service.HeadObject(s.updatePath(filePath))
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+func (s *SwiftObjectStoreDriver) Remove(names ...string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = s.updatePath(name)
+	}
+	return s.service.DeleteObjects(paths)
+}
+
+func (s *SwiftObjectStoreDriver) Read(src string) (io.ReadCloser, error) {
+	return s.service.GetObject(s.updatePath(src))
+}
+
+func (s *SwiftObjectStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	return s.service.PutObject(s.updatePath(dst), rs)
+}
+
+func (s *SwiftObjectStoreDriver) Upload(src, dst string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return s.service.PutObject(s.updatePath(dst), file)
+}
+
+func (s *SwiftObjectStoreDriver) Download(src, dst string) error {
+	if _, err := os.Stat(dst); err != nil {
+		os.Remove(dst)
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rc, err := s.service.GetObject(s.updatePath(src))
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(f, rc)
+	return err
+}
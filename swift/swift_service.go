@@ -0,0 +1,315 @@
+package swift
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type SwiftService struct {
+	AuthURL       string
+	AuthVersion   string
+	Username      string
+	Password      string
+	ProjectName   string
+	UserDomain    string
+	ProjectDomain string
+	Region        string
+	Container     string
+
+	token      string
+	storageURL string
+	client     *http.Client
+}
+
+type swiftObject struct {
+	Name   string `json:"name"`
+	Bytes  int64  `json:"bytes"`
+	Subdir string `json:"subdir"`
+}
+
+type catalogEndpoint struct {
+	Region    string `json:"region"`
+	Interface string `json:"interface"`
+	URL       string `json:"url"`
+	PublicURL string `json:"publicURL"`
+}
+
+type catalogEntry struct {
+	Type      string            `json:"type"`
+	Endpoints []catalogEndpoint `json:"endpoints"`
+}
+
+func (s *SwiftService) httpClient() *http.Client {
+	if s.client == nil {
+		s.client = &http.Client{}
+	}
+	return s.client
+}
+
+func (s *SwiftService) findStorageURL(catalog []catalogEntry) error {
+	for _, entry := range catalog {
+		if entry.Type != "object-store" {
+			continue
+		}
+		for _, ep := range entry.Endpoints {
+			if s.Region != "" && ep.Region != s.Region {
+				continue
+			}
+			if ep.PublicURL != "" {
+				s.storageURL = ep.PublicURL
+				return nil
+			}
+			if ep.Interface == "public" {
+				s.storageURL = ep.URL
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("Cannot find object-store endpoint in region %v in the service catalog", s.Region)
+}
+
+func (s *SwiftService) post(u string, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.httpClient().Post(u, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Keystone authentication failed: %v, %v", resp.Status, string(msg))
+	}
+	return resp, nil
+}
+
+func (s *SwiftService) authV2() error {
+	body := map[string]interface{}{
+		"auth": map[string]interface{}{
+			"passwordCredentials": map[string]string{
+				"username": s.Username,
+				"password": s.Password,
+			},
+			"tenantName": s.ProjectName,
+		},
+	}
+	resp, err := s.post(strings.TrimRight(s.AuthURL, "/")+"/tokens", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	result := struct {
+		Access struct {
+			Token struct {
+				ID string `json:"id"`
+			} `json:"token"`
+			ServiceCatalog []catalogEntry `json:"serviceCatalog"`
+		} `json:"access"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	s.token = result.Access.Token.ID
+	return s.findStorageURL(result.Access.ServiceCatalog)
+}
+
+func (s *SwiftService) authV3() error {
+	body := map[string]interface{}{
+		"auth": map[string]interface{}{
+			"identity": map[string]interface{}{
+				"methods": []string{"password"},
+				"password": map[string]interface{}{
+					"user": map[string]interface{}{
+						"name":     s.Username,
+						"password": s.Password,
+						"domain":   map[string]string{"name": s.UserDomain},
+					},
+				},
+			},
+			"scope": map[string]interface{}{
+				"project": map[string]interface{}{
+					"name":   s.ProjectName,
+					"domain": map[string]string{"name": s.ProjectDomain},
+				},
+			},
+		},
+	}
+	resp, err := s.post(strings.TrimRight(s.AuthURL, "/")+"/auth/tokens", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	result := struct {
+		Token struct {
+			Catalog []catalogEntry `json:"catalog"`
+		} `json:"token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	s.token = resp.Header.Get("X-Subject-Token")
+	return s.findStorageURL(result.Token.Catalog)
+}
+
+func (s *SwiftService) Authenticate() error {
+	if s.AuthVersion == "2" {
+		return s.authV2()
+	}
+	return s.authV3()
+}
+
+func (s *SwiftService) ensureAuthenticated() error {
+	if s.token != "" && s.storageURL != "" {
+		return nil
+	}
+	return s.Authenticate()
+}
+
+func (s *SwiftService) objectURL(key string) string {
+	return strings.TrimRight(s.storageURL, "/") + "/" + url.QueryEscape(s.Container) + "/" + escapeObjectName(key)
+}
+
+func escapeObjectName(key string) string {
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		parts[i] = strings.Replace(url.QueryEscape(p), "+", "%20", -1)
+	}
+	return strings.Join(parts, "/")
+}
+
+// do sends the request, authenticates again in case the token has expired
+func (s *SwiftService) do(method, u string, body io.ReadSeeker) (*http.Response, error) {
+	for retry := 0; ; retry++ {
+		if body != nil {
+			if _, err := body.Seek(0, 0); err != nil {
+				return nil, err
+			}
+		}
+		req, err := http.NewRequest(method, u, body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Auth-Token", s.token)
+		resp, err := s.httpClient().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && retry == 0 {
+			resp.Body.Close()
+			if err := s.Authenticate(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			defer resp.Body.Close()
+			msg, _ := ioutil.ReadAll(resp.Body)
+			return nil, fmt.Errorf("Swift Error: %v %v %v, %v", method, u, resp.Status, string(msg))
+		}
+		return resp, nil
+	}
+}
+
+func (s *SwiftService) ListObjects(prefix, delimiter string) ([]swiftObject, error) {
+	var result []swiftObject
+	marker := ""
+	for {
+		if err := s.ensureAuthenticated(); err != nil {
+			return nil, err
+		}
+		query := url.Values{}
+		query.Set("format", "json")
+		query.Set("prefix", prefix)
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		u := strings.TrimRight(s.storageURL, "/") + "/" + url.QueryEscape(s.Container) + "?" + query.Encode()
+		resp, err := s.do("GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		objects := []swiftObject{}
+		if resp.StatusCode != http.StatusNoContent {
+			err = json.NewDecoder(resp.Body).Decode(&objects)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(objects) == 0 {
+			break
+		}
+		result = append(result, objects...)
+		last := objects[len(objects)-1]
+		marker = last.Name
+		if last.Subdir != "" {
+			marker = last.Subdir
+		}
+	}
+	return result, nil
+}
+
+func (s *SwiftService) HeadObject(key string) (int64, error) {
+	if err := s.ensureAuthenticated(); err != nil {
+		return -1, err
+	}
+	resp, err := s.do("HEAD", s.objectURL(key), nil)
+	if err != nil {
+		return -1, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+func (s *SwiftService) GetObject(key string) (io.ReadCloser, error) {
+	if err := s.ensureAuthenticated(); err != nil {
+		return nil, err
+	}
+	resp, err := s.do("GET", s.objectURL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *SwiftService) PutObject(key string, rs io.ReadSeeker) error {
+	if err := s.ensureAuthenticated(); err != nil {
+		return err
+	}
+	resp, err := s.do("PUT", s.objectURL(key), rs)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *SwiftService) DeleteObjects(keys []string) error {
+	for _, key := range keys {
+		objects, err := s.ListObjects(key, "")
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			resp, err := s.do("DELETE", s.objectURL(obj.Name), nil)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+		}
+	}
+	return nil
+}
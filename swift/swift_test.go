@@ -0,0 +1,107 @@
+package swift
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/rancher/convoy/objectstore"
+	"gopkg.in/check.v1"
+)
+
+func TestSwift(t *testing.T) { check.TestingT(t) }
+
+type SwiftTestSuite struct {
+	server *httptest.Server
+	auths  int
+}
+
+var _ = check.Suite(&SwiftTestSuite{})
+
+func (s *SwiftTestSuite) SetUpTest(c *check.C) {
+	s.auths = 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v3/auth/tokens", func(w http.ResponseWriter, r *http.Request) {
+		s.auths++
+		w.Header().Set("X-Subject-Token", fmt.Sprintf("token-%v", s.auths))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token": {"catalog": [{"type": "object-store", "endpoints": [
+			{"interface": "internal", "region": "RegionOne", "url": "http://internal"},
+			{"interface": "public", "region": "RegionOne", "url": "%v/v1/AUTH_test"}]}]}}`, s.server.URL)
+	})
+	mux.HandleFunc("/v2.0/tokens", func(w http.ResponseWriter, r *http.Request) {
+		s.auths++
+		fmt.Fprintf(w, `{"access": {"token": {"id": "token-%v"}, "serviceCatalog": [{"type": "object-store", "endpoints": [
+			{"region": "RegionOne", "publicURL": "%v/v1/AUTH_test"}]}]}}`, s.auths, s.server.URL)
+	})
+	mux.HandleFunc("/v1/AUTH_test/backups", func(w http.ResponseWriter, r *http.Request) {
+		// Only the second token is accepted, to test re-authentication
+		if r.Header.Get("X-Auth-Token") != "token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		objects := []swiftObject{}
+		if r.URL.Query().Get("marker") == "" {
+			objects = []swiftObject{
+				{Name: "path/volume.cfg", Bytes: 10},
+				{Subdir: "path/backups/"},
+			}
+		}
+		json.NewEncoder(w).Encode(objects)
+	})
+	s.server = httptest.NewServer(mux)
+
+	os.Setenv(ENV_AUTH_URL, s.server.URL+"/v3")
+	os.Setenv(ENV_USERNAME, "user")
+	os.Setenv(ENV_PASSWORD, "password")
+	os.Setenv(ENV_PROJECT_NAME, "project")
+	os.Setenv(ENV_REGION_NAME, "RegionOne")
+	os.Unsetenv(ENV_IDENTITY_VERSION)
+}
+
+func (s *SwiftTestSuite) TearDownTest(c *check.C) {
+	s.server.Close()
+}
+
+func (s *SwiftTestSuite) TestInitFuncV3(c *check.C) {
+	driver, err := initFunc("swift://backups/path", "")
+	c.Assert(err, check.IsNil)
+	c.Check(driver.GetURL(), check.Equals, "swift://backups/path")
+
+	d := driver.(*SwiftObjectStoreDriver)
+	c.Check(d.service.AuthVersion, check.Equals, "3")
+	c.Check(d.service.UserDomain, check.Equals, DEFAULT_DOMAIN)
+	c.Check(d.service.storageURL, check.Equals, s.server.URL+"/v1/AUTH_test")
+	c.Check(d.service.token, check.Equals, "token-2")
+
+	names, err := driver.List("")
+	c.Assert(err, check.IsNil)
+	c.Check(names, check.DeepEquals, []string{"volume.cfg", "backups"})
+}
+
+func (s *SwiftTestSuite) TestInitFuncV2(c *check.C) {
+	os.Setenv(ENV_AUTH_URL, s.server.URL+"/v2.0")
+
+	driver, err := initFunc("swift://backups/path", "")
+	c.Assert(err, check.IsNil)
+
+	d := driver.(*SwiftObjectStoreDriver)
+	c.Check(d.service.AuthVersion, check.Equals, "2")
+	c.Check(d.service.token, check.Equals, "token-2")
+}
+
+func (s *SwiftTestSuite) TestInitFuncBadConfig(c *check.C) {
+	_, err := initFuncWithConnectionCheck("swift:///path", "", func(d objectstore.ObjectStoreDriver) error {
+		return nil
+	})
+	c.Check(err, check.NotNil)
+
+	os.Unsetenv(ENV_PASSWORD)
+	_, err = initFuncWithConnectionCheck("swift://backups/path", "", func(d objectstore.ObjectStoreDriver) error {
+		return nil
+	})
+	c.Check(err, check.NotNil)
+}
@@ -0,0 +1,452 @@
+package tmpfs
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+)
+
+const (
+	DRIVER_NAME        = "tmpfs"
+	DRIVER_CONFIG_FILE = "tmpfs.cfg"
+
+	VOLUME_CFG_PREFIX = "volume_"
+	CFG_PREFIX        = DRIVER_NAME + "_"
+	CFG_POSTFIX       = ".json"
+
+	VOLUMES_DIR = "volumes"
+	MOUNTS_DIR  = "mounts"
+
+	TMPFS_DEFAULT_VOLUME_SIZE = "tmpfs.defaultvolumesize"
+	TMPFS_TOTAL_SIZE          = "tmpfs.totalsize"
+
+	DEFAULT_VOLUME_SIZE = "1G"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "tmpfs"})
+)
+
+type Driver struct {
+	mutex *sync.RWMutex
+	Device
+}
+
+type Device struct {
+	Root              string
+	DefaultVolumeSize int64
+	TotalSize         int64
+}
+
+func (dev *Device) ConfigFile() (string, error) {
+	if dev.Root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty device config path")
+	}
+	return filepath.Join(dev.Root, DRIVER_CONFIG_FILE), nil
+}
+
+// Volume is a tmpfs mounted at Path since the volume was created. Mounting
+// the volume would bind mount Path, so the content would survive the
+// container restarts, until the volume is deleted or the daemon restarts.
+type Volume struct {
+	Name        string
+	Size        int64
+	Path        string
+	MountPoint  string
+	CreatedTime string
+
+	configPath string
+}
+
+func (v *Volume) ConfigFile() (string, error) {
+	if v.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if v.configPath == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume config path")
+	}
+	return filepath.Join(v.configPath, CFG_PREFIX+VOLUME_CFG_PREFIX+v.Name+CFG_POSTFIX), nil
+}
+
+func (v *Volume) GetDevice() (string, error) {
+	if v.Path == "" {
+		return "", fmt.Errorf("BUG: Invalid empty tmpfs path of volume %v", v.Name)
+	}
+	return v.Path, nil
+}
+
+func (v *Volume) GetMountOpts() []string {
+	return []string{"--bind"}
+}
+
+func (v *Volume) GenerateDefaultMountPoint() string {
+	return filepath.Join(v.configPath, MOUNTS_DIR, v.Name)
+}
+
+// tmpfsMount is the tmpfs behind a volume, which only lives in memory
+type tmpfsMount struct {
+	Name       string
+	Size       int64
+	MountPoint string
+
+	path string
+}
+
+func (t *tmpfsMount) GetDevice() (string, error) {
+	return DRIVER_NAME, nil
+}
+
+func (t *tmpfsMount) GetMountOpts() []string {
+	return []string{"-t", "tmpfs", "-o", "size=" + strconv.FormatInt(t.Size, 10) + ",mode=0755"}
+}
+
+func (t *tmpfsMount) GenerateDefaultMountPoint() string {
+	return t.path
+}
+
+func init() {
+	if err := Register(DRIVER_NAME, Init); err != nil {
+		panic(err)
+	}
+}
+
+func (d *Driver) Name() string {
+	return DRIVER_NAME
+}
+
+func (d *Driver) listVolumeNames() ([]string, error) {
+	return util.ListConfigIDs(d.Root, CFG_PREFIX+VOLUME_CFG_PREFIX, CFG_POSTFIX)
+}
+
+func (d *Driver) blankVolume(name string) *Volume {
+	return &Volume{
+		configPath: d.Root,
+		Name:       name,
+	}
+}
+
+func (d *Driver) tmpfs(volume *Volume) *tmpfsMount {
+	t := &tmpfsMount{
+		Name: volume.Name,
+		Size: volume.Size,
+		path: filepath.Join(d.Root, VOLUMES_DIR, volume.Name),
+	}
+	if volume.Path != "" {
+		t.MountPoint = t.path
+	}
+	return t
+}
+
+func verifyConfig(root string, config map[string]string) (*Device, error) {
+	dev := &Device{
+		Root: root,
+	}
+
+	if config[TMPFS_DEFAULT_VOLUME_SIZE] == "" {
+		config[TMPFS_DEFAULT_VOLUME_SIZE] = DEFAULT_VOLUME_SIZE
+	}
+	size, err := util.ParseSize(config[TMPFS_DEFAULT_VOLUME_SIZE])
+	if err != nil || size == 0 {
+		return nil, fmt.Errorf("Illegal default volume size specified")
+	}
+	dev.DefaultVolumeSize = size
+
+	if config[TMPFS_TOTAL_SIZE] != "" {
+		totalSize, err := util.ParseSize(config[TMPFS_TOTAL_SIZE])
+		if err != nil {
+			return nil, fmt.Errorf("Illegal total size specified")
+		}
+		if totalSize != 0 && totalSize < dev.DefaultVolumeSize {
+			return nil, fmt.Errorf("Total size %v is smaller than default volume size %v", totalSize, dev.DefaultVolumeSize)
+		}
+		dev.TotalSize = totalSize
+	}
+	return dev, nil
+}
+
+// cleanupVolumes removes all the volumes left by the last run of the daemon.
+// The content of them may already be gone with a reboot, and there is no
+// point to keep the scratch space of the containers which have gone
+func (d *Driver) cleanupVolumes() error {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return err
+	}
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return err
+		}
+		log.Debugf("Cleaning up volume %v left by last run", id)
+		if err := util.VolumeUmount(volume); err != nil {
+			log.Warnf("Failed to umount volume %v at %v: %v", id, volume.MountPoint, err)
+		}
+		if err := util.VolumeUmount(d.tmpfs(volume)); err != nil {
+			log.Warnf("Failed to umount tmpfs of volume %v: %v", id, err)
+		}
+		if err := util.ObjectDelete(volume); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Init(root string, config map[string]string) (ConvoyDriver, error) {
+	if err := util.MkdirIfNotExists(root); err != nil {
+		return nil, err
+	}
+	dev := &Device{
+		Root: root,
+	}
+	exists, err := util.ObjectExists(dev)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if err := util.ObjectLoad(dev); err != nil {
+			return nil, err
+		}
+	} else {
+		if dev, err = verifyConfig(root, config); err != nil {
+			return nil, err
+		}
+		if err := util.ObjectSave(dev); err != nil {
+			return nil, err
+		}
+	}
+	d := &Driver{
+		mutex:  &sync.RWMutex{},
+		Device: *dev,
+	}
+	if err := d.cleanupVolumes(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Driver) Info() (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	allocated, err := d.allocatedSize()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"Driver":            d.Name(),
+		"Root":              d.Root,
+		"DefaultVolumeSize": strconv.FormatInt(d.DefaultVolumeSize, 10),
+		"TotalSize":         strconv.FormatInt(d.TotalSize, 10),
+		"AllocatedSize":     strconv.FormatInt(allocated, 10),
+	}, nil
+}
+
+func (d *Driver) VolumeOps() (VolumeOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) SnapshotOps() (SnapshotOperations, error) {
+	return nil, fmt.Errorf("Doesn't support snapshot operations")
+}
+
+func (d *Driver) BackupOps() (BackupOperations, error) {
+	return nil, fmt.Errorf("Doesn't support backup operations")
+}
+
+// allocatedSize returns the sum of size limits of all the volumes
+func (d *Driver) allocatedSize() (int64, error) {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return 0, err
+	}
+	allocated := int64(0)
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return 0, err
+		}
+		allocated += volume.Size
+	}
+	return allocated, nil
+}
+
+func (d *Driver) getSize(opts map[string]string) (int64, error) {
+	size := opts[OPT_SIZE]
+	if size == "" || size == "0" {
+		size = strconv.FormatInt(d.DefaultVolumeSize, 10)
+	}
+	return util.ParseSize(size)
+}
+
+func (d *Driver) checkTotalSize(size int64) error {
+	if d.TotalSize == 0 {
+		return nil
+	}
+	allocated, err := d.allocatedSize()
+	if err != nil {
+		return err
+	}
+	if allocated+size > d.TotalSize {
+		return fmt.Errorf("Cannot allocate %v bytes for volume, %v of total %v bytes has been allocated", size, allocated, d.TotalSize)
+	}
+	return nil
+}
+
+func (d *Driver) CreateVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	opts := req.Options
+
+	if opts[OPT_BACKUP_URL] != "" {
+		return fmt.Errorf("tmpfs driver doesn't support restoring from backup")
+	}
+	size, err := d.getSize(opts)
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		return fmt.Errorf("Invalid volume size 0")
+	}
+
+	volume := d.blankVolume(id)
+	exists, err := util.ObjectExists(volume)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Already has volume with specific uuid %v", id)
+	}
+	if err := d.checkTotalSize(size); err != nil {
+		return err
+	}
+
+	volume.Size = size
+	t := d.tmpfs(volume)
+	path, err := util.VolumeMount(t, "", false)
+	if err != nil {
+		return err
+	}
+	volume.Path = path
+	volume.CreatedTime = util.Now()
+	if err := util.ObjectSave(volume); err != nil {
+		if err := util.VolumeUmount(t); err != nil {
+			log.Warnf("Failed to umount tmpfs of volume %v: %v", id, err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (d *Driver) DeleteVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if volume.MountPoint != "" {
+		return fmt.Errorf("Cannot delete volume %v. It is still mounted", id)
+	}
+
+	// The content is always discarded along with the tmpfs, so
+	// OPT_REFERENCE_ONLY makes no difference here
+	if err := util.VolumeUmount(d.tmpfs(volume)); err != nil {
+		return err
+	}
+	return util.ObjectDelete(volume)
+}
+
+func (d *Driver) MountVolume(req Request) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	opts := req.Options
+
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+
+	mountPoint, err := util.VolumeMount(volume, opts[OPT_MOUNT_POINT], false)
+	if err != nil {
+		return "", err
+	}
+	if err := util.ObjectSave(volume); err != nil {
+		return "", err
+	}
+	return mountPoint, nil
+}
+
+func (d *Driver) UmountVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if err := util.VolumeUmount(volume); err != nil {
+		return err
+	}
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) MountPoint(req Request) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	return volume.MountPoint, nil
+}
+
+func (d *Driver) GetVolumeInfo(name string) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.getVolumeInfo(name)
+}
+
+func (d *Driver) getVolumeInfo(name string) (map[string]string, error) {
+	volume := d.blankVolume(name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"Path":                  volume.Path,
+		OPT_MOUNT_POINT:         volume.MountPoint,
+		OPT_SIZE:                strconv.FormatInt(volume.Size, 10),
+		OPT_VOLUME_NAME:         volume.Name,
+		OPT_VOLUME_CREATED_TIME: volume.CreatedTime,
+	}, nil
+}
+
+func (d *Driver) ListVolume(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]map[string]string{}
+	for _, id := range volumeIDs {
+		result[id], err = d.getVolumeInfo(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,99 @@
+package tmpfs
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/rancher/convoy/util"
+
+	. "gopkg.in/check.v1"
+)
+
+const (
+	GB = 1024 * 1024 * 1024
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TestSuite struct {
+	dir string
+}
+
+var _ = Suite(&TestSuite{})
+
+func (s *TestSuite) SetUpTest(c *C) {
+	var err error
+	s.dir, err = ioutil.TempDir("", "convoy-tmpfs")
+	c.Assert(err, IsNil)
+}
+
+func (s *TestSuite) TearDownTest(c *C) {
+	os.RemoveAll(s.dir)
+}
+
+func (s *TestSuite) newDriver(c *C, config map[string]string) *Driver {
+	dev, err := verifyConfig(s.dir, config)
+	c.Assert(err, IsNil)
+	return &Driver{
+		mutex:  &sync.RWMutex{},
+		Device: *dev,
+	}
+}
+
+func (s *TestSuite) saveVolume(c *C, d *Driver, name string, size int64) {
+	volume := d.blankVolume(name)
+	volume.Size = size
+	c.Assert(util.ObjectSave(volume), IsNil)
+}
+
+func (s *TestSuite) TestVerifyConfig(c *C) {
+	dev, err := verifyConfig(s.dir, map[string]string{})
+	c.Assert(err, IsNil)
+	c.Assert(dev.DefaultVolumeSize, Equals, int64(GB))
+	c.Assert(dev.TotalSize, Equals, int64(0))
+
+	dev, err = verifyConfig(s.dir, map[string]string{
+		TMPFS_DEFAULT_VOLUME_SIZE: "100M",
+		TMPFS_TOTAL_SIZE:          "2G",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(dev.DefaultVolumeSize, Equals, int64(100*1024*1024))
+	c.Assert(dev.TotalSize, Equals, int64(2*GB))
+
+	_, err = verifyConfig(s.dir, map[string]string{TMPFS_DEFAULT_VOLUME_SIZE: "0"})
+	c.Assert(err, ErrorMatches, "Illegal default volume size specified")
+	_, err = verifyConfig(s.dir, map[string]string{TMPFS_TOTAL_SIZE: "512M"})
+	c.Assert(err, ErrorMatches, "Total size .* is smaller than default volume size .*")
+}
+
+func (s *TestSuite) TestTotalSize(c *C) {
+	d := s.newDriver(c, map[string]string{TMPFS_TOTAL_SIZE: "3G"})
+	s.saveVolume(c, d, "vol1", GB)
+	s.saveVolume(c, d, "vol2", GB)
+
+	allocated, err := d.allocatedSize()
+	c.Assert(err, IsNil)
+	c.Assert(allocated, Equals, int64(2*GB))
+	c.Assert(d.checkTotalSize(GB), IsNil)
+	c.Assert(d.checkTotalSize(GB+1), ErrorMatches, "Cannot allocate .* bytes for volume.*")
+
+	size, err := d.getSize(map[string]string{})
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, int64(GB))
+
+	d.TotalSize = 0
+	c.Assert(d.checkTotalSize(10*GB), IsNil)
+}
+
+func (s *TestSuite) TestCleanupVolumes(c *C) {
+	d := s.newDriver(c, map[string]string{})
+	s.saveVolume(c, d, "vol1", GB)
+	s.saveVolume(c, d, "vol2", GB)
+
+	c.Assert(d.cleanupVolumes(), IsNil)
+	volumes, err := d.ListVolume(map[string]string{})
+	c.Assert(err, IsNil)
+	c.Assert(volumes, HasLen, 0)
+}
@@ -0,0 +1,180 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+CronSchedule is a parsed cron expression, in the standard five fields format:
+"minute hour day-of-month month day-of-week". Each field accepts "*", numbers,
+ranges "a-b", lists "a,b" and steps "a-b/n", in which the range can be "*" as
+well. Month and day-of-week accept the English abbreviations, e.g. "jan" and
+"sun". If both day-of-month and day-of-week are restricted, the time matches
+either of them, as Vixie cron does. The macros "@hourly", "@daily",
+"@weekly", "@monthly" and "@yearly" are supported as well.
+*/
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	domStar, dowStar bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var (
+	cronFields = []cronField{
+		{"minute", 0, 59, nil},
+		{"hour", 0, 23, nil},
+		{"day of month", 1, 31, nil},
+		{"month", 1, 12, []string{"", "jan", "feb", "mar", "apr", "may", "jun",
+			"jul", "aug", "sep", "oct", "nov", "dec"}},
+		{"day of week", 0, 7, []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+	}
+
+	cronMacros = map[string]string{
+		"@hourly":   "0 * * * *",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@weekly":   "0 0 * * 0",
+		"@monthly":  "0 0 1 * *",
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+	}
+
+	// Don't search forever for the expressions never match, e.g. "0 0 30 2 *"
+	cronSearchYears = 5
+)
+
+func (f *cronField) parseValue(value string) (int, error) {
+	for i, name := range f.names {
+		if name != "" && strings.EqualFold(value, name) {
+			return i, nil
+		}
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("Invalid %v %v in cron expression, should be between %v and %v", f.name, value, f.min, f.max)
+	}
+	return v, nil
+}
+
+func (f *cronField) parse(expr string) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(expr, ",") {
+		rangeExpr, step := item, 1
+		if idx := strings.Index(item, "/"); idx >= 0 {
+			rangeExpr = item[:idx]
+			s, err := strconv.Atoi(item[idx+1:])
+			if err != nil || s < 1 {
+				return 0, fmt.Errorf("Invalid step %v of %v in cron expression", item[idx+1:], f.name)
+			}
+			step = s
+		}
+
+		var start, end int
+		var err error
+		if rangeExpr == "*" {
+			start, end = f.min, f.max
+		} else if idx := strings.Index(rangeExpr, "-"); idx >= 0 {
+			if start, err = f.parseValue(rangeExpr[:idx]); err != nil {
+				return 0, err
+			}
+			if end, err = f.parseValue(rangeExpr[idx+1:]); err != nil {
+				return 0, err
+			}
+			if start > end {
+				return 0, fmt.Errorf("Invalid range %v of %v in cron expression", rangeExpr, f.name)
+			}
+		} else {
+			if start, err = f.parseValue(rangeExpr); err != nil {
+				return 0, err
+			}
+			end = start
+			if step != 1 {
+				end = f.max
+			}
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// ParseCron parses the cron expression
+func ParseCron(expr string) (*CronSchedule, error) {
+	spec := strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("Invalid cron expression %q, expect 5 fields: minute hour day-of-month month day-of-week", expr)
+	}
+
+	bits := make([]uint64, len(fields))
+	for i, field := range fields {
+		var err error
+		if bits[i], err = cronFields[i].parse(field); err != nil {
+			return nil, err
+		}
+	}
+	// Both 0 and 7 are Sunday
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	return &CronSchedule{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next returns the first time matches the schedule after t, or zero time if
+// nothing matches in the following years
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Year() + cronSearchYears
+
+	for t.Year() <= limit {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestParseCron(c *C) {
+	for _, expr := range []string{
+		"* * * * *",
+		"*/15 0-6,22 1 jan-mar,dec mon-fri",
+		"0 3 * * 7",
+		"@daily",
+	} {
+		_, err := ParseCron(expr)
+		c.Assert(err, IsNil, Commentf("%v", expr))
+	}
+
+	_, err := ParseCron("* * * *")
+	c.Assert(err, ErrorMatches, "Invalid cron expression .*, expect 5 fields.*")
+	_, err = ParseCron("60 * * * *")
+	c.Assert(err, ErrorMatches, "Invalid minute 60 in cron expression, should be between 0 and 59")
+	_, err = ParseCron("* * * foo *")
+	c.Assert(err, ErrorMatches, "Invalid month foo in cron expression.*")
+	_, err = ParseCron("*/0 * * * *")
+	c.Assert(err, ErrorMatches, "Invalid step 0 of minute in cron expression")
+	_, err = ParseCron("* 5-3 * * *")
+	c.Assert(err, ErrorMatches, "Invalid range 5-3 of hour in cron expression")
+}
+
+func (s *TestSuite) TestCronNext(c *C) {
+	base := time.Date(2016, 2, 27, 10, 30, 15, 0, time.UTC) // Saturday
+	cases := []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2016, 2, 27, 10, 31, 0, 0, time.UTC)},
+		{"*/20 * * * *", time.Date(2016, 2, 27, 10, 40, 0, 0, time.UTC)},
+		{"30 10 * * *", time.Date(2016, 2, 28, 10, 30, 0, 0, time.UTC)},
+		{"@daily", time.Date(2016, 2, 28, 0, 0, 0, 0, time.UTC)},
+		{"0 2 29 2 *", time.Date(2016, 2, 29, 2, 0, 0, 0, time.UTC)},
+		{"0 2 * * mon", time.Date(2016, 2, 29, 2, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2016, 2, 28, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2016, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// Either day of month or day of week
+		{"0 0 15 * fri", time.Date(2016, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2016, 3, 31, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, t := range cases {
+		schedule, err := ParseCron(t.expr)
+		c.Assert(err, IsNil)
+		c.Check(schedule.Next(base), Equals, t.next, Commentf("%v", t.expr))
+	}
+
+	schedule, err := ParseCron("0 0 30 2 *")
+	c.Assert(err, IsNil)
+	c.Check(schedule.Next(base).IsZero(), Equals, true)
+}
@@ -0,0 +1,138 @@
+package util
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	FSFREEZE_BINARY   = "fsfreeze"
+	XFS_FREEZE_BINARY = "xfs_freeze"
+)
+
+var (
+	freezeMutex = &sync.Mutex{}
+	// The number of the callers holding the freeze of each mount point
+	freezeCounts = map[string]int{}
+
+	freezeBinaries = []string{FSFREEZE_BINARY, XFS_FREEZE_BINARY}
+	freezeExecute  = Execute
+	mountsExecute  = Execute
+)
+
+func getFreezeBinary() (string, error) {
+	for _, binary := range freezeBinaries {
+		if _, err := exec.LookPath(binary); err == nil {
+			return binary, nil
+		}
+	}
+	return "", fmt.Errorf("Cannot find %v to freeze filesystem", strings.Join(freezeBinaries, " or "))
+}
+
+/*
+Freeze suspends the accesses to the filesystem mounted at mountpoint, so the
+snapshot of the device would be consistent. fsfreeze would be used, or
+xfs_freeze if fsfreeze is not available. The filesystem can be frozen by the
+daemon and the driver at the same time, since the freeze is counted, and only
+the last UnFreeze would thaw the filesystem.
+*/
+func Freeze(mountpoint string) error {
+	freezeMutex.Lock()
+	defer freezeMutex.Unlock()
+
+	if freezeCounts[mountpoint] == 0 {
+		binary, err := getFreezeBinary()
+		if err != nil {
+			return err
+		}
+		if _, err := freezeExecute(binary, []string{"-f", mountpoint}); err != nil {
+			return err
+		}
+		log.Debugf("Froze filesystem at %v", mountpoint)
+	}
+	freezeCounts[mountpoint]++
+	return nil
+}
+
+func UnFreeze(mountpoint string) error {
+	freezeMutex.Lock()
+	defer freezeMutex.Unlock()
+
+	if freezeCounts[mountpoint] == 0 {
+		return fmt.Errorf("Filesystem at %v is not frozen", mountpoint)
+	}
+	if freezeCounts[mountpoint] == 1 {
+		binary, err := getFreezeBinary()
+		if err != nil {
+			return err
+		}
+		if _, err := freezeExecute(binary, []string{"-u", mountpoint}); err != nil {
+			return err
+		}
+		log.Debugf("Thawed filesystem at %v", mountpoint)
+	}
+	freezeCounts[mountpoint]--
+	if freezeCounts[mountpoint] == 0 {
+		delete(freezeCounts, mountpoint)
+	}
+	return nil
+}
+
+/*
+GetMountDevice returns the source of the filesystem mounted exactly at
+mountPoint, in the mount namespace of the volumes, or "" if mountPoint is
+only a directory on the filesystem holding it. The last mount at mountPoint
+wins, since it hides the ones beneath.
+*/
+func GetMountDevice(mountPoint string) (string, error) {
+	cmdName, cmdArgs := updateMountNamespace("cat", []string{"/proc/self/mounts"})
+	output, err := mountsExecute(cmdName, cmdArgs)
+	if err != nil {
+		return "", err
+	}
+	return parseMountDevice(output, mountPoint), nil
+}
+
+func parseMountDevice(mounts, mountPoint string) string {
+	device := ""
+	for _, line := range strings.Split(mounts, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if unescapeMountField(fields[1]) == mountPoint {
+			device = unescapeMountField(fields[0])
+		}
+	}
+	return device
+}
+
+// unescapeMountField decodes the octal escapes of the spaces, tabs,
+// newlines and backslashes in the fields of /proc/mounts
+func unescapeMountField(field string) string {
+	if !strings.Contains(field, "\\") {
+		return field
+	}
+	result := []byte{}
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+3 < len(field) {
+			if c, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				result = append(result, byte(c))
+				i += 3
+				continue
+			}
+		}
+		result = append(result, field[i])
+	}
+	return string(result)
+}
+
+// IsBlockDevice checks file is a block device, in the mount namespace of the
+// volumes
+func IsBlockDevice(file string) bool {
+	fileType, err := getFileType(file)
+	return err == nil && fileType == FILE_TYPE_BLOCKDEVICE
+}
@@ -0,0 +1,65 @@
+package util
+
+import (
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestFreeze(c *C) {
+	executed := []string{}
+	oldBinaries, oldExecute := freezeBinaries, freezeExecute
+	defer func() {
+		freezeBinaries, freezeExecute = oldBinaries, oldExecute
+	}()
+	freezeBinaries = []string{"not-existed-freeze", "true"}
+	freezeExecute = func(binary string, args []string) (string, error) {
+		executed = append(executed, binary+" "+strings.Join(args, " "))
+		return "", nil
+	}
+
+	// Nested freezes of the same mount point
+	c.Assert(Freeze("/mnt/a"), IsNil)
+	c.Assert(Freeze("/mnt/a"), IsNil)
+	c.Assert(Freeze("/mnt/b"), IsNil)
+	c.Assert(UnFreeze("/mnt/a"), IsNil)
+	c.Assert(executed, DeepEquals, []string{"true -f /mnt/a", "true -f /mnt/b"})
+	c.Assert(UnFreeze("/mnt/a"), IsNil)
+	c.Assert(UnFreeze("/mnt/b"), IsNil)
+	c.Assert(executed, DeepEquals, []string{"true -f /mnt/a", "true -f /mnt/b", "true -u /mnt/a", "true -u /mnt/b"})
+	c.Assert(UnFreeze("/mnt/a"), ErrorMatches, "Filesystem at /mnt/a is not frozen")
+
+	freezeBinaries = []string{"not-existed-freeze"}
+	c.Assert(Freeze("/mnt/a"), ErrorMatches, "Cannot find not-existed-freeze.*")
+}
+
+func (s *TestSuite) TestGetMountDevice(c *C) {
+	mounts := `/dev/sda1 / ext4 rw,relatime 0 0
+proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+/dev/mapper/vol1 /var/lib/convoy/mounts/vol1 ext4 rw,relatime 0 0
+/dev/sdb /mnt/with\040space xfs rw 0 0
+tmpfs /mnt/stacked tmpfs rw 0 0
+/dev/sdc /mnt/stacked ext4 rw 0 0
+`
+	oldExecute := mountsExecute
+	defer func() {
+		mountsExecute = oldExecute
+	}()
+	mountsExecute = func(binary string, args []string) (string, error) {
+		return mounts, nil
+	}
+
+	device, err := GetMountDevice("/var/lib/convoy/mounts/vol1")
+	c.Assert(err, IsNil)
+	c.Assert(device, Equals, "/dev/mapper/vol1")
+	device, err = GetMountDevice("/mnt/with space")
+	c.Assert(err, IsNil)
+	c.Assert(device, Equals, "/dev/sdb")
+	device, err = GetMountDevice("/mnt/stacked")
+	c.Assert(err, IsNil)
+	c.Assert(device, Equals, "/dev/sdc")
+	// A directory on the filesystem holding it is not a mount point
+	device, err = GetMountDevice("/var/lib/convoy/vfs/vol2")
+	c.Assert(err, IsNil)
+	c.Assert(device, Equals, "")
+}
@@ -0,0 +1,122 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	QEMU_NBD_BINARY = "qemu-nbd"
+
+	SYS_BLOCK_DIR = "/sys/block"
+	NBD_PREFIX    = "nbd"
+
+	NBD_WAIT_RETRIES  = 50
+	NBD_WAIT_INTERVAL = 100 * time.Millisecond
+)
+
+var (
+	// nbdMutex serializes looking for free nbd devices between drivers
+	nbdMutex = &sync.Mutex{}
+)
+
+func ListNBDDevices() ([]string, error) {
+	dirs, err := filepath.Glob(filepath.Join(SYS_BLOCK_DIR, NBD_PREFIX+"*"))
+	if err != nil {
+		return nil, err
+	}
+	devices := []string{}
+	for _, dir := range dirs {
+		devices = append(devices, filepath.Join("/dev", filepath.Base(dir)))
+	}
+	return devices, nil
+}
+
+// nbdPid returns the pid of qemu-nbd serving the device, or 0 if the device
+// is not connected
+func nbdPid(dev string) int {
+	data, err := ioutil.ReadFile(filepath.Join(SYS_BLOCK_DIR, filepath.Base(dev), "pid"))
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// NBDConnectedTo tells if the device is exported from target, which is the
+// file or the URL passed to qemu-nbd
+func NBDConnectedTo(dev, target string) bool {
+	pid := nbdPid(dev)
+	if pid == 0 {
+		return false
+	}
+	cmdline, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return false
+	}
+	for _, arg := range strings.Split(string(cmdline), "\x00") {
+		if arg == target {
+			return true
+		}
+	}
+	return false
+}
+
+// ConnectNBD exports target through the first free nbd device, with the
+// extra options of qemu-nbd, e.g. "-f qcow2" or "-r"
+func ConnectNBD(target string, opts []string) (string, error) {
+	nbdMutex.Lock()
+	defer nbdMutex.Unlock()
+
+	devices, err := ListNBDDevices()
+	if err != nil {
+		return "", err
+	}
+	for _, dev := range devices {
+		if nbdPid(dev) != 0 {
+			continue
+		}
+		args := append([]string{"-c", dev}, opts...)
+		args = append(args, target)
+		if _, err := Execute(QEMU_NBD_BINARY, args); err != nil {
+			// The device may be taken by others in the meantime
+			log.Debugf("Failed to connect %v to %v: %v", target, dev, err)
+			continue
+		}
+		for i := 0; i < NBD_WAIT_RETRIES; i++ {
+			if nbdPid(dev) != 0 {
+				return dev, nil
+			}
+			time.Sleep(NBD_WAIT_INTERVAL)
+		}
+		DisconnectNBD(dev)
+		return "", fmt.Errorf("Timeout waiting for %v to be connected to %v", dev, target)
+	}
+	return "", fmt.Errorf("Cannot find free nbd device for %v", target)
+}
+
+func DisconnectNBD(dev string) error {
+	if _, err := Execute(QEMU_NBD_BINARY, []string{"-d", dev}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func CheckNBD() error {
+	devices, err := ListNBDDevices()
+	if err != nil {
+		return err
+	}
+	if len(devices) == 0 {
+		return fmt.Errorf("Cannot find any nbd device, please load nbd module by \"modprobe nbd\"")
+	}
+	return nil
+}
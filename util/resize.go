@@ -0,0 +1,99 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	BLKID_BINARY      = "blkid"
+	E2FSCK_BINARY     = "e2fsck"
+	RESIZE2FS_BINARY  = "resize2fs"
+	XFS_GROWFS_BINARY = "xfs_growfs"
+
+	GROW_MOUNT_PREFIX = "convoy-grow-"
+)
+
+var (
+	resizeExecute = Execute
+	resizeMount   = callMount
+	resizeUmount  = callUmount
+)
+
+// GetFilesystemType returns the type of the filesystem on the device or the
+// image file, e.g. ext4, or empty if there is no filesystem on it
+func GetFilesystemType(dev string) (string, error) {
+	output, err := resizeExecute(BLKID_BINARY, []string{"-o", "value", "-s", "TYPE", dev})
+	if err != nil {
+		// blkid exits with 2 if no filesystem signature was found
+		if strings.Contains(err.Error(), "exit status 2") {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(output), nil
+}
+
+/*
+GrowFilesystem grows the filesystem on the device or the image file to fill
+it, e.g. after a backup was restored to a volume larger than the original
+one. ext2, ext3 and ext4 would be checked by e2fsck and resized by resize2fs,
+and xfs would be grown by xfs_growfs on a temporary mount point, since it can
+only be grown when mounted. The device without a filesystem would be left as
+it is, e.g. used by a layered driver.
+*/
+func GrowFilesystem(dev string) error {
+	fsType, err := GetFilesystemType(dev)
+	if err != nil {
+		return err
+	}
+	switch fsType {
+	case "":
+		log.Debugf("No filesystem found on %v, skip growing", dev)
+		return nil
+	case "ext2", "ext3", "ext4":
+		// e2fsck exits with 1 if the errors have been corrected
+		if _, err := resizeExecute(E2FSCK_BINARY, []string{"-f", "-p", dev}); err != nil &&
+			!strings.Contains(err.Error(), "exit status 1") {
+			return err
+		}
+		if _, err := resizeExecute(RESIZE2FS_BINARY, []string{dev}); err != nil {
+			return err
+		}
+	case "xfs":
+		if err := growXFS(dev); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Cannot grow filesystem %v on %v", fsType, dev)
+	}
+	log.Debugf("Grew %v filesystem on %v", fsType, dev)
+	return nil
+}
+
+func growXFS(dev string) error {
+	mountPoint := filepath.Join(os.TempDir(), GROW_MOUNT_PREFIX+filepath.Base(dev))
+	if err := callMkdirIfNotExists(mountPoint); err != nil {
+		return err
+	}
+	defer os.Remove(mountPoint)
+
+	opts := []string{}
+	if st, err := os.Stat(dev); err == nil && st.Mode().IsRegular() {
+		opts = append(opts, "-o", "loop")
+	}
+	if _, err := resizeMount(opts, []string{dev, mountPoint}); err != nil {
+		return err
+	}
+	defer func() {
+		if err := resizeUmount([]string{mountPoint}); err != nil {
+			log.Warnf("Failed to umount %v: %v", mountPoint, err)
+		}
+	}()
+
+	cmdName, cmdArgs := updateMountNamespace(XFS_GROWFS_BINARY, []string{mountPoint})
+	_, err := resizeExecute(cmdName, cmdArgs)
+	return err
+}
@@ -0,0 +1,58 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *TestSuite) TestGrowFilesystem(c *C) {
+	executed := []string{}
+	fsType := ""
+	oldExecute, oldMount, oldUmount := resizeExecute, resizeMount, resizeUmount
+	defer func() {
+		resizeExecute, resizeMount, resizeUmount = oldExecute, oldMount, oldUmount
+	}()
+	resizeExecute = func(binary string, args []string) (string, error) {
+		executed = append(executed, binary+" "+strings.Join(args, " "))
+		switch binary {
+		case BLKID_BINARY:
+			if fsType == "" {
+				return "", fmt.Errorf("Failed to execute: blkid, error exit status 2")
+			}
+			return fsType + "\n", nil
+		case E2FSCK_BINARY:
+			return "", fmt.Errorf("Failed to execute: e2fsck, error exit status 1")
+		}
+		return "", nil
+	}
+	resizeMount = func(opts, args []string) (string, error) {
+		executed = append(executed, "mount "+strings.Join(append(opts, args...), " "))
+		return "", nil
+	}
+	resizeUmount = func(args []string) error {
+		executed = append(executed, "umount "+strings.Join(args, " "))
+		return nil
+	}
+
+	// The device without filesystem is left as it is
+	c.Assert(GrowFilesystem("/dev/test"), IsNil)
+	c.Assert(executed, HasLen, 1)
+
+	executed = []string{}
+	fsType = "ext4"
+	c.Assert(GrowFilesystem("/dev/test"), IsNil)
+	c.Assert(executed[1:], DeepEquals, []string{"e2fsck -f -p /dev/test", "resize2fs /dev/test"})
+
+	executed = []string{}
+	fsType = "xfs"
+	c.Assert(GrowFilesystem(s.imageFile), IsNil)
+	c.Assert(executed, HasLen, 4)
+	c.Assert(executed[1], Matches, "mount -o loop "+s.imageFile+" .*/"+GROW_MOUNT_PREFIX+testImage)
+	c.Assert(executed[2], Matches, "xfs_growfs .*/"+GROW_MOUNT_PREFIX+testImage)
+	c.Assert(executed[3], Matches, "umount .*/"+GROW_MOUNT_PREFIX+testImage)
+
+	fsType = "btrfs"
+	c.Assert(GrowFilesystem("/dev/test"), ErrorMatches, "Cannot grow filesystem btrfs on /dev/test")
+}
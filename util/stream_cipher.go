@@ -0,0 +1,183 @@
+package util
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// The plaintext of each segment sealed separately in the stream
+	STREAM_SEGMENT_SIZE = 64 * 1024
+
+	STREAM_CIPHER_MAGIC = "CVS1"
+
+	streamNoncePrefixSize = 7
+	streamNonceSize       = streamNoncePrefixSize + 4 + 1
+)
+
+/*
+The encrypted stream starts with the magic and a random nonce prefix, then
+the segments of the plaintext sealed by the AEAD one by one, so the stream of
+any size can be encrypted or decrypted without buffering all of it. The nonce
+of each segment is the prefix, the index of the segment and a flag set only
+for the last segment, so the segments cannot be reordered, and the stream
+cannot be truncated at a segment boundary without being detected.
+*/
+func streamNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, streamNonceSize)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[streamNoncePrefixSize:], index)
+	if last {
+		nonce[streamNonceSize-1] = 1
+	}
+	return nonce
+}
+
+func checkStreamAEAD(aead cipher.AEAD) error {
+	if aead.NonceSize() != streamNonceSize {
+		return fmt.Errorf("BUG: Invalid nonce size %v of stream cipher", aead.NonceSize())
+	}
+	return nil
+}
+
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+	buf    []byte
+	closed bool
+}
+
+// NewEncryptWriter returns the writer encrypts the data written to it by
+// aead to w, the last segment would be written when it's closed
+func NewEncryptWriter(w io.Writer, aead cipher.AEAD) (io.WriteCloser, error) {
+	if err := checkStreamAEAD(aead); err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, streamNoncePrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append([]byte(STREAM_CIPHER_MAGIC), prefix...)); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		prefix: prefix,
+		buf:    make([]byte, 0, STREAM_SEGMENT_SIZE),
+	}, nil
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	if e.index == ^uint32(0) {
+		return fmt.Errorf("Stream is too large to be encrypted")
+	}
+	sealed := e.aead.Seal(nil, streamNonce(e.prefix, e.index, last), e.buf, nil)
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+	e.index++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, fmt.Errorf("Write to closed stream")
+	}
+	n := 0
+	for len(p) > 0 {
+		// The full segment is only sealed when there is more data, since
+		// the last one must be sealed as the last
+		if len(e.buf) == STREAM_SEGMENT_SIZE {
+			if err := e.seal(false); err != nil {
+				return n, err
+			}
+		}
+		m := copy(e.buf[len(e.buf):STREAM_SEGMENT_SIZE], p)
+		e.buf = e.buf[:len(e.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.seal(true)
+}
+
+type decryptReader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+	buf    []byte
+	sealed []byte
+	last   bool
+}
+
+// NewDecryptReader returns the reader decrypts the stream written by
+// NewEncryptWriter from r. Reading the stream would fail if it has been
+// tampered or truncated.
+func NewDecryptReader(r io.Reader, aead cipher.AEAD) (io.Reader, error) {
+	if err := checkStreamAEAD(aead); err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(STREAM_CIPHER_MAGIC)+streamNoncePrefixSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("Failed to read header of encrypted stream: %v", err)
+	}
+	if string(header[:len(STREAM_CIPHER_MAGIC)]) != STREAM_CIPHER_MAGIC {
+		return nil, fmt.Errorf("Invalid header of encrypted stream")
+	}
+	return &decryptReader{
+		r:      bufio.NewReaderSize(r, STREAM_SEGMENT_SIZE+aead.Overhead()),
+		aead:   aead,
+		prefix: header[len(STREAM_CIPHER_MAGIC):],
+		sealed: make([]byte, STREAM_SEGMENT_SIZE+aead.Overhead()),
+	}, nil
+}
+
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.r, d.sealed)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		d.last = true
+	} else if err != nil {
+		return err
+	} else if _, err := d.r.Peek(1); err == io.EOF {
+		d.last = true
+	}
+	if n == 0 {
+		return fmt.Errorf("Encrypted stream is truncated")
+	}
+	d.buf, err = d.aead.Open(d.buf[:0], streamNonce(d.prefix, d.index, d.last), d.sealed[:n], nil)
+	if err != nil {
+		return fmt.Errorf("Failed to decrypt segment %v of stream, it may be corrupted or truncated: %v", d.index, err)
+	}
+	d.index++
+	return nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.last {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,120 @@
+package util
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func newTestAEAD(c *C) cipher.AEAD {
+	key := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, key)
+	c.Assert(err, IsNil)
+	block, err := aes.NewCipher(key)
+	c.Assert(err, IsNil)
+	aead, err := cipher.NewGCM(block)
+	c.Assert(err, IsNil)
+	return aead
+}
+
+func encryptStream(c *C, aead cipher.AEAD, data []byte) []byte {
+	buf := &bytes.Buffer{}
+	w, err := NewEncryptWriter(buf, aead)
+	c.Assert(err, IsNil)
+	// Written in pieces not aligned to the segments
+	for len(data) > 0 {
+		n := 1000
+		if n > len(data) {
+			n = len(data)
+		}
+		_, err := w.Write(data[:n])
+		c.Assert(err, IsNil)
+		data = data[n:]
+	}
+	c.Assert(w.Close(), IsNil)
+	return buf.Bytes()
+}
+
+func decryptStream(aead cipher.AEAD, data []byte) ([]byte, error) {
+	r, err := NewDecryptReader(bytes.NewReader(data), aead)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func (s *TestSuite) TestStreamCipher(c *C) {
+	aead := newTestAEAD(c)
+	for _, size := range []int{0, 1, STREAM_SEGMENT_SIZE, STREAM_SEGMENT_SIZE + 1, 3 * STREAM_SEGMENT_SIZE} {
+		data := make([]byte, size)
+		_, err := io.ReadFull(rand.Reader, data)
+		c.Assert(err, IsNil)
+
+		encrypted := encryptStream(c, aead, data)
+		result, err := decryptStream(aead, encrypted)
+		c.Assert(err, IsNil, Commentf("size %v", size))
+		c.Assert(bytes.Equal(result, data), Equals, true, Commentf("size %v", size))
+	}
+
+	// A fixed pattern long enough not to show up in the ciphertext by chance
+	data := bytes.Repeat([]byte("convoy plaintext"), STREAM_SEGMENT_SIZE/8)
+	encrypted := encryptStream(c, aead, data)
+	c.Assert(bytes.Contains(encrypted, data[:16]), Equals, false)
+
+	data = make([]byte, 2*STREAM_SEGMENT_SIZE+100)
+	encrypted = encryptStream(c, aead, data)
+
+	tampered := append([]byte{}, encrypted...)
+	tampered[len(tampered)-1] ^= 1
+	_, err := decryptStream(aead, tampered)
+	c.Assert(err, ErrorMatches, "Failed to decrypt segment 2 of stream.*")
+
+	// Truncated at the boundary of the segments
+	segment := STREAM_SEGMENT_SIZE + aead.Overhead()
+	header := len(STREAM_CIPHER_MAGIC) + streamNoncePrefixSize
+	_, err = decryptStream(aead, encrypted[:header+2*segment])
+	c.Assert(err, ErrorMatches, "Failed to decrypt segment 1 of stream.*")
+	_, err = decryptStream(aead, encrypted[:header])
+	c.Assert(err, ErrorMatches, "Encrypted stream is truncated")
+
+	_, err = decryptStream(newTestAEAD(c), encrypted)
+	c.Assert(err, ErrorMatches, "Failed to decrypt segment 0 of stream.*")
+	_, err = decryptStream(aead, []byte("invalid stream"))
+	c.Assert(err, ErrorMatches, "Invalid header of encrypted stream")
+}
+
+func (s *TestSuite) TestCompressDirTo(c *C) {
+	tmpdir := c.MkDir()
+	path := filepath.Join(tmpdir, "path")
+	c.Assert(os.Mkdir(path, 0700), IsNil)
+	data := []byte("Some random string for file")
+	c.Assert(ioutil.WriteFile(filepath.Join(path, "file"), data, 0600), IsNil)
+
+	aead := newTestAEAD(c)
+	buf := &bytes.Buffer{}
+	w, err := NewEncryptWriter(buf, aead)
+	c.Assert(err, IsNil)
+	c.Assert(CompressDirTo(path, w, "gzip -1"), IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	r, err := NewDecryptReader(bytes.NewReader(buf.Bytes()), aead)
+	c.Assert(err, IsNil)
+	target := filepath.Join(tmpdir, "target")
+	c.Assert(DecompressDirFrom(r, target, "gzip"), IsNil)
+	result, err := ioutil.ReadFile(filepath.Join(target, "file"))
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, data)
+
+	// The target is kept if the stream cannot be extracted
+	c.Assert(DecompressDirFrom(bytes.NewReader([]byte("invalid")), target, "gzip"), NotNil)
+	result, err = ioutil.ReadFile(filepath.Join(target, "file"))
+	c.Assert(err, IsNil)
+	c.Assert(result, DeepEquals, data)
+}
@@ -106,20 +106,6 @@ func Sync() error {
 	return nil
 }
 
-func Freeze(mountpoint string) error {
-	if _, err := Execute("fsfreeze", []string{"-f", mountpoint}); err != nil {
-		return err
-	}
-	return nil
-}
-
-func UnFreeze(mountpoint string) error {
-	if _, err := Execute("fsfreeze", []string{"-u", mountpoint}); err != nil {
-		return err
-	}
-	return nil
-}
-
 func SliceToMap(slices []string) map[string]string {
 	result := map[string]string{}
 	for _, v := range slices {
@@ -168,8 +154,70 @@ func CompressDir(sourceDir, targetFile string) error {
 	return nil
 }
 
+// CompressDirBy archives sourceDir to targetFile by tar, compressed by the
+// program with its arguments, e.g. "pigz -6 -p 4", or not compressed if
+// program is empty
+func CompressDirBy(sourceDir, targetFile, program string) error {
+	tmpFile := targetFile + ".tmp"
+	args := []string{"-c"}
+	if program != "" {
+		args = append(args, "-I", program)
+	}
+	args = append(args, "-f", tmpFile, "-C", sourceDir, ".")
+	if _, err := Execute("tar", args); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	return os.Rename(tmpFile, targetFile)
+}
+
+// CompressDirTo archives sourceDir as CompressDirBy does, with the tarball
+// written to w rather than a file
+func CompressDirTo(sourceDir string, w io.Writer, program string) error {
+	args := []string{"-c"}
+	if program != "" {
+		args = append(args, "-I", program)
+	}
+	args = append(args, "-f", "-", "-C", sourceDir, ".")
+	return executeStream("tar", args, nil, w)
+}
+
 // If sourceFile is inside targetDir, it would be deleted automatically
 func DecompressDir(sourceFile, targetDir string) error {
+	return DecompressDirBy(sourceFile, targetDir, "")
+}
+
+// DecompressDirBy is DecompressDir with sourceFile decompressed by program,
+// e.g. "zstd", or by the one detected by tar if program is empty
+func DecompressDirBy(sourceFile, targetDir, program string) error {
+	return extractDir(targetDir, func(tmpDir string) error {
+		args := []string{"-x"}
+		if program != "" {
+			args = append(args, "-I", program)
+		}
+		args = append(args, "-f", sourceFile, "-C", tmpDir)
+		_, err := Execute("tar", args)
+		return err
+	})
+}
+
+// DecompressDirFrom is DecompressDirBy with the tarball read from r. The
+// program must be specified if the tarball is compressed, since tar cannot
+// detect the compression of a stream.
+func DecompressDirFrom(r io.Reader, targetDir, program string) error {
+	return extractDir(targetDir, func(tmpDir string) error {
+		args := []string{"-x"}
+		if program != "" {
+			args = append(args, "-I", program)
+		}
+		args = append(args, "-f", "-", "-C", tmpDir)
+		return executeStream("tar", args, r, nil)
+	})
+}
+
+// extractDir replaces targetDir with the directory extracted by extract, only
+// if the extraction succeeded
+func extractDir(targetDir string, extract func(tmpDir string) error) error {
 	tmpDir := targetDir + ".tmp"
 	if _, err := Execute("rm", []string{"-rf", tmpDir}); err != nil {
 		return err
@@ -177,7 +225,7 @@ func DecompressDir(sourceFile, targetDir string) error {
 	if err := os.Mkdir(tmpDir, os.ModeDir|0700); err != nil {
 		return err
 	}
-	if _, err := Execute("tar", []string{"xf", sourceFile, "-C", tmpDir}); err != nil {
+	if err := extract(tmpDir); err != nil {
 		return err
 	}
 	if _, err := Execute("rm", []string{"-rf", targetDir}); err != nil {
@@ -321,9 +369,18 @@ func CheckBinaryVersion(binaryName, minVersion string, args []string) error {
 }
 
 func Execute(binary string, args []string) (string, error) {
+	return ExecuteWithEnv(binary, args, nil)
+}
+
+// ExecuteWithEnv executes the binary as Execute does, with the environment
+// variables in the form of "key=value" added to the ones of the current process
+func ExecuteWithEnv(binary string, args []string, env []string) (string, error) {
 	var output []byte
 	var err error
 	cmd := exec.Command(binary, args...)
+	if len(env) != 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 	done := make(chan struct{})
 
 	go func() {
@@ -349,6 +406,21 @@ func Execute(binary string, args []string) (string, error) {
 	return string(output), nil
 }
 
+// executeStream executes the binary with its stdin and stdout connected to r
+// and w, either can be nil. There is no timeout since the time depends on
+// the size of the stream.
+func executeStream(binary string, args []string, r io.Reader, w io.Writer) error {
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command(binary, args...)
+	cmd.Stdin = r
+	cmd.Stdout = w
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Failed to execute: %v %v, output %v, error %v", binary, args, stderr.String(), err)
+	}
+	return nil
+}
+
 func Now() string {
 	return time.Now().Format(time.RubyDate)
 }
@@ -324,6 +324,31 @@ func (s *TestSuite) TestCompressDir(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *TestSuite) TestCompressDirBy(c *C) {
+	tmpdir := c.MkDir()
+	path := filepath.Join(tmpdir, "path")
+	c.Assert(os.Mkdir(path, 0700), IsNil)
+	data := []byte("Some random string for file")
+	c.Assert(ioutil.WriteFile(filepath.Join(path, "file"), data, 0600), IsNil)
+
+	for _, program := range []string{"", "gzip -1"} {
+		tarFile := filepath.Join(tmpdir, "test.tar")
+		c.Assert(CompressDirBy(path, tarFile, program), IsNil)
+		_, err := os.Stat(tarFile + ".tmp")
+		c.Assert(os.IsNotExist(err), Equals, true)
+
+		target := filepath.Join(tmpdir, "target")
+		c.Assert(DecompressDirBy(tarFile, target, program), IsNil)
+		result, err := ioutil.ReadFile(filepath.Join(target, "file"))
+		c.Assert(err, IsNil)
+		c.Assert(result, DeepEquals, data)
+	}
+
+	c.Assert(CompressDirBy(filepath.Join(tmpdir, "nonexistent"), filepath.Join(tmpdir, "failed.tar"), ""), NotNil)
+	_, err := os.Stat(filepath.Join(tmpdir, "failed.tar.tmp"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
 var (
 	firstLetters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
 	letters      = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_.-")
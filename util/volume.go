@@ -17,6 +17,8 @@ const (
 	IMAGE_FILE_NAME = "disk.img"
 	BLOCK_DEV_NAME  = "disk.dev"
 
+	SNAPSHOT_MOUNTS_DIR = "snapshot_mounts"
+
 	FILE_TYPE_REGULAR     = "regular file"
 	FILE_TYPE_DIRECTORY   = "directory"
 	FILE_TYPE_BLOCKDEVICE = "block special file"
@@ -192,6 +194,55 @@ func VolumeUmount(v interface{}) error {
 	return nil
 }
 
+/*
+SnapshotMount is a VolumeHelper for mounting the device of a snapshot
+read-only by VolumeMount. The mount point would be a directory named after the
+snapshot under MountsDir if it's not specified.
+*/
+type SnapshotMount struct {
+	Name       string
+	MountPoint string
+	Device     string
+	Filesystem string
+	MountsDir  string
+}
+
+func (m *SnapshotMount) GetDevice() (string, error) {
+	return m.Device, nil
+}
+
+func (m *SnapshotMount) GetMountOpts() []string {
+	return ReadOnlyMountOpts(m.Filesystem)
+}
+
+func (m *SnapshotMount) GenerateDefaultMountPoint() string {
+	return filepath.Join(m.MountsDir, m.Name)
+}
+
+// SnapshotUmount umounts the snapshot, which may have been umounted already,
+// e.g. the host has been rebooted since
+func SnapshotUmount(m *SnapshotMount) error {
+	if m.MountPoint != "" && !isMounted(m.MountPoint) {
+		log.Debugf("Snapshot %v is no longer mounted at %v", m.Name, m.MountPoint)
+		m.MountPoint = ""
+		return nil
+	}
+	return VolumeUmount(m)
+}
+
+// ReadOnlyMountOpts returns the options to mount the filesystem read-only,
+// without replaying its journal, which would write to the device. The XFS
+// snapshots have the same UUID as their volumes, which may be mounted.
+func ReadOnlyMountOpts(filesystem string) []string {
+	switch filesystem {
+	case "ext3", "ext4":
+		return []string{"-o", "ro,noload"}
+	case "xfs":
+		return []string{"-o", "ro,norecovery,nouuid"}
+	}
+	return []string{"-o", "ro"}
+}
+
 func callMkdirIfNotExists(dirName string) error {
 	cmdName := "mkdir"
 	cmdArgs := []string{"-p", dirName}
@@ -134,3 +134,21 @@ func testVolumeVMSupport(r *HelperVolume, s *TestSuite, c *C) {
 	c.Assert(err, IsNil)
 
 }
+
+func (s *TestSuite) TestSnapshotMount(c *C) {
+	m := &SnapshotMount{
+		Name:       "snap1",
+		Device:     "/dev/null",
+		Filesystem: "ext4",
+		MountsDir:  filepath.Join(testMountPath, SNAPSHOT_MOUNTS_DIR, "vol1"),
+	}
+	c.Assert(m.GetMountOpts(), DeepEquals, []string{"-o", "ro,noload"})
+	c.Assert(m.GenerateDefaultMountPoint(), Equals, filepath.Join(testMountPath, SNAPSHOT_MOUNTS_DIR, "vol1", "snap1"))
+	c.Assert(ReadOnlyMountOpts("xfs"), DeepEquals, []string{"-o", "ro,norecovery,nouuid"})
+	c.Assert(ReadOnlyMountOpts("btrfs"), DeepEquals, []string{"-o", "ro"})
+
+	// The mount may be gone with the reboot
+	m.MountPoint = filepath.Join(testMountPath, "nonexistent-snapshot-mount")
+	c.Assert(SnapshotUmount(m), IsNil)
+	c.Assert(m.MountPoint, Equals, "")
+}
@@ -0,0 +1,249 @@
+package vfs
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+)
+
+const (
+	COMPRESSION_NONE = "none"
+	COMPRESSION_GZIP = "gzip"
+	COMPRESSION_PIGZ = "pigz"
+	COMPRESSION_ZSTD = "zstd"
+
+	DEFAULT_COMPRESSION = COMPRESSION_GZIP
+)
+
+var (
+	// The tarballs compressed by gzip or pigz keep the postfix used before
+	// the compression was configurable
+	compressionPostfixes = map[string]string{
+		COMPRESSION_NONE: ".tar",
+		COMPRESSION_GZIP: SNAPSHOT_TARBALL_POSTFIX,
+		COMPRESSION_PIGZ: SNAPSHOT_TARBALL_POSTFIX,
+		COMPRESSION_ZSTD: ".tar.zst",
+	}
+	compressionMaxLevels = map[string]int{
+		COMPRESSION_GZIP: 9,
+		COMPRESSION_PIGZ: 9,
+		COMPRESSION_ZSTD: 19,
+	}
+)
+
+/*
+Compression is how the tarball of a snapshot is compressed, in the form of
+<codec>[:<level>], e.g. "zstd:3". Level 0 means the default level of the
+codec. Threads is only used by pigz and zstd, 0 means all the CPUs.
+*/
+type Compression struct {
+	Codec   string
+	Level   int
+	Threads int
+}
+
+func parseCompression(spec string) (*Compression, error) {
+	c := &Compression{
+		Codec: spec,
+	}
+	if i := strings.Index(spec, ":"); i >= 0 {
+		level, err := strconv.Atoi(spec[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid compression level of %v", spec)
+		}
+		c.Codec, c.Level = spec[:i], level
+	}
+	if _, ok := compressionPostfixes[c.Codec]; !ok {
+		return nil, fmt.Errorf("Unsupported compression %v, should be one of %v, %v, %v and %v",
+			c.Codec, COMPRESSION_NONE, COMPRESSION_GZIP, COMPRESSION_PIGZ, COMPRESSION_ZSTD)
+	}
+	if c.Level < 0 || c.Level > compressionMaxLevels[c.Codec] {
+		return nil, fmt.Errorf("Invalid level %v of compression %v", c.Level, c.Codec)
+	}
+	return c, nil
+}
+
+func (c *Compression) String() string {
+	if c.Level == 0 {
+		return c.Codec
+	}
+	return c.Codec + ":" + strconv.Itoa(c.Level)
+}
+
+// program returns the command line used by tar to compress the tarball
+func (c *Compression) program() string {
+	args := []string{}
+	switch c.Codec {
+	case COMPRESSION_NONE:
+		return ""
+	case COMPRESSION_GZIP:
+		args = append(args, "gzip")
+	case COMPRESSION_PIGZ:
+		args = append(args, "pigz")
+		if c.Threads > 0 {
+			args = append(args, "-p", strconv.Itoa(c.Threads))
+		}
+	case COMPRESSION_ZSTD:
+		args = append(args, "zstd", "-T"+strconv.Itoa(c.Threads))
+	}
+	if c.Level > 0 {
+		args = append(args, "-"+strconv.Itoa(c.Level))
+	}
+	return strings.Join(args, " ")
+}
+
+// decompressProgram returns the command line used by tar to decompress the
+// tarball, which must be specified if the tarball is read from a stream
+func (c *Compression) decompressProgram() string {
+	switch c.Codec {
+	case COMPRESSION_GZIP, COMPRESSION_PIGZ:
+		return "gzip"
+	case COMPRESSION_ZSTD:
+		return "zstd"
+	}
+	return ""
+}
+
+// isGzip checks the tarball compressed by c is in gzip format, which pigz
+// produces as well
+func (c *Compression) isGzip() bool {
+	return c.Codec == COMPRESSION_GZIP || c.Codec == COMPRESSION_PIGZ
+}
+
+func (d *Driver) getDefaultCompression() string {
+	if d.SnapshotCompression == "" {
+		return DEFAULT_COMPRESSION
+	}
+	return d.SnapshotCompression
+}
+
+// getSnapshotCompression returns the compression of the new snapshot, which
+// is specified by the snapshot or the driver
+func (d *Driver) getSnapshotCompression(opts map[string]string) (*Compression, error) {
+	spec := opts[OPT_SNAPSHOT_COMPRESSION]
+	if spec == "" {
+		spec = d.getDefaultCompression()
+	}
+	c, err := parseCompression(spec)
+	if err != nil {
+		return nil, err
+	}
+	c.Threads = d.SnapshotCompressionThreads
+	return c, nil
+}
+
+// getCompression returns the compression of the existing tarball snapshot,
+// the ones created before the compression was configurable are gzipped
+func (snapshot *Snapshot) getCompression() (*Compression, error) {
+	if snapshot.Compression == "" {
+		return &Compression{Codec: COMPRESSION_GZIP}, nil
+	}
+	return parseCompression(snapshot.Compression)
+}
+
+/*
+getBackupFile returns the gzipped tarball of the snapshot to be uploaded as
+the single file backup, so the backups are in the same format regardless of
+the compression of the snapshots. The incremental snapshot would be archived,
+by pigz if it's the compression of the driver, and the tarball not gzipped
+or encrypted would be converted, so the encrypted snapshot would be stored
+in cleartext during the backup. The file must be removed after the backup if
+it's not the tarball of the snapshot.
+*/
+func (d *Driver) getBackupFile(snapshot *Snapshot) (string, error) {
+	if snapshot.Path != "" {
+		c, err := d.getSnapshotCompression(nil)
+		if err != nil {
+			return "", err
+		}
+		if !c.isGzip() {
+			c = &Compression{Codec: COMPRESSION_GZIP}
+		}
+		file := snapshot.Path + SNAPSHOT_TARBALL_POSTFIX
+		if err := compressDir(snapshot.Path, file, c); err != nil {
+			return "", err
+		}
+		return file, nil
+	}
+
+	c, err := snapshot.getCompression()
+	if err != nil {
+		return "", err
+	}
+	if c.isGzip() && snapshot.KeyID == "" {
+		return snapshot.FilePath, nil
+	}
+	var r io.ReadCloser
+	if snapshot.KeyID != "" {
+		r, err = d.openTarball(snapshot)
+	} else {
+		r, err = os.Open(snapshot.FilePath)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	file := getTarballBaseName(snapshot, c) + SNAPSHOT_TARBALL_POSTFIX
+	log.Debugf("Converting %v to %v for backup", snapshot.FilePath, file)
+	if err := convertToGzip(r, file, c); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+func compressDir(dir, file string, c *Compression) error {
+	log.Debugf("Archiving %v to %v with compression %v", dir, file, c)
+	return util.CompressDirBy(dir, file, c.program())
+}
+
+func decompressDir(file, dir string, c *Compression) error {
+	program := ""
+	if c.Codec == COMPRESSION_ZSTD {
+		program = "zstd"
+	}
+	return util.DecompressDirBy(file, dir, program)
+}
+
+// convertToGzip writes the tarball compressed by c read from in to dst in
+// gzip format, so it can be used as a single file backup
+func convertToGzip(in io.Reader, dst string, c *Compression) (err error) {
+	r := in
+	if c.Codec == COMPRESSION_ZSTD {
+		zr, err := zstd.NewReader(in)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := out.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			os.Remove(dst)
+		}
+	}()
+	if c.isGzip() {
+		_, err := io.Copy(out, r)
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, r); err != nil {
+		return err
+	}
+	return zw.Close()
+}
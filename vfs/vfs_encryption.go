@@ -0,0 +1,178 @@
+package vfs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	SNAPSHOT_ENCRYPTED_POSTFIX = ".enc"
+)
+
+/*
+initSnapshotEncryption loads the key of vfs.snapshotkey, which is in the same
+format as the keys of --backup-keys. The salt used to derive the key from the
+passphrase is generated once for the driver, so the same key file always
+derives the same key. Only the tarball snapshots can be encrypted, since the
+files of the incremental snapshots are linked to each other.
+*/
+func (d *Driver) initSnapshotEncryption() error {
+	if d.SnapshotKeyFile == "" {
+		return nil
+	}
+	if d.getSnapshotFormat() != SNAPSHOT_FORMAT_TARBALL {
+		return fmt.Errorf("%v requires %v %v", VFS_SNAPSHOT_KEY, VFS_SNAPSHOT_FORMAT, SNAPSHOT_FORMAT_TARBALL)
+	}
+	key, err := objectstore.LoadEncryptionKey(d.SnapshotKeyFile)
+	if err != nil {
+		return err
+	}
+	if d.SnapshotKeySalt == "" {
+		salt := make([]byte, objectstore.ENCRYPTION_SALT_SIZE)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return err
+		}
+		d.SnapshotKeySalt = hex.EncodeToString(salt)
+	}
+	salt, err := hex.DecodeString(d.SnapshotKeySalt)
+	if err != nil {
+		return fmt.Errorf("Invalid snapshot key salt of driver: %v", err)
+	}
+	derived := key.DeriveKey(salt)
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return err
+	}
+	d.snapshotAEAD, err = cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	d.snapshotKeyID = getSnapshotKeyID(derived)
+	return nil
+}
+
+// getSnapshotKeyID identifies the key without revealing it
+func getSnapshotKeyID(key []byte) string {
+	sum := sha256.Sum256(append([]byte("convoy-vfs-snapshot-key:"), key...))
+	return hex.EncodeToString(sum[:objectstore.KEY_ID_SIZE])
+}
+
+// getSnapshotCipher returns the cipher of the encrypted snapshot, which must
+// be encrypted by the current key of the driver
+func (d *Driver) getSnapshotCipher(snapshot *Snapshot) (cipher.AEAD, error) {
+	if d.snapshotAEAD == nil {
+		return nil, fmt.Errorf("Snapshot %v is encrypted by key %v, but %v is not configured",
+			snapshot.Name, snapshot.KeyID, VFS_SNAPSHOT_KEY)
+	}
+	if snapshot.KeyID != d.snapshotKeyID {
+		return nil, fmt.Errorf("Snapshot %v is encrypted by key %v, but the key of %v is %v",
+			snapshot.Name, snapshot.KeyID, VFS_SNAPSHOT_KEY, d.snapshotKeyID)
+	}
+	return d.snapshotAEAD, nil
+}
+
+// createTarballSnapshot archives the volume directory to the tarball of the
+// snapshot, which would be encrypted if the driver has a key, so the content
+// of the volume is never written to the disk in cleartext
+func (d *Driver) createTarballSnapshot(dir string, snapshot *Snapshot, c *Compression) error {
+	if d.snapshotAEAD == nil {
+		return compressDir(dir, snapshot.FilePath, c)
+	}
+	snapshot.FilePath += SNAPSHOT_ENCRYPTED_POSTFIX
+	snapshot.KeyID = d.snapshotKeyID
+	log.Debugf("Archiving %v to %v with compression %v, encrypted by key %v", dir, snapshot.FilePath, c, snapshot.KeyID)
+
+	tmpFile := snapshot.FilePath + ".tmp"
+	if err := encryptToFile(tmpFile, d.snapshotAEAD, func(w io.Writer) error {
+		return util.CompressDirTo(dir, w, c.program())
+	}); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	return os.Rename(tmpFile, snapshot.FilePath)
+}
+
+func encryptToFile(file string, aead cipher.AEAD, write func(w io.Writer) error) (err error) {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := f.Close(); err == nil {
+			err = e
+		}
+	}()
+	w, err := util.NewEncryptWriter(f, aead)
+	if err != nil {
+		return err
+	}
+	if err := write(w); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// openTarball returns the reader of the decrypted tarball of the snapshot
+func (d *Driver) openTarball(snapshot *Snapshot) (io.ReadCloser, error) {
+	aead, err := d.getSnapshotCipher(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(snapshot.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	r, err := util.NewDecryptReader(f, aead)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &tarballReader{r, f}, nil
+}
+
+type tarballReader struct {
+	io.Reader
+	f *os.File
+}
+
+func (t *tarballReader) Close() error {
+	return t.f.Close()
+}
+
+// extractTarballSnapshot replaces the content of dir with the content of the
+// tarball snapshot
+func (d *Driver) extractTarballSnapshot(snapshot *Snapshot, dir string) error {
+	c, err := snapshot.getCompression()
+	if err != nil {
+		return err
+	}
+	if snapshot.KeyID == "" {
+		return decompressDir(snapshot.FilePath, dir, c)
+	}
+	r, err := d.openTarball(snapshot)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return util.DecompressDirFrom(r, dir, c.decompressProgram())
+}
+
+// getTarballBaseName returns the path of the tarball of the snapshot without
+// the postfixes
+func getTarballBaseName(snapshot *Snapshot, c *Compression) string {
+	file := strings.TrimSuffix(snapshot.FilePath, SNAPSHOT_ENCRYPTED_POSTFIX)
+	return strings.TrimSuffix(file, compressionPostfixes[c.Codec])
+}
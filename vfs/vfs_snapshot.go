@@ -0,0 +1,103 @@
+package vfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rancher/convoy/util"
+)
+
+const (
+	SNAPSHOT_FORMAT_INCREMENTAL = "incremental"
+	SNAPSHOT_FORMAT_TARBALL     = "tarball"
+
+	SNAPSHOT_TARBALL_POSTFIX = ".tar.gz"
+)
+
+func validateSnapshotFormat(format string) error {
+	if format != SNAPSHOT_FORMAT_INCREMENTAL && format != SNAPSHOT_FORMAT_TARBALL {
+		return fmt.Errorf("Invalid %v %v, should be %v or %v", VFS_SNAPSHOT_FORMAT, format,
+			SNAPSHOT_FORMAT_INCREMENTAL, SNAPSHOT_FORMAT_TARBALL)
+	}
+	return nil
+}
+
+func (d *Driver) getSnapshotFormat() string {
+	if d.SnapshotFormat == "" {
+		return SNAPSHOT_FORMAT_INCREMENTAL
+	}
+	return d.SnapshotFormat
+}
+
+func (d *Driver) getSnapshotDirPath(snapshotID, volumeID string) string {
+	return filepath.Join(d.Root, SNAPSHOT_PATH, volumeID+"_"+snapshotID)
+}
+
+// getLatestIncrementalSnapshot returns the latest incremental snapshot of the
+// volume, or nil if there is none
+func getLatestIncrementalSnapshot(volume *Volume) *Snapshot {
+	var (
+		latest     *Snapshot
+		latestTime time.Time
+	)
+	for id := range volume.Snapshots {
+		snapshot := volume.Snapshots[id]
+		if snapshot.Path == "" {
+			continue
+		}
+		t, err := time.Parse(time.RubyDate, snapshot.CreatedTime)
+		if err != nil {
+			continue
+		}
+		if latest == nil || t.After(latestTime) {
+			latest, latestTime = &snapshot, t
+		}
+	}
+	return latest
+}
+
+/*
+createIncrementalSnapshot copies the volume directory to path by rsync, with
+the files unchanged since the latest incremental snapshot of the volume, by
+the size and the modification time, hard linked to the ones of that snapshot
+rather than copied. So it only takes the time and the space of the changed
+files. The files of the snapshots would never be written in place, so they're
+not affected by each other or the volume.
+*/
+func createIncrementalSnapshot(volume *Volume, path string) error {
+	tmpPath := path + ".tmp"
+	if err := os.RemoveAll(tmpPath); err != nil {
+		return err
+	}
+	args := []string{}
+	if base := getLatestIncrementalSnapshot(volume); base != nil {
+		log.Debugf("Creating incremental snapshot of volume %v at %v based on snapshot %v", volume.Name, path, base.Name)
+		args = append(args, "--link-dest="+base.Path)
+	} else {
+		log.Debugf("Creating incremental snapshot of volume %v at %v", volume.Name, path)
+	}
+	if err := rsync(volume.Path, tmpPath, args...); err != nil {
+		os.RemoveAll(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.RemoveAll(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// copyDir makes the content of dst the same as src, the files in dst which
+// don't exist in src would be removed
+func copyDir(src, dst string) error {
+	return rsync(src, dst)
+}
+
+func rsync(src, dst string, args ...string) error {
+	params := append([]string{"-a", "-H", "--numeric-ids", "--delete"}, args...)
+	params = append(params, src+"/", dst+"/")
+	_, err := util.Execute("rsync", params)
+	return err
+}
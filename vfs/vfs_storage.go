@@ -1,6 +1,7 @@
 package vfs
 
 import (
+	"crypto/cipher"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -24,11 +25,20 @@ const (
 
 	VFS_DEFAULT_VOLUME_SIZE = "vfs.defaultvolumesize"
 	DEFAULT_VOLUME_SIZE     = "100G"
+
+	VFS_SNAPSHOT_FORMAT              = "vfs.snapshotformat"
+	VFS_SNAPSHOT_COMPRESSION         = "vfs.snapshotcompression"
+	VFS_SNAPSHOT_COMPRESSION_THREADS = "vfs.snapshotcompressionthreads"
+	VFS_SNAPSHOT_KEY                 = "vfs.snapshotkey"
 )
 
 type Driver struct {
 	mutex *sync.RWMutex
 	Device
+
+	// The cipher of the new snapshots, nil if they're not encrypted
+	snapshotAEAD  cipher.AEAD
+	snapshotKeyID string
 }
 
 func init() {
@@ -46,6 +56,15 @@ type Device struct {
 	Path              string
 	ConfigPath        string
 	DefaultVolumeSize int64
+	// SNAPSHOT_FORMAT_INCREMENTAL if it's empty
+	SnapshotFormat string `json:",omitempty"`
+	// The compression of the tarballs, DEFAULT_COMPRESSION if it's empty
+	SnapshotCompression        string `json:",omitempty"`
+	SnapshotCompressionThreads int    `json:",omitempty"`
+	// The key file to encrypt the tarballs, and the salt to derive the key
+	// from it, see initSnapshotEncryption
+	SnapshotKeyFile string `json:",omitempty"`
+	SnapshotKeySalt string `json:",omitempty"`
 }
 
 func (dev *Device) ConfigFile() (string, error) {
@@ -55,11 +74,22 @@ func (dev *Device) ConfigFile() (string, error) {
 	return filepath.Join(dev.Root, DRIVER_CONFIG_FILE), nil
 }
 
+/*
+Snapshot is either a tarball of the volume directory at FilePath, or an
+incremental copy of it at Path, whose files unchanged since the previous
+incremental snapshot are hard links to the ones of that snapshot.
+*/
 type Snapshot struct {
 	Name        string
 	CreatedTime string
 	VolumeUUID  string
-	FilePath    string
+	FilePath    string `json:",omitempty"`
+	Path        string `json:",omitempty"`
+	// The compression of the tarball, see Compression
+	Compression string `json:",omitempty"`
+	// The ID of the key encrypted the tarball, empty if it's not encrypted
+	KeyID string `json:",omitempty"`
+	SnapshotMetadata
 }
 
 type Volume struct {
@@ -133,6 +163,32 @@ func Init(root string, config map[string]string) (ConvoyDriver, error) {
 		dev.DefaultVolumeSize = volumeSize
 	}
 
+	// The format can be changed later, and only applies to the new snapshots
+	if format, exists := config[VFS_SNAPSHOT_FORMAT]; exists {
+		if err := validateSnapshotFormat(format); err != nil {
+			return nil, err
+		}
+		dev.SnapshotFormat = format
+	}
+	if spec, exists := config[VFS_SNAPSHOT_COMPRESSION]; exists {
+		c, err := parseCompression(spec)
+		if err != nil {
+			return nil, err
+		}
+		dev.SnapshotCompression = c.String()
+	}
+	if threads, exists := config[VFS_SNAPSHOT_COMPRESSION_THREADS]; exists {
+		dev.SnapshotCompressionThreads, err = strconv.Atoi(threads)
+		if err != nil || dev.SnapshotCompressionThreads < 0 {
+			return nil, fmt.Errorf("Invalid %v %v", VFS_SNAPSHOT_COMPRESSION_THREADS, threads)
+		}
+	}
+	// The key can be removed by specifying it as empty, the snapshots
+	// encrypted by it cannot be used without it
+	if file, exists := config[VFS_SNAPSHOT_KEY]; exists {
+		dev.SnapshotKeyFile = file
+	}
+
 	// For upgrade case
 	if dev.DefaultVolumeSize == 0 {
 		dev.DefaultVolumeSize, err = util.ParseSize(DEFAULT_VOLUME_SIZE)
@@ -141,23 +197,32 @@ func Init(root string, config map[string]string) (ConvoyDriver, error) {
 		}
 	}
 
-	if err := util.ObjectSave(dev); err != nil {
-		return nil, err
-	}
 	d := &Driver{
 		mutex:  &sync.RWMutex{},
 		Device: *dev,
 	}
+	if err := d.initSnapshotEncryption(); err != nil {
+		return nil, err
+	}
+	if err := util.ObjectSave(&d.Device); err != nil {
+		return nil, err
+	}
 
 	return d, nil
 }
 
 func (d *Driver) Info() (map[string]string, error) {
-	return map[string]string{
-		"Root":              d.Root,
-		"Path":              d.Path,
-		"DefaultVolumeSize": strconv.FormatInt(d.DefaultVolumeSize, 10),
-	}, nil
+	info := map[string]string{
+		"Root":                d.Root,
+		"Path":                d.Path,
+		"DefaultVolumeSize":   strconv.FormatInt(d.DefaultVolumeSize, 10),
+		"SnapshotFormat":      d.getSnapshotFormat(),
+		"SnapshotCompression": d.getDefaultCompression(),
+	}
+	if d.snapshotKeyID != "" {
+		info["SnapshotKeyID"] = d.snapshotKeyID
+	}
+	return info, nil
 }
 
 func (d *Driver) VolumeOps() (VolumeOperations, error) {
@@ -195,15 +260,22 @@ func (d *Driver) CreateVolume(req Request) error {
 
 	backupURL := opts[OPT_BACKUP_URL]
 	endpointURL := opts[OPT_ENDPOINT_URL]
+	// The delta block backups of the other drivers would be restored as the
+	// image of the volume prepared for VM
+	var objVolume *objectstore.Volume
 	if backupURL != "" {
-		objVolume, err := objectstore.LoadVolume(backupURL, endpointURL)
+		objVolume, err = objectstore.LoadVolume(backupURL, endpointURL)
 		if err != nil {
 			return err
 		}
-		if objVolume.Driver != d.Name() {
-			return fmt.Errorf("Cannot restore backup of %v to %v", objVolume.Driver, d.Name())
+		if objVolume.Driver != d.Name() && opts[OPT_PREPARE_FOR_VM] != "true" {
+			return fmt.Errorf("Cannot restore backup of %v to %v, unless the volume is prepared for VM", objVolume.Driver, d.Name())
+		}
+		if err := objectstore.CheckRestoreDriver(objVolume, backupURL, endpointURL, d.Name()); err != nil {
+			return err
 		}
 	}
+	restoreImage := objVolume != nil && objVolume.Driver != d.Name()
 
 	exists, err := util.ObjectExists(volume)
 	if err != nil {
@@ -217,7 +289,15 @@ func (d *Driver) CreateVolume(req Request) error {
 	if err != nil {
 		return err
 	}
-	if volume.PrepareForVM {
+	if restoreImage {
+		volume.Size, err = d.getSize(opts, objVolume.Size)
+		if err != nil {
+			return err
+		}
+		if volume.Size < objVolume.Size {
+			return fmt.Errorf("Volume size cannot be less than backup's size %v", objVolume.Size)
+		}
+	} else if volume.PrepareForVM {
 		volume.Size, err = d.getSize(opts, d.DefaultVolumeSize)
 		if err != nil {
 			return err
@@ -233,7 +313,13 @@ func (d *Driver) CreateVolume(req Request) error {
 	volume.Snapshots = make(map[string]Snapshot)
 	volume.Name = id
 
-	if backupURL != "" {
+	if restoreImage {
+		imageFile := filepath.Join(volumePath, util.IMAGE_FILE_NAME)
+		if err := objectstore.RestoreDeltaBlockBackupToSize(backupURL, endpointURL, imageFile, volume.Size); err != nil {
+			os.Remove(imageFile)
+			return err
+		}
+	} else if backupURL != "" {
 		file, err := objectstore.RestoreSingleFileBackup(backupURL, endpointURL, volumePath)
 		if err != nil {
 			return err
@@ -290,8 +376,9 @@ func (d *Driver) MountVolume(req Request) (string, error) {
 		return "", err
 	}
 
+	// The volume would be mounted at its path again after reverted
 	specifiedPoint := opts[OPT_MOUNT_POINT]
-	if specifiedPoint != "" {
+	if specifiedPoint != "" && specifiedPoint != volume.Path {
 		return "", fmt.Errorf("VFS doesn't support specified mount point")
 	}
 	if volume.MountPoint == "" {
@@ -400,8 +487,8 @@ func (d *Driver) SnapshotOps() (SnapshotOperations, error) {
 	return d, nil
 }
 
-func (d *Driver) getSnapshotFilePath(snapshotID, volumeID string) string {
-	return filepath.Join(d.Root, SNAPSHOT_PATH, volumeID+"_"+snapshotID+".tar.gz")
+func (d *Driver) getSnapshotFilePath(snapshotID, volumeID string, c *Compression) string {
+	return filepath.Join(d.Root, SNAPSHOT_PATH, volumeID+"_"+snapshotID+compressionPostfixes[c.Codec])
 }
 
 func (d *Driver) CreateSnapshot(req Request) error {
@@ -421,8 +508,7 @@ func (d *Driver) CreateSnapshot(req Request) error {
 	if _, exists := volume.Snapshots[id]; exists {
 		return fmt.Errorf("Snapshot %v already exists for volume %v", id, volumeID)
 	}
-	snapFile := d.getSnapshotFilePath(id, volumeID)
-	if err := util.MkdirIfNotExists(filepath.Dir(snapFile)); err != nil {
+	if err := util.MkdirIfNotExists(filepath.Join(d.Root, SNAPSHOT_PATH)); err != nil {
 		return err
 	}
 
@@ -433,16 +519,29 @@ func (d *Driver) CreateSnapshot(req Request) error {
 		}
 	}
 
-	if err := util.CompressDir(volume.Path, snapFile); err != nil {
-		return err
+	snapshot := Snapshot{
+		Name:             id,
+		VolumeUUID:       volumeID,
+		SnapshotMetadata: NewSnapshotMetadata(req.Options),
 	}
-
-	volume.Snapshots[id] = Snapshot{
-		Name:        id,
-		CreatedTime: util.Now(),
-		VolumeUUID:  volumeID,
-		FilePath:    snapFile,
+	if d.getSnapshotFormat() == SNAPSHOT_FORMAT_TARBALL {
+		c, err := d.getSnapshotCompression(req.Options)
+		if err != nil {
+			return err
+		}
+		snapshot.FilePath = d.getSnapshotFilePath(id, volumeID, c)
+		snapshot.Compression = c.String()
+		if err := d.createTarballSnapshot(volume.Path, &snapshot, c); err != nil {
+			return err
+		}
+	} else {
+		snapshot.Path = d.getSnapshotDirPath(id, volumeID)
+		if err := createIncrementalSnapshot(volume, snapshot.Path); err != nil {
+			return err
+		}
 	}
+	snapshot.CreatedTime = util.Now()
+	volume.Snapshots[id] = snapshot
 
 	lockFile, err := flock(volume)
 	if err != nil {
@@ -472,7 +571,11 @@ func (d *Driver) deleteSnapshot(id, volumeID string) error {
 	if !exists {
 		return fmt.Errorf("Snapshot %v doesn't exists for volume %v", id, volumeID)
 	}
-	if err := os.Remove(snapshot.FilePath); err != nil {
+	if snapshot.Path != "" {
+		if err := os.RemoveAll(snapshot.Path); err != nil {
+			return err
+		}
+	} else if err := os.Remove(snapshot.FilePath); err != nil {
 		return err
 	}
 	delete(volume.Snapshots, id)
@@ -485,6 +588,102 @@ func (d *Driver) deleteSnapshot(id, volumeID string) error {
 	return util.ObjectSave(volume)
 }
 
+// RevertSnapshot replaces the content of the volume with the content of the
+// snapshot
+func (d *Driver) RevertSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+	volume := d.blankVolume(volumeID)
+
+	lockFile, err := flock(volume)
+	if err != nil {
+		return fmt.Errorf("Couldn't get flock. Error: %v", err)
+	}
+	defer util.UnlockFile(lockFile)
+
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	snapshot, exists := volume.Snapshots[req.Name]
+	if !exists {
+		return fmt.Errorf("Snapshot %v doesn't exists for volume %v", req.Name, volumeID)
+	}
+	if volume.MountPoint != "" {
+		return fmt.Errorf("Cannot revert volume %v, which is mounted at %v", volumeID, volume.MountPoint)
+	}
+
+	if snapshot.Path != "" {
+		log.Debugf("Reverting volume %v to snapshot %v by copying %v", volumeID, req.Name, snapshot.Path)
+		return copyDir(snapshot.Path, volume.Path)
+	}
+	log.Debugf("Reverting volume %v to snapshot %v by extracting %v", volumeID, req.Name, snapshot.FilePath)
+	return d.extractTarballSnapshot(&snapshot, volume.Path)
+}
+
+// CreateVolumeFromSnapshot copies the content of the snapshot as the content
+// of the new volume
+func (d *Driver) CreateVolumeFromSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	snapshotID, err := util.GetFieldFromOpts(OPT_SNAPSHOT_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+	volumeID, err := util.GetFieldFromOpts(OPT_SNAPSHOT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+	origin := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(origin); err != nil {
+		return err
+	}
+	snapshot, exists := origin.Snapshots[snapshotID]
+	if !exists {
+		return fmt.Errorf("Snapshot %v doesn't exists for volume %v", snapshotID, volumeID)
+	}
+
+	volume := d.blankVolume(id)
+	lockFile, err := flock(volume)
+	if err != nil {
+		return fmt.Errorf("Couldn't get flock. Error: %v", err)
+	}
+	defer util.UnlockFile(lockFile)
+
+	exists, err = util.ObjectExists(volume)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Already has volume with specific uuid %v", id)
+	}
+
+	volumePath := filepath.Join(d.Path, id)
+	if snapshot.Path != "" {
+		log.Debugf("Creating volume %v by copying %v", id, snapshot.Path)
+		if err := copyDir(snapshot.Path, volumePath); err != nil {
+			return err
+		}
+	} else {
+		log.Debugf("Creating volume %v by extracting %v", id, snapshot.FilePath)
+		if err := d.extractTarballSnapshot(&snapshot, volumePath); err != nil {
+			return err
+		}
+	}
+	volume.Path = volumePath
+	volume.Size = origin.Size
+	volume.PrepareForVM = origin.PrepareForVM
+	volume.CreatedTime = util.Now()
+	volume.Snapshots = make(map[string]Snapshot)
+	return util.ObjectSave(volume)
+}
+
 func (d *Driver) GetSnapshotInfo(req Request) (map[string]string, error) {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
@@ -506,12 +705,28 @@ func (d *Driver) getSnapshotInfo(id, volumeID string) (map[string]string, error)
 	if !exists {
 		return nil, fmt.Errorf("Snapshot %v doesn't exists for volume %v", id, volumeID)
 	}
-	return map[string]string{
+	info := map[string]string{
 		OPT_SNAPSHOT_NAME:         snapshot.Name,
+		OPT_SNAPSHOT_LABELS:       snapshot.Labels,
+		OPT_SNAPSHOT_DESCRIPTION:  snapshot.Description,
 		OPT_SNAPSHOT_CREATED_TIME: snapshot.CreatedTime,
 		"VolumeUUID":              snapshot.VolumeUUID,
-		"FilePath":                snapshot.FilePath,
-	}, nil
+	}
+	if snapshot.Path != "" {
+		info["Format"] = SNAPSHOT_FORMAT_INCREMENTAL
+		info["Path"] = snapshot.Path
+	} else {
+		info["Format"] = SNAPSHOT_FORMAT_TARBALL
+		info["FilePath"] = snapshot.FilePath
+		if c, err := snapshot.getCompression(); err == nil {
+			info["Compression"] = c.String()
+		}
+		info["Encrypted"] = strconv.FormatBool(snapshot.KeyID != "")
+		if snapshot.KeyID != "" {
+			info["KeyID"] = snapshot.KeyID
+		}
+	}
+	return info, nil
 }
 
 func (d *Driver) ListSnapshot(opts map[string]string) (map[string]map[string]string, error) {
@@ -562,6 +777,13 @@ func (d *Driver) CreateBackup(snapshotID, volumeID, destURL, endpointURL string,
 	if !exists {
 		return "", fmt.Errorf("Cannot find snapshot %v for volume %v", snapshotID, volumeID)
 	}
+	file, err := d.getBackupFile(&snapshot)
+	if err != nil {
+		return "", err
+	}
+	if file != snapshot.FilePath {
+		defer os.Remove(file)
+	}
 	objVolume := &objectstore.Volume{
 		Name:        volume.Name,
 		Driver:      d.Name(),
@@ -570,11 +792,12 @@ func (d *Driver) CreateBackup(snapshotID, volumeID, destURL, endpointURL string,
 	objSnapshot := &objectstore.Snapshot{
 		Name:        snapshotID,
 		CreatedTime: opts[OPT_SNAPSHOT_CREATED_TIME],
+		Labels:      objectstore.DecodeLabels(opts[OPT_BACKUP_LABELS]),
 	}
-	return objectstore.CreateSingleFileBackup(objVolume, objSnapshot, snapshot.FilePath, destURL, endpointURL)
+	return objectstore.CreateSingleFileBackup(objVolume, objSnapshot, file, destURL, endpointURL)
 }
 
-func (d *Driver) DeleteBackup(backupURL, endpointURL string) error {
+func (d *Driver) DeleteBackup(backupURL, endpointURL string, opts map[string]string) error {
 	objVolume, err := objectstore.LoadVolume(backupURL, endpointURL)
 	if err != nil {
 		return err
@@ -0,0 +1,170 @@
+package webdav
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/objectstore"
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "webdav"})
+)
+
+type WebDAVObjectStoreDriver struct {
+	kind    string
+	destURL string
+	service WebDAVService
+}
+
+const (
+	KIND     = "webdav"
+	KIND_TLS = "webdavs"
+
+	ENV_USERNAME    = "WEBDAV_USERNAME"
+	ENV_PASSWORD    = "WEBDAV_PASSWORD"
+	ENV_CLIENT_CERT = "WEBDAV_CLIENT_CERT"
+	ENV_CLIENT_KEY  = "WEBDAV_CLIENT_KEY"
+	ENV_CA_CERT     = "WEBDAV_CA_CERT"
+)
+
+func init() {
+	if err := objectstore.RegisterDriver(KIND, initFunc); err != nil {
+		panic(err)
+	}
+	if err := objectstore.RegisterDriver(KIND_TLS, initFunc); err != nil {
+		panic(err)
+	}
+}
+
+func initFunc(destURL, endpoint string) (objectstore.ObjectStoreDriver, error) {
+	return initFuncWithConnectionCheck(destURL, endpoint, func(d objectstore.ObjectStoreDriver) error {
+		_, err := d.List("")
+		return err
+	})
+}
+
+func initFuncWithConnectionCheck(destURL, endpoint string, connectionTest func(d objectstore.ObjectStoreDriver) error) (objectstore.ObjectStoreDriver, error) {
+	b := &WebDAVObjectStoreDriver{}
+
+	u, err := url.Parse(destURL)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := ""
+	switch u.Scheme {
+	case KIND:
+		scheme = "http"
+	case KIND_TLS:
+		scheme = "https"
+	default:
+		return nil, fmt.Errorf("BUG: Why dispatch %v to %v?", u.Scheme, KIND)
+	}
+	b.kind = u.Scheme
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("Invalid URL. Must be %v://host[:port]/path/", u.Scheme)
+	}
+	if u.User != nil {
+		return nil, fmt.Errorf("Credentials shouldn't be in the URL, use %v and %v instead",
+			ENV_USERNAME, ENV_PASSWORD)
+	}
+	path := strings.Trim(u.Path, "/")
+
+	b.service.BaseURL = (&url.URL{Scheme: scheme, Host: u.Host, Path: "/" + path}).String()
+	b.service.Username = os.Getenv(ENV_USERNAME)
+	b.service.Password = os.Getenv(ENV_PASSWORD)
+	b.service.ClientCert = os.Getenv(ENV_CLIENT_CERT)
+	b.service.ClientKey = os.Getenv(ENV_CLIENT_KEY)
+	b.service.CACert = os.Getenv(ENV_CA_CERT)
+	if err := b.service.Init(); err != nil {
+		return nil, err
+	}
+
+	if err := connectionTest(b); err != nil {
+		return nil, err
+	}
+
+	b.destURL = b.kind + "://" + u.Host + "/" + path
+	log.Debugf("Loaded driver for %v", b.destURL)
+	return b, nil
+}
+
+func (s *WebDAVObjectStoreDriver) Kind() string {
+	return s.kind
+}
+
+func (s *WebDAVObjectStoreDriver) GetURL() string {
+	return s.destURL
+}
+
+func (s *WebDAVObjectStoreDriver) List(listPath string) ([]string, error) {
+	result, err := s.service.List(listPath)
+	if err != nil {
+		log.Error("Fail to list webdav: ", err)
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *WebDAVObjectStoreDriver) FileExists(filePath string) bool {
+	return s.FileSize(filePath) >= 0
+}
+
+func (s *WebDAVObjectStoreDriver) FileSize(filePath string) int64 {
+	size, err := s.service.FileSize(filePath)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+func (s *WebDAVObjectStoreDriver) Remove(names ...string) error {
+	for _, name := range names {
+		if err := s.service.Delete(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *WebDAVObjectStoreDriver) Read(src string) (io.ReadCloser, error) {
+	return s.service.Get(src)
+}
+
+func (s *WebDAVObjectStoreDriver) Write(dst string, rs io.ReadSeeker) error {
+	return s.service.Put(dst, rs)
+}
+
+func (s *WebDAVObjectStoreDriver) Upload(src, dst string) error {
+	file, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return s.service.Put(dst, file)
+}
+
+func (s *WebDAVObjectStoreDriver) Download(src, dst string) error {
+	if _, err := os.Stat(dst); err != nil {
+		os.Remove(dst)
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rc, err := s.service.Get(src)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	_, err = io.Copy(f, rc)
+	return err
+}
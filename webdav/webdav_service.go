@@ -0,0 +1,277 @@
+package webdav
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+type WebDAVService struct {
+	// BaseURL is the http(s) URL of the backup root collection
+	BaseURL    string
+	Username   string
+	Password   string
+	ClientCert string
+	ClientKey  string
+	CACert     string
+
+	client *http.Client
+}
+
+type davResponse struct {
+	Href     string `xml:"href"`
+	Propstat []struct {
+		Prop struct {
+			ContentLength string `xml:"getcontentlength"`
+			ResourceType  struct {
+				Collection *struct{} `xml:"collection"`
+			} `xml:"resourcetype"`
+		} `xml:"prop"`
+		Status string `xml:"status"`
+	} `xml:"propstat"`
+}
+
+type davMultistatus struct {
+	Responses []davResponse `xml:"response"`
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/><D:getcontentlength/></D:prop></D:propfind>`
+
+func (s *WebDAVService) Init() error {
+	tlsConfig := &tls.Config{}
+	if s.ClientCert != "" || s.ClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(s.ClientCert, s.ClientKey)
+		if err != nil {
+			return fmt.Errorf("Failed to load WebDAV client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if s.CACert != "" {
+		pem, err := ioutil.ReadFile(s.CACert)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("No certificate found in %v", s.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	s.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+	return nil
+}
+
+func (s *WebDAVService) httpClient() *http.Client {
+	if s.client == nil {
+		s.client = &http.Client{}
+	}
+	return s.client
+}
+
+func escapePath(key string) string {
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		parts[i] = strings.Replace(url.QueryEscape(p), "+", "%20", -1)
+	}
+	return strings.Join(parts, "/")
+}
+
+func (s *WebDAVService) url(key string) string {
+	u := strings.TrimRight(s.BaseURL, "/")
+	if key != "" {
+		u += "/" + escapePath(strings.Trim(key, "/"))
+	}
+	return u
+}
+
+func (s *WebDAVService) do(method, u string, headers map[string]string, body io.Reader, length int64) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = length
+	}
+	if s.Username != "" {
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return s.httpClient().Do(req)
+}
+
+func responseError(method, u string, resp *http.Response) error {
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("WebDAV Error: %v %v %v, %v", method, u, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+func (s *WebDAVService) propfind(key, depth string) ([]davResponse, error) {
+	u := s.url(key)
+	if depth != "0" {
+		u += "/"
+	}
+	resp, err := s.do("PROPFIND", u, map[string]string{
+		"Depth":        depth,
+		"Content-Type": "application/xml; charset=utf-8",
+	}, strings.NewReader(propfindBody), int64(len(propfindBody)))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, responseError("PROPFIND", u, resp)
+	}
+	defer resp.Body.Close()
+	ms := &davMultistatus{}
+	if err := xml.NewDecoder(resp.Body).Decode(ms); err != nil {
+		return nil, err
+	}
+	return ms.Responses, nil
+}
+
+func hrefPath(href string) string {
+	if u, err := url.Parse(href); err == nil {
+		href = u.Path
+	}
+	return strings.TrimRight(href, "/")
+}
+
+// List returns the names of the members of the collection
+func (s *WebDAVService) List(key string) ([]string, error) {
+	responses, err := s.propfind(key, "1")
+	if err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(s.url(key))
+	if err != nil {
+		return nil, err
+	}
+	self := strings.TrimRight(base.Path, "/")
+	result := []string{}
+	for _, r := range responses {
+		p := hrefPath(r.Href)
+		if p == self {
+			continue
+		}
+		result = append(result, path.Base(p))
+	}
+	return result, nil
+}
+
+// FileSize returns the size of the file, or error if it doesn't exist or
+// it's a collection
+func (s *WebDAVService) FileSize(key string) (int64, error) {
+	responses, err := s.propfind(key, "0")
+	if err != nil {
+		return -1, err
+	}
+	for _, r := range responses {
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			if ps.Prop.ResourceType.Collection != nil {
+				return -1, fmt.Errorf("%v is a collection", key)
+			}
+			var size int64
+			if _, err := fmt.Sscan(ps.Prop.ContentLength, &size); err != nil {
+				return -1, err
+			}
+			return size, nil
+		}
+	}
+	return -1, fmt.Errorf("Cannot find size of %v", key)
+}
+
+func (s *WebDAVService) Get(key string) (io.ReadCloser, error) {
+	u := s.url(key)
+	resp, err := s.do("GET", u, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError("GET", u, resp)
+	}
+	return resp.Body, nil
+}
+
+func (s *WebDAVService) mkcols(key string) error {
+	dirs := strings.Split(strings.Trim(path.Dir(key), "/"), "/")
+	for i := range dirs {
+		u := s.url(strings.Join(dirs[:i+1], "/")) + "/"
+		resp, err := s.do("MKCOL", u, nil, nil, 0)
+		if err != nil {
+			return err
+		}
+		// 405 means the collection already exists
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+			return responseError("MKCOL", u, resp)
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// Put uploads the content, and creates the missing parent collections if
+// the server responses 409 Conflict, or 404 as some servers do
+func (s *WebDAVService) Put(key string, rs io.ReadSeeker) error {
+	u := s.url(key)
+	for retry := 0; ; retry++ {
+		length, err := rs.Seek(0, 2)
+		if err != nil {
+			return err
+		}
+		if _, err := rs.Seek(0, 0); err != nil {
+			return err
+		}
+		resp, err := s.do("PUT", u, nil, ioutil.NopCloser(rs), length)
+		if err != nil {
+			return err
+		}
+		if (resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusNotFound) && retry == 0 {
+			resp.Body.Close()
+			if err := s.mkcols(key); err != nil {
+				return err
+			}
+			continue
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return responseError("PUT", u, resp)
+		}
+		resp.Body.Close()
+		return nil
+	}
+}
+
+// Delete removes the file or collection recursively, it's not an error if
+// the resource doesn't exist
+func (s *WebDAVService) Delete(key string) error {
+	u := s.url(key)
+	resp, err := s.do("DELETE", u, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return responseError("DELETE", u, resp)
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,996 @@
+package zfs
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/convoy/metadata"
+	"github.com/rancher/convoy/objectstore"
+	"github.com/rancher/convoy/util"
+
+	. "github.com/rancher/convoy/convoydriver"
+	. "github.com/rancher/convoy/logging"
+)
+
+const (
+	DRIVER_NAME        = "zfs"
+	DRIVER_CONFIG_FILE = "zfs.cfg"
+
+	VOLUME_CFG_PREFIX = "volume_"
+	ZFS_CFG_PREFIX    = DRIVER_NAME + "_"
+	CFG_POSTFIX       = ".json"
+
+	MOUNTS_DIR = "mounts"
+	ZVOL_DIR   = "/dev/zvol"
+
+	ZFS_BINARY = "zfs"
+
+	ZFS_DATASET              = "zfs.dataset"
+	ZFS_DEFAULT_VOLUME_SIZE  = "zfs.defaultvolumesize"
+	ZFS_DEFAULT_FS_TYPE      = "zfs.fs"
+	ZFS_DEFAULT_VOLBLOCKSIZE = "zfs.volblocksize"
+
+	DEFAULT_VOLUME_SIZE = "100G"
+	DEFAULT_FS_TYPE     = "ext4"
+
+	// The zvol device would be created by udev asynchronously
+	DEVICE_WAIT_RETRIES  = 100
+	DEVICE_WAIT_INTERVAL = 100 * time.Millisecond
+)
+
+var (
+	log = logrus.WithFields(logrus.Fields{"pkg": "zfs"})
+)
+
+type Driver struct {
+	mutex *sync.RWMutex
+	Device
+}
+
+type Device struct {
+	Root              string
+	Dataset           string
+	DefaultVolumeSize int64
+	VolBlockSize      string
+	Filesystem        string
+}
+
+func (dev *Device) ConfigFile() (string, error) {
+	if dev.Root == "" {
+		return "", fmt.Errorf("BUG: Invalid empty device config path")
+	}
+	return filepath.Join(dev.Root, DRIVER_CONFIG_FILE), nil
+}
+
+type Volume struct {
+	Name        string
+	Dataset     string
+	Origin      string
+	Size        int64
+	MountPoint  string
+	CreatedTime string
+	Filesystem  string
+	Snapshots   map[string]Snapshot
+
+	configPath string
+}
+
+type Snapshot struct {
+	Name        string
+	CreatedTime string
+	// Where the snapshot is mounted read-only, see MountSnapshot
+	MountPoint string `json:",omitempty"`
+	SnapshotMetadata
+}
+
+func (v *Volume) ConfigFile() (string, error) {
+	if v.Name == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume name")
+	}
+	if v.configPath == "" {
+		return "", fmt.Errorf("BUG: Invalid empty volume config path")
+	}
+	return filepath.Join(v.configPath, ZFS_CFG_PREFIX+VOLUME_CFG_PREFIX+v.Name+CFG_POSTFIX), nil
+}
+
+func (v *Volume) GetDevice() (string, error) {
+	return zvolPath(v.Dataset), nil
+}
+
+func (v *Volume) GetMountOpts() []string {
+	return []string{}
+}
+
+func (v *Volume) GenerateDefaultMountPoint() string {
+	return filepath.Join(v.configPath, MOUNTS_DIR, v.Name)
+}
+
+func init() {
+	if err := Register(DRIVER_NAME, Init); err != nil {
+		panic(err)
+	}
+}
+
+func (d *Driver) Name() string {
+	return DRIVER_NAME
+}
+
+func (device *Device) listVolumeNames() ([]string, error) {
+	return util.ListConfigIDs(device.Root, ZFS_CFG_PREFIX+VOLUME_CFG_PREFIX, CFG_POSTFIX)
+}
+
+func (d *Driver) blankVolume(name string) *Volume {
+	return &Volume{
+		configPath: d.Root,
+		Name:       name,
+	}
+}
+
+func zvolPath(dataset string) string {
+	return filepath.Join(ZVOL_DIR, dataset)
+}
+
+func snapshotName(dataset, snapshot string) string {
+	return dataset + "@" + snapshot
+}
+
+func zfs(args ...string) (string, error) {
+	return util.Execute(ZFS_BINARY, args)
+}
+
+func datasetExists(dataset string) bool {
+	_, err := zfs("list", "-H", "-o", "name", dataset)
+	return err == nil
+}
+
+func waitForDevice(dev string) error {
+	for i := 0; i < DEVICE_WAIT_RETRIES; i++ {
+		if _, err := os.Stat(dev); err == nil {
+			return nil
+		}
+		time.Sleep(DEVICE_WAIT_INTERVAL)
+	}
+	return fmt.Errorf("Timeout waiting for device %v", dev)
+}
+
+func verifyConfig(config map[string]string) (*Device, error) {
+	dv := &Device{
+		Dataset: strings.Trim(config[ZFS_DATASET], "/"),
+	}
+	if dv.Dataset == "" {
+		return nil, fmt.Errorf("ZFS dataset unspecified")
+	}
+	if !datasetExists(dv.Dataset) {
+		return nil, fmt.Errorf("ZFS dataset %v doesn't exist", dv.Dataset)
+	}
+
+	if _, exists := config[ZFS_DEFAULT_VOLUME_SIZE]; !exists {
+		config[ZFS_DEFAULT_VOLUME_SIZE] = DEFAULT_VOLUME_SIZE
+	}
+	volumeSize, err := util.ParseSize(config[ZFS_DEFAULT_VOLUME_SIZE])
+	if err != nil || volumeSize == 0 {
+		return nil, fmt.Errorf("Illegal default volume size specified")
+	}
+	dv.DefaultVolumeSize = volumeSize
+
+	// Use the default of ZFS if unspecified
+	dv.VolBlockSize = config[ZFS_DEFAULT_VOLBLOCKSIZE]
+
+	if _, exists := config[ZFS_DEFAULT_FS_TYPE]; !exists {
+		config[ZFS_DEFAULT_FS_TYPE] = DEFAULT_FS_TYPE
+	}
+	fsType := config[ZFS_DEFAULT_FS_TYPE]
+	if fsType != "ext4" && fsType != "xfs" {
+		return nil, fmt.Errorf("Unsupported filesystem type specified")
+	}
+	dv.Filesystem = fsType
+	return dv, nil
+}
+
+func (d *Driver) remountVolumes() error {
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return err
+	}
+	for _, id := range volumeIDs {
+		volume := d.blankVolume(id)
+		if err := util.ObjectLoad(volume); err != nil {
+			return err
+		}
+		if volume.MountPoint == "" {
+			continue
+		}
+		if err := waitForDevice(zvolPath(volume.Dataset)); err != nil {
+			return err
+		}
+		req := Request{
+			Name:    id,
+			Options: map[string]string{},
+		}
+		if _, err := d.MountVolume(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Init(root string, config map[string]string) (ConvoyDriver, error) {
+	if _, err := exec.LookPath(ZFS_BINARY); err != nil {
+		return nil, fmt.Errorf("Cannot find %v binary, please make sure ZFS is installed", ZFS_BINARY)
+	}
+
+	if err := util.MkdirIfNotExists(root); err != nil {
+		return nil, err
+	}
+	dev := &Device{
+		Root: root,
+	}
+	exists, err := util.ObjectExists(dev)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if err := util.ObjectLoad(dev); err != nil {
+			return nil, err
+		}
+		d := &Driver{
+			mutex:  &sync.RWMutex{},
+			Device: *dev,
+		}
+		if err := d.remountVolumes(); err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+
+	dev, err = verifyConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	dev.Root = root
+
+	if err := util.ObjectSave(dev); err != nil {
+		return nil, err
+	}
+	d := &Driver{
+		mutex:  &sync.RWMutex{},
+		Device: *dev,
+	}
+	return d, nil
+}
+
+func (d *Driver) Info() (map[string]string, error) {
+	return map[string]string{
+		"Driver":            d.Name(),
+		"Root":              d.Root,
+		"Dataset":           d.Dataset,
+		"DefaultVolumeSize": strconv.FormatInt(d.DefaultVolumeSize, 10),
+		"VolBlockSize":      d.VolBlockSize,
+		"Filesystem":        d.Filesystem,
+	}, nil
+}
+
+func (d *Driver) VolumeOps() (VolumeOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) SnapshotOps() (SnapshotOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) getSize(opts map[string]string, defaultVolumeSize int64) (int64, error) {
+	size := opts[OPT_SIZE]
+	if size == "" || size == "0" {
+		size = strconv.FormatInt(defaultVolumeSize, 10)
+	}
+	return util.ParseSize(size)
+}
+
+// getCloneOrigin accepts either the ZFS snapshot, or the snapshot of a Convoy
+// volume as "<volume>@<snapshot>"
+func (d *Driver) getCloneOrigin(origin string) (string, error) {
+	parts := strings.Split(origin, "@")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("Invalid ZFS snapshot %v to clone, must be <dataset>@<snapshot>", origin)
+	}
+	if !strings.Contains(parts[0], "/") {
+		volume := d.blankVolume(parts[0])
+		if err := util.ObjectLoad(volume); err != nil {
+			return "", err
+		}
+		if _, exists := volume.Snapshots[parts[1]]; !exists {
+			return "", fmt.Errorf("Cannot find snapshot %v of volume %v", parts[1], parts[0])
+		}
+		origin = snapshotName(volume.Dataset, parts[1])
+	}
+	if !datasetExists(origin) {
+		return "", fmt.Errorf("ZFS snapshot %v doesn't exist", origin)
+	}
+	return origin, nil
+}
+
+func (d *Driver) CreateVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var (
+		size int64
+		err  error
+	)
+	id := req.Name
+	opts := req.Options
+
+	backupURL := opts[OPT_BACKUP_URL]
+	endpointURL := opts[OPT_ENDPOINT_URL]
+	origin := opts[OPT_VOLUME_DRIVER_ID]
+	if backupURL != "" && origin != "" {
+		return fmt.Errorf("Cannot restore from backup and clone from snapshot at the same time")
+	}
+	if backupURL != "" {
+		objVolume, err := objectstore.LoadVolume(backupURL, endpointURL)
+		if err != nil {
+			return err
+		}
+		if err := objectstore.CheckRestoreDriver(objVolume, backupURL, endpointURL, d.Name()); err != nil {
+			return err
+		}
+		size, err = d.getSize(opts, objVolume.Size)
+		if err != nil {
+			return err
+		}
+		if size < objVolume.Size {
+			return fmt.Errorf("Volume size cannot be less than backup's size %v", objVolume.Size)
+		}
+	} else if origin == "" {
+		size, err = d.getSize(opts, d.DefaultVolumeSize)
+		if err != nil {
+			return err
+		}
+		if size%objectstore.DEFAULT_BLOCK_SIZE != 0 {
+			return fmt.Errorf("Size must be multiple of %v", objectstore.DEFAULT_BLOCK_SIZE)
+		}
+	}
+
+	volume := d.blankVolume(id)
+	exists, err := util.ObjectExists(volume)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("Already has volume with specific uuid %v", id)
+	}
+
+	dataset := filepath.Join(d.Dataset, id)
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_START,
+		LOG_FIELD_EVENT:  LOG_EVENT_CREATE,
+		LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME: id,
+	}).Debugf("Creating ZFS volume %v", dataset)
+	if origin != "" {
+		if origin, err = d.getCloneOrigin(origin); err != nil {
+			return err
+		}
+		if _, err := zfs("clone", "-o", "snapdev=visible", origin, dataset); err != nil {
+			return err
+		}
+		out, err := zfs("get", "-H", "-p", "-o", "value", "volsize", dataset)
+		if err != nil {
+			return err
+		}
+		if size, err = strconv.ParseInt(strings.TrimSpace(out), 10, 64); err != nil {
+			return err
+		}
+	} else {
+		args := []string{"create", "-s", "-V", strconv.FormatInt(size, 10), "-o", "snapdev=visible"}
+		if d.VolBlockSize != "" {
+			args = append(args, "-o", "volblocksize="+d.VolBlockSize)
+		}
+		if _, err := zfs(append(args, dataset)...); err != nil {
+			return err
+		}
+	}
+
+	volume.Dataset = dataset
+	volume.Origin = origin
+	volume.Size = size
+	volume.CreatedTime = util.Now()
+	volume.Snapshots = make(map[string]Snapshot)
+	volume.Filesystem = d.Filesystem
+	if err := util.ObjectSave(volume); err != nil {
+		return err
+	}
+
+	dev := zvolPath(dataset)
+	if err := waitForDevice(dev); err != nil {
+		return err
+	}
+	if backupURL != "" {
+		return objectstore.RestoreDeltaBlockBackupToSize(backupURL, endpointURL, dev, size)
+	}
+	if origin == "" {
+		log.Debugf("Formatting device %s with %s filesystem", dev, d.Filesystem)
+		if _, err := util.Execute("mkfs", []string{"-t", d.Filesystem, dev}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) DeleteVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	if volume.MountPoint != "" {
+		return fmt.Errorf("Cannot delete volume %s, it hasn't been umounted", id)
+	}
+	for _, snapshot := range volume.Snapshots {
+		if snapshot.MountPoint != "" {
+			return fmt.Errorf("Cannot delete volume %s, its snapshot %v is mounted at %v", id, snapshot.Name, snapshot.MountPoint)
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON: LOG_REASON_START,
+		LOG_FIELD_EVENT:  LOG_EVENT_REMOVE,
+		LOG_FIELD_OBJECT: LOG_OBJECT_VOLUME,
+		LOG_FIELD_VOLUME: id,
+	}).Debugf("Deleting ZFS volume %v", volume.Dataset)
+	// The snapshots would be deleted along with the volume, unless they're
+	// still used by clones
+	if _, err := zfs("destroy", "-r", volume.Dataset); err != nil {
+		return err
+	}
+	return util.ObjectDelete(volume)
+}
+
+func (d *Driver) MountVolume(req Request) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+
+	mountPoint, err := util.VolumeMount(volume, req.Options[OPT_MOUNT_POINT], false)
+	if err != nil {
+		return "", err
+	}
+
+	if err := util.ObjectSave(volume); err != nil {
+		return "", err
+	}
+	return mountPoint, nil
+}
+
+func (d *Driver) UmountVolume(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+
+	if err := util.VolumeUmount(volume); err != nil {
+		return err
+	}
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) MountPoint(req Request) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(req.Name)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+	return volume.MountPoint, nil
+}
+
+func (d *Driver) GetVolumeInfo(id string) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.getVolumeInfo(id)
+}
+
+func (d *Driver) getVolumeInfo(id string) (map[string]string, error) {
+	volume := d.blankVolume(id)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"Dataset":               volume.Dataset,
+		"Origin":                volume.Origin,
+		"Device":                zvolPath(volume.Dataset),
+		OPT_VOLUME_NAME:         volume.Name,
+		OPT_VOLUME_CREATED_TIME: volume.CreatedTime,
+		OPT_MOUNT_POINT:         volume.MountPoint,
+		OPT_SIZE:                strconv.FormatInt(volume.Size, 10),
+		OPT_FILESYSTEM:          volume.Filesystem,
+	}, nil
+}
+
+func (d *Driver) ListVolume(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeIDs, err := d.listVolumeNames()
+	if err != nil {
+		return nil, err
+	}
+	volumes := make(map[string]map[string]string)
+	for _, id := range volumeIDs {
+		volumes[id], err = d.getVolumeInfo(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return volumes, nil
+}
+
+func (d *Driver) CreateSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	id := req.Name
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return err
+	}
+	if _, exists := volume.Snapshots[id]; exists {
+		return fmt.Errorf("Snapshot %v already exists for volume %v", id, volumeID)
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_CREATE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: id,
+		LOG_FIELD_VOLUME:   volumeID,
+	}).Debugf("Creating snapshot")
+	if _, err := zfs("snapshot", snapshotName(volume.Dataset, id)); err != nil {
+		return err
+	}
+
+	volume.Snapshots[id] = Snapshot{
+		Name:             id,
+		CreatedTime:      util.Now(),
+		SnapshotMetadata: NewSnapshotMetadata(req.Options),
+	}
+	return util.ObjectSave(volume)
+}
+
+func (d *Driver) DeleteSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+
+	snapshot, volume, err := d.getSnapshotAndVolume(req.Name, volumeID)
+	if err != nil {
+		return err
+	}
+	if snapshot.MountPoint != "" {
+		return fmt.Errorf("Cannot delete snapshot %v of volume %v, which is mounted at %v", req.Name, volumeID, snapshot.MountPoint)
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_REMOVE,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_SNAPSHOT,
+		LOG_FIELD_SNAPSHOT: req.Name,
+		LOG_FIELD_VOLUME:   volumeID,
+	}).Debugf("Deleting snapshot for volume")
+	// It would fail if the snapshot has been cloned
+	if _, err := zfs("destroy", snapshotName(volume.Dataset, req.Name)); err != nil {
+		return err
+	}
+	delete(volume.Snapshots, req.Name)
+	return util.ObjectSave(volume)
+}
+
+// RevertSnapshot rolls the dataset of the volume back to the snapshot. ZFS
+// can only roll back to the latest snapshot, unless the newer ones are
+// destroyed, which is left to the user.
+func (d *Driver) RevertSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+	_, volume, err := d.getSnapshotAndVolume(req.Name, volumeID)
+	if err != nil {
+		return err
+	}
+	if volume.MountPoint != "" {
+		return fmt.Errorf("Cannot revert volume %v, which is mounted at %v", volumeID, volume.MountPoint)
+	}
+
+	log.WithFields(logrus.Fields{
+		LOG_FIELD_REASON:   LOG_REASON_START,
+		LOG_FIELD_EVENT:    LOG_EVENT_REVERT,
+		LOG_FIELD_OBJECT:   LOG_OBJECT_VOLUME,
+		LOG_FIELD_SNAPSHOT: req.Name,
+		LOG_FIELD_VOLUME:   volumeID,
+	}).Debugf("Reverting volume to snapshot")
+	if _, err := zfs("rollback", snapshotName(volume.Dataset, req.Name)); err != nil {
+		return fmt.Errorf("Cannot revert volume %v to snapshot %v, only the latest snapshot can be reverted to, "+
+			"delete the newer snapshots first: %v", volumeID, req.Name, err)
+	}
+	return nil
+}
+
+func (d *Driver) snapshotMount(snapshot *Snapshot, volume *Volume) *util.SnapshotMount {
+	return &util.SnapshotMount{
+		Name:       snapshot.Name,
+		MountPoint: snapshot.MountPoint,
+		Device:     zvolPath(snapshotName(volume.Dataset, snapshot.Name)),
+		Filesystem: volume.Filesystem,
+		MountsDir:  filepath.Join(d.Root, util.SNAPSHOT_MOUNTS_DIR, volume.Name),
+	}
+}
+
+// MountSnapshot mounts the device of the snapshot, which is visible since
+// the volumes are created with snapdev=visible, and read-only by ZFS
+func (d *Driver) MountSnapshot(req Request) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return "", err
+	}
+	snapshot, volume, err := d.getSnapshotAndVolume(req.Name, volumeID)
+	if err != nil {
+		return "", err
+	}
+	m := d.snapshotMount(snapshot, volume)
+	if err := waitForDevice(m.Device); err != nil {
+		return "", err
+	}
+	mountPoint, err := util.VolumeMount(m, req.Options[OPT_MOUNT_POINT], false)
+	if err != nil {
+		return "", err
+	}
+	snapshot.MountPoint = mountPoint
+	volume.Snapshots[req.Name] = *snapshot
+	if err := util.ObjectSave(volume); err != nil {
+		return "", err
+	}
+	return mountPoint, nil
+}
+
+func (d *Driver) UmountSnapshot(req Request) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+	snapshot, volume, err := d.getSnapshotAndVolume(req.Name, volumeID)
+	if err != nil {
+		return err
+	}
+	if snapshot.MountPoint == "" {
+		return nil
+	}
+	if err := util.SnapshotUmount(d.snapshotMount(snapshot, volume)); err != nil {
+		return err
+	}
+	snapshot.MountPoint = ""
+	volume.Snapshots[req.Name] = *snapshot
+	return util.ObjectSave(volume)
+}
+
+// CreateVolumeFromSnapshot clones the snapshot as the new volume, the same
+// as creating the volume with the snapshot as opts[OPT_VOLUME_DRIVER_ID]. The
+// snapshot cannot be deleted while the clone exists.
+func (d *Driver) CreateVolumeFromSnapshot(req Request) error {
+	snapshotID, err := util.GetFieldFromOpts(OPT_SNAPSHOT_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+	volumeID, err := util.GetFieldFromOpts(OPT_SNAPSHOT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return err
+	}
+	return d.CreateVolume(Request{
+		Name: req.Name,
+		Options: map[string]string{
+			OPT_VOLUME_DRIVER_ID: snapshotName(volumeID, snapshotID),
+		},
+	})
+}
+
+func (d *Driver) getSnapshotAndVolume(snapshotID, volumeID string) (*Snapshot, *Volume, error) {
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, nil, err
+	}
+	snapshot, exists := volume.Snapshots[snapshotID]
+	if !exists {
+		return nil, nil, fmt.Errorf("Cannot find snapshot %v of volume %v", snapshotID, volumeID)
+	}
+	return &snapshot, volume, nil
+}
+
+func (d *Driver) GetSnapshotInfo(req Request) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volumeID, err := util.GetFieldFromOpts(OPT_VOLUME_NAME, req.Options)
+	if err != nil {
+		return nil, err
+	}
+	return d.getSnapshotInfo(req.Name, volumeID)
+}
+
+func (d *Driver) getSnapshotInfo(id, volumeID string) (map[string]string, error) {
+	snapshot, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		OPT_SNAPSHOT_NAME:         snapshot.Name,
+		OPT_SNAPSHOT_LABELS:       snapshot.Labels,
+		OPT_SNAPSHOT_DESCRIPTION:  snapshot.Description,
+		OPT_SNAPSHOT_CREATED_TIME: snapshot.CreatedTime,
+		OPT_SIZE:                  strconv.FormatInt(volume.Size, 10),
+		"VolumeUUID":              volumeID,
+		"Dataset":                 snapshotName(volume.Dataset, id),
+		OPT_MOUNT_POINT:           snapshot.MountPoint,
+	}, nil
+}
+
+func (d *Driver) ListSnapshot(opts map[string]string) (map[string]map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	var (
+		volumeIDs []string
+		err       error
+	)
+	snapshots := make(map[string]map[string]string)
+	specifiedVolumeID, _ := util.GetFieldFromOpts(OPT_VOLUME_NAME, opts)
+	if specifiedVolumeID != "" {
+		volumeIDs = []string{
+			specifiedVolumeID,
+		}
+	} else {
+		volumeIDs, err = d.listVolumeNames()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, volumeID := range volumeIDs {
+		volume := d.blankVolume(volumeID)
+		if err := util.ObjectLoad(volume); err != nil {
+			return nil, err
+		}
+		for snapshotID := range volume.Snapshots {
+			snapshots[snapshotID], err = d.getSnapshotInfo(snapshotID, volumeID)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return snapshots, nil
+}
+
+// GetSnapshotUniqueSizes returns the used space of each snapshot reported by
+// ZFS, which is the space held only by the snapshot
+func (d *Driver) GetSnapshotUniqueSizes(volumeID string) (map[string]int64, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+	out, err := zfs("list", "-H", "-p", "-o", "name,used", "-t", "snapshot", "-d", "1", volume.Dataset)
+	if err != nil {
+		return nil, err
+	}
+	used := map[string]int64{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid used space %v of ZFS snapshot %v", fields[1], fields[0])
+		}
+		used[fields[0]] = size
+	}
+
+	sizes := map[string]int64{}
+	for snapshotID := range volume.Snapshots {
+		size, exists := used[snapshotName(volume.Dataset, snapshotID)]
+		if !exists {
+			return nil, fmt.Errorf("Cannot find ZFS snapshot %v", snapshotName(volume.Dataset, snapshotID))
+		}
+		sizes[snapshotID] = size
+	}
+	return sizes, nil
+}
+
+func (d *Driver) BackupOps() (BackupOperations, error) {
+	return d, nil
+}
+
+func (d *Driver) HasSnapshot(id, volumeID string) bool {
+	_, _, err := d.getSnapshotAndVolume(id, volumeID)
+	return err == nil
+}
+
+/*
+CompareSnapshot parses the stream of "zfs send" for the changed blocks, so
+only the changed blocks need to be read from the snapshot. A full stream
+would be used if compareID is empty, which contains the allocated blocks.
+
+The stream carries the data of the changed blocks as well, which is read by
+ZFS and discarded by the parser, so the comparison costs about as much IO as
+the backup itself. It's sent compressed, so the blocks are read as they're
+stored on disk, without being decompressed.
+*/
+func (d *Driver) CompareSnapshot(id, compareID, volumeID string) (*metadata.Mappings, error) {
+	_, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"send", "-c"}
+	if compareID != "" && compareID != id {
+		if _, _, err := d.getSnapshotAndVolume(compareID, volumeID); err != nil {
+			return nil, err
+		}
+		args = append(args, "-i", snapshotName(volume.Dataset, compareID))
+	}
+	args = append(args, snapshotName(volume.Dataset, id))
+
+	cmd := exec.Command(ZFS_BINARY, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	mapping, err := metadata.ZFSSendStreamParser(stdout, objectstore.DEFAULT_BLOCK_SIZE, volume.Size)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("Failed to parse the stream of zfs %v: %v", strings.Join(args, " "), err)
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("Failed to execute zfs %v: %v", strings.Join(args, " "), err)
+	}
+	return mapping, nil
+}
+
+func (d *Driver) OpenSnapshot(id, volumeID string) error {
+	_, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return err
+	}
+	return waitForDevice(zvolPath(snapshotName(volume.Dataset, id)))
+}
+
+func (d *Driver) ReadSnapshot(id, volumeID string, offset int64, data []byte) error {
+	_, volume, err := d.getSnapshotAndVolume(id, volumeID)
+	if err != nil {
+		return err
+	}
+
+	devFile, err := os.Open(zvolPath(snapshotName(volume.Dataset, id)))
+	if err != nil {
+		return err
+	}
+	defer devFile.Close()
+
+	_, err = devFile.ReadAt(data, offset)
+	return err
+}
+
+func (d *Driver) CloseSnapshot(id, volumeID string) error {
+	return nil
+}
+
+func (d *Driver) CreateBackup(snapshotID, volumeID, destURL, endpointURL string, opts map[string]string) (string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return "", err
+	}
+
+	objVolume := &objectstore.Volume{
+		Name:        volumeID,
+		Driver:      d.Name(),
+		Size:        volume.Size,
+		CreatedTime: opts[OPT_VOLUME_CREATED_TIME],
+	}
+	objSnapshot := &objectstore.Snapshot{
+		Name:        snapshotID,
+		CreatedTime: opts[OPT_SNAPSHOT_CREATED_TIME],
+		ExportImage: opts[OPT_EXPORT_IMAGE],
+		Labels:      objectstore.DecodeLabels(opts[OPT_BACKUP_LABELS]),
+	}
+	return objectstore.CreateDeltaBlockBackup(objVolume, objSnapshot, destURL, endpointURL, d)
+}
+
+func (d *Driver) EstimateBackup(snapshotID, volumeID, destURL, endpointURL string, opts map[string]string) (map[string]string, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	volume := d.blankVolume(volumeID)
+	if err := util.ObjectLoad(volume); err != nil {
+		return nil, err
+	}
+
+	objVolume := &objectstore.Volume{
+		Name:   volumeID,
+		Driver: d.Name(),
+		Size:   volume.Size,
+	}
+	objSnapshot := &objectstore.Snapshot{
+		Name: snapshotID,
+	}
+	return objectstore.EstimateDeltaBlockBackup(objVolume, objSnapshot, destURL, endpointURL, d)
+}
+
+func (d *Driver) DeleteBackup(backupURL, endpointURL string, opts map[string]string) error {
+	objVolume, err := objectstore.LoadVolume(backupURL, endpointURL)
+	if err != nil {
+		return err
+	}
+	if objVolume.Driver != d.Name() {
+		return fmt.Errorf("BUG: Wrong driver handling DeleteBackup(), driver should be %v but is %v", objVolume.Driver, d.Name())
+	}
+	force, _ := strconv.ParseBool(opts[OPT_FORCE])
+	return objectstore.DeleteDeltaBlockBackup(backupURL, endpointURL, force)
+}
+
+func (d *Driver) GetBackupInfo(backupURL, endpointURL string) (map[string]string, error) {
+	objVolume, err := objectstore.LoadVolume(backupURL, endpointURL)
+	if err != nil {
+		return nil, err
+	}
+	if objVolume.Driver != d.Name() {
+		return nil, fmt.Errorf("BUG: Wrong driver handling GetBackupInfo(), driver should be %v but is %v", objVolume.Driver, d.Name())
+	}
+	return objectstore.GetBackupInfo(backupURL, endpointURL)
+}
+
+func (d *Driver) ListBackup(destURL, endpointURL string, opts map[string]string) (map[string]map[string]string, error) {
+	return objectstore.List(opts[OPT_VOLUME_NAME], destURL, endpointURL, d.Name())
+}